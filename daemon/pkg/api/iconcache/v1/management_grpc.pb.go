@@ -0,0 +1,310 @@
+// management.proto
+// gRPC management API of the icon cache watchdog (grpc.enabled). It offers
+// what the control pipe offers integrators — status, history, the effective
+// configuration, repairs and a stream of events — to any language with a
+// gRPC toolchain. The server listens on the loopback address only; every
+// call carries the token the daemon draws at startup, as
+// "authorization: Bearer <token>" metadata. The daemon writes the address
+// and the token to grpc.json in its data directory (client.go).
+//
+// The package is the version: fields and calls are only ever added within
+// iconcache.v1, and an incompatible change becomes iconcache.v2, served
+// alongside v1 for a while.
+//
+// Regenerate the Go code after a change, from daemon/pkg/api:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//     iconcache/v1/management.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: iconcache/v1/management.proto
+
+package iconcachev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Management_GetStatus_FullMethodName       = "/iconcache.v1.Management/GetStatus"
+	Management_GetHistory_FullMethodName      = "/iconcache.v1.Management/GetHistory"
+	Management_GetConfig_FullMethodName       = "/iconcache.v1.Management/GetConfig"
+	Management_Repair_FullMethodName          = "/iconcache.v1.Management/Repair"
+	Management_SubscribeEvents_FullMethodName = "/iconcache.v1.Management/SubscribeEvents"
+)
+
+// ManagementClient is the client API for Management service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ManagementClient interface {
+	// GetStatus answers with the status as of now (status.json).
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error)
+	// GetHistory answers with the last 24 hours of cache size and events.
+	GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*History, error)
+	// GetConfig answers with every setting, its value and where it came from.
+	GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*Config, error)
+	// Repair requests a repair like the remote commands do: the cooldown,
+	// quiet hours and other holds apply. Recorded in the command trail.
+	Repair(ctx context.Context, in *RepairRequest, opts ...grpc.CallOption) (*RepairResult, error)
+	// SubscribeEvents streams repair progress and/or log lines until the
+	// client cancels.
+	SubscribeEvents(ctx context.Context, in *SubscribeEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+}
+
+type managementClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewManagementClient(cc grpc.ClientConnInterface) ManagementClient {
+	return &managementClient{cc}
+}
+
+func (c *managementClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Status)
+	err := c.cc.Invoke(ctx, Management_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementClient) GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*History, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(History)
+	err := c.cc.Invoke(ctx, Management_GetHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementClient) GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*Config, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Config)
+	err := c.cc.Invoke(ctx, Management_GetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementClient) Repair(ctx context.Context, in *RepairRequest, opts ...grpc.CallOption) (*RepairResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RepairResult)
+	err := c.cc.Invoke(ctx, Management_Repair_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementClient) SubscribeEvents(ctx context.Context, in *SubscribeEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Management_ServiceDesc.Streams[0], Management_SubscribeEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeEventsRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Management_SubscribeEventsClient = grpc.ServerStreamingClient[Event]
+
+// ManagementServer is the server API for Management service.
+// All implementations must embed UnimplementedManagementServer
+// for forward compatibility.
+type ManagementServer interface {
+	// GetStatus answers with the status as of now (status.json).
+	GetStatus(context.Context, *GetStatusRequest) (*Status, error)
+	// GetHistory answers with the last 24 hours of cache size and events.
+	GetHistory(context.Context, *GetHistoryRequest) (*History, error)
+	// GetConfig answers with every setting, its value and where it came from.
+	GetConfig(context.Context, *GetConfigRequest) (*Config, error)
+	// Repair requests a repair like the remote commands do: the cooldown,
+	// quiet hours and other holds apply. Recorded in the command trail.
+	Repair(context.Context, *RepairRequest) (*RepairResult, error)
+	// SubscribeEvents streams repair progress and/or log lines until the
+	// client cancels.
+	SubscribeEvents(*SubscribeEventsRequest, grpc.ServerStreamingServer[Event]) error
+	mustEmbedUnimplementedManagementServer()
+}
+
+// UnimplementedManagementServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedManagementServer struct{}
+
+func (UnimplementedManagementServer) GetStatus(context.Context, *GetStatusRequest) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedManagementServer) GetHistory(context.Context, *GetHistoryRequest) (*History, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
+}
+func (UnimplementedManagementServer) GetConfig(context.Context, *GetConfigRequest) (*Config, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedManagementServer) Repair(context.Context, *RepairRequest) (*RepairResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Repair not implemented")
+}
+func (UnimplementedManagementServer) SubscribeEvents(*SubscribeEventsRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeEvents not implemented")
+}
+func (UnimplementedManagementServer) mustEmbedUnimplementedManagementServer() {}
+func (UnimplementedManagementServer) testEmbeddedByValue()                    {}
+
+// UnsafeManagementServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ManagementServer will
+// result in compilation errors.
+type UnsafeManagementServer interface {
+	mustEmbedUnimplementedManagementServer()
+}
+
+func RegisterManagementServer(s grpc.ServiceRegistrar, srv ManagementServer) {
+	// If the following call pancis, it indicates UnimplementedManagementServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Management_ServiceDesc, srv)
+}
+
+func _Management_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Management_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Management_GetHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServer).GetHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Management_GetHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServer).GetHistory(ctx, req.(*GetHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Management_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Management_GetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServer).GetConfig(ctx, req.(*GetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Management_Repair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepairRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServer).Repair(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Management_Repair_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServer).Repair(ctx, req.(*RepairRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Management_SubscribeEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ManagementServer).SubscribeEvents(m, &grpc.GenericServerStream[SubscribeEventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Management_SubscribeEventsServer = grpc.ServerStreamingServer[Event]
+
+// Management_ServiceDesc is the grpc.ServiceDesc for Management service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Management_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "iconcache.v1.Management",
+	HandlerType: (*ManagementServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStatus",
+			Handler:    _Management_GetStatus_Handler,
+		},
+		{
+			MethodName: "GetHistory",
+			Handler:    _Management_GetHistory_Handler,
+		},
+		{
+			MethodName: "GetConfig",
+			Handler:    _Management_GetConfig_Handler,
+		},
+		{
+			MethodName: "Repair",
+			Handler:    _Management_Repair_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeEvents",
+			Handler:       _Management_SubscribeEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "iconcache/v1/management.proto",
+}
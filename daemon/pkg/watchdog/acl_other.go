@@ -0,0 +1,16 @@
+//go:build !windows
+
+// acl_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "os"
+
+func worldWritable(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return info.Mode().Perm()&0002 != 0, nil
+}
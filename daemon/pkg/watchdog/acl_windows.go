@@ -0,0 +1,93 @@
+// acl_windows.go
+// DACL inspection: does a broad group (Everyone, Users, Authenticated
+// Users, Interactive) hold write access to a file or directory?
+
+package watchdog
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	seFileObject             = 1
+	daclSecurityInformation  = 0x4
+	accessAllowedAceType     = 0x0
+	inheritOnlyAce           = 0x8
+	fileWriteData            = 0x2
+	fileAppendData           = 0x4
+	deleteAccess             = 0x10000
+	writeDAC                 = 0x40000
+	writeOwner               = 0x80000
+	genericAll               = 0x10000000
+	genericWrite             = 0x40000000
+	broadWriteMask           = fileWriteData | fileAppendData | deleteAccess | writeDAC | writeOwner | genericAll | genericWrite
+	errorSuccessSecurityInfo = 0
+	sidEveryone              = "S-1-1-0"
+	sidUsers                 = "S-1-5-32-545"
+	sidAuthenticatedUsers    = "S-1-5-11"
+	sidInteractive           = "S-1-5-4"
+)
+
+var (
+	modAdvapi32               = syscall.NewLazyDLL("advapi32.dll")
+	procGetNamedSecurityInfoW = modAdvapi32.NewProc("GetNamedSecurityInfoW")
+	procGetAce                = modAdvapi32.NewProc("GetAce")
+	procLocalFree             = modKernel32.NewProc("LocalFree")
+)
+
+type aclHeader struct {
+	AclRevision byte
+	Sbz1        byte
+	AclSize     uint16
+	AceCount    uint16
+	Sbz2        uint16
+}
+
+type aceHeader struct {
+	AceType  byte
+	AceFlags byte
+	AceSize  uint16
+	Mask     uint32
+	// SID follows
+}
+
+// worldWritable reports whether a broad, non-administrative group may
+// modify path. A NULL DACL grants everyone full access.
+func worldWritable(path string) (bool, error) {
+	p, err := syscall.UTF16PtrFromString(extendedPath(path))
+	if err != nil {
+		return false, err
+	}
+	var dacl *aclHeader
+	var sd uintptr
+	r, _, _ := procGetNamedSecurityInfoW.Call(
+		uintptr(unsafe.Pointer(p)), seFileObject, daclSecurityInformation,
+		0, 0, uintptr(unsafe.Pointer(&dacl)), 0, uintptr(unsafe.Pointer(&sd)))
+	if r != errorSuccessSecurityInfo {
+		return false, fmt.Errorf("GetNamedSecurityInfo %s: %w", path, syscall.Errno(r))
+	}
+	defer procLocalFree.Call(sd)
+	if dacl == nil {
+		return true, nil
+	}
+	for i := 0; i < int(dacl.AceCount); i++ {
+		var ace *aceHeader
+		if ok, _, _ := procGetAce.Call(uintptr(unsafe.Pointer(dacl)), uintptr(i), uintptr(unsafe.Pointer(&ace))); ok == 0 || ace == nil {
+			continue
+		}
+		if ace.AceType != accessAllowedAceType || ace.AceFlags&inheritOnlyAce != 0 || ace.Mask&broadWriteMask == 0 {
+			continue
+		}
+		sid, err := (*syscall.SID)(unsafe.Add(unsafe.Pointer(ace), 8)).String()
+		if err != nil {
+			continue
+		}
+		switch sid {
+		case sidEveryone, sidUsers, sidAuthenticatedUsers, sidInteractive:
+			return true, nil
+		}
+	}
+	return false, nil
+}
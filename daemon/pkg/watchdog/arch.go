@@ -0,0 +1,40 @@
+// arch.go
+// Processor architecture, for fleet inventory. The daemon is built for
+// windows/amd64 and windows/arm64 (Build-Daemon.ps1 -Arch). On ARM64 devices
+// (Surface Pro X, Snapdragon laptops) the amd64 build also runs, under
+// emulation, at a cost in battery and start-up time, so the native machine
+// is reported next to the build: in status, the heartbeat and at startup,
+// with a warning when the build does not match.
+
+package watchdog
+
+import (
+	"runtime"
+	"sync"
+)
+
+type archInfo struct {
+	Binary   string `json:"binary"`             // GOARCH of this build
+	Machine  string `json:"machine"`            // native architecture of the processor
+	Emulated bool   `json:"emulated,omitempty"` // Binary runs under emulation
+}
+
+func (a archInfo) String() string {
+	if a.Emulated {
+		return a.Binary + " build on " + a.Machine + " (emulated)"
+	}
+	return a.Binary
+}
+
+// hostArch is detected once per process.
+var hostArch = sync.OnceValue(detectArch)
+
+// detectArch compares this build with the native machine.
+func detectArch() archInfo {
+	a := archInfo{Binary: runtime.GOARCH, Machine: nativeMachine()}
+	if a.Machine == "" {
+		a.Machine = a.Binary
+	}
+	a.Emulated = a.Machine != a.Binary
+	return a
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+// arch_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+// nativeMachine is unknown here; the build's architecture is assumed.
+func nativeMachine() string { return "" }
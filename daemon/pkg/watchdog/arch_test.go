@@ -0,0 +1,20 @@
+// arch_test.go
+// Processor architecture as reported in status and the heartbeat.
+
+package watchdog
+
+import "testing"
+
+func TestArchString(t *testing.T) {
+	for _, c := range []struct {
+		a    archInfo
+		want string
+	}{
+		{archInfo{Binary: "arm64", Machine: "arm64"}, "arm64"},
+		{archInfo{Binary: "amd64", Machine: "arm64", Emulated: true}, "amd64 build on arm64 (emulated)"},
+	} {
+		if got := c.a.String(); got != c.want {
+			t.Errorf("%+v = %q, want %q", c.a, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// arch_windows.go
+// Native machine from IsWow64Process2 (Windows 10 1709 and later). Its
+// nativeMachine is the processor's, also for an amd64 process emulated on
+// ARM64, which is not WOW64 and reports IMAGE_FILE_MACHINE_UNKNOWN as its
+// process machine. Older LTSC releases only have IsWow64Process, which is
+// enough to tell a 386 build on 64-bit Windows.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procIsWow64Process  = modKernel32.NewProc("IsWow64Process")
+	procIsWow64Process2 = modKernel32.NewProc("IsWow64Process2")
+)
+
+// IMAGE_FILE_MACHINE_* (winnt.h) by GOARCH.
+var imageMachines = map[uint16]string{
+	0x014c: "386",
+	0x01c4: "arm",
+	0x8664: "amd64",
+	0xaa64: "arm64",
+}
+
+func nativeMachine() string {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return ""
+	}
+	if procIsWow64Process2.Find() != nil {
+		var wow64 int32
+		if r, _, _ := procIsWow64Process.Call(uintptr(h), uintptr(unsafe.Pointer(&wow64))); r != 0 && wow64 != 0 {
+			return "amd64"
+		}
+		return ""
+	}
+	var process, native uint16
+	if r, _, _ := procIsWow64Process2.Call(uintptr(h), uintptr(unsafe.Pointer(&process)), uintptr(unsafe.Pointer(&native))); r == 0 {
+		return ""
+	}
+	return imageMachines[native]
+}
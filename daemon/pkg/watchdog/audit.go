@@ -0,0 +1,138 @@
+// audit.go
+// Audit log of control commands. The control pipe and the dashboard API
+// can start repairs, restores and pauses, and hand out the dashboard's
+// sign-in link, so each such request is written to ControlAudit.log in the
+// log directory with the channel, the client and the outcome:
+//
+//   [2026-03-02 14:05:11][AUDIT] control pipe PC01\jdoe (pid 4120) repair: Repair started.
+//   [2026-03-02 14:06:40][DENIED] dashboard unknown user (127.0.0.1:52114) GET /api/status: unauthorized
+//
+// On Windows the pipe client is the account the daemon impersonates after
+// reading the request; elsewhere only the socket's owner can connect. A
+// dashboard client is named by its certificate with ui.clientCA, and is
+// otherwise the holder of the token; failed TLS handshakes are refusals.
+// control.audit "changes" (default) records repair, rollback, pause, resume
+// and dashboard requests and every refusal; "all" adds the read-only ones
+// (status, logs, history, ...); "off" records nothing. The file is kept
+// apart from the other logs so it is not lost among poll lines and can be
+// collected on its own.
+//
+// Whatever control.audit says, the commands that change what the daemon
+// does — a repair, rollback, pause or resume from the pipe or the dashboard,
+// and the remote commands (remote.go), whose sender is unknown — also form
+// a command trail: a COMMAND line in Watchdog.log and a "command" event in
+// the history with the client under "by".
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const auditLogName = "ControlAudit.log"
+
+const (
+	auditChanges = "changes"
+	auditAll     = "all"
+	auditOff     = "off"
+)
+
+// auditMu keeps lines from concurrent clients whole.
+var auditMu sync.Mutex
+
+// controlPeer is the client of a control request.
+type controlPeer struct {
+	User string // DOMAIN\user, or the certificate's subject on the dashboard
+	PID  int    // pipe client process; 0 when unknown
+	Addr string // dashboard client address
+}
+
+func (p controlPeer) String() string {
+	s := p.User
+	if s == "" {
+		s = "unknown user"
+	}
+	switch {
+	case p.PID != 0:
+		s += fmt.Sprintf(" (pid %d)", p.PID)
+	case p.Addr != "":
+		s += " (" + p.Addr + ")"
+	}
+	return s
+}
+
+// changingCommand reports whether cmd changes what the daemon does; those
+// form the command trail.
+func changingCommand(cmd string) bool {
+	switch cmd {
+	case requestRepair, requestRollback, requestPause, requestResume, dashboardRepair, grpcRepair:
+		return true
+	}
+	return false
+}
+
+// auditedCommand reports whether a request that was not refused is
+// recorded under control.audit "changes".
+func auditedCommand(cmd string) bool {
+	return changingCommand(cmd) || cmd == requestDashboard
+}
+
+// audit appends one line to the audit log, and adds commands that were
+// carried out to the command trail. refused requests are recorded in every
+// mode but "off".
+func (d *daemon) audit(channel string, peer controlPeer, cmd, outcome string, refused bool) {
+	if !refused && changingCommand(cmd) {
+		d.recordCommand(channel, peer, cmd, outcome)
+	}
+	mode := d.cfg.Control.Audit
+	if mode == auditOff || d.sim != nil || !refused && mode != auditAll && !auditedCommand(cmd) {
+		return
+	}
+	level := "AUDIT"
+	if refused {
+		level = "DENIED"
+	}
+	if cmd == "" {
+		cmd = "(no command)"
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	os.MkdirAll(d.logDir, 0755)
+	f, err := os.OpenFile(filepath.Join(d.logDir, auditLogName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "[%s][%s] %s %s %s: %s\n", d.clock.Now().Format("2006-01-02 15:04:05"), level, channel, peer, cmd, outcome)
+}
+
+// auditControl records a control-pipe request by the first event sent back.
+func (d *daemon) auditControl(peer controlPeer, cmd string, ev controlEvent) {
+	outcome := "ok"
+	switch {
+	case ev.Type == eventError:
+		d.audit("control pipe", peer, cmd, ev.Error, true)
+		return
+	case ev.Repair != nil:
+		outcome = ev.Repair.Result
+	case ev.Pause != nil:
+		outcome = ev.Pause.String()
+	case ev.Type == eventPause:
+		outcome = "resumed"
+	case ev.Phase != "":
+		outcome = "restore " + ev.Phase
+	case ev.Type == eventDashboard:
+		outcome = "sign-in link sent"
+	}
+	d.audit("control pipe", peer, cmd, outcome, false)
+}
+
+// recordCommand adds a command to the trail: Watchdog.log and the history.
+func (d *daemon) recordCommand(via string, peer controlPeer, cmd, outcome string) {
+	msg := fmt.Sprintf("%s via %s by %s: %s", cmd, via, peer, outcome)
+	d.watchLog_("COMMAND", msg)
+	d.addHistoryEvent(historyEvent{Kind: historyCommand, Message: msg, Incident: d.incidents.id(), By: peer.String()})
+}
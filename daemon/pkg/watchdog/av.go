@@ -0,0 +1,192 @@
+// av.go
+// Antivirus interference with repairs (antivirus.enabled). A real-time
+// scanner that holds iconcache_*.db open while Explorer rebuilds it leaves a
+// half-written cache that fails the next health check, and the daemon
+// repairs again: a repair loop that only an AV exclusion ends. While a
+// repair runs and for avRebuildWindow after it, the daemon asks the Restart
+// Manager which processes have the cache files open (av_windows.go) and
+// matches them against known scanner engines. Each repair's sightings are
+// kept in state.json; when one scanner held the cache during avMinRepairs of
+// the last avRecentRepairs repairs, an advisory recommending an exclusion is
+// logged, written to status.json and shown by `status`.
+
+package watchdog
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	avSampleEvery   = 250 * time.Millisecond
+	avRebuildWindow = 60 * time.Second // Explorer rebuilds the cache after the restart
+	avRecentRepairs = 5
+	avMinRepairs    = 2
+)
+
+// avEngines maps scanner processes (image or service short name, lower
+// case) to the product named in the advisory.
+var avEngines = map[string]string{
+	"msmpeng.exe":             "Microsoft Defender Antivirus",
+	"windefend":               "Microsoft Defender Antivirus",
+	"mssense.exe":             "Microsoft Defender for Endpoint",
+	"csfalconservice.exe":     "CrowdStrike Falcon",
+	"sentinelagent.exe":       "SentinelOne",
+	"ccsvchst.exe":            "Symantec Endpoint Protection",
+	"mcshield.exe":            "McAfee / Trellix",
+	"avp.exe":                 "Kaspersky",
+	"vsserv.exe":              "Bitdefender",
+	"bdservicehost.exe":       "Bitdefender",
+	"ekrn.exe":                "ESET",
+	"savservice.exe":          "Sophos",
+	"sophosfilescanner.exe":   "Sophos",
+	"repmgr.exe":              "Carbon Black",
+	"avastsvc.exe":            "Avast",
+	"avgsvc.exe":              "AVG",
+	"ntrtscan.exe":            "Trend Micro",
+	"wrsa.exe":                "Webroot",
+	"cylancesvc.exe":          "Cylance",
+	"paloaltonetworks-tp.exe": "Palo Alto Cortex XDR",
+}
+
+type antivirusOptions struct {
+	Enabled bool `json:"enabled"`
+}
+
+// avSighting is one watched repair: the scanners seen holding the cache.
+type avSighting struct {
+	At       time.Time `json:"at"`
+	Products []string  `json:"products,omitempty"`
+}
+
+type avAdvisory struct {
+	Product   string `json:"product"`
+	Repairs   int    `json:"repairs"` // watched repairs in which it held the cache
+	Of        int    `json:"of"`
+	Exclusion string `json:"exclusion"`         // recommended exclusion path
+	Command   string `json:"command,omitempty"` // for Microsoft Defender
+}
+
+func (a avAdvisory) String() string {
+	return fmt.Sprintf("%s held the icon cache open during %d of the last %d repairs; exclude %s from real-time scanning", a.Product, a.Repairs, a.Of, a.Exclusion)
+}
+
+// watchAV samples the cache file holders until finish is called and
+// avRebuildWindow has passed, then records the repair's sighting.
+func (d *daemon) watchAV() (finish func()) {
+	if !d.cfg.Antivirus.Enabled || d.sim != nil || runtime.GOOS != "windows" {
+		return func() {}
+	}
+	started := d.clock.Now()
+	finished := make(chan struct{})
+	go func() {
+		seen := map[string]int{} // product -> samples
+		tick := d.clock.NewTicker(avSampleEvery)
+		defer tick.Stop()
+		remaining := -1 // ticks left once the repair has finished
+		for remaining != 0 {
+			d.sampleAV(seen)
+			select {
+			case <-tick.C():
+				if remaining > 0 {
+					remaining--
+				}
+			case <-finished:
+				finished = nil
+				remaining = int(avRebuildWindow / avSampleEvery)
+			case <-d.done:
+				return
+			}
+		}
+		d.recordAV(started, seen)
+	}()
+	return func() { close(finished) }
+}
+
+func (d *daemon) sampleAV(seen map[string]int) {
+	var files []string
+	for _, name := range cacheFileNames(d.cacheDir, d.cfg.CacheFiles.Icon, d.cfg.CacheFiles.Thumbnail) {
+		files = append(files, filepath.Join(d.cacheDir, name))
+	}
+	if len(files) == 0 {
+		return
+	}
+	holders, err := cacheFileHolders(files)
+	if err != nil {
+		return
+	}
+	products := map[string]bool{}
+	for _, h := range holders {
+		if p, ok := avEngines[strings.ToLower(h)]; ok {
+			products[p] = true
+		}
+	}
+	for p := range products {
+		seen[p]++
+	}
+}
+
+// recordAV keeps the sighting and logs a new or changed advisory.
+func (d *daemon) recordAV(at time.Time, seen map[string]int) {
+	s := avSighting{At: at}
+	for p, n := range seen {
+		s.Products = append(s.Products, p)
+		d.repairLog_("WARN", fmt.Sprintf("Antivirus: %s held the cache files open in %d sample(s) during the repair and rebuild.", p, n))
+	}
+	sort.Strings(s.Products)
+
+	d.mu.Lock()
+	d.avSightings = append(d.avSightings, s)
+	if len(d.avSightings) > avRecentRepairs {
+		d.avSightings = d.avSightings[len(d.avSightings)-avRecentRepairs:]
+	}
+	prev := d.avAdvisory
+	d.avAdvisory = avAdvice(d.avSightings, d.cacheDir, d.cfg.CacheFiles.Icon)
+	adv := d.avAdvisory
+	d.saveState()
+	d.mu.Unlock()
+
+	if adv != nil && (prev == nil || prev.Product != adv.Product) {
+		d.healthLog_("WARN", fmt.Sprintf("ANTIVIRUS ADVISORY: %s.", adv))
+		if adv.Command != "" {
+			d.healthLog_("INFO", fmt.Sprintf("Exclusion for %s (elevated PowerShell): %s", adv.Product, adv.Command))
+		}
+	}
+}
+
+// avAdvice returns the advisory for the scanner that held the cache most
+// often, if it did in at least avMinRepairs of the sightings.
+func avAdvice(sightings []avSighting, cacheDir string, iconPatterns []string) *avAdvisory {
+	counts := map[string]int{}
+	for _, s := range sightings {
+		for _, p := range s.Products {
+			counts[p]++
+		}
+	}
+	var best string
+	for p, n := range counts {
+		if n > counts[best] || n == counts[best] && p < best {
+			best = p
+		}
+	}
+	if counts[best] < avMinRepairs {
+		return nil
+	}
+	// The exclusion names the first glob; a regular expression cannot be one.
+	glob := "*.db"
+	for _, p := range iconPatterns {
+		if !strings.HasPrefix(p, cacheRegexpPrefix) {
+			glob = p
+			break
+		}
+	}
+	a := &avAdvisory{Product: best, Repairs: counts[best], Of: len(sightings), Exclusion: filepath.Join(cacheDir, glob)}
+	if best == avEngines["msmpeng.exe"] {
+		a.Command = fmt.Sprintf("Add-MpPreference -ExclusionPath '%s'", a.Exclusion)
+	}
+	return a
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+// av_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "errors"
+
+func cacheFileHolders(files []string) ([]string, error) {
+	return nil, errors.New("file holder lookup is only available on Windows")
+}
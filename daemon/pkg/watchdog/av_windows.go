@@ -0,0 +1,107 @@
+// av_windows.go
+// Cache file holders via the Restart Manager, which lists the processes
+// (and services) with a file open without needing SeDebugPrivilege.
+
+package watchdog
+
+import (
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	rmSessionKeyLen   = 32  // CCH_RM_SESSION_KEY
+	rmMaxAppName      = 255 // CCH_RM_MAX_APP_NAME
+	rmMaxSvcName      = 63  // CCH_RM_MAX_SVC_NAME
+	errorMoreData     = 234 // ERROR_MORE_DATA
+	rmGetListAttempts = 3
+)
+
+var (
+	modRstrtmgr                    = syscall.NewLazyDLL("rstrtmgr.dll")
+	procRmStartSession             = modRstrtmgr.NewProc("RmStartSession")
+	procRmRegisterResources        = modRstrtmgr.NewProc("RmRegisterResources")
+	procRmGetList                  = modRstrtmgr.NewProc("RmGetList")
+	procRmEndSession               = modRstrtmgr.NewProc("RmEndSession")
+	procQueryFullProcessImageNameW = modKernel32.NewProc("QueryFullProcessImageNameW")
+)
+
+// rmProcessInfo is RM_PROCESS_INFO.
+type rmProcessInfo struct {
+	PID              uint32
+	StartTime        syscall.Filetime
+	AppName          [rmMaxAppName + 1]uint16
+	ServiceShortName [rmMaxSvcName + 1]uint16
+	ApplicationType  uint32
+	AppStatus        uint32
+	TSSessionID      uint32
+	Restartable      int32
+}
+
+// cacheFileHolders returns the image names (or, when the process cannot be
+// opened, the service short names) of the processes holding files open.
+func cacheFileHolders(files []string) ([]string, error) {
+	var session uint32
+	key := make([]uint16, rmSessionKeyLen+1)
+	if r, _, _ := procRmStartSession.Call(uintptr(unsafe.Pointer(&session)), 0, uintptr(unsafe.Pointer(&key[0]))); r != 0 {
+		return nil, syscall.Errno(r)
+	}
+	defer procRmEndSession.Call(uintptr(session))
+
+	names := make([]*uint16, 0, len(files))
+	for _, f := range files {
+		p, err := syscall.UTF16PtrFromString(f)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, p)
+	}
+	if r, _, _ := procRmRegisterResources.Call(uintptr(session), uintptr(len(names)), uintptr(unsafe.Pointer(&names[0])), 0, 0, 0, 0); r != 0 {
+		return nil, syscall.Errno(r)
+	}
+
+	var infos []rmProcessInfo
+	for attempt := 0; ; attempt++ {
+		var needed, reasons uint32
+		n := uint32(len(infos))
+		var ptr uintptr
+		if n > 0 {
+			ptr = uintptr(unsafe.Pointer(&infos[0]))
+		}
+		r, _, _ := procRmGetList.Call(uintptr(session), uintptr(unsafe.Pointer(&needed)), uintptr(unsafe.Pointer(&n)), ptr, uintptr(unsafe.Pointer(&reasons)))
+		if r == 0 {
+			infos = infos[:n]
+			break
+		}
+		if r != errorMoreData || attempt == rmGetListAttempts {
+			return nil, syscall.Errno(r)
+		}
+		infos = make([]rmProcessInfo, needed)
+	}
+
+	holders := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if name := processImageName(info.PID); name != "" {
+			holders = append(holders, name)
+		} else if svc := syscall.UTF16ToString(info.ServiceShortName[:]); svc != "" {
+			holders = append(holders, svc)
+		}
+	}
+	return holders, nil
+}
+
+// processImageName returns the executable file name of pid, or "".
+func processImageName(pid uint32) string {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, pid)
+	if err != nil {
+		return ""
+	}
+	defer syscall.CloseHandle(h)
+	buf := make([]uint16, syscall.MAX_LONG_PATH)
+	n := uint32(len(buf))
+	if r, _, _ := procQueryFullProcessImageNameW.Call(uintptr(h), 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&n))); r == 0 {
+		return ""
+	}
+	return filepath.Base(syscall.UTF16ToString(buf[:n]))
+}
@@ -0,0 +1,49 @@
+// backup.go
+// Pre-repair backups of the cache files (backup.enabled). Repair-IconCache.ps1
+// copies the iconcache_*.db files to backups\<user>\<yyyyMMdd-HHmmss>\ in
+// the data directory after stopping Explorer and before deleting them, with
+// a manifest.json, and keeps the newest backup.keep of them.
+//
+// Files still held open by another process fail to copy, or could be copied
+// half written. With backup.shadowCopy the script reads every file from a
+// Volume Shadow Copy snapshot instead, which needs elevation: it works for
+// the elevated broker route and elevated daemons, and otherwise falls back
+// to direct copies with locked files skipped and logged.
+
+package watchdog
+
+import (
+	"path/filepath"
+	"strconv"
+)
+
+const backupDirName = "backups"
+
+type backupOptions struct {
+	Enabled    bool `json:"enabled"`
+	Keep       int  `json:"keep"`
+	ShadowCopy bool `json:"shadowCopy"`
+}
+
+// backupDir is where this daemon's cache is backed up: one folder per user,
+// so multi-session hosts keep each user's backups apart.
+func (d *daemon) backupDir() string {
+	_, user := reportIdentity()
+	if d.session != nil {
+		user = d.session.User
+	}
+	return filepath.Join(d.dataDir, backupDirName, user)
+}
+
+// backupParams are the repair script parameters for backup.*, or nil.
+func (d *daemon) backupParams() []string {
+	b := d.cfg.Backup
+	if !b.Enabled {
+		return nil
+	}
+	params := []string{"-BackupDir", d.backupDir(), "-BackupKeep", strconv.Itoa(b.Keep)}
+	if b.ShadowCopy {
+		params = append(params, "-ShadowCopy")
+	}
+	return params
+}
@@ -0,0 +1,127 @@
+// breaker.go
+// Circuit breaker (breaker.enabled). A watchdog that loops — a trigger that
+// fires on every event, a repair that fails every time it runs — floods the
+// logs and keeps killing Explorer, and so destabilises the machine it is
+// meant to keep healthy. The breaker trips when, within one hour, more than
+// breaker.maxTriggers repairs are requested (whether they start or are
+// skipped), or when breaker.maxFailures repairs in a row fail. From then on
+// the daemon is alert-only: polls, health checks and their alerts go on,
+// but nothing is repaired, as during a pause, and skipped triggers are
+// folded into one line an hour. Only `resume` (or the tray's Resume) closes
+// the breaker again; it survives restarts in state.json. Single-user mode
+// only.
+
+package watchdog
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	breakerWindow     = time.Hour // triggers are counted over this
+	breakerNoteEvery  = time.Hour // one "still open" line per this
+	maxBreakerSamples = 1000
+)
+
+type breakerOptions struct {
+	Enabled     bool `json:"enabled"`
+	MaxTriggers int  `json:"maxTriggers"` // repair requests within an hour
+	MaxFailures int  `json:"maxFailures"` // failed repairs in a row
+}
+
+func defaultBreakerOptions() breakerOptions {
+	return breakerOptions{Enabled: true, MaxTriggers: 100, MaxFailures: 3}
+}
+
+// breakerState is a tripped breaker, as kept in state.json.
+type breakerState struct {
+	Tripped    time.Time `json:"tripped"`
+	Reason     string    `json:"reason"`
+	Suppressed int       `json:"suppressed,omitempty"` // triggers skipped since
+}
+
+func (b breakerState) String() string {
+	return fmt.Sprintf("stopped by the circuit breaker since %s (%s)", b.Tripped.Local().Format("2006-01-02 15:04"), b.Reason)
+}
+
+func (d *daemon) breakerActive() bool {
+	return d.cfg.Breaker.Enabled && d.session == nil && !d.cfg.MultiSession.Enabled && d.sim == nil
+}
+
+// breakerTrigger counts a repair request and reports whether the breaker
+// is open, so the request must be dropped. Requests dropped while it is
+// open are folded into one line per breakerNoteEvery; their count is saved
+// with that line, not per request. Caller holds d.mu.
+func (d *daemon) breakerTrigger(reason string) bool {
+	if !d.breakerActive() {
+		return false
+	}
+	now := d.clock.Now()
+	if b := d.breaker; b != nil {
+		b.Suppressed++
+		d.metrics.inc(mRepairsSkipped)
+		if now.Sub(d.breakerNoted) >= breakerNoteEvery {
+			d.breakerNoted = now
+			d.saveState()
+			d.logMsg(msgBreakerOpen, b, b.Suppressed, reason)
+		}
+		return true
+	}
+	d.triggers = append(d.triggers, now)
+	for len(d.triggers) > 0 && now.Sub(d.triggers[0]) > breakerWindow || len(d.triggers) > maxBreakerSamples {
+		d.triggers = d.triggers[1:]
+	}
+	if n := len(d.triggers); n > d.cfg.Breaker.MaxTriggers {
+		d.tripBreaker(fmt.Sprintf("%d repair triggers within %s", n, duration(breakerWindow)))
+		return true
+	}
+	return false
+}
+
+// breakerRepairDone records the outcome of a repair; failures in a row
+// trip the breaker. Caller holds d.mu.
+func (d *daemon) breakerRepairDone(ok bool) {
+	if !d.breakerActive() || d.breaker != nil {
+		return
+	}
+	if ok {
+		if d.failures > 0 {
+			d.failures = 0
+			d.saveState()
+		}
+		return
+	}
+	d.failures++
+	if d.failures >= d.cfg.Breaker.MaxFailures {
+		d.tripBreaker(fmt.Sprintf("%d repairs failed in a row", d.failures))
+		return
+	}
+	d.saveState()
+}
+
+// tripBreaker opens the breaker. Caller holds d.mu.
+func (d *daemon) tripBreaker(why string) {
+	now := d.clock.Now()
+	d.breaker = &breakerState{Tripped: now, Reason: why}
+	d.breakerNoted = now
+	d.triggers, d.failures = nil, 0
+	d.saveState()
+	d.metrics.inc(mBreakerTrips)
+	d.logMsg(msgBreakerTripped, why)
+	d.historyEvent(historyBreaker, "tripped: "+why)
+	d.incidents.step(stepSkipped, "circuit breaker tripped: "+why)
+}
+
+// resetBreaker closes a tripped breaker, for `resume`. Caller holds d.mu.
+func (d *daemon) resetBreaker(by string) bool {
+	b := d.breaker
+	if b == nil {
+		return false
+	}
+	d.breaker = nil
+	d.saveState()
+	d.logMsg(msgBreakerReset, by, b.Suppressed)
+	d.historyEvent(historyBreaker, "reset via "+by)
+	return true
+}
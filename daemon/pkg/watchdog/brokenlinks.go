@@ -0,0 +1,92 @@
+// brokenlinks.go
+// Broken shortcut scan (shortcutScan.enabled). A shortcut whose target was
+// uninstalled or moved shows a blank or generic icon, which users readily
+// blame on the icon cache, and no repair can fix it. With every health
+// check the daemon reads the shortcuts on the desktops, in the Start menus
+// and on the taskbar (shortcuts.go) and checks that each target exists.
+// Dead shortcuts are logged and reported apart from the heuristics: like
+// H5 they take no part in the health score and never trigger a repair.
+//
+// Targets on network shares are only checked when the share answers (see
+// network.go); shortcuts on an unreachable share are counted as offline, not
+// broken. Shortcuts without a file target (Control Panel items, advertised
+// installer shortcuts, Store apps) are skipped.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const brokenLogMax = 10 // shortcuts named in the health log
+
+type shortcutScanOptions struct {
+	Enabled bool `json:"enabled"`
+}
+
+type brokenShortcut struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+}
+
+type shortcutStatus struct {
+	Scanned int              `json:"scanned"`
+	Offline int              `json:"offline,omitempty"` // targets on unreachable shares
+	Broken  []brokenShortcut `json:"broken,omitempty"`
+}
+
+func (s *shortcutStatus) String() string {
+	msg := fmt.Sprintf("%d of %d broken", len(s.Broken), s.Scanned)
+	if s.Offline > 0 {
+		msg += fmt.Sprintf(", %d on unreachable shares", s.Offline)
+	}
+	return msg
+}
+
+// scanBrokenShortcuts returns nil when the scan is disabled.
+func (d *daemon) scanBrokenShortcuts() *shortcutStatus {
+	if !d.cfg.ShortcutScan.Enabled {
+		return nil
+	}
+	links := scanShortcuts(shortcutDirs())
+	st := &shortcutStatus{Scanned: len(links)}
+	reachable := map[string]bool{}
+	for _, s := range links {
+		target := expandPercentVars(s.Target)
+		if target == "" {
+			continue
+		}
+		if root := networkRoot(target); root != "" {
+			up, ok := reachable[root]
+			if !ok {
+				up = probeRoot(root, d.cfg.Network.ProbeTimeout.D())
+				reachable[root] = up
+			}
+			if !up {
+				st.Offline++
+				continue
+			}
+		}
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			st.Broken = append(st.Broken, brokenShortcut{Path: s.Path, Target: target})
+		}
+	}
+
+	if len(st.Broken) == 0 {
+		d.healthLog_("PASS", fmt.Sprintf("Shortcut scan: %s.", st))
+		return st
+	}
+	var names []string
+	for _, b := range st.Broken[:min(len(st.Broken), brokenLogMax)] {
+		names = append(names, fmt.Sprintf("%s -> %s", filepath.Base(b.Path), b.Target))
+	}
+	if more := len(st.Broken) - brokenLogMax; more > 0 {
+		names = append(names, fmt.Sprintf("%d more", more))
+	}
+	d.healthLog_("WARN", fmt.Sprintf("Shortcut scan: %s. Their targets are gone, so they show generic icons; a cache repair cannot fix them: %s",
+		st, strings.Join(names, "; ")))
+	return st
+}
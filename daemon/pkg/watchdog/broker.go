@@ -0,0 +1,99 @@
+// broker.go
+// Elevation broker. Register-Tasks.ps1 pre-registers \IconCache\ElevatedRepair
+// (RunLevel HighestAvailable, no triggers) while the installer is elevated.
+// An unelevated daemon that cannot delete the cache files writes the trigger
+// reason to a handoff file and starts that task on demand — no UAC prompt at
+// repair time. The handoff directory is ACL'd by the installer to SYSTEM,
+// Administrators and the installing user; the repair script only consumes
+// the reason text from it and rejects stale requests.
+
+package watchdog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+const elevatedRepairTask = `\IconCache\ElevatedRepair`
+
+type handoff struct {
+	Reason     string    `json:"reason"`
+	Requested  time.Time `json:"requested"`
+	Nonce      string    `json:"nonce"`
+	PID        int       `json:"pid"`
+	DeepClean  bool      `json:"deepClean,omitempty"`         // deepclean.go
+	Thumbcache bool      `json:"includeThumbcache,omitempty"` // targets.go
+
+	// backup.go; the script derives the directory from its -DataDir.
+	Backup     bool `json:"backup,omitempty"`
+	BackupKeep int  `json:"backupKeep,omitempty"`
+	ShadowCopy bool `json:"shadowCopy,omitempty"`
+
+	// explorerstop.go; empty while explorerStop is the default.
+	StopMethod   string `json:"stopMethod,omitempty"`
+	StopTimeouts string `json:"stopTimeouts,omitempty"`
+}
+
+func (d *daemon) handoffFile() string {
+	return filepath.Join(d.dataDir, "broker", "handoff.json")
+}
+
+// requestElevatedRepair writes the handoff file and starts the elevated task.
+func (d *daemon) requestElevatedRepair(reason string, deepClean, thumbcache bool) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+	var stopMethod, stopTimeouts string
+	if p := d.explorerStopParams(); p != nil {
+		stopMethod, stopTimeouts = p[1], p[3]
+	}
+	raw, err := json.Marshal(handoff{
+		Reason:     reason,
+		Requested:  d.clock.Now().UTC(),
+		Nonce:      hex.EncodeToString(nonce),
+		PID:        os.Getpid(),
+		DeepClean:  deepClean,
+		Thumbcache: thumbcache,
+		Backup:     d.cfg.Backup.Enabled,
+		BackupKeep: d.cfg.Backup.Keep,
+		ShadowCopy: d.cfg.Backup.ShadowCopy,
+
+		StopMethod:   stopMethod,
+		StopTimeouts: stopTimeouts,
+	})
+	if err != nil {
+		return err
+	}
+	path := d.handoffFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create handoff dir: %w", err)
+	}
+	// A leftover handoff from an unconsumed request is replaced, never reused.
+	os.Remove(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("write handoff: %w", err)
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return fmt.Errorf("write handoff: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write handoff: %w", err)
+	}
+	return runScheduledTask(elevatedRepairTask)
+}
+
+// scheduledTaskExists reports whether a task is registered under name.
+func scheduledTaskExists(name string) bool {
+	cmd := exec.Command("schtasks.exe", "/Query", "/TN", name)
+	cmd.SysProcAttr = sysProcAttr()
+	return cmd.Run() == nil
+}
@@ -0,0 +1,64 @@
+// cachedir.go
+// The cache directory going away mid-run: a roaming or container profile
+// unloaded, a redirected drive removed, the profile deleted. Listing a
+// missing directory returns nothing, which Layer B would read as an empty
+// cache under the limit and the heuristics as passes (H2, H4) or as a broken
+// index (H1) that no repair can fix.
+//
+// So every poll and health check first looks for the directory. When it is
+// gone outside a repair (which may delete and recreate it), watching stops:
+// one WARN (W030, event 131) names it, the polls and health checks are
+// skipped, repairs are skipped with W007, and status shows since when. Each
+// poll looks again; the return is logged once (W031, event 132) and watching
+// resumes with a fresh listing.
+
+package watchdog
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// cacheDirPresent reports whether the cache directory exists, logging when
+// it goes away and comes back. A directory missing during a repair counts
+// as present.
+func (d *daemon) cacheDirPresent() bool {
+	if d.sim != nil {
+		return true
+	}
+	_, err := os.Stat(d.cacheDir)
+	missing := errors.Is(err, fs.ErrNotExist)
+	now := d.clock.Now()
+	d.mu.Lock()
+	since := d.cacheGone
+	switch {
+	case missing && since.IsZero() && d.inFlight == nil:
+		d.cacheGone = now
+	case !missing && !since.IsZero():
+		d.cacheGone = time.Time{}
+		d.lastFiles = nil
+	}
+	gone := d.cacheGone
+	d.mu.Unlock()
+
+	switch {
+	case gone.IsZero() && !since.IsZero():
+		d.logMsg(msgCacheDirBack, d.cacheDir, duration(now.Sub(since).Truncate(time.Second)))
+		d.historyEvent(historyCacheDir, "cache directory back")
+	case !gone.IsZero() && since.IsZero():
+		d.logMsg(msgCacheDirGone, d.cacheDir)
+		d.historyEvent(historyCacheDir, "cache directory missing")
+	}
+	return gone.IsZero()
+}
+
+// cacheGoneReason says why repairs are skipped for a missing cache
+// directory, or "". Caller holds d.mu.
+func (d *daemon) cacheGoneReason() string {
+	if d.cacheGone.IsZero() {
+		return ""
+	}
+	return "missing since " + formatTime(d.cacheGone)
+}
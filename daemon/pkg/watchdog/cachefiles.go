@@ -0,0 +1,123 @@
+// cachefiles.go
+// Which files in the Explorer folder are the cache. Windows builds have
+// added tiers over time (iconcache_wide.db, iconcache_exif.db,
+// iconcache_custom_stream.db, thumbcache_sr.db) and may add more, so the
+// names are patterns in the config rather than prefixes in the code:
+//
+//   "cacheFiles": {"icon": ["iconcache_*.db"], "thumbnail": ["thumbcache_*.db"]}
+//
+// A pattern is a glob (path.Match, case-insensitive) or, with a "re:"
+// prefix, a regular expression that must match the whole name, also
+// case-insensitively. The icon patterns are the cache: Layer B sizes and
+// polls them, H3 counts them, the USN journal watches them and repairs
+// delete them. The thumbnail patterns add the files that the ledger (H6),
+// the antivirus sampler, deep cleans and rollbacks cover. Repair-IconCache.ps1
+// gets both as -IconPatterns and -ThumbPatterns ('|'-separated) when they
+// differ from the defaults, which it shares.
+
+package watchdog
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const cacheRegexpPrefix = "re:"
+
+type cacheFileOptions struct {
+	Icon      []string `json:"icon"`      // icon cache file patterns
+	Thumbnail []string `json:"thumbnail"` // thumbnail cache file patterns
+}
+
+func defaultCacheFileOptions() cacheFileOptions {
+	return cacheFileOptions{
+		Icon:      []string{"iconcache_*.db"},
+		Thumbnail: []string{"thumbcache_*.db"},
+	}
+}
+
+// cacheRegexps holds the compiled "re:" patterns by pattern.
+var cacheRegexps sync.Map
+
+// compileCachePattern checks pattern and compiles it when it is a regular
+// expression.
+func compileCachePattern(pattern string) (*regexp.Regexp, error) {
+	expr, ok := strings.CutPrefix(pattern, cacheRegexpPrefix)
+	if !ok {
+		_, err := path.Match(strings.ToLower(pattern), "")
+		return nil, err
+	}
+	if re, ok := cacheRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(`(?i)^(?:` + expr + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	cacheRegexps.Store(pattern, re)
+	return re, nil
+}
+
+// matchCachePattern reports whether name matches one of patterns. Invalid
+// patterns match nothing; the schema check rejects them.
+func matchCachePattern(patterns []string, name string) bool {
+	for _, p := range patterns {
+		re, err := compileCachePattern(p)
+		switch {
+		case err != nil:
+		case re != nil:
+			if re.MatchString(name) {
+				return true
+			}
+		default:
+			if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isIconCacheFile reports whether name is an icon cache file.
+func (d *daemon) isIconCacheFile(name string) bool {
+	return matchCachePattern(d.cfg.CacheFiles.Icon, name)
+}
+
+// cacheFileNames lists the files in dir matching any of the pattern lists,
+// by name and sorted.
+func cacheFileNames(dir string, lists ...[]string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if slices.ContainsFunc(lists, func(patterns []string) bool { return matchCachePattern(patterns, e.Name()) }) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cachePatternParams returns the repair script parameters for cacheFiles,
+// or nil while both lists are the defaults.
+func (d *daemon) cachePatternParams() []string {
+	def := defaultCacheFileOptions()
+	var params []string
+	if c := d.cfg.CacheFiles.Icon; !slices.Equal(c, def.Icon) {
+		params = append(params, "-IconPatterns", strings.Join(c, "|"))
+	}
+	if c := d.cfg.CacheFiles.Thumbnail; !slices.Equal(c, def.Thumbnail) {
+		params = append(params, "-ThumbPatterns", strings.Join(c, "|"))
+	}
+	return params
+}
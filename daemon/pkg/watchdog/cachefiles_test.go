@@ -0,0 +1,16 @@
+// cachefiles_test.go
+// Cache file patterns: globs and re: expressions.
+
+package watchdog
+
+import "testing"
+
+func TestMatchCachePattern(t *testing.T) {
+	patterns := []string{"iconcache_*.db", `re:shellicons_\d+\.db`}
+	// Globs and expressions ignore case; expressions match whole names.
+	for name, want := range map[string]bool{"IconCache_Custom_Stream.db": true, "SHELLICONS_16.DB": true, "shellicons_16.db.bak": false, "thumbcache_96.db": false} {
+		if got := matchCachePattern(patterns, name); got != want {
+			t.Errorf("match %s = %v, want %v", name, got, want)
+		}
+	}
+}
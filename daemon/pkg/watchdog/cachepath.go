@@ -0,0 +1,78 @@
+// cachepath.go
+// Relocated profiles. The cache path is derived from %LOCALAPPDATA% (or the
+// session's profile directory), but the files may live elsewhere: a
+// ProfilesDirectory moved to D:, a LOCALAPPDATA junctioned to a data drive,
+// a symbolic link left by a migration tool. Explorer writes through the
+// links, but the USN journal, the free space guard and the container check
+// must look at the volume that actually holds the files. At startup the
+// daemon follows every junction and link in the cache path, validates the
+// target and monitors it:
+//
+//   - a target that is not a directory, or a link whose target is gone, is
+//     logged as an error and the configured path is kept;
+//   - a target on a network drive is a warning: Explorer does not keep a
+//     reliable cache there;
+//   - a cache directory that does not exist yet is fine; Explorer creates it
+//     at logon.
+
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveCachePath replaces d.cacheDir with its final path and keeps the
+// configured one in d.cacheLink when the two differ.
+func (d *daemon) resolveCachePath() {
+	configured := d.cacheDir
+	if link := danglingLink(configured); link != "" {
+		d.watchLog_("ERROR", fmt.Sprintf("Cache path %s runs through %s, a junction or link whose target is missing. Explorer cannot write its cache there.", configured, link))
+		return
+	}
+	target, err := finalPath(configured)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		d.watchLog_("INFO", fmt.Sprintf("Cache dir %s does not exist yet; Explorer creates it at logon.", configured))
+		return
+	case err != nil:
+		d.watchLog_("WARN", fmt.Sprintf("Cannot resolve cache dir %s: %v. Monitoring it as configured.", configured, err))
+		return
+	case samePath(target, configured):
+		return
+	}
+	if info, err := os.Stat(target); err != nil || !info.IsDir() {
+		d.watchLog_("ERROR", fmt.Sprintf("Cache dir %s resolves to %s, which is not a directory. Monitoring it as configured.", configured, target))
+		return
+	}
+	d.cacheDir, d.cacheLink = target, configured
+	d.watchLog_("INFO", fmt.Sprintf("Cache dir %s resolves to %s (junction or symbolic link). Monitoring the target.", configured, target))
+	if onNetworkDrive(target) {
+		d.watchLog_("WARN", fmt.Sprintf("Cache dir %s is on a network drive. Explorer does not keep a reliable icon cache there; expect repeated repairs.", target))
+	}
+}
+
+// danglingLink returns the first element of path that exists as a link but
+// whose target does not, or "".
+func danglingLink(path string) string {
+	for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+		if _, err := os.Lstat(p); err == nil {
+			if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+				return p
+			}
+			return ""
+		}
+		if filepath.Dir(p) == p {
+			return ""
+		}
+	}
+}
+
+func onNetworkDrive(path string) bool {
+	vol := filepath.VolumeName(path)
+	return strings.HasPrefix(vol, `\\`) || (vol != "" && isRemoteDrive(vol+`\`))
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+// cachepath_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "path/filepath"
+
+func finalPath(path string) (string, error) { return filepath.EvalSymlinks(path) }
@@ -0,0 +1,36 @@
+// cachepath_windows.go
+// kernel32!GetFinalPathNameByHandleW, which follows junctions, mount points
+// and symbolic links alike.
+
+package watchdog
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const volumeNameDOS = 0x0 // GetFinalPathNameByHandle VOLUME_NAME_DOS
+
+func finalPath(path string) (string, error) {
+	p, err := syscall.UTF16PtrFromString(extendedPath(path))
+	if err != nil {
+		return "", err
+	}
+	h, err := syscall.CreateFile(p, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(h)
+	buf := make([]uint16, syscall.MAX_LONG_PATH)
+	n, _, callErr := procGetFinalPathNameByHandleW.Call(uintptr(h), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), volumeNameDOS)
+	if n == 0 || int(n) >= len(buf) {
+		return "", callErr
+	}
+	final := syscall.UTF16ToString(buf[:n])
+	// \\?\D:\rest or \\?\UNC\server\share\rest
+	if rest, ok := strings.CutPrefix(final, `\\?\UNC\`); ok {
+		return `\\` + rest, nil
+	}
+	return strings.TrimPrefix(final, `\\?\`), nil
+}
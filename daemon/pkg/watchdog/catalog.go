@@ -0,0 +1,234 @@
+// catalog.go
+// Message catalog. The lines an admin searches for — a skipped or deferred
+// repair, a failed heuristic, a launch, a rescue — come from numbered
+// templates, so the same code appears in the log files, the live log
+// (`logs`, the dashboard) and the Application log, and a knowledge-base
+// article can be keyed by it:
+//
+//   [2026-03-02 14:05:11][WARN] W001 Cooldown active (12m remaining). Skipping repair. ...
+//
+// The letter is the area: W for the watchdog (Layer B, skips and holds,
+// startup), H for health (Layers C and D), R for repairs, S for the shell and
+// C for the config file. `explain` prints the catalog or one entry. Codes are
+// part of the interface like the event IDs: a template's wording may change,
+// its meaning may not, and a retired code is never reused.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Message codes.
+const (
+	msgCooldownSkip    = "W001"
+	msgPausedSkip      = "W002"
+	msgLowDiskSkip     = "W003"
+	msgRepairDeferred  = "W004"
+	msgSuspendedSkip   = "W006"
+	msgCacheGoneSkip   = "W007"
+	msgSizeTrigger     = "W010"
+	msgSizeBackUnder   = "W011"
+	msgSizeStillOver   = "W012"
+	msgThumbTrigger    = "W013"
+	msgThumbBackUnder  = "W014"
+	msgDaemonStarted   = "W020"
+	msgCacheDirGone    = "W030"
+	msgCacheDirBack    = "W031"
+	msgBreakerTripped  = "W040"
+	msgBreakerOpen     = "W041"
+	msgBreakerReset    = "W042"
+	msgIndexMissing    = "H101"
+	msgIndexCorrupt    = "H102"
+	msgExplorerHung    = "H107"
+	msgHealthBelow     = "H110"
+	msgHealthRestored  = "H111"
+	msgDiskSpaceAlert  = "H130"
+	msgDiskRecovered   = "H131"
+	msgShellExtCulprit = "H150"
+	msgContainerIssue  = "H160"
+	msgRepairTriggered = "R200"
+	msgRepairLaunched  = "R201"
+	msgLaunchFailed    = "R202"
+	msgRepairRefused   = "R203"
+	msgRepairCompleted = "R204"
+	msgRepairFailed    = "R205"
+	msgRepairSlow      = "R206"
+	msgIneffective     = "R207"
+	msgSizeSuspended   = "R208"
+	msgSoftRepaired    = "R209"
+	msgSoftFailed      = "R210"
+	msgSoftRestored    = "R211"
+	msgSoftNoEffect    = "R212"
+	msgShellRescue     = "S301"
+	msgShellGaveUp     = "S302"
+	msgShellNoStart    = "S303"
+	msgConfigChanged   = "C401"
+	msgConfigRejected  = "C402"
+	msgConfigIgnored   = "C403"
+)
+
+// catalogEntry is one message template. Event is the Application log event
+// ID the message is also written as, or 0.
+type catalogEntry struct {
+	Code      string `json:"code"`
+	Subsystem string `json:"subsystem"`
+	Level     string `json:"level"`
+	Event     uint32 `json:"event,omitempty"`
+	Title     string `json:"title"`
+	Text      string `json:"text"`
+}
+
+var messageCatalog = map[string]catalogEntry{
+	msgCooldownSkip: {Subsystem: subsystemRepair, Level: "WARN", Title: "Repair skipped: cooldown",
+		Text: "Cooldown active (%s remaining). Skipping repair. Reason was: %s"},
+	msgPausedSkip: {Subsystem: subsystemRepair, Level: "WARN", Title: "Repair skipped: paused",
+		Text: "Watchdog %s. Skipping repair. Reason was: %s"},
+	msgLowDiskSkip: {Subsystem: subsystemRepair, Level: "WARN", Title: "Repair skipped: low disk space",
+		Text: "Low disk space: %s. Skipping repair. Reason was: %s"},
+	msgRepairDeferred: {Subsystem: subsystemRepair, Level: "WARN", Event: evtRepairDeferred, Title: "Repair deferred",
+		Text: "Repair deferred during %s: %s"},
+	msgSuspendedSkip: {Subsystem: subsystemRepair, Level: "WARN", Title: "Repair skipped: size repairs suspended",
+		Text: "Size repairs %s; the cache must drop below %.2f MB first. Skipping repair. Reason was: %s"},
+	msgCacheGoneSkip: {Subsystem: subsystemRepair, Level: "WARN", Title: "Repair skipped: cache directory missing",
+		Text: "Cache directory %s. Skipping repair. Reason was: %s"},
+	msgSizeTrigger: {Subsystem: subsystemWatchdog, Level: "TRIGGER", Event: evtSizeOverLimit, Title: "Cache over the size limit",
+		Text: "Cache is %.2f MB > %s threshold.%s"},
+	msgSizeBackUnder: {Subsystem: subsystemWatchdog, Level: "INFO", Title: "Cache back under the size limit",
+		Text: "Cache is back under the %s threshold (%.2f MB) after %s over it. Size trigger re-armed."},
+	msgSizeStillOver: {Subsystem: subsystemWatchdog, Level: "INFO", Title: "Cache still over the size limit",
+		Text: "Cache still exceeds the %s threshold: %.2f MB (peak %.2f MB), %s over it, %s since the last line. %s"},
+	msgDaemonStarted: {Subsystem: subsystemWatchdog, Level: "INFO", Event: evtDaemonStarted, Title: "Daemon started",
+		Text: "Daemon started. Monitoring %s."},
+	msgCacheDirGone: {Subsystem: subsystemWatchdog, Level: "WARN", Event: evtCacheDirGone, Title: "Cache directory missing",
+		Text: "Cache directory %s is gone (profile unloaded or drive removed?). Watching and health checks are paused until it returns."},
+	msgCacheDirBack: {Subsystem: subsystemWatchdog, Level: "INFO", Event: evtCacheDirBack, Title: "Cache directory back",
+		Text: "Cache directory %s is back after %s. Watching resumed."},
+	msgBreakerTripped: {Subsystem: subsystemWatchdog, Level: "ERROR", Event: evtBreakerTripped, Title: "Circuit breaker tripped",
+		Text: "Circuit breaker tripped: %s. Automatic repairs stop and the watchdog only alerts until `resume` is run."},
+	msgBreakerOpen: {Subsystem: subsystemRepair, Level: "WARN", Title: "Repairs skipped: circuit breaker open",
+		Text: "Watchdog %s; %d repair triggers skipped so far. Run `resume` once the cause is fixed. Latest reason: %s"},
+	msgBreakerReset: {Subsystem: subsystemWatchdog, Level: "INFO", Event: evtBreakerReset, Title: "Circuit breaker reset",
+		Text: "Circuit breaker reset via %s after skipping %d repair triggers; automatic repairs are active again."},
+	msgThumbTrigger: {Subsystem: subsystemWatchdog, Level: "TRIGGER", Title: "Thumbnail cache over its size limit",
+		Text: "Thumbnail cache is %.2f MB > %s threshold (targets.thumbnail)."},
+	msgThumbBackUnder: {Subsystem: subsystemWatchdog, Level: "INFO", Title: "Thumbnail cache back under its size limit",
+		Text: "Thumbnail cache is back under the %s threshold (%.2f MB) after %s over it."},
+	msgIndexMissing: {Subsystem: subsystemHealth, Level: "WARN", Title: "H1: index missing",
+		Text: "H1 FAIL: iconcache_idx.db is missing."},
+	msgIndexCorrupt: {Subsystem: subsystemHealth, Level: "WARN", Title: "H1: index corrupt",
+		Text: "H1 FAIL: iconcache_idx.db is %d bytes (expected >%d). Index corrupt."},
+	msgExplorerHung: {Subsystem: subsystemHealth, Level: "WARN", Title: "H7: Explorer not responding",
+		Text: "H7 FAIL: Explorer is not responding: %s did not answer within %s, twice."},
+	msgHealthBelow: {Subsystem: subsystemHealth, Level: "WARN", Event: evtHealthBelow, Title: "Health score below threshold",
+		Text: "Health score %d below %d (%s)."},
+	msgHealthRestored: {Subsystem: subsystemHealth, Level: "INFO", Event: evtHealthRestored, Title: "Health score restored",
+		Text: "Health score %d is back at or above %d."},
+	msgDiskSpaceAlert: {Subsystem: subsystemHealth, Level: "WARN", Event: evtDiskSpaceLow, Title: "Low disk space",
+		Text: "DISK SPACE ALERT: %s. Repairs are skipped until space is freed; a rebuild would thrash the disk."},
+	msgDiskRecovered: {Subsystem: subsystemHealth, Level: "INFO", Title: "Disk space recovered",
+		Text: "Disk space recovered: %s."},
+	msgShellExtCulprit: {Subsystem: subsystemHealth, Level: "WARN", Event: evtShellExtCulprit, Title: "Shell extension culprit",
+		Text: "Likely icon cache culprit: %s"},
+	msgContainerIssue: {Subsystem: subsystemHealth, Level: "WARN", Event: evtContainerIssue, Title: "Profile container problem",
+		Text: "Profile container: %s"},
+	msgRepairTriggered: {Subsystem: subsystemRepair, Level: "TRIGGER", Title: "Repair triggered",
+		Text: "Repair triggered: %s%s"},
+	msgRepairLaunched: {Subsystem: subsystemRepair, Level: "INFO", Event: evtRepairStarted, Title: "Repair launched",
+		Text: "Repair script launched. Reason was: %s"},
+	msgLaunchFailed: {Subsystem: subsystemRepair, Level: "ERROR", Event: evtRepairFailed, Title: "Repair could not be launched",
+		Text: "Failed to launch repair script: %v. Reason was: %s"},
+	msgRepairRefused: {Subsystem: subsystemRepair, Level: "TAMPER", Event: evtRepairRefused, Title: "Repair refused: script tampered",
+		Text: "Refusing to launch repair: %v"},
+	msgRepairCompleted: {Subsystem: subsystemRepair, Level: "INFO", Event: evtRepairCompleted, Title: "Repair completed",
+		Text: "Repair completed: %s"},
+	msgRepairFailed: {Subsystem: subsystemRepair, Level: "WARN", Event: evtRepairFailed, Title: "Repair script failed",
+		Text: "Repair script failed: %v. Reason was: %s"},
+	msgRepairSlow: {Subsystem: subsystemRepair, Level: "WARN", Event: evtRepairSlow, Title: "Slow repair",
+		Text: "SLOW REPAIR: took %s (thresholds.slowRepair %s); Explorer was down %s. Slow repairs usually mean disk or antivirus trouble. Reason was: %s"},
+	msgIneffective: {Subsystem: subsystemRepair, Level: "WARN", Event: evtRepairIneffective, Title: "Size repair ineffective",
+		Text: "%s ineffective: cache is %.2f MB (was %.2f MB), above the recovery threshold of %.2f MB (%d%% of %s); %s in a row."},
+	msgSizeSuspended: {Subsystem: subsystemRepair, Level: "ERROR", Title: "Size repairs suspended",
+		Text: "Size repairs suspended: repeating the repair does not shrink the cache. They resume once the cache drops below the recovery threshold."},
+	msgSoftRepaired: {Subsystem: subsystemRepair, Level: "INFO", Event: evtSoftRepair, Title: "Soft repair run",
+		Text: "Soft repair: %s %s finished in %.2fs. The next health check decides whether the full repair is needed. Reason was: %s"},
+	msgSoftFailed: {Subsystem: subsystemRepair, Level: "WARN", Title: "Soft repair failed",
+		Text: "Soft repair failed: %s %s: %v. Running the full repair."},
+	msgSoftRestored: {Subsystem: subsystemRepair, Level: "INFO", Title: "Soft repair restored health",
+		Text: "Soft repair restored health: score %d at or above %d. No full repair needed."},
+	msgSoftNoEffect: {Subsystem: subsystemRepair, Level: "WARN", Title: "Soft repair did not help",
+		Text: "Soft repair at %s did not restore health (score %d). Running the full repair."},
+	msgShellRescue: {Subsystem: subsystemRepair, Level: "WARN", Event: evtShellRescued, Title: "Shell rescued",
+		Text: "SHELL RESCUE: no explorer.exe for %s in an unlocked session. Started the shell (attempt %d of %d)."},
+	msgShellGaveUp: {Subsystem: subsystemRepair, Level: "ERROR", Title: "Shell rescue gave up",
+		Text: "Shell rescue: Explorer did not stay up after %d starts. Giving up until it runs again."},
+	msgShellNoStart: {Subsystem: subsystemRepair, Level: "ERROR", Title: "Shell rescue failed",
+		Text: "Shell rescue: cannot start explorer.exe: %v"},
+	msgConfigChanged: {Subsystem: subsystemHealth, Level: "TAMPER", Event: evtConfigTamper, Title: "Config file changed",
+		Text: "Config file %s changed since it was loaded (sha256 %s -> %s)."},
+	msgConfigRejected: {Subsystem: subsystemWatchdog, Level: "TAMPER", Title: "Config file rejected",
+		Text: "Config file rejected, using defaults: %v"},
+	msgConfigIgnored: {Subsystem: subsystemWatchdog, Level: "WARN", Title: "Config file ignored",
+		Text: "Config file ignored, using defaults: %v"},
+}
+
+// logMsg logs catalog message code, writes its event if it has one, and
+// returns the formatted text.
+func (d *daemon) logMsg(code string, args ...any) string {
+	e := messageCatalog[code]
+	msg := fmt.Sprintf(e.Text, args...)
+	d.logCoded(e.Subsystem, e.Level, code, msg)
+	if e.Event != 0 {
+		d.reportEvent(e.Event, code+" "+msg)
+	}
+	return msg
+}
+
+// catalogEntries returns the catalog in code order.
+func catalogEntries() []catalogEntry {
+	var out []catalogEntry
+	for code, e := range messageCatalog {
+		e.Code = code
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Code < out[j].Code })
+	return out
+}
+
+func cmdExplain(d *daemon, args []string) int {
+	fs := newFlagSet("explain")
+	asJSON := fs.Bool("json", false, "print the entries as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	entries := catalogEntries()
+	if code := strings.ToUpper(fs.Arg(0)); code != "" {
+		e, ok := messageCatalog[code]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown message code %s. Run `explain` for the list.\n", code)
+			return 1
+		}
+		e.Code = code
+		entries = []catalogEntry{e}
+	}
+	if *asJSON {
+		printJSON("explain", struct {
+			Messages []catalogEntry `json:"messages"`
+		}{entries})
+		return 0
+	}
+	for _, e := range entries {
+		event := ""
+		if e.Event != 0 {
+			event = fmt.Sprintf(", event %d", e.Event)
+		}
+		fmt.Printf("%s  %-40s [%s] %s%s\n", e.Code, e.Title, e.Level, e.Subsystem, event)
+		if len(entries) == 1 {
+			fmt.Printf("      %s\n", e.Text)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,423 @@
+// cli.go
+// Subcommand dispatch. With no arguments the binary runs as the daemon
+// (Layers B, C and D); with a subcommand it performs a one-off action
+// against the same configuration and data directory, then exits.
+//
+// Every command is described once in the commands table: its arguments,
+// examples, the values of its positional arguments and, for config, its
+// subcommands. `help`, -h/--help after any command and shell completion
+// (`completion`) are built from that table. Flags are not repeated in it:
+// a command that takes flags parses them with newFlagSet before anything
+// else, so the help runs it with -h and lists the flag set it defines.
+
+package watchdog
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const progName = "icon-cache-watchdog"
+
+type command struct {
+	name     string
+	summary  string
+	run      func(d *daemon, args []string) int
+	usage    string    // arguments after the name, e.g. "[-json] [file]"
+	flags    bool      // parses its arguments with newFlagSet
+	values   []string  // positional values offered by completion
+	examples []string  // arguments after the program name
+	sub      []command // subcommands (config init, ...)
+}
+
+// The tables are filled in init: the help they lead to reads them back.
+var commands, configCommands []command
+
+func init() {
+	configCommands = []command{
+		{name: "init", summary: "Write a commented config file with every setting", run: cmdConfigInit,
+			usage: "[-force] [-preset NAME] [file]", flags: true, examples: []string{"config init", "config init -preset laptop -force"}},
+		{name: "validate", summary: "Check the config file against the schema", run: cmdConfigValidate,
+			usage: "[-json] [file]", flags: true, examples: []string{"config validate", `config validate C:\staging\icon-cache-watchdog.json`}},
+		{name: "show-effective", summary: "Print every setting with the layer it came from", run: cmdConfigShowEffective,
+			usage: "[-json]", flags: true, examples: []string{"config show-effective", "--size-limit-mb=64 config show-effective"}},
+		{name: "presets", summary: "List the presets and the settings they change", run: cmdConfigPresets,
+			usage: "[-json]", flags: true, examples: []string{"config presets"}},
+		{name: "keygen", summary: "Create the key pair for signed configs", run: cmdConfigKeygen,
+			usage: "[-out file]", flags: true, examples: []string{`config keygen -out=D:\keys\icon-cache-watchdog.key`}},
+		{name: "sign", summary: "Sign a config file with the private key from keygen", run: cmdConfigSign,
+			usage: "-key file [file]", flags: true, examples: []string{`config sign -key=D:\keys\icon-cache-watchdog.key .\icon-cache-watchdog.json`}},
+	}
+	commands = []command{
+		{name: "status", summary: "Print daemon status and repair capabilities (-json)", run: cmdStatus,
+			usage: "[-json] [-host pc1,pc2,... [-token-file path] [-timeout 10s]]", flags: true,
+			examples: []string{"status", "status -json", "status -host pc1,pc2,pc3"}},
+		{name: "repair", summary: "Run one repair with cooldown, health check and logging (-reason, -force)", run: cmdRepair,
+			usage: "[-reason TEXT] [-force] [-deep-clean]", flags: true,
+			examples: []string{"repair", `repair -force -reason "icons white after update"`, "repair -deep-clean"}},
+		{name: "refresh", summary: "Reload the shell's icons with ie4uinit.exe (soft repair)", run: cmdRefresh,
+			flags: true, examples: []string{"refresh"}},
+		{name: "trigger", summary: "Hand a trigger to the running daemon (-source, -reason, -priority, -evidence)", run: cmdTrigger,
+			usage: "[-source NAME] [-reason TEXT] [-priority check|normal|critical] [-evidence key=value]...", flags: true,
+			examples: []string{"trigger -source event -priority normal", "trigger -source mdm -reason ticket -evidence ticket=INC0012"}},
+		{name: "healthcheck", summary: "Run one health check, print the result and exit", run: cmdHealthCheck,
+			usage: "[-json] [-repair]", flags: true, examples: []string{"healthcheck", "healthcheck -json", "--once"}},
+		{name: "compliance", summary: "Intune remediation contract: one line, exit 0/1 (detect, remediate)", run: cmdCompliance,
+			usage: "detect|remediate", values: []string{"detect", "remediate"},
+			examples: []string{"compliance detect", "compliance remediate"}},
+		{name: "rollback", summary: "Restore the newest pre-repair cache backup (-list, -backup)", run: cmdRollback,
+			usage: "[-list [-json]] [-backup NAME]", flags: true,
+			examples: []string{"rollback -list", "rollback", "rollback -backup 20260302-140511"}},
+		{name: "history", summary: "Show the last 24h of cache size and events", run: cmdHistory,
+			usage: "[-n COUNT] [-json]", flags: true, examples: []string{"history", "history -n 50"}},
+		{name: "summary", summary: "Print the summary of the last 7 days from the daily reports", run: cmdSummary,
+			usage: "[-json]", flags: true, examples: []string{"summary"}},
+		{name: "dashboard", summary: "Print the web dashboard link of the running daemon (-open)", run: cmdDashboard,
+			usage: "[-open] [-json]", flags: true, examples: []string{"dashboard -open"}},
+		{name: "logs", summary: "Print the running daemon's latest log lines (-n, -f to follow)", run: cmdLogs,
+			usage: "[-n COUNT] [-f] [-json]", flags: true, examples: []string{"logs", "logs -n 100 -f"}},
+		{name: "progress", summary: "Follow repair progress live from the running daemon", run: cmdProgress,
+			usage: "[-f] [-json] [-addr PIPE]", flags: true, examples: []string{"progress", "progress -f -json"}},
+		{name: "config", summary: "Config file tools (init, validate, show-effective, presets, keygen, sign)", run: cmdConfig,
+			usage: "<subcommand>", sub: configCommands},
+		{name: "pause", summary: "Pause repairs for a while, resuming on its own (-for 2h, -reason)", run: cmdPause,
+			usage: "[-for DURATION] [-reason TEXT]", flags: true,
+			examples: []string{"pause", `pause -for 2h -reason "disk imaging"`}},
+		{name: "resume", summary: "End a pause now", run: cmdResume, examples: []string{"resume"}},
+		{name: "opt-out", summary: "Exclude this account from monitoring and repairs (-undo to opt back in)", run: cmdOptOut,
+			usage: "[-undo]", flags: true, examples: []string{"opt-out", "opt-out -undo"}},
+		{name: "start-shell", summary: "Start Explorer in a session with the user's token (-session)", run: cmdStartShell,
+			usage: "[-session ID] [-host PATH]", flags: true, examples: []string{"start-shell -session 2"}},
+		{name: "signal", summary: "Ask the running daemon for a repair or health check (remote commands)", run: cmdSignal,
+			usage: remoteRepair + "|" + remoteHealthCheck, values: []string{remoteRepair, remoteHealthCheck},
+			examples: []string{"signal " + remoteHealthCheck}},
+		{name: "install", summary: "Register the scheduled tasks silently for package managers (/S)", run: cmdInstall,
+			usage: `[/S] [-user=DOMAIN\name] [-profile=laptop|desktop|vdi]`, values: []string{"/S"},
+			examples: []string{"install /S", "install /S -profile=vdi"}},
+		{name: "uninstall", summary: "Remove the scheduled tasks and WMI class silently (/S)", run: cmdUninstall,
+			usage: "[/S]", values: []string{"/S"}, examples: []string{"uninstall /S"}},
+		{name: "explain", summary: "Describe a log message code, or list the catalog (-json)", run: cmdExplain,
+			usage: "[-json] [CODE]", flags: true, examples: []string{"explain W007", "explain"}},
+		{name: "shellext", summary: "List shell icon handlers and likely corruption culprits", run: cmdShellExt,
+			usage: "[-json]", flags: true, examples: []string{"shellext"}},
+		{name: "strays", summary: "List large non-cache files in the cache directory (-clean deletes them)", run: cmdStrays,
+			usage: "[-json] [-clean]", flags: true, examples: []string{"strays", "strays -clean"}},
+		{name: "plugins", summary: "List the third-party plug-ins (-check runs their heuristics)", run: cmdPlugins,
+			usage: "[-check] [-json]", flags: true, examples: []string{"plugins -check"}},
+		{name: "tune", summary: "Recommend thresholds from this machine's history (-apply writes them)", run: cmdTune,
+			usage: "[-json] [-apply [-yes]]", flags: true, examples: []string{"tune", "tune -apply"}},
+		{name: "record", summary: "Capture a cache metadata trace for simulate", run: cmdRecord,
+			usage: "[-o FILE] [-every DURATION] [-duration DURATION]", flags: true,
+			examples: []string{"record -duration 8h", `record -o C:\traces\vdi.jsonl -every 10s`}},
+		{name: "simulate", summary: "Replay a recorded cache trace and report triggers", run: cmdSimulate,
+			usage: "[-json] [-v] TRACE", flags: true, examples: []string{`simulate C:\traces\vdi.jsonl`, "--size-limit-mb=128 simulate -v trace.jsonl"}},
+		{name: "help", summary: "Show the commands, or a command's flags and examples", run: cmdHelp,
+			usage: "[COMMAND [SUBCOMMAND] | settings]", examples: []string{"help repair", "help config init", "help settings"}},
+		{name: "completion", summary: "Print a shell completion script (powershell, bash)", run: cmdCompletion,
+			usage: "powershell|bash", values: []string{"powershell", "bash"},
+			examples: []string{"completion powershell | Out-String | Invoke-Expression", "completion powershell >> $PROFILE", "completion bash"}},
+	}
+}
+
+// completeCommand is the hidden command the completion scripts call.
+const completeCommand = "__complete"
+
+func runCommand(d *daemon, args []string) int {
+	if isHelpArg(args[0]) {
+		args = []string{"help"}
+	}
+	if args[0] == completeCommand {
+		return cmdComplete(d, args[1:])
+	}
+	c, ok := findCommand(commands, args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		printCommandList(os.Stderr, commands)
+		fmt.Fprintf(os.Stderr, "\nRun `%s help <command>` for its flags and examples.\n", progName)
+		return 2
+	}
+	if len(args) > 1 && isHelpArg(args[1]) && c.name != "help" {
+		return cmdHelp(d, []string{c.name})
+	}
+	return c.run(d, args[1:])
+}
+
+func cmdConfig(d *daemon, args []string) int {
+	if len(args) == 0 {
+		printCommandHelp(d, os.Stderr, []string{"config"})
+		return 2
+	}
+	c, ok := findCommand(configCommands, args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown config command %q\n", args[0])
+		return 2
+	}
+	if len(args) > 1 && isHelpArg(args[1]) {
+		return cmdHelp(d, []string{"config", c.name})
+	}
+	return c.run(d, args[1:])
+}
+
+func findCommand(list []command, name string) (command, bool) {
+	for _, c := range list {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func isHelpArg(a string) bool {
+	switch a {
+	case "-h", "-help", "--help", "/?":
+		return true
+	}
+	return false
+}
+
+// newFlagSet is flag.NewFlagSet for a command; name is its path, e.g.
+// "config init". -h and flag errors print the command's help.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		if flagCapture != nil {
+			flagCapture(fs)
+			return
+		}
+		printHelp(fs.Output(), strings.Fields(name), fs)
+	}
+	return fs
+}
+
+// flagCapture, when set, receives the flag set of a command run with -h
+// instead of its help being printed.
+var flagCapture func(*flag.FlagSet)
+
+// commandFlags returns the flags c defines, or nil when it takes none.
+func commandFlags(d *daemon, c command) *flag.FlagSet {
+	if !c.flags {
+		return nil
+	}
+	var fs *flag.FlagSet
+	flagCapture = func(f *flag.FlagSet) { fs = f }
+	defer func() { flagCapture = nil }()
+	c.run(d, []string{"-h"})
+	return fs
+}
+
+// lookupCommand resolves a command path such as ["config", "init"].
+func lookupCommand(path []string) (command, bool) {
+	list := commands
+	var c command
+	for _, name := range path {
+		var ok bool
+		if c, ok = findCommand(list, name); !ok {
+			return command{}, false
+		}
+		list = c.sub
+	}
+	return c, len(path) > 0
+}
+
+func cmdHelp(d *daemon, args []string) int {
+	switch {
+	case len(args) == 0:
+		printOverview(os.Stdout)
+		return 0
+	case len(args) == 1 && args[0] == "settings":
+		fmt.Printf("Settings override the config file for one run, before the command:\n\n  %s [--setting=value ...] [command]\n\n%s", progName, overrideFlagList())
+		return 0
+	}
+	if _, ok := lookupCommand(args); !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", strings.Join(args, " "))
+		return 2
+	}
+	printCommandHelp(d, os.Stdout, args)
+	return 0
+}
+
+func printOverview(w io.Writer) {
+	fmt.Fprintf(w, "%s: self-healing Windows icon cache daemon\n\n", progName)
+	fmt.Fprintf(w, "Usage:\n  %s [--setting=value ...]            run the daemon\n", progName)
+	fmt.Fprintf(w, "  %s [--setting=value ...] <command> [arguments]\n\nCommands:\n", progName)
+	printCommandList(w, commands)
+	fmt.Fprintf(w, "\nRun `%s help <command>` for a command's flags and examples,\n", progName)
+	fmt.Fprintf(w, "`%s help settings` for the --setting=value overrides and\n", progName)
+	fmt.Fprintf(w, "`%s completion powershell` for tab completion.\n", progName)
+}
+
+func printCommandList(w io.Writer, list []command) {
+	for _, c := range list {
+		fmt.Fprintf(w, "  %-15s %s\n", c.name, c.summary)
+	}
+}
+
+// printCommandHelp prints the help of the command at path.
+func printCommandHelp(d *daemon, w io.Writer, path []string) {
+	c, _ := lookupCommand(path)
+	printHelp(w, path, commandFlags(d, c))
+}
+
+// printHelp prints the usage, summary, subcommands, flags and examples of
+// the command at path.
+func printHelp(w io.Writer, path []string, fs *flag.FlagSet) {
+	c, _ := lookupCommand(path)
+	usage := strings.TrimSpace(progName + " " + strings.Join(path, " ") + " " + c.usage)
+	fmt.Fprintf(w, "Usage: %s\n\n%s\n", usage, c.summary)
+	if len(c.sub) > 0 {
+		fmt.Fprintln(w, "\nSubcommands:")
+		printCommandList(w, c.sub)
+	}
+	if fs != nil && hasFlags(fs) {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+	if len(c.examples) > 0 {
+		fmt.Fprintln(w, "\nExamples:")
+		for _, e := range c.examples {
+			fmt.Fprintf(w, "  %s %s\n", progName, e)
+		}
+	}
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	n := 0
+	fs.VisitAll(func(*flag.Flag) { n++ })
+	return n > 0
+}
+
+// cmdComplete prints the completions of the last word, one per line. The
+// scripts prefix every word with ':' so an empty last word survives
+// PowerShell's native argument passing.
+func cmdComplete(d *daemon, args []string) int {
+	words := make([]string, len(args))
+	for i, a := range args {
+		words[i] = strings.TrimPrefix(a, ":")
+	}
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	for _, s := range completions(d, words[:len(words)-1], words[len(words)-1]) {
+		fmt.Println(s)
+	}
+	return 0
+}
+
+// completions lists the candidates for word after the words before it.
+func completions(d *daemon, before []string, word string) []string {
+	var cands []string
+	i := 0
+	for i < len(before) && strings.HasPrefix(before[i], "--") {
+		i++ // --setting=value overrides
+	}
+	before = before[i:]
+	if len(before) == 0 && strings.HasPrefix(word, "--") {
+		flags, _ := overrideNames()
+		for name := range flags {
+			cands = append(cands, "--"+name+"=")
+		}
+		return matching(cands, word)
+	}
+
+	list := commands
+	var c command
+	found := false
+	for _, w := range before {
+		switch {
+		case found && c.name == "help":
+			if next, ok := findCommand(list, w); ok {
+				list = next.sub
+			}
+			continue
+		case found && len(c.sub) == 0:
+			continue
+		}
+		next, ok := findCommand(list, w)
+		if !ok {
+			return nil
+		}
+		c, found, list = next, true, next.sub
+		if c.name == "help" {
+			list = commands
+		}
+	}
+
+	switch {
+	case !found || len(c.sub) > 0:
+		for _, s := range list {
+			cands = append(cands, s.name)
+		}
+	case c.name == "help":
+		for _, s := range list {
+			cands = append(cands, s.name)
+		}
+		if len(before) == 1 {
+			cands = append(cands, "settings")
+		}
+	case strings.HasPrefix(word, "-"):
+		if fs := commandFlags(d, c); fs != nil {
+			fs.VisitAll(func(f *flag.Flag) { cands = append(cands, "-"+f.Name) })
+		}
+	default:
+		cands = slices.Clone(c.values)
+	}
+	return matching(cands, word)
+}
+
+func matching(cands []string, prefix string) []string {
+	var out []string
+	for _, c := range cands {
+		if strings.HasPrefix(c, prefix) {
+			out = append(out, c)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+func cmdCompletion(d *daemon, args []string) int {
+	if len(args) != 1 || (args[0] != "powershell" && args[0] != "bash") {
+		fmt.Fprintln(os.Stderr, "usage: completion powershell|bash")
+		return 2
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		exe = progName
+	}
+	name := strings.TrimSuffix(filepath.Base(exe), ".exe")
+	if args[0] == "powershell" {
+		fmt.Printf(powerShellCompletion, name, name, strings.ReplaceAll(exe, "'", "''"), completeCommand)
+	} else {
+		fmt.Printf(bashCompletion, strings.ReplaceAll(name, "-", "_"), exe, completeCommand, strings.ReplaceAll(name, "-", "_"), name)
+	}
+	return 0
+}
+
+const powerShellCompletion = `# %s tab completion. Load it for this session:
+#   & <path>\icon-cache-watchdog.exe completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName '%s', '%[2]s.exe' -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 |
+        Where-Object { $_.Extent.StartOffset -lt $cursorPosition } |
+        ForEach-Object { ':' + $_.Extent.Text })
+    if ($wordToComplete -eq '') { $words += ':' }
+    & '%s' %s @words 2>$null | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`
+
+const bashCompletion = `# bash completion; load it with: source <(icon-cache-watchdog completion bash)
+_%s() {
+    local IFS=$'\n' w words=()
+    for w in "${COMP_WORDS[@]:1:COMP_CWORD}"; do words+=(":$w"); done
+    COMPREPLY=($('%s' %s "${words[@]}" 2>/dev/null))
+}
+complete -o default -F _%s %s
+`
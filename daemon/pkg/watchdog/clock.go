@@ -0,0 +1,35 @@
+// clock.go
+// Time source for the daemon. Cooldowns, heartbeats, staleness, quiet hours
+// and the poll/health schedules all read time through d.clock, so tests can
+// substitute a fake clock and advance it instead of waiting 45 minutes.
+
+package watchdog
+
+import "time"
+
+// Clock is the subset of the time package the daemon depends on.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker mirrors *time.Ticker behind an interface.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the wall clock used in production.
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct{ t *time.Ticker }
+
+func (r realTicker) C() <-chan time.Time { return r.t.C }
+func (r realTicker) Stop()               { r.t.Stop() }
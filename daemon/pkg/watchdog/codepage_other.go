@@ -0,0 +1,15 @@
+//go:build !windows
+
+// codepage_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+// Latin-1 stands in for the system code page.
+
+package watchdog
+
+func decodeANSI(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
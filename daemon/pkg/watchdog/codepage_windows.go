@@ -0,0 +1,26 @@
+// codepage_windows.go
+// kernel32!MultiByteToWideChar for text in the system (ANSI) code page.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const cpACP = 0
+
+var procMultiByteToWideChar = modKernel32.NewProc("MultiByteToWideChar")
+
+func decodeANSI(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	n, _, _ := procMultiByteToWideChar.Call(cpACP, 0, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), 0, 0)
+	if n == 0 {
+		return string(b)
+	}
+	u := make([]uint16, n)
+	n, _, _ = procMultiByteToWideChar.Call(cpACP, 0, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), uintptr(unsafe.Pointer(&u[0])), n)
+	return syscall.UTF16ToString(u[:n])
+}
@@ -0,0 +1,95 @@
+// compliance.go
+// `compliance detect|remediate`: the contract of an Intune proactive
+// remediation (Remediations) script pair. Intune keeps only the last line of
+// stdout, up to 2048 characters, and reads the exit code: detection exits 1
+// when the device needs remediating, remediation exits 0 when it succeeded.
+// Both modes therefore print exactly one line and exit 0 or 1, nothing else.
+//
+// detect runs the health evaluation without repairing and reports the device
+// non-compliant when the score is below the threshold or the cache exceeds
+// the size limit, as `repair` does. remediate runs a forced repair, since
+// Intune only calls it after detection failed, and waits for the outcome.
+//
+// A missing cache directory cannot be evaluated. Reporting that as
+// compliant would hide the device, so detect exits 1 with a NotEvaluated
+// line; remediate then fails without restarting Explorer, as there is
+// nothing to repair, and the device stays visible as failed in Intune.
+// scripts/Test-IconCacheCompliance.ps1 and Invoke-IconCacheRemediation.ps1
+// are the wrappers to upload.
+
+package watchdog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const complianceMaxLine = 2048 // Intune truncates script output here
+
+func cmdCompliance(d *daemon, args []string) int {
+	if len(args) != 1 || (args[0] != "detect" && args[0] != "remediate") {
+		fmt.Println("usage: compliance detect|remediate")
+		return 2
+	}
+	d.loadState()
+	d.started = d.clock.Now()
+	d.profile = detectCurrentProfile()
+	d.caps = d.probeCapabilities()
+	d.resolveCachePath()
+
+	code, line := 0, ""
+	if args[0] == "detect" {
+		d.healthLog_("INFO", "--- Health check running (compliance detection) ---")
+		res, sizeMB, needed := d.assessCache()
+		line = fmt.Sprintf("Compliant: health score %d/100, cache %.2f MB", res.Score, sizeMB)
+		if res.CacheMissing {
+			code = 1
+			line = fmt.Sprintf("NotEvaluated: cache directory %s is missing", d.cacheDir)
+		} else if needed {
+			code = 1
+			line = fmt.Sprintf("NonCompliant: %s", complianceFindings(d, res, sizeMB))
+		}
+	} else if !d.cacheDirPresent() {
+		code = 1
+		line = fmt.Sprintf("RemediationFailed: cache directory %s is missing; nothing to repair", d.cacheDir)
+	} else {
+		ok, outcome := d.repairAndWait("intune remediation", false)
+		line = "Remediated: " + outcome
+		if !ok {
+			code = 1
+			line = "RemediationFailed: " + outcome
+		}
+	}
+	fmt.Println(complianceLine(line))
+	return code
+}
+
+// complianceFindings names what made detection fail.
+func complianceFindings(d *daemon, res healthResult, sizeMB float64) string {
+	var failed []string
+	for name, passed := range res.Heuristics {
+		if !passed {
+			failed = append(failed, strings.ToUpper(name))
+		}
+	}
+	sort.Strings(failed)
+	s := fmt.Sprintf("health score %d/100 (repair below %d)", res.Score, res.Threshold)
+	if len(failed) > 0 {
+		s += ", failed " + strings.Join(failed, " ")
+	}
+	s += fmt.Sprintf(", cache %.2f MB", sizeMB)
+	if limit := d.cfg.Thresholds.SizeLimit.MB(); sizeMB > limit {
+		s += fmt.Sprintf(" (limit %.0f MB)", limit)
+	}
+	return s
+}
+
+// complianceLine folds s onto one line within Intune's limit.
+func complianceLine(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > complianceMaxLine {
+		s = s[:complianceMaxLine-3] + "..."
+	}
+	return s
+}
@@ -0,0 +1,318 @@
+// config.go
+// Optional JSON configuration file: icon-cache-watchdog.json in the project
+// root (next to scripts/ and bin/). Every field is optional — a missing file
+// or missing key keeps the built-in defaults.
+
+package watchdog
+
+import (
+	"errors"
+	"os"
+)
+
+const configFileName = "icon-cache-watchdog.json"
+
+type config struct {
+	// Preset starts from tuned defaults for "laptop", "desktop" or "vdi"
+	// (presets.go); empty keeps the built-in defaults.
+	Preset string `json:"preset"`
+
+	// DataDir selects where logs and state are written:
+	//   "programdata"  — %ProgramData%\IconCacheWatchdog (default)
+	//   "localappdata" — %LOCALAPPDATA%\IconCacheWatchdog
+	//   "install"      — the project root (legacy v2.0.0 layout)
+	//   any other value is used as an explicit directory path.
+	DataDir string `json:"dataDir"`
+
+	// RepairScript overrides scripts/Repair-IconCache.ps1. Relative paths
+	// are resolved against the project root.
+	RepairScript string `json:"repairScript"`
+
+	// RepairScriptSHA256 pins the repair script content (hex). Empty
+	// disables the check. See integrity.go.
+	RepairScriptSHA256 string `json:"repairScriptSha256"`
+
+	// PowerShell tunes how the repair script is invoked (powershell.go).
+	PowerShell powerShellOptions `json:"powershell"`
+
+	// MultiSession enables RDS / AVD session host mode (multisession.go).
+	MultiSession multiSessionOptions `json:"multiSession"`
+
+	// OptOut excludes accounts on shared machines (optout.go).
+	OptOut optOutOptions `json:"optOut"`
+
+	// VDI reports only on non-persistent desktops, where a rebuilt cache is
+	// discarded at logoff (vdi.go).
+	VDI vdiOptions `json:"vdi"`
+
+	// Health sets heuristic weights and the repair score threshold (score.go).
+	Health healthOptions `json:"health"`
+
+	// Ledger adds heuristic H6, block hashes of the cache kept between
+	// health checks (ledger.go).
+	Ledger ledgerOptions `json:"ledger"`
+
+	// Responsiveness adds heuristic H7, a probe of the taskbar and desktop
+	// windows, and can restart a hung Explorer (responsive.go).
+	Responsiveness responsivenessOptions `json:"responsiveness"`
+
+	// ShellStability adds heuristic H8, the rate of Explorer crashes and
+	// shell restarts (shellstability.go).
+	ShellStability shellStabilityOptions `json:"shellStability"`
+
+	// Pause bounds how long users may pause the watchdog (pause.go).
+	Pause pauseOptions `json:"pause"`
+
+	// ShellRescue starts Explorer when it is missing from an unlocked
+	// session (shellrescue.go).
+	ShellRescue shellRescueOptions `json:"shellRescue"`
+
+	// Quiet defers non-critical repairs during Focus Assist / quiet hours.
+	Quiet quietOptions `json:"quiet"`
+
+	// Thresholds and intervals; defaults are the constants in daemon.go.
+	Thresholds thresholdOptions `json:"thresholds"`
+
+	// SizeRepair verifies that size-triggered repairs shrink the cache and
+	// escalates when they do not (sizeverify.go).
+	SizeRepair sizeRepairOptions `json:"sizeRepair"`
+
+	// Logging selects split or unified log files (logging.go).
+	Logging loggingOptions `json:"logging"`
+
+	// DeepClean schedules a periodic full rebuild (deepclean.go).
+	DeepClean deepCleanOptions `json:"deepClean"`
+
+	// SelfCheck limits the daemon's own resource use (selfcheck.go).
+	SelfCheck selfCheckOptions `json:"selfCheck"`
+
+	// Reports writes a daily health summary for collection (reports.go).
+	Reports reportOptions `json:"reports"`
+
+	// WeeklySummary delivers a plain-language summary of the week as a
+	// file, toast and/or email (weeklysummary.go).
+	WeeklySummary weeklySummaryOptions `json:"weeklySummary"`
+
+	// Remote accepts repair and health-check requests via named events and
+	// a window message (remote.go).
+	Remote remoteOptions `json:"remote"`
+
+	// Triggers queues triggers from Layer A and other tools until a daemon
+	// processes them (spool.go).
+	Triggers triggerOptions `json:"triggers"`
+
+	// WMI publishes the health state as a WMI class (wmi.go).
+	WMI wmiOptions `json:"wmi"`
+
+	// EventLog writes state changes to the Application log (eventlog.go).
+	EventLog eventLogOptions `json:"eventLog"`
+
+	// Heartbeat delivers the heartbeat to the event log, a webhook and/or a
+	// file for liveness monitoring (heartbeat.go).
+	Heartbeat heartbeatOptions `json:"heartbeat"`
+
+	// Incidents groups a trigger, its repair and its verification under one
+	// ID in logs, events, history and an optional webhook (incident.go).
+	Incidents incidentOptions `json:"incidents"`
+
+	// OTel pushes metrics and repair traces to an OpenTelemetry collector
+	// over OTLP/HTTP (otel.go).
+	OTel otelOptions `json:"otel"`
+
+	// USNJournal lets Layer B skip directory scans when the NTFS change
+	// journal shows no cache changes, and catch up after downtime (usn.go).
+	USNJournal usnOptions `json:"usnJournal"`
+
+	// Backup copies the cache files before each repair (backup.go).
+	Backup backupOptions `json:"backup"`
+
+	// Network holds repairs while shortcut targets are on unreachable
+	// shares (network.go).
+	Network networkOptions `json:"network"`
+
+	// ShortcutScan reports shortcuts with missing targets (brokenlinks.go).
+	ShortcutScan shortcutScanOptions `json:"shortcutScan"`
+
+	// UI serves the local web dashboard (dashboard.go).
+	UI uiOptions `json:"ui"`
+
+	// Fleet serves the status to `status -host` on other machines
+	// (fleet.go).
+	Fleet fleetOptions `json:"fleet"`
+
+	// GRPC serves the gRPC management API (grpcapi.go).
+	GRPC grpcOptions `json:"grpc"`
+
+	// Control restricts the control pipe and sets what the audit log
+	// records (control.go, audit.go).
+	Control controlOptions `json:"control"`
+
+	// Plugins runs third-party heuristics and repair actions (plugins.go).
+	Plugins pluginOptions `json:"plugins"`
+
+	// ShellMods adapts repairs to shell replacements such as ExplorerPatcher
+	// and Open-Shell (shellmods.go).
+	ShellMods shellModOptions `json:"shellMods"`
+
+	// Antivirus watches for scanners holding the cache files during repairs
+	// (av.go).
+	Antivirus antivirusOptions `json:"antivirus"`
+
+	// CacheFiles names the icon and thumbnail cache files by pattern
+	// (cachefiles.go).
+	CacheFiles cacheFileOptions `json:"cacheFiles"`
+
+	// StrayFiles reports large non-cache files in the cache directory
+	// (strayfiles.go).
+	StrayFiles strayFileOptions `json:"strayFiles"`
+
+	// SoftRepair runs ie4uinit.exe before the full repair for a failing
+	// health check (softrepair.go).
+	SoftRepair softRepairOptions `json:"softRepair"`
+
+	// TierRepair parses the cache tiers and rebuilds corrupt ones by
+	// themselves (tiers.go).
+	TierRepair tierRepairOptions `json:"tierRepair"`
+
+	// WarmUp loads the shortcuts' icons into a rebuilt cache (warmup.go).
+	WarmUp warmUpOptions `json:"warmUp"`
+
+	// ExplorerStop picks how the repair script stops Explorer
+	// (explorerstop.go).
+	ExplorerStop explorerStopOptions `json:"explorerStop"`
+
+	// UpdateBaseline resets the heuristics' baselines after a Windows
+	// cumulative update (updatebaseline.go).
+	UpdateBaseline updateBaselineOptions `json:"updateBaseline"`
+
+	// Breaker stops automatic repairs when the watchdog loops (breaker.go).
+	Breaker breakerOptions `json:"breaker"`
+
+	// Targets override the size limit and cooldown for the icon cache, the
+	// thumbnail cache and single accounts (targets.go).
+	Targets targetOptions `json:"targets"`
+}
+
+type thresholdOptions struct {
+	SizeLimit          byteSize `json:"sizeLimit"`        // Layer B: repair above this cache size
+	GrowthSlope        float64  `json:"growthSlope"`      // MB per hour of growth the size score ignores (score.go)
+	Cooldown           duration `json:"cooldown"`         // minimum time between repairs
+	PollEvery          duration `json:"pollEvery"`        // Layer B poll interval
+	HealthCheckEvery   duration `json:"healthCheckEvery"` // Layer D interval
+	HeartbeatEvery     duration `json:"heartbeatEvery"`
+	StillExceededEvery duration `json:"stillExceededEvery"` // Layer B: summary of polls over the limit after a trigger
+	RecentWrite        duration `json:"recentWrite"`        // H2: suspicious write window
+	MinHealthyFiles    int      `json:"minHealthyFiles"`    // H3
+	StaleAge           duration `json:"staleAge"`           // H4: preemptive refresh age
+	IndexMinSize       byteSize `json:"indexMinSize"`       // H1: smallest healthy index
+	SlowRepair         duration `json:"slowRepair"`         // warn when a direct repair takes longer
+	MinFreeSpace       byteSize `json:"minFreeSpace"`       // skip repairs below this free disk space
+}
+
+func defaultConfig() config {
+	return config{
+		DataDir: dataDirProgramData,
+		PowerShell: powerShellOptions{
+			NoProfile: true,
+		},
+		MultiSession: multiSessionOptions{
+			MaxConcurrentRepairs: 1,
+			PollWorkers:          4,
+		},
+		OptOut: optOutOptions{
+			AllowUsers: true,
+		},
+		VDI: vdiOptions{
+			Mode: vdiModeAuto,
+		},
+		Health:         defaultHealthOptions(),
+		Ledger:         defaultLedgerOptions(),
+		Responsiveness: defaultResponsivenessOptions(),
+		ShellStability: defaultShellStabilityOptions(),
+		ShellRescue:    defaultShellRescueOptions(),
+		Pause:          defaultPauseOptions(),
+		Quiet: quietOptions{
+			RespectFocusAssist:   true,
+			HoldDuringFullScreen: true,
+		},
+		Thresholds: thresholdOptions{
+			SizeLimit:          defaultSizeLimit,
+			Cooldown:           defaultCooldown,
+			PollEvery:          defaultPollEvery,
+			HealthCheckEvery:   defaultHealthCheckEvery,
+			HeartbeatEvery:     defaultHeartbeatEvery,
+			StillExceededEvery: defaultStillExceeded,
+			RecentWrite:        defaultRecentWrite,
+			MinHealthyFiles:    defaultMinHealthyFiles,
+			StaleAge:           defaultStaleAge,
+			IndexMinSize:       defaultIndexMinSize,
+			SlowRepair:         defaultSlowRepair,
+			MinFreeSpace:       defaultMinFreeSpace,
+		},
+		SizeRepair: defaultSizeRepairOptions(),
+		Logging: loggingOptions{
+			Mode: logModeSplit,
+		},
+		DeepClean: defaultDeepCleanOptions(),
+		SelfCheck: selfCheckOptions{
+			MaxMemory:  defaultMaxMemory,
+			MaxHandles: defaultMaxHandles,
+		},
+		Reports: reportOptions{
+			Format:   reportFormatBoth,
+			Jitter:   defaultReportJitter,
+			RetryMax: defaultReportRetryMax,
+		},
+		WeeklySummary: defaultWeeklySummaryOptions(),
+		Triggers:      defaultTriggerOptions(),
+		Heartbeat:     defaultHeartbeatOptions(),
+		Incidents:     defaultIncidentOptions(),
+		OTel:          defaultOTelOptions(),
+		Backup: backupOptions{
+			Keep: 3,
+		},
+		Network:   defaultNetworkOptions(),
+		UI:        defaultUIOptions(),
+		Fleet:     defaultFleetOptions(),
+		GRPC:      defaultGRPCOptions(),
+		Control:   defaultControlOptions(),
+		Plugins:   defaultPluginOptions(),
+		ShellMods: defaultShellModOptions(),
+		Antivirus: antivirusOptions{
+			Enabled: true,
+		},
+		CacheFiles:     defaultCacheFileOptions(),
+		StrayFiles:     defaultStrayFileOptions(),
+		SoftRepair:     defaultSoftRepairOptions(),
+		WarmUp:         defaultWarmUpOptions(),
+		ExplorerStop:   defaultExplorerStopOptions(),
+		UpdateBaseline: defaultUpdateBaselineOptions(),
+		Breaker:        defaultBreakerOptions(),
+		Targets:        defaultTargetOptions(),
+	}
+}
+
+// loadConfig reads the config file at path on top of the defaults and
+// reports which settings it set. A missing file is not an error; a file
+// failing the signature policy (signature.go) or the schema (schema.go) is,
+// and yields the defaults. Policy is layered on afterwards (settings.go).
+func loadConfig(path string) (config, configSources, error) {
+	cfg := defaultConfig()
+	sources := configSources{}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, sources, nil
+	}
+	if err != nil {
+		return cfg, sources, err
+	}
+	if err := verifyConfigSignature(path, raw); err != nil {
+		return cfg, sources, err
+	}
+	cfg, keys, err := parseConfig(raw)
+	for _, k := range keys {
+		sources[k] = sourceFile
+	}
+	applyPreset(&cfg, sources)
+	return cfg, sources, err
+}
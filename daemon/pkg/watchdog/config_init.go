@@ -0,0 +1,288 @@
+// config_init.go
+// `config init` writes a commented config file holding every setting at its
+// default, as a starting point for edits; -preset=NAME writes the preset's
+// values instead (presets.go). The comments use // syntax, which
+// the loader strips before parsing (schema.go).
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// settingDocs is the one-line description written above each key.
+var settingDocs = map[string]string{
+	"preset":                            `Tuned defaults: "laptop", "desktop" or "vdi"; empty for the built-in defaults`,
+	"dataDir":                           `Logs and state: "programdata", "localappdata", "install" or a path`,
+	"repairScript":                      "Repair script; relative paths resolve against the project root",
+	"repairScriptSha256":                "Pinned SHA-256 of the repair script (hex); empty disables the check",
+	"powershell":                        "How the repair script is launched",
+	"powershell.path":                   "Interpreter; empty selects PowerShell 7, else System32 Windows PowerShell",
+	"powershell.noProfile":              "Pass -NoProfile to the hidden child",
+	"powershell.constrainedLanguage":    "Run the script in ConstrainedLanguage mode",
+	"multiSession":                      "RDS / AVD session host mode (run as LocalSystem)",
+	"multiSession.enabled":              "Monitor every active user session from one daemon",
+	"multiSession.maxConcurrentRepairs": "Host-wide cap on repairs running at the same time",
+	"multiSession.pollWorkers":          "Sessions polled and health-checked in parallel",
+	"optOut":                            "Accounts left alone on shared machines",
+	"optOut.users":                      `Accounts never monitored, comma-separated "user" or "DOMAIN\user" (* and ? wildcards)`,
+	"optOut.allowUsers":                 "Honour the users' own opt-out (opt-out command or OptOut registry value)",
+	"vdi":                               "Non-persistent VDI (Citrix PVS / MCS): report instead of repairing a cache discarded at logoff",
+	"vdi.mode":                          `"auto" (report only when the cache does not survive logoff), "on" or "off"`,
+	"health":                            "Health score: weighted heuristics, repair below a threshold",
+	"health.weights":                    "Contribution of each heuristic and of size headroom",
+	"health.repairBelowScore":           "Repair when the score (0-100) drops below this",
+	"health.schedule":                   "Periodic checks on a calendar, e.g. \"Mon-Fri 09:00,13:00\" or cron fields; empty: every thresholds.healthCheckEvery",
+	"ledger":                            "H6: block hashes of the cache, compared at the next health check",
+	"ledger.enabled":                    "Fail H6 when cache content changes while size and modification time do not",
+	"ledger.sampleBlocks":               "64 KiB blocks hashed per file, first and last included; 0 hashes every block",
+	"ledger.weight":                     "Score weight of H6",
+	"responsiveness.enabled":            "H7: probe the taskbar and desktop windows for a hung Explorer",
+	"responsiveness.timeout":            "H7: time a shell window has to answer, per probe",
+	"responsiveness.weight":             "Score weight of H7; 0 reports a hang without rebuilding the cache",
+	"responsiveness.restartExplorer":    "Restart a hung Explorer even when the cache looks fine",
+	"shellStability":                    "H8: rate of Explorer crashes (WER reports) and shell restarts",
+	"shellStability.enabled":            "H8: count Explorer crashes, hangs and restarts",
+	"shellStability.window":             "H8: span the crashes and restarts are counted over",
+	"shellStability.maxEvents":          "H8 fails above this many crashes or restarts in the window",
+	"shellStability.weight":             "Score weight of H8; 10 alone stays above the default threshold",
+	"shellRescue.enabled":               "Start Explorer when it is missing from an unlocked session",
+	"shellRescue.after":                 "Time without Explorer before the shell is started",
+	"pause.enabled":                     "Allow users to pause repairs with `pause`",
+	"pause.max":                         "Longest pause accepted",
+	"quiet":                             "When repairs wait",
+	"quiet.respectFocusAssist":          "Defer non-critical repairs while Focus Assist is on",
+	"quiet.hours":                       `Daily window in which non-critical repairs wait, e.g. "22:00-07:00"`,
+	"quiet.holdDuringFullScreen":        "Hold all repairs while a full-screen application is in front",
+	"quiet.holdForProcesses":            `Programs that hold all repairs while they run, e.g. "obs64.exe, setup*.exe"`,
+	"thresholds":                        `Sizes: "B", "KB", "MB", "GB". Durations: "s", "m", "h", "d"`,
+	"thresholds.sizeLimit":              "Repair when the cache exceeds this",
+	"thresholds.growthSlope":            "Growth in MB per hour that the size score treats as normal (0 = any growth counts; see `tune`)",
+	"thresholds.cooldown":               "Minimum time between repairs",
+	"thresholds.pollEvery":              "Cache size poll interval",
+	"thresholds.healthCheckEvery":       "Periodic health check interval",
+	"thresholds.heartbeatEvery":         "Heartbeat log interval",
+	"thresholds.stillExceededEvery":     "After a size trigger, how often a cache still over the limit is summarized until the trigger re-arms",
+	"thresholds.recentWrite":            "H2: window for suspicious writes while Explorer is down",
+	"thresholds.minHealthyFiles":        "H3: minimum cache files while Explorer is running",
+	"thresholds.staleAge":               "H4: cache age that triggers a preemptive refresh",
+	"thresholds.indexMinSize":           "H1: smallest healthy iconcache_idx.db",
+	"thresholds.slowRepair":             "Warn when a direct repair takes longer than this",
+	"thresholds.minFreeSpace":           "Skip repairs while the cache volume has less free space (0 = off)",
+	"sizeRepair":                        "Check that size-triggered repairs shrink the cache; escalate when they do not",
+	"sizeRepair.verify":                 "Verify each size repair; an ineffective one leads to a deep clean, then to suspension",
+	"sizeRepair.recoveryPercent":        "A size repair must bring the cache below this percentage of thresholds.sizeLimit",
+	"sizeRepair.verifyAfter":            "Verify no sooner than this after the repair started (and only once it ended)",
+	"sizeRepair.maxIneffective":         "Suspend size repairs after this many ineffective ones in a row, until the cache shrinks",
+	"logging":                           "Log files",
+	"deepClean":                         "Scheduled full rebuild regardless of heuristics (single-user mode)",
+	"deepClean.enabled":                 "Run the deep clean: icon and thumbnail caches, tray icon streams, shell icon index",
+	"deepClean.every":                   "Minimum time between deep cleans",
+	"deepClean.window":                  "Daily maintenance window in which it may start",
+	"deepClean.schedule":                "Run at these calendar slots instead, e.g. \"Sat 03:00\" or cron fields",
+	"selfCheck":                         "Limits on the daemon's own resource use, checked every minute",
+	"selfCheck.maxMemory":               "Warn when the daemon's private memory exceeds this",
+	"selfCheck.maxHandles":              "Warn when the daemon holds more OS handles than this",
+	"selfCheck.restart":                 "Restart the daemon when over a limit (after at least 1h of uptime)",
+	"reports":                           "Daily health summary files for collection from a directory or share (single-user mode)",
+	"reports.enabled":                   "Write the daily report",
+	"reports.dir":                       `Local directory or UNC share; empty means <dataDir>\reports`,
+	"reports.format":                    `"json", "csv" or "both"`,
+	"reports.jitter":                    "Window over which machines spread their deliveries to reports.dir; each keeps a fixed slot",
+	"reports.retryMax":                  "Longest wait between attempts to deliver to an unreachable reports.dir",
+	"weeklySummary":                     "Plain-language summary of the week for the user (single-user mode)",
+	"weeklySummary.enabled":             "Deliver the weekly summary; keeps the daily reports it is built from",
+	"weeklySummary.day":                 "Weekday of delivery, e.g. \"monday\"",
+	"weeklySummary.at":                  "Local time of delivery, \"HH:MM\"",
+	"weeklySummary.toast":               "Show the headline as a Windows notification",
+	"weeklySummary.smtp":                "SMTP relay \"host:port\" to mail the summary through; empty: no email",
+	"weeklySummary.from":                "Sender address of the email",
+	"weeklySummary.to":                  "Comma-separated recipients of the email",
+	"remote":                            "Named events and a window message for RMM tools (single-user mode)",
+	"remote.enabled":                    "Accept repair and health-check requests via events and window message",
+	"triggers":                          "Queue of triggers from Layer A and other tools (trigger command)",
+	"triggers.maxAge":                   "Drop queued triggers older than this instead of replaying old crashes",
+	"triggers.startDaemon":              `Start the \IconCache\Watchdog task when a trigger finds no daemon running`,
+	"wmi":                               `Health state as WMI class root\IconCacheWatchdog:IconCache_Health`,
+	"wmi.enabled":                       "Publish after every health check (class registered by Register-Tasks.ps1)",
+	"eventLog":                          "Application log events (source IconCacheWatchdog) for event-triggered admin scripts",
+	"eventLog.enabled":                  "Write one event ID per state change: repairs, health, size, config tampering",
+	"heartbeat":                         "Deliver the heartbeat (thresholds.heartbeatEvery) to liveness monitors, not only the log",
+	"heartbeat.eventLog":                "Write event 101 at startup and every heartbeat, even without eventLog.enabled",
+	"heartbeat.webhook":                 "http(s) URL of a push monitor; the heartbeat is POSTed to it as JSON",
+	"heartbeat.file":                    "File rewritten with the heartbeat as JSON, relative to the data dir; agents check its age",
+	"heartbeat.timeout":                 "Timeout of a webhook ping",
+	"incidents.enabled":                 "Group a trigger, its repair and its verification under one incident ID",
+	"incidents.webhook":                 "http(s) URL the opening and closing of each incident are POSTed to as JSON",
+	"incidents.timeout":                 "Timeout of an incident webhook post",
+	"incidents.maxOpen":                 "Close an incident unresolved when it is still open after this",
+	"otel.enabled":                      "Push metrics and repair traces to an OpenTelemetry collector over OTLP/HTTP",
+	"otel.endpoint":                     "OTLP/HTTP base URL of the collector; /v1/metrics and /v1/traces are appended",
+	"otel.headers":                      "Request headers as name=value,name=value, e.g. an API key",
+	"otel.serviceName":                  "service.name resource attribute",
+	"otel.traces":                       "Export one trace per direct repair, with a span per progress phase",
+	"otel.metrics":                      "Export the daemon's metrics every otel.interval",
+	"otel.interval":                     "Metrics export interval",
+	"otel.timeout":                      "Timeout of an OTLP request",
+	"usnJournal":                        "NTFS USN journal as the Layer B change source (single-user mode)",
+	"usnJournal.enabled":                "Scan the cache only after journal changes; log changes made while stopped",
+	"backup":                            `Copy the cache files to <dataDir>\backups\<user> before each repair`,
+	"backup.enabled":                    "Back up before deleting",
+	"backup.keep":                       "Backups kept per user (newest first)",
+	"backup.shadowCopy":                 "Read the files from a Volume Shadow Copy snapshot (needs elevation)",
+	"network":                           "Hold repairs while shortcut targets are on unreachable shares (single-user mode)",
+	"network.holdWhileOffline":          "Defer non-critical repairs and deep cleans; a rebuild now would cache generic icons",
+	"network.minShortcuts":              "Shortcuts that must be affected to hold",
+	"network.probeTimeout":              "Time each share gets to answer",
+	"network.maxHold":                   "Stop holding for shares unreachable this long",
+	"shortcutScan":                      "Report dead desktop / Start menu shortcuts apart from the cache (single-user mode)",
+	"shortcutScan.enabled":              "Check shortcut targets with every health check; never triggers a repair",
+	"ui":                                "Local web dashboard on 127.0.0.1 (single-user mode; `dashboard` prints the link)",
+	"ui.enabled":                        "Serve the dashboard; --ui turns it on for one run",
+	"ui.port":                           "TCP port on the loopback address; 0 picks a free one",
+	"ui.tlsCert":                        "PEM certificate to serve the dashboard over HTTPS; empty: plain HTTP",
+	"ui.tlsKey":                         "PEM private key of ui.tlsCert",
+	"ui.clientCA":                       "PEM CA bundle; require a client certificate it signed (mutual TLS)",
+	"fleet":                             "Read-only HTTPS status endpoint for `status -host` on other machines",
+	"fleet.enabled":                     "Serve the status on the network (machine-wide instance: needs multiSession.enabled)",
+	"fleet.port":                        "TCP port on every interface; 0 picks a free one",
+	"fleet.tokenFile":                   "File holding the token shared by the fleet (16 characters or more)",
+	"fleet.tlsCert":                     "PEM certificate of the endpoint (required)",
+	"fleet.tlsKey":                      "PEM private key of fleet.tlsCert",
+	"fleet.ca":                          "PEM CA bundle `status -host` trusts besides the system roots",
+	"grpc":                              "gRPC management API on 127.0.0.1 for integrators (single-user mode; schema in pkg/api)",
+	"grpc.enabled":                      "Serve the API; its address and token go to grpc.json in the data directory",
+	"grpc.port":                         "TCP port on the loopback address; 0 picks a free one",
+	"control":                           "Access to the control pipe and the audit log of control commands",
+	"control.allow":                     "SIDs granted the control pipe besides the daemon's account, Administrators and SYSTEM (Windows)",
+	"control.audit":                     "\"changes\" (repairs, rollbacks, pauses, dashboard links, refusals), \"all\" or \"off\"",
+	"plugins":                           "Third-party heuristics and repair actions: executables speaking JSON on stdin/stdout",
+	"plugins.enabled":                   "Describe the plug-ins at startup and call them with every health check and repair",
+	"plugins.dir":                       "Folder of the plug-in executables, inside the project root",
+	"plugins.weight":                    "Score weight of each plug-in heuristic",
+	"plugins.timeout":                   "Time each call gets before the plug-in is skipped",
+	"shellMods":                         "Shell replacements (ExplorerPatcher, StartAllBack, Open-Shell) found by the health checks",
+	"shellMods.watch":                   "Poll the replacements' own icon caches with Explorer's and clear them in repairs",
+	"shellMods.restartHosts":            "Restart the replacements' host processes (e.g. Open-Shell's StartMenu.exe) with Explorer",
+	"antivirus":                         "Antivirus scanners holding the cache files during repairs",
+	"antivirus.enabled":                 "Watch each repair and the rebuild after it; advise an exclusion when a scanner keeps interfering",
+	"cacheFiles":                        "Names of the cache files in the Explorer folder: globs, or regular expressions after \"re:\"",
+	"cacheFiles.icon":                   "Icon cache files: sized, polled, counted by H3 and deleted by repairs",
+	"cacheFiles.thumbnail":              "Thumbnail cache files: also covered by the ledger, the antivirus watch, deep cleans and rollbacks",
+	"strayFiles":                        "Large files in the cache directory that are not cache files (leftover copies, temp files)",
+	"strayFiles.enabled":                "List them with every health check; never triggers a repair",
+	"strayFiles.minSize":                "Smallest file reported",
+	"softRepair":                        "Reload the shell's icons with ie4uinit.exe before deleting the cache",
+	"softRepair.enabled":                "Try the soft repair first for a failing health check; the full repair follows at the next check if it did not help",
+	"softRepair.timeout":                "Stop ie4uinit.exe after this",
+	"softRepair.refreshWindows":         "Refresh the desktop and open Explorer windows (F5) after a soft repair",
+	"tierRepair":                        "Parse each resolution tier of the icon cache and rebuild only the corrupt ones",
+	"tierRepair.enabled":                "Delete just the corrupt tiers and the index when the rest of the cache is healthy",
+	"warmUp":                            "Load the icons of taskbar, desktop and Start menu shortcuts into the cache after a repair",
+	"warmUp.enabled":                    "Warm the rebuilt cache so the user sees no white placeholder icons",
+	"warmUp.delay":                      "Wait after the repair, for Explorer to settle",
+	"warmUp.maxItems":                   "Most files asked for per warm-up",
+	"explorerStop":                      "How the repair script stops Explorer",
+	"explorerStop.method":               "\"forced\" kills explorer.exe; \"graceful\" tries Restart Manager, then the shell's own exit, then force",
+	"explorerStop.restartManager":       "Wait for Explorer to exit after the Restart Manager shutdown",
+	"explorerStop.graceful":             "Wait for Explorer to exit after \"Exit Explorer\"",
+	"explorerStop.force":                "Wait for Explorer to exit after it is killed",
+	"updateBaseline":                    "Reset heuristic baselines after a Windows cumulative update",
+	"updateBaseline.enabled":            "Re-baseline when the OS build or update revision changes",
+	"updateBaseline.settle":             "After an update, tolerate H2 writes and baseline new shell extensions for this long",
+	"breaker":                           "Stop automatic repairs when the watchdog loops, until `resume`",
+	"breaker.enabled":                   "Trip the circuit breaker on trigger floods and repeated repair failures",
+	"breaker.maxTriggers":               "Trip when more repairs than this are requested within an hour",
+	"breaker.maxFailures":               "Trip when this many repairs fail in a row",
+	"targets":                           "Size limit and cooldown per target; a value left out or 0 is inherited",
+	"targets.icon":                      "The icon cache: replaces thresholds.sizeLimit and thresholds.cooldown",
+	"targets.thumbnail":                 "The thumbnail cache, watched while sizeLimit is set; repairs clear it too",
+	"targets.users":                     `Per-account overrides, first match wins: [{"match": "CONTOSO\\design-*", "icon": {...}, "thumbnail": {...}}]`,
+	"logging.mode":                      `"split" (Watchdog.log + IconCacheHealth.log) or "unified" (IconCache.log, tagged by subsystem)`,
+}
+
+func cmdConfigInit(d *daemon, args []string) int {
+	fs := newFlagSet("config init")
+	force := fs.Bool("force", false, "overwrite an existing file")
+	preset := fs.String("preset", "", "start from a preset's values (config presets)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg, err := presetConfig(*preset)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	path := d.configFile
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !*force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			fmt.Fprintf(os.Stderr, "%s already exists (use -force to overwrite)\n", path)
+		} else {
+			fmt.Fprintf(os.Stderr, "Cannot write config: %v\n", err)
+		}
+		return 1
+	}
+	defer f.Close()
+	if _, err := f.WriteString(commentedConfig(cfg)); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write config: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Wrote %s\n", path)
+	if readSigningPolicy().required {
+		fmt.Println("Signed config is required on this machine: sign it with `config sign -key=FILE` after editing.")
+	}
+	return 0
+}
+
+// commentedConfig renders cfg as JSON with a comment above each key.
+func commentedConfig(cfg config) string {
+	var b strings.Builder
+	b.WriteString("// icon-cache-watchdog configuration. Every key is optional: delete a key\n")
+	b.WriteString("// to use its built-in default. Check edits with `config validate`.\n")
+	writeCommentedObject(&b, reflect.ValueOf(cfg), "", "")
+	b.WriteString("\n")
+	return b.String()
+}
+
+func writeCommentedObject(b *strings.Builder, v reflect.Value, path, indent string) {
+	fields := orderedFields(v.Type())
+	b.WriteString("{\n")
+	for i, f := range fields {
+		fv := v.FieldByIndex(f.Index)
+		p := f.Name
+		if path != "" {
+			p = path + "." + f.Name
+		}
+		inner := indent + "  "
+		if doc := settingDocs[p]; doc != "" {
+			if i > 0 && path == "" {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(b, "%s// %s\n", inner, doc)
+		}
+		fmt.Fprintf(b, "%s%q: ", inner, f.Name)
+		if fv.Kind() == reflect.Struct && !reflect.PointerTo(fv.Type()).Implements(jsonUnmarshaler) && p != "health.weights" {
+			writeCommentedObject(b, fv, p, inner)
+		} else {
+			raw, _ := json.Marshal(fv.Interface())
+			b.Write(raw)
+		}
+		if i < len(fields)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(indent + "}")
+}
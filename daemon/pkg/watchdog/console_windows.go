@@ -0,0 +1,31 @@
+// console_windows.go
+// The daemon is a GUI-subsystem binary and has no console. CLI subcommands
+// (status, ...) attach to the parent console so their output is visible
+// when run from cmd.exe or PowerShell. Redirected output is left untouched.
+
+package watchdog
+
+import (
+	"os"
+	"syscall"
+)
+
+const attachParentProcess = ^uint32(0) // ATTACH_PARENT_PROCESS ((DWORD)-1)
+
+var (
+	modKernel32       = syscall.NewLazyDLL("kernel32.dll")
+	procAttachConsole = modKernel32.NewProc("AttachConsole")
+)
+
+func attachParentConsole() {
+	if h, err := syscall.GetStdHandle(syscall.STD_OUTPUT_HANDLE); err == nil && h != 0 && h != syscall.InvalidHandle {
+		return // stdout already redirected to a file or pipe
+	}
+	if r, _, _ := procAttachConsole.Call(uintptr(attachParentProcess)); r == 0 {
+		return
+	}
+	if f, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0); err == nil {
+		os.Stdout = f
+		os.Stderr = f
+	}
+}
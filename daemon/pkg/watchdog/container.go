@@ -0,0 +1,127 @@
+// container.go
+// Profile containers. FSLogix and RDS User Profile Disks (UPD) keep the
+// profile in a VHD(X) that is attached at logon and mounted over
+// C:\Users\<name>, so the Explorer cache lives on the container's volume
+// rather than on C:. The path the daemon watches stays the same, but the
+// USN journal has to be read on the container volume (usn_windows.go), and
+// the container itself can be the problem: one that failed to attach
+// leaves a local or temporary profile, one attached read-only (FSLogix
+// concurrent sessions) discards every repair at logoff, and a full one
+// makes Explorer's cache writes fail.
+//
+// Every health check resolves the cache folder to its final path on the
+// volume it is on and records the container state in the profile
+// (status.json, healthcheck -json); a new problem is logged and reported
+// once (eventlog.go).
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	containerFSLogix = "FSLogix"
+	containerUPD     = "UPD"
+
+	// UPD is configured per RD Session Host collection.
+	updSettingsKey = `SYSTEM\CurrentControlSet\Control\Terminal Server\ClusterSettings`
+
+	containerMinFree = 200 * mib
+)
+
+type containerInfo struct {
+	Kind      string  `json:"kind"`                // containerFSLogix or containerUPD
+	Mounted   bool    `json:"mounted"`             // the cache is on the container volume
+	Volume    string  `json:"volume,omitempty"`    // \\?\Volume{GUID}\ holding the cache
+	CachePath string  `json:"cachePath,omitempty"` // final path of the cache folder
+	ReadOnly  bool    `json:"readOnly,omitempty"`
+	SizeMB    float64 `json:"sizeMB,omitempty"`
+	FreeMB    float64 `json:"freeMB,omitempty"`
+	Problem   string  `json:"problem,omitempty"`
+}
+
+func (c containerInfo) String() string {
+	if c.Problem != "" {
+		return fmt.Sprintf("%s container %s", c.Kind, c.Problem)
+	}
+	return fmt.Sprintf("%s container, cache at %s (%.0f MB free of %.0f MB)", c.Kind, c.CachePath, c.FreeMB, c.SizeMB)
+}
+
+// containerKind names the profile container technology enabled on this
+// machine, or "".
+func containerKind() string {
+	for _, k := range []struct{ kind, key, value string }{
+		{containerFSLogix, fslogixProfilesKey, "Enabled"},
+		{containerUPD, updSettingsKey, "UvhdEnabled"},
+	} {
+		if data, typ, err := readRegistryValue(k.key, k.value); err == nil {
+			if n, _ := registryDWORD(data, typ); n != 0 {
+				return k.kind
+			}
+		}
+	}
+	return ""
+}
+
+// detectContainer resolves cacheDir onto its volume and checks the
+// container's mount. It returns nil when no container technology is on.
+func detectContainer(cacheDir string) *containerInfo {
+	kind := containerKind()
+	if kind == "" {
+		return nil
+	}
+	c := &containerInfo{Kind: kind}
+	vol, final, err := resolveVolume(cacheDir)
+	if err != nil {
+		c.Problem = fmt.Sprintf("not reachable: %v", err)
+		return c
+	}
+	c.Volume, c.CachePath = vol, final
+	drive := os.Getenv("SystemDrive")
+	if drive == "" {
+		drive = "C:"
+	}
+	if sys, err := volumeGUIDName(drive + `\`); err == nil && strings.EqualFold(sys, vol) {
+		c.Problem = "not attached: the cache is on the system volume (local or temporary profile)"
+		return c
+	}
+	c.Mounted = true
+	size, free, readOnly, err := volumeStats(vol)
+	if err != nil {
+		c.Problem = fmt.Sprintf("volume unreadable: %v", err)
+		return c
+	}
+	c.SizeMB, c.FreeMB, c.ReadOnly = byteSize(size).MB(), byteSize(free).MB(), readOnly
+	switch {
+	case readOnly:
+		c.Problem = "attached read-only: cache writes and repairs are discarded at logoff"
+	case free < uint64(containerMinFree):
+		c.Problem = fmt.Sprintf("nearly full (%.0f MB free): Explorer cannot write the cache", c.FreeMB)
+	}
+	return c
+}
+
+// checkContainer refreshes the container state of the profile and logs
+// what changed.
+func (d *daemon) checkContainer() {
+	if d.sim != nil {
+		return
+	}
+	c := detectContainer(d.cacheDir)
+	d.mu.Lock()
+	prev := d.profile.Container
+	d.profile.Container = c
+	d.mu.Unlock()
+	switch {
+	case c == nil:
+	case c.Problem != "" && (prev == nil || prev.Problem != c.Problem):
+		d.logMsg(msgContainerIssue, c)
+	case c.Problem == "" && prev != nil && prev.Problem != "":
+		d.healthLog_("INFO", fmt.Sprintf("Profile container healthy again: %s", c))
+	case prev == nil:
+		d.healthLog_("INFO", fmt.Sprintf("Profile container: %s", c))
+	}
+}
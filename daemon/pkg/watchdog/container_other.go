@@ -0,0 +1,18 @@
+//go:build !windows
+
+// container_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "errors"
+
+var errNoVolumes = errors.New("volume resolution is only available on Windows")
+
+func resolveVolume(path string) (volume, final string, err error) { return "", "", errNoVolumes }
+
+func volumeGUIDName(mountPoint string) (string, error) { return "", errNoVolumes }
+
+func volumeStats(volume string) (size, free uint64, readOnly bool, err error) {
+	return 0, 0, false, errNoVolumes
+}
@@ -0,0 +1,79 @@
+// container_windows.go
+// Volume resolution for profile containers: the final path of the cache
+// folder by volume GUID, the GUID of a mount point, and the size, free
+// space and read-only flag of a volume.
+
+package watchdog
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	volumeNameGUID     = 0x1        // GetFinalPathNameByHandle VOLUME_NAME_GUID
+	fileReadOnlyVolume = 0x00080000 // FILE_READ_ONLY_VOLUME
+)
+
+var (
+	procGetFinalPathNameByHandleW         = modKernel32.NewProc("GetFinalPathNameByHandleW")
+	procGetVolumeNameForVolumeMountPointW = modKernel32.NewProc("GetVolumeNameForVolumeMountPointW")
+	procGetDiskFreeSpaceExW               = modKernel32.NewProc("GetDiskFreeSpaceExW")
+	procGetVolumeInformationW             = modKernel32.NewProc("GetVolumeInformationW")
+)
+
+// resolveVolume follows junctions and mount points below path and returns
+// the volume (\\?\Volume{GUID}\) and the final path on it.
+func resolveVolume(path string) (volume, final string, err error) {
+	p, err := syscall.UTF16PtrFromString(extendedPath(path))
+	if err != nil {
+		return "", "", err
+	}
+	h, err := syscall.CreateFile(p, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return "", "", err
+	}
+	defer syscall.CloseHandle(h)
+	buf := make([]uint16, syscall.MAX_LONG_PATH)
+	n, _, callErr := procGetFinalPathNameByHandleW.Call(uintptr(h), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), volumeNameGUID)
+	if n == 0 || int(n) >= len(buf) {
+		return "", "", callErr
+	}
+	final = syscall.UTF16ToString(buf[:n])
+	// \\?\Volume{GUID}\rest
+	i := strings.Index(final[4:], `\`)
+	if !strings.HasPrefix(final, `\\?\Volume{`) || i < 0 {
+		return "", "", errors.New("unexpected final path " + final)
+	}
+	return final[:4+i+1], final, nil
+}
+
+// volumeGUIDName returns \\?\Volume{GUID}\ for a mount point such as C:\.
+func volumeGUIDName(mountPoint string) (string, error) {
+	p, err := syscall.UTF16PtrFromString(mountPoint)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]uint16, 64)
+	if r, _, callErr := procGetVolumeNameForVolumeMountPointW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf))); r == 0 {
+		return "", callErr
+	}
+	return syscall.UTF16ToString(buf), nil
+}
+
+func volumeStats(volume string) (size, free uint64, readOnly bool, err error) {
+	p, err := syscall.UTF16PtrFromString(volume)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if r, _, callErr := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&size)), 0); r == 0 {
+		return 0, 0, false, callErr
+	}
+	var flags uint32
+	if r, _, callErr := procGetVolumeInformationW.Call(uintptr(unsafe.Pointer(p)), 0, 0, 0, 0, uintptr(unsafe.Pointer(&flags)), 0, 0); r == 0 {
+		return size, free, false, callErr
+	}
+	return size, free, flags&fileReadOnlyVolume != 0, nil
+}
@@ -0,0 +1,346 @@
+// control.go
+// Control pipe: a local endpoint through which the CLI and the tray UI talk
+// to the running daemon — a named pipe on Windows
+// (\\.\pipe\icon-cache-watchdog-<user>), a Unix socket in the data directory
+// elsewhere. The client sends one request and the daemon answers with
+// events, as JSON lines or, once negotiated, length-prefixed frames
+// (protocol.go).
+//
+//   → {"cmd":"progress"}
+//   ← {"type":"progress","phase":"files-deleting","done":3,"total":7,...}
+//
+// "progress" streams repair progress until the client disconnects. A client
+// connecting mid-repair first receives the latest event of that repair.
+// "rollback" restores a pre-repair backup (rollback.go) and streams the
+// progress of that restore until it ends. "history" answers with one event
+// carrying the last 24 hours of cache size and events (history.go),
+// "dashboard" with the sign-in link of the web dashboard (dashboard.go).
+// "logs" sends the latest log lines and, with "follow", streams new ones
+// (logfeed.go). "status", "config" and "repair" answer with one event each:
+// status.json as of now, config show-effective, and the outcome of a repair
+// requested like a remote command. "pause" (with "for" and "reason") and
+// "resume" answer with the pause now in effect, if any (pause.go).
+//
+// The protocol is versioned for integrators. "version" answers with
+// controlAPIVersion, the oldest version still answered, the commands this
+// daemon knows and its capabilities; a request may carry "api", and one
+// asking for a newer version than the connection speaks is refused. Fields
+// are only ever added within a version.
+//
+// Only the daemon's own account, Administrators and SYSTEM (plus the SIDs in
+// control.allow) may open the Windows pipe; the Unix socket is the owner's
+// alone. Requests are recorded in the audit log (audit.go).
+
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// controlAPIVersion changes when a request or event changes incompatibly.
+// 2: length-prefixed frames after a hello (protocol.go).
+const controlAPIVersion = 2
+
+const (
+	requestHello     = "hello"
+	requestVersion   = "version"
+	requestStatus    = "status"
+	requestConfig    = "config"
+	requestRepair    = "repair"
+	requestProgress  = "progress"
+	requestRollback  = "rollback"
+	requestHistory   = "history"
+	requestDashboard = "dashboard"
+	requestLogs      = "logs"
+	requestPause     = "pause"
+	requestResume    = "resume"
+
+	eventHello     = "hello"
+	eventPing      = "ping"
+	eventVersion   = "version"
+	eventStatus    = "status"
+	eventConfig    = "config"
+	eventRepair    = "repair"
+	eventProgress  = "progress"
+	eventHistory   = "history"
+	eventDashboard = "dashboard"
+	eventLog       = "log"
+	eventPause     = "pause"
+	eventError     = "error"
+)
+
+// Repair phases. The script reports the middle ones on stdout (progress.go);
+// the daemon adds the first and the last.
+const (
+	phaseLaunched         = "launched"
+	phaseHandedOff        = "handed-off" // broker or repair task; no progress follows
+	phaseExplorerStopping = "explorer-stopping"
+	phaseFilesDeleting    = "files-deleting"
+	phaseTrayClearing     = "tray-streams-clearing" // deep clean only
+	phaseShellRefreshing  = "shell-refreshing"
+	phaseExplorerStarting = "explorer-starting"
+	phaseVerifying        = "verifying"
+	phaseComplete         = "complete"
+	phaseFailed           = "failed"
+)
+
+// controlCommands lists the requests for the version reply.
+var controlCommands = []string{requestHello, requestVersion, requestStatus, requestConfig, requestRepair, requestProgress,
+	requestRollback, requestHistory, requestDashboard, requestLogs, requestPause, requestResume}
+
+type controlOptions struct {
+	Allow []string `json:"allow"` // SIDs also granted the pipe (Windows)
+	Audit string   `json:"audit"` // "changes", "all" or "off" (audit.go)
+}
+
+func defaultControlOptions() controlOptions {
+	return controlOptions{Allow: []string{}, Audit: auditChanges}
+}
+
+type controlRequest struct {
+	Cmd    string   `json:"cmd"`
+	API    int      `json:"api,omitempty"`    // protocol version the client expects
+	Caps   []string `json:"caps,omitempty"`   // hello: capabilities the client has
+	Backup string   `json:"backup,omitempty"` // rollback; empty selects the newest
+	Lines  int      `json:"lines,omitempty"`  // logs: backlog lines to send first
+	Follow bool     `json:"follow,omitempty"` // logs: keep streaming new lines
+
+	For    duration `json:"for,omitempty"`    // pause: how long
+	Reason string   `json:"reason,omitempty"` // pause: note for the logs
+}
+
+type controlEvent struct {
+	Type     string    `json:"type"`
+	At       time.Time `json:"at"`
+	Phase    string    `json:"phase,omitempty"`
+	Done     int       `json:"done,omitempty"`
+	Total    int       `json:"total,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Session  *uint32   `json:"session,omitempty"`  // multi-session mode
+	Incident string    `json:"incident,omitempty"` // incident.go
+	Error    string    `json:"error,omitempty"`
+
+	History *historyView `json:"history,omitempty"` // history reply
+	URL     string       `json:"url,omitempty"`     // dashboard reply
+	Log     *logEntry    `json:"log,omitempty"`     // log stream
+
+	API      int              `json:"api,omitempty"`      // version and hello replies
+	MinAPI   int              `json:"minApi,omitempty"`   // version reply
+	Commands []string         `json:"commands,omitempty"` // version reply
+	Caps     []string         `json:"caps,omitempty"`     // version reply; hello: those agreed
+	Status   *statusReport    `json:"status,omitempty"`   // status reply
+	Config   *effectiveConfig `json:"config,omitempty"`   // config reply
+	Repair   *repairOutcome   `json:"repair,omitempty"`   // repair reply
+	Pause    *pauseState      `json:"pause,omitempty"`    // pause reply; nil after resume
+}
+
+// finished reports whether ev ends a repair.
+func (ev controlEvent) finished() bool {
+	switch ev.Phase {
+	case phaseComplete, phaseFailed, phaseHandedOff:
+		return true
+	}
+	return false
+}
+
+// controlListener accepts control-pipe connections (control_windows.go,
+// control_other.go).
+type controlListener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+}
+
+// progressHub fans repair progress out to subscribed clients. Slow clients
+// lose events rather than stall the repair.
+type progressHub struct {
+	mu     sync.Mutex
+	subs   map[chan controlEvent]struct{}
+	active map[string]controlEvent // latest event per running repair
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{subs: map[chan controlEvent]struct{}{}, active: map[string]controlEvent{}}
+}
+
+// publish is a no-op on a nil hub (simulation, tests).
+func (h *progressHub) publish(ev controlEvent) {
+	if h == nil {
+		return
+	}
+	ev.Type = eventProgress
+	key := ""
+	if ev.Session != nil {
+		key = fmt.Sprint(*ev.Session)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ev.finished() {
+		delete(h.active, key)
+	} else {
+		h.active[key] = ev
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (h *progressHub) subscribe() chan controlEvent {
+	ch := make(chan controlEvent, 64)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ev := range h.active {
+		ch <- ev
+	}
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+func (h *progressHub) unsubscribe(ch chan controlEvent) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// serveControl runs the control pipe until the listener fails.
+func (d *daemon) serveControl() {
+	l, err := listenControl(d.controlAddr(), d.cfg.Control.Allow)
+	if err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("Control pipe unavailable: %v", err))
+		return
+	}
+	defer l.Close()
+	d.ipcLog_("INFO", fmt.Sprintf("Control pipe listening on %s", d.controlAddr()))
+	if d.done != nil {
+		go func() {
+			<-d.done
+			l.Close()
+		}()
+	}
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			d.ipcLog_("ERROR", fmt.Sprintf("Control pipe stopped: %v", err))
+			return
+		}
+		go d.handleControl(conn)
+	}
+}
+
+func (d *daemon) handleControl(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	c, req, err := d.readControlRequest(conn)
+	if c == nil {
+		return
+	}
+	// The client can be named once its request has been read.
+	peer := controlPeerOf(conn)
+	audited := false
+	send := func(ev controlEvent) error {
+		if !audited {
+			audited = true
+			d.auditControl(peer, req.Cmd, ev)
+		}
+		return c.write(ev)
+	}
+	if err != nil {
+		send(controlEvent{Type: eventError, At: d.clock.Now(), Error: err.Error()})
+		return
+	}
+	if req.API > c.api {
+		send(controlEvent{Type: eventError, At: d.clock.Now(), Error: fmt.Sprintf("API version %d not supported on this connection (it speaks %d; this daemon up to %d)", req.API, c.api, controlAPIVersion)})
+		return
+	}
+	switch req.Cmd {
+	case requestHello:
+		send(controlEvent{Type: eventError, At: d.clock.Now(), Error: "hello is only accepted as the first request"})
+	case requestVersion:
+		send(controlEvent{Type: eventVersion, At: d.clock.Now(), API: controlAPIVersion, MinAPI: minControlAPIVersion, Commands: controlCommands, Caps: controlCaps})
+	case requestStatus:
+		st := d.statusSnapshot()
+		send(controlEvent{Type: eventStatus, At: d.clock.Now(), Status: &st})
+	case requestConfig:
+		cfg := d.effectiveConfig()
+		send(controlEvent{Type: eventConfig, At: d.clock.Now(), Config: &cfg})
+	case requestRepair:
+		if d.cfg.MultiSession.Enabled {
+			send(controlEvent{Type: eventError, At: d.clock.Now(), Error: "repair requests are not available in multi-session mode"})
+			return
+		}
+		out := d.requestRepair("control pipe")
+		send(controlEvent{Type: eventRepair, At: d.clock.Now(), Repair: &out})
+	case requestProgress:
+		// A client that hung up is noticed at the next write. (Reading to
+		// detect it sooner would block writes on a synchronous pipe handle.)
+		d.auditControl(peer, req.Cmd, controlEvent{Type: eventProgress})
+		ch := d.progress.subscribe()
+		defer d.progress.unsubscribe(ch)
+		ping, stop := d.pings(c)
+		defer stop()
+		for {
+			select {
+			case ev := <-ch:
+				err = c.write(ev)
+			case <-ping:
+				err = c.write(controlEvent{Type: eventPing, At: d.clock.Now()})
+			}
+			if err != nil {
+				return
+			}
+		}
+	case requestRollback:
+		d.serveRollback(send, req.Backup)
+	case requestPause, requestResume:
+		send(d.servePause(req))
+	case requestLogs:
+		d.auditControl(peer, req.Cmd, controlEvent{Type: eventLog})
+		if !req.Follow {
+			for _, e := range d.logs.backlog(req.Lines) {
+				if c.write(controlEvent{Type: eventLog, At: e.At, Log: &e}) != nil {
+					return
+				}
+			}
+			return
+		}
+		ch := d.logs.subscribe(req.Lines)
+		defer d.logs.unsubscribe(ch)
+		ping, stop := d.pings(c)
+		defer stop()
+		for {
+			select {
+			case e := <-ch:
+				err = c.write(controlEvent{Type: eventLog, At: e.At, Log: &e})
+			case <-ping:
+				err = c.write(controlEvent{Type: eventPing, At: d.clock.Now()})
+			}
+			if err != nil {
+				return
+			}
+		}
+	case requestDashboard:
+		if d.dashboard == nil {
+			send(controlEvent{Type: eventError, At: d.clock.Now(), Error: "the dashboard is off (ui.enabled, or start the daemon with --ui)"})
+			return
+		}
+		send(controlEvent{Type: eventDashboard, At: d.clock.Now(), URL: d.dashboard.url()})
+	case requestHistory:
+		if d.history == nil {
+			send(controlEvent{Type: eventError, At: d.clock.Now(), Error: "no history is kept in multi-session mode"})
+			return
+		}
+		view := d.history.snapshot(d.clock.Now())
+		send(controlEvent{Type: eventHistory, At: d.clock.Now(), History: &view})
+	default:
+		send(controlEvent{Type: eventError, At: d.clock.Now(), Error: fmt.Sprintf("unknown command %q", req.Cmd)})
+	}
+}
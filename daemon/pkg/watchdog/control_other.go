@@ -0,0 +1,48 @@
+//go:build !windows
+
+// control_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+// The control pipe is a Unix socket in the data directory that only its
+// owner may connect to; control.allow does not apply.
+
+package watchdog
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+func (d *daemon) controlAddr() string { return filepath.Join(d.dataDir, "control.sock") }
+
+type socketListener struct{ net.Listener }
+
+func listenControl(addr string, allow []string) (controlListener, error) {
+	if err := os.MkdirAll(filepath.Dir(addr), 0755); err != nil {
+		return nil, err
+	}
+	os.Remove(addr) // left behind by a previous run
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return socketListener{l}, nil
+}
+
+// controlPeerOf names the client: with the socket mode 0600, the owner.
+func controlPeerOf(conn io.ReadWriteCloser) controlPeer {
+	if u, err := user.Current(); err == nil {
+		return controlPeer{User: u.Username}
+	}
+	return controlPeer{}
+}
+
+func (l socketListener) Accept() (io.ReadWriteCloser, error) { return l.Listener.Accept() }
+
+func dialControl(addr string) (io.ReadWriteCloser, error) { return net.Dial("unix", addr) }
@@ -0,0 +1,197 @@
+// control_windows.go
+// Control pipe as a Windows named pipe, one per user. Instances are created
+// one at a time; the first is created with FILE_FLAG_FIRST_PIPE_INSTANCE so
+// another process cannot have squatted on the name. The pipe's DACL grants
+// the daemon's account, Administrators, SYSTEM and the SIDs in
+// control.allow; the client is named by impersonating it.
+
+package watchdog
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	pipeAccessDuplex        = 0x00000003
+	fileFlagFirstPipeInst   = 0x00080000
+	pipeTypeByte            = 0x00000000
+	pipeWait                = 0x00000000
+	pipeRejectRemoteClients = 0x00000008
+	pipeUnlimitedInstances  = 255
+	errorPipeConnected      = syscall.Errno(535)
+	controlPipeBufferSize   = 4096
+	sddlRevision1           = 1
+	tokenQuery              = 0x0008
+	currentThread           = ^uintptr(1) // GetCurrentThread pseudo handle
+)
+
+var (
+	procCreateNamedPipeW                                     = modKernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe                                     = modKernel32.NewProc("ConnectNamedPipe")
+	procGetNamedPipeClientProcessId                          = modKernel32.NewProc("GetNamedPipeClientProcessId")
+	procConvertStringSecurityDescriptorToSecurityDescriptorW = modAdvapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+	procImpersonateNamedPipeClient                           = modAdvapi32.NewProc("ImpersonateNamedPipeClient")
+	procOpenThreadToken                                      = modAdvapi32.NewProc("OpenThreadToken")
+	procRevertToSelf                                         = modAdvapi32.NewProc("RevertToSelf")
+)
+
+func (d *daemon) controlAddr() string {
+	user := strings.NewReplacer(`\`, "-", "/", "-").Replace(os.Getenv("USERNAME"))
+	return `\\.\pipe\icon-cache-watchdog-` + user
+}
+
+type pipeListener struct {
+	name   string
+	first  bool
+	sa     *syscall.SecurityAttributes
+	closed atomic.Bool
+}
+
+// pipeSecurity builds the pipe's security descriptor: full access for the
+// daemon's account, Administrators and SYSTEM, read and write for allow.
+func pipeSecurity(allow []string) (*syscall.SecurityAttributes, error) {
+	t, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	u, err := t.GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	owner, err := u.User.Sid.String()
+	if err != nil {
+		return nil, err
+	}
+	sddl := fmt.Sprintf("D:P(A;;GA;;;%s)(A;;GA;;;BA)(A;;GA;;;SY)", owner)
+	for _, sid := range allow {
+		sddl += fmt.Sprintf("(A;;GRGW;;;%s)", sid)
+	}
+	p, err := syscall.UTF16PtrFromString(sddl)
+	if err != nil {
+		return nil, err
+	}
+	var sd uintptr
+	if r, _, err := procConvertStringSecurityDescriptorToSecurityDescriptorW.Call(
+		uintptr(unsafe.Pointer(p)), sddlRevision1, uintptr(unsafe.Pointer(&sd)), 0); r == 0 {
+		return nil, fmt.Errorf("pipe security descriptor: %w", err)
+	}
+	sa := &syscall.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+	return sa, nil
+}
+
+func listenControl(addr string, allow []string) (controlListener, error) {
+	sa, err := pipeSecurity(allow)
+	if err != nil {
+		return nil, err
+	}
+	l := &pipeListener{name: addr, first: true, sa: sa}
+	// Create the first instance now so a name clash fails at startup.
+	h, err := l.create()
+	if err != nil {
+		procLocalFree.Call(sa.SecurityDescriptor)
+		return nil, err
+	}
+	syscall.CloseHandle(h)
+	l.first = false
+	return l, nil
+}
+
+func (l *pipeListener) create() (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(l.name)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	mode := uintptr(pipeAccessDuplex)
+	if l.first {
+		mode |= fileFlagFirstPipeInst
+	}
+	h, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name)), mode,
+		pipeTypeByte|pipeWait|pipeRejectRemoteClients,
+		pipeUnlimitedInstances, controlPipeBufferSize, controlPipeBufferSize, 0, uintptr(unsafe.Pointer(l.sa)))
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+	return syscall.Handle(h), nil
+}
+
+// Accept creates a pipe instance and blocks until a client connects to it.
+func (l *pipeListener) Accept() (io.ReadWriteCloser, error) {
+	h, err := l.create()
+	if err != nil {
+		return nil, err
+	}
+	if r, _, err := procConnectNamedPipe.Call(uintptr(h), 0); r == 0 && err != errorPipeConnected {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+	if l.closed.Load() {
+		syscall.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	return os.NewFile(uintptr(h), l.name), nil
+}
+
+// Close stops the listener. A blocked ConnectNamedPipe cannot be cancelled,
+// so Close connects to the waiting instance once to release it.
+func (l *pipeListener) Close() error {
+	if l.closed.Swap(true) {
+		return nil
+	}
+	if f, err := dialControl(l.name); err == nil {
+		f.Close()
+	}
+	return nil
+}
+
+// controlPeerOf names the client of an accepted pipe instance. Windows lets
+// the server impersonate a client only after reading from the pipe.
+func controlPeerOf(conn io.ReadWriteCloser) controlPeer {
+	var p controlPeer
+	f, ok := conn.(*os.File)
+	if !ok {
+		return p
+	}
+	h := f.Fd()
+	var pid uint32
+	if r, _, _ := procGetNamedPipeClientProcessId.Call(h, uintptr(unsafe.Pointer(&pid))); r != 0 {
+		p.PID = int(pid)
+	}
+	// Impersonation applies to the OS thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if r, _, _ := procImpersonateNamedPipeClient.Call(h); r == 0 {
+		return p
+	}
+	var t syscall.Token
+	r, _, _ := procOpenThreadToken.Call(currentThread, tokenQuery, 1, uintptr(unsafe.Pointer(&t)))
+	procRevertToSelf.Call()
+	if r == 0 {
+		return p
+	}
+	defer t.Close()
+	u, err := t.GetTokenUser()
+	if err != nil {
+		return p
+	}
+	if account, domain, _, err := u.User.Sid.LookupAccount(""); err == nil {
+		p.User = domain + `\` + account
+	} else {
+		p.User, _ = u.User.Sid.String()
+	}
+	return p
+}
+
+func dialControl(addr string) (io.ReadWriteCloser, error) {
+	return os.OpenFile(addr, os.O_RDWR, 0)
+}
@@ -0,0 +1,1133 @@
+// daemon.go
+// The daemon core: configuration and paths, logging, Layers B–D and the
+// repair trigger. Main is the entry point of icon-cache-watchdog.exe;
+// programs that embed the daemon use watchdog.go instead.
+
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// CONFIGURATION
+// Defaults for the thresholds.* config keys (config.go).
+// ---------------------------------------------------------------------------
+
+const (
+	defaultPollEvery        duration = duration(30 * time.Second)
+	defaultSizeLimit        byteSize = 32 * mib                   // Repair if cache exceeds this
+	defaultCooldown         duration = duration(30 * time.Minute) // Min time between repairs
+	defaultHealthCheckEvery duration = duration(45 * time.Minute)
+	defaultHeartbeatEvery   duration = duration(6 * time.Hour)
+	defaultStillExceeded    duration = duration(10 * time.Minute) // Summary of polls over the limit after a trigger
+	defaultSlowRepair       duration = duration(time.Minute)
+	defaultMinFreeSpace     byteSize = 1 * gib                       // Skip repairs with less free disk space
+	defaultRecentWrite      duration = duration(15 * time.Minute)    // H2: suspicious external write window
+	defaultMinHealthyFiles           = 5                             // H3: minimum expected cache files
+	defaultStaleAge         duration = duration(30 * 24 * time.Hour) // H4: preemptive refresh threshold
+	defaultIndexMinSize     byteSize = 100                           // H1: index file minimum healthy size
+)
+
+// ---------------------------------------------------------------------------
+// STATE
+// ---------------------------------------------------------------------------
+
+type daemon struct {
+	cfg          config
+	done         <-chan struct{} // closed to stop an embedded daemon (watchdog.go)
+	configFile   string
+	cfgDigest    string
+	cfgSources   configSources
+	cfgFileState string  // for show-effective
+	cfgWarnings  []error // overrides that were skipped
+	rootDir      string
+	dataDir      string
+	cacheDir     string
+	cacheLink    string // cacheDir as derived, when links lead elsewhere (cachepath.go)
+	repairScript string
+	stateFile    string
+	logDir       string
+	watchLog     string
+	healthLog    string
+	unifiedLog   string       // logging.mode "unified" (logging.go)
+	progress     *progressHub // repair progress for control-pipe clients
+	logs         *logFeed     // live log stream (logfeed.go)
+	healthNow    chan string  // requested health checks (remote.go)
+	logPrefix    string
+	metrics      *metricsRegistry
+	mu           sync.Mutex
+	lastRepair   time.Time
+	deepCleaned  time.Time // last scheduled deep clean (deepclean.go)
+	deepTried    time.Time
+	summarized   time.Time       // last weekly summary slot delivered (weeklysummary.go)
+	summaryHeld  string          // why the due summary is held, once logged
+	inFlight     *repairInFlight // direct repair running (recovery.go)
+	taskRequest  *repairRequest  // written once for the repair task (trampoline.go)
+	started      time.Time
+	caps         capabilities
+	profile      profileInfo
+	clock        Clock
+
+	// Health check outcome, reported per session in multi-session mode.
+	lastHealthCheck time.Time
+	lastHealthy     bool
+	lastScore       int
+	lastResult      *healthResult              // latest periodic check, for Health() (watchdog.go)
+	heuristicStats  map[string]heuristicRecord // last pass and fail per heuristic (heuristicstats.go)
+	overlays        *overlayStatus             // H5, single-user mode (overlay.go)
+	shortcuts       *shortcutStatus            // broken shortcut scan (brokenlinks.go)
+	history         *historyStore              // 24-hour size and event history (history.go)
+	dashboard       *dashboard                 // web dashboard, when ui.enabled (dashboard.go)
+	fleetAddr       string                     // fleet endpoint, when fleet.enabled (fleet.go)
+	grpcAddr        string                     // gRPC API, when grpc.enabled (grpcapi.go)
+	plugins         []plugin                   // described on first use (plugins.go)
+	pluginsOnce     sync.Once
+	samples         []sizeSample
+	lastFiles       map[string]fileSnap // previous poll (delta.go)
+	usn             *usnCursor          // journal read position (usn.go)
+	usnSaved        time.Time
+	network         networkState // shortcut shares and their reachability (network.go)
+	deferredReason  string
+	deferredPrio    repairPriority
+	sizeRepair      sizeRepairState // shrink verification; persists in state.json (sizeverify.go)
+
+	// Size trigger deduplication (checkSize): while the cache stays over
+	// the limit during the cooldown that followed a trigger, polls are
+	// folded into a "still exceeds" line every thresholds.stillExceededEvery.
+	sizeOver      time.Time // first poll over the limit; zero when armed
+	sizeNoted     time.Time // last TRIGGER or "still exceeds" line
+	sizeOverPolls int       // polls folded since sizeNoted
+	sizeOverPeak  float64
+
+	// Thumbnail target (targets.go).
+	thumbnails    targetThresholds // resolved for the monitored account; no sizeLimit: not watched
+	thumbSizeMB   float64
+	thumbOver     time.Time // first poll over the limit; zero while under it
+	thumbTried    time.Time // last thumbnail trigger
+	thumbRepaired time.Time // last thumbnail repair started; persists in state.json
+
+	reportOnlyLogged time.Time // last repair skipped in report-only mode (vdi.go)
+
+	// Shell extension churn (shellext.go); corruptions persist in state.json.
+	corruptions   []time.Time
+	shellSuspects []shellSuspect
+	shellMods     []shellMod      // shell replacements (shellmods.go)
+	strays        *strayStatus    // non-cache files in the cache directory (strayfiles.go)
+	softRepair    softRepairState // soft repair of the current health episode (softrepair.go)
+	tierSuspects  map[string]bool // tiers corrupt at the last health check (tiers.go)
+	osBuild       string          // OS build and revision; persists in state.json (updatebaseline.go)
+	osUpdated     time.Time       // when a change of osBuild was last detected
+	breaker       *breakerState   // tripped circuit breaker; persists in state.json (breaker.go)
+	breakerNoted  time.Time       // last "still open" line
+	triggers      []time.Time     // repair requests within the breaker window
+	failures      int             // failed repairs in a row; persists in state.json
+	shellReported string
+
+	// Antivirus interference (av.go); sightings persist in state.json.
+	avSightings []avSighting
+	avAdvisory  *avAdvisory
+
+	pause *pauseState // persists in state.json (pause.go)
+
+	diskSpace *diskSpaceStatus // latest free space reading (diskspace.go)
+	cacheGone time.Time        // cache directory missing since (cachedir.go)
+
+	// Shell rescue (shellrescue.go).
+	shellMissing time.Time // first poll without Explorer
+	shellRescues int       // shells started since Explorer last ran
+
+	// Shell stability, H8 (shellstability.go).
+	shellPID      uint32          // taskbar owner at the last poll that found one
+	shellSeen     time.Time       // when shellPID was last seen
+	shellRestarts []time.Time     // shell launches not started by a repair
+	werSeen       map[string]bool // explorer.exe WER reports in the window
+
+	// Daily health report (reports.go).
+	reportMu      sync.Mutex
+	report        *dailyReport
+	reportWritten time.Time
+	reportFailing bool
+
+	// Report outbox (reportoutbox.go), under reportMu.
+	reportDeliverAt     time.Time // next delivery to reports.dir; zero: none due
+	reportAttempts      int       // failed deliveries in a row
+	reportOutboxChecked bool      // leftovers of the previous run scheduled
+	wmiFailing          bool      // wmi.go
+
+	eventLogFailing atomic.Bool // set while events cannot be written (eventlog.go)
+	warmUpBusy      atomic.Bool // a cache warm-up runs (warmup.go)
+
+	// Heartbeat sinks (heartbeat.go).
+	webhookBusy          atomic.Bool // a webhook ping is in flight
+	webhookFailing       atomic.Bool
+	heartbeatFileFailing atomic.Bool
+
+	incidents *incidentTracker // single-user daemon only (incident.go)
+	otel      *otelExporter    // otel.go; shared with the session monitors
+
+	// Multi-session mode only (multisession.go).
+	session         *sessionInfo
+	throttle        chan struct{}
+	lastScan        time.Duration // last size poll of this session
+	sessionStatuses []sessionStatus
+	optedOut        map[uint32]string // sessions not attached, and why (optout.go)
+
+	// Trace replay only (simulate.go).
+	sim *simulation
+}
+
+// ---------------------------------------------------------------------------
+// LOGGING
+// ---------------------------------------------------------------------------
+
+func (d *daemon) log(subsystem, level, msg string) { d.logCoded(subsystem, level, "", msg) }
+
+// logCoded writes a log line, led by its message code (catalog.go) if any.
+func (d *daemon) logCoded(subsystem, level, code, msg string) {
+	if d.sim != nil {
+		d.sim.log(d.clock.Now().Format("2006-01-02 15:04:05"), level, code, d.logPrefix+msg)
+		return
+	}
+	now := d.clock.Now()
+	d.logs.publish(logEntry{At: now, Subsystem: subsystem, Level: level, Code: code, Message: d.logPrefix + msg})
+	if code != "" {
+		msg = code + " " + msg
+	}
+	file, tag := d.watchLog, ""
+	switch {
+	case d.cfg.Logging.Mode == logModeUnified:
+		file, tag = d.unifiedLog, "subsystem="+subsystem+" "
+	case subsystem == subsystemHealth:
+		file = d.healthLog
+	}
+	os.MkdirAll(d.logDir, 0755)
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	ts := now.Format("2006-01-02 15:04:05")
+	fmt.Fprintf(f, "[%s][%s] %s%s%s\n", ts, level, tag, d.logPrefix, msg)
+}
+
+func (d *daemon) watchLog_(level, msg string)  { d.log(subsystemWatchdog, level, msg) }
+func (d *daemon) healthLog_(level, msg string) { d.log(subsystemHealth, level, msg) }
+func (d *daemon) repairLog_(level, msg string) { d.log(subsystemRepair, level, msg) }
+func (d *daemon) ipcLog_(level, msg string)    { d.log(subsystemIPC, level, msg) }
+
+// ---------------------------------------------------------------------------
+// CACHE HELPERS
+// ---------------------------------------------------------------------------
+
+func (d *daemon) getCacheFiles() []os.FileInfo {
+	entries, err := os.ReadDir(d.cacheDir)
+	if err != nil {
+		return nil
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if !e.IsDir() && d.isIconCacheFile(e.Name()) {
+			if info, err := e.Info(); err == nil {
+				files = append(files, info)
+			}
+		}
+	}
+	return files
+}
+
+func (d *daemon) getCacheSizeMB() float64 {
+	files := d.getCacheFiles()
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+	return float64(total) / (1024 * 1024)
+}
+
+// ---------------------------------------------------------------------------
+// REPAIR
+// ---------------------------------------------------------------------------
+
+// cooldownLeft is the time until thresholds.cooldown allows the next
+// repair, rounded up to the second; 0 when it does. Caller holds d.mu.
+func (d *daemon) cooldownLeft() duration {
+	left := d.cfg.Thresholds.Cooldown.D() - d.clock.Since(d.lastRepair)
+	if left <= 0 {
+		return 0
+	}
+	return duration((left + time.Second - 1).Truncate(time.Second))
+}
+
+func (d *daemon) triggerRepair(reason string, prio repairPriority) {
+	d.tryRepair(reason, prio, false)
+}
+
+// tryRepair runs a repair, or a deep clean, unless the cooldown, a
+// pause or a hold stands in the way, and reports whether it was started.
+// extra is passed on to a script run directly.
+func (d *daemon) tryRepair(reason string, prio repairPriority, deepClean bool, extra ...string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.incidents.request(reason)
+
+	if d.breakerTrigger(reason) {
+		d.incidents.settle(incidentRepair, stepSkipped, "circuit breaker open", false)
+		return false
+	}
+
+	if left := d.cooldownLeft(); left > 0 {
+		d.logMsg(msgCooldownSkip, left, reason)
+		d.metrics.inc(mRepairsSkipped)
+		d.incidents.settle(incidentRepair, stepSkipped, fmt.Sprintf("cooldown active (%s remaining)", left), false)
+		return false
+	}
+
+	if why := d.reportOnlyReason(); why != "" {
+		d.skipReportOnly(reason, why)
+		d.metrics.inc(mRepairsSkipped)
+		d.incidents.settle(incidentRepair, stepSkipped, "report-only: "+why, false)
+		return false
+	}
+
+	if why := d.pausedReason(); why != "" {
+		d.logMsg(msgPausedSkip, why, reason)
+		d.metrics.inc(mRepairsSkipped)
+		d.incidents.settle(incidentRepair, stepSkipped, "watchdog "+why, false)
+		return false
+	}
+
+	if why := d.diskSpaceReason(); why != "" {
+		d.logMsg(msgLowDiskSkip, why, reason)
+		d.metrics.inc(mRepairsSkipped)
+		d.incidents.settle(incidentRepair, stepSkipped, "low disk space: "+why, false)
+		return false
+	}
+
+	if why := d.cacheGoneReason(); why != "" {
+		d.logMsg(msgCacheGoneSkip, why, reason)
+		d.metrics.inc(mRepairsSkipped)
+		d.incidents.settle(incidentRepair, stepSkipped, "cache directory "+why, false)
+		return false
+	}
+
+	if why := d.holdReason(prio); why != "" {
+		d.deferRepair(reason, prio, why)
+		d.metrics.inc(mRepairsSkipped)
+		d.incidents.step(stepDeferred, "held during "+why)
+		return false
+	}
+	d.deferredReason = ""
+
+	d.logMsg(msgRepairTriggered, reason, d.incidentSuffix())
+
+	if d.sim != nil {
+		d.sim.repairs = append(d.sim.repairs, simRepair{At: d.clock.Now(), Reason: reason})
+		d.lastRepair = d.clock.Now()
+		return true
+	}
+
+	if !d.launchRepair(reason, deepClean, extra...) {
+		d.incidents.settle(incidentRepair, stepSkipped, "repair could not be started", false)
+		return false
+	}
+	d.metrics.inc(mRepairsStarted)
+	d.updateReport(func(r *dailyReport) { r.Repairs++ })
+	return true
+}
+
+// launchRepair starts the repair script, directly or through the broker or
+// repair task, and reports whether it was started. extra only reaches a
+// script run directly; -IncludeThumbcache is passed on to the broker and
+// the repair task as well. Caller holds d.mu.
+func (d *daemon) launchRepair(reason string, deepClean bool, extra ...string) bool {
+	if err := d.validateRepairScript(); err != nil {
+		d.logMsg(msgRepairRefused, err)
+		return false
+	}
+
+	// In multi-session mode the per-session monitors run as LocalSystem and
+	// repair directly; routing only applies to the single-user daemon.
+	if d.session == nil {
+		// Explorer may have been restarted into another session since startup.
+		d.caps = d.probeCapabilities()
+		thumbcache := slices.Contains(extra, "-IncludeThumbcache")
+		switch d.caps.RepairRoute {
+		case routeBroker:
+			d.ipcLog_("WARN", fmt.Sprintf("Cannot delete cache files unelevated (%s). Using elevated broker %s.", d.caps, elevatedRepairTask))
+			if err := d.requestElevatedRepair(reason, deepClean, thumbcache); err != nil {
+				d.ipcLog_("ERROR", fmt.Sprintf("Failed to start elevated repair task: %v", err))
+				return false
+			}
+			d.lastRepair = d.clock.Now()
+			d.saveState()
+			d.ipcLog_("INFO", "Elevated repair task started successfully.")
+			d.progress.publish(d.repairEvent(controlEvent{Phase: phaseHandedOff}, reason))
+			d.incidents.settle(incidentRepair, phaseHandedOff, "repair handed to "+elevatedRepairTask, true)
+			return true
+
+		case routeTrampoline:
+			if deepClean {
+				// The event-triggered task runs a plain repair.
+				d.ipcLog_("WARN", fmt.Sprintf("Deep clean needs a direct or brokered repair (%s). Skipping.", d.caps))
+				return false
+			}
+			d.ipcLog_("WARN", fmt.Sprintf("Insufficient rights for a direct repair (%s). Routing via %s.", d.caps, eventRepairTask))
+			// The task's `repair` command picks the reason up from state.json.
+			d.lastRepair = d.clock.Now()
+			d.taskRequest = &repairRequest{Reason: reason, Requested: d.clock.Now(), Thumbcache: thumbcache}
+			d.saveState()
+			d.taskRequest = nil
+			if err := runScheduledTask(eventRepairTask); err != nil {
+				d.ipcLog_("ERROR", fmt.Sprintf("Failed to start repair task: %v", err))
+				return false
+			}
+			d.ipcLog_("INFO", "Repair task started successfully.")
+			d.progress.publish(d.repairEvent(controlEvent{Phase: phaseHandedOff}, reason))
+			d.incidents.settle(incidentRepair, phaseHandedOff, "repair handed to "+eventRepairTask, true)
+			return true
+		}
+	}
+	return d.startRepairScript(reason, deepClean, extra...)
+}
+
+// startRepairScript runs the repair script from this process. The caller
+// has decided a repair is needed, so the script's own size check is skipped
+// (-Force). extra is appended to the script parameters. Caller holds d.mu.
+func (d *daemon) startRepairScript(reason string, deepClean bool, extra ...string) bool {
+	// Host-wide cap on simultaneous repairs (multi-session mode). The slot is
+	// held until the repair script exits.
+	if d.throttle != nil {
+		select {
+		case d.throttle <- struct{}{}:
+		default:
+			d.repairLog_("WARN", fmt.Sprintf("Host-wide repair limit reached (%d running). Deferring repair: %s", cap(d.throttle), reason))
+			return false
+		}
+	}
+
+	// Launch repair script silently via PowerShell
+	// pwsh.exe is invisible here because WE are the GUI-subsystem process.
+	// Child processes inherit our windowless context.
+	params := []string{"-DataDir", d.dataDir, "-Reason", reason, "-Force"}
+	if deepClean {
+		params = append(params, "-DeepClean")
+	}
+	if d.session != nil {
+		params = append(params, "-CachePath", d.cacheDir, "-SessionId", fmt.Sprint(d.session.ID))
+	}
+	if exe, err := os.Executable(); err == nil {
+		// Explorer is restarted through `start-shell` (userprocess.go).
+		params = append(params, "-ShellLauncher", exe)
+	}
+	params = append(params, d.cachePatternParams()...)
+	params = append(params, d.shellModParams()...)
+	params = append(params, d.backupParams()...)
+	params = append(params, d.explorerStopParams()...)
+	params = append(params, extra...)
+	cmd := d.powerShellCommand(d.repairScript, params...)
+	release := func() {
+		if d.throttle != nil {
+			<-d.throttle
+		}
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		d.logMsg(msgLaunchFailed, err, reason)
+		release()
+		d.breakerRepairDone(false)
+		return false
+	}
+	d.progress.publish(d.repairEvent(controlEvent{Phase: phaseLaunched}, reason))
+	d.incidents.step(phaseLaunched, "repair script started")
+	inFlight := &repairInFlight{Reason: reason, DeepClean: deepClean, Started: d.clock.Now(), PID: cmd.Process.Pid}
+	go d.followRepair(cmd, stdout, reason, func() {
+		release()
+		d.mu.Lock()
+		if d.inFlight == inFlight {
+			d.inFlight = nil
+			d.saveState()
+		}
+		d.mu.Unlock()
+	})
+
+	d.lastRepair = d.clock.Now()
+	d.inFlight = inFlight
+	d.saveState()
+	d.logMsg(msgRepairLaunched, reason)
+	return true
+}
+
+// ---------------------------------------------------------------------------
+// LAYER B: FileSystem Polling
+// Go's fsnotify would be ideal but adds a dependency.
+// We use a lightweight 30-second poll — still far more responsive than
+// the old 5-minute Wait-Event loop, and zero external dependencies.
+// Each poll re-lists the directory, so a repair deleting and recreating it
+// needs no handle recovery (see docs/architecture.md, Layer B).
+// ---------------------------------------------------------------------------
+
+func (d *daemon) runWatchdog() {
+	d.watchLog_("INFO", "=== icon-cache-watchdog started ===")
+	d.watchLog_("INFO", fmt.Sprintf("Watching: %s", d.cacheDir))
+	t := d.cfg.Thresholds
+	d.watchLog_("INFO", fmt.Sprintf("Threshold: %s | Cooldown: %s", t.SizeLimit, t.Cooldown))
+	d.watchLog_("INFO", fmt.Sprintf("Repair script: %s", d.repairScript))
+	mechanism := fmt.Sprintf("polling every %s (pure Go, no dependencies)", t.PollEvery)
+	if d.usn != nil {
+		mechanism = fmt.Sprintf("USN journal on %s, checked every %s", d.usn.Volume, t.PollEvery)
+	}
+	d.watchLog_("INFO", "Mechanism: "+mechanism)
+
+	sizeMB := d.getCacheSizeMB()
+	d.watchLog_("INFO", fmt.Sprintf("Cache size at startup: %.2f MB", sizeMB))
+	d.drainQueuedTriggers()
+	d.writeStatus()
+	d.sendHeartbeat(fmt.Sprintf("Watchdog started. Cache: %.2f MB (threshold: %s).", sizeMB, t.SizeLimit))
+
+	ticker := d.clock.NewTicker(t.PollEvery.D())
+	defer ticker.Stop()
+
+	heartbeat := d.clock.NewTicker(t.HeartbeatEvery.D())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			d.checkPause()
+			d.drainTriggers()
+			d.checkSize()
+			d.checkDeepClean()
+			d.checkWeeklySummary()
+			d.checkShell()
+			d.trackShell()
+			d.writeStatus()
+
+		case <-heartbeat.C():
+			sizeMB := d.getCacheSizeMB()
+			d.heartbeat(fmt.Sprintf("Watchdog alive. Cache: %.2f MB (threshold: %s). Self: %s. Metrics: %s", sizeMB, t.SizeLimit, d.selfUsage(), d.metrics.summary()))
+
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *daemon) checkSize() {
+	if !d.cacheDirPresent() {
+		return
+	}
+	d.replayDeferredRepair()
+
+	start := time.Now()
+	sizeMB, files := d.pollCache()
+	d.metrics.observe(mPollSeconds, time.Since(start).Seconds())
+	d.metrics.inc(mPolls)
+	if d.session == nil {
+		d.metrics.set(mCacheBytes, sizeMB*float64(mib))
+	}
+	d.incidents.expire()
+	d.recordSize(sizeMB, files)
+	d.historySize(sizeMB)
+	d.updateReport(func(r *dailyReport) { r.addSize(sizeMB) })
+	d.verifySizeRepair(sizeMB)
+	d.checkThumbnails()
+	limit := d.cfg.Thresholds.SizeLimit
+	if sizeMB <= limit.MB() {
+		if !d.sizeOver.IsZero() {
+			d.logMsg(msgSizeBackUnder, limit, sizeMB, duration(d.clock.Since(d.sizeOver).Truncate(time.Second)))
+			d.sizeOver = time.Time{}
+		}
+		d.mu.Lock()
+		verifying := !d.sizeRepair.Pending.IsZero()
+		d.mu.Unlock()
+		if !verifying {
+			d.incidents.settle(incidentSize, stepCleared, fmt.Sprintf("cache is %.2f MB, under the %s threshold", sizeMB, limit), true)
+		}
+		return
+	}
+	if d.sizeTriggerSuppressed(sizeMB) {
+		return
+	}
+	growing := ""
+	if top := d.topGrowth(); top != "" {
+		growing = " Growing: " + top + "."
+	}
+	d.incidents.trigger(incidentSize, fmt.Sprintf("Cache is %.2f MB > %s threshold.%s", sizeMB, limit, growing))
+	d.logMsg(msgSizeTrigger, sizeMB, limit, growing)
+	d.metrics.inc(mSizeTriggers)
+	d.updateReport(func(r *dailyReport) { r.SizeTriggers++ })
+	d.triggerSizeRepair(fmt.Sprintf("size %.2f MB exceeds %s limit", sizeMB, limit), sizeMB)
+}
+
+// sizeTriggerSuppressed folds a poll over the limit into the previous
+// trigger while the cooldown runs or size repairs are suspended
+// (sizeverify.go), logging a summary now and then. It returns false,
+// re-arming the trigger, once the cooldown has ended.
+func (d *daemon) sizeTriggerSuppressed(sizeMB float64) bool {
+	d.mu.Lock()
+	left, suspended := d.cooldownLeft(), d.sizeRepair.Suspended
+	d.mu.Unlock()
+	now := d.clock.Now()
+	if d.sizeOver.IsZero() || left == 0 && !suspended {
+		if d.sizeOver.IsZero() {
+			d.sizeOver = now
+		}
+		d.sizeNoted, d.sizeOverPolls, d.sizeOverPeak = now, 0, sizeMB
+		return false
+	}
+	d.sizeOverPolls++
+	d.sizeOverPeak = max(d.sizeOverPeak, sizeMB)
+	d.metrics.inc(mSizeTriggersFolded)
+	if every := d.cfg.Thresholds.StillExceededEvery.D(); now.Sub(d.sizeNoted) >= every {
+		rearm := fmt.Sprintf("Size trigger re-arms when the cooldown ends (%s) or the cache drops below the threshold.", left)
+		if suspended {
+			rearm = fmt.Sprintf("Size repairs are suspended until the cache drops below %.2f MB.", d.recoveryMB())
+		}
+		d.logMsg(msgSizeStillOver, d.cfg.Thresholds.SizeLimit, sizeMB, d.sizeOverPeak, duration(now.Sub(d.sizeOver).Truncate(time.Second)),
+			plural(d.sizeOverPolls, "poll", "no polls"), rearm)
+		d.sizeNoted, d.sizeOverPolls = now, 0
+	}
+	return true
+}
+
+// ---------------------------------------------------------------------------
+// LAYER C+D: Health Check Heuristics
+// ---------------------------------------------------------------------------
+
+func (d *daemon) runHealthChecks() {
+	// Layer C: run immediately at startup
+	d.healthLog_("INFO", "--- Health check running (startup) ---")
+	d.checkHealth()
+	d.writeStatus()
+	d.publishWMI()
+
+	// Layer D: repeat every 45 minutes, or on health.schedule
+	ticker, every := d.healthTicker()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			d.healthLog_("INFO", fmt.Sprintf("--- Health check running (periodic, %s) ---", every))
+		case why := <-d.healthNow:
+			d.healthLog_("INFO", fmt.Sprintf("--- Health check running (%s) ---", why))
+		case <-d.done:
+			return
+		}
+		d.checkHealth()
+		d.writeStatus()
+		d.publishWMI()
+	}
+}
+
+func (d *daemon) checkHealth() {
+	res := d.evaluateHealth(true)
+	res.CacheDir = d.cacheDir
+	res.CacheSizeMB = d.getCacheSizeMB()
+	res.Profile = d.profile
+	d.recordHeuristics(res)
+	d.mu.Lock()
+	d.lastResult = &res
+	d.mu.Unlock()
+}
+
+// evaluateHealth runs H1–H8, records the outcome and, when repair is set
+// and the score is below the threshold, triggers a repair.
+func (d *daemon) evaluateHealth(repair bool) healthResult {
+	defer func(start time.Time) { d.metrics.observe(mHealthSeconds, time.Since(start).Seconds()) }(time.Now())
+	if d.session == nil {
+		d.checkConfigTamper()
+		if d.sim == nil {
+			d.checkOSUpdate()
+			defer d.checkShellExtensions()
+		}
+	}
+	d.healthLog_("INFO", fmt.Sprintf("Profile: %s", d.profile))
+	d.checkContainer()
+	if !d.cacheDirPresent() {
+		d.healthLog_("INFO", fmt.Sprintf("Cache directory %s is missing. Health check skipped.", d.cacheDir))
+		d.mu.Lock()
+		score := d.lastScore
+		d.mu.Unlock()
+		return healthResult{Checked: d.clock.Now(), CacheDir: d.cacheDir, CacheMissing: true, Score: score, Threshold: d.cfg.Health.RepairBelowScore}
+	}
+	if d.sim == nil {
+		d.checkShellMods()
+	}
+	disk := d.checkDiskSpace()
+
+	h1 := d.checkH1Index()
+	h2 := d.checkH2RecentWrite()
+	h3 := d.checkH3FileCount()
+	h4 := d.checkH4Staleness()
+	heuristics := map[string]bool{"h1": h1, "h2": h2, "h3": h3, "h4": h4}
+	var ledger *ledgerResult
+	if d.ledgerActive() {
+		var h6 bool
+		h6, ledger = d.checkH6Ledger()
+		heuristics["h6"] = h6
+	}
+	var shell *shellStatus
+	if d.responsivenessActive() {
+		var h7 bool
+		h7, shell = d.checkH7Responsive()
+		heuristics["h7"] = h7
+	}
+	var stability *stabilityStatus
+	if d.shellStabilityActive() {
+		var h8 bool
+		h8, stability = d.checkH8Stability()
+		heuristics["h8"] = h8
+	}
+	var strays *strayStatus
+	if d.sim == nil {
+		strays = d.checkStrayFiles()
+	}
+	var tiers *tierStatus
+	if d.tierRepairActive() {
+		tiers = d.checkTiers()
+	}
+	var overlays *overlayStatus
+	var shortcuts *shortcutStatus
+	if d.session == nil && d.sim == nil {
+		overlays = d.checkH5OverlaySlots() // advisory, not scored
+		shortcuts = d.scanBrokenShortcuts()
+	}
+
+	var plugins []pluginResult
+	if d.sim == nil {
+		plugins = d.checkPlugins()
+	}
+
+	var failed uint64
+	for _, pass := range heuristics {
+		if !pass {
+			failed++
+		}
+	}
+	for _, p := range plugins {
+		if !p.Pass {
+			failed++
+		}
+	}
+	healthy := failed == 0
+	score := d.healthScore(heuristics, plugins)
+	d.metrics.inc(mHealthChecks)
+	d.metrics.add(mHeuristicFailures, failed)
+	if d.session == nil {
+		d.metrics.set(mHealthScore, float64(score))
+	}
+	threshold := d.cfg.Health.RepairBelowScore
+	d.mu.Lock()
+	wasBelow := !d.lastHealthCheck.IsZero() && d.lastScore < threshold
+	d.lastHealthCheck = d.clock.Now()
+	d.lastHealthy = healthy
+	d.lastScore = score
+	d.overlays = overlays
+	d.shortcuts = shortcuts
+	d.strays = strays
+	d.mu.Unlock()
+	d.updateReport(func(r *dailyReport) {
+		r.addScore(score)
+		if healthy {
+			r.HealthyChecks++
+		}
+		if shortcuts != nil {
+			r.BrokenShortcuts = len(shortcuts.Broken)
+		}
+	})
+
+	growth := fmt.Sprintf("growth %.2f MB/h", d.growthMBPerHour())
+	if top := d.topGrowth(); top != "" {
+		growth += ": " + top
+	}
+	d.healthLog_("INFO", fmt.Sprintf("Health score: %d/100 (repair below %d, %s)", score, threshold, growth))
+	res := healthResult{
+		Checked:      d.clock.Now(),
+		Heuristics:   heuristics,
+		Healthy:      healthy,
+		Score:        score,
+		Threshold:    threshold,
+		RepairNeeded: score < threshold,
+		Overlays:     overlays,
+		Shortcuts:    shortcuts,
+		Strays:       strays,
+		Tiers:        tiers,
+		Plugins:      plugins,
+		Ledger:       ledger,
+		Shell:        shell,
+		Stability:    stability,
+		DiskSpace:    disk,
+	}
+	if healthy && d.ledgerActive() {
+		d.recordLedger()
+	}
+
+	if score >= threshold {
+		d.softRepairSettled(score, threshold)
+		if wasBelow {
+			d.logMsg(msgHealthRestored, score, threshold)
+		}
+		d.incidents.settle(incidentHealth, stepCleared, fmt.Sprintf("health score %d at or above %d", score, threshold), true)
+		if healthy {
+			d.healthLog_("PASS", "=== ALL HEURISTICS PASSED. Cache is healthy. ===")
+		} else {
+			d.healthLog_("PASS", "=== HEURISTIC FAILURE TOLERATED. Score above repair threshold. ===")
+		}
+		switch {
+		case shell != nil && len(shell.Hung) > 0 && repair && d.cfg.Responsiveness.RestartExplorer:
+			d.restartHungExplorer("Explorer not responding: " + strings.Join(shell.Hung, ", "))
+		case tiers != nil && len(tiers.Confirmed) > 0 && repair:
+			d.triggerTierRepair(tiers)
+		}
+		return res
+	}
+	if repair {
+		d.incidents.trigger(incidentHealth, fmt.Sprintf("Health score %d below %d (%s).", score, threshold, failedHeuristics(res)))
+	}
+	d.historyEvent(historyHealth, fmt.Sprintf("health score %d below %d", score, threshold))
+	d.logMsg(msgHealthBelow, score, threshold, failedHeuristics(res))
+	if !repair {
+		d.healthLog_("WARN", "=== HEALTH SCORE BELOW THRESHOLD. Repair not requested. ===")
+		return res
+	}
+
+	// A broken index means icons are already wrong: never defer that.
+	prio := priorityNormal
+	if !h1 {
+		prio = priorityCritical
+	}
+	d.mu.Lock()
+	d.recordCorruption()
+	d.mu.Unlock()
+	d.metrics.inc(mHealthTriggers)
+	d.updateReport(func(r *dailyReport) { r.HealthTriggers++ })
+	reason := fmt.Sprintf("health score %d below %d", score, threshold)
+	if d.trySoftRepair(reason, score) {
+		return res
+	}
+	d.healthLog_("REPAIR", "=== HEALTH SCORE BELOW THRESHOLD. Triggering repair... ===")
+	d.triggerRepair(reason, prio)
+	return res
+}
+
+// H1: Index file present and non-empty
+func (d *daemon) checkH1Index() bool {
+	idxPath := filepath.Join(d.cacheDir, "iconcache_idx.db")
+	info, err := os.Stat(idxPath)
+	if err != nil {
+		d.logMsg(msgIndexMissing)
+		return false
+	}
+	if minSize := int64(d.cfg.Thresholds.IndexMinSize); info.Size() < minSize {
+		d.logMsg(msgIndexCorrupt, info.Size(), minSize)
+		return false
+	}
+	d.healthLog_("PASS", fmt.Sprintf("H1 PASS: iconcache_idx.db present and %.1f KB.", float64(info.Size())/1024))
+	return true
+}
+
+// H2: Main cache not recently written while Explorer was not running
+func (d *daemon) checkH2RecentWrite() bool {
+	mainCache := filepath.Join(d.cacheDir, "iconcache_256.db")
+	info, err := os.Stat(mainCache)
+	if err != nil {
+		d.healthLog_("PASS", "H2 PASS: iconcache_256.db not present (will be created on next Explorer start).")
+		return true
+	}
+
+	age := d.clock.Since(info.ModTime())
+	if age < d.cfg.Thresholds.RecentWrite.D() {
+		if !d.explorerRunning() && d.updateSettling() {
+			d.healthLog_("PASS", fmt.Sprintf("H2 PASS: iconcache_256.db written %.1f min ago while Explorer was not running, tolerated after the Windows update.", age.Minutes()))
+			return true
+		}
+		if !d.explorerRunning() {
+			d.healthLog_("WARN", fmt.Sprintf("H2 FAIL: iconcache_256.db written %.1f min ago while Explorer was NOT running.", age.Minutes()))
+			return false
+		}
+		d.healthLog_("PASS", "H2 PASS: Recently modified but Explorer was running (normal rebuild).")
+	} else {
+		d.healthLog_("PASS", fmt.Sprintf("H2 PASS: Last modified %.0f min ago (outside suspicious window).", age.Minutes()))
+	}
+	return true
+}
+
+// H3: Enough cache files exist while Explorer is running
+func (d *daemon) checkH3FileCount() bool {
+	files := d.getCacheFiles()
+	count := len(files)
+	if minFiles := d.cfg.Thresholds.MinHealthyFiles; d.explorerRunning() && count < minFiles {
+		d.healthLog_("WARN", fmt.Sprintf("H3 FAIL: Only %d cache files while Explorer is running (expected >=%d).", count, minFiles))
+		return false
+	}
+	d.healthLog_("PASS", fmt.Sprintf("H3 PASS: %d cache files present.", count))
+	return true
+}
+
+// H4: Cache is not stale
+func (d *daemon) checkH4Staleness() bool {
+	if d.profile.DiscardedAtLogoff {
+		d.healthLog_("PASS", fmt.Sprintf("H4 SKIP: %s profile is discarded at logoff; preemptive refresh would be wasted.", d.profile.Type))
+		return true
+	}
+	files := d.getCacheFiles()
+	if len(files) == 0 {
+		return true
+	}
+	var newest time.Time
+	for _, f := range files {
+		if f.ModTime().After(newest) {
+			newest = f.ModTime()
+		}
+	}
+	age := d.clock.Since(newest)
+	daysOld := age.Hours() / 24 // for the log only
+	if age > d.cfg.Thresholds.StaleAge.D() {
+		d.healthLog_("WARN", fmt.Sprintf("H4 FAIL: Cache last updated %.0f days ago. Preemptive refresh.", daysOld))
+		return false
+	}
+	d.healthLog_("PASS", fmt.Sprintf("H4 PASS: Cache last updated %.1f days ago.", daysOld))
+	return true
+}
+
+// ---------------------------------------------------------------------------
+// HELPERS
+// ---------------------------------------------------------------------------
+
+// findPowerShell returns a full path so the interpreter is never looked up
+// via PATH (a user-writable PATH entry could otherwise shadow it).
+func findPowerShell(override string) string {
+	if override != "" {
+		return override
+	}
+	pwsh7 := filepath.Join(nativeProgramFiles(), "PowerShell", "7", "pwsh.exe")
+	if _, err := os.Stat(pwsh7); err == nil {
+		return pwsh7
+	}
+	if sys := nativeSystemDir(); sys != "" {
+		return filepath.Join(sys, "WindowsPowerShell", "v1.0", "powershell.exe")
+	}
+	return "powershell.exe"
+}
+
+// explorerRunning checks for Explorer in the monitored session only when
+// running in multi-session mode, otherwise anywhere on the machine.
+func (d *daemon) explorerRunning() bool {
+	if d.sim != nil {
+		return d.sim.explorer
+	}
+	if d.session != nil {
+		return isExplorerRunning("/FI", fmt.Sprintf("SESSION eq %d", d.session.ID))
+	}
+	return isExplorerRunning()
+}
+
+func isExplorerRunning(filters ...string) bool {
+	// Check if explorer.exe process exists
+	if runtime.GOOS != "windows" {
+		return true // assume running in non-Windows environments
+	}
+	args := append([]string{"/FI", "IMAGENAME eq explorer.exe"}, filters...)
+	cmd := exec.Command("tasklist", append(args, "/NH")...)
+	out, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(out)), "explorer.exe")
+}
+
+// ---------------------------------------------------------------------------
+// ENTRY POINT
+// ---------------------------------------------------------------------------
+
+// Main runs icon-cache-watchdog.exe: a subcommand when one is given, else
+// the daemon, which never returns.
+func Main() {
+	// Leading --setting=value options override the config (overrides.go).
+	args, flagValues, err := splitOverrideFlags(os.Args[1:])
+	if err != nil {
+		attachParentConsole()
+		fmt.Fprintf(os.Stderr, "%v\n\nSettings:\n%s", err, overrideFlagList())
+		os.Exit(2)
+	}
+	d, cfgErr := newDaemon(flagValues)
+
+	// Subcommands (status, ...) run once against the same paths and exit.
+	if len(args) > 0 {
+		attachParentConsole()
+		os.Exit(runCommand(d, args))
+	}
+	d.run(cfgErr)
+}
+
+// run starts the daemon and blocks until d.done is closed (never, for the
+// executable). cfgErr is the config file error to log, if any.
+func (d *daemon) run(cfgErr error) {
+	d.started = d.clock.Now()
+	d.migrateLegacyLogs(filepath.Join(d.rootDir, "logs"))
+	d.loadState()
+
+	d.watchLog_("INFO", fmt.Sprintf("Daemon starting. Root: %s", d.rootDir))
+	d.watchLog_("INFO", fmt.Sprintf("Data dir: %s", d.dataDir))
+	d.resolveCachePath()
+	d.watchLog_("INFO", fmt.Sprintf("Cache dir: %s", d.cacheDir))
+	d.logMsg(msgDaemonStarted, d.cacheDir)
+	if errors.Is(cfgErr, errConfigSignature) {
+		d.logMsg(msgConfigRejected, cfgErr)
+	} else if cfgErr != nil {
+		d.logMsg(msgConfigIgnored, cfgErr)
+	}
+	for _, err := range d.cfgWarnings {
+		d.watchLog_("WARN", fmt.Sprintf("Config override ignored: %v", err))
+	}
+	if d.cfg.Preset != "" {
+		d.watchLog_("INFO", fmt.Sprintf("Configuration preset: %s", d.cfg.Preset))
+	}
+	if !d.cfg.MultiSession.Enabled {
+		if why := d.optOutReason(); why != "" {
+			d.watchLog_("INFO", fmt.Sprintf("User opted out (%s). Not monitoring this account.", why))
+			return
+		}
+	}
+
+	d.caps = d.probeCapabilities()
+	d.watchLog_("INFO", fmt.Sprintf("Capabilities: %s", d.caps))
+	if a := hostArch(); a.Emulated {
+		d.watchLog_("WARN", fmt.Sprintf("Architecture: %s. Install the %s build; emulation costs battery and start-up time.", a, a.Machine))
+	} else {
+		d.watchLog_("INFO", fmt.Sprintf("Architecture: %s", a))
+	}
+	d.logSoftRepair()
+
+	d.profile = detectCurrentProfile()
+	d.watchLog_("INFO", fmt.Sprintf("Profile: %s", d.profile))
+	if d.profile.Redirected != "" {
+		d.watchLog_("WARN", fmt.Sprintf("%%LOCALAPPDATA%% is redirected outside the profile (%s). Monitoring %s; verify this is where Explorer keeps its cache.", d.profile.Redirected, d.cacheDir))
+	}
+	if why := d.reportOnlyReason(); why != "" {
+		d.watchLog_("WARN", fmt.Sprintf("Report-only mode (%s): health is checked and logged, repairs are not run (vdi.mode).", why))
+	}
+	if !d.caps.canRepairDirect() {
+		d.watchLog_("WARN", fmt.Sprintf("This process cannot repair directly; repairs will be routed via %s.", d.caps.RepairRoute))
+	}
+
+	d.startOTel()
+	go d.runSelfCheck()
+	if !d.cfg.MultiSession.Enabled {
+		d.history = d.loadHistory()
+		d.recordHistory()
+		d.startIncidents()
+	}
+	d.serveDashboard()
+	d.serveGRPC()
+	d.serveFleet()
+	go d.serveControl()
+	d.recoverInterruptedRepair()
+
+	if d.cfg.MultiSession.Enabled {
+		// RDS / AVD: per-session Layers B, C and D (blocks until stopped)
+		d.runMultiSession()
+		return
+	}
+
+	d.usnCatchUp()
+
+	// Run Layer C+D health checks in background goroutine
+	go d.runHealthChecks()
+	d.serveRemote()
+
+	// Run Layer B watchdog in main goroutine (blocks until stopped)
+	d.runWatchdog()
+}
+
+// newDaemon resolves all paths relative to the executable location and
+// loads the optional config file. The config error is returned separately
+// so the daemon can log it once logging is set up.
+func newDaemon(flagValues map[string]string) (*daemon, error) {
+	rootDir := projectRoot()
+	configFile := filepath.Join(rootDir, configFileName)
+	cfg, sources, cfgErr := loadConfig(configFile)
+	warnings := applyOverrides(&cfg, sources, flagValues)
+	applyPreset(&cfg, sources)
+	var thumbnails targetThresholds
+	if !cfg.MultiSession.Enabled {
+		thumbnails = applyTargets(&cfg, sources, os.Getenv("USERDOMAIN"), os.Getenv("USERNAME"))
+	}
+
+	d := newDaemonWith(cfg, rootDir, configFile)
+	d.thumbnails = thumbnails
+	d.cfgSources = sources
+	d.cfgFileState = fileState(configFile, cfgErr)
+	d.cfgWarnings = warnings
+	return d, cfgErr
+}
+
+// projectRoot is the folder holding bin\ and scripts\, found from the
+// executable's location.
+func projectRoot() string {
+	// Resolve paths relative to executable location
+	exeDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		exeDir = "."
+	}
+
+	// Navigate from bin/ up to project root
+	rootDir := filepath.Dir(exeDir)
+
+	// Support running from project root directly (during development)
+	if _, err := os.Stat(filepath.Join(exeDir, "scripts")); err == nil {
+		rootDir = exeDir
+	}
+	return rootDir
+}
+
+// newDaemonWith resolves the paths cfg implies. configFile is "" when the
+// config does not come from a file (watchdog.go).
+func newDaemonWith(cfg config, rootDir, configFile string) *daemon {
+	localAppData := os.Getenv("LOCALAPPDATA")
+	dataDir := resolveDataDir(cfg.DataDir, rootDir)
+
+	repairScript := filepath.Join(rootDir, "scripts", "Repair-IconCache.ps1")
+	if cfg.RepairScript != "" {
+		repairScript = cfg.RepairScript
+		if !filepath.IsAbs(repairScript) {
+			repairScript = filepath.Join(rootDir, repairScript)
+		}
+	}
+
+	return &daemon{
+		cfg:          cfg,
+		configFile:   configFile,
+		cfgDigest:    fileDigest(configFile),
+		rootDir:      rootDir,
+		dataDir:      dataDir,
+		cacheDir:     filepath.Join(localAppData, "Microsoft", "Windows", "Explorer"),
+		repairScript: repairScript,
+		stateFile:    filepath.Join(dataDir, "state.json"),
+		logDir:       filepath.Join(dataDir, "logs"),
+		watchLog:     filepath.Join(dataDir, "logs", "Watchdog.log"),
+		healthLog:    filepath.Join(dataDir, "logs", "IconCacheHealth.log"),
+		unifiedLog:   filepath.Join(dataDir, "logs", unifiedLogName),
+		progress:     newProgressHub(),
+		logs:         newLogFeed(),
+		metrics:      newMetricsRegistry(),
+		healthNow:    make(chan string, 1),
+		lastRepair:   time.Time{},
+		clock:        realClock{},
+	}
+}
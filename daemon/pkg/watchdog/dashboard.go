@@ -0,0 +1,283 @@
+// dashboard.go
+// Local web dashboard (ui.enabled, or --ui on the command line): one page
+// on http://127.0.0.1:<ui.port> with the live status, the 24-hour history
+// (history.go), the live log, the effective configuration and a "Repair
+// now" button, for users who would rather use a browser than log files.
+//
+// The server listens on the loopback address only and answers only requests
+// whose Host header names it, so a web page cannot reach it through DNS
+// rebinding. The page itself holds no data: every API call needs the token
+// the daemon draws at startup and keeps in memory. `dashboard` asks the
+// running daemon for the address over the control pipe and prints it with
+// the token in the fragment (#token=...), which browsers never send to a
+// server. With ui.tlsCert and ui.tlsKey the server speaks HTTPS, and with
+// ui.clientCA it also requires a client certificate signed by that CA
+// (mutual TLS) before the token is even looked at. API calls and refusals
+// go to the audit log (audit.go). Single-user mode only.
+//
+//   GET  /api/status   status.json as of now (status.go)
+//   GET  /api/history  the 24-hour history
+//   GET  /api/config   config show-effective -json
+//   GET  /api/logs     the live log as server-sent events (logfeed.go)
+//   GET  /api/metrics  the metrics in the Prometheus text format (metrics.go)
+//   POST /api/repair   a repair as requested via remote commands (remote.go)
+
+package watchdog
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const dashboardLogBacklog = 100 // log lines a new page starts with
+
+const dashboardRepair = "POST /api/repair"
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+type uiOptions struct {
+	Enabled  bool   `json:"enabled"`
+	Port     int    `json:"port"`     // 0 picks a free port
+	TLSCert  string `json:"tlsCert"`  // PEM certificate; empty: plain HTTP
+	TLSKey   string `json:"tlsKey"`   // PEM private key of tlsCert
+	ClientCA string `json:"clientCA"` // PEM CA bundle; set: require client certificates it signed
+}
+
+func defaultUIOptions() uiOptions {
+	return uiOptions{Port: 8765}
+}
+
+// dashboard is set once the server listens; nil when it is off.
+type dashboard struct {
+	addr   string // 127.0.0.1:port
+	scheme string // http, or https with ui.tlsCert
+	token  string
+}
+
+func (u *dashboard) url() string {
+	return u.scheme + "://" + u.addr + "/#token=" + u.token
+}
+
+// tlsConfig loads the dashboard's certificates; nil serves plain HTTP.
+func (o uiOptions) tlsConfig() (*tls.Config, error) {
+	if o.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(o.TLSCert, o.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if o.ClientCA != "" {
+		raw, err := os.ReadFile(o.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("%s holds no PEM certificate", o.ClientCA)
+		}
+		c.ClientCAs, c.ClientAuth = pool, tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// serveDashboard starts the server if ui.enabled. It must run before the
+// control pipe serves requests, which read d.dashboard without a lock.
+func (d *daemon) serveDashboard() {
+	if !d.cfg.UI.Enabled {
+		return
+	}
+	if d.cfg.MultiSession.Enabled {
+		d.ipcLog_("WARN", "Dashboard is not available in multi-session mode.")
+		return
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("Dashboard unavailable: generate token: %v", err))
+		return
+	}
+	tlsConfig, err := d.cfg.UI.tlsConfig()
+	if err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("Dashboard unavailable: load TLS certificates: %v", err))
+		return
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", d.cfg.UI.Port))
+	if err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("Dashboard unavailable: %v", err))
+		return
+	}
+	u := &dashboard{addr: l.Addr().String(), scheme: "http", token: hex.EncodeToString(token)}
+	if tlsConfig != nil {
+		l, u.scheme = tls.NewListener(l, tlsConfig), "https"
+	}
+	d.dashboard = u
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardPage)
+	})
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.statusSnapshot())
+	})
+	mux.HandleFunc("GET /api/history", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.history.snapshot(d.clock.Now()))
+	})
+	mux.HandleFunc("GET /api/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.effectiveConfig())
+	})
+	mux.HandleFunc("GET /api/logs", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		ch := d.logs.subscribe(dashboardLogBacklog)
+		defer d.logs.unsubscribe(ch)
+		for {
+			select {
+			case e := <-ch:
+				raw, _ := json.Marshal(e)
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", raw); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	mux.HandleFunc("GET /api/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		d.metrics.writePrometheus(w)
+		d.writeHeuristicMetrics(w)
+	})
+	mux.HandleFunc(dashboardRepair, func(w http.ResponseWriter, r *http.Request) {
+		out := d.requestRepair("dashboard")
+		d.audit("dashboard", dashboardPeer(r, true), dashboardRepair, out.Result, false)
+		writeJSON(w, out)
+	})
+
+	srv := &http.Server{Handler: u.guard(d, mux), ReadHeaderTimeout: 10 * time.Second, ErrorLog: log.New(dashboardErrorLog{d}, "", 0)}
+	go srv.Serve(l)
+	if d.done != nil {
+		go func() {
+			<-d.done
+			srv.Close()
+		}()
+	}
+	d.ipcLog_("INFO", fmt.Sprintf("Dashboard listening on %s://%s (run `dashboard` for the sign-in link)", u.scheme, u.addr))
+}
+
+// dashboardErrorLog records failed TLS handshakes (no or a foreign client
+// certificate) as refusals and passes the server's other errors on.
+type dashboardErrorLog struct{ d *daemon }
+
+func (l dashboardErrorLog) Write(p []byte) (int, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(string(p)), "http: TLS handshake error from ")
+	if !ok {
+		return os.Stderr.Write(p)
+	}
+	addr, reason, _ := strings.Cut(rest, ": ")
+	l.d.audit("dashboard", controlPeer{Addr: addr}, "TLS handshake", reason, true)
+	return len(p), nil
+}
+
+// dashboardPeer names a dashboard client for the audit log: by its
+// certificate with ui.clientCA, else as the token's holder once it has
+// shown the token.
+func dashboardPeer(r *http.Request, authorized bool) controlPeer {
+	p := controlPeer{Addr: r.RemoteAddr}
+	switch {
+	case r.TLS != nil && len(r.TLS.PeerCertificates) > 0:
+		p.User = r.TLS.PeerCertificates[0].Subject.String()
+	case authorized:
+		p.User = "token holder"
+	}
+	return p
+}
+
+// guard rejects foreign Host headers and API calls without the token, and
+// records API calls in the audit log.
+func (u *dashboard) guard(d *daemon, next http.Handler) http.Handler {
+	_, port, _ := net.SplitHostPort(u.addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cmd := r.Method + " " + r.URL.Path
+		if r.Host != u.addr && r.Host != "localhost:"+port {
+			d.audit("dashboard", dashboardPeer(r, false), cmd, "foreign Host header "+r.Host, true)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(u.token)) != 1 {
+				d.audit("dashboard", dashboardPeer(r, false), cmd, "unauthorized", true)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if cmd != dashboardRepair {
+				d.audit("dashboard", dashboardPeer(r, true), cmd, "ok", false)
+			}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// cmdDashboard prints (and with -open, opens) the sign-in link of the
+// running daemon's dashboard.
+func cmdDashboard(d *daemon, args []string) int {
+	fs := newFlagSet("dashboard")
+	open := fs.Bool("open", false, "open the link in the default browser")
+	asJSON := fs.Bool("json", false, "print the link as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	c, err := openControl(d.controlAddr())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Daemon not reachable: %v\n", err)
+		return 1
+	}
+	defer c.Close()
+	ev, err := c.call(controlRequest{Cmd: requestDashboard})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No dashboard: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		printJSON("dashboard", struct {
+			URL string `json:"url"`
+		}{ev.URL})
+	} else {
+		fmt.Println(ev.URL)
+	}
+	if *open {
+		if err := openURL(ev.URL); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open a browser: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+// dashboard_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "errors"
+
+func openURL(url string) error {
+	return errors.New("opening a browser needs Windows")
+}
@@ -0,0 +1,10 @@
+// dashboard_windows.go
+// Opening the dashboard link in the user's default browser.
+
+package watchdog
+
+import "os/exec"
+
+func openURL(url string) error {
+	return exec.Command("rundll32.exe", "url.dll,FileProtocolHandler", url).Start()
+}
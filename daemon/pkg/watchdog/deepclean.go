@@ -0,0 +1,101 @@
+// deepclean.go
+// Scheduled deep clean: for users who prefer proactive hygiene, a full
+// rebuild — icon and thumbnail caches, tray icon streams, shell icon index —
+// once per deepClean.every, inside the deepClean.window maintenance window,
+// or at the slots of deepClean.schedule, regardless of what the heuristics
+// say. Quiet hours and the cooldown do not apply (the window is the user's
+// choice, and the schedule bounds the rate); a full-screen hold postpones it
+// to a later poll inside the window.
+// Single-user mode only.
+
+package watchdog
+
+import (
+	"fmt"
+	"time"
+)
+
+type deepCleanOptions struct {
+	Enabled bool     `json:"enabled"`
+	Every   duration `json:"every"`  // minimum time between deep cleans
+	Window  string   `json:"window"` // daily local-time window, "HH:MM-HH:MM"
+
+	// Schedule replaces Every and Window with calendar slots (schedule.go),
+	// e.g. "Sat 03:00". A slot missed by more than deepCleanCatchUp, the
+	// machine being off, waits for the next one.
+	Schedule string `json:"schedule"`
+}
+
+const deepCleanCatchUp = 2 * time.Hour
+
+func defaultDeepCleanOptions() deepCleanOptions {
+	return deepCleanOptions{
+		Every:  7 * duration(24*time.Hour),
+		Window: "03:00-05:00",
+	}
+}
+
+// deepCleanDue reports whether a deep clean should start now.
+func (d *daemon) deepCleanDue() bool {
+	o := d.cfg.DeepClean
+	if !o.Enabled || d.session != nil || d.reportOnlyReason() != "" {
+		return false
+	}
+	if o.Schedule != "" {
+		s, err := parseSchedule(o.Schedule)
+		if err != nil {
+			return false
+		}
+		now := d.clock.Now()
+		from := now.Add(-deepCleanCatchUp)
+		for _, t := range []time.Time{d.deepTried, d.deepCleaned} {
+			if t.After(from) {
+				from = t
+			}
+		}
+		return !s.next(from).After(now)
+	}
+	if d.clock.Since(d.deepCleaned) < o.Every.D() {
+		return false
+	}
+	// A failed launch is retried in the next day's window, not every poll.
+	if d.clock.Since(d.deepTried) < 20*time.Hour {
+		return false
+	}
+	start, end, ok := parseQuietHours(o.Window)
+	return ok && inDailyWindow(d.clock.Now(), start, end)
+}
+
+// checkDeepClean runs a due deep clean. Called on every Layer B poll.
+func (d *daemon) checkDeepClean() {
+	if !d.deepCleanDue() {
+		return
+	}
+	if d.fullScreenReason() != "" || d.processHoldReason() != "" || d.networkReason() != "" {
+		return // retried on the next poll while the window lasts
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pausedReason() != "" {
+		return // retried on the next poll after the pause
+	}
+	if why := d.diskSpaceReason(); why != "" {
+		d.deepTried = d.clock.Now()
+		d.repairLog_("WARN", fmt.Sprintf("Low disk space: %s. Skipping scheduled deep clean.", why))
+		return
+	}
+	reason := fmt.Sprintf("scheduled deep clean (every %s, window %s)", d.cfg.DeepClean.Every, d.cfg.DeepClean.Window)
+	if s := d.cfg.DeepClean.Schedule; s != "" {
+		reason = fmt.Sprintf("scheduled deep clean (schedule %q)", s)
+	}
+	d.repairLog_("TRIGGER", fmt.Sprintf("Repair triggered: %s", reason))
+	d.deepTried = d.clock.Now()
+	if !d.launchRepair(reason, true) {
+		return
+	}
+	d.deepCleaned = d.clock.Now()
+	d.saveState()
+	d.metrics.inc(mRepairsStarted)
+	d.updateReport(func(r *dailyReport) { r.Repairs++ })
+}
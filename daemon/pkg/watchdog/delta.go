@@ -0,0 +1,173 @@
+// delta.go
+// Per-file change detection for the Layer B poll. Each poll compares the
+// size and modification time of every iconcache_*.db with the previous poll
+// and logs the files that grew, so a trigger names iconcache_256.db growing
+// by 40 MB instead of only an aggregate total. The per-file sizes are kept
+// with the trend samples (score.go), which gives the growth rate per file
+// over the trend window; the fastest-growing files are added to the size
+// trigger and to the health score log line.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	deltaLogMinBytes = 1 << 20 // smaller per-poll growth is normal Explorer churn
+	growthTopFiles   = 3
+)
+
+type fileSnap struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// fileDelta is one file's change between two polls.
+type fileDelta struct {
+	Name   string `json:"file"`
+	Before int64  `json:"before"` // -1: new file
+	After  int64  `json:"after"`  // -1: removed
+}
+
+func (c fileDelta) String() string {
+	switch {
+	case c.Before < 0:
+		return fmt.Sprintf("%s new (%.2f MB)", c.Name, mb(c.After))
+	case c.After < 0:
+		return fmt.Sprintf("%s removed (was %.2f MB)", c.Name, mb(c.Before))
+	}
+	return fmt.Sprintf("%s %+.2f MB (%.2f -> %.2f MB)", c.Name, mb(c.After-c.Before), mb(c.Before), mb(c.After))
+}
+
+func mb(n int64) float64 { return float64(n) / (1024 * 1024) }
+
+// snapshotCache reads the size and mtime of every cache file.
+func (d *daemon) snapshotCache() map[string]fileSnap {
+	files := map[string]fileSnap{}
+	for _, f := range d.getCacheFiles() {
+		files[f.Name()] = fileSnap{Size: f.Size(), ModTime: f.ModTime()}
+	}
+	for name, path := range d.modCacheFiles() {
+		if info, err := os.Stat(path); err == nil {
+			files[name] = fileSnap{Size: info.Size(), ModTime: info.ModTime()}
+		}
+	}
+	return files
+}
+
+// diffCache lists the files that appeared, disappeared or changed size or
+// mtime between prev and cur, largest growth first.
+func diffCache(prev, cur map[string]fileSnap) []fileDelta {
+	var out []fileDelta
+	for name, c := range cur {
+		p, ok := prev[name]
+		switch {
+		case !ok:
+			out = append(out, fileDelta{name, -1, c.Size})
+		case p.Size != c.Size || !p.ModTime.Equal(c.ModTime):
+			out = append(out, fileDelta{name, p.Size, c.Size})
+		}
+	}
+	for name, p := range prev {
+		if _, ok := cur[name]; !ok {
+			out = append(out, fileDelta{name, p.Size, -1})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		gi, gj := out[i].growth(), out[j].growth()
+		if gi != gj {
+			return gi > gj
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+func (c fileDelta) growth() int64 { return max(c.After, 0) - max(c.Before, 0) }
+
+// pollCache takes this poll's snapshot, logs the files that grew by at
+// least deltaLogMinBytes since the previous poll and returns the total size.
+// The first poll only sets the baseline.
+//
+// With the USN journal (usn.go), a poll the journal reports no cache changes
+// for reuses the previous snapshot instead of listing the directory.
+func (d *daemon) pollCache() (sizeMB float64, files map[string]fileSnap) {
+	// The journal covers the Explorer cache folder only.
+	if d.usnEnabled() && len(d.modCacheFiles()) == 0 {
+		d.mu.Lock()
+		prev := d.lastFiles
+		d.mu.Unlock()
+		if changed, ok := d.usnPoll(); ok && !changed && prev != nil {
+			files = prev
+		}
+	}
+	if files == nil {
+		files = d.snapshotCache()
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	d.mu.Lock()
+	prev := d.lastFiles
+	d.lastFiles = files
+	d.mu.Unlock()
+	if prev != nil {
+		for _, c := range diffCache(prev, files) {
+			if c.growth() >= deltaLogMinBytes {
+				d.watchLog_("INFO", "Cache grew: "+c.String())
+			}
+		}
+	}
+	return mb(total), files
+}
+
+// fileGrowth is one file's growth rate over the trend window.
+type fileGrowth struct {
+	Name       string
+	MBPerHour  float64
+	GrownBytes int64
+}
+
+// growthByFile returns the files that grew across the trend window, fastest
+// first, or nil when there is not enough history yet.
+func (d *daemon) growthByFile() []fileGrowth {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	first, last, ok := d.trendSpan()
+	if !ok || first.Files == nil || last.Files == nil {
+		return nil
+	}
+	hours := last.At.Sub(first.At).Hours()
+	var out []fileGrowth
+	for name, size := range last.Files {
+		if grown := size - first.Files[name]; grown > 0 {
+			out = append(out, fileGrowth{name, mb(grown) / hours, grown})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].GrownBytes != out[j].GrownBytes {
+			return out[i].GrownBytes > out[j].GrownBytes
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// topGrowth renders the fastest-growing files for a log line, or "".
+func (d *daemon) topGrowth() string {
+	g := d.growthByFile()
+	if len(g) == 0 {
+		return ""
+	}
+	var parts []string
+	for _, f := range g[:min(len(g), growthTopFiles)] {
+		parts = append(parts, fmt.Sprintf("%s +%.2f MB (%.2f MB/h)", f.Name, mb(f.GrownBytes), f.MBPerHour))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,66 @@
+// diskspace.go
+// Free space guard. A rebuild on a nearly full disk thrashes: Explorer
+// writes the new cache into the last free megabytes, the page file grows
+// into the same space, and the cache comes back truncated or not at all.
+// Before every repair the daemon reads the free space on the cache volume
+// (the system drive unless %LOCALAPPDATA% is redirected); below
+// thresholds.minFreeSpace the repair is skipped, not deferred, and a disk
+// space alert is raised: a health log warning, event 117 and a count in
+// the daily report. Health checks take the same reading, so the alert
+// shows up even when nothing triggers a repair. 0 turns the guard off.
+
+package watchdog
+
+import "fmt"
+
+// diskSpaceStatus is the latest reading of the cache volume.
+type diskSpaceStatus struct {
+	FreeMB  float64 `json:"freeMB"`
+	FloorMB float64 `json:"floorMB"`
+	Low     bool    `json:"low"`
+}
+
+func (s diskSpaceStatus) String() string {
+	return fmt.Sprintf("%.0f MB free on the cache volume (floor %.0f MB)", s.FreeMB, s.FloorMB)
+}
+
+// measureDiskSpace reads the free space on the cache volume and raises or
+// clears the alert. A nil status means the guard is off or the volume could
+// not be read. Caller holds d.mu.
+func (d *daemon) measureDiskSpace() *diskSpaceStatus {
+	floor := d.cfg.Thresholds.MinFreeSpace
+	if floor == 0 || d.sim != nil {
+		return nil
+	}
+	free, err := freeDiskSpace(d.cacheDir)
+	if err != nil {
+		return nil
+	}
+	s := &diskSpaceStatus{FreeMB: byteSize(free).MB(), FloorMB: floor.MB(), Low: free < uint64(floor)}
+	wasLow := d.diskSpace != nil && d.diskSpace.Low
+	d.diskSpace = s
+	switch {
+	case s.Low && !wasLow:
+		d.logMsg(msgDiskSpaceAlert, s)
+		d.updateReport(func(r *dailyReport) { r.DiskSpaceAlerts++ })
+	case !s.Low && wasLow:
+		d.logMsg(msgDiskRecovered, s)
+	}
+	return s
+}
+
+// diskSpaceReason returns why the cache volume is too full for a rebuild,
+// or "". Caller holds d.mu.
+func (d *daemon) diskSpaceReason() string {
+	if s := d.measureDiskSpace(); s != nil && s.Low {
+		return s.String()
+	}
+	return ""
+}
+
+// checkDiskSpace takes the health check's reading.
+func (d *daemon) checkDiskSpace() *diskSpaceStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.measureDiskSpace()
+}
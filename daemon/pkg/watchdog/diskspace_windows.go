@@ -0,0 +1,23 @@
+// diskspace_windows.go
+// kernel32!GetDiskFreeSpaceExW for the free space guard.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// freeDiskSpace returns the bytes available to the daemon's user on the
+// volume holding dir.
+func freeDiskSpace(dir string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(extendedPath(dir))
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if r, _, callErr := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&free)), 0, 0); r == 0 {
+		return 0, callErr
+	}
+	return free, nil
+}
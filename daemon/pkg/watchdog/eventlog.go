@@ -0,0 +1,145 @@
+// eventlog.go
+// Daemon events in the Windows Application log (eventLog.enabled), source
+// IconCacheWatchdog, one event ID per state change. Admins attach their own
+// scripts with event-triggered scheduled tasks (e.g. an inventory update
+// after every repair) instead of modifying the daemon. The IDs are part of
+// the interface: docs/architecture.md lists them, and they are never reused.
+//
+// Register-Tasks.ps1 registers the source with the .NET message file, so
+// Event Viewer shows the text without "description cannot be found". Each
+// event's single insertion string is the log line, prefixed with the user
+// (or session) it concerns.
+
+package watchdog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const eventSource = "IconCacheWatchdog"
+
+// Event IDs, grouped in tens by subsystem.
+const (
+	evtDaemonStarted     = 100
+	evtHeartbeat         = 101
+	evtBreakerTripped    = 102
+	evtBreakerReset      = 103
+	evtRepairStarted     = 110
+	evtRepairCompleted   = 111
+	evtRepairFailed      = 112
+	evtRepairDeferred    = 113
+	evtRepairRefused     = 114
+	evtRepairSlow        = 115
+	evtShellRescued      = 116
+	evtDiskSpaceLow      = 117
+	evtRepairIneffective = 118
+	evtSoftRepair        = 119
+	evtHealthBelow       = 120
+	evtHealthRestored    = 121
+	evtSizeOverLimit     = 130
+	evtCacheDirGone      = 131
+	evtCacheDirBack      = 132
+	evtConfigTamper      = 140
+	evtShellExtCulprit   = 150
+	evtContainerIssue    = 160
+)
+
+// Event types (winnt.h EVENTLOG_*_TYPE).
+const (
+	evtTypeError       = 0x1
+	evtTypeWarning     = 0x2
+	evtTypeInformation = 0x4
+)
+
+var evtTypes = map[uint32]uint16{
+	evtDaemonStarted:     evtTypeInformation,
+	evtHeartbeat:         evtTypeInformation,
+	evtBreakerTripped:    evtTypeError,
+	evtBreakerReset:      evtTypeInformation,
+	evtRepairStarted:     evtTypeInformation,
+	evtRepairCompleted:   evtTypeInformation,
+	evtRepairFailed:      evtTypeError,
+	evtRepairDeferred:    evtTypeWarning,
+	evtRepairRefused:     evtTypeError,
+	evtRepairSlow:        evtTypeWarning,
+	evtShellRescued:      evtTypeWarning,
+	evtDiskSpaceLow:      evtTypeWarning,
+	evtRepairIneffective: evtTypeWarning,
+	evtSoftRepair:        evtTypeInformation,
+	evtHealthBelow:       evtTypeWarning,
+	evtHealthRestored:    evtTypeInformation,
+	evtSizeOverLimit:     evtTypeWarning,
+	evtCacheDirGone:      evtTypeWarning,
+	evtCacheDirBack:      evtTypeInformation,
+	evtConfigTamper:      evtTypeWarning,
+	evtShellExtCulprit:   evtTypeWarning,
+	evtContainerIssue:    evtTypeWarning,
+}
+
+// incidentEvents carry the open incident's ID (incident.go) in their text.
+var incidentEvents = map[uint32]bool{
+	evtRepairStarted:     true,
+	evtRepairCompleted:   true,
+	evtRepairFailed:      true,
+	evtRepairDeferred:    true,
+	evtRepairSlow:        true,
+	evtRepairIneffective: true,
+	evtSoftRepair:        true,
+	evtHealthBelow:       true,
+	evtHealthRestored:    true,
+	evtSizeOverLimit:     true,
+}
+
+type eventLogOptions struct {
+	Enabled bool `json:"enabled"`
+}
+
+// reportEvent writes one event when eventLog.enabled is set.
+func (d *daemon) reportEvent(id uint32, msg string) {
+	if !d.cfg.EventLog.Enabled || d.sim != nil {
+		return
+	}
+	if incidentEvents[id] {
+		msg += d.incidentSuffix()
+	}
+	d.emitEvent(id, msg)
+}
+
+// emitEvent writes one event regardless of eventLog.enabled; failures are
+// logged once until writing works again.
+func (d *daemon) emitEvent(id uint32, msg string) {
+	prefix := d.logPrefix
+	if prefix == "" {
+		_, user := reportIdentity()
+		prefix = "[" + user + "] "
+	}
+	err := writeEvent(eventSource, evtTypes[id], id, prefix+msg)
+	switch {
+	case err != nil && !d.eventLogFailing.Swap(true):
+		d.watchLog_("WARN", fmt.Sprintf("Cannot write event %d to the Application log: %v", id, err))
+	case err == nil && d.eventLogFailing.Swap(false):
+		d.watchLog_("INFO", "Writing to the Application log works again.")
+	}
+}
+
+// failedHeuristics names the failed heuristics of res for an event text.
+func failedHeuristics(res healthResult) string {
+	var failed []string
+	for name, pass := range res.Heuristics {
+		if !pass {
+			failed = append(failed, strings.ToUpper(name))
+		}
+	}
+	sort.Strings(failed)
+	for _, p := range res.Plugins {
+		if !p.Pass {
+			failed = append(failed, "plug-in "+p.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return "size headroom low"
+	}
+	return "failed: " + strings.Join(failed, ", ")
+}
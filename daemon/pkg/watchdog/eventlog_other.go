@@ -0,0 +1,8 @@
+//go:build !windows
+
+// eventlog_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func writeEvent(source string, typ uint16, id uint32, msg string) error { return nil }
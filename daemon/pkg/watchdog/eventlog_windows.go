@@ -0,0 +1,37 @@
+// eventlog_windows.go
+// Application log writes via RegisterEventSourceW / ReportEventW. A source
+// that is not registered still logs, under the Application log.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procRegisterEventSourceW  = modAdvapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = modAdvapi32.NewProc("DeregisterEventSource")
+	procReportEventW          = modAdvapi32.NewProc("ReportEventW")
+)
+
+func writeEvent(source string, typ uint16, id uint32, msg string) error {
+	name, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return err
+	}
+	text, err := syscall.UTF16PtrFromString(msg)
+	if err != nil {
+		return err
+	}
+	h, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(name)))
+	if h == 0 {
+		return err
+	}
+	defer procDeregisterEventSource.Call(h)
+	strs := [1]*uint16{text}
+	if r, _, err := procReportEventW.Call(h, uintptr(typ), 0, uintptr(id), 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0); r == 0 {
+		return err
+	}
+	return nil
+}
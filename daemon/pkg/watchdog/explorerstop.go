@@ -0,0 +1,109 @@
+// explorerstop.go
+// How the repair script stops Explorer (explorerStop). By default it kills
+// explorer.exe outright (Stop-Process -Force), which is quick but loses
+// what Explorer would save on a normal exit: open folder windows, the
+// position of desktop icons changed since logon, a tray layout. With
+// explorerStop.method "graceful" the script climbs a ladder instead and
+// stops at the first rung that gets every explorer.exe to exit within its
+// timeout:
+//
+//   restart-manager  Restart Manager asks Explorer to shut down, as setup
+//                    programs do (explorerStop.restartManager)
+//   graceful         the shell's own exit, the taskbar's "Exit Explorer"
+//                    (explorerStop.graceful)
+//   force            Stop-Process -Force (explorerStop.force)
+//
+// The script reports the rung as "##explorer-stopped <rung> <seconds>" on
+// stdout next to its progress records; the daemon logs it to Repair.log,
+// and graceful stops that fell through to force are counted. The restart
+// of a hung Explorer always forces: a hung shell answers neither of the
+// first rungs.
+
+package watchdog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stopGraceful = "graceful"
+	stopForced   = "forced"
+
+	explorerStopPrefix = "##explorer-stopped "
+
+	// Rungs the script reports; "none" when Explorer was not running,
+	// "failed" when it outlived the last rung.
+	rungRestartManager = "restart-manager"
+	rungGraceful       = "graceful"
+	rungForce          = "force"
+	rungNone           = "none"
+	rungFailed         = "failed"
+)
+
+type explorerStopOptions struct {
+	Method                string   `json:"method"`         // "graceful" or "forced"
+	RestartManagerTimeout duration `json:"restartManager"` // wait for the Restart Manager shutdown
+	GracefulTimeout       duration `json:"graceful"`       // wait after "Exit Explorer"
+	ForceTimeout          duration `json:"force"`          // wait after Stop-Process -Force
+}
+
+func defaultExplorerStopOptions() explorerStopOptions {
+	return explorerStopOptions{
+		Method:                stopForced,
+		RestartManagerTimeout: duration(10 * time.Second),
+		GracefulTimeout:       duration(10 * time.Second),
+		ForceTimeout:          duration(5 * time.Second),
+	}
+}
+
+// explorerStopParams returns the repair script parameters for explorerStop,
+// or nil while it is the default.
+func (d *daemon) explorerStopParams() []string {
+	s := d.cfg.ExplorerStop
+	if s == defaultExplorerStopOptions() {
+		return nil
+	}
+	secs := func(t duration) string { return strconv.Itoa(int(t.D() / time.Second)) }
+	return []string{"-StopMethod", s.Method,
+		"-StopTimeouts", strings.Join([]string{secs(s.RestartManagerTimeout), secs(s.GracefulTimeout), secs(s.ForceTimeout)}, "|")}
+}
+
+// parseExplorerStop parses one "##explorer-stopped" record.
+func parseExplorerStop(line string) (rung string, took float64, ok bool) {
+	rest, found := strings.CutPrefix(strings.TrimSpace(line), explorerStopPrefix)
+	if !found {
+		return "", 0, false
+	}
+	fields := strings.Fields(rest)
+	if len(fields) != 2 {
+		return "", 0, false
+	}
+	took, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return fields[0], took, true
+}
+
+// explorerStopped logs the rung that stopped Explorer for a repair.
+func (d *daemon) explorerStopped(rung string, took float64, reason string) {
+	switch rung {
+	case rungNone:
+		d.repairLog_("INFO", fmt.Sprintf("Explorer was not running when the repair began. Reason: %s", reason))
+	case rungFailed:
+		d.repairLog_("WARN", fmt.Sprintf("Explorer still running %.1fs into the repair, after every stop method. Cache files may be locked. Reason: %s", took, reason))
+		d.incidents.step(phaseExplorerStopping, "explorer.exe outlived every stop method")
+	case rungForce:
+		if d.cfg.ExplorerStop.Method == stopGraceful {
+			d.repairLog_("WARN", fmt.Sprintf("Explorer stopped by force after %.1fs: Restart Manager and the shell's own exit did not stop it. Reason: %s", took, reason))
+			d.metrics.inc(mStopFallbacks)
+			return
+		}
+		fallthrough
+	default:
+		d.repairLog_("INFO", fmt.Sprintf("Explorer stopped (%s) in %.1fs. Reason: %s", rung, took, reason))
+	}
+}
@@ -0,0 +1,313 @@
+// fleet.go
+// Fleet status (fleet.enabled): a read-only HTTPS endpoint on the network,
+// so an admin can see many machines from one console with
+// `status -host pc1,pc2,pc3`. Unlike the dashboard (dashboard.go), which
+// only listens on the loopback address with a token drawn at startup, the
+// fleet endpoint listens on every interface at fleet.port and takes the
+// token shared by the fleet: the contents of fleet.tokenFile. One port and
+// one token per machine, so only the machine-wide instance serves it — the
+// multi-session daemon running as LocalSystem, which can read a token file
+// and TLS key that only SYSTEM and Administrators may read. Per-user
+// daemons would race for the port and need files every user can read. It
+// serves one call:
+//
+//   GET /api/status   status.json as last written (status.go)
+//
+// TLS is required (fleet.tlsCert, fleet.tlsKey); the CLI verifies servers
+// against the system roots and fleet.ca. Calls and refusals go to the audit
+// log (audit.go) under the "fleet" channel.
+
+package watchdog
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fleetStatusPath = "/api/status"
+	minFleetToken   = 16 // characters
+)
+
+type fleetOptions struct {
+	Enabled   bool   `json:"enabled"`
+	Port      int    `json:"port"`      // 0 picks a free port
+	TokenFile string `json:"tokenFile"` // shared bearer token, also read by `status -host`
+	TLSCert   string `json:"tlsCert"`   // PEM certificate
+	TLSKey    string `json:"tlsKey"`    // PEM private key of tlsCert
+	CA        string `json:"ca"`        // PEM CA bundle `status -host` trusts besides the system roots
+}
+
+func defaultFleetOptions() fleetOptions {
+	return fleetOptions{Port: 8766}
+}
+
+// token reads the shared token from fleet.tokenFile.
+func (o fleetOptions) token() (string, error) {
+	raw, err := os.ReadFile(o.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(raw))
+	if len(token) < minFleetToken {
+		return "", fmt.Errorf("%s holds fewer than %d characters", o.TokenFile, minFleetToken)
+	}
+	return token, nil
+}
+
+// serveFleet starts the fleet endpoint if fleet.enabled.
+func (d *daemon) serveFleet() {
+	o := d.cfg.Fleet
+	if !o.Enabled {
+		return
+	}
+	if !d.cfg.MultiSession.Enabled {
+		d.ipcLog_("WARN", "Fleet endpoint unavailable: it is served only by the machine-wide instance (multiSession.enabled)")
+		return
+	}
+	token, err := o.token()
+	if err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("Fleet endpoint unavailable: token: %v", err))
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(o.TLSCert, o.TLSKey)
+	if err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("Fleet endpoint unavailable: load TLS certificate: %v", err))
+		return
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", o.Port))
+	if err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("Fleet endpoint unavailable: %v", err))
+		return
+	}
+	d.fleetAddr = l.Addr().String()
+	l = tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+fleetStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		peer := controlPeer{Addr: r.RemoteAddr}
+		cmd := r.Method + " " + r.URL.Path
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			d.audit("fleet", peer, cmd, "unauthorized", true)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		peer.User = "fleet token holder"
+		raw, err := os.ReadFile(d.statusFile())
+		if err != nil {
+			d.audit("fleet", peer, cmd, "no status.json", false)
+			http.Error(w, "no status yet", http.StatusServiceUnavailable)
+			return
+		}
+		d.audit("fleet", peer, cmd, "ok", false)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(raw)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second, ErrorLog: log.New(io.Discard, "", 0)}
+	go srv.Serve(l)
+	if d.done != nil {
+		go func() {
+			<-d.done
+			srv.Close()
+		}()
+	}
+	d.ipcLog_("INFO", fmt.Sprintf("Fleet endpoint listening on https://%s%s", d.fleetAddr, fleetStatusPath))
+}
+
+// fleetHost is the outcome of asking one host for its status.
+type fleetHost struct {
+	Host    string        `json:"host"`
+	Running bool          `json:"running"` // status.json refreshed within statusStaleAfter of the reply
+	Status  *statusReport `json:"status,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// fleetClient builds the HTTPS client of `status -host`.
+func fleetClient(o fleetOptions, timeout time.Duration) (*http.Client, error) {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CA != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		raw, err := os.ReadFile(o.CA)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("%s holds no PEM certificate", o.CA)
+		}
+		c.RootCAs = roots
+	}
+	return &http.Client{Timeout: timeout, Transport: &http.Transport{TLSClientConfig: c}}, nil
+}
+
+// queryFleet asks every host at once; results keep the order of hosts.
+func queryFleet(client *http.Client, token string, port int, hosts []string) []fleetHost {
+	out := make([]fleetHost, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out[i] = queryHost(client, token, port, host)
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+func queryHost(client *http.Client, token string, port int, host string) fleetHost {
+	h := fleetHost{Host: host}
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	req, err := http.NewRequest("GET", "https://"+addr+fleetStatusPath, nil)
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := client.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		h.Error = err.Error()
+		return h
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		h.Error = res.Status
+		return h
+	}
+	var r statusReport
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		h.Error = "unreadable status: " + err.Error()
+		return h
+	}
+	now := time.Now()
+	if at, err := http.ParseTime(res.Header.Get("Date")); err == nil {
+		now = at // the host's clock, so skew does not read as stale
+	}
+	h.Status, h.Running = &r, now.Sub(r.Updated) <= statusStaleAfter
+	return h
+}
+
+// cmdFleetStatus is `status -host`: one row per host.
+func cmdFleetStatus(d *daemon, hosts []string, tokenFile string, timeout time.Duration, asJSON bool) int {
+	o := d.cfg.Fleet
+	if tokenFile != "" {
+		o.TokenFile = tokenFile
+	}
+	if o.TokenFile == "" {
+		fmt.Fprintln(os.Stderr, "No fleet token: set fleet.tokenFile or pass -token-file.")
+		return 2
+	}
+	token, err := o.token()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fleet token: %v\n", err)
+		return 2
+	}
+	client, err := fleetClient(o, timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fleet.ca: %v\n", err)
+		return 2
+	}
+	results := queryFleet(client, token, o.Port, hosts)
+	code := 0
+	for _, r := range results {
+		if r.Error != "" {
+			code = 1
+		}
+	}
+	if asJSON {
+		printJSON("fleet", struct {
+			Hosts []fleetHost `json:"hosts"`
+		}{results})
+		return code
+	}
+
+	fmt.Printf("%-20s %-12s %5s %9s  %-16s  %-16s  %s\n", "HOST", "STATE", "SCORE", "CACHE MB", "LAST CHECK", "LAST REPAIR", "NOTE")
+	sort.SliceStable(results, func(i, j int) bool { return fleetRank(results[i]) < fleetRank(results[j]) })
+	for _, r := range results {
+		if r.Status == nil {
+			fmt.Printf("%-20s %-12s %5s %9s  %-16s  %-16s  %s\n", r.Host, "UNREACHABLE", "-", "-", "-", "-", r.Error)
+			continue
+		}
+		s := r.Status
+		state, score, note := fleetState(r)
+		fmt.Printf("%-20s %-12s %5s %9.2f  %-16s  %-16s  %s\n", r.Host, state, score, s.CacheSizeMB,
+			fleetTime(s.LastCheck), fleetTime(s.LastRepair), note)
+	}
+	return code
+}
+
+// fleetState returns the STATE, SCORE and NOTE columns of a host that
+// answered. A tripped breaker outranks an open incident, whose repairs it
+// holds; the note then names both.
+func fleetState(r fleetHost) (state, score, note string) {
+	s := r.Status
+	state, score = "healthy", "-"
+	if !s.LastCheck.IsZero() {
+		score = strconv.Itoa(s.HealthScore)
+		if !s.Healthy {
+			state = "degraded"
+		}
+	}
+	switch {
+	case !r.Running:
+		state, note = "NOT RUNNING", "last seen "+formatTime(s.Updated)
+	case s.Breaker != nil:
+		state, note = "BREAKER", s.Breaker.String()
+		if s.Incident != nil {
+			note += "; incident " + s.Incident.String()
+		}
+	case s.Incident != nil:
+		note = s.Incident.String()
+	case s.Paused != nil && s.Updated.Before(s.Paused.Until):
+		state, note = "paused", s.Paused.String()
+	case s.CacheGone != nil:
+		state, note = "cache gone", "missing since "+formatTime(*s.CacheGone)
+	}
+	return state, score, note
+}
+
+// fleetRank puts the hosts that need attention first.
+func fleetRank(r fleetHost) int {
+	switch {
+	case r.Status == nil:
+		return 0
+	case !r.Running:
+		return 1
+	case r.Status.Breaker != nil || r.Status.Incident != nil || !r.Status.Healthy:
+		return 2
+	}
+	return 3
+}
+
+func fleetTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04")
+}
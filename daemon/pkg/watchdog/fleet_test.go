@@ -0,0 +1,46 @@
+// fleet_test.go
+// The fleet table: each host's state and where it sorts.
+
+package watchdog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFleetState(t *testing.T) {
+	now := time.Date(2026, 3, 4, 14, 15, 2, 0, time.Local)
+	inc := &incident{ID: "20260304-141502-9f3a", Opened: now, Cause: "health score 40"}
+	brk := &breakerState{Tripped: now, Reason: "3 repairs failed in a row"}
+	for _, c := range []struct {
+		name  string
+		host  fleetHost
+		state string
+		note  []string // in the note; none means no note
+		rank  int
+	}{
+		{"healthy", fleetHost{Running: true, Status: &statusReport{Healthy: true, LastCheck: now, HealthScore: 100}}, "healthy", nil, 3},
+		{"degraded", fleetHost{Running: true, Status: &statusReport{LastCheck: now, HealthScore: 60}}, "degraded", nil, 2},
+		{"incident", fleetHost{Running: true, Status: &statusReport{LastCheck: now, HealthScore: 40, Incident: inc}}, "degraded", []string{inc.ID}, 2},
+		// A tripped breaker is not hidden by the incident it holds.
+		{"breaker with incident", fleetHost{Running: true, Status: &statusReport{LastCheck: now, HealthScore: 40, Incident: inc, Breaker: brk}}, "BREAKER", []string{"3 repairs failed", inc.ID}, 2},
+		{"not running", fleetHost{Status: &statusReport{Healthy: true, Updated: now, Breaker: brk}}, "NOT RUNNING", []string{"last seen"}, 1},
+	} {
+		state, _, note := fleetState(c.host)
+		if state != c.state || (len(c.note) == 0) != (note == "") {
+			t.Errorf("%s: state %s, note %q", c.name, state, note)
+		}
+		for _, want := range c.note {
+			if !strings.Contains(note, want) {
+				t.Errorf("%s: note %q lacks %q", c.name, note, want)
+			}
+		}
+		if r := fleetRank(c.host); r != c.rank {
+			t.Errorf("%s: rank %d, want %d", c.name, r, c.rank)
+		}
+	}
+	if r := fleetRank(fleetHost{Host: "pc07", Error: "timeout"}); r != 0 {
+		t.Errorf("unreachable host: rank %d", r)
+	}
+}
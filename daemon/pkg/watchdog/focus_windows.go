@@ -0,0 +1,37 @@
+// focus_windows.go
+// Focus Assist state from the shell's WNF notification
+// WNF_SHEL_QUIETHOURS_ACTIVE_PROFILE_CHANGED: 0 off, 1 priority only,
+// 2 alarms only. There is no documented API for this; the WNF state name has
+// been stable since Windows 10 1803.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const wnfShelQuietHoursActiveProfileChanged uint64 = 0x0D83063EA3BF1C75
+
+var (
+	modNtdll                = syscall.NewLazyDLL("ntdll.dll")
+	procNtQueryWnfStateData = modNtdll.NewProc("NtQueryWnfStateData")
+)
+
+func focusAssistState() (int, bool) {
+	if procNtQueryWnfStateData.Find() != nil {
+		return 0, false
+	}
+	name := wnfShelQuietHoursActiveProfileChanged
+	var stamp, value uint32
+	size := uint32(unsafe.Sizeof(value))
+	status, _, _ := procNtQueryWnfStateData.Call(
+		uintptr(unsafe.Pointer(&name)), 0, 0,
+		uintptr(unsafe.Pointer(&stamp)),
+		uintptr(unsafe.Pointer(&value)),
+		uintptr(unsafe.Pointer(&size)))
+	if status != 0 {
+		return 0, false
+	}
+	return int(value), true
+}
@@ -0,0 +1,39 @@
+// fullscreen.go
+// Full-screen detection. Killing Explorer while a game or presentation runs
+// full screen minimises it on many setups, so every repair that restarts
+// Explorer — critical ones included — is held until the user leaves
+// full-screen mode. SHQueryUserNotificationState reports the same condition
+// the shell uses to suppress its own toasts.
+
+package watchdog
+
+const (
+	qunsBusy                 = 2 // full-screen application
+	qunsRunningD3DFullScreen = 3 // exclusive-mode Direct3D
+	qunsPresentationMode     = 4
+	qunsApp                  = 7 // immersive (Store) app full screen
+)
+
+// fullScreenReason returns a description of the full-screen state blocking
+// Explorer restarts, or "" when none is active.
+func (d *daemon) fullScreenReason() string {
+	// Only meaningful for the daemon's own session.
+	if !d.cfg.Quiet.HoldDuringFullScreen || d.session != nil {
+		return ""
+	}
+	state, ok := userNotificationState()
+	if !ok {
+		return ""
+	}
+	switch state {
+	case qunsBusy:
+		return "full-screen application"
+	case qunsRunningD3DFullScreen:
+		return "full-screen Direct3D application"
+	case qunsPresentationMode:
+		return "presentation mode"
+	case qunsApp:
+		return "full-screen app"
+	}
+	return ""
+}
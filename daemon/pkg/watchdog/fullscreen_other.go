@@ -0,0 +1,8 @@
+//go:build !windows
+
+// fullscreen_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func userNotificationState() (int, bool) { return 0, false }
@@ -0,0 +1,22 @@
+// fullscreen_windows.go
+// shell32!SHQueryUserNotificationState (Vista+).
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modShell32                       = syscall.NewLazyDLL("shell32.dll")
+	procSHQueryUserNotificationState = modShell32.NewProc("SHQueryUserNotificationState")
+)
+
+func userNotificationState() (int, bool) {
+	var state int32
+	if hr, _, _ := procSHQueryUserNotificationState.Call(uintptr(unsafe.Pointer(&state))); hr != 0 {
+		return 0, false
+	}
+	return int(state), true
+}
@@ -0,0 +1,271 @@
+// grpcapi.go
+// gRPC management API (grpc.enabled) for integrators who would rather use
+// generated clients than the control pipe's JSON. The schema is
+// pkg/api/iconcache/v1/management.proto and the Go client is generated
+// next to it; the calls mirror the pipe's requests:
+//
+//   GetStatus        status.json as of now (status.go)
+//   GetHistory       the 24-hour history (history.go)
+//   GetConfig        config show-effective, per setting and as JSON
+//   Repair           a repair as requested via remote commands (remote.go)
+//   SubscribeEvents  repair progress and/or the live log (logfeed.go)
+//
+// Like the dashboard, the server listens on the loopback address only and
+// every call needs the token the daemon draws at startup. The daemon writes
+// the address and token to grpc.json in the data directory, readable by its
+// own account only, and removes it on shutdown; iconcachev1.Dial reads it.
+// Calls and refusals go to the audit log (audit.go). Single-user mode only.
+
+package watchdog
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	iconcachev1 "icon-cache-watchdog/pkg/api/iconcache/v1"
+)
+
+const grpcRepair = iconcachev1.Management_Repair_FullMethodName
+
+type grpcOptions struct {
+	Enabled bool `json:"enabled"`
+	Port    int  `json:"port"` // 0 picks a free port
+}
+
+func defaultGRPCOptions() grpcOptions {
+	return grpcOptions{Port: 8767}
+}
+
+func (d *daemon) grpcEndpointFile() string {
+	return filepath.Join(d.dataDir, iconcachev1.EndpointFile)
+}
+
+// serveGRPC starts the API if grpc.enabled.
+func (d *daemon) serveGRPC() {
+	if !d.cfg.GRPC.Enabled {
+		return
+	}
+	if d.cfg.MultiSession.Enabled {
+		d.ipcLog_("WARN", "gRPC API is not available in multi-session mode.")
+		return
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("gRPC API unavailable: generate token: %v", err))
+		return
+	}
+	token := hex.EncodeToString(raw)
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", d.cfg.GRPC.Port))
+	if err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("gRPC API unavailable: %v", err))
+		return
+	}
+	endpoint, _ := json.Marshal(iconcachev1.Endpoint{Address: l.Addr().String(), Token: token})
+	if err := os.WriteFile(d.grpcEndpointFile(), endpoint, 0600); err != nil {
+		l.Close()
+		d.ipcLog_("WARN", fmt.Sprintf("gRPC API unavailable: %v", err))
+		return
+	}
+	d.grpcAddr = l.Addr().String()
+
+	auth := grpcAuth{d, token}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(auth.unary), grpc.StreamInterceptor(auth.stream))
+	iconcachev1.RegisterManagementServer(srv, grpcServer{d: d})
+	go srv.Serve(l)
+	if d.done != nil {
+		go func() {
+			<-d.done
+			srv.Stop()
+			os.Remove(d.grpcEndpointFile())
+		}()
+	}
+	d.ipcLog_("INFO", fmt.Sprintf("gRPC API listening on %s (address and token in %s)", d.grpcAddr, d.grpcEndpointFile()))
+}
+
+// grpcAuth rejects calls without the token and records calls in the audit
+// log; Repair records its own outcome.
+type grpcAuth struct {
+	d     *daemon
+	token string
+}
+
+func (a grpcAuth) check(ctx context.Context, method string) error {
+	peer := grpcPeer(ctx, false)
+	var got string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) == 1 {
+			got, _ = strings.CutPrefix(v[0], "Bearer ")
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) != 1 {
+		a.d.audit("gRPC API", peer, method, "unauthorized", true)
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	if method != grpcRepair {
+		a.d.audit("gRPC API", grpcPeer(ctx, true), method, "ok", false)
+	}
+	return nil
+}
+
+func (a grpcAuth) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := a.check(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a grpcAuth) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.check(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcPeer names an API client for the audit log.
+func grpcPeer(ctx context.Context, authorized bool) controlPeer {
+	var p controlPeer
+	if pr, ok := grpcpeer.FromContext(ctx); ok {
+		p.Addr = pr.Addr.String()
+	}
+	if authorized {
+		p.User = "token holder"
+	}
+	return p
+}
+
+type grpcServer struct {
+	iconcachev1.UnimplementedManagementServer
+	d *daemon
+}
+
+func (s grpcServer) GetStatus(context.Context, *iconcachev1.GetStatusRequest) (*iconcachev1.Status, error) {
+	st := s.d.statusSnapshot()
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out := &iconcachev1.Status{
+		Pid:             int32(st.PID),
+		Started:         pbTime(st.Started),
+		Updated:         pbTime(st.Updated),
+		CacheDir:        st.CacheDir,
+		DataDir:         st.DataDir,
+		CacheSizeMb:     st.CacheSizeMB,
+		GrowthMbPerHour: st.GrowthMBPerH,
+		HealthScore:     int32(st.HealthScore),
+		Healthy:         st.Healthy,
+		LastHealthCheck: pbTime(st.LastCheck),
+		LastRepair:      pbTime(st.LastRepair),
+		DeferredRepair:  st.Deferred,
+		Quiet:           st.Quiet,
+		ReportOnly:      st.ReportOnly,
+		Json:            string(raw),
+	}
+	if p := st.Paused; p != nil {
+		out.Paused = &iconcachev1.Pause{Until: pbTime(p.Until), Reason: p.Reason, By: p.By}
+	}
+	if b := st.Breaker; b != nil {
+		out.CircuitBreaker = &iconcachev1.Breaker{Tripped: pbTime(b.Tripped), Reason: b.Reason, Suppressed: int32(b.Suppressed)}
+	}
+	if inc := st.Incident; inc != nil {
+		out.Incident = &iconcachev1.Incident{Id: inc.ID, Opened: pbTime(inc.Opened), Cause: inc.Cause, Triggers: int32(inc.Triggers), Awaiting: inc.Awaiting}
+	}
+	if st.CacheGone != nil {
+		out.CacheMissingSince = pbTime(*st.CacheGone)
+	}
+	return out, nil
+}
+
+func (s grpcServer) GetHistory(context.Context, *iconcachev1.GetHistoryRequest) (*iconcachev1.History, error) {
+	view := s.d.history.snapshot(s.d.clock.Now())
+	out := &iconcachev1.History{}
+	for _, p := range view.Sizes {
+		out.Sizes = append(out.Sizes, &iconcachev1.SizePoint{At: pbTime(p.At), SizeMb: p.SizeMB})
+	}
+	for _, e := range view.Events {
+		out.Events = append(out.Events, &iconcachev1.HistoryEvent{At: pbTime(e.At), Kind: e.Kind, Message: e.Message, Incident: e.Incident, By: e.By})
+	}
+	for _, r := range view.RepairTimes {
+		out.RepairTimes = append(out.RepairTimes, &iconcachev1.RepairTime{At: pbTime(r.At), Seconds: r.Seconds, ExplorerDownSeconds: r.ExplorerDownSeconds})
+	}
+	return out, nil
+}
+
+func (s grpcServer) GetConfig(context.Context, *iconcachev1.GetConfigRequest) (*iconcachev1.Config, error) {
+	eff := s.d.effectiveConfig()
+	raw, err := json.Marshal(eff)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out := &iconcachev1.Config{Json: string(raw)}
+	for _, st := range settingsOf(&eff.Config) {
+		value, _ := json.Marshal(st.Value.Interface())
+		out.Settings = append(out.Settings, &iconcachev1.Setting{Path: st.Path, Value: string(value), Source: eff.Sources[st.Path]})
+	}
+	return out, nil
+}
+
+func (s grpcServer) Repair(ctx context.Context, _ *iconcachev1.RepairRequest) (*iconcachev1.RepairResult, error) {
+	out := s.d.requestRepair("gRPC API")
+	s.d.audit("gRPC API", grpcPeer(ctx, true), grpcRepair, out.Result, false)
+	return &iconcachev1.RepairResult{Started: out.Started, Result: out.Result}, nil
+}
+
+func (s grpcServer) SubscribeEvents(req *iconcachev1.SubscribeEventsRequest, stream iconcachev1.Management_SubscribeEventsServer) error {
+	if !req.Progress && !req.Logs {
+		return status.Error(codes.InvalidArgument, "subscribe to progress, logs or both")
+	}
+	// A nil channel never delivers, so an unrequested kind stays silent.
+	var progress chan controlEvent
+	var logs chan logEntry
+	if req.Progress {
+		progress = s.d.progress.subscribe()
+		defer s.d.progress.unsubscribe(progress)
+	}
+	if req.Logs {
+		logs = s.d.logs.subscribe(int(req.LogBacklog))
+		defer s.d.logs.unsubscribe(logs)
+	}
+	for {
+		var ev *iconcachev1.Event
+		select {
+		case e := <-progress:
+			ev = &iconcachev1.Event{At: pbTime(e.At), Kind: &iconcachev1.Event_Progress{Progress: &iconcachev1.Progress{
+				Phase: e.Phase, Done: int32(e.Done), Total: int32(e.Total), Reason: e.Reason, Session: e.Session, Incident: e.Incident, Error: e.Error,
+			}}}
+		case e := <-logs:
+			ev = &iconcachev1.Event{At: pbTime(e.At), Kind: &iconcachev1.Event_Log{Log: &iconcachev1.LogEntry{
+				Subsystem: e.Subsystem, Level: e.Level, Code: e.Code, Message: e.Message,
+			}}}
+		case <-stream.Context().Done():
+			return nil
+		}
+		if err := stream.Send(ev); err != nil {
+			return err
+		}
+	}
+}
+
+// pbTime converts t, leaving the zero time (never happened) unset.
+func pbTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
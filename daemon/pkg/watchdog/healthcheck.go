@@ -0,0 +1,167 @@
+// healthcheck.go
+// `healthcheck`: one full health evaluation from the command line, for
+// scheduled tasks and scripts that want the daemon's heuristics rather than
+// a parallel PowerShell implementation. It runs the same checks as Layers C
+// and D, logs them to the health log, prints the result (text or -json) and
+// exits 0 when the score is at or above the repair threshold, 1 when it is
+// not. With -repair a low score triggers a repair under the usual cooldown
+// and quiet-hours rules, and the command waits for a direct repair to end.
+
+package watchdog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type healthResult struct {
+	Checked      time.Time        `json:"checked"`
+	CacheDir     string           `json:"cacheDir"`
+	CacheSizeMB  float64          `json:"cacheSizeMB"`
+	Heuristics   map[string]bool  `json:"heuristics"` // h1..h4 (h6, h7, h8) passed
+	Healthy      bool             `json:"healthy"`    // all heuristics passed
+	Score        int              `json:"score"`
+	Threshold    int              `json:"repairBelowScore"`
+	RepairNeeded bool             `json:"repairNeeded"`
+	Repair       string           `json:"repair,omitempty"` // -repair outcome
+	Overlays     *overlayStatus   `json:"overlays,omitempty"`
+	Shortcuts    *shortcutStatus  `json:"shortcuts,omitempty"`  // not scored
+	Strays       *strayStatus     `json:"strayFiles,omitempty"` // not scored (strayfiles.go)
+	Tiers        *tierStatus      `json:"tiers,omitempty"`      // not scored (tiers.go)
+	Plugins      []pluginResult   `json:"plugins,omitempty"`    // plug-in heuristics (plugins.go)
+	Ledger       *ledgerResult    `json:"ledger,omitempty"`     // H6 evidence (ledger.go)
+	Shell        *shellStatus     `json:"shell,omitempty"`      // H7 evidence (responsive.go)
+	Stability    *stabilityStatus `json:"stability,omitempty"`  // H8 evidence (shellstability.go)
+	DiskSpace    *diskSpaceStatus `json:"diskSpace,omitempty"`  // free space guard (diskspace.go)
+	Profile      profileInfo      `json:"profile"`
+	CacheMissing bool             `json:"cacheMissing,omitempty"` // not evaluated (cachedir.go)
+}
+
+func cmdHealthCheck(d *daemon, args []string) int {
+	fs := newFlagSet("healthcheck")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	repair := fs.Bool("repair", false, "trigger a repair when the score is below the threshold")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	d.loadState()
+	d.started = d.clock.Now()
+	d.profile = detectCurrentProfile()
+	d.caps = d.probeCapabilities()
+	d.resolveCachePath()
+
+	d.healthLog_("INFO", "--- Health check running (command line) ---")
+	d.mu.Lock()
+	before := d.lastRepair
+	d.mu.Unlock()
+	res := d.evaluateHealth(*repair)
+	res.CacheDir = d.cacheDir
+	res.CacheSizeMB = d.getCacheSizeMB()
+	res.Profile = d.profile
+
+	if *repair && res.RepairNeeded {
+		d.mu.Lock()
+		started := d.lastRepair != before
+		d.mu.Unlock()
+		res.Repair = "not started (cooldown, hold or launch failure; see the logs)"
+		if started {
+			res.Repair = "started"
+			if d.waitRepair() {
+				res.Repair = "finished"
+			}
+		}
+	}
+
+	if *asJSON {
+		printJSON("healthcheck", res)
+	} else {
+		printHealthResult(res)
+	}
+	if res.RepairNeeded {
+		return 1
+	}
+	return 0
+}
+
+// waitRepair waits for a direct repair to exit and reports whether it did.
+// Brokered and task repairs run elsewhere and are not waited for.
+func (d *daemon) waitRepair() bool {
+	for {
+		d.mu.Lock()
+		running := d.inFlight != nil
+		d.mu.Unlock()
+		if !running {
+			return true
+		}
+		if d.clock.Since(d.lastRepair) > interruptedRepairMaxAge {
+			return false
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func printHealthResult(r healthResult) {
+	if r.CacheMissing {
+		fmt.Printf("Health score:  not evaluated — cache directory %s is missing\n", r.CacheDir)
+		return
+	}
+	verdict := "healthy"
+	if r.RepairNeeded {
+		verdict = "REPAIR NEEDED"
+	}
+	fmt.Printf("Health score:  %d/100 (repair below %d) — %s\n", r.Score, r.Threshold, verdict)
+	var names []string
+	for name := range r.Heuristics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result := "PASS"
+		if !r.Heuristics[name] {
+			result = "FAIL"
+		}
+		fmt.Printf("  %-4s %s\n", name, result)
+	}
+	if l := r.Ledger; l != nil {
+		for _, m := range l.Mismatches {
+			fmt.Printf("       %s\n", m)
+		}
+	}
+	if s := r.Shell; s != nil && len(s.Hung) > 0 {
+		fmt.Printf("       not responding: %s\n", strings.Join(s.Hung, ", "))
+	}
+	if s := r.Stability; s != nil && !r.Heuristics["h8"] {
+		fmt.Printf("       unstable: %s\n", s)
+	}
+	fmt.Printf("Cache:         %.2f MB in %s\n", r.CacheSizeMB, r.CacheDir)
+	fmt.Printf("Profile:       %s\n", r.Profile)
+	if c := r.Profile.Container; c != nil {
+		fmt.Printf("Container:     %s\n", c)
+	}
+	if o := r.Overlays; o != nil {
+		if len(o.NotLoaded) > 0 {
+			fmt.Printf("Overlays:      %d registered, only %d load. Not loaded: %s\n", o.Registered, overlaySlots, strings.Join(o.NotLoaded, ", "))
+		} else {
+			fmt.Printf("Overlays:      %d of %d slots used\n", o.Registered, overlaySlots)
+		}
+	}
+	if s := r.Strays; s != nil && len(s.Files) > 0 {
+		fmt.Printf("Stray files:   %s (run `strays` for the list)\n", s)
+	}
+	if t := r.Tiers; t != nil && len(t.Corrupt) > 0 {
+		for _, p := range t.Corrupt {
+			fmt.Printf("Corrupt tier:  %s: %s\n", p.File, p.Problem)
+		}
+	}
+	if s := r.Shortcuts; s != nil {
+		fmt.Printf("Shortcuts:     %s\n", s)
+		for _, b := range s.Broken {
+			fmt.Printf("  %s -> %s\n", b.Path, b.Target)
+		}
+	}
+	if r.Repair != "" {
+		fmt.Printf("Repair:        %s\n", r.Repair)
+	}
+}
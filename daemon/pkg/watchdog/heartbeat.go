@@ -0,0 +1,127 @@
+// heartbeat.go
+// Heartbeat delivery. Every thresholds.heartbeatEvery the daemon logs a
+// HEARTBEAT line; monitoring that does not read Watchdog.log can get the
+// same sign of life from the sinks under heartbeat:
+//
+//   - eventLog: event 101 in the Application log, for monitoring agents that
+//     alert on a missing event (independent of eventLog.enabled);
+//   - webhook: an HTTP POST of the heartbeat as JSON to a push monitor
+//     (Healthchecks, Uptime Kuma, Better Stack and the like), which alerts
+//     when pings stop;
+//   - file: the same JSON rewritten at every heartbeat, for agents that
+//     check a file's age.
+//
+// The sinks are also served once at startup, so a monitor sees a started
+// daemon without waiting a full interval. The webhook is pinged in the
+// background and never delays the poll loop; a ping still in flight at the
+// next heartbeat is not doubled. Failures are logged once per sink until
+// it works again. Trace simulation delivers nothing.
+
+package watchdog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+type heartbeatOptions struct {
+	EventLog bool     `json:"eventLog"` // write event 101 at every heartbeat
+	Webhook  string   `json:"webhook"`  // http(s) URL to POST to; empty: none
+	File     string   `json:"file"`     // file to rewrite, relative to the data dir; empty: none
+	Timeout  duration `json:"timeout"`  // webhook request timeout
+}
+
+func defaultHeartbeatOptions() heartbeatOptions {
+	return heartbeatOptions{Timeout: duration(10 * time.Second)}
+}
+
+// heartbeatPing is the JSON payload of the webhook and the file.
+type heartbeatPing struct {
+	Time     string   `json:"time"`
+	Computer string   `json:"computer"`
+	User     string   `json:"user"`
+	PID      int      `json:"pid"`
+	Arch     archInfo `json:"architecture"`
+	Interval string   `json:"interval"` // the next heartbeat is due after this
+	Message  string   `json:"message"`
+}
+
+// heartbeat logs one HEARTBEAT line and delivers it to the sinks.
+func (d *daemon) heartbeat(msg string) {
+	d.watchLog_("HEARTBEAT", msg)
+	d.sendHeartbeat(msg)
+}
+
+// sendHeartbeat delivers msg to the configured sinks without logging it.
+func (d *daemon) sendHeartbeat(msg string) {
+	o := d.cfg.Heartbeat
+	if d.sim != nil {
+		return
+	}
+	if o.EventLog {
+		d.emitEvent(evtHeartbeat, msg)
+	}
+	if o.Webhook == "" && o.File == "" {
+		return
+	}
+	computer, user := reportIdentity()
+	raw, _ := json.Marshal(heartbeatPing{
+		Time:     d.clock.Now().Format(time.RFC3339),
+		Computer: computer,
+		User:     user,
+		PID:      os.Getpid(),
+		Arch:     hostArch(),
+		Interval: d.cfg.Thresholds.HeartbeatEvery.String(),
+		Message:  msg,
+	})
+	raw = stampJSON("heartbeat", raw)
+
+	if o.File != "" {
+		d.sinkResult("heartbeat file", &d.heartbeatFileFailing, writeFileAtomic(d.heartbeatFile(), raw))
+	}
+	if o.Webhook != "" && !d.webhookBusy.Swap(true) {
+		go func() {
+			defer d.webhookBusy.Store(false)
+			d.sinkResult("heartbeat webhook", &d.webhookFailing, postJSON(o.Webhook, o.Timeout.D(), raw))
+		}()
+	}
+}
+
+// heartbeatFile resolves heartbeat.file against the data dir.
+func (d *daemon) heartbeatFile() string {
+	if isAbsPath(d.cfg.Heartbeat.File) {
+		return d.cfg.Heartbeat.File
+	}
+	return filepath.Join(d.dataDir, d.cfg.Heartbeat.File)
+}
+
+// sinkResult logs the first failure of a sink and its recovery.
+func (d *daemon) sinkResult(sink string, failing *atomic.Bool, err error) {
+	switch {
+	case err != nil && !failing.Swap(true):
+		d.watchLog_("WARN", fmt.Sprintf("Cannot deliver the %s: %v", sink, err))
+	case err == nil && failing.Swap(false):
+		d.watchLog_("INFO", fmt.Sprintf("Delivering the %s works again.", sink))
+	}
+}
+
+// postJSON POSTs one heartbeat ping or incident notice (incident.go); any
+// status other than 2xx is a failure.
+func postJSON(url string, timeout time.Duration, body []byte) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// heuristicstats.go
+// Per-heuristic track record. Every health check of the daemon records, for
+// each heuristic (h1..h8 and the plug-in heuristics by name), when it last
+// passed and last failed, since when it has been failing without a pass in
+// between, and its failures over the last heuristicWindow. A heuristic that
+// fails now and then never shows in a single health check, and is otherwise
+// only found by reading the health log back: "H2 has failed 9 times in the
+// last 3 days while passing in between" is the pattern this makes visible.
+//
+// The records persist in state.json and appear in `status` (and its -json),
+// status.json, the dashboard and, with a heuristic label, the dashboard's
+// Prometheus metrics. One-shot health checks and trace simulation do not
+// record; multi-session monitors keep theirs in memory.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const heuristicWindow = 7 * 24 * time.Hour
+
+type heuristicRecord struct {
+	LastPass     time.Time   `json:"lastPass,omitempty"`
+	LastFail     time.Time   `json:"lastFail,omitempty"`
+	FailingSince time.Time   `json:"failingSince,omitempty"` // first failure of the current run; zero while passing
+	Failures     []time.Time `json:"failures,omitempty"`     // within heuristicWindow, oldest first
+}
+
+func (r heuristicRecord) failing() bool { return !r.FailingSince.IsZero() }
+
+// MarshalJSON leaves unset times out: omitempty keeps a zero time.Time,
+// which a reader would take for a real one.
+func (r heuristicRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		LastPass     *time.Time  `json:"lastPass,omitempty"`
+		LastFail     *time.Time  `json:"lastFail,omitempty"`
+		FailingSince *time.Time  `json:"failingSince,omitempty"`
+		Failures     []time.Time `json:"failures,omitempty"`
+	}{setTime(r.LastPass), setTime(r.LastFail), setTime(r.FailingSince), r.Failures})
+}
+
+// setTime returns t, or nil when it is zero.
+func setTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// String renders the record for `status`, e.g. "passing; 3 failures in the
+// last 7 days, first 2026-03-02 14:00, last 2026-03-04 09:15".
+func (r heuristicRecord) String() string {
+	stamp := func(t time.Time) string { return t.Format("2006-01-02 15:04") }
+	var b strings.Builder
+	if r.failing() {
+		fmt.Fprintf(&b, "FAILING since %s (last pass %s)", stamp(r.FailingSince), formatTime(r.LastPass))
+	} else {
+		b.WriteString("passing")
+	}
+	if n := len(r.Failures); n > 0 {
+		fmt.Fprintf(&b, "; %s in the last 7 days, first %s, last %s", plural(n, "failure", "no failures"), stamp(r.Failures[0]), stamp(r.LastFail))
+	} else if !r.LastFail.IsZero() {
+		fmt.Fprintf(&b, "; last failed %s", stamp(r.LastFail))
+	}
+	return b.String()
+}
+
+// recordHeuristics adds one health check's outcome to the records.
+func (d *daemon) recordHeuristics(res healthResult) {
+	if d.sim != nil {
+		return
+	}
+	outcome := map[string]bool{}
+	for name, pass := range res.Heuristics {
+		outcome[name] = pass
+	}
+	for _, p := range res.Plugins {
+		outcome[p.Name] = p.Pass
+	}
+	now := res.Checked
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.heuristicStats == nil {
+		d.heuristicStats = map[string]heuristicRecord{}
+	}
+	for name, pass := range outcome {
+		r := d.heuristicStats[name]
+		if pass {
+			r.LastPass, r.FailingSince = now, time.Time{}
+		} else {
+			r.LastFail = now
+			r.Failures = append(r.Failures, now)
+			if r.FailingSince.IsZero() {
+				r.FailingSince = now
+			}
+		}
+		for len(r.Failures) > 0 && now.Sub(r.Failures[0]) > heuristicWindow {
+			r.Failures = r.Failures[1:]
+		}
+		d.heuristicStats[name] = r
+	}
+	d.saveState()
+}
+
+// heuristicRecords copies the records for status. Caller holds d.mu.
+func (d *daemon) heuristicRecords() map[string]heuristicRecord {
+	if len(d.heuristicStats) == 0 {
+		return nil
+	}
+	out := make(map[string]heuristicRecord, len(d.heuristicStats))
+	for name, r := range d.heuristicStats {
+		r.Failures = append([]time.Time(nil), r.Failures...)
+		out[name] = r
+	}
+	return out
+}
+
+func builtinHeuristic(name string) bool {
+	return len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '9'
+}
+
+// heuristicLabel is "H2" for a built-in heuristic and the plug-in's name
+// otherwise.
+func heuristicLabel(name string) string {
+	if builtinHeuristic(name) {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+// sortedHeuristics lists record names with h1..h8 first, then plug-ins.
+func sortedHeuristics(records map[string]heuristicRecord) []string {
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if bi, bj := builtinHeuristic(names[i]), builtinHeuristic(names[j]); bi != bj {
+			return bi
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// writeHeuristicMetrics adds the records to the Prometheus text, one series
+// per heuristic.
+func (d *daemon) writeHeuristicMetrics(w io.Writer) {
+	d.mu.Lock()
+	records := d.heuristicRecords()
+	d.mu.Unlock()
+	if len(records) == 0 {
+		return
+	}
+	names := sortedHeuristics(records)
+	series := []struct {
+		name, help, kind string
+		value            func(heuristicRecord) float64
+	}{
+		{"iconcache_heuristic_last_pass_timestamp_seconds", "Time a heuristic last passed (Unix seconds; 0 = never).", "gauge", func(r heuristicRecord) float64 { return unixSeconds(r.LastPass) }},
+		{"iconcache_heuristic_last_fail_timestamp_seconds", "Time a heuristic last failed (Unix seconds; 0 = never).", "gauge", func(r heuristicRecord) float64 { return unixSeconds(r.LastFail) }},
+		{"iconcache_heuristic_failing", "1 while a heuristic has failed since it last passed.", "gauge", func(r heuristicRecord) float64 {
+			if r.failing() {
+				return 1
+			}
+			return 0
+		}},
+		{"iconcache_heuristic_recent_failures", "Failures of a heuristic in the last 7 days.", "gauge", func(r heuristicRecord) float64 { return float64(len(r.Failures)) }},
+	}
+	var b strings.Builder
+	for _, s := range series {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s{heuristic=%q} %g\n", s.name, name, s.value(records[name]))
+		}
+	}
+	io.WriteString(w, b.String())
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
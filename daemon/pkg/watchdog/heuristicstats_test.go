@@ -0,0 +1,39 @@
+// heuristicstats_test.go
+// Per-heuristic track records as status shows them.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeuristicRecord(t *testing.T) {
+	pass := time.Date(2026, 3, 4, 9, 15, 0, 0, time.UTC)
+	fail := pass.Add(-26 * time.Hour)
+
+	// Unset times are left out rather than written as year 1.
+	raw, err := json.Marshal(heuristicRecord{LastPass: pass})
+	if err != nil || string(raw) != `{"lastPass":"2026-03-04T09:15:00Z"}` {
+		t.Fatalf("passing record = %s, %v", raw, err)
+	}
+	var back heuristicRecord
+	if err := json.Unmarshal(raw, &back); err != nil || !back.LastPass.Equal(pass) || !back.LastFail.IsZero() || back.failing() {
+		t.Fatalf("read back = %+v, %v", back, err)
+	}
+
+	r := heuristicRecord{LastPass: pass, LastFail: fail, Failures: []time.Time{fail.Add(-time.Hour), fail}}
+	if got := r.String(); got != "passing; 2 failures in the last 7 days, first 2026-03-03 06:15, last 2026-03-03 07:15" {
+		t.Errorf("passing record = %q", got)
+	}
+	r = heuristicRecord{LastFail: fail}
+	if got := r.String(); got != "passing; last failed 2026-03-03 07:15" {
+		t.Errorf("old failure = %q", got)
+	}
+	r = heuristicRecord{LastPass: pass, LastFail: fail, FailingSince: fail, Failures: []time.Time{fail}}
+	if got := r.String(); !r.failing() || !strings.HasPrefix(got, "FAILING since 2026-03-03 07:15") {
+		t.Errorf("failing record = %q", got)
+	}
+}
@@ -0,0 +1,333 @@
+// history.go
+// A 24-hour history for at-a-glance views: the cache size in 10-minute
+// buckets, the latest events (repairs and their outcome, deferrals,
+// health checks below the threshold, slow repairs, shell rescues, pauses,
+// commands from clients) and how long each direct repair took
+// (repairtime.go). The daemon keeps it in memory and in
+// history.json in the data directory, so it survives restarts. Clients ask
+// for it over the control pipe:
+//
+//   → {"cmd":"history"}
+//   ← {"type":"history","history":{"sizes":[...],"events":[...]}}
+//
+// `history` prints it as a sparkline and an event feed, from the running
+// daemon or, when none answers, from history.json. Single-user mode only;
+// commands other than the daemon itself never record history.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	historyFileName  = "history.json"
+	historySpan      = 24 * time.Hour
+	historyBucket    = 10 * time.Minute
+	historyMaxEvents = 50
+)
+
+// Event kinds besides the finishing repair phases (complete, failed,
+// handed-off).
+const (
+	historyRepair      = "repair"
+	historyDeferred    = "deferred"
+	historyHealth      = "health"
+	historySlow        = "slow"
+	historyIneffective = "ineffective"
+	historyRescue      = "rescue"
+	historyPaused      = "paused"
+	historyResumed     = "resumed"
+	historyIncident    = "incident"
+	historyCommand     = "command"
+	historyCacheDir    = "cachedir"
+	historySoft        = "soft"
+	historyUpdate      = "update"
+	historyBreaker     = "breaker"
+)
+
+type historyPoint struct {
+	At     time.Time `json:"at"`     // bucket start
+	SizeMB float64   `json:"sizeMB"` // largest sample in the bucket
+}
+
+type historyEvent struct {
+	At       time.Time `json:"at"`
+	Kind     string    `json:"kind"`
+	Message  string    `json:"message"`
+	Incident string    `json:"incident,omitempty"` // incident.go
+	By       string    `json:"by,omitempty"`       // command: the client (audit.go)
+}
+
+// historyRepairTime is one direct repair's duration and Explorer downtime.
+type historyRepairTime struct {
+	At                  time.Time `json:"at"`
+	Seconds             float64   `json:"seconds"`
+	ExplorerDownSeconds float64   `json:"explorerDownSeconds"`
+}
+
+type historyView struct {
+	Sizes       []historyPoint      `json:"sizes"`
+	Events      []historyEvent      `json:"events"`
+	RepairTimes []historyRepairTime `json:"repairTimes,omitempty"`
+}
+
+// historyStore is nil outside the daemon; its methods are then no-ops.
+type historyStore struct {
+	mu   sync.Mutex
+	path string
+	view historyView
+}
+
+// loadHistory continues the history in history.json, if any.
+func (d *daemon) loadHistory() *historyStore {
+	h := &historyStore{path: filepath.Join(d.dataDir, historyFileName)}
+	if raw, err := os.ReadFile(h.path); err == nil {
+		if json.Unmarshal(raw, &h.view) != nil {
+			d.watchLog_("WARN", "history.json is unreadable; starting a new history.")
+			h.view = historyView{}
+		}
+	}
+	return h
+}
+
+// snapshot returns a copy of the last 24 hours as of now.
+func (h *historyStore) snapshot(now time.Time) historyView {
+	if h == nil {
+		return historyView{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trim(now)
+	return historyView{
+		Sizes:       append([]historyPoint{}, h.view.Sizes...),
+		Events:      append([]historyEvent{}, h.view.Events...),
+		RepairTimes: append([]historyRepairTime(nil), h.view.RepairTimes...),
+	}
+}
+
+// trim drops entries older than historySpan. Caller holds h.mu.
+func (h *historyStore) trim(now time.Time) {
+	cutoff := now.Add(-historySpan)
+	for len(h.view.Sizes) > 0 && h.view.Sizes[0].At.Before(cutoff) {
+		h.view.Sizes = h.view.Sizes[1:]
+	}
+	for len(h.view.Events) > 0 && h.view.Events[0].At.Before(cutoff) {
+		h.view.Events = h.view.Events[1:]
+	}
+	if n := len(h.view.Events); n > historyMaxEvents {
+		h.view.Events = h.view.Events[n-historyMaxEvents:]
+	}
+	for len(h.view.RepairTimes) > 0 && h.view.RepairTimes[0].At.Before(cutoff) {
+		h.view.RepairTimes = h.view.RepairTimes[1:]
+	}
+	if n := len(h.view.RepairTimes); n > historyMaxEvents {
+		h.view.RepairTimes = h.view.RepairTimes[n-historyMaxEvents:]
+	}
+}
+
+// save writes history.json. Caller holds h.mu.
+func (h *historyStore) save() {
+	if raw, err := json.MarshalIndent(h.view, "", "  "); err == nil {
+		writeFileAtomic(h.path, raw)
+	}
+}
+
+// historySize adds a Layer B sample. The file is written when a new bucket
+// starts, so at most every historyBucket.
+func (d *daemon) historySize(sizeMB float64) {
+	h := d.history
+	if h == nil {
+		return
+	}
+	now := d.clock.Now()
+	start := now.Truncate(historyBucket)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n := len(h.view.Sizes); n > 0 && h.view.Sizes[n-1].At.Equal(start) {
+		h.view.Sizes[n-1].SizeMB = max(h.view.Sizes[n-1].SizeMB, sizeMB)
+		return
+	}
+	h.view.Sizes = append(h.view.Sizes, historyPoint{At: start, SizeMB: sizeMB})
+	h.trim(now)
+	h.save()
+}
+
+// historyEvent records one event, under the open incident if any, and
+// writes the file.
+func (d *daemon) historyEvent(kind, msg string) {
+	d.historyEventIn(d.incidents.id(), kind, msg)
+}
+
+// historyEventIn records one event under the given incident.
+func (d *daemon) historyEventIn(incident, kind, msg string) {
+	d.addHistoryEvent(historyEvent{Kind: kind, Message: msg, Incident: incident})
+}
+
+// addHistoryEvent records e as of now.
+func (d *daemon) addHistoryEvent(e historyEvent) {
+	h := d.history
+	if h == nil {
+		return
+	}
+	now := d.clock.Now()
+	e.At = now
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.view.Events = append(h.view.Events, e)
+	h.trim(now)
+	h.save()
+}
+
+// historyRepairTime records how long a direct repair took.
+func (d *daemon) historyRepairTime(t *repairTimer) {
+	h := d.history
+	if h == nil {
+		return
+	}
+	now := d.clock.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.view.RepairTimes = append(h.view.RepairTimes, historyRepairTime{
+		At: now, Seconds: t.Duration.Seconds(), ExplorerDownSeconds: t.ExplorerOff.Seconds(),
+	})
+	h.trim(now)
+	h.save()
+}
+
+// recordHistory turns the start and end of every repair into events. It
+// subscribes before returning, so no repair started afterwards is missed.
+func (d *daemon) recordHistory() {
+	events := d.progress.subscribe()
+	go func() {
+		defer d.progress.unsubscribe(events)
+		for {
+			var ev controlEvent
+			select {
+			case ev = <-events:
+			case <-d.done:
+				return
+			}
+			switch {
+			case ev.Phase == phaseLaunched:
+				d.historyEventIn(ev.Incident, historyRepair, ev.Reason)
+			case ev.finished():
+				msg := ev.Reason
+				if ev.Error != "" {
+					msg += " (" + ev.Error + ")"
+				}
+				d.historyEventIn(ev.Incident, ev.Phase, msg)
+			}
+		}
+	}()
+}
+
+// sparkline renders the sizes as one character per bucket over the last
+// 24 hours, scaled to the largest; buckets without a sample are blank.
+func sparkline(sizes []historyPoint, now time.Time) string {
+	const bars = "▁▂▃▄▅▆▇█"
+	levels := []rune(bars)
+	slots := int(historySpan / historyBucket)
+	first := now.Truncate(historyBucket).Add(-historySpan + historyBucket)
+	var peak float64
+	for _, p := range sizes {
+		peak = max(peak, p.SizeMB)
+	}
+	line := []rune(strings.Repeat(" ", slots))
+	for _, p := range sizes {
+		i := int(p.At.Sub(first) / historyBucket)
+		if i < 0 || i >= slots {
+			continue
+		}
+		level := 0
+		if peak > 0 {
+			level = min(int(p.SizeMB/peak*float64(len(levels))), len(levels)-1)
+		}
+		line[i] = levels[level]
+	}
+	return string(line)
+}
+
+func cmdHistory(d *daemon, args []string) int {
+	fs := newFlagSet("history")
+	n := fs.Int("n", 20, "events to show")
+	asJSON := fs.Bool("json", false, "print the history as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	view, err := fetchHistory(d)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No history: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		printJSON("history", view)
+		return 0
+	}
+
+	now := d.clock.Now()
+	var peak, last float64
+	for _, p := range view.Sizes {
+		peak = max(peak, p.SizeMB)
+		last = p.SizeMB
+	}
+	fmt.Printf("Cache size, last 24h (peak %.2f MB, latest %.2f MB, limit %s):\n", peak, last, d.cfg.Thresholds.SizeLimit)
+	fmt.Printf("  %s\n", sparkline(view.Sizes, now))
+	fmt.Printf("  %-*s%s\n", int(historySpan/historyBucket)-3, now.Add(-historySpan).Local().Format("15:04"), "now")
+	if len(view.RepairTimes) > 0 {
+		var took, down []float64
+		for _, r := range view.RepairTimes {
+			took = append(took, r.Seconds)
+			down = append(down, r.ExplorerDownSeconds)
+		}
+		fmt.Printf("Repairs, last 24h: %d, took p50 %.1fs / p95 %.1fs, Explorer down p50 %.1fs / p95 %.1fs.\n",
+			len(took), percentile(took, 0.5), percentile(took, 0.95), percentile(down, 0.5), percentile(down, 0.95))
+	}
+
+	events := view.Events
+	if len(events) > *n {
+		events = events[len(events)-*n:]
+	}
+	if len(events) == 0 {
+		fmt.Println("No events in the last 24h.")
+		return 0
+	}
+	fmt.Println("Events:")
+	for _, e := range events {
+		msg := e.Message
+		if e.Incident != "" {
+			msg += " [" + e.Incident + "]"
+		}
+		fmt.Printf("  %s  %-10s %s\n", e.At.Local().Format("01-02 15:04"), e.Kind, msg)
+	}
+	return 0
+}
+
+// fetchHistory asks the running daemon, else reads history.json.
+func fetchHistory(d *daemon) (historyView, error) {
+	if c, err := openControl(d.controlAddr()); err == nil {
+		defer c.Close()
+		ev, err := c.call(controlRequest{Cmd: requestHistory})
+		if err != nil {
+			return historyView{}, err
+		}
+		if ev.History != nil {
+			return *ev.History, nil
+		}
+	}
+	raw, err := os.ReadFile(filepath.Join(d.dataDir, historyFileName))
+	if err != nil {
+		return historyView{}, err
+	}
+	var view historyView
+	if err := json.Unmarshal(raw, &view); err != nil {
+		return historyView{}, err
+	}
+	return (&historyStore{view: view}).snapshot(d.clock.Now()), nil
+}
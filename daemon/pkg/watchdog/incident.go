@@ -0,0 +1,347 @@
+// incident.go
+// Incident grouping. One cache problem used to reach monitoring as four
+// unrelated signals: the size or health trigger, the repair starting, the
+// repair ending and, for size repairs, the verification. The daemon now
+// opens an incident at the first trigger and files everything that follows
+// under its ID until the problem is gone:
+//
+//   - a size trigger waits for a poll that finds the cache back under
+//     thresholds.sizeLimit with no size repair awaiting verification;
+//   - a health trigger waits for a health check at or above
+//     health.repairBelowScore;
+//   - a repair request (any trigger, or remote, spooled and manual ones)
+//     waits for the repair to complete or be handed to the broker or task.
+//
+// Triggers while an incident is open join it. The incident is resolved once
+// nothing is awaited, and closed unresolved when a requested repair cannot
+// start, fails with nothing else to wait for, size repairs are suspended
+// (sizeverify.go) or it is still open after incidents.maxOpen.
+//
+// The ID ("20260304-141502-9f3a") appears in the INCIDENT lines that open
+// and close it, on the "Repair triggered" line, in the text of the related
+// Application log events, on history events and progress events, and in
+// `status`. With incidents.webhook set, the opening and the closing are
+// POSTed as JSON (schema "incident", the closing with the whole timeline),
+// in order and in the background; a failing webhook is logged once.
+//
+// Only the single-user daemon groups incidents; they are not kept across
+// restarts. One-shot commands, multi-session monitors and trace simulation
+// have no tracker, and every method is a no-op on a nil tracker.
+
+package watchdog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type incidentOptions struct {
+	Enabled bool     `json:"enabled"`
+	Webhook string   `json:"webhook"` // http(s) URL to POST openings and closings to; empty: none
+	Timeout duration `json:"timeout"` // webhook request timeout
+	MaxOpen duration `json:"maxOpen"` // close unresolved after this
+}
+
+func defaultIncidentOptions() incidentOptions {
+	return incidentOptions{Enabled: true, Timeout: duration(10 * time.Second), MaxOpen: duration(6 * time.Hour)}
+}
+
+// What an incident waits for.
+const (
+	incidentSize   = "size"
+	incidentHealth = "health"
+	incidentRepair = "repair"
+)
+
+// Timeline step kinds; the repair phases (launched, complete, failed,
+// handed-off) are used as they are.
+const (
+	stepTrigger     = "trigger"
+	stepRequested   = "requested"
+	stepSkipped     = "skipped"
+	stepDeferred    = "deferred"
+	stepVerified    = "verified"
+	stepIneffective = "ineffective"
+	stepSoftRepair  = "soft-repair"
+	stepCleared     = "cleared"
+	stepClosed      = "closed"
+)
+
+// incidentQueue is the number of webhook posts that may wait for delivery.
+const incidentQueue = 32
+
+type incidentStep struct {
+	At      time.Time `json:"at"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+}
+
+type incident struct {
+	ID       string         `json:"id"`
+	Opened   time.Time      `json:"opened"`
+	Closed   time.Time      `json:"closed,omitempty"`
+	Cause    string         `json:"cause"`              // the trigger that opened it
+	Outcome  string         `json:"outcome,omitempty"`  // resolved or unresolved, once closed
+	Triggers int            `json:"triggers"`           // including the one that opened it
+	Awaiting []string       `json:"awaiting,omitempty"` // size, health, repair
+	Timeline []incidentStep `json:"timeline"`
+}
+
+// String renders an open incident for `status`, e.g. "20260304-141502-9f3a,
+// opened 14:15:02, awaiting [size repair]: Cache is 812.40 MB > 500MB
+// threshold.".
+func (inc incident) String() string {
+	return fmt.Sprintf("%s, opened %s, awaiting %v: %s", inc.ID, inc.Opened.Format("15:04:05"), inc.Awaiting, inc.Cause)
+}
+
+func (inc *incident) add(at time.Time, kind, msg string) {
+	inc.Timeline = append(inc.Timeline, incidentStep{At: at, Kind: kind, Message: msg})
+}
+
+func (inc *incident) copy() incident {
+	c := *inc
+	c.Awaiting = slices.Clone(inc.Awaiting)
+	c.Timeline = slices.Clone(inc.Timeline)
+	return c
+}
+
+// incidentNotice is the webhook payload.
+type incidentNotice struct {
+	Event    string   `json:"event"` // opened or closed
+	Computer string   `json:"computer"`
+	User     string   `json:"user"`
+	Incident incident `json:"incident"`
+}
+
+type incidentTracker struct {
+	d       *daemon
+	mu      sync.Mutex
+	open    *incident
+	queue   chan []byte // webhook posts; nil without incidents.webhook
+	failing atomic.Bool
+}
+
+// startIncidents sets up the tracker of the single-user daemon.
+func (d *daemon) startIncidents() {
+	if !d.cfg.Incidents.Enabled || d.sim != nil {
+		return
+	}
+	t := &incidentTracker{d: d}
+	if d.cfg.Incidents.Webhook != "" {
+		t.queue = make(chan []byte, incidentQueue)
+		go t.deliver()
+	}
+	d.incidents = t
+}
+
+func newIncidentID(now time.Time) string {
+	b := make([]byte, 2)
+	rand.Read(b)
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// id is the open incident's ID, or "" when none is open.
+func (t *incidentTracker) id() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open == nil {
+		return ""
+	}
+	return t.open.ID
+}
+
+// snapshot copies the open incident for status.
+func (t *incidentTracker) snapshot() *incident {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open == nil {
+		return nil
+	}
+	c := t.open.copy()
+	return &c
+}
+
+// trigger opens an incident for cause, or adds cause to the open one, and
+// makes it wait for cond.
+func (t *incidentTracker) trigger(cond, cause string) {
+	t.attach(cond, stepTrigger, cause)
+}
+
+// request records a repair request: it joins the open incident, or opens
+// one when the request is the first sign of trouble (a remote, spooled or
+// manual repair).
+func (t *incidentTracker) request(reason string) {
+	t.attach(incidentRepair, stepRequested, reason)
+}
+
+func (t *incidentTracker) attach(cond, kind, msg string) {
+	if t == nil {
+		return
+	}
+	now := t.d.clock.Now()
+	t.mu.Lock()
+	inc := t.open
+	opened := inc == nil
+	if opened {
+		inc = &incident{ID: newIncidentID(now), Opened: now, Cause: msg}
+		kind = stepTrigger
+		t.open = inc
+	}
+	if kind == stepTrigger {
+		inc.Triggers++
+	}
+	if !slices.Contains(inc.Awaiting, cond) {
+		inc.Awaiting = append(inc.Awaiting, cond)
+	}
+	inc.add(now, kind, msg)
+	c := inc.copy()
+	t.mu.Unlock()
+	if opened {
+		t.announce(c)
+	}
+}
+
+// step adds a step to the open incident's timeline.
+func (t *incidentTracker) step(kind, msg string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open != nil {
+		t.open.add(t.d.clock.Now(), kind, msg)
+	}
+}
+
+// settle ends the wait for cond; resolved tells whether it ended well. The
+// incident closes, with that outcome, when nothing else is awaited.
+func (t *incidentTracker) settle(cond, kind, msg string, resolved bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	inc := t.open
+	if inc == nil || !slices.Contains(inc.Awaiting, cond) {
+		t.mu.Unlock()
+		return
+	}
+	inc.add(t.d.clock.Now(), kind, msg)
+	inc.Awaiting = slices.DeleteFunc(inc.Awaiting, func(c string) bool { return c == cond })
+	if len(inc.Awaiting) > 0 {
+		t.mu.Unlock()
+		return
+	}
+	t.closeLocked(msg, resolved)
+}
+
+// fail closes the open incident unresolved.
+func (t *incidentTracker) fail(msg string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.open == nil {
+		t.mu.Unlock()
+		return
+	}
+	t.closeLocked(msg, false)
+}
+
+// expire closes an incident open for longer than incidents.maxOpen. Called
+// on every Layer B poll.
+func (t *incidentTracker) expire() {
+	if t == nil {
+		return
+	}
+	max := t.d.cfg.Incidents.MaxOpen
+	t.mu.Lock()
+	if t.open == nil || t.d.clock.Since(t.open.Opened) < max.D() {
+		t.mu.Unlock()
+		return
+	}
+	t.closeLocked(fmt.Sprintf("still awaiting %v after %s (incidents.maxOpen)", t.open.Awaiting, max), false)
+}
+
+// closeLocked closes the open incident and announces it. Called with t.mu
+// held; it unlocks.
+func (t *incidentTracker) closeLocked(msg string, resolved bool) {
+	inc := t.open
+	inc.Closed, inc.Outcome = t.d.clock.Now(), "unresolved"
+	if resolved {
+		inc.Outcome = "resolved"
+	}
+	inc.add(inc.Closed, stepClosed, msg)
+	t.open = nil
+	c := inc.copy()
+	t.mu.Unlock()
+	t.announce(c)
+}
+
+// announce logs an opened or closed incident, files it in the history and
+// queues it for the webhook. Called without t.mu, so the log and history
+// may ask for the ID.
+func (t *incidentTracker) announce(inc incident) {
+	d := t.d
+	event := "opened"
+	if inc.Closed.IsZero() {
+		d.metrics.inc(mIncidentsOpened)
+		d.watchLog_("INCIDENT", fmt.Sprintf("Incident %s opened: %s", inc.ID, inc.Cause))
+		d.historyEventIn(inc.ID, historyIncident, "opened: "+inc.Cause)
+	} else {
+		event = "closed"
+		last := inc.Timeline[len(inc.Timeline)-1].Message
+		took := duration(inc.Closed.Sub(inc.Opened).Truncate(time.Second))
+		level := "INCIDENT"
+		if inc.Outcome != "resolved" {
+			d.metrics.inc(mIncidentsUnresolved)
+			level = "WARN"
+		}
+		d.watchLog_(level, fmt.Sprintf("Incident %s %s after %s (%s, %s): %s", inc.ID, inc.Outcome, took,
+			plural(inc.Triggers, "trigger", "no triggers"), plural(len(inc.Timeline), "step", "no steps"), last))
+		d.historyEventIn(inc.ID, historyIncident, inc.Outcome+": "+last)
+	}
+	if t.queue == nil {
+		return
+	}
+	computer, user := reportIdentity()
+	raw, _ := json.Marshal(incidentNotice{Event: event, Computer: computer, User: user, Incident: inc})
+	select {
+	case t.queue <- stampJSON("incident", raw):
+	default:
+		d.watchLog_("WARN", fmt.Sprintf("Incident webhook is %d posts behind; dropping the %s notice of %s.", incidentQueue, event, inc.ID))
+	}
+}
+
+// deliver posts the queued notices in order until the daemon stops.
+func (t *incidentTracker) deliver() {
+	o := t.d.cfg.Incidents
+	for {
+		select {
+		case raw := <-t.queue:
+			t.d.sinkResult("incident webhook", &t.failing, postJSON(o.Webhook, o.Timeout.D(), raw))
+		case <-t.d.done:
+			return
+		}
+	}
+}
+
+// incidentSuffix is " (incident <id>)" while an incident is open, for the
+// text of related log lines and events.
+func (d *daemon) incidentSuffix() string {
+	if id := d.incidents.id(); id != "" {
+		return " (incident " + id + ")"
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+// install.go
+// `install` and `uninstall`: silent entry points for package managers
+// (winget, Chocolatey) and software distribution. They run
+// scripts\Register-Tasks.ps1 and scripts\Unregister-Tasks.ps1 from the
+// package without a window or prompt and append the script output to
+// Install.log in the log directory. /S (also /silent, /quiet, /q) keeps the
+// console quiet as well; without it the output is echoed.
+//
+// MSI custom actions call --install-phase=register|unregister, which main
+// turns into `install /S` and `uninstall /S` (overrides.go). Deferred
+// actions run as SYSTEM, so install takes -user=DOMAIN\name ([LogonUser]) to
+// register the tasks for that user. Both phases are idempotent, and a
+// register that fails is rolled back by unregistering, so the machine is
+// left uninstalled rather than half registered.
+//
+// -profile=NAME (also --profile) selects a configuration preset for the
+// machine class (presets.go): the scheduled tasks start the daemon with
+// --preset=NAME, so the config file can stay shared across machine classes.
+//
+// Exit codes are fixed so packages can declare them:
+//
+//	0     installed / uninstalled (uninstalling twice also returns 0)
+//	2     unknown argument
+//	740   not elevated (ERROR_ELEVATION_REQUIRED)
+//	1603  the script failed; details in Install.log (ERROR_INSTALL_FAILURE)
+
+package watchdog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	exitElevationRequired = 740
+	exitInstallFailure    = 1603
+	installLogName        = "Install.log"
+)
+
+func cmdInstall(d *daemon, args []string) int {
+	var rest, params []string
+	for _, a := range args {
+		if len(a) > 6 && strings.EqualFold(a[:6], "-user=") {
+			params = append(params, "-User", a[6:])
+			continue
+		}
+		if name, ok := installProfile(a); ok {
+			if _, known := presets[name]; !known {
+				fmt.Fprintf(os.Stderr, "unknown profile %q (%s)\n", name, strings.Join(presetNames(), ", "))
+				return 2
+			}
+			params = append(params, "-Preset", name)
+			continue
+		}
+		rest = append(rest, a)
+	}
+	code := d.runInstaller("install", "Register-Tasks.ps1", rest, params...)
+	if code == exitInstallFailure {
+		d.runInstaller("rollback", "Unregister-Tasks.ps1", rest, "-CallerPid", strconv.Itoa(os.Getpid()))
+	}
+	return code
+}
+
+// installProfile recognizes -profile=NAME and --profile=NAME.
+func installProfile(arg string) (string, bool) {
+	a := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+	if len(a) > 8 && strings.EqualFold(a[:8], "profile=") {
+		return strings.ToLower(a[8:]), true
+	}
+	return "", false
+}
+
+func cmdUninstall(d *daemon, args []string) int {
+	return d.runInstaller("uninstall", "Unregister-Tasks.ps1", args, "-CallerPid", strconv.Itoa(os.Getpid()))
+}
+
+// runInstaller runs one of the task scripts elevated-only and maps its
+// outcome onto the exit codes above.
+func (d *daemon) runInstaller(name, script string, args []string, params ...string) int {
+	silent := false
+	for _, a := range args {
+		switch strings.ToLower(a) {
+		case "/s", "/silent", "/quiet", "/q", "-s", "--silent":
+			silent = true
+		default:
+			usage := name + " [/S]"
+			if name == "install" {
+				usage += ` [-user=DOMAIN\name] [-profile=laptop|desktop|vdi]`
+			}
+			fmt.Fprintf(os.Stderr, "usage: %s\n", usage)
+			return 2
+		}
+	}
+
+	var out io.Writer = io.Discard
+	os.MkdirAll(d.logDir, 0755)
+	if f, err := os.OpenFile(filepath.Join(d.logDir, installLogName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+		defer f.Close()
+		out = f
+	}
+	if !silent {
+		out = io.MultiWriter(out, os.Stdout)
+	}
+	logLine := func(level, msg string) {
+		fmt.Fprintf(out, "[%s][%s] %s\n", d.clock.Now().Format("2006-01-02 15:04:05"), level, msg)
+	}
+
+	if !isElevated() {
+		logLine("ERROR", fmt.Sprintf("%s must run elevated (exit %d).", name, exitElevationRequired))
+		return exitElevationRequired
+	}
+	path := filepath.Join(d.rootDir, "scripts", script)
+	if _, err := os.Stat(path); err != nil {
+		logLine("ERROR", fmt.Sprintf("%s: %v (exit %d)", name, err, exitInstallFailure))
+		return exitInstallFailure
+	}
+
+	cmd := installerCommand(findPowerShell(d.cfg.PowerShell.Path), path, params...)
+	logLine("INFO", fmt.Sprintf("--- %s: %s ---", name, commandLine(cmd.Path, cmd.Args[1:])))
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Run(); err != nil {
+		logLine("ERROR", fmt.Sprintf("%s failed: %v (exit %d)", name, err, exitInstallFailure))
+		return exitInstallFailure
+	}
+	logLine("INFO", fmt.Sprintf("%s complete.", name))
+	return 0
+}
+
+// installerCommand runs script hidden and non-interactive. Unlike the repair
+// script it never runs in ConstrainedLanguage mode: registration needs .NET
+// and WMI types.
+func installerCommand(exe, script string, params ...string) *exec.Cmd {
+	args := append([]string{
+		"-NoLogo", "-NoProfile", "-NonInteractive",
+		"-ExecutionPolicy", "Bypass",
+		"-File", script,
+	}, params...)
+	cmd := exec.Command(exe, args...)
+	cmd.SysProcAttr = sysProcAttr()
+	return cmd
+}
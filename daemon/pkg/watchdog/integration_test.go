@@ -0,0 +1,4524 @@
+//go:build integration
+
+// integration_test.go
+// End-to-end tests: a real daemon against a sandbox cache directory, a stub
+// explorer process and a mock repair script. Nothing outside t.TempDir() is
+// touched, so the suite is safe on developer machines and CI runners.
+//
+//   go test -tags integration ./...
+//
+// The test binary doubles as the helper processes: TestMain dispatches on
+// its own file name, so a copy named fake-pwsh(.exe) plays PowerShell and a
+// copy named explorer(.exe) plays the shell (visible to tasklist on Windows).
+// Time is driven by fakeClock, so cooldowns, staleness and tickers are
+// exercised by advancing it rather than by waiting.
+//
+// Only scenarios that need the sandbox belong here. Parsers, framing and
+// range checks are tested without the tag, in the _test.go file of their
+// source (schedule_test.go, protocol_test.go, ...).
+
+package watchdog
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	iconcachev1 "icon-cache-watchdog/pkg/api/iconcache/v1"
+)
+
+const (
+	fakePowerShellName = "fake-pwsh"
+	fakeExplorerName   = "explorer"
+	cacheDirEnv        = "ICW_TEST_CACHE_DIR" // inherited by fake-pwsh
+	repairsFile        = "repairs.log"        // one line per mock repair, in dataDir
+	sizeLimitMB        = int(defaultSizeLimit / mib)
+
+	fakePluginName = "fake-plugin"
+	pluginPassEnv  = "ICW_TEST_PLUGIN_PASS" // "0" fails the fake plug-in's check
+	pluginLogEnv   = "ICW_TEST_PLUGIN_LOG"  // fake plug-in repair actions, one per line
+
+	fakeIe4uinitName = "ie4uinit"
+	ie4uinitLogEnv   = "ICW_TEST_IE4UINIT_LOG" // fake ie4uinit.exe runs, one per line; unset fails them
+	stopRungEnv      = "ICW_TEST_STOP_RUNG"    // how fake-pwsh reports Explorer stopped; default force
+)
+
+func TestMain(m *testing.M) {
+	switch strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") {
+	case fakePowerShellName:
+		os.Exit(fakePowerShell(os.Args[1:]))
+	case fakeExplorerName:
+		time.Sleep(time.Hour) // killed by the harness
+		os.Exit(0)
+	case fakePluginName:
+		os.Exit(fakePlugin())
+	case fakeIe4uinitName:
+		os.Exit(appendLine(os.Getenv(ie4uinitLogEnv), strings.Join(os.Args[1:], " ")))
+	}
+	os.Exit(m.Run())
+}
+
+// fakePowerShell stands in for the interpreter: it checks that it was asked
+// to run the mock script, rebuilds the sandbox cache the way Explorer would
+// after a repair and records the request.
+func fakePowerShell(args []string) int {
+	var script string
+	params := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-File" && i+1 < len(args):
+			script = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "-") && script != "" && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-"):
+			params[args[i]] = args[i+1]
+			i++
+		}
+	}
+	body, err := os.ReadFile(script)
+	if err != nil || !strings.Contains(string(body), "mock repair") {
+		fmt.Fprintf(os.Stderr, "fake-pwsh: unexpected script %q\n", script)
+		return 1
+	}
+	cache := params["-CachePath"]
+	if cache == "" {
+		cache = os.Getenv(cacheDirEnv)
+	}
+	fmt.Println("##progress explorer-stopping")
+	rung := os.Getenv(stopRungEnv)
+	if rung == "" || slices.Contains(args, "-RestartExplorer") {
+		rung = rungForce
+	}
+	fmt.Printf("%s%s 0.4\n", explorerStopPrefix, rung)
+	if slices.Contains(args, "-RestartExplorer") {
+		fmt.Println("##progress explorer-starting")
+		return appendLine(filepath.Join(params["-DataDir"], repairsFile), params["-Reason"])
+	}
+	patterns := defaultCacheFileOptions().Icon
+	if p := params["-IconPatterns"]; p != "" {
+		patterns = strings.Split(p, "|")
+	}
+	if tiers := params["-Tiers"]; tiers != "" {
+		// Explorer rebuilds the tiers and the index at their old sizes.
+		for _, f := range append(strings.Split(tiers, "|"), tierIndexFile) {
+			os.Remove(filepath.Join(cache, f))
+			if err := os.WriteFile(filepath.Join(cache, f), make([]byte, 4096), 0644); err != nil {
+				return 1
+			}
+		}
+		fmt.Println("##progress explorer-starting")
+		return appendLine(filepath.Join(params["-DataDir"], repairsFile), params["-Reason"])
+	}
+	old := cacheFileNames(cache, patterns)
+	for i, f := range old {
+		os.Remove(filepath.Join(cache, f))
+		fmt.Printf("##progress files-deleting %d/%d\n", i+1, len(old))
+	}
+	if slices.Contains(args, "-IncludeThumbcache") {
+		for _, f := range cacheFileNames(cache, defaultCacheFileOptions().Thumbnail) {
+			os.Remove(filepath.Join(cache, f))
+		}
+	}
+	if mods := params["-ModCaches"]; mods != "" {
+		for _, f := range strings.Split(mods, "|") {
+			os.Remove(f)
+		}
+	}
+	fmt.Println("##progress explorer-starting")
+	if err := writeHealthyCache(cache); err != nil {
+		fmt.Fprintln(os.Stderr, "fake-pwsh:", err)
+		return 1
+	}
+	return appendLine(filepath.Join(params["-DataDir"], repairsFile), params["-Reason"])
+}
+
+// fakePlugin answers one plug-in request (plugins.go) as "vendor".
+func fakePlugin() int {
+	var req pluginRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintln(os.Stderr, "fake-plugin:", err)
+		return 1
+	}
+	reply := pluginReply{}
+	switch req.Call {
+	case pluginDescribe:
+		reply = pluginReply{Protocol: pluginProtocol, Name: "vendor", Heuristic: true, Repair: true}
+	case pluginCheck:
+		reply.Pass = os.Getenv(pluginPassEnv) != "0"
+		reply.Message = "vendor cache checked"
+	case pluginRepair:
+		if appendLine(os.Getenv(pluginLogEnv), req.Reason) != 0 {
+			return 1
+		}
+		reply = pluginReply{OK: true, Message: "vendor cache cleared"}
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(reply); err != nil { // one line
+		return 1
+	}
+	return 0
+}
+
+func appendLine(path, line string) int {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+	return 0
+}
+
+// writeHealthyCache creates a cache that passes H1–H4.
+func writeHealthyCache(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files := map[string]int{
+		"iconcache_idx.db":  4096,
+		"iconcache_16.db":   64 * 1024,
+		"iconcache_32.db":   128 * 1024,
+		"iconcache_48.db":   256 * 1024,
+		"iconcache_256.db":  512 * 1024,
+		"iconcache_wide.db": 32 * 1024,
+	}
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// FAKE CLOCK
+// ---------------------------------------------------------------------------
+
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	clock   *fakeClock
+	c       chan time.Time
+	every   time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func newFakeClock(now time.Time) *fakeClock { return &fakeClock{now: now} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration { return c.Now().Sub(t) }
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), every: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward, firing due tickers. Like time.Ticker,
+// ticks are dropped while the previous one has not been received.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.stopped && !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.every)
+		}
+	}
+}
+
+// waitTickers blocks until n tickers exist, i.e. a loop under test has
+// set up its schedule.
+func (c *fakeClock) waitTickers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		got := len(c.tickers)
+		c.mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d tickers", n)
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	t.stopped = true
+	t.clock.mu.Unlock()
+}
+
+// ---------------------------------------------------------------------------
+// HARNESS
+// ---------------------------------------------------------------------------
+
+type harness struct {
+	t     *testing.T
+	d     *daemon
+	clock *fakeClock
+	root  string
+	cache string
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	root := t.TempDir()
+	cache := filepath.Join(t.TempDir(), "Explorer")
+	for _, dir := range []string{"bin", "scripts"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := filepath.Join(root, "scripts", "Repair-IconCache.ps1")
+	if err := os.WriteFile(script, []byte("# mock repair script for integration tests\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pwsh := copySelf(t, filepath.Join(root, "bin", fakePowerShellName))
+	explorer := copySelf(t, filepath.Join(root, "bin", fakeExplorerName))
+	if err := writeHealthyCache(cache); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(cacheDirEnv, cache)
+
+	shell := exec.Command(explorer)
+	if err := shell.Start(); err != nil {
+		t.Fatalf("start stub explorer: %v", err)
+	}
+	t.Cleanup(func() {
+		shell.Process.Kill()
+		shell.Wait()
+	})
+
+	cfg := defaultConfig()
+	cfg.PowerShell.Path = pwsh
+	cfg.Quiet.RespectFocusAssist = false
+	cfg.Quiet.HoldDuringFullScreen = false
+	cfg.Network.HoldWhileOffline = false
+	cfg.Thresholds.MinFreeSpace = 0
+
+	clock := newFakeClock(time.Now())
+	dataDir := filepath.Join(root, "data")
+	d := &daemon{
+		cfg:          cfg,
+		configFile:   filepath.Join(root, configFileName),
+		rootDir:      root,
+		dataDir:      dataDir,
+		cacheDir:     cache,
+		repairScript: script,
+		stateFile:    filepath.Join(dataDir, "state.json"),
+		logDir:       filepath.Join(dataDir, "logs"),
+		watchLog:     filepath.Join(dataDir, "logs", "Watchdog.log"),
+		healthLog:    filepath.Join(dataDir, "logs", "IconCacheHealth.log"),
+		unifiedLog:   filepath.Join(dataDir, "logs", unifiedLogName),
+		progress:     newProgressHub(),
+		logs:         newLogFeed(),
+		metrics:      newMetricsRegistry(),
+		started:      clock.Now(),
+		profile:      profileInfo{Type: profileLocal},
+		clock:        clock,
+	}
+	if err := os.MkdirAll(d.logDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &harness{t: t, d: d, clock: clock, root: root, cache: cache}
+}
+
+// copySelf copies the running test binary to path (adding .exe on Windows).
+func copySelf(t *testing.T, path string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		path += ".exe"
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.Open(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// bloat grows iconcache_256.db to sizeMB.
+func (h *harness) bloat(sizeMB int) {
+	h.t.Helper()
+	if err := os.Truncate(filepath.Join(h.cache, "iconcache_256.db"), int64(sizeMB)<<20); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// repairs returns the reasons recorded by fake-pwsh so far.
+func (h *harness) repairs() []string {
+	raw, err := os.ReadFile(filepath.Join(h.d.dataDir, repairsFile))
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(raw)), "\n")
+}
+
+// waitRepairs waits for the asynchronously launched repair script.
+func (h *harness) waitRepairs(n int) []string {
+	h.t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		if r := h.repairs(); len(r) >= n {
+			return r
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	h.t.Fatalf("expected %d repair(s), got %q", n, h.repairs())
+	return nil
+}
+
+// noRepairs asserts that no repair beyond the first n is launched.
+func (h *harness) noRepairs(n int) {
+	h.t.Helper()
+	time.Sleep(500 * time.Millisecond)
+	if r := h.repairs(); len(r) != n {
+		h.t.Fatalf("expected %d repair(s), got %q", n, r)
+	}
+}
+
+// startControl serves the control pipe and waits until it accepts.
+func (h *harness) startControl() {
+	h.t.Helper()
+	go h.d.serveControl()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		conn, err := dialControl(h.d.controlAddr())
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("control pipe: %v", err)
+		}
+	}
+}
+
+func (h *harness) assertLog(file, level, substr string) {
+	h.t.Helper()
+	raw, _ := os.ReadFile(file)
+	for _, line := range strings.Split(string(raw), "\n") {
+		if strings.Contains(line, "]["+level+"] ") && strings.Contains(line, substr) {
+			return
+		}
+	}
+	h.t.Fatalf("%s: no [%s] line containing %q in:\n%s", filepath.Base(file), level, substr, raw)
+}
+
+// ---------------------------------------------------------------------------
+// TESTS
+// ---------------------------------------------------------------------------
+
+func TestIntegrationHealthyCachePasses(t *testing.T) {
+	h := newHarness(t)
+	h.d.checkSize()
+	h.d.checkHealth()
+	h.d.writeStatus()
+
+	h.assertLog(h.d.healthLog, "PASS", "ALL HEURISTICS PASSED")
+	h.noRepairs(0)
+
+	raw, err := os.ReadFile(h.d.statusFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r statusReport
+	if err := json.Unmarshal(raw, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.HealthScore != 100 {
+		t.Fatalf("status health score = %v, want 100", r.HealthScore)
+	}
+}
+
+func TestIntegrationPerFileDelta(t *testing.T) {
+	h := newHarness(t)
+	h.d.checkSize() // baseline
+	h.clock.Advance(10 * time.Minute)
+	h.bloat(sizeLimitMB / 2)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "INFO", "Cache grew: iconcache_256.db +")
+
+	h.clock.Advance(10 * time.Minute)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.assertLog(h.d.watchLog, "TRIGGER", "Growing: iconcache_256.db +")
+	if g := h.d.growthByFile(); len(g) != 1 || g[0].Name != "iconcache_256.db" || g[0].MBPerHour <= 0 {
+		t.Fatalf("growth by file = %+v", g)
+	}
+}
+
+func TestIntegrationUSNJournal(t *testing.T) {
+	at := time.Date(2026, 3, 2, 14, 2, 11, 0, time.Local)
+	got := summarizeUSN([]usnChange{
+		{"iconcache_256.db", 0x2, at.Add(-time.Minute)},
+		{"iconcache_256.db", 0x1 | 0x80000000, at},
+		{"iconcache_idx.db", 0x200, at},
+	})
+	if want := "2 cache files changed: iconcache_256.db (overwrite+extend, last 2026-03-02 14:02:11), iconcache_idx.db (delete, last 2026-03-02 14:02:11)"; got != want {
+		t.Fatalf("summary = %q", got)
+	}
+
+	h := newHarness(t)
+	h.d.cfg.USNJournal.Enabled = true
+	h.d.usnCatchUp()
+	if runtime.GOOS != "windows" {
+		// Without a journal every poll scans, so Layer B is unaffected.
+		h.assertLog(h.d.watchLog, "WARN", "USN journal unavailable")
+		h.bloat(sizeLimitMB + 8)
+		h.d.checkSize()
+		h.waitRepairs(1)
+	}
+}
+
+func TestIntegrationBackupParams(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Backup = backupOptions{Enabled: true, Keep: 5, ShadowCopy: true}
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	_, user := reportIdentity()
+	dir := filepath.Join(h.d.dataDir, backupDirName, user)
+	h.assertLog(h.d.watchLog, "INFO", fmt.Sprintf("-BackupDir %s -BackupKeep 5 -ShadowCopy", dir))
+}
+
+func TestIntegrationRollback(t *testing.T) {
+	h := newHarness(t)
+	if code := cmdRollback(h.d, nil); code != 1 {
+		t.Fatalf("rollback without backups exited %d, want 1", code)
+	}
+	for _, name := range []string{"20261014-080000", "20261015-091502"} {
+		dir := filepath.Join(h.d.backupDir(), name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "iconcache_256.db"), make([]byte, 1024), 0644); err != nil {
+			t.Fatal(err)
+		}
+		manifest := "\xef\xbb\xbf" + `{"created":"2026-10-15T09:15:02.0000000Z","reason":"size","complete":true,` +
+			`"files":[{"name":"iconcache_256.db","size":1024,"shadowCopy":false}]}`
+		if err := os.WriteFile(filepath.Join(dir, backupManifestName), []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code := cmdRollback(h.d, []string{"-backup", "19990101-000000"}); code != 1 {
+		t.Fatalf("rollback to a missing backup exited %d, want 1", code)
+	}
+
+	// With the daemon up, the restore runs through it and starts its cooldown.
+	h.startControl()
+	if code := cmdRollback(h.d, nil); code != 0 {
+		t.Fatalf("rollback exited %d", code)
+	}
+	if got := h.waitRepairs(1); got[0] != "rollback to backup 20261015-091502 (taken before: size)" {
+		t.Fatalf("reason = %q", got[0])
+	}
+	h.assertLog(h.d.watchLog, "INFO", "-RestoreFrom "+filepath.Join(h.d.backupDir(), "20261015-091502"))
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "WARN", "Cooldown active")
+	h.noRepairs(1)
+}
+
+func TestIntegrationHistory(t *testing.T) {
+	h := newHarness(t)
+	h.d.history = h.d.loadHistory()
+	h.d.recordHistory()
+	h.startControl()
+
+	h.d.checkSize()
+	h.clock.Advance(historyBucket)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	var view historyView
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		var err error
+		if view, err = fetchHistory(h.d); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(view.Events); n > 0 && view.Events[n-1].Kind == phaseComplete {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no complete event: %+v", view.Events)
+		}
+	}
+	if len(view.Sizes) != 2 || view.Sizes[1].SizeMB <= defaultSizeLimit.MB() {
+		t.Fatalf("sizes = %+v", view.Sizes)
+	}
+	if view.Events[0].Kind != historyRepair {
+		t.Fatalf("events = %+v", view.Events)
+	}
+	if line := sparkline(view.Sizes, h.clock.Now()); !strings.HasSuffix(line, "▁█") {
+		t.Fatalf("sparkline ends %q", line[len(line)-8:])
+	}
+
+	// history.json carries it across restarts and ages out after a day.
+	if got := h.d.loadHistory().snapshot(h.clock.Now()); len(got.Events) != len(view.Events) {
+		t.Fatalf("reloaded %d events, want %d", len(got.Events), len(view.Events))
+	}
+	if got := h.d.loadHistory().snapshot(h.clock.Now().Add(historySpan + historyBucket)); len(got.Sizes)+len(got.Events) != 0 {
+		t.Fatalf("kept %+v after 24h", got)
+	}
+	if code := cmdHistory(h.d, []string{"-n", "5"}); code != 0 {
+		t.Fatalf("history exited %d", code)
+	}
+}
+
+func TestIntegrationDashboard(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.UI = uiOptions{Enabled: true, Port: 0}
+	h.d.history = h.d.loadHistory()
+	h.d.serveDashboard()
+	if h.d.dashboard == nil {
+		t.Fatal("dashboard not started")
+	}
+	h.startControl()
+	c, err := openControl(h.d.controlAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := c.call(controlRequest{Cmd: requestDashboard})
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, token, ok := strings.Cut(ev.URL, "/#token=")
+	if !ok || base != "http://"+h.d.dashboard.addr || len(token) != 32 {
+		t.Fatalf("url = %q", ev.URL)
+	}
+
+	call := func(method, path, token, host string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, base+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+	for _, c := range []struct {
+		method, path, token, host string
+		want                      int
+	}{
+		{"GET", "/", "", "", http.StatusOK},
+		{"GET", "/api/status", "", "", http.StatusUnauthorized},
+		{"GET", "/api/status", strings.Repeat("0", 32), "", http.StatusUnauthorized},
+		{"GET", "/", "", "rebind.example:80", http.StatusForbidden},
+		{"GET", "/api/repair", token, "", http.StatusMethodNotAllowed},
+		{"GET", "/api/config", token, "", http.StatusOK},
+		{"GET", "/api/history", token, "", http.StatusOK},
+	} {
+		if res := call(c.method, c.path, c.token, c.host); res.StatusCode != c.want {
+			t.Errorf("%s %s (token %t, host %q) = %d, want %d", c.method, c.path, c.token != "", c.host, res.StatusCode, c.want)
+		}
+	}
+
+	var st statusReport
+	if err := json.NewDecoder(call("GET", "/api/status", token, "").Body).Decode(&st); err != nil || st.CacheDir != h.cache {
+		t.Fatalf("status = %+v, %v", st, err)
+	}
+	var rep struct{ Started bool }
+	if err := json.NewDecoder(call("POST", "/api/repair", token, "").Body).Decode(&rep); err != nil || !rep.Started {
+		t.Fatalf("repair = %+v, %v", rep, err)
+	}
+	if got := h.waitRepairs(1); got[0] != "remote request via dashboard" {
+		t.Fatalf("reason = %q", got[0])
+	}
+	if err := json.NewDecoder(call("POST", "/api/repair", token, "").Body).Decode(&rep); err != nil || rep.Started {
+		t.Fatalf("second repair within the cooldown = %+v, %v", rep, err)
+	}
+	raw, err := io.ReadAll(call("GET", "/api/metrics", token, "").Body)
+	if err != nil || !strings.Contains(string(raw), "# TYPE iconcache_repairs_started_total counter\niconcache_repairs_started_total 1\n") {
+		t.Fatalf("metrics = %s, %v", raw, err)
+	}
+}
+
+func TestIntegrationGRPC(t *testing.T) {
+	h := newHarness(t)
+	done := make(chan struct{})
+	h.d.done = done
+	h.d.cfg.GRPC = grpcOptions{Enabled: true, Port: 0}
+	h.d.history = h.d.loadHistory()
+	h.d.serveGRPC()
+	if h.d.grpcAddr == "" {
+		t.Fatal("gRPC API not started")
+	}
+	endpoint, err := iconcachev1.ReadEndpoint(h.d.dataDir)
+	if err != nil || endpoint.Address != h.d.grpcAddr || len(endpoint.Token) != 32 {
+		t.Fatalf("endpoint = %+v, %v", endpoint, err)
+	}
+	conn, err := iconcachev1.Dial(h.d.dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	api := iconcachev1.NewManagementClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Without the token, or with another one, every call is refused.
+	for _, token := range []string{"", strings.Repeat("0", 32)} {
+		wrong, err := iconcachev1.Endpoint{Address: endpoint.Address, Token: token}.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = iconcachev1.NewManagementClient(wrong).GetStatus(ctx, &iconcachev1.GetStatusRequest{})
+		wrong.Close()
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("token %q: %v", token, err)
+		}
+	}
+	audit := filepath.Join(h.d.logDir, auditLogName)
+	h.assertLog(audit, "DENIED", iconcachev1.Management_GetStatus_FullMethodName+": unauthorized")
+
+	st, err := api.GetStatus(ctx, &iconcachev1.GetStatusRequest{})
+	if err != nil || st.CacheDir != h.cache || st.Started.AsTime() != h.d.started.UTC() || st.LastRepair != nil || !strings.Contains(st.Json, `"cacheDir"`) {
+		t.Fatalf("status = %v, %v", st, err)
+	}
+	cfg, err := api.GetConfig(ctx, &iconcachev1.GetConfigRequest{})
+	if err != nil || !strings.Contains(cfg.Json, `"sources"`) {
+		t.Fatalf("config = %v, %v", cfg, err)
+	}
+	var port *iconcachev1.Setting
+	for _, s := range cfg.Settings {
+		if s.Path == "grpc.port" {
+			port = s
+		}
+	}
+	if port == nil || port.Value != "0" || port.Source != sourceDefault {
+		t.Fatalf("grpc.port = %v", port)
+	}
+
+	// Progress streams once subscribed; a request without a kind is refused.
+	bad, err := api.SubscribeEvents(ctx, &iconcachev1.SubscribeEventsRequest{})
+	if err == nil {
+		_, err = bad.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("empty subscription: %v", err)
+	}
+	events, err := api.SubscribeEvents(ctx, &iconcachev1.SubscribeEventsRequest{Progress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		h.d.progress.mu.Lock()
+		n := len(h.d.progress.subs)
+		h.d.progress.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("progress subscription not registered")
+		}
+	}
+
+	rep, err := api.Repair(ctx, &iconcachev1.RepairRequest{})
+	if err != nil || !rep.Started {
+		t.Fatalf("repair = %v, %v", rep, err)
+	}
+	if got := h.waitRepairs(1); got[0] != "remote request via gRPC API" {
+		t.Fatalf("reason = %q", got[0])
+	}
+	ev, err := events.Recv()
+	if err != nil || ev.GetProgress().GetPhase() != phaseLaunched {
+		t.Fatalf("first event = %v, %v", ev, err)
+	}
+	if rep, err := api.Repair(ctx, &iconcachev1.RepairRequest{}); err != nil || rep.Started {
+		t.Fatalf("second repair within the cooldown = %v, %v", rep, err)
+	}
+	h.assertLog(h.d.watchLog, "COMMAND", iconcachev1.Management_Repair_FullMethodName+" via gRPC API by token holder")
+
+	hist, err := api.GetHistory(ctx, &iconcachev1.GetHistoryRequest{})
+	if err != nil || !slices.ContainsFunc(hist.Events, func(e *iconcachev1.HistoryEvent) bool { return e.Kind == historyCommand }) {
+		t.Fatalf("history = %v, %v", hist, err)
+	}
+
+	// Shutting down stops the server and removes the endpoint file.
+	close(done)
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(h.d.grpcEndpointFile()); os.IsNotExist(err) {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("endpoint file left behind")
+		}
+	}
+	for {
+		_, err := events.Recv()
+		if status.Code(err) == codes.DeadlineExceeded {
+			t.Fatal("stream survived the shutdown")
+		}
+		if err != nil {
+			break
+		}
+	}
+}
+
+func TestIntegrationMetrics(t *testing.T) {
+	h := newHarness(t)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize() // within the cooldown: folded into the first trigger
+	h.d.checkHealth()
+
+	// Updates from many goroutines at once are all counted (run with -race).
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h.d.metrics.inc(mHealthChecks)
+				h.d.metrics.observe(mRepairSeconds, 0.25)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := map[string]metricSample{}
+	for _, m := range h.d.statusSnapshot().Metrics {
+		got[m.Name] = m
+	}
+	for name, want := range map[string]float64{
+		"iconcache_watchdog_polls_total":       2,
+		"iconcache_size_triggers_total":        1,
+		"iconcache_size_triggers_folded_total": 1,
+		"iconcache_repairs_started_total":      1,
+		"iconcache_repairs_skipped_total":      0,
+		"iconcache_health_checks_total":        801,
+		"iconcache_health_score":               float64(h.d.lastScore),
+	} {
+		if got[name].Value != want {
+			t.Errorf("%s = %v, want %v", name, got[name].Value, want)
+		}
+	}
+	if r := got["iconcache_repair_seconds"]; r.Value < 800 || r.P50 != 0.25 || r.P95 != 0.25 {
+		t.Errorf("repair summary = %+v", r)
+	}
+	if s := h.d.metrics.summary(); !strings.Contains(s, "polls 2, size triggers 1, health checks 801") {
+		t.Errorf("heartbeat summary = %q", s)
+	}
+}
+
+func TestIntegrationSlowRepair(t *testing.T) {
+	h := newHarness(t)
+	h.d.history = h.d.loadHistory()
+	h.d.cfg.Thresholds.SlowRepair = duration(time.Millisecond)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	for deadline := time.Now().Add(10 * time.Second); h.d.metrics.snapshot()[mSlowRepairs].Value == 0; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("repair never counted as slow")
+		}
+	}
+	h.assertLog(h.d.watchLog, "WARN", "SLOW REPAIR: took")
+	h.assertLog(h.d.watchLog, "WARN", "Reason was: size")
+
+	view := h.d.history.snapshot(h.clock.Now())
+	if len(view.RepairTimes) != 1 || view.RepairTimes[0].Seconds <= 0 || view.RepairTimes[0].ExplorerDownSeconds <= 0 ||
+		view.RepairTimes[0].ExplorerDownSeconds > view.RepairTimes[0].Seconds {
+		t.Fatalf("repair times = %+v", view.RepairTimes)
+	}
+	if !slices.ContainsFunc(view.Events, func(e historyEvent) bool { return e.Kind == historySlow }) {
+		t.Fatalf("events = %+v", view.Events)
+	}
+	down := h.d.metrics.snapshot()[mExplorerDownSeconds]
+	if down.Value != 1 || down.P50 != view.RepairTimes[0].ExplorerDownSeconds {
+		t.Fatalf("explorer downtime = %+v", down)
+	}
+}
+
+func TestIntegrationControlAPI(t *testing.T) {
+	h := newHarness(t)
+	h.startControl()
+	call := func(req controlRequest) (controlEvent, error) {
+		t.Helper()
+		c, err := openControl(h.d.controlAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		return c.call(req)
+	}
+
+	ev, err := call(controlRequest{Cmd: requestVersion})
+	if err != nil || ev.Type != eventVersion || ev.API != controlAPIVersion || !slices.Contains(ev.Commands, requestRepair) {
+		t.Fatalf("version = %+v, %v", ev, err)
+	}
+	if _, err := call(controlRequest{Cmd: requestStatus, API: controlAPIVersion + 1}); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("newer API version: %v", err)
+	}
+	if ev, err := call(controlRequest{Cmd: requestStatus, API: controlAPIVersion}); err != nil || ev.Status == nil || ev.Status.CacheDir != h.cache {
+		t.Fatalf("status = %+v, %v", ev, err)
+	}
+	if ev, err := call(controlRequest{Cmd: requestConfig}); err != nil || ev.Config == nil || ev.Config.Sources["thresholds.sizeLimit"] != sourceDefault {
+		t.Fatalf("config = %+v, %v", ev, err)
+	}
+	if ev, err := call(controlRequest{Cmd: requestRepair}); err != nil || ev.Repair == nil || !ev.Repair.Started {
+		t.Fatalf("repair = %+v, %v", ev, err)
+	}
+	if got := h.waitRepairs(1); got[0] != "remote request via control pipe" {
+		t.Fatalf("reason = %q", got[0])
+	}
+	if ev, err := call(controlRequest{Cmd: requestRepair}); err != nil || ev.Repair.Started {
+		t.Fatalf("repair within the cooldown = %+v, %v", ev, err)
+	}
+}
+
+func TestIntegrationLibrary(t *testing.T) {
+	h := newHarness(t)
+	local := t.TempDir()
+	cache := filepath.Join(local, "Microsoft", "Windows", "Explorer")
+	if err := writeHealthyCache(cache); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOCALAPPDATA", local)
+	t.Setenv(cacheDirEnv, cache)
+	// The project root is found from the host program's location.
+	arg0 := os.Args[0]
+	os.Args[0] = filepath.Join(h.root, "bin", "kiosk-shell")
+	t.Cleanup(func() { os.Args[0] = arg0 })
+
+	cfg := DefaultConfig()
+	cfg.DataDir = filepath.Join(h.root, "embedded")
+	cfg.PowerShell = h.d.cfg.PowerShell
+	cfg.Quiet = h.d.cfg.Quiet
+	cfg.Network = h.d.cfg.Network
+	cfg.SelfCheck.Restart = true
+	if _, err := New(cfg); err == nil {
+		t.Fatal("New accepted selfCheck.restart")
+	}
+	cfg.SelfCheck.Restart = false
+	cfg.Thresholds.PollEvery = Duration(time.Second)
+
+	w, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := w.Health(); !res.Healthy || res.CacheDir != cache {
+		t.Fatalf("health before Run = %+v", res)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- w.Run(ctx) }()
+	started := func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.running
+	}
+	for deadline := time.Now().Add(10 * time.Second); !started() || w.Health().Checked.IsZero(); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no startup health check")
+		}
+	}
+	if err := w.Run(ctx); !errors.Is(err, ErrRunning) {
+		t.Fatalf("second Run = %v", err)
+	}
+
+	if err := w.TriggerRepair("kiosk maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	e := &harness{t: t, d: w.d}
+	if got := e.waitRepairs(1); got[0] != "kiosk maintenance" {
+		t.Fatalf("reason = %q", got[0])
+	}
+	if err := w.TriggerRepair("again"); !errors.Is(err, ErrRepairNotStarted) {
+		t.Fatalf("repair within the cooldown = %v", err)
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestIntegrationPlugins(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Plugins.Enabled = true
+	if err := os.MkdirAll(filepath.Join(h.root, "plugins"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	copySelf(t, filepath.Join(h.root, "plugins", fakePluginName))
+	actions := filepath.Join(t.TempDir(), "actions.log")
+	t.Setenv(pluginLogEnv, actions)
+
+	h.d.checkHealth()
+	h.assertLog(h.d.watchLog, "INFO", "Plug-in vendor loaded")
+	h.assertLog(h.d.healthLog, "PASS", "Plug-in vendor PASS: vendor cache checked")
+	if h.d.lastScore != 100 {
+		t.Fatalf("score with a passing plug-in = %d", h.d.lastScore)
+	}
+	h.noRepairs(0)
+
+	t.Setenv(pluginPassEnv, "0")
+	h.d.checkHealth()
+	h.assertLog(h.d.healthLog, "WARN", "Plug-in vendor FAIL")
+	if got := h.waitRepairs(1); !strings.Contains(got[0], "health score 85") {
+		t.Fatalf("reason = %q", got[0])
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if raw, _ := os.ReadFile(actions); strings.Contains(string(raw), "health score 85") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("plug-in repair action did not run")
+		}
+	}
+}
+
+func TestIntegrationLogStream(t *testing.T) {
+	h := newHarness(t)
+	h.startControl()
+	for i := 1; i <= 3; i++ {
+		h.d.watchLog_("INFO", fmt.Sprintf("stream line %d", i))
+	}
+	open := func(req controlRequest) (io.ReadWriteCloser, *json.Decoder) {
+		t.Helper()
+		conn, err := dialControl(h.d.controlAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := json.NewEncoder(conn).Encode(req); err != nil {
+			t.Fatal(err)
+		}
+		return conn, json.NewDecoder(conn)
+	}
+	next := func(dec *json.Decoder) string {
+		t.Helper()
+		var ev controlEvent
+		if err := dec.Decode(&ev); err != nil || ev.Type != eventLog || ev.Log == nil {
+			t.Fatalf("event = %+v, %v", ev, err)
+		}
+		return ev.Log.String()
+	}
+
+	// Without follow: the backlog, then the daemon hangs up.
+	_, dec := open(controlRequest{Cmd: requestLogs, Lines: 2})
+	for _, want := range []string{"stream line 2", "stream line 3"} {
+		if got := next(dec); !strings.Contains(got, "[INFO] subsystem=watchdog "+want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	var ev controlEvent
+	if err := dec.Decode(&ev); err != io.EOF {
+		t.Fatalf("after the backlog: %+v, %v", ev, err)
+	}
+
+	// With follow: new lines as they are logged.
+	_, dec = open(controlRequest{Cmd: requestLogs, Follow: true})
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		h.d.logs.mu.Lock()
+		n := len(h.d.logs.subs)
+		h.d.logs.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("log subscription not registered")
+		}
+	}
+	h.d.repairLog_("TRIGGER", "stream line 4")
+	if got := next(dec); !strings.Contains(got, "[TRIGGER] subsystem=repair stream line 4") {
+		t.Fatalf("followed line = %q", got)
+	}
+
+	// The dashboard streams the same lines as server-sent events.
+	h.d.cfg.UI = uiOptions{Enabled: true, Port: 0}
+	h.d.serveDashboard()
+	req, _ := http.NewRequest("GET", "http://"+h.d.dashboard.addr+"/api/logs", nil)
+	req.Header.Set("Authorization", "Bearer "+h.d.dashboard.token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	sc := bufio.NewScanner(res.Body)
+	for sc.Scan() {
+		if strings.HasPrefix(sc.Text(), "data: ") && strings.Contains(sc.Text(), "stream line 4") {
+			return
+		}
+	}
+	t.Fatalf("no stream line 4 in the event stream: %v", sc.Err())
+}
+
+// writeShortcut writes a minimal shell link whose LinkInfo points at
+// base\suffix: a network share when base is a UNC path, else a local path.
+func writeShortcut(t *testing.T, path, base, suffix string) {
+	t.Helper()
+	le := binary.LittleEndian
+	info := make([]byte, 0x1c)
+	le.PutUint32(info[4:], 0x1c) // LinkInfoHeaderSize
+	var body []byte
+	if strings.HasPrefix(base, `\\`) {
+		cnrl := make([]byte, 0x14)
+		le.PutUint32(cnrl[8:], 0x14) // NetNameOffset
+		body = append(append(cnrl, base...), 0)
+		le.PutUint32(body, uint32(len(body)))
+		le.PutUint32(info[8:], linkInfoNetwork)
+		le.PutUint32(info[0x14:], 0x1c) // CommonNetworkRelativeLinkOffset
+	} else {
+		body = append([]byte(base), 0)
+		le.PutUint32(info[8:], linkInfoLocal)
+		le.PutUint32(info[0x10:], 0x1c) // LocalBasePathOffset
+	}
+	le.PutUint32(info[0x18:], uint32(0x1c+len(body))) // CommonPathSuffixOffset
+	info = append(append(append(info, body...), suffix...), 0)
+	le.PutUint32(info, uint32(len(info)))
+	header := make([]byte, lnkHeaderSize)
+	le.PutUint32(header, lnkHeaderSize)
+	copy(header[4:], lnkCLSID)
+	le.PutUint32(header[0x14:], lnkHasLinkInfo)
+	if err := os.WriteFile(path, append(header, info...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// desktop points the shortcut folders at an empty temporary profile and
+// returns its Desktop folder.
+func (h *harness) desktop() string {
+	h.t.Helper()
+	profile := h.t.TempDir()
+	for _, env := range []string{"PUBLIC", "APPDATA", "ProgramData"} {
+		h.t.Setenv(env, "")
+	}
+	h.t.Setenv("USERPROFILE", profile)
+	desktop := filepath.Join(profile, "Desktop")
+	if err := os.MkdirAll(desktop, 0755); err != nil {
+		h.t.Fatal(err)
+	}
+	return desktop
+}
+
+func TestIntegrationNetworkHold(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Network.HoldWhileOffline = true
+	desktop := h.desktop()
+	for i := 0; i < 3; i++ {
+		writeShortcut(t, filepath.Join(desktop, fmt.Sprintf("Tool %d.lnk", i)), `\\offline-srv\Apps`, fmt.Sprintf("tool%d.exe", i))
+	}
+	s, err := readShortcut(filepath.Join(desktop, "Tool 0.lnk"))
+	if err != nil || s.Target != `\\offline-srv\Apps\tool0.exe` || s.Share != `\\offline-srv\Apps` {
+		t.Fatalf("shortcut = %+v, %v", s, err)
+	}
+
+	// The share does not answer: the size repair waits for it.
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "WARN", `Repair deferred during 3 shortcut(s) on unreachable network shares (\\offline-srv\apps)`)
+	h.noRepairs(0)
+
+	// After network.maxHold the share no longer holds the repair.
+	h.clock.Advance(h.d.cfg.Network.MaxHold.D() + time.Minute)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.assertLog(h.d.watchLog, "WARN", "No longer holding repairs")
+}
+
+func TestIntegrationBrokenShortcuts(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.ShortcutScan.Enabled = true
+	desktop := h.desktop()
+	apps := t.TempDir()
+	if err := os.WriteFile(filepath.Join(apps, "app.exe"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeShortcut(t, filepath.Join(desktop, "App.lnk"), filepath.Join(apps, "app.exe"), "")
+	writeShortcut(t, filepath.Join(desktop, "Gone.lnk"), filepath.Join(apps, "uninstalled.exe"), "")
+	writeShortcut(t, filepath.Join(desktop, "Share.lnk"), `\\offline-srv\Apps`, "tool.exe")
+
+	res := h.d.evaluateHealth(false)
+	s := res.Shortcuts
+	if s == nil || s.Scanned != 3 || s.Offline != 1 || len(s.Broken) != 1 || s.Broken[0].Target != filepath.Join(apps, "uninstalled.exe") {
+		t.Fatalf("shortcuts = %+v", s)
+	}
+	h.assertLog(h.d.healthLog, "WARN", "Shortcut scan: 1 of 3 broken, 1 on unreachable shares.")
+	h.assertLog(h.d.healthLog, "WARN", "Gone.lnk -> ")
+	// Dead shortcuts are not cache corruption.
+	if !res.Healthy || res.RepairNeeded {
+		t.Fatalf("health = %+v", res)
+	}
+}
+
+func TestIntegrationUnifiedLog(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Logging.Mode = logModeUnified
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.d.checkHealth()
+
+	h.assertLog(h.d.unifiedLog, "TRIGGER", "subsystem=watchdog W010 Cache is ")
+	h.assertLog(h.d.unifiedLog, "TRIGGER", "subsystem=repair R200 Repair triggered:")
+	h.assertLog(h.d.unifiedLog, "INFO", "subsystem=repair Command line:")
+	h.assertLog(h.d.unifiedLog, "PASS", "subsystem=health === ALL HEURISTICS PASSED")
+	for _, split := range []string{h.d.watchLog, h.d.healthLog} {
+		if _, err := os.Stat(split); !os.IsNotExist(err) {
+			t.Fatalf("%s written in unified mode", filepath.Base(split))
+		}
+	}
+}
+
+func TestIntegrationRepairProgress(t *testing.T) {
+	h := newHarness(t)
+	go h.d.serveControl()
+	var conn io.ReadWriteCloser
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var err error
+		if conn, err = dialControl(h.d.controlAddr()); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("control pipe: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(controlRequest{Cmd: requestProgress}); err != nil {
+		t.Fatal(err)
+	}
+	// The subscription is registered once the request has been read.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		h.d.progress.mu.Lock()
+		n := len(h.d.progress.subs)
+		h.d.progress.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("progress subscription not registered")
+		}
+	}
+
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+
+	var phases []string
+	dec := json.NewDecoder(conn)
+	for {
+		var ev controlEvent
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("after %v: %v", phases, err)
+		}
+		p := ev.Phase
+		if ev.Total > 0 {
+			p = fmt.Sprintf("%s %d/%d", p, ev.Done, ev.Total)
+		}
+		phases = append(phases, p)
+		if ev.finished() {
+			break
+		}
+	}
+	want := []string{"launched", "explorer-stopping", "files-deleting 1/6", "files-deleting 6/6", "explorer-starting", "complete"}
+	for _, w := range want {
+		found := false
+		for _, p := range phases {
+			found = found || p == w
+		}
+		if !found {
+			t.Fatalf("phases %v: missing %q", phases, w)
+		}
+	}
+}
+
+func TestIntegrationScheduledDeepClean(t *testing.T) {
+	h := newHarness(t)
+	now := h.clock.Now()
+	from, to := now.Add(-time.Hour), now.Add(time.Hour)
+	h.d.cfg.DeepClean.Enabled = true
+	h.d.cfg.DeepClean.Window = from.Format("15:04") + "-" + to.Format("15:04")
+
+	// Heuristics pass; the schedule alone triggers the rebuild.
+	h.d.checkDeepClean()
+	reasons := h.waitRepairs(1)
+	if !strings.Contains(reasons[0], "scheduled deep clean") {
+		t.Fatalf("repair reason = %q", reasons[0])
+	}
+	h.assertLog(h.d.watchLog, "INFO", "-DeepClean")
+
+	// Once per deepClean.every, even across a restart.
+	h.clock.Advance(2 * time.Hour)
+	h.d.checkDeepClean()
+	restarted := &daemon{cfg: h.d.cfg, stateFile: h.d.stateFile, logDir: h.d.logDir, watchLog: h.d.watchLog, clock: h.clock}
+	restarted.loadState()
+	restarted.checkDeepClean()
+	h.noRepairs(1)
+	if restarted.deepCleaned.IsZero() {
+		t.Fatal("deep clean time not persisted")
+	}
+}
+
+func TestIntegrationDailyReport(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Reports.Enabled = true
+	today := h.clock.Now().Format("2006-01-02")
+	read := func(date string) dailyReport {
+		t.Helper()
+		raw, err := os.ReadFile(filepath.Join(h.d.reportDir(), reportBase(date)+".json"))
+		if err != nil {
+			t.Fatalf("report for %s: %v", date, err)
+		}
+		var r dailyReport
+		if err := json.Unmarshal(raw, &r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	if _, err := os.Stat(filepath.Join(h.d.reportDir(), reportBase(today)+".csv")); err != nil {
+		t.Fatalf("CSV report not written: %v", err)
+	}
+
+	// The rest of the day is written after midnight.
+	h.d.checkSize()
+	h.clock.Advance(24 * time.Hour)
+	h.d.checkSize()
+	r := read(today)
+	if r.SizeTriggers != 1 || r.Repairs != 1 || r.SizeSamples != 2 || r.CacheMBMax < defaultSizeLimit.MB() {
+		t.Fatalf("report = %+v", r)
+	}
+
+	// A restart continues the current day from its file.
+	next := h.clock.Now().Format("2006-01-02")
+	restarted := &daemon{cfg: h.d.cfg, dataDir: h.d.dataDir, cacheDir: h.d.cacheDir, logDir: h.d.logDir, watchLog: h.d.watchLog, clock: h.clock}
+	restarted.checkSize()
+	if r := read(next); r.SizeSamples != 2 {
+		t.Fatalf("after restart = %+v", r)
+	}
+}
+
+func TestIntegrationWeeklySummary(t *testing.T) {
+	h := newHarness(t)
+	addr, mails := fakeSMTP(t)
+	h.d.cfg.WeeklySummary = weeklySummaryOptions{Enabled: true, Day: "monday", At: "09:00", SMTP: addr, From: "icw@example.com", To: "jdoe@example.com"}
+	if errs := h.d.cfg.checkRanges(); len(errs) != 0 {
+		t.Fatalf("range errors = %v", errs)
+	}
+
+	// The first week starts now; nothing is delivered yet.
+	h.d.checkWeeklySummary()
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.d.checkHealth()
+	files := func() []string {
+		found, _ := filepath.Glob(filepath.Join(h.d.reportDir(), "*-week-*.txt"))
+		return found
+	}
+	if f := files(); len(f) != 0 {
+		t.Fatalf("summary before the first week: %v", f)
+	}
+
+	h.clock.Advance(7 * 24 * time.Hour)
+	// The toast waits out quiet hours, and the rest of the summary with it.
+	now := h.clock.Now()
+	h.d.cfg.WeeklySummary.Toast = true
+	h.d.cfg.Quiet.Hours = now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04")
+	h.d.checkWeeklySummary()
+	h.d.checkWeeklySummary()
+	if f := files(); len(f) != 0 {
+		t.Fatalf("summary during quiet hours: %v", f)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "Weekly summary held (quiet hours ")
+	h.d.cfg.Quiet.Hours = ""
+	h.d.checkWeeklySummary()
+	h.d.checkWeeklySummary()
+	f := files()
+	if len(f) != 1 {
+		t.Fatalf("summaries = %v", f)
+	}
+	raw, _ := os.ReadFile(f[0])
+	if !strings.Contains(string(raw), "1 repair, peak cache") || !strings.Contains(string(raw), "1 size trigger") {
+		t.Fatalf("summary:\n%s", raw)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "Weekly summary (")
+	h.assertLog(h.d.watchLog, "INFO", "Weekly summary mailed to jdoe@example.com")
+	select {
+	case mail := <-mails:
+		if !strings.Contains(mail, "Subject: Icon cache weekly summary") || !strings.Contains(mail, "1 repair, peak cache") {
+			t.Fatalf("mail:\n%s", mail)
+		}
+	default:
+		t.Fatal("no summary mailed")
+	}
+	if code := runCommand(h.d, []string{"summary"}); code != 0 {
+		t.Fatalf("summary exit %d", code)
+	}
+}
+
+// fakeSMTP accepts mail on a local port and passes each message on.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	mails := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+			reply("220 fake-smtp")
+			var data strings.Builder
+			inData := false
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+				if inData {
+					if line == ".\r\n" {
+						inData = false
+						mails <- data.String()
+						reply("250 queued")
+					} else {
+						data.WriteString(line)
+					}
+					continue
+				}
+				cmd := strings.ToUpper(strings.TrimSpace(line))
+				if cmd == "DATA" {
+					inData = true
+					reply("354 go ahead")
+				} else if cmd == "QUIT" {
+					reply("221 bye")
+					break
+				} else {
+					reply("250 ok")
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), mails
+}
+
+func TestIntegrationRemoteCommands(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Quiet.Hours = "00:00-23:59" // defers normal repairs, not requested ones
+	h.d.healthNow = make(chan string, 1)
+
+	h.d.runRemote(remoteRepair, "event "+remoteEventName(remoteRepair))
+	reasons := h.waitRepairs(1)
+	if !strings.Contains(reasons[0], "remote request via event") {
+		t.Fatalf("repair reason = %q", reasons[0])
+	}
+	h.assertLog(h.d.watchLog, "INFO", "Remote repair requested via event")
+
+	// Health checks are handed to the health-check goroutine; extra
+	// requests while one is pending are merged.
+	h.d.runRemote(remoteHealthCheck, "window message")
+	h.d.runRemote(remoteHealthCheck, "window message")
+	if why := <-h.d.healthNow; why != "requested via window message" {
+		t.Fatalf("health check request = %q", why)
+	}
+	select {
+	case why := <-h.d.healthNow:
+		t.Fatalf("second request not merged: %q", why)
+	default:
+	}
+}
+
+func TestIntegrationSessionPollWorkers(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.MultiSession.PollWorkers = 3
+	var sessions []*daemon
+	for i := 0; i < 10; i++ {
+		sessions = append(sessions, h.d.sessionDaemon(sessionInfo{ID: uint32(i + 1), User: fmt.Sprintf("user%d", i), ProfileDir: t.TempDir()}))
+	}
+
+	var mu sync.Mutex
+	running, peak, done := 0, 0, map[uint32]bool{}
+	h.d.forEachSession(sessions, func(sd *daemon) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond) // a slow profile disk
+		mu.Lock()
+		running--
+		done[sd.session.ID] = true
+		mu.Unlock()
+	})
+	if len(done) != len(sessions) {
+		t.Fatalf("polled %d of %d sessions", len(done), len(sessions))
+	}
+	if peak < 2 || peak > 3 {
+		t.Fatalf("%d sessions polled at once, want 2-3", peak)
+	}
+}
+
+// TestIntegrationSessionPollRace runs the service's real poll and health
+// rounds on a worker pool, with repairs in flight and status read alongside,
+// as CI does under -race.
+func TestIntegrationSessionPollRace(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.MultiSession.Enabled = true
+	h.d.cfg.MultiSession.PollWorkers = 4
+	h.d.throttle = make(chan struct{}, 8)
+	var active []sessionInfo
+	for i := 0; i < 8; i++ {
+		profile := t.TempDir()
+		if err := writeHealthyCache(filepath.Join(profile, "AppData", "Local", "Microsoft", "Windows", "Explorer")); err != nil {
+			t.Fatal(err)
+		}
+		active = append(active, sessionInfo{ID: uint32(i + 1), User: fmt.Sprintf("user%d", i), ProfileDir: profile})
+	}
+	sessions := map[uint32]*daemon{}
+	h.d.syncSessions(sessions, active) // logon health checks, in parallel
+	if len(sessions) != len(active) {
+		t.Fatalf("attached %d of %d sessions", len(sessions), len(active))
+	}
+	for _, id := range []uint32{2, 5, 7} {
+		if err := os.Truncate(filepath.Join(sessions[id].cacheDir, "iconcache_256.db"), 40<<20); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.d.writeSessionStatus(sessions)
+			h.d.statusSnapshot()
+			h.d.metrics.snapshot()
+		}
+	}()
+	for round := 0; round < 3; round++ {
+		h.d.forEachSession(sortedSessions(sessions), func(sd *daemon) {
+			start := time.Now()
+			sd.checkSize()
+			sd.mu.Lock()
+			sd.lastScan = time.Since(start)
+			sd.mu.Unlock()
+		})
+		h.d.forEachSession(sortedSessions(sessions), func(sd *daemon) {
+			sd.healthLog_("INFO", "--- Health check running (periodic) ---")
+			sd.checkHealth()
+		})
+		h.clock.Advance(time.Minute)
+	}
+	r := h.waitRepairs(3)
+	close(stop)
+	readers.Wait()
+
+	// One repair per bloated session, of its own cache; the cooldown held
+	// the later rounds.
+	h.noRepairs(3)
+	for _, reason := range r {
+		if !strings.Contains(reason, "40.47 MB exceeds") {
+			t.Fatalf("repairs = %q", r)
+		}
+	}
+	for _, id := range []uint32{2, 5, 7} {
+		h.assertLog(h.d.watchLog, "TRIGGER", fmt.Sprintf("[S%d user%d] ", id, id-1))
+		if info, err := os.Stat(filepath.Join(sessions[id].cacheDir, "iconcache_256.db")); err != nil || info.Size() != 512*1024 {
+			t.Fatalf("session %d cache after repair: %v, %v", id, info, err)
+		}
+	}
+	h.d.writeSessionStatus(sessions)
+	st := h.d.statusSnapshot()
+	if len(st.Sessions) != len(active) {
+		t.Fatalf("status sessions = %+v", st.Sessions)
+	}
+	for _, s := range st.Sessions {
+		if repaired := s.ID == 2 || s.ID == 5 || s.ID == 7; repaired == s.LastRepair.IsZero() || s.LastCheck.IsZero() {
+			t.Fatalf("session status = %+v", s)
+		}
+	}
+}
+
+func TestIntegrationOptOut(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.OptOut.Users = `kiosk, CONTOSO\svc-*`
+	if errs := h.d.cfg.checkRanges(); len(errs) != 0 {
+		t.Fatalf("range errors = %v", errs)
+	}
+	session := func(id uint32, domain, user string) sessionInfo {
+		profile := t.TempDir()
+		if err := writeHealthyCache(filepath.Join(profile, "AppData", "Local", "Microsoft", "Windows", "Explorer")); err != nil {
+			t.Fatal(err)
+		}
+		return sessionInfo{ID: id, Domain: domain, User: user, ProfileDir: profile}
+	}
+	active := []sessionInfo{session(1, "PC01", "kiosk"), session(2, "CONTOSO", "svc-backup"), session(3, "CONTOSO", "jdoe")}
+	sessions := map[uint32]*daemon{}
+	h.d.syncSessions(sessions, active)
+	if len(sessions) != 1 || sessions[3] == nil {
+		t.Fatalf("attached %v, want session 3 only", sortedSessionIDs(sessions))
+	}
+	h.assertLog(h.d.watchLog, "INFO", "[S1 kiosk] User opted out (listed in optOut.users). Session not monitored.")
+
+	// The user opts out while logged on, then back in.
+	marker := optOutMarker(filepath.Join(active[2].ProfileDir, "AppData", "Local"))
+	os.MkdirAll(filepath.Dir(marker), 0755)
+	os.WriteFile(marker, nil, 0644)
+	h.d.syncSessions(sessions, active)
+	if len(sessions) != 0 {
+		t.Fatalf("attached %v after opt-out", sortedSessionIDs(sessions))
+	}
+	h.assertLog(h.d.watchLog, "INFO", "[S3 jdoe] User opted out (opt-out file")
+	os.Remove(marker)
+	h.d.syncSessions(sessions, active)
+	if len(sessions) != 1 {
+		t.Fatalf("attached %v after opting back in", sortedSessionIDs(sessions))
+	}
+
+	// Single-user: the opt-out command skips repairs until undone.
+	t.Setenv("LOCALAPPDATA", t.TempDir())
+	if code := runCommand(h.d, []string{"opt-out"}); code != 0 {
+		t.Fatalf("opt-out exit %d", code)
+	}
+	if why := h.d.repairSkipReason(); !strings.HasPrefix(why, "User opted out (opt-out file") {
+		t.Fatalf("skip reason = %q", why)
+	}
+	if code := runCommand(h.d, []string{"opt-out", "-undo"}); code != 0 || h.d.repairSkipReason() != "" {
+		t.Fatalf("opt-out -undo exit %d, skip reason %q", code, h.d.repairSkipReason())
+	}
+	h.d.cfg.OptOut.AllowUsers = false
+	if code := runCommand(h.d, []string{"opt-out"}); code != 1 {
+		t.Fatalf("opt-out while disallowed: exit %d, want 1", code)
+	}
+}
+
+func TestIntegrationInterruptedRepairRecovery(t *testing.T) {
+	h := newHarness(t)
+	interrupted := func() *daemon {
+		h.d.mu.Lock()
+		h.d.lastRepair = h.clock.Now() // cooldown does not stop recovery
+		h.d.inFlight = &repairInFlight{Reason: "size 40.00 MB exceeds 32MB limit", Started: h.clock.Now(), PID: -1}
+		h.d.saveState()
+		h.d.mu.Unlock()
+		restarted := &daemon{cfg: h.d.cfg, rootDir: h.d.rootDir, dataDir: h.d.dataDir, cacheDir: h.d.cacheDir, repairScript: h.d.repairScript,
+			stateFile: h.d.stateFile, logDir: h.d.logDir, watchLog: h.d.watchLog, healthLog: h.d.healthLog, caps: h.d.caps, clock: h.clock}
+		restarted.loadState()
+		return restarted
+	}
+
+	// A consistent cache needs nothing more.
+	restarted := interrupted()
+	restarted.recoverInterruptedRepair()
+	h.noRepairs(0)
+	h.assertLog(h.d.watchLog, "INFO", "interrupted repair verified")
+
+	// A cache left without its index is repaired again.
+	os.Remove(filepath.Join(h.cache, "iconcache_idx.db"))
+	restarted = interrupted()
+	restarted.recoverInterruptedRepair()
+	reasons := h.waitRepairs(1)
+	if !strings.Contains(reasons[0], "recovery of interrupted repair") {
+		t.Fatalf("repair reason = %q", reasons[0])
+	}
+	h.assertLog(h.d.watchLog, "WARN", "Previous run ended during a repair")
+}
+
+func TestIntegrationOneShotHealthCheck(t *testing.T) {
+	h := newHarness(t)
+	if code := cmdHealthCheck(h.d, []string{"-json"}); code != 0 {
+		t.Fatalf("healthy cache: exit %d", code)
+	}
+
+	// Without -repair a failing check changes nothing.
+	os.Remove(filepath.Join(h.cache, "iconcache_idx.db"))
+	if res := h.d.evaluateHealth(false); !res.RepairNeeded || res.Heuristics["h1"] {
+		t.Fatalf("result = %+v", res)
+	}
+	h.noRepairs(0)
+
+	// With -repair it waits for the repair script to exit.
+	if code := cmdHealthCheck(h.d, []string{"-repair"}); code != 1 {
+		t.Fatalf("broken index: exit %d", code)
+	}
+	h.waitRepairs(1)
+	if h.d.inFlight != nil {
+		t.Fatal("healthcheck -repair returned before the repair ended")
+	}
+}
+
+// captureStdout runs fn with os.Stdout redirected and returns what it
+// printed.
+func captureStdout(t *testing.T, fn func() int) (string, int) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- b
+	}()
+	code := fn()
+	os.Stdout = stdout
+	w.Close()
+	return string(<-out), code
+}
+
+func TestIntegrationJSONOutput(t *testing.T) {
+	h := newHarness(t)
+	h.d.started = h.clock.Now()
+	h.d.writeStatus()
+	broken := filepath.Join(t.TempDir(), "broken.json")
+	os.WriteFile(broken, []byte(`{"thresholds": {"pollEvery": "0s"}}`), 0644)
+
+	for _, c := range []struct {
+		args   []string
+		schema string
+		code   int
+		want   string // a field of the output
+	}{
+		{[]string{"status", "--json"}, "status", 0, "running"},
+		{[]string{"healthcheck", "--json"}, "healthcheck", 0, "score"},
+		{[]string{"summary", "-json"}, "summary", 0, "headline"},
+		{[]string{"rollback", "-list", "-json"}, "rollback", 0, "backups"},
+		{[]string{"config", "validate", "-json", broken}, "config-validate", 1, "problems"},
+		{[]string{"config", "presets", "-json"}, "config-presets", 0, "presets"},
+		{[]string{"config", "show-effective", "-json"}, "config-show-effective", 0, "sources"},
+		{[]string{"explain", "-json"}, "explain", 0, "messages"},
+	} {
+		out, code := captureStdout(t, func() int { return runCommand(h.d, c.args) })
+		var got map[string]any
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatalf("%v: not one JSON object: %v\n%s", c.args, err, out)
+		}
+		if code != c.code || got["schema"] != c.schema || got["schemaVersion"] != float64(jsonSchemas[c.schema]) || got[c.want] == nil {
+			t.Fatalf("%v: exit %d, output:\n%s", c.args, code, out)
+		}
+		if !strings.HasPrefix(out, "{\n  \"schema\": ") {
+			t.Fatalf("%v: schema is not the first field:\n%s", c.args, out)
+		}
+	}
+
+	out, _ := captureStdout(t, func() int { return runCommand(h.d, []string{"status", "-json"}) })
+	var st struct {
+		Running bool `json:"running"`
+		PID     int  `json:"pid"`
+	}
+	if json.Unmarshal([]byte(out), &st); !st.Running || st.PID != os.Getpid() {
+		t.Fatalf("status: %s", out)
+	}
+}
+
+func TestIntegrationComplianceCommand(t *testing.T) {
+	h := newHarness(t)
+	if code := cmdCompliance(h.d, []string{"detect"}); code != 0 {
+		t.Fatalf("healthy cache: detect exit %d", code)
+	}
+	h.noRepairs(0)
+
+	os.Remove(filepath.Join(h.cache, "iconcache_idx.db"))
+	if got := complianceFindings(h.d, h.d.evaluateHealth(false), 1); !strings.Contains(got, "failed H1") {
+		t.Fatalf("findings = %q", got)
+	}
+	if code := cmdCompliance(h.d, []string{"detect"}); code != 1 {
+		t.Fatalf("broken index: detect exit %d", code)
+	}
+	h.noRepairs(0)
+
+	// Remediation repairs even inside the cooldown and waits for the script.
+	h.d.mu.Lock()
+	h.d.lastRepair = h.clock.Now()
+	h.d.mu.Unlock()
+	if code := cmdCompliance(h.d, []string{"remediate"}); code != 0 {
+		t.Fatalf("remediate exit %d", code)
+	}
+	if reasons := h.waitRepairs(1); reasons[0] != "intune remediation" {
+		t.Fatalf("repair reason = %q", reasons[0])
+	}
+	if h.d.inFlight != nil {
+		t.Fatal("remediate returned before the repair ended")
+	}
+
+	// A missing cache is not evaluated, so not reported compliant, and
+	// not repaired.
+	os.RemoveAll(h.cache)
+	out, code := captureStdout(t, func() int { return cmdCompliance(h.d, []string{"detect"}) })
+	if code != 1 || !strings.HasPrefix(out, "NotEvaluated: cache directory "+h.cache+" is missing") {
+		t.Fatalf("missing cache: detect exit %d, %q", code, out)
+	}
+	out, code = captureStdout(t, func() int { return cmdCompliance(h.d, []string{"remediate"}) })
+	if code != 1 || !strings.HasPrefix(out, "RemediationFailed: ") {
+		t.Fatalf("missing cache: remediate exit %d, %q", code, out)
+	}
+	h.noRepairs(1)
+
+	if got := complianceLine("a\nb  " + strings.Repeat("x", 3000)); len(got) != complianceMaxLine || strings.Contains(got, "\n") {
+		t.Fatalf("line not folded: %d chars", len(got))
+	}
+}
+
+func TestIntegrationSilentInstall(t *testing.T) {
+	h := newHarness(t)
+	if code := cmdInstall(h.d, []string{"/S", "/norestart"}); code != 2 {
+		t.Fatalf("unknown switch: exit %d", code)
+	}
+	if !isElevated() {
+		if code := cmdInstall(h.d, []string{"/S"}); code != exitElevationRequired {
+			t.Fatalf("not elevated: exit %d", code)
+		}
+		h.assertLog(filepath.Join(h.d.logDir, installLogName), "ERROR", "must run elevated")
+		return
+	}
+
+	if code := cmdInstall(h.d, []string{"/S"}); code != exitInstallFailure {
+		t.Fatalf("missing script: exit %d", code)
+	}
+	// A failing script maps onto 1603 and leaves its output in Install.log.
+	script := filepath.Join(h.root, "scripts", "Unregister-Tasks.ps1")
+	if err := os.WriteFile(script, []byte("exit 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := cmdUninstall(h.d, []string{"/silent"}); code != exitInstallFailure {
+		t.Fatalf("failing script: exit %d", code)
+	}
+	h.assertLog(filepath.Join(h.d.logDir, installLogName), "ERROR", "uninstall failed")
+	body, _ := os.ReadFile(filepath.Join(h.d.logDir, installLogName))
+	if !strings.Contains(string(body), "unexpected script") || !strings.Contains(string(body), "-CallerPid") {
+		t.Fatalf("Install.log lacks the script output:\n%s", body)
+	}
+}
+
+func TestIntegrationInstallPhases(t *testing.T) {
+	if !isElevated() {
+		t.Skip("rollback needs an elevated test run")
+	}
+	h := newHarness(t)
+
+	// A failed register is undone by Unregister-Tasks.ps1.
+	for _, name := range []string{"Register-Tasks.ps1", "Unregister-Tasks.ps1"} {
+		if err := os.WriteFile(filepath.Join(h.root, "scripts", name), []byte("exit 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code := cmdInstall(h.d, []string{"/S", `-user=CORP\alice`}); code != exitInstallFailure {
+		t.Fatalf("failing register: exit %d", code)
+	}
+	log := filepath.Join(h.d.logDir, installLogName)
+	h.assertLog(log, "INFO", `-User CORP\alice`)
+	h.assertLog(log, "ERROR", "install failed")
+	h.assertLog(log, "INFO", "--- rollback: ")
+}
+
+func TestIntegrationPresets(t *testing.T) {
+	h := newHarness(t)
+	if code := cmdInstall(h.d, []string{"/S", "-profile=kiosk"}); code != 2 {
+		t.Fatalf("unknown profile: exit %d", code)
+	}
+}
+
+func TestIntegrationRepairCommand(t *testing.T) {
+	h := newHarness(t)
+
+	// Layer A on a healthy cache: nothing to do.
+	if code := cmdRepair(h.d, []string{"-reason=event"}); code != 0 {
+		t.Fatalf("healthy cache: exit %d", code)
+	}
+	h.noRepairs(0)
+	h.assertLog(h.d.watchLog, "INFO", "No repair needed")
+
+	// A broken index is repaired, and the repair counts for the cooldown.
+	os.Remove(filepath.Join(h.cache, "iconcache_idx.db"))
+	if code := cmdRepair(h.d, []string{"-reason=event"}); code != 0 {
+		t.Fatalf("broken index: exit %d", code)
+	}
+	reasons := h.waitRepairs(1)
+	if !strings.HasPrefix(reasons[0], "event (health score") {
+		t.Fatalf("repair reason = %q", reasons[0])
+	}
+	os.Remove(filepath.Join(h.cache, "iconcache_idx.db"))
+	cmdRepair(h.d, []string{"-reason=event"})
+	h.noRepairs(1)
+	h.assertLog(h.d.watchLog, "WARN", "Cooldown active")
+
+	// The daemon's trampoline request has passed the cooldown already.
+	h.d.mu.Lock()
+	h.d.taskRequest = &repairRequest{Reason: "size 40.00 MB exceeds 32MB limit", Requested: h.clock.Now()}
+	h.d.saveState()
+	h.d.taskRequest = nil
+	h.d.mu.Unlock()
+	if code := cmdRepair(h.d, []string{"-reason=event"}); code != 0 {
+		t.Fatalf("handed-over repair: exit %d", code)
+	}
+	if reasons := h.waitRepairs(2); reasons[1] != "size 40.00 MB exceeds 32MB limit" {
+		t.Fatalf("repair reason = %q", reasons[1])
+	}
+	if raw, _ := os.ReadFile(h.d.stateFile); strings.Contains(string(raw), "repairRequest") {
+		t.Fatal("request left in state.json")
+	}
+}
+
+func TestIntegrationTriggerSpool(t *testing.T) {
+	h := newHarness(t)
+	h.d.healthNow = make(chan string, 1)
+	event := triggerPayload{Version: triggerVersion, Created: h.clock.Now(), Source: "event", Reason: "event",
+		Evidence: map[string]string{"eventId": "1000", "provider": "Application Error"}}
+
+	// A check trigger asks for a health check.
+	if _, err := h.d.spoolTrigger(event); err != nil {
+		t.Fatal(err)
+	}
+	h.d.drainTriggers()
+	select {
+	case why := <-h.d.healthNow:
+		if why != "event trigger: event (eventId=1000, provider=Application Error)" {
+			t.Fatalf("health check reason = %q", why)
+		}
+	default:
+		t.Fatal("no health check requested")
+	}
+	h.assertLog(h.d.watchLog, "TRIGGER", "Spooled event trigger: event (eventId=1000, provider=Application Error) (priority check).")
+
+	// Stale and unreadable payloads are dropped; a critical one repairs.
+	stale := event
+	stale.Created = h.clock.Now().Add(-48 * time.Hour)
+	h.d.spoolTrigger(stale)
+	os.WriteFile(filepath.Join(h.d.triggerDir(), "1-garbage.json"), []byte("{"), 0644)
+	critical := event
+	critical.Source, critical.Priority = "rmm", triggerCritical
+	h.d.spoolTrigger(critical)
+	h.d.drainTriggers()
+	h.waitRepairs(1)
+	h.assertLog(h.d.watchLog, "WARN", "Discarded unreadable trigger 1-garbage.json.")
+	h.assertLog(h.d.watchLog, "WARN", "Discarded stale trigger from event (48h0m0s old)")
+	if left, _ := os.ReadDir(h.d.triggerDir()); len(left) != 0 {
+		t.Fatalf("spool not drained: %v", left)
+	}
+
+	// Without a running daemon, trigger decides like repair (cooldown here).
+	if code := runCommand(h.d, []string{"trigger", "-source=event", "-reason=event", "-evidence=eventId=1002", "-evidence=module="}); code != 0 {
+		t.Fatalf("trigger exit %d", code)
+	}
+	h.assertLog(h.d.watchLog, "WARN", "Skipping repair. Reason was: event trigger: event (eventId=1002)")
+	if left, _ := os.ReadDir(h.d.triggerDir()); len(left) != 0 {
+		t.Fatalf("trigger left queued after the repair fallback: %v", left)
+	}
+	if code := runCommand(h.d, []string{"trigger", "-priority=urgent"}); code != 2 {
+		t.Fatalf("trigger with a bad priority: exit %d, want 2", code)
+	}
+}
+
+func TestIntegrationOfflineTriggerQueue(t *testing.T) {
+	h := newHarness(t)
+	h.d.healthNow = make(chan string, 1)
+
+	// Raised while no daemon ran: one queued, one claimed by a daemon that
+	// died before running it.
+	boot := triggerPayload{Version: triggerVersion, Created: h.clock.Now().Add(-3 * time.Hour), Source: "event", Reason: "boot"}
+	if _, err := h.d.spoolTrigger(boot); err != nil {
+		t.Fatal(err)
+	}
+	crash := triggerPayload{Version: triggerVersion, Created: h.clock.Now().Add(-time.Hour), Source: "rmm", Reason: "crash", Priority: triggerNormal}
+	file, err := h.d.spoolTrigger(crash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(file, file+".claimed"); err != nil {
+		t.Fatal(err)
+	}
+
+	h.d.drainQueuedTriggers()
+	h.assertLog(h.d.watchLog, "INFO", "2 triggers queued while the daemon was not running.")
+	h.assertLog(h.d.watchLog, "TRIGGER", "Spooled event trigger: boot (priority check).")
+	h.assertLog(h.d.watchLog, "TRIGGER", "Spooled rmm trigger: crash (priority normal).")
+	if why := <-h.d.healthNow; why != "event trigger: boot" {
+		t.Fatalf("health check reason = %q", why)
+	}
+	h.waitRepairs(1)
+	if left, _ := os.ReadDir(h.d.triggerDir()); len(left) != 0 {
+		t.Fatalf("queue not drained: %v", left)
+	}
+}
+
+func TestIntegrationSizeTriggerRepairs(t *testing.T) {
+	h := newHarness(t)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+
+	reasons := h.waitRepairs(1)
+	if !strings.Contains(reasons[0], "exceeds") {
+		t.Fatalf("repair reason = %q", reasons[0])
+	}
+	h.assertLog(h.d.watchLog, "TRIGGER", "MB threshold")
+	h.assertLog(h.d.watchLog, "INFO", "Command line:")
+	h.assertLog(h.d.watchLog, "INFO", "R201 Repair script launched. Reason was: ")
+	if got := h.d.getCacheSizeMB(); got >= defaultSizeLimit.MB() {
+		t.Fatalf("cache still %.2f MB after repair", got)
+	}
+}
+
+func TestIntegrationCooldown(t *testing.T) {
+	h := newHarness(t)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.d.checkSize() // the repaired cache re-arms the size trigger
+
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.noRepairs(1)
+	h.assertLog(h.d.watchLog, "WARN", "Cooldown active (30m remaining)")
+
+	// Cooldown survives a restart via state.json...
+	restarted := &daemon{stateFile: h.d.stateFile, logDir: h.d.logDir, watchLog: h.d.watchLog, clock: h.clock}
+	restarted.loadState()
+	if restarted.lastRepair.IsZero() {
+		t.Fatal("lastRepair not persisted to state.json")
+	}
+
+	// ...and expires after thresholds.cooldown.
+	h.clock.Advance(defaultCooldown.D() + time.Minute)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(2)
+
+	// Sub-minute cooldowns work too.
+	h.d.cfg.Thresholds.Cooldown = duration(20 * time.Second)
+	h.d.checkSize()
+	h.clock.Advance(5 * time.Second)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.noRepairs(2)
+	h.assertLog(h.d.watchLog, "WARN", "Cooldown active (15s remaining)")
+	h.clock.Advance(16 * time.Second)
+	h.d.checkSize()
+	h.waitRepairs(3)
+}
+
+func TestIntegrationSizeTriggerDedup(t *testing.T) {
+	h := newHarness(t)
+	triggers := func() int {
+		raw, _ := os.ReadFile(h.d.watchLog)
+		return strings.Count(string(raw), "[TRIGGER] W010 Cache is ")
+	}
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+
+	// A cache that stays over the limit during the cooldown is logged once,
+	// then summarized every thresholds.stillExceededEvery.
+	h.bloat(sizeLimitMB + 8)
+	for i := 0; i < 20; i++ {
+		h.clock.Advance(30 * time.Second)
+		h.d.checkSize()
+	}
+	if n := triggers(); n != 1 {
+		t.Fatalf("%d size triggers during the cooldown, want 1", n)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "Cache still exceeds the 32MB threshold: ")
+	h.assertLog(h.d.watchLog, "INFO", "10m over it, 20 polls since the last line. Size trigger re-arms when the cooldown ends (20m) or the cache drops below the threshold.")
+	h.noRepairs(1)
+
+	// Dropping below the limit re-arms the trigger at once...
+	h.bloat(1)
+	h.clock.Advance(30 * time.Second)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "INFO", "Cache is back under the 32MB threshold")
+	h.assertLog(h.d.watchLog, "INFO", "after 10m30s over it. Size trigger re-armed.")
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	if n := triggers(); n != 2 {
+		t.Fatalf("%d size triggers after re-arming, want 2", n)
+	}
+	h.assertLog(h.d.watchLog, "WARN", "Cooldown active (19m30s remaining)")
+
+	// ...and so does the end of the cooldown.
+	h.clock.Advance(19 * time.Minute)
+	h.d.checkSize()
+	h.noRepairs(1)
+	h.clock.Advance(30 * time.Second)
+	h.d.checkSize()
+	h.waitRepairs(2)
+	if n := triggers(); n != 3 {
+		t.Fatalf("%d size triggers after the cooldown, want 3", n)
+	}
+}
+
+func TestIntegrationSizeRepairVerification(t *testing.T) {
+	h := newHarness(t)
+	// finish waits for the repair's script to exit and the cache it left
+	// (rebuilt at once when big) to be polled after sizeRepair.verifyAfter.
+	finish := func(n int, rebuiltMB int) {
+		t.Helper()
+		h.waitRepairs(n)
+		for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			h.d.mu.Lock()
+			running := h.d.inFlight != nil
+			h.d.mu.Unlock()
+			if !running {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("repair script did not exit")
+			}
+		}
+		if rebuiltMB > 0 {
+			h.bloat(rebuiltMB)
+		}
+		h.clock.Advance(defaultSizeRepairOptions().VerifyAfter.D())
+		h.d.checkSize()
+	}
+
+	// A repair after which the cache is still big escalates to a deep clean...
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	finish(1, sizeLimitMB+6)
+	h.assertLog(h.d.watchLog, "WARN", "Size repair ineffective: cache is ")
+	h.assertLog(h.d.watchLog, "WARN", "above the recovery threshold of 16.00 MB (50% of 32MB); 1 ineffective repair in a row.")
+	h.assertLog(h.d.watchLog, "INFO", "Escalating: the next size repair is a deep clean.")
+	h.clock.Advance(defaultCooldown.D())
+	h.d.checkSize()
+	if r := h.waitRepairs(2); !strings.HasSuffix(r[1], "(deep clean: 1 ineffective repair)") {
+		t.Fatalf("escalated repair = %q", r[1])
+	}
+
+	// ...and a second one suspends size repairs, across restarts too.
+	finish(2, sizeLimitMB+6)
+	h.assertLog(h.d.watchLog, "ERROR", "Size repairs suspended")
+	h.clock.Advance(defaultCooldown.D() + time.Minute)
+	h.d.checkSize()
+	h.noRepairs(2)
+	if r := h.d.statusSnapshot(); r.SizeRepair == nil || !r.SizeRepair.Suspended || r.SizeRepair.Ineffective != 2 {
+		t.Fatalf("status sizeRepair = %+v", r.SizeRepair)
+	}
+	if m := h.d.metrics.snapshot(); m[mRepairsIneffective].Value != 2 {
+		t.Fatalf("ineffective repairs metric = %v", m[mRepairsIneffective].Value)
+	}
+	restarted := &daemon{stateFile: h.d.stateFile, logDir: h.d.logDir, watchLog: h.d.watchLog, clock: h.clock}
+	restarted.loadState()
+	if !restarted.sizeRepair.Suspended {
+		t.Fatal("size repair suspension not persisted to state.json")
+	}
+
+	// A cache below the recovery threshold resumes them, and a repair that
+	// shrinks the cache is verified.
+	h.bloat(1)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "INFO", "Size repairs resumed.")
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	finish(3, 0)
+	h.assertLog(h.d.watchLog, "INFO", "Size repair verified: cache is ")
+	if r := h.waitRepairs(3); strings.Contains(r[2], "deep clean") {
+		t.Fatalf("repair after resuming = %q", r[2])
+	}
+	if h.d.sizeRepair != (sizeRepairState{}) {
+		t.Fatalf("size repair state after verification = %+v", h.d.sizeRepair)
+	}
+}
+
+func TestIntegrationVDIReportOnly(t *testing.T) {
+	h := newHarness(t)
+	h.d.profile.VDI = vdiInfo{Platform: vdiCitrixPVS, NonPersistent: true}
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.noRepairs(0)
+	h.assertLog(h.d.watchLog, "WARN", "Report-only (non-persistent desktop, Citrix PVS (non-persistent)): repair not run")
+	if msg := h.d.repairSkipReason(); !strings.HasPrefix(msg, "Report-only") {
+		t.Fatalf("repair command skip reason = %q", msg)
+	}
+
+	// A profile container keeps the cache unless redirections.xml excludes it.
+	excluded := []byte(`<FrxProfileFolderRedirection ExcludeCommonFolders="0">
+  <Excludes><Exclude Copy="0">AppData\Local\Microsoft\Windows</Exclude></Excludes>
+  <Includes><Include>AppData\Local\Microsoft\Windows\Caches</Include></Includes>
+</FrxProfileFolderRedirection>`)
+	if !redirectionsExclude(excluded, cacheProfilePath) {
+		t.Fatal("excluded cache folder not detected")
+	}
+	if redirectionsExclude([]byte(`<FrxProfileFolderRedirection><Excludes><Exclude>AppData\Local</Exclude></Excludes>
+<Includes><Include>appdata/local/microsoft/windows/explorer</Include></Includes></FrxProfileFolderRedirection>`), cacheProfilePath) {
+		t.Fatal("re-included cache folder reported as excluded")
+	}
+	h.d.profile.VDI.FSLogix = true
+	h.d.checkSize()
+	h.waitRepairs(1)
+
+	h.d.cfg.VDI.Mode = vdiModeOn
+	if why := h.d.reportOnlyReason(); why == "" {
+		t.Fatal("vdi.mode on does not report only")
+	}
+}
+
+func TestIntegrationAntivirusAdvisory(t *testing.T) {
+	h := newHarness(t)
+	defender := avEngines["msmpeng.exe"]
+	h.d.recordAV(h.clock.Now(), map[string]int{defender: 3})
+	if h.d.avAdvisory != nil {
+		t.Fatal("advisory after a single sighting")
+	}
+	h.d.recordAV(h.clock.Now(), nil)
+	h.d.recordAV(h.clock.Now(), map[string]int{defender: 1, "Sophos": 2})
+	h.assertLog(h.d.healthLog, "WARN", "ANTIVIRUS ADVISORY: Microsoft Defender Antivirus held the icon cache open during 2 of the last 3 repairs")
+	h.assertLog(h.d.healthLog, "INFO", "Add-MpPreference -ExclusionPath")
+
+	// Sightings survive a restart via state.json and bring the advisory back.
+	restarted := &daemon{stateFile: h.d.stateFile, logDir: h.d.logDir, watchLog: h.d.watchLog, clock: h.clock, cacheDir: h.cache}
+	restarted.loadState()
+	if a := restarted.avAdvisory; a == nil || a.Product != defender || a.Of != 3 {
+		t.Fatalf("advisory after restart = %+v", a)
+	}
+	if got := h.d.statusSnapshot().Antivirus; got == nil || got.Command == "" {
+		t.Fatalf("status advisory = %+v", got)
+	}
+}
+
+func TestIntegrationExplorerHung(t *testing.T) {
+	h := newHarness(t)
+	probes := 0
+	hung := func(time.Duration) []shellProbe {
+		probes++
+		return []shellProbe{{Window: "taskbar", Responded: probes > 2}, {Window: "desktop", Responded: probes != 1}}
+	}
+	// A desktop that misses one probe is not hung; a taskbar that misses two is.
+	if pass, st := h.d.rateShellProbes(hung); pass || !slices.Equal(st.Hung, []string{"taskbar"}) {
+		t.Fatalf("pass=%v status=%+v", pass, st)
+	}
+	h.assertLog(h.d.healthLog, "WARN", "H7 FAIL: Explorer is not responding: taskbar did not answer within 5s")
+	if pass, _ := h.d.rateShellProbes(hung); !pass {
+		t.Fatal("responding shell failed H7")
+	}
+
+	// With restartExplorer a hang restarts Explorer and keeps the cache.
+	h.d.cfg.Responsiveness.RestartExplorer = true
+	h.bloat(sizeLimitMB + 8)
+	h.d.restartHungExplorer("Explorer not responding: taskbar")
+	if got := h.waitRepairs(1); got[0] != "Explorer not responding: taskbar" {
+		t.Fatalf("reason = %q", got[0])
+	}
+	h.assertLog(h.d.watchLog, "TRIGGER", "Explorer restart triggered")
+	h.assertLog(h.d.watchLog, "INFO", "-RestartExplorer")
+	if size := h.d.getCacheSizeMB(); size <= defaultSizeLimit.MB() {
+		t.Fatalf("cache rebuilt by an Explorer restart: %.2f MB", size)
+	}
+	h.d.restartHungExplorer("Explorer not responding: taskbar")
+	h.assertLog(h.d.watchLog, "WARN", "Skipping Explorer restart")
+	h.noRepairs(1)
+}
+
+func TestIntegrationShellRescue(t *testing.T) {
+	h := newHarness(t)
+	h.d.history = h.d.loadHistory()
+	starts := 0
+	start := func() error { starts++; return nil }
+	observe := func(running, unlocked bool, wait time.Duration) {
+		h.d.rescueShell(running, unlocked, start)
+		h.clock.Advance(wait)
+	}
+
+	// A locked session or a running repair is left alone.
+	observe(false, false, time.Minute)
+	observe(false, false, time.Minute)
+	h.d.inFlight = &repairInFlight{Reason: "size"}
+	observe(false, true, time.Minute)
+	observe(false, true, time.Minute)
+	h.d.inFlight = nil
+	if starts != 0 {
+		t.Fatalf("%d shell start(s) while locked or repairing", starts)
+	}
+
+	// Missing for shellRescue.after in an unlocked session: start it.
+	observe(false, true, 20*time.Second)
+	observe(false, true, 20*time.Second)
+	observe(false, true, 0)
+	if starts != 1 {
+		t.Fatalf("starts = %d, want 1", starts)
+	}
+	h.assertLog(h.d.watchLog, "WARN", "SHELL RESCUE: no explorer.exe for 40s in an unlocked session")
+	if view := h.d.history.snapshot(h.clock.Now()); len(view.Events) != 1 || view.Events[0].Kind != historyRescue {
+		t.Fatalf("events = %+v", view.Events)
+	}
+
+	// A shell that keeps dying is started shellRescueAttempts times in all.
+	for i := 0; i < 10; i++ {
+		observe(false, true, time.Minute)
+	}
+	if starts != shellRescueAttempts {
+		t.Fatalf("starts = %d, want %d", starts, shellRescueAttempts)
+	}
+	h.assertLog(h.d.watchLog, "ERROR", "Giving up until it runs again")
+	observe(true, true, 0)
+	h.assertLog(h.d.watchLog, "INFO", "Explorer is running again")
+	if got := h.d.metrics.snapshot()[mShellRescues].Value; got != shellRescueAttempts {
+		t.Fatalf("rescue metric = %v", got)
+	}
+}
+
+func TestIntegrationShellStability(t *testing.T) {
+	h := newHarness(t)
+
+	// A new taskbar process counts as a restart unless a repair started it.
+	h.d.observeShell(100)
+	h.clock.Advance(30 * time.Second)
+	h.d.observeShell(100)
+	h.d.observeShell(200)
+	h.assertLog(h.d.watchLog, "WARN", "Explorer shell restarted outside a repair (process 200 replaced 100); 1 restart in the last 1d.")
+	h.clock.Advance(30 * time.Second)
+	h.d.mu.Lock()
+	h.d.lastRepair = h.clock.Now()
+	h.d.mu.Unlock()
+	h.clock.Advance(30 * time.Second)
+	h.d.observeShell(300)
+
+	// WER reports for explorer.exe within the window count; others do not.
+	wer := filepath.Join(filepath.Dir(h.d.cacheDir), "WER", "ReportArchive")
+	report := func(name string, age time.Duration) {
+		dir := filepath.Join(wer, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		at := h.clock.Now().Add(-age)
+		os.Chtimes(dir, at, at)
+	}
+	report("AppCrash_explorer.exe_5c1f_1", time.Hour)
+	report("AppHang_explorer.exe_77a0_2", 2*time.Hour)
+	report("AppCrash_notepad.exe_0b3e_3", time.Hour)
+	report("AppCrash_explorer.exe_9d2e_4", 48*time.Hour)
+	pass, st := h.d.checkH8Stability()
+	if !pass || st.Crashes != 1 || st.Hangs != 1 || st.Restarts != 1 {
+		t.Fatalf("H8 = %v, %+v", pass, st)
+	}
+	h.assertLog(h.d.healthLog, "PASS", "H8 PASS: Explorer is stable: 1 crash report, 1 hang report and 1 restart in the last 1d.")
+	h.assertLog(h.d.healthLog, "WARN", "New Windows Error Reporting report for Explorer: AppCrash_explorer.exe_5c1f_1")
+
+	// One more crash tips it over maxEvents; alone it does not repair.
+	report("AppCrash_explorer.exe_e401_5", 0)
+	res := h.d.evaluateHealth(true)
+	if res.Heuristics["h8"] || res.Stability == nil || res.Stability.events() != 3 || res.RepairNeeded {
+		t.Fatalf("health = %+v, stability %+v", res, res.Stability)
+	}
+	h.assertLog(h.d.healthLog, "WARN", "H8 FAIL: Explorer is unstable: 2 crash reports, 1 hang report and 1 restart in the last 1d (more than 2).")
+	h.noRepairs(0)
+	m := h.d.metrics.snapshot()
+	if m[mShellRestarts].Value != 1 || m[mShellCrashReports].Value != 3 || m[mShellEvents].Value != 3 {
+		t.Fatalf("metrics: restarts %v, crash reports %v, events %v", m[mShellRestarts].Value, m[mShellCrashReports].Value, m[mShellEvents].Value)
+	}
+}
+
+func TestIntegrationShellLauncher(t *testing.T) {
+	h := newHarness(t)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "-ShellLauncher "+exe)
+
+	// start-shell leaves a running Explorer alone (always assumed here).
+	if code := runCommand(h.d, []string{"start-shell", "-session=2"}); code != 0 {
+		t.Fatalf("start-shell exit %d", code)
+	}
+	if code := runCommand(h.d, []string{"start-shell", "-session=x"}); code != 2 {
+		t.Fatalf("start-shell with a bad session: exit %d, want 2", code)
+	}
+}
+
+func TestIntegrationPause(t *testing.T) {
+	h := newHarness(t)
+	h.d.history = h.d.loadHistory()
+	h.startControl()
+	if code := cmdPause(h.d, []string{"-for", "2d"}); code != 1 {
+		t.Fatalf("pause beyond pause.max exited %d", code)
+	}
+	if code := cmdPause(h.d, []string{"-for", "2h", "-reason", "VM import"}); code != 0 {
+		t.Fatalf("pause exited %d", code)
+	}
+	h.assertLog(h.d.watchLog, "WARN", "(VM import) via control pipe; no repairs until then.")
+
+	// Triggers and requests are skipped while paused.
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "WARN", "Skipping repair. Reason was: size")
+	if out := h.d.requestRepair("dashboard"); out.Started || !strings.Contains(out.Result, "Resume it first") {
+		t.Fatalf("repair request while paused = %+v", out)
+	}
+	h.noRepairs(0)
+	if st := h.d.statusSnapshot(); st.Paused == nil || st.Paused.Reason != "VM import" {
+		t.Fatalf("status paused = %+v", st.Paused)
+	}
+
+	// The pause survives a restart and ends on its own.
+	restarted := &daemon{stateFile: h.d.stateFile, logDir: h.d.logDir, watchLog: h.d.watchLog, clock: h.clock}
+	restarted.loadState()
+	if p := restarted.pause; p == nil || !p.Until.Equal(h.clock.Now().Add(2*time.Hour)) {
+		t.Fatalf("pause after restart = %+v", p)
+	}
+	h.clock.Advance(2*time.Hour + time.Minute)
+	h.d.checkPause()
+	h.assertLog(h.d.watchLog, "INFO", "Pause ended at")
+	h.d.checkSize()
+	h.waitRepairs(1)
+
+	// resume lifts a pause early.
+	if code := cmdPause(h.d, []string{"-for", "30m"}); code != 0 {
+		t.Fatalf("pause exited %d", code)
+	}
+	if code := cmdResume(h.d, nil); code != 0 {
+		t.Fatalf("resume exited %d", code)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "Watchdog resumed via control pipe")
+	if st := h.d.statusSnapshot(); st.Paused != nil {
+		t.Fatalf("still paused: %+v", st.Paused)
+	}
+	var kinds []string
+	for _, e := range h.d.history.snapshot(h.clock.Now()).Events {
+		kinds = append(kinds, e.Kind)
+	}
+	if !slices.Contains(kinds, historyPaused) || !slices.Contains(kinds, historyResumed) {
+		t.Fatalf("history kinds = %q", kinds)
+	}
+}
+
+func TestIntegrationLedgerMismatch(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Ledger.Enabled = true
+	if res := h.d.evaluateHealth(false); !res.Healthy || res.Ledger != nil {
+		t.Fatalf("first check: healthy=%v ledger=%+v", res.Healthy, res.Ledger)
+	}
+
+	// Rewrite one byte of the second block and put the timestamp back.
+	path := filepath.Join(h.cache, "iconcache_32.db")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte{0xff}, ledgerBlockSize+7)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	res := h.d.evaluateHealth(false)
+	if !res.RepairNeeded || res.Heuristics["h6"] || res.Ledger == nil || len(res.Ledger.Mismatches) != 1 {
+		t.Fatalf("tampered block not caught: %+v", res)
+	}
+	if m := res.Ledger.Mismatches[0]; m.File != "iconcache_32.db" || m.Offset != ledgerBlockSize {
+		t.Fatalf("mismatch = %+v", m)
+	}
+	h.assertLog(h.d.healthLog, "WARN", "H6 FAIL: cache content changed without a size or modification time change")
+	h.assertLog(h.d.healthLog, "INFO", "1 rehashed (iconcache_32.db 1/2 blocks with mtime unchanged); 5 unchanged")
+
+	// A change that moves the modification time is Explorer's own.
+	later := info.ModTime().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if res := h.d.evaluateHealth(false); !res.Heuristics["h6"] || res.Ledger.Explained != 1 {
+		t.Fatalf("explained change failed H6: %+v", res.Ledger)
+	}
+
+	// The ledger recorded by that check is the base of the next diff.
+	if err := os.WriteFile(filepath.Join(h.cache, "thumbcache_96.db"), make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(h.cache, "iconcache_wide.db")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(h.cache, "iconcache_48.db"), make([]byte, 320*1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := h.d.evaluateHealth(false).Ledger.Diff
+	if len(d.Added) != 1 || len(d.Removed) != 1 || len(d.Resized) != 1 || len(d.Rehashed) != 0 {
+		t.Fatalf("diff = %+v", d)
+	}
+	h.assertLog(h.d.healthLog, "INFO", "1 added (thumbcache_96.db); 1 removed (iconcache_wide.db); 1 resized (iconcache_48.db +0.06 MB (0.25 -> 0.31 MB)); 4 unchanged")
+
+	if got := ledgerOffsets(10*ledgerBlockSize, 3); len(got) != 3 || got[0] != 0 || got[2] != 9*ledgerBlockSize {
+		t.Fatalf("sampled offsets = %v", got)
+	}
+}
+
+func TestIntegrationCorruptIndexRepairsAndVerifies(t *testing.T) {
+	h := newHarness(t)
+	if err := os.WriteFile(filepath.Join(h.cache, "iconcache_idx.db"), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.d.checkHealth()
+	h.assertLog(h.d.healthLog, "WARN", "H1 FAIL")
+	h.assertLog(h.d.healthLog, "REPAIR", "HEALTH SCORE BELOW THRESHOLD")
+	h.waitRepairs(1)
+
+	// Verification: the next health check sees the rebuilt cache.
+	h.d.checkHealth()
+	h.d.mu.Lock()
+	healthy, score := h.d.lastHealthy, h.d.lastScore
+	h.d.mu.Unlock()
+	if !healthy || score != 100 {
+		t.Fatalf("after repair: healthy=%t score=%d", healthy, score)
+	}
+}
+
+func TestIntegrationTamperedScriptRefused(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.RepairScriptSHA256 = strings.Repeat("0", 64)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+
+	h.assertLog(h.d.watchLog, "TAMPER", "SHA-256 mismatch")
+	h.noRepairs(0)
+}
+
+func TestIntegrationQuietHoursDeferAndReplay(t *testing.T) {
+	h := newHarness(t)
+	now := h.clock.Now()
+	h.d.cfg.Quiet.Hours = fmt.Sprintf("%s-%s", now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04"))
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.noRepairs(0)
+	h.assertLog(h.d.watchLog, "WARN", "Repair deferred during quiet hours")
+
+	h.d.cfg.Quiet.Hours = ""
+	h.d.checkSize()
+	reasons := h.waitRepairs(1)
+	if !strings.Contains(reasons[0], "(deferred)") {
+		t.Fatalf("repair reason = %q, want replayed deferred repair", reasons[0])
+	}
+}
+
+func TestIntegrationProcessHold(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Quiet.HoldForProcesses = "obs64.exe, sleep"
+	blocker := exec.Command("sleep", "60")
+	if err := blocker.Start(); err != nil {
+		t.Skipf("cannot start sleep: %v", err)
+	}
+	t.Cleanup(func() { blocker.Process.Kill(); blocker.Wait() })
+
+	// A critical repair waits too.
+	h.d.triggerRepair("index corrupt", priorityCritical)
+	h.noRepairs(0)
+	h.assertLog(h.d.watchLog, "WARN", "Repair deferred during blocklisted process sleep: index corrupt")
+
+	blocker.Process.Kill()
+	blocker.Wait()
+	h.d.replayDeferredRepair()
+	h.waitRepairs(1)
+}
+
+func TestIntegrationLowDiskSpace(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Reports.Enabled = true
+	h.d.cfg.Thresholds.MinFreeSpace = 1 << 60 // more than any test machine has
+
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "WARN", "Low disk space: ")
+	h.assertLog(h.d.healthLog, "WARN", "DISK SPACE ALERT: ")
+	h.noRepairs(0)
+	if msg := h.d.repairSkipReason(); !strings.HasPrefix(msg, "Low disk space") {
+		t.Fatalf("skip reason = %q", msg)
+	}
+	res := h.d.evaluateHealth(false)
+	if res.DiskSpace == nil || !res.DiskSpace.Low {
+		t.Fatalf("health result disk space = %+v", res.DiskSpace)
+	}
+	h.d.reportMu.Lock()
+	alerts := h.d.report.DiskSpaceAlerts
+	h.d.reportMu.Unlock()
+	if alerts != 1 {
+		t.Fatalf("daily report disk space alerts = %d, want 1 per low period", alerts)
+	}
+
+	h.d.cfg.Thresholds.MinFreeSpace = mib
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.assertLog(h.d.healthLog, "INFO", "Disk space recovered")
+}
+
+func TestIntegrationRelocatedCachePath(t *testing.T) {
+	h := newHarness(t)
+	data := filepath.Join(t.TempDir(), "Profiles", "user", "AppData", "Local")
+	if err := os.MkdirAll(filepath.Join(data, "Explorer"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "Local")
+	if err := os.Symlink(data, local); err != nil {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+
+	configured := filepath.Join(local, "Explorer")
+	h.d.cacheDir = configured
+	h.d.resolveCachePath()
+	want, _ := filepath.EvalSymlinks(filepath.Join(data, "Explorer"))
+	if h.d.cacheDir != want || h.d.cacheLink != configured {
+		t.Fatalf("cache dir = %q via %q, want %q via %q", h.d.cacheDir, h.d.cacheLink, want, configured)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "(junction or symbolic link). Monitoring the target.")
+	if st := h.d.statusSnapshot(); st.CacheLink != configured {
+		t.Fatalf("status cache link = %q", st.CacheLink)
+	}
+	// The Layer A and Intune entry points look at the target too.
+	for _, args := range [][]string{{"compliance", "detect"}, {"repair", "-force"}} {
+		h.d.cacheDir, h.d.cacheLink = configured, ""
+		captureStdout(t, func() int { return runCommand(h.d, args) })
+		if h.d.cacheDir != want || h.d.cacheLink != configured {
+			t.Fatalf("%s: cache dir = %q via %q", args[0], h.d.cacheDir, h.d.cacheLink)
+		}
+	}
+	h.waitRepairs(1)
+
+	// A link whose target was removed is reported, not followed.
+	gone := filepath.Join(t.TempDir(), "Gone")
+	if err := os.Symlink(filepath.Join(t.TempDir(), "missing"), gone); err != nil {
+		t.Fatal(err)
+	}
+	h.d.cacheDir, h.d.cacheLink = filepath.Join(gone, "Explorer"), ""
+	h.d.resolveCachePath()
+	h.assertLog(h.d.watchLog, "ERROR", "a junction or link whose target is missing")
+	if h.d.cacheDir != filepath.Join(gone, "Explorer") || h.d.cacheLink != "" {
+		t.Fatalf("dangling link resolved to %q via %q", h.d.cacheDir, h.d.cacheLink)
+	}
+
+	h.d.cacheDir = filepath.Join(data, "NotYet")
+	h.d.resolveCachePath()
+	h.assertLog(h.d.watchLog, "INFO", "does not exist yet")
+}
+
+func TestIntegrationUnicodePaths(t *testing.T) {
+	h := newHarness(t)
+	// An accented user name with brackets, the cache nested past MAX_PATH.
+	cache := filepath.Join(t.TempDir(), "Jösé Müller [IT]", "AppData", "Local")
+	for i := 0; len(cache) < 320; i++ {
+		cache = filepath.Join(cache, fmt.Sprintf("Ördner-%02d-%s", i, strings.Repeat("ß", 24)))
+	}
+	cache = filepath.Join(cache, "Explorer")
+	if err := writeHealthyCache(cache); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(cacheDirEnv, cache)
+	h.cache, h.d.cacheDir = cache, cache
+	h.d.resolveCachePath()
+	if h.d.cacheDir != cache {
+		t.Fatalf("cache dir resolved to %q", h.d.cacheDir)
+	}
+	h.d.checkHealth()
+	h.assertLog(h.d.healthLog, "PASS", "ALL HEURISTICS PASSED")
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.d.writeStatus()
+	raw, err := os.ReadFile(h.d.statusFile())
+	if err != nil || !strings.Contains(string(raw), "Jösé Müller [IT]") {
+		t.Fatalf("status.json does not keep the cache path: %v", err)
+	}
+
+	// A shortcut stores its LinkInfo path in the system code page.
+	dir := filepath.Join(t.TempDir(), "Café")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	lnk := filepath.Join(t.TempDir(), "Café.lnk")
+	writeShortcut(t, lnk, strings.ReplaceAll(dir, "é", "\xe9"), "tool.exe")
+	if s, err := readShortcut(lnk); err != nil || s.Target != dir+`\tool.exe` {
+		t.Fatalf("shortcut = %+v, %v", s, err)
+	}
+}
+
+func TestIntegrationHeuristicTrackRecord(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Health.RepairBelowScore = 0
+	check := func(staleAge time.Duration) heuristicRecord {
+		t.Helper()
+		h.d.cfg.Thresholds.StaleAge = duration(staleAge)
+		h.d.checkHealth()
+		return h.d.statusSnapshot().Heuristics["h4"]
+	}
+
+	start := h.clock.Now()
+	if r := check(defaultStaleAge.D()); r.failing() || !r.LastPass.Equal(start) || !r.LastFail.IsZero() {
+		t.Fatalf("after a pass: %+v", r)
+	}
+	// Times never set are left out of the JSON, not written as year 1.
+	if raw, _ := json.Marshal(h.d.statusSnapshot().Heuristics["h4"]); strings.Contains(string(raw), "lastFail") || strings.Contains(string(raw), "failingSince") || !strings.Contains(string(raw), `"lastPass":`) {
+		t.Fatalf("record JSON = %s", raw)
+	}
+	h.clock.Advance(2 * time.Hour)
+	failed := h.clock.Now()
+	check(time.Hour)
+	h.clock.Advance(45 * time.Minute)
+	r := check(time.Hour)
+	if !r.FailingSince.Equal(failed) || !r.LastPass.Equal(start) || len(r.Failures) != 2 {
+		t.Fatalf("after two failures: %+v", r)
+	}
+	h.d.writeStatus()
+	out, code := captureStdout(t, func() int { return runCommand(h.d, []string{"status"}) })
+	if code != 0 || !strings.Contains(out, "  H4           FAILING since "+failed.Format("2006-01-02 15:04")) || !strings.Contains(out, "  H1           passing\n") {
+		t.Fatalf("status exit %d:\n%s", code, out)
+	}
+	var prom strings.Builder
+	h.d.writeHeuristicMetrics(&prom)
+	for _, want := range []string{`iconcache_heuristic_failing{heuristic="h4"} 1`, `iconcache_heuristic_failing{heuristic="h1"} 0`, `iconcache_heuristic_recent_failures{heuristic="h4"} 2`} {
+		if !strings.Contains(prom.String(), want) {
+			t.Fatalf("metrics without %q:\n%s", want, prom.String())
+		}
+	}
+
+	// A pass ends the failing run; the failures stay for 7 days.
+	h.clock.Advance(45 * time.Minute)
+	r = check(defaultStaleAge.D())
+	if r.failing() || len(r.Failures) != 2 || !strings.HasPrefix(r.String(), "passing; 2 failures in the last 7 days, first "+failed.Format("2006-01-02 15:04")) {
+		t.Fatalf("after recovering: %+v (%s)", r, r)
+	}
+	restarted := &daemon{stateFile: h.d.stateFile, logDir: h.d.logDir, watchLog: h.d.watchLog, clock: h.clock}
+	restarted.loadState()
+	if len(restarted.heuristicStats["h4"].Failures) != 2 {
+		t.Fatalf("track record not persisted: %+v", restarted.heuristicStats)
+	}
+	h.clock.Advance(8 * 24 * time.Hour)
+	if r = check(defaultStaleAge.D() + 8*24*time.Hour); len(r.Failures) != 0 || r.String() != "passing; last failed "+r.LastFail.Format("2006-01-02 15:04") {
+		t.Fatalf("after a week: %+v (%s)", r, r)
+	}
+}
+
+func TestIntegrationStaleCacheRefresh(t *testing.T) {
+	h := newHarness(t)
+	h.clock.Advance(defaultStaleAge.D() + 24*time.Hour)
+	h.d.checkHealth()
+	h.assertLog(h.d.healthLog, "WARN", "H4 FAIL")
+	h.assertLog(h.d.healthLog, "REPAIR", "HEALTH SCORE BELOW THRESHOLD")
+	h.waitRepairs(1)
+}
+
+func TestIntegrationWatchdogSchedule(t *testing.T) {
+	h := newHarness(t)
+	go h.d.runWatchdog() // never returns; the fake tickers stay idle after the test
+	h.clock.waitTickers(t, 2)
+
+	h.bloat(sizeLimitMB + 8)
+	h.clock.Advance(30 * time.Second)
+	h.waitRepairs(1)
+
+	h.clock.Advance(defaultHeartbeatEvery.D())
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if raw, _ := os.ReadFile(h.d.watchLog); strings.Contains(string(raw), "[HEARTBEAT]") {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("no heartbeat after advancing the clock")
+}
+
+func TestIntegrationHeartbeatSinks(t *testing.T) {
+	h := newHarness(t)
+	pings := make(chan heartbeatPing, 4)
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p heartbeatPing
+		json.NewDecoder(r.Body).Decode(&p)
+		w.WriteHeader(status)
+		pings <- p
+	}))
+	defer srv.Close()
+	h.d.cfg.Heartbeat.Webhook = srv.URL + "/ping/abc"
+	h.d.cfg.Heartbeat.File = "heartbeat.json"
+	wait := func() heartbeatPing {
+		t.Helper()
+		select {
+		case p := <-pings:
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatal("no webhook ping")
+			return heartbeatPing{}
+		}
+	}
+
+	// The sinks get the startup heartbeat and then every heartbeat line.
+	go h.d.runWatchdog()
+	h.clock.waitTickers(t, 2)
+	if p := wait(); !strings.HasPrefix(p.Message, "Watchdog started.") || p.PID != os.Getpid() || p.Interval != "6h" {
+		t.Fatalf("startup ping = %+v", p)
+	}
+	// A heartbeat while the last post is in flight is skipped.
+	for h.d.webhookBusy.Load() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	h.clock.Advance(defaultHeartbeatEvery.D())
+	if p := wait(); !strings.HasPrefix(p.Message, "Watchdog alive.") {
+		t.Fatalf("heartbeat ping = %+v", p)
+	}
+	h.assertLog(h.d.watchLog, "HEARTBEAT", "Watchdog alive.")
+	raw, err := os.ReadFile(filepath.Join(h.d.dataDir, "heartbeat.json"))
+	var file struct {
+		Schema  string `json:"schema"`
+		Version int    `json:"schemaVersion"`
+		heartbeatPing
+	}
+	if err != nil || json.Unmarshal(raw, &file) != nil || file.Schema != "heartbeat" || file.Version != 1 || file.Message == "" {
+		t.Fatalf("heartbeat file = %s, %v", raw, err)
+	}
+
+	// A failing webhook is logged once, and its recovery too.
+	for h.d.webhookBusy.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status = http.StatusServiceUnavailable
+	h.d.sendHeartbeat("one")
+	wait()
+	for !h.d.webhookFailing.Load() || h.d.webhookBusy.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.assertLog(h.d.watchLog, "WARN", "Cannot deliver the heartbeat webhook: "+srv.URL+"/ping/abc answered 503 Service Unavailable")
+	status = http.StatusOK
+	h.d.sendHeartbeat("two")
+	wait()
+	for h.d.webhookFailing.Load() || h.d.webhookBusy.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "Delivering the heartbeat webhook works again.")
+}
+
+func TestIntegrationIncidents(t *testing.T) {
+	h := newHarness(t)
+	notices := make(chan incidentNotice, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n incidentNotice
+		json.NewDecoder(r.Body).Decode(&n)
+		notices <- n
+	}))
+	defer srv.Close()
+	h.d.cfg.Incidents.Webhook = srv.URL
+	h.d.history = h.d.loadHistory()
+	h.d.recordHistory()
+	h.d.startIncidents()
+	wait := func(event string) incident {
+		t.Helper()
+		select {
+		case n := <-notices:
+			if n.Event != event {
+				t.Fatalf("incident notice = %+v, want %s", n, event)
+			}
+			return n.Incident
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s incident notice", event)
+			return incident{}
+		}
+	}
+
+	// A size trigger opens an incident that the repair and its
+	// verification join...
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	inc := wait("opened")
+	if !strings.HasPrefix(inc.Cause, "Cache is ") || !slices.Equal(inc.Awaiting, []string{incidentSize}) {
+		t.Fatalf("opened incident = %+v", inc)
+	}
+	h.assertLog(h.d.watchLog, "INCIDENT", "Incident "+inc.ID+" opened: Cache is ")
+	h.assertLog(h.d.watchLog, "TRIGGER", "limit (incident "+inc.ID+")")
+	if r := h.d.statusSnapshot(); r.Incident == nil || r.Incident.ID != inc.ID {
+		t.Fatalf("status incident = %+v", r.Incident)
+	}
+	h.waitRepairs(1)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		// The history records the completion from its own subscription.
+		recorded := slices.ContainsFunc(h.d.history.snapshot(h.clock.Now()).Events, func(e historyEvent) bool { return e.Kind == phaseComplete })
+		if open := h.d.incidents.snapshot(); recorded && open != nil && !slices.Contains(open.Awaiting, incidentRepair) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("repair did not complete the incident's repair")
+		}
+	}
+
+	// ...and that is resolved once the cache is verified small again.
+	h.clock.Advance(defaultSizeRepairOptions().VerifyAfter.D())
+	h.d.checkSize()
+	closed := wait("closed")
+	var kinds []string
+	for _, s := range closed.Timeline {
+		kinds = append(kinds, s.Kind)
+	}
+	if closed.ID != inc.ID || closed.Outcome != "resolved" || strings.Join(kinds, " ") != "trigger requested launched complete verified cleared closed" {
+		t.Fatalf("closed incident = %+v (%v)", closed, kinds)
+	}
+	h.assertLog(h.d.watchLog, "INCIDENT", "Incident "+inc.ID+" resolved after 2m (1 trigger, 7 steps): cache is ")
+	if h.d.statusSnapshot().Incident != nil {
+		t.Fatal("status still shows a closed incident")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var tagged []string
+		for _, e := range h.d.history.snapshot(h.clock.Now()).Events {
+			if e.Incident == inc.ID {
+				tagged = append(tagged, e.Kind)
+			}
+		}
+		if strings.Join(tagged, " ") == "incident repair complete incident" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("history events of the incident = %v", tagged)
+		}
+	}
+
+	// A repair request that cannot start closes its incident unresolved.
+	h.d.triggerRepair("manual", priorityNormal)
+	if inc := wait("opened"); inc.Cause != "manual" {
+		t.Fatalf("opened incident = %+v", inc)
+	}
+	if closed := wait("closed"); closed.Outcome != "unresolved" || !strings.HasPrefix(closed.Timeline[len(closed.Timeline)-1].Message, "cooldown active") {
+		t.Fatalf("closed incident = %+v", closed)
+	}
+	h.assertLog(h.d.watchLog, "WARN", "unresolved after 0s (1 trigger, 3 steps): cooldown active")
+	if m := h.d.metrics.snapshot(); m[mIncidentsOpened].Value != 2 || m[mIncidentsUnresolved].Value != 1 {
+		t.Fatalf("incident metrics = %v opened, %v unresolved", m[mIncidentsOpened].Value, m[mIncidentsUnresolved].Value)
+	}
+}
+
+func TestIntegrationOTelExport(t *testing.T) {
+	h := newHarness(t)
+	type request struct {
+		path, apiKey string
+		body         []byte
+	}
+	requests := make(chan request, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.URL.Path, r.Header.Get("api-key"), body}
+	}))
+	defer srv.Close()
+	h.d.cfg.OTel.Enabled = true
+	h.d.cfg.OTel.Endpoint = srv.URL + "/"
+	h.d.cfg.OTel.Headers = "api-key=secret"
+	h.d.startOTel()
+	h.assertLog(h.d.watchLog, "INFO", "Exporting traces and metrics to "+srv.URL+"/ via OTLP.")
+	wait := func(path string) []byte {
+		t.Helper()
+		select {
+		case r := <-requests:
+			if r.path != path || r.apiKey != "secret" {
+				t.Fatalf("OTLP request to %s with api-key %q, want %s", r.path, r.apiKey, path)
+			}
+			return r.body
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no OTLP request to %s", path)
+			return nil
+		}
+	}
+
+	// A direct repair is one trace: a root span and one span per phase.
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	var traces otlpTracesRequest
+	if err := json.Unmarshal(wait("/v1/traces"), &traces); err != nil {
+		t.Fatal(err)
+	}
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+		if s.TraceID != spans[0].TraceID || s.Name != "repair" && s.ParentSpanID != spans[0].SpanID {
+			t.Fatalf("span %+v is not part of the repair trace", s)
+		}
+	}
+	if got := strings.Join(names, " "); got != "repair explorer-stopping files-deleting explorer-starting" {
+		t.Fatalf("spans = %q", got)
+	}
+	if root := spans[0]; root.Status.Code != otlpStatusOK || !strings.HasPrefix(*root.Attributes[0].Value.StringValue, "size ") {
+		t.Fatalf("repair span = %+v", root)
+	}
+
+	// The metrics go out every otel.interval.
+	h.clock.waitTickers(t, 1)
+	h.clock.Advance(defaultOTelOptions().Interval.D())
+	var metrics otlpMetricsRequest
+	if err := json.Unmarshal(wait("/v1/metrics"), &metrics); err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]otlpMetric{}
+	for _, m := range metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		byName[m.Name] = m
+	}
+	if m := byName["iconcache_repairs_started_total"]; m.Sum == nil || !m.Sum.IsMonotonic || m.Sum.DataPoints[0].AsInt != "1" {
+		t.Fatalf("repairs started = %+v", m)
+	}
+	if m := byName["iconcache_watchdog_poll_seconds"]; m.Histogram == nil || m.Unit != "s" || len(m.Histogram.DataPoints[0].BucketCounts) != len(metricBuckets)+1 {
+		t.Fatalf("poll histogram = %+v", m)
+	}
+	if m := byName["iconcache_cache_bytes"]; m.Gauge == nil || m.Unit != "By" {
+		t.Fatalf("cache gauge = %+v", m)
+	}
+}
+
+func TestIntegrationReportOutbox(t *testing.T) {
+	h := newHarness(t)
+	t.Setenv("COMPUTERNAME", "PC01")
+	t.Setenv("USERNAME", "jdoe")
+	blocker := filepath.Join(t.TempDir(), "offline")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	share := filepath.Join(blocker, "reports")
+	h.d.cfg.Reports.Enabled = true
+	h.d.cfg.Reports.Dir = share
+	spread := reportSpread(h.d.cfg.Reports.Jitter)
+	if spread <= 0 || spread >= h.d.cfg.Reports.Jitter.D() {
+		t.Fatalf("spread = %s", spread)
+	}
+	count := func(dir string) int {
+		t.Helper()
+		entries, _ := os.ReadDir(dir)
+		return len(entries)
+	}
+
+	// The report waits in the outbox for this machine's slot.
+	h.d.checkSize()
+	if n := count(h.d.reportOutbox()); n != 2 {
+		t.Fatalf("outbox holds %d files, want 2", n)
+	}
+	h.clock.Advance(spread)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "WARN", "Cannot deliver health reports to "+share)
+	h.assertLog(h.d.watchLog, "WARN", "Keeping 2 files in "+h.d.reportOutbox()+"; retrying with backoff")
+
+	// Once the share is back, the next retry delivers the outbox.
+	os.Remove(blocker)
+	h.clock.Advance(reportRetryMin)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "INFO", "Health reports delivered to "+share+" again (2 files from the outbox).")
+	if n := count(h.d.reportOutbox()); n != 0 {
+		t.Fatalf("outbox holds %d files after delivery", n)
+	}
+	if _, err := os.Stat(filepath.Join(share, reportBase(h.clock.Now().Format("2006-01-02"))+".json")); err != nil {
+		t.Fatalf("report not delivered: %v", err)
+	}
+
+	// The hourly rewrite keeps the same slot.
+	h.clock.Advance(reportWriteEvery)
+	h.d.checkSize()
+	if n := count(h.d.reportOutbox()); n != 2 {
+		t.Fatalf("outbox holds %d files before the slot, want 2", n)
+	}
+	h.clock.Advance(spread)
+	h.d.checkSize()
+	if n := count(h.d.reportOutbox()); n != 0 {
+		t.Fatalf("outbox holds %d files after the slot", n)
+	}
+}
+
+// testCert issues a certificate for 127.0.0.1 signed by parent (self-signed
+// when parent is nil) and writes it and its key as PEM files into dir.
+func testCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, client bool) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if client {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage, tmpl.ExtKeyUsage = true, true, x509.KeyUsageCertSign, nil
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0644)
+	os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600)
+	cert, _ := x509.ParseCertificate(der)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pair
+}
+
+func TestIntegrationControlAccess(t *testing.T) {
+	h := newHarness(t)
+	audit := filepath.Join(h.d.logDir, auditLogName)
+	h.startControl()
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(h.d.controlAddr()); err != nil || info.Mode().Perm() != 0600 {
+			t.Fatalf("socket mode = %v, %v", info.Mode(), err)
+		}
+	}
+	peer := controlPeerOf(nil).User
+	call := func(req controlRequest) (controlEvent, error) {
+		t.Helper()
+		c, err := openControl(h.d.controlAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		return c.call(req)
+	}
+
+	// Changes and refusals are recorded; reads only with "all".
+	if _, err := call(controlRequest{Cmd: requestRepair}); err != nil {
+		t.Fatal(err)
+	}
+	h.waitRepairs(1)
+	h.assertLog(audit, "AUDIT", "control pipe "+peer)
+	h.assertLog(audit, "AUDIT", " repair: Repair started.")
+	call(controlRequest{Cmd: requestStatus})
+	if _, err := call(controlRequest{Cmd: "format-disk"}); err == nil {
+		t.Fatal("unknown command accepted")
+	}
+	h.assertLog(audit, "DENIED", ` format-disk: unknown command "format-disk"`)
+	if raw, _ := os.ReadFile(audit); strings.Contains(string(raw), " status: ") {
+		t.Fatalf("status audited under %q:\n%s", auditChanges, raw)
+	}
+	h.d.cfg.Control.Audit = auditAll
+	call(controlRequest{Cmd: requestStatus})
+	h.assertLog(audit, "AUDIT", " status: ok")
+
+	// The dashboard with mutual TLS.
+	dir := t.TempDir()
+	ca, caKey, _ := testCert(t, dir, "ca", nil, nil, false)
+	testCert(t, dir, "server", ca, caKey, false)
+	_, _, client := testCert(t, dir, "helpdesk", ca, caKey, true)
+	h.d.cfg.UI = uiOptions{Enabled: true, TLSCert: filepath.Join(dir, "server.pem"), TLSKey: filepath.Join(dir, "server.key"), ClientCA: filepath.Join(dir, "ca.pem")}
+	h.d.serveDashboard()
+	if h.d.dashboard == nil {
+		t.Fatal("dashboard not started")
+	}
+	ev, err := call(controlRequest{Cmd: requestDashboard})
+	if err != nil || !strings.HasPrefix(ev.URL, "https://"+h.d.dashboard.addr+"/#token=") {
+		t.Fatalf("url = %q, %v", ev.URL, err)
+	}
+	h.assertLog(audit, "AUDIT", " dashboard: sign-in link sent")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(certs []tls.Certificate, token string) (*http.Response, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		req, _ := http.NewRequest("GET", "https://"+h.d.dashboard.addr+"/api/status", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := c.Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		return res, err
+	}
+	if _, err := get(nil, h.d.dashboard.token); err == nil {
+		t.Fatal("request without a client certificate accepted")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if raw, _ := os.ReadFile(audit); strings.Contains(string(raw), "TLS handshake: ") || time.Now().After(deadline) {
+			break
+		}
+	}
+	h.assertLog(audit, "DENIED", "dashboard unknown user (127.0.0.1:")
+	if res, err := get([]tls.Certificate{client}, ""); err != nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without token = %v, %v", res, err)
+	}
+	h.assertLog(audit, "DENIED", "dashboard CN=helpdesk (127.0.0.1:")
+	if res, err := get([]tls.Certificate{client}, h.d.dashboard.token); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("with certificate and token = %v, %v", res, err)
+	}
+	h.assertLog(audit, "AUDIT", "GET /api/status: ok")
+}
+
+func TestIntegrationCommandTrail(t *testing.T) {
+	h := newHarness(t)
+	h.d.history = h.d.loadHistory()
+	h.d.cfg.Control.Audit = auditOff // the trail does not depend on it
+	h.startControl()
+	peer := controlPeerOf(nil).String()
+	call := func(req controlRequest) {
+		t.Helper()
+		c, err := openControl(h.d.controlAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if _, err := c.call(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call(controlRequest{Cmd: requestRepair})
+	h.waitRepairs(1)
+	call(controlRequest{Cmd: requestPause, For: duration(time.Hour), Reason: "VM import"})
+	call(controlRequest{Cmd: requestStatus})
+	h.assertLog(h.d.watchLog, "COMMAND", "repair via control pipe by "+peer+": Repair started.")
+	h.assertLog(h.d.watchLog, "COMMAND", "pause via control pipe by "+peer+": paused until ")
+
+	var commands []historyEvent
+	for _, e := range h.d.history.snapshot(h.clock.Now()).Events {
+		if e.Kind == historyCommand {
+			commands = append(commands, e)
+		}
+	}
+	if len(commands) != 2 || commands[0].By != peer || !strings.HasPrefix(commands[1].Message, "pause via control pipe") {
+		t.Fatalf("command events = %+v", commands)
+	}
+	if _, err := os.Stat(filepath.Join(h.d.logDir, auditLogName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("audit log written with control.audit %q: %v", auditOff, err)
+	}
+}
+
+func TestIntegrationMessageCatalog(t *testing.T) {
+	h := newHarness(t)
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.d.checkSize()
+	h.bloat(sizeLimitMB + 8)
+	h.d.checkSize()
+	h.noRepairs(1)
+	h.assertLog(h.d.watchLog, "TRIGGER", "W010 Cache is ")
+	h.assertLog(h.d.watchLog, "INFO", "R201 Repair script launched. Reason was: size ")
+	h.assertLog(h.d.watchLog, "WARN", "W001 Cooldown active (30m remaining). Skipping repair.")
+
+	// The live log carries the code in its own field.
+	var skip *logEntry
+	h.d.logs.mu.Lock()
+	for i, e := range h.d.logs.recent {
+		if e.Code == msgCooldownSkip {
+			skip = &h.d.logs.recent[i]
+		}
+	}
+	h.d.logs.mu.Unlock()
+	if skip == nil || !strings.HasPrefix(skip.Message, "Cooldown active") || !strings.Contains(skip.String(), "subsystem=repair W001 Cooldown") {
+		t.Fatalf("live log entry = %+v", skip)
+	}
+
+	// Every event-backed message has an event type, and every code its area.
+	for _, e := range catalogEntries() {
+		if _, ok := evtTypes[e.Event]; e.Event != 0 && !ok {
+			t.Errorf("%s: event %d has no type", e.Code, e.Event)
+		}
+		if !strings.Contains("WHRSC", e.Code[:1]) || len(e.Code) != 4 || e.Title == "" || e.Text == "" {
+			t.Errorf("malformed catalog entry %+v", e)
+		}
+	}
+
+	out, code := captureStdout(t, func() int { return runCommand(h.d, []string{"explain", "w001"}) })
+	if code != 0 || !strings.Contains(out, "W001") || !strings.Contains(out, "Cooldown active (%s remaining)") {
+		t.Fatalf("explain w001: exit %d\n%s", code, out)
+	}
+	if _, code := captureStdout(t, func() int { return runCommand(h.d, []string{"explain", "X999"}) }); code != 1 {
+		t.Fatalf("explain of an unknown code exited %d, want 1", code)
+	}
+}
+
+func TestIntegrationShellMods(t *testing.T) {
+	h := newHarness(t)
+	programFiles, localAppData := filepath.Join(h.root, "Program Files"), filepath.Join(h.root, "LocalAppData")
+	t.Setenv("ProgramFiles", programFiles)
+	t.Setenv("ProgramW6432", programFiles)
+	t.Setenv("LOCALAPPDATA", localAppData)
+	modCache := filepath.Join(localAppData, "OpenShell", "DataCache.db")
+	for _, dir := range []string{filepath.Join(programFiles, "Open-Shell"), filepath.Dir(modCache)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.d.cfg.ShellMods.Watch = true
+
+	h.d.checkShellMods()
+	h.assertLog(h.d.healthLog, "INFO", "Shell replacement detected: Open-Shell in "+filepath.Join(programFiles, "Open-Shell"))
+	h.assertLog(h.d.healthLog, "INFO", "Its icon cache is polled and cleared by repairs. Repairs restart its host with Explorer.")
+	if mods := h.d.statusSnapshot().ShellMods; len(mods) != 1 || mods[0].Caches[0] != modCache {
+		t.Fatalf("status shellMods = %+v", mods)
+	}
+
+	// The mod's cache alone pushes the total over the limit, and the repair
+	// clears it and restarts the host.
+	h.d.checkSize()
+	if err := os.WriteFile(modCache, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(modCache, int64(sizeLimitMB+8)*int64(mib)); err != nil {
+		t.Fatal(err)
+	}
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.assertLog(h.d.watchLog, "INFO", "-ModCaches "+modCache+" -ModHosts StartMenu.exe")
+	if _, err := os.Stat(modCache); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("mod cache not cleared by the repair: %v", err)
+	}
+
+	// start-shell only starts the known hosts.
+	if _, code := captureStdout(t, func() int { return runCommand(h.d, []string{"start-shell", "-host=" + h.d.repairScript}) }); code != 2 {
+		t.Fatalf("start-shell -host with an unknown image exited %d, want 2", code)
+	}
+
+	os.RemoveAll(filepath.Join(programFiles, "Open-Shell"))
+	h.d.checkShellMods()
+	h.assertLog(h.d.healthLog, "INFO", "Shell replacement removed: Open-Shell.")
+}
+
+func TestIntegrationArchitecture(t *testing.T) {
+	h := newHarness(t)
+	a := hostArch()
+	if a.Binary != runtime.GOARCH || a.Machine == "" || a.Emulated != (a.Machine != a.Binary) {
+		t.Fatalf("architecture = %+v", a)
+	}
+	if r := h.d.statusSnapshot(); r.Architecture != a {
+		t.Fatalf("status architecture = %+v, want %+v", r.Architecture, a)
+	}
+	h.d.writeStatus()
+	out, code := captureStdout(t, func() int { return runCommand(h.d, []string{"status"}) })
+	if code != 0 || !strings.Contains(out, "Architecture:  "+a.String()+"\n") {
+		t.Fatalf("status exit %d:\n%s", code, out)
+	}
+
+	// The heartbeat carries it for fleet inventory.
+	h.d.cfg.Heartbeat.File = "heartbeat.json"
+	h.d.sendHeartbeat("Watchdog alive.")
+	raw, err := os.ReadFile(filepath.Join(h.d.dataDir, "heartbeat.json"))
+	var ping heartbeatPing
+	if err != nil || json.Unmarshal(raw, &ping) != nil || ping.Arch != a {
+		t.Fatalf("heartbeat file = %s, %v", raw, err)
+	}
+}
+
+func TestIntegrationCacheFilePatterns(t *testing.T) {
+	h := newHarness(t)
+	// A future tier the default glob does not know, named by expression.
+	tier := filepath.Join(h.cache, "shellicons_48.db")
+	if err := os.WriteFile(tier, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(tier, int64(sizeLimitMB+8)*int64(mib)); err != nil {
+		t.Fatal(err)
+	}
+	h.d.checkSize()
+	h.noRepairs(0)
+
+	h.d.cfg.CacheFiles.Icon = []string{"iconcache_*.db", `re:shellicons_\d+\.db`}
+	if names := cacheFileNames(h.cache, h.d.cfg.CacheFiles.Icon); !slices.Contains(names, "shellicons_48.db") || !slices.Contains(names, "iconcache_idx.db") {
+		t.Fatalf("icon cache files = %v", names)
+	}
+	h.d.checkSize()
+	h.waitRepairs(1)
+	h.assertLog(h.d.watchLog, "INFO", `-IconPatterns iconcache_*.db|re:shellicons_\d+\.db`)
+	if _, err := os.Stat(tier); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("new tier not cleared by the repair: %v", err)
+	}
+}
+
+func TestIntegrationCacheDirGone(t *testing.T) {
+	h := newHarness(t)
+	h.d.history = h.d.loadHistory()
+	h.d.checkSize()
+	if err := os.RemoveAll(h.cache); err != nil {
+		t.Fatal(err)
+	}
+
+	// One line for the whole outage, not one per poll.
+	h.d.checkSize()
+	h.clock.Advance(time.Minute)
+	h.d.checkSize()
+	raw, _ := os.ReadFile(h.d.watchLog)
+	if n := strings.Count(string(raw), "[WARN] W030 Cache directory "+h.cache+" is gone"); n != 1 {
+		t.Fatalf("%d W030 lines:\n%s", n, raw)
+	}
+	if r := h.d.statusSnapshot(); r.CacheGone == nil {
+		t.Fatal("status without cacheMissingSince")
+	}
+
+	// Health checks report nothing instead of a broken index, and repairs
+	// are skipped.
+	if res := h.d.evaluateHealth(true); !res.CacheMissing || res.RepairNeeded || len(res.Heuristics) != 0 {
+		t.Fatalf("health result while missing = %+v", res)
+	}
+	h.d.triggerRepair("event", priorityNormal)
+	h.noRepairs(0)
+	h.assertLog(h.d.watchLog, "WARN", "W007 Cache directory missing since ")
+
+	if err := writeHealthyCache(h.cache); err != nil {
+		t.Fatal(err)
+	}
+	h.clock.Advance(time.Minute)
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "INFO", "W031 Cache directory "+h.cache+" is back after 2m. Watching resumed.")
+	if r := h.d.statusSnapshot(); r.CacheGone != nil {
+		t.Fatalf("status still missing since %s", r.CacheGone)
+	}
+	if res := h.d.evaluateHealth(false); res.CacheMissing || !res.Healthy {
+		t.Fatalf("health result after the return = %+v", res)
+	}
+	var kinds []string
+	for _, e := range h.d.history.snapshot(h.clock.Now()).Events {
+		kinds = append(kinds, e.Kind)
+	}
+	if strings.Join(kinds, " ") != "cachedir cachedir" {
+		t.Fatalf("history events = %v", kinds)
+	}
+}
+
+func TestIntegrationStrayFiles(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.StrayFiles.MinSize = mib
+	write := func(name string, size int) {
+		if err := os.WriteFile(filepath.Join(h.cache, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("iconcache_256.db.bak", 3*int(mib))
+	write("dump.tmp", 2*int(mib))
+	write("small.tmp", 1024)               // below minSize
+	write("iconcache_1024.db", 4*int(mib)) // a cache file
+
+	res := h.d.evaluateHealth(false)
+	if res.Strays == nil || len(res.Strays.Files) != 2 || res.Strays.Files[0].Name != "iconcache_256.db.bak" || res.Strays.Bytes != 5*int64(mib) {
+		t.Fatalf("strays = %+v", res.Strays)
+	}
+	if !res.Healthy {
+		t.Fatalf("stray files affected the score: %+v", res)
+	}
+	h.assertLog(h.d.healthLog, "WARN", "Stray files: 2 file(s), 5.00 MB in the cache directory that are not cache files.")
+	if r := h.d.statusSnapshot(); r.Strays == nil || len(r.Strays.Files) != 2 {
+		t.Fatalf("status strays = %+v", r.Strays)
+	}
+
+	out, code := captureStdout(t, func() int { return runCommand(h.d, []string{"strays", "-json"}) })
+	if code != 0 || !strings.Contains(out, `"schema": "strays"`) || !strings.Contains(out, `"name": "dump.tmp"`) {
+		t.Fatalf("strays -json (exit %d):\n%s", code, out)
+	}
+	if _, code := captureStdout(t, func() int { return runCommand(h.d, []string{"strays", "-clean"}) }); code != 0 {
+		t.Fatalf("strays -clean exit %d", code)
+	}
+	for _, name := range []string{"iconcache_256.db.bak", "dump.tmp"} {
+		if _, err := os.Stat(filepath.Join(h.cache, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s not deleted: %v", name, err)
+		}
+	}
+	for _, name := range []string{"small.tmp", "iconcache_1024.db"} {
+		if _, err := os.Stat(filepath.Join(h.cache, name)); err != nil {
+			t.Fatalf("%s deleted: %v", name, err)
+		}
+	}
+	h.assertLog(h.d.healthLog, "INFO", "Stray file deleted (strays -clean): dump.tmp (2.00 MB)")
+}
+
+func TestIntegrationSoftRepair(t *testing.T) {
+	h := newHarness(t)
+	h.d.history = h.d.loadHistory()
+	windows := filepath.Join(t.TempDir(), "Windows")
+	system32 := filepath.Join(windows, "System32")
+	if err := os.MkdirAll(system32, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SystemRoot", windows)
+	runs := filepath.Join(t.TempDir(), "ie4uinit.log")
+	t.Setenv(ie4uinitLogEnv, runs)
+
+	// Without ie4uinit.exe the health check goes straight to the full repair.
+	os.Remove(filepath.Join(h.cache, "iconcache_idx.db"))
+	h.d.evaluateHealth(true)
+	h.waitRepairs(1)
+	h.d.evaluateHealth(false) // healthy again: the episode ends
+	h.clock.Advance(2 * h.d.cfg.Thresholds.Cooldown.D())
+
+	fake := copySelf(t, filepath.Join(system32, fakeIe4uinitName))
+	if err := os.Rename(fake, filepath.Join(system32, "ie4uinit.exe")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The soft repair comes first, and fixes it.
+	os.Remove(filepath.Join(h.cache, "iconcache_idx.db"))
+	h.d.evaluateHealth(true)
+	h.noRepairs(1)
+	if raw, _ := os.ReadFile(runs); string(raw) != "-show\n" {
+		t.Fatalf("ie4uinit.exe runs = %q", raw)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "R209 Soft repair: ie4uinit.exe -show finished in ")
+	if err := writeHealthyCache(h.cache); err != nil {
+		t.Fatal(err)
+	}
+	h.d.evaluateHealth(true)
+	h.assertLog(h.d.watchLog, "INFO", "R211 Soft repair restored health")
+
+	// It does not fix it: the next check runs the full repair.
+	os.Remove(filepath.Join(h.cache, "iconcache_idx.db"))
+	h.d.evaluateHealth(true)
+	h.noRepairs(1)
+	h.d.evaluateHealth(true)
+	h.assertLog(h.d.watchLog, "WARN", "R212 Soft repair at ")
+	h.waitRepairs(2)
+
+	// A failing ie4uinit.exe hands over to the full repair at once.
+	h.d.evaluateHealth(true)
+	h.clock.Advance(2 * h.d.cfg.Thresholds.Cooldown.D())
+	t.Setenv(ie4uinitLogEnv, "")
+	os.Remove(filepath.Join(h.cache, "iconcache_idx.db"))
+	h.d.evaluateHealth(true)
+	h.assertLog(h.d.watchLog, "WARN", "R210 Soft repair failed: ie4uinit.exe -show: exit status 1. Running the full repair.")
+	h.waitRepairs(3)
+
+	var soft int
+	for _, e := range h.d.history.snapshot(h.clock.Now()).Events {
+		if e.Kind == historySoft {
+			soft++
+		}
+	}
+	if soft != 2 {
+		t.Fatalf("%d soft repair history events", soft)
+	}
+}
+
+func TestIntegrationShellRefresh(t *testing.T) {
+	h := newHarness(t)
+	calls := 0
+	refresh := func(r shellRefresh) func() shellRefresh {
+		return func() shellRefresh { calls++; return r }
+	}
+	h.d.refreshShell(refresh(shellRefresh{Desktop: true, Windows: 2}))
+	h.assertLog(h.d.watchLog, "INFO", "Shell refreshed: desktop and 2 Explorer window(s).")
+	h.d.refreshShell(refresh(shellRefresh{}))
+	h.assertLog(h.d.watchLog, "INFO", "Shell refresh: no desktop or Explorer window found.")
+
+	h.d.cfg.SoftRepair.RefreshWindows = false
+	h.d.refreshShell(refresh(shellRefresh{Desktop: true}))
+	if calls != 2 {
+		t.Fatalf("%d refreshes, want 2", calls)
+	}
+}
+
+// writeCacheTier writes a tier database with entries of 64 bytes each.
+func writeCacheTier(t *testing.T, path string, entries int) {
+	t.Helper()
+	const header, entry = 32, 64
+	b := make([]byte, header+entries*entry+256) // zeroed free space at the end
+	copy(b, tierSignature)
+	binary.LittleEndian.PutUint32(b[4:], 0x20) // Windows 10
+	binary.LittleEndian.PutUint32(b[16:], header)
+	binary.LittleEndian.PutUint32(b[20:], uint32(header+entries*entry))
+	binary.LittleEndian.PutUint32(b[24:], uint32(entries))
+	for i := 0; i < entries; i++ {
+		at := header + i*entry
+		copy(b[at:], tierSignature)
+		binary.LittleEndian.PutUint32(b[at+4:], entry)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntegrationTierRepair(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.TierRepair.Enabled = true
+	tiers := []string{"iconcache_16.db", "iconcache_32.db", "iconcache_48.db", "iconcache_256.db", "iconcache_wide.db"}
+	for _, name := range tiers {
+		writeCacheTier(t, filepath.Join(h.cache, name), 8)
+	}
+	if res := h.d.evaluateHealth(true); res.Tiers == nil || res.Tiers.Parsed != len(tiers) || len(res.Tiers.Corrupt) != 0 {
+		t.Fatalf("tiers = %+v", res.Tiers)
+	}
+
+	// A broken entry in one tier; the header is fine.
+	raw, _ := os.ReadFile(filepath.Join(h.cache, "iconcache_48.db"))
+	copy(raw[32+3*64:], "XXXX")
+	os.WriteFile(filepath.Join(h.cache, "iconcache_48.db"), raw, 0644)
+	if problem, err := parseCacheTier(filepath.Join(h.cache, "iconcache_48.db")); err != nil || problem != "entry at 0xE0 has no CMMM signature" {
+		t.Fatalf("problem = %q, %v", problem, err)
+	}
+
+	// The first sighting waits for confirmation; the second rebuilds the
+	// tier and the index only.
+	res := h.d.evaluateHealth(true)
+	if len(res.Tiers.Corrupt) != 1 || len(res.Tiers.Confirmed) != 0 {
+		t.Fatalf("tiers = %+v", res.Tiers)
+	}
+	h.noRepairs(0)
+	h.assertLog(h.d.healthLog, "WARN", "Cache tier iconcache_48.db corrupt: entry at 0xE0 has no CMMM signature (first seen;")
+	h.d.evaluateHealth(true)
+	if got := h.waitRepairs(1); got[0] != "corrupt cache tier iconcache_48.db" {
+		t.Fatalf("reason = %q", got[0])
+	}
+	for _, name := range []string{"iconcache_16.db", "iconcache_256.db"} {
+		if problem, _ := parseCacheTier(filepath.Join(h.cache, name)); problem != "" {
+			t.Fatalf("%s touched: %s", name, problem)
+		}
+		if raw, _ := os.ReadFile(filepath.Join(h.cache, name)); string(raw[:4]) != tierSignature {
+			t.Fatalf("%s rebuilt", name)
+		}
+	}
+	if raw, _ := os.ReadFile(filepath.Join(h.cache, "iconcache_48.db")); string(raw[:4]) == tierSignature {
+		t.Fatal("iconcache_48.db not rebuilt")
+	}
+
+	// Every tier corrupt: the full repair.
+	h.clock.Advance(2 * h.d.cfg.Thresholds.Cooldown.D())
+	for _, name := range tiers {
+		os.WriteFile(filepath.Join(h.cache, name), []byte("garbage, not a tier database"), 0644)
+	}
+	h.d.evaluateHealth(true)
+	h.d.evaluateHealth(true)
+	h.waitRepairs(2)
+	h.assertLog(h.d.healthLog, "REPAIR", "EVERY CACHE TIER CORRUPT")
+}
+
+func TestIntegrationWarmUp(t *testing.T) {
+	h := newHarness(t)
+	profile := t.TempDir()
+	t.Setenv("APPDATA", filepath.Join(profile, "AppData", "Roaming"))
+	t.Setenv("USERPROFILE", profile)
+	t.Setenv("PUBLIC", filepath.Join(t.TempDir(), "Public"))
+	t.Setenv("ProgramData", t.TempDir())
+	write := func(path string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	startMenu := filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Windows", "Start Menu", "Programs")
+	write(filepath.Join(startMenu, "Tools", "Editor.lnk"))
+	write(filepath.Join(startMenu, "readme.txt")) // not a shortcut
+	write(filepath.Join(profile, "Desktop", "Report.docx"))
+	write(filepath.Join(profile, "Desktop", "desktop.ini"))
+	write(filepath.Join(profile, "Desktop", "Old", "notes.txt")) // not shown on the desktop
+	write(filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Internet Explorer", "Quick Launch", "User Pinned", "TaskBar", "File Explorer.lnk"))
+
+	// Taskbar first, then the desktop, then the Start menu.
+	var asked []string
+	h.d.warmUp(func(paths []string) int {
+		for _, p := range paths {
+			asked = append(asked, filepath.Base(p))
+		}
+		return len(paths) - 1
+	})
+	if got := strings.Join(asked, ","); got != "File Explorer.lnk,Report.docx,Editor.lnk" {
+		t.Fatalf("asked for %s", got)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "Cache warm-up: 0 of 3 icons loaded (taskbar 0/1, desktop 0/1, Start menu 0/1) in ")
+
+	h.d.cfg.WarmUp.MaxItems = 2
+	if stages := warmUpStages(h.d.cfg.WarmUp.MaxItems); len(stages) != 2 {
+		t.Fatalf("stages with maxItems 2 = %+v", stages)
+	}
+
+	// A completed repair starts it.
+	h.d.cfg.WarmUp = warmUpOptions{Enabled: true, MaxItems: 10}
+	h.d.triggerRepair("manual", priorityNormal)
+	h.waitRepairs(1)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if raw, _ := os.ReadFile(h.d.watchLog); strings.Count(string(raw), "Cache warm-up: ") == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no warm-up after the repair")
+		}
+	}
+}
+
+func TestIntegrationExplorerStop(t *testing.T) {
+	h := newHarness(t)
+	if p := h.d.explorerStopParams(); p != nil {
+		t.Fatalf("default explorerStop passed %q", p)
+	}
+	for _, bad := range []string{"##explorer-stopped force", "##explorer-stopped force soon", "##progress explorer-stopping"} {
+		if _, _, ok := parseExplorerStop(bad); ok {
+			t.Fatalf("parsed %q", bad)
+		}
+	}
+
+	// A graceful stop that falls through to force is logged and counted.
+	t.Setenv(stopRungEnv, rungForce)
+	h.d.cfg.ExplorerStop = explorerStopOptions{Method: stopGraceful,
+		RestartManagerTimeout: duration(3 * time.Second), GracefulTimeout: duration(4 * time.Second), ForceTimeout: duration(2 * time.Second)}
+	h.d.triggerRepair("manual", priorityNormal)
+	h.waitRepairs(1)
+	h.assertLog(h.d.watchLog, "INFO", "-StopMethod graceful -StopTimeouts 3|4|2")
+	for deadline := time.Now().Add(10 * time.Second); h.d.metrics.snapshot()[mStopFallbacks].Value == 0; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("forced stop not counted")
+		}
+	}
+	h.assertLog(h.d.watchLog, "WARN", "Explorer stopped by force after 0.4s: Restart Manager and the shell's own exit did not stop it. Reason: manual")
+
+	h.d.explorerStopped(rungRestartManager, 1.25, "manual")
+	h.assertLog(h.d.watchLog, "INFO", "Explorer stopped (restart-manager) in 1.2s. Reason: manual")
+	h.d.explorerStopped(rungFailed, 17, "manual")
+	h.assertLog(h.d.watchLog, "WARN", "Explorer still running 17.0s into the repair, after every stop method.")
+	if n := h.d.metrics.snapshot()[mStopFallbacks].Value; n != 1 {
+		t.Fatalf("fallbacks = %v, want 1", n)
+	}
+}
+
+func TestIntegrationCommandHelp(t *testing.T) {
+	h := newHarness(t)
+	out, code := captureStdout(t, func() int { return runCommand(h.d, []string{"--help"}) })
+	if code != 0 || !strings.Contains(out, "  strays          List large non-cache files") || !strings.Contains(out, "help settings") {
+		t.Fatalf("--help exited %d:\n%s", code, out)
+	}
+
+	// Flags come from the command's own flag set; the table says which
+	// commands have one.
+	for _, list := range [][]command{commands, configCommands} {
+		for _, c := range list {
+			if fs := commandFlags(h.d, c); (fs != nil) != c.flags {
+				t.Errorf("%s: flags %v, but the flag set is %v", c.name, c.flags, fs)
+			}
+		}
+	}
+	out, code = captureStdout(t, func() int { return runCommand(h.d, []string{"repair", "-h"}) })
+	for _, want := range []string{"Usage: icon-cache-watchdog repair [-reason TEXT]", "  -deep-clean\n", "Examples:\n  icon-cache-watchdog repair\n"} {
+		if code != 0 || !strings.Contains(out, want) {
+			t.Fatalf("repair -h exited %d, no %q in:\n%s", code, want, out)
+		}
+	}
+	h.noRepairs(0)
+	out, _ = captureStdout(t, func() int { return runCommand(h.d, []string{"help", "config", "init"}) })
+	if !strings.Contains(out, "  -preset string") {
+		t.Fatalf("help config init:\n%s", out)
+	}
+	// A command without flags is not run for its help.
+	out, _ = captureStdout(t, func() int { return runCommand(h.d, []string{"resume", "--help"}) })
+	if strings.Contains(out, "Watchdog resumed.") || !strings.Contains(out, "End a pause now") {
+		t.Fatalf("resume --help:\n%s", out)
+	}
+
+	for _, tc := range []struct {
+		before []string
+		word   string
+		want   string
+	}{
+		{nil, "st", "start-shell status strays"},
+		{[]string{"--size-limit-mb=64"}, "heal", "healthcheck"},
+		{nil, "--repair-script", "--repair-script-sha256= --repair-script="},
+		{[]string{"config"}, "s", "show-effective sign"},
+		{[]string{"rollback"}, "-", "-backup -json -list"},
+		{[]string{"signal"}, "", "healthcheck repair"},
+		{[]string{"help", "config"}, "p", "presets"},
+		{[]string{"status", "-json"}, "x", ""},
+	} {
+		if got := strings.Join(completions(h.d, tc.before, tc.word), " "); got != tc.want {
+			t.Errorf("complete %q %q = %q, want %q", tc.before, tc.word, got, tc.want)
+		}
+	}
+	out, _ = captureStdout(t, func() int { return runCommand(h.d, []string{"__complete", ":config", ":"}) })
+	if out != "init\nkeygen\npresets\nshow-effective\nsign\nvalidate\n" {
+		t.Fatalf("__complete config:\n%s", out)
+	}
+	out, code = captureStdout(t, func() int { return runCommand(h.d, []string{"completion", "powershell"}) })
+	if code != 0 || !strings.Contains(out, "Register-ArgumentCompleter -Native") || !strings.Contains(out, "__complete @words") {
+		t.Fatalf("completion powershell exited %d:\n%s", code, out)
+	}
+}
+
+func TestIntegrationUpdateBaseline(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Ledger.Enabled = true
+	h.d.cfg.ShellStability.Enabled = true
+
+	// The first build recorded is the baseline, not an update.
+	h.d.noteOSBuild("19045.4170")
+	if h.d.updateSettling() || h.d.metrics.snapshot()[mRebaselines].Value != 0 {
+		t.Fatal("first build treated as an update")
+	}
+	if res := h.d.evaluateHealth(false); !res.Healthy {
+		t.Fatalf("health = %+v", res)
+	}
+	if _, err := os.Stat(h.d.ledgerPath()); err != nil {
+		t.Fatalf("ledger not recorded: %v", err)
+	}
+	wer := filepath.Join(filepath.Dir(h.d.cacheDir), "WER", "ReportArchive", "AppCrash_explorer.exe_5c1f_1")
+	if err := os.MkdirAll(wer, 0755); err != nil {
+		t.Fatal(err)
+	}
+	at := h.clock.Now().Add(-time.Hour)
+	os.Chtimes(wer, at, at)
+
+	// A cumulative update resets the ledger and the H8 window.
+	h.d.noteOSBuild("19045.4291")
+	h.assertLog(h.d.healthLog, "INFO", "Windows updated from build 19045.4170 to 19045.4291: heuristic baselines reset")
+	if _, err := os.Stat(h.d.ledgerPath()); !os.IsNotExist(err) {
+		t.Fatalf("ledger kept after the update: %v", err)
+	}
+	if !h.d.updateSettling() || h.d.metrics.snapshot()[mRebaselines].Value != 1 {
+		t.Fatal("update not re-baselined")
+	}
+	if _, st := h.d.checkH8Stability(); st.Crashes != 0 {
+		t.Fatalf("crash before the update counted: %+v", st)
+	}
+	h.d.loadState()
+	if h.d.osBuild != "19045.4291" || h.d.osUpdated.IsZero() {
+		t.Fatalf("state.json: build %q, updated %v", h.d.osBuild, h.d.osUpdated)
+	}
+
+	h.clock.Advance(h.d.cfg.UpdateBaseline.Settle.D())
+	if h.d.updateSettling() {
+		t.Fatal("still settling after updateBaseline.settle")
+	}
+}
+
+func TestIntegrationSchedules(t *testing.T) {
+	// Health checks fire at the slot, not an interval after the start.
+	h := newHarness(t)
+	slot := h.clock.Now().Truncate(time.Minute).Add(2 * time.Minute)
+	h.d.cfg.Health.Schedule = "daily " + slot.Format("15:04")
+	ticker, how := h.d.healthTicker()
+	defer ticker.Stop()
+	if how != `schedule "daily `+slot.Format("15:04")+`"` {
+		t.Fatalf("healthTicker = %q", how)
+	}
+	h.clock.waitTickers(t, 1)
+	for i := 1; i <= 3; i++ {
+		h.clock.Advance(time.Minute)
+		select {
+		case <-ticker.C():
+			if i != 2 {
+				t.Fatalf("fired after %d minute(s), slot in 2", i)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if i == 2 {
+				t.Fatal("slot did not fire")
+			}
+		}
+	}
+
+	// A deep clean slot missed by less than deepCleanCatchUp still runs,
+	// once.
+	h.d.cfg.DeepClean.Enabled = true
+	h.d.cfg.DeepClean.Schedule = "daily " + h.clock.Now().Add(-30*time.Minute).Format("15:04")
+	h.d.checkDeepClean()
+	reasons := h.waitRepairs(1)
+	if !strings.Contains(reasons[0], `scheduled deep clean (schedule "daily `) {
+		t.Fatalf("repair reason = %q", reasons[0])
+	}
+	h.d.checkDeepClean()
+	h.noRepairs(1)
+	h.clock.Advance(24 * time.Hour)
+	h.d.checkDeepClean()
+	h.waitRepairs(2)
+}
+
+func TestIntegrationFleetStatus(t *testing.T) {
+	h := newHarness(t)
+	dir := t.TempDir()
+	ca, caKey, _ := testCert(t, dir, "ca", nil, nil, false)
+	testCert(t, dir, "server", ca, caKey, false)
+	tokenFile := filepath.Join(dir, "fleet.token")
+	os.WriteFile(tokenFile, []byte("0123456789abcdef0123\n"), 0600)
+	h.d.cfg.Fleet = fleetOptions{Enabled: true, TokenFile: tokenFile,
+		TLSCert: filepath.Join(dir, "server.pem"), TLSKey: filepath.Join(dir, "server.key"), CA: filepath.Join(dir, "ca.pem")}
+	if errs := h.d.cfg.checkRanges(); len(errs) != 1 || errs[0].Path != "fleet.enabled" {
+		t.Fatalf("per-user checkRanges = %v", errs)
+	}
+	// A per-user daemon does not serve the endpoint.
+	h.d.serveFleet()
+	if h.d.fleetAddr != "" {
+		t.Fatalf("per-user daemon serves the fleet endpoint on %s", h.d.fleetAddr)
+	}
+	h.assertLog(h.d.watchLog, "WARN", "served only by the machine-wide instance")
+	h.d.cfg.MultiSession.Enabled = true
+	if errs := h.d.cfg.checkRanges(); len(errs) != 0 {
+		t.Fatalf("checkRanges = %v", errs)
+	}
+	h.d.cfg.Control.Audit = auditAll // reads are audited with "all" only
+	h.d.checkHealth()
+	h.d.writeStatus()
+	done := make(chan struct{})
+	h.d.done = done
+	h.d.serveFleet()
+	if h.d.fleetAddr == "" {
+		t.Fatal("fleet endpoint not started")
+	}
+	_, port, _ := net.SplitHostPort(h.d.fleetAddr)
+	self := "127.0.0.1:" + port
+
+	// One table for every host; unreachable ones first, exit code 1.
+	out, code := captureStdout(t, func() int {
+		return runCommand(h.d, []string{"status", "-host", self + ", 127.0.0.1:1", "-timeout", "5s"})
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if code != 1 || len(lines) != 3 || !strings.HasPrefix(lines[1], "127.0.0.1:1") || !strings.Contains(lines[1], "UNREACHABLE") ||
+		!strings.HasPrefix(lines[2], self) || !strings.Contains(lines[2], " healthy ") || !strings.Contains(lines[2], "  100 ") {
+		t.Fatalf("status -host exited %d:\n%s", code, out)
+	}
+	h.assertLog(filepath.Join(h.d.logDir, auditLogName), "AUDIT", "fleet fleet token holder (127.0.0.1:")
+
+	out, code = captureStdout(t, func() int { return runCommand(h.d, []string{"status", "-host", self, "-json"}) })
+	var res struct {
+		Schema string      `json:"schema"`
+		Hosts  []fleetHost `json:"hosts"`
+	}
+	if err := json.Unmarshal([]byte(out), &res); err != nil || code != 0 || res.Schema != "fleet" || len(res.Hosts) != 1 ||
+		!res.Hosts[0].Running || res.Hosts[0].Status == nil || res.Hosts[0].Status.CacheDir != h.d.cacheDir {
+		t.Fatalf("status -host -json exited %d, %v:\n%s", code, err, out)
+	}
+
+	// Another token is refused.
+	other := filepath.Join(dir, "other.token")
+	os.WriteFile(other, []byte("fedcba9876543210fedcba"), 0600)
+	out, code = captureStdout(t, func() int { return runCommand(h.d, []string{"status", "-host", self, "-token-file", other}) })
+	if code != 1 || !strings.Contains(out, "401 Unauthorized") {
+		t.Fatalf("foreign token exited %d:\n%s", code, out)
+	}
+	h.assertLog(filepath.Join(h.d.logDir, auditLogName), "DENIED", "GET /api/status: unauthorized")
+
+	// The port is released when the daemon stops.
+	close(done)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		conn, err := net.Dial("tcp", self)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("fleet endpoint still listening after stop")
+		}
+	}
+
+	h.d.cfg.Fleet.TLSKey = ""
+	if errs := h.d.cfg.checkRanges(); len(errs) != 1 || errs[0].Path != "fleet.tlsKey" {
+		t.Fatalf("checkRanges = %v", errs)
+	}
+}
+
+func TestIntegrationCircuitBreaker(t *testing.T) {
+	h := newHarness(t)
+	h.d.cfg.Breaker.MaxTriggers = 5
+
+	// A flood of triggers trips the breaker, cooldown skips included.
+	for i := 0; i < 6; i++ {
+		h.d.triggerRepair("size", priorityNormal)
+	}
+	h.waitRepairs(1)
+	h.assertLog(h.d.watchLog, "ERROR", "Circuit breaker tripped: 6 repair triggers within 1h")
+	if h.d.metrics.snapshot()[mBreakerTrips].Value != 1 {
+		t.Fatal("breaker trip not counted")
+	}
+
+	// Alert-only from then on, with one line an hour for the skipped triggers.
+	h.clock.Advance(time.Hour + time.Minute)
+	h.d.triggerRepair("size", priorityNormal)
+	h.assertLog(h.d.watchLog, "WARN", "1 repair triggers skipped so far")
+	if out := h.d.requestRepair("dashboard"); out.Started || !strings.Contains(out.Result, "stopped by the circuit breaker") {
+		t.Fatalf("repair request with the breaker open = %+v", out)
+	}
+	h.noRepairs(1)
+	if st := h.d.statusSnapshot(); st.Breaker == nil || st.Breaker.Suppressed != 2 {
+		t.Fatalf("status breaker = %+v", st.Breaker)
+	}
+
+	// It survives a restart; only resume closes it. The count of skipped
+	// triggers is saved with the hourly line, not per trigger.
+	restarted := &daemon{stateFile: h.d.stateFile, logDir: h.d.logDir, watchLog: h.d.watchLog, clock: h.clock}
+	restarted.loadState()
+	if b := restarted.breaker; b == nil || !strings.Contains(b.Reason, "repair triggers") || b.Suppressed != 1 {
+		t.Fatalf("breaker after restart = %+v", b)
+	}
+	h.startControl()
+	if code := cmdResume(h.d, nil); code != 0 {
+		t.Fatalf("resume exited %d", code)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "Circuit breaker reset via control pipe after skipping 2 repair triggers")
+	h.d.triggerRepair("size", priorityNormal)
+	h.waitRepairs(2)
+
+	// Repairs that fail in a row trip it too.
+	h.d.cfg.PowerShell.Path = filepath.Join(h.root, "missing-pwsh")
+	h.clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		h.d.triggerRepair("health", priorityNormal)
+	}
+	h.assertLog(h.d.watchLog, "ERROR", "Circuit breaker tripped: 3 repairs failed in a row")
+}
+
+func TestIntegrationTune(t *testing.T) {
+	h := newHarness(t)
+	h.d.history = h.d.loadHistory()
+
+	// Five days of reports peaking at 30–50 MB, a day of steady growth at
+	// 0.5 MB/h and health-check repairs ten days apart.
+	today := h.clock.Now().Local()
+	for i := 1; i <= 5; i++ {
+		date := today.AddDate(0, 0, -i).Format("2006-01-02")
+		r := dailyReport{Date: date, Updated: h.clock.Now(), SizeSamples: 100, CacheMBMax: float64(25 + 5*i)}
+		raw, _ := json.Marshal(r)
+		if err := os.MkdirAll(h.d.reportDir(), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(h.d.reportDir(), reportBase(date)+".json"), raw, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < int(historySpan/historyBucket); i++ {
+		h.d.historySize(20 + float64(i)/12)
+		h.clock.Advance(historyBucket)
+	}
+	for i := 4; i > 0; i-- {
+		h.d.corruptions = append(h.d.corruptions, h.clock.Now().Add(-time.Duration(i)*10*24*time.Hour))
+	}
+	h.d.saveState()
+	h.d.corruptions = nil
+
+	r := h.d.buildTuneReport()
+	want := map[string]string{"thresholds.sizeLimit": "80MB", "thresholds.staleAge": "8d", "thresholds.growthSlope": "0.8"}
+	if len(r.Recommendations) != len(want) || r.Days != 5 || r.Repairs != 4 {
+		t.Fatalf("report = %+v", r)
+	}
+	for _, rec := range r.Recommendations {
+		if rec.Recommended != want[rec.Setting] {
+			t.Fatalf("%s recommended %q, want %q (%s)", rec.Setting, rec.Recommended, want[rec.Setting], rec.Basis)
+		}
+	}
+	out, code := captureStdout(t, func() int { return runCommand(h.d, []string{"tune", "-json"}) })
+	if code != 0 || !strings.Contains(out, `"schema": "tune"`) || !strings.Contains(out, `"recommended": "80MB"`) {
+		t.Fatalf("tune -json (exit %d):\n%s", code, out)
+	}
+
+	// -apply edits the file in place, keeping its comments, and leaves
+	// settings fixed by policy alone.
+	const file = "// site defaults\n{\n  \"thresholds\": {\n    \"sizeLimit\": \"32MB\", // too small\n    \"cooldown\": \"30m\"\n  }\n}\n"
+	if err := os.WriteFile(h.d.configFile, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.d.cfgSources = configSources{"thresholds.sizeLimit": sourceFile, "thresholds.growthSlope": sourcePolicy}
+	out, code = captureStdout(t, func() int { return runCommand(h.d, []string{"tune", "-apply", "-yes"}) })
+	if code != 0 || !strings.Contains(out, "thresholds.growthSlope is set by policy; not written") {
+		t.Fatalf("tune -apply (exit %d):\n%s", code, out)
+	}
+	raw, _ := os.ReadFile(h.d.configFile)
+	cfg, _, err := parseConfig(raw)
+	if err != nil || cfg.Thresholds.SizeLimit != 80*mib || cfg.Thresholds.StaleAge.D() != 8*24*time.Hour ||
+		cfg.Thresholds.GrowthSlope != 0 || cfg.Thresholds.Cooldown.D() != 30*time.Minute {
+		t.Fatalf("config after tune -apply (%v):\n%s", err, raw)
+	}
+	if !strings.Contains(string(raw), "// site defaults") || !strings.Contains(string(raw), `"sizeLimit": "80MB", // too small`) {
+		t.Fatalf("comments lost:\n%s", raw)
+	}
+	h.assertLog(h.d.watchLog, "INFO", "Config thresholds.sizeLimit set to 80MB (tune -apply).")
+
+	// A missing file is created; a value already in place is kept.
+	os.Remove(h.d.configFile)
+	if err := writeConfigValues(h.d.configFile, []configSet{{"thresholds.growthSlope", 0.8}, {"breaker.maxFailures", 5}}); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = os.ReadFile(h.d.configFile)
+	if cfg, _, err := parseConfig(raw); err != nil || cfg.Thresholds.GrowthSlope != 0.8 || cfg.Breaker.MaxFailures != 5 {
+		t.Fatalf("new config (%v):\n%s", err, raw)
+	}
+	h.d.cfg.Thresholds.SizeLimit = 80 * mib
+	if rec := h.d.buildTuneReport().Recommendations[0]; rec.Recommended != "" {
+		t.Fatalf("size limit already tuned, recommended %q", rec.Recommended)
+	}
+}
+
+func TestIntegrationTargets(t *testing.T) {
+	h := newHarness(t)
+	limit := h.d.cfg.Thresholds.SizeLimit
+
+	// Each session resolves its own account's target.
+	h.d.cfg.Targets = targetOptions{
+		Thumbnail: targetThresholds{SizeLimit: 2 * mib},
+		Users:     []userTarget{{Match: `CONTOSO\design-*`, Icon: targetThresholds{SizeLimit: 96 * mib}}},
+	}
+	sd := h.d.sessionDaemon(sessionInfo{ID: 2, Domain: "CONTOSO", User: "design-07", ProfileDir: t.TempDir()})
+	if sd.cfg.Thresholds.SizeLimit != 96*mib || sd.thumbnails.SizeLimit != 2*mib || h.d.cfg.Thresholds.SizeLimit != limit {
+		t.Fatalf("session limit = %s, thumbnails = %+v, service limit = %s", sd.cfg.Thresholds.SizeLimit, sd.thumbnails, h.d.cfg.Thresholds.SizeLimit)
+	}
+
+	// The thumbnail cache is repaired on its own limit, then once per
+	// thumbnail cooldown.
+	h.d.thumbnails = targetThresholds{SizeLimit: 2 * mib, Cooldown: duration(4 * time.Hour)}
+	thumbFile := filepath.Join(h.cache, "thumbcache_256.db")
+	if err := os.WriteFile(thumbFile, make([]byte, 3<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.d.checkSize()
+	if r := h.waitRepairs(1); !strings.Contains(r[0], "thumbnail cache 3.00 MB exceeds 2MB limit") {
+		t.Fatalf("repairs = %q", r)
+	}
+	h.assertLog(h.d.watchLog, "TRIGGER", "Thumbnail cache is 3.00 MB > 2MB threshold (targets.thumbnail).")
+	if s := h.d.statusSnapshot(); s.Thumbnails == nil || s.Thumbnails.SizeMB != 3 || s.Thumbnails.LastRepair.IsZero() {
+		t.Fatalf("status thumbnails = %+v", s.Thumbnails)
+	}
+	// The repair cleared it; thumbnails fill it up again.
+	if _, err := os.Stat(thumbFile); !os.IsNotExist(err) {
+		t.Fatalf("thumbnail cache after repair: %v", err)
+	}
+	if err := os.WriteFile(thumbFile, make([]byte, 3<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.clock.Advance(2 * time.Hour)
+	h.d.checkSize()
+	h.noRepairs(1)
+
+	if err := os.Truncate(thumbFile, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "INFO", "Thumbnail cache is back under the 2MB threshold (1.00 MB) after 2h over it.")
+
+	// A repair handed to the repair task keeps -IncludeThumbcache. The
+	// probe path taken by a directory denies the direct route; without
+	// schtasks the task cannot start, so no thumbnail repair is recorded.
+	if err := os.WriteFile(thumbFile, make([]byte, 3<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	probe := filepath.Join(h.cache, ".icon-cache-watchdog.probe")
+	if err := os.Mkdir(probe, 0755); err != nil {
+		t.Fatal(err)
+	}
+	h.clock.Advance(4 * time.Hour)
+	repaired := h.d.thumbRepaired
+	h.d.checkSize()
+	h.assertLog(h.d.watchLog, "WARN", "Routing via "+eventRepairTask)
+	if h.d.caps.RepairRoute != routeTrampoline || h.d.thumbRepaired != repaired {
+		t.Fatalf("route = %s, thumbnail repair = %s (was %s)", h.d.caps.RepairRoute, h.d.thumbRepaired, repaired)
+	}
+	h.d.mu.Lock()
+	pending := h.d.repairRequestPending()
+	h.d.mu.Unlock()
+	if !pending {
+		t.Fatal("no repair request left in state.json")
+	}
+	os.Remove(probe)
+	if _, code := captureStdout(t, func() int { return runCommand(h.d, []string{"repair"}) }); code != 0 {
+		t.Fatalf("repair exited %d", code)
+	}
+	if r := h.waitRepairs(2); !strings.Contains(r[1], "thumbnail cache 3.00 MB exceeds 2MB limit") {
+		t.Fatalf("repairs = %q", r)
+	}
+	if _, err := os.Stat(thumbFile); !os.IsNotExist(err) {
+		t.Fatalf("thumbnail cache after the task's repair: %v", err)
+	}
+
+	// The broker is told in its handoff file.
+	if err := h.d.requestElevatedRepair("thumbnail cache", false, true); err == nil {
+		t.Fatal("broker started without schtasks")
+	}
+	var ho handoff
+	if raw, err := os.ReadFile(h.d.handoffFile()); err != nil || json.Unmarshal(raw, &ho) != nil || !ho.Thumbcache {
+		t.Fatalf("handoff = %+v (%v)", ho, err)
+	}
+}
+
+func TestIntegrationControlProtocol(t *testing.T) {
+	h := newHarness(t)
+	addr := h.d.controlAddr()
+
+	// A daemon that predates the hello refuses it; the client asks again
+	// in an API 1 line.
+	old, err := listenControl(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := old.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			lines <- line
+			reply := `{"type":"error","error":"API version 2 not supported (this daemon speaks 1)"}`
+			if !strings.Contains(line, `"hello"`) {
+				reply = `{"type":"status","status":{"cacheDir":"C:\\old"}}`
+			}
+			fmt.Fprintln(conn, reply)
+			conn.Close()
+		}
+	}()
+	c, err := openControl(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := c.call(controlRequest{Cmd: requestStatus})
+	c.Close()
+	if err != nil || ev.Status == nil || ev.Status.CacheDir != `C:\old` || c.framed || c.api != 1 {
+		t.Fatalf("status from an API 1 daemon = %+v, %v (framed %v, api %d)", ev, err, c.framed, c.api)
+	}
+	if first, second := <-lines, <-lines; !strings.Contains(first, `"cmd":"hello"`) || strings.TrimSpace(second) != `{"cmd":"status"}` {
+		t.Fatalf("requests = %q, %q", first, second)
+	}
+	old.Close()
+
+	// A current daemon agrees on frames and pings.
+	h.startControl()
+	c, err = openControl(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err = c.call(controlRequest{Cmd: requestVersion})
+	c.Close()
+	if err != nil || !c.framed || c.api != controlAPIVersion || !slices.Equal(c.caps, controlCaps) ||
+		ev.MinAPI != 1 || !slices.Contains(ev.Commands, requestHello) {
+		t.Fatalf("version = %+v, %v (framed %v, api %d, caps %v)", ev, err, c.framed, c.api, c.caps)
+	}
+
+	// Clients that send no hello are answered in lines, at API 1.
+	raw := func(request string) string {
+		t.Helper()
+		conn, err := dialControl(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprintln(conn, request)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return line
+	}
+	if got := raw(`{"cmd":"version","api":1}`); !strings.HasPrefix(got, `{"type":"version"`) || !strings.Contains(got, `"api":2,"minApi":1`) {
+		t.Fatalf("API 1 version reply = %q", got)
+	}
+	if got := raw(`{"cmd":"status","api":2}`); !strings.Contains(got, "API version 2 not supported on this connection (it speaks 1") {
+		t.Fatalf("API 2 request in a line = %q", got)
+	}
+	if got := raw(`{"cmd":"hello","api":1,"caps":["framed","ping","future"]}`); !strings.Contains(got, `"api":1,"caps":["ping"]`) {
+		t.Fatalf("API 1 hello = %q", got)
+	}
+
+	// A frame over the limit is refused.
+	conn, err := dialControl(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	fmt.Fprintln(conn, `{"cmd":"hello","api":2,"caps":["framed"]}`)
+	if _, err := r.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte{0x7f, 0, 0, 0})
+	if msg, err := readFrame(r); err != nil || !strings.Contains(string(msg), "frame larger than") {
+		t.Fatalf("oversized frame: %s, %v", msg, err)
+	}
+	conn.Close()
+
+	// An idle stream is pinged; the client skips the pings.
+	h.clock.mu.Lock()
+	tickers := len(h.clock.tickers)
+	h.clock.mu.Unlock()
+	c, err = openControl(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.send(controlRequest{Cmd: requestProgress}); err != nil {
+		t.Fatal(err)
+	}
+	h.clock.waitTickers(t, tickers+1)
+	h.clock.Advance(controlPingEvery)
+	if msg, err := readFrame(c.r); err != nil || !strings.Contains(string(msg), `"type":"ping"`) {
+		t.Fatalf("ping = %s, %v", msg, err)
+	}
+	h.clock.Advance(controlPingEvery)
+	h.d.progress.publish(controlEvent{At: h.clock.Now(), Phase: phaseLaunched, Reason: "test"})
+	if ev, _, err := c.next(); err != nil || ev.Type != eventProgress || ev.Phase != phaseLaunched {
+		t.Fatalf("after a ping: %+v, %v", ev, err)
+	}
+}
+
+func TestIntegrationLegacyLogMigration(t *testing.T) {
+	h := newHarness(t)
+	legacy := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(path string) string {
+		raw, _ := os.ReadFile(path)
+		return string(raw)
+	}
+	health, repair := filepath.Join(h.d.logDir, "IconCacheHealth.log"), filepath.Join(h.d.logDir, "IconCacheRepair.log")
+	os.Remove(health)
+	write(repair, "new line\n")
+	write(filepath.Join(legacy, "IconCacheHealth.log"), "old health\n")
+	write(filepath.Join(legacy, "IconCacheRepair.log"), "old repair\n")
+
+	// A log not yet at the destination is moved; one that is gets appended.
+	h.d.migrateLegacyLogs(legacy)
+	if got := read(health); got != "old health\n" {
+		t.Fatalf("health log = %q", got)
+	}
+	if got := read(repair); got != "new line\nold repair\n" {
+		t.Fatalf("repair log = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(legacy, "IconCacheRepair.log")); !os.IsNotExist(err) {
+		t.Fatalf("legacy copy left behind: %v", err)
+	}
+
+	// A copy that could not be removed is not appended again.
+	write(filepath.Join(legacy, "IconCacheRepair.log"), "old repair\n")
+	write(repair+".migrated", filepath.Join(legacy, "IconCacheRepair.log")+"\n")
+	h.d.migrateLegacyLogs(legacy)
+	if got := read(repair); got != "new line\nold repair\n" {
+		t.Fatalf("repair log after a second start = %q", got)
+	}
+}
+
+func TestIntegrationSignedConfig(t *testing.T) {
+	h := newHarness(t)
+	dir := t.TempDir()
+	keyFile, cfgFile := filepath.Join(dir, "signing.key"), filepath.Join(dir, configFileName)
+	if err := os.WriteFile(cfgFile, []byte(`{"thresholds": {"sizeLimit": "64MB"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, code := captureStdout(t, func() int { return runCommand(h.d, []string{"config", "keygen", "-out=" + keyFile}) })
+	_, b64, ok := strings.Cut(out, "Public key: ")
+	if code != 0 || !ok {
+		t.Fatalf("keygen (exit %d):\n%s", code, out)
+	}
+	pub, err := base64.StdEncoding.DecodeString(strings.Fields(b64)[0])
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		t.Fatalf("public key %q: %v", b64, err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Fatalf("private key file: %v, %v", info, err)
+	}
+	if _, code := captureStdout(t, func() int { return runCommand(h.d, []string{"config", "sign", "-key=" + keyFile, cfgFile}) }); code != 0 {
+		t.Fatalf("config sign exit %d", code)
+	}
+
+	p := signingPolicy{required: true, publicKey: pub}
+	raw, _ := os.ReadFile(cfgFile)
+	if err := p.verify(cfgFile, raw); err != nil {
+		t.Fatalf("signed config rejected: %v", err)
+	}
+	if err := p.verify(cfgFile, append(raw, ' ')); !errors.Is(err, errConfigSignature) {
+		t.Fatalf("edited config accepted: %v", err)
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if err := (signingPolicy{required: true, publicKey: other}).verify(cfgFile, raw); !errors.Is(err, errConfigSignature) {
+		t.Fatalf("config accepted with another machine's key: %v", err)
+	}
+	// An HMAC key alone no longer satisfies the requirement.
+	if err := (signingPolicy{required: true, legacyKey: true}).verify(cfgFile, raw); err == nil || !strings.Contains(err.Error(), "no longer trusted") {
+		t.Fatalf("legacy key: %v", err)
+	}
+	os.Remove(signatureFile(cfgFile))
+	if err := p.verify(cfgFile, raw); !errors.Is(err, errConfigSignature) {
+		t.Fatalf("unsigned config accepted: %v", err)
+	}
+}
+
+func TestIntegrationSimulate(t *testing.T) {
+	h := newHarness(t)
+
+	// testdata/trace.jsonl: the cache grows past the 32MB limit, is repaired,
+	// grows again within the cooldown and is repaired once it has passed.
+	out, code := captureStdout(t, func() int { return runCommand(h.d, []string{"simulate", "-json", "testdata/trace.jsonl"}) })
+	var r struct {
+		Schema string `json:"schema"`
+		simReport
+	}
+	if err := json.Unmarshal([]byte(out), &r); err != nil || code != 0 || r.Schema != "simulate" {
+		t.Fatalf("simulate exit %d, %v:\n%s", code, err, out)
+	}
+	if r.Snapshots != 6 || r.SizeChecks != 6 || r.HealthChecks != 2 || r.SizeTriggers != 3 || r.HealthFails != 0 || r.Cooldowns != 1 || r.Deferrals != 0 {
+		t.Fatalf("report = %+v", r.simReport)
+	}
+	if len(r.Repairs) != 2 || r.Repairs[0].At.Format("15:04") != "08:05" || r.Repairs[1].At.Format("15:04") != "08:45" ||
+		!strings.Contains(r.Repairs[1].Reason, "48.47 MB exceeds 32MB limit") {
+		t.Fatalf("repairs = %+v", r.Repairs)
+	}
+	// Nothing is repaired for real.
+	h.noRepairs(0)
+
+	// A trace that does not parse is refused before anything is replayed.
+	for name, trace := range map[string]string{
+		"bad json":     `{"t":"2026-10-01T08:00:00Z","files":[` + "\n",
+		"no timestamp": `{"files":[]}` + "\n",
+		"out of order": `{"t":"2026-10-01T08:05:00Z","files":[]}` + "\n" + `{"t":"2026-10-01T08:00:00Z","files":[]}` + "\n",
+		"newer":        `{"trace":2}` + "\n",
+	} {
+		if _, _, err := readTrace(strings.NewReader(trace)); err == nil {
+			t.Errorf("%s: readTrace accepted %q", name, trace)
+		}
+	}
+	_, snaps, err := readTrace(strings.NewReader(`{"trace":1}` + "\n\n" + `{"t":"2026-10-01T08:00:00Z","files":[]}` + "\n"))
+	if err != nil || len(snaps) != 1 {
+		t.Fatalf("readTrace = %d snapshots, %v", len(snaps), err)
+	}
+	_, _, err = readTrace(strings.NewReader(`{"trace":1}` + "\n" + `{"t":"2026-10-01T08:00:00Z","files":[]}` + "\n" + `not json` + "\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Fatalf("readTrace error = %v", err)
+	}
+	bad := filepath.Join(t.TempDir(), "bad.jsonl")
+	os.WriteFile(bad, []byte(`{"t":"2026-10-01T08:00:00Z","files":[`), 0644)
+	if _, code := captureStdout(t, func() int { return runCommand(h.d, []string{"simulate", bad}) }); code != 1 {
+		t.Fatalf("simulate of a malformed trace exited %d", code)
+	}
+	if _, code := captureStdout(t, func() int { return runCommand(h.d, []string{"simulate"}) }); code != 2 {
+		t.Fatalf("simulate without a trace exited %d", code)
+	}
+}
+
+func TestIntegrationRecordTrace(t *testing.T) {
+	h := newHarness(t)
+	trace := filepath.Join(t.TempDir(), "trace.jsonl")
+	lines := func() int {
+		raw, _ := os.ReadFile(trace)
+		return strings.Count(string(raw), "\n")
+	}
+	waitLines := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); lines() < n; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("trace has %d lines, want %d", lines(), n)
+			}
+		}
+	}
+
+	done := make(chan int)
+	go func() {
+		_, code := captureStdout(t, func() int {
+			return runCommand(h.d, []string{"record", "-o", trace, "-every", "1m", "-duration", "4m"})
+		})
+		done <- code
+	}()
+	h.clock.waitTickers(t, 1)
+	waitLines(2) // header and first snapshot
+
+	// A snapshot per poll that changed the cache: over the limit, back
+	// under after a repair, over again within the cooldown.
+	for i, sizeMB := range []int{40, 0, 48} {
+		if sizeMB == 0 {
+			writeHealthyCache(h.cache)
+		} else {
+			h.bloat(sizeMB)
+		}
+		h.clock.Advance(time.Minute)
+		waitLines(3 + i)
+	}
+	// An unchanged poll writes nothing; the last one ends the recording.
+	h.clock.Advance(time.Minute)
+	if code := <-done; code != 0 {
+		t.Fatalf("record exited %d", code)
+	}
+
+	f, err := os.Open(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, snaps, err := readTrace(f)
+	f.Close()
+	if err != nil || hdr.Version != traceVersion || len(snaps) != 4 {
+		t.Fatalf("readTrace = %+v, %d snapshots, %v", hdr, len(snaps), err)
+	}
+	if n := len(snaps[1].Files); n != 6 || snaps[1].At.Sub(snaps[0].At) != time.Minute || snaps[1].Explorer == nil || !*snaps[1].Explorer {
+		t.Fatalf("snapshot = %+v", snaps[1])
+	}
+
+	out, code := captureStdout(t, func() int { return runCommand(h.d, []string{"simulate", "-json", trace}) })
+	var r simReport
+	if err := json.Unmarshal([]byte(out), &r); err != nil || code != 0 {
+		t.Fatalf("simulate exit %d, %v:\n%s", code, err, out)
+	}
+	if r.Snapshots != 4 || r.SizeTriggers != 2 || r.Cooldowns != 1 || len(r.Repairs) != 1 || !r.Repairs[0].At.Equal(snaps[1].At) {
+		t.Fatalf("report = %+v", r)
+	}
+	h.noRepairs(0)
+}
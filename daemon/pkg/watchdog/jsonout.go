@@ -0,0 +1,77 @@
+// jsonout.go
+// Machine-readable output of the query commands, for scripts and RMM
+// agents. Every query command takes -json (--json works as well; the flag
+// package accepts both) and prints one JSON object whose first two fields
+// name its schema and the schema's version:
+//
+//	{"schema": "status", "schemaVersion": 1, "pid": 4120, ...}
+//
+// The streaming commands (logs, progress) print one such object per line.
+// Within a version fields are only added, never renamed, removed or given
+// a new meaning; anything else bumps that schema's version in jsonSchemas,
+// so a parser that checks schema and schemaVersion can rely on what it
+// reads. compliance keeps the one-line contract Intune expects.
+
+package watchdog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// jsonSchemas are the current versions of the -json outputs, by schema
+// name (the command, or "config-<subcommand>"; "heartbeat" is the payload
+// of the heartbeat sinks, "incident" that of the incident webhook, "fleet"
+// that of `status -host`).
+var jsonSchemas = map[string]int{
+	"status":                1,
+	"healthcheck":           1,
+	"history":               1,
+	"summary":               1,
+	"rollback":              1,
+	"dashboard":             1,
+	"logs":                  1,
+	"progress":              1,
+	"shellext":              1,
+	"plugins":               1,
+	"simulate":              1,
+	"config-show-effective": 1,
+	"config-validate":       1,
+	"config-presets":        1,
+	"heartbeat":             1,
+	"incident":              1,
+	"explain":               1,
+	"strays":                1,
+	"fleet":                 1,
+	"tune":                  1,
+}
+
+// stampJSON prefixes a marshalled JSON object with its schema and version.
+func stampJSON(schema string, object []byte) []byte {
+	head, _ := json.Marshal(struct {
+		Schema  string `json:"schema"`
+		Version int    `json:"schemaVersion"`
+	}{schema, jsonSchemas[schema]})
+	rest := bytes.TrimSpace(bytes.TrimPrefix(bytes.TrimSpace(object), []byte("{")))
+	if len(rest) > 1 { // not "}"
+		head = append(head[:len(head)-1], ',')
+		head = append(head, rest...)
+	}
+	return head
+}
+
+// printJSON prints v, which must marshal to an object, indented and
+// stamped with its schema.
+func printJSON(schema string, v any) {
+	raw, _ := json.Marshal(v)
+	var out bytes.Buffer
+	json.Indent(&out, stampJSON(schema, raw), "", "  ")
+	fmt.Println(out.String())
+}
+
+// printJSONLine prints one stamped object of a stream on a line of its own;
+// raw is the object as marshalled already.
+func printJSONLine(schema string, raw []byte) {
+	fmt.Println(string(stampJSON(schema, raw)))
+}
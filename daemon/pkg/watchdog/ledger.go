@@ -0,0 +1,312 @@
+// ledger.go
+// Content integrity ledger (ledger.enabled), heuristic H6. After every
+// health check that passes in full, the daemon hashes the cache files in
+// 64 KiB blocks — ledger.sampleBlocks evenly spaced blocks per file, or all
+// of them — and keeps the hashes with each file's size and modification
+// time in ledger.json. The next health check hashes the same blocks again.
+// A file that Explorer rewrote, grew or that a repair deleted has a new size
+// or modification time and is explained; a block that changed while both
+// stayed the same was written behind Explorer's back (a tool restoring
+// timestamps, a bad sector, a filter driver) and fails H6 with the block's
+// offset and both hashes as evidence.
+//
+// Each check also logs what changed since the ledger, as one line: files
+// added, removed, resized and rehashed (same size, sampled blocks changed),
+// so after an incident the health log shows what happened to the cache
+// between the last good check and the bad one.
+//
+// Explorer writes parts of the cache through file mappings, which do not
+// always move the modification time at once, so H6 is off by default.
+// Multi-session monitors skip it.
+
+package watchdog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ledgerFileName  = "ledger.json"
+	ledgerBlockSize = 64 * 1024
+)
+
+type ledgerOptions struct {
+	Enabled bool `json:"enabled"`
+
+	// SampleBlocks is the number of blocks hashed per file, first and last
+	// included; 0 hashes every block.
+	SampleBlocks int `json:"sampleBlocks"`
+
+	// Weight is the score weight of H6 (score.go); the default outweighs
+	// the margin below 100, so a mismatch repairs.
+	Weight float64 `json:"weight"`
+}
+
+func defaultLedgerOptions() ledgerOptions {
+	return ledgerOptions{SampleBlocks: 32, Weight: 20}
+}
+
+type ledgerBlock struct {
+	Offset int64  `json:"offset"`
+	SHA256 string `json:"sha256"`
+}
+
+type ledgerEntry struct {
+	Size    int64         `json:"size"`
+	ModTime time.Time     `json:"modTime"`
+	Blocks  []ledgerBlock `json:"blocks"`
+}
+
+// ledger is ledger.json: the cache as of the last healthy check.
+type ledger struct {
+	Recorded time.Time              `json:"recorded"`
+	CacheDir string                 `json:"cacheDir"`
+	Files    map[string]ledgerEntry `json:"files"`
+}
+
+// ledgerMismatch is one block whose content changed under an unchanged
+// size and modification time.
+type ledgerMismatch struct {
+	File     string `json:"file"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	Recorded string `json:"recorded"` // SHA-256 in the ledger
+	Found    string `json:"found"`
+}
+
+func (m ledgerMismatch) String() string {
+	return fmt.Sprintf("%s bytes %d-%d (0x%x): SHA-256 %s is now %s", m.File, m.Offset, m.Offset+m.Length-1, m.Offset, m.Recorded[:16], m.Found[:16])
+}
+
+type ledgerResult struct {
+	Recorded   time.Time        `json:"recorded"`  // ledger compared against
+	Compared   int              `json:"compared"`  // files with unchanged size and mtime
+	Explained  int              `json:"explained"` // files rewritten, resized or deleted since
+	Mismatches []ledgerMismatch `json:"mismatches,omitempty"`
+	Diff       ledgerDiff       `json:"diff"`
+}
+
+// ledgerDiff summarises how the cache changed since the ledger was
+// recorded, for working out afterwards what touched it.
+type ledgerDiff struct {
+	Added     []string       `json:"added,omitempty"`
+	Removed   []string       `json:"removed,omitempty"`
+	Resized   []fileDelta    `json:"resized,omitempty"`
+	Rehashed  []ledgerRehash `json:"rehashed,omitempty"` // same size, sampled blocks rehashed
+	Unchanged int            `json:"unchanged"`
+}
+
+type ledgerRehash struct {
+	File     string `json:"file"`
+	Changed  int    `json:"changed"` // blocks whose hash differs
+	Of       int    `json:"of"`
+	Tampered bool   `json:"tampered,omitempty"` // modification time unchanged (H6)
+}
+
+func (r ledgerRehash) String() string {
+	s := fmt.Sprintf("%s %d/%d blocks", r.File, r.Changed, r.Of)
+	if r.Tampered {
+		s += " with mtime unchanged"
+	}
+	return s
+}
+
+func (g ledgerDiff) changed() bool {
+	return len(g.Added)+len(g.Removed)+len(g.Resized)+len(g.Rehashed) > 0
+}
+
+func (g ledgerDiff) String() string {
+	var parts []string
+	add := func(n int, what string, items []string) {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s (%s)", n, what, strings.Join(items, ", ")))
+		}
+	}
+	add(len(g.Added), "added", g.Added)
+	add(len(g.Removed), "removed", g.Removed)
+	var items []string
+	for _, c := range g.Resized {
+		items = append(items, c.String())
+	}
+	add(len(g.Resized), "resized", items)
+	items = nil
+	for _, r := range g.Rehashed {
+		items = append(items, r.String())
+	}
+	add(len(g.Rehashed), "rehashed", items)
+	return strings.Join(append(parts, fmt.Sprintf("%d unchanged", g.Unchanged)), "; ")
+}
+
+func (d *daemon) ledgerPath() string { return filepath.Join(d.dataDir, ledgerFileName) }
+
+// ledgerActive reports whether H6 runs in this daemon.
+func (d *daemon) ledgerActive() bool {
+	return d.cfg.Ledger.Enabled && d.session == nil && d.sim == nil
+}
+
+// ledgerCacheFiles lists the cache files the ledger covers, by name.
+func (d *daemon) ledgerCacheFiles() []string {
+	return cacheFileNames(d.cacheDir, d.cfg.CacheFiles.Icon, d.cfg.CacheFiles.Thumbnail)
+}
+
+// H6: cache content unchanged where size and mtime say it should be. A nil
+// result means there was no ledger to compare against. Every change since
+// the ledger is summarised in the health log.
+func (d *daemon) checkH6Ledger() (bool, *ledgerResult) {
+	raw, err := os.ReadFile(d.ledgerPath())
+	var l ledger
+	if err == nil {
+		err = json.Unmarshal(raw, &l)
+	}
+	if err != nil || l.CacheDir != d.cacheDir {
+		d.healthLog_("PASS", "H6 PASS: no ledger for this cache yet (recorded after the next healthy check).")
+		return true, nil
+	}
+	res := &ledgerResult{Recorded: l.Recorded}
+	for _, name := range d.ledgerCacheFiles() {
+		if _, ok := l.Files[name]; !ok {
+			res.Diff.Added = append(res.Diff.Added, name)
+		}
+	}
+	names := make([]string, 0, len(l.Files))
+	for name := range l.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e := l.Files[name]
+		path := filepath.Join(d.cacheDir, name)
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			res.Diff.Removed = append(res.Diff.Removed, name)
+			res.Explained++
+			continue
+		case info.Size() != e.Size:
+			res.Diff.Resized = append(res.Diff.Resized, fileDelta{Name: name, Before: e.Size, After: info.Size()})
+			res.Explained++
+			continue
+		}
+		offsets := make([]int64, len(e.Blocks))
+		for i, b := range e.Blocks {
+			offsets[i] = b.Offset
+		}
+		blocks, err := hashBlocks(path, offsets)
+		if err != nil {
+			d.healthLog_("WARN", fmt.Sprintf("H6: cannot read %s: %v", name, err))
+			continue
+		}
+		sameTime := info.ModTime().Equal(e.ModTime)
+		rehash := ledgerRehash{File: name, Of: len(blocks)}
+		for i, b := range blocks {
+			if b.SHA256 == e.Blocks[i].SHA256 {
+				continue
+			}
+			rehash.Changed++
+			if sameTime {
+				res.Mismatches = append(res.Mismatches, ledgerMismatch{
+					File: name, Offset: b.Offset, Length: min(ledgerBlockSize, e.Size-b.Offset),
+					Recorded: e.Blocks[i].SHA256, Found: b.SHA256,
+				})
+			}
+		}
+		rehash.Tampered = sameTime && rehash.Changed > 0
+		if sameTime {
+			res.Compared++
+		} else {
+			res.Explained++
+		}
+		if rehash.Changed > 0 || !sameTime {
+			res.Diff.Rehashed = append(res.Diff.Rehashed, rehash)
+		} else {
+			res.Diff.Unchanged++
+		}
+	}
+	if res.Diff.changed() {
+		d.healthLog_("INFO", fmt.Sprintf("Cache diff since %s: %s.", l.Recorded.Format("2006-01-02 15:04:05"), res.Diff))
+	}
+
+	if n := len(res.Mismatches); n > 0 {
+		msg := fmt.Sprintf("H6 FAIL: cache content changed without a size or modification time change since %s: %s", l.Recorded.Format("2006-01-02 15:04"), res.Mismatches[0])
+		if n > 1 {
+			msg += fmt.Sprintf(" (and %d more block(s))", n-1)
+		}
+		d.healthLog_("WARN", msg+".")
+		return false, res
+	}
+	d.healthLog_("PASS", fmt.Sprintf("H6 PASS: %d file(s) match the ledger, %d changed with their size or modification time.", res.Compared, res.Explained))
+	return true, res
+}
+
+// recordLedger hashes the cache as it is now into ledger.json.
+func (d *daemon) recordLedger() {
+	l := ledger{Recorded: d.clock.Now(), CacheDir: d.cacheDir, Files: map[string]ledgerEntry{}}
+	for _, name := range d.ledgerCacheFiles() {
+		path := filepath.Join(d.cacheDir, name)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		blocks, err := hashBlocks(path, ledgerOffsets(info.Size(), d.cfg.Ledger.SampleBlocks))
+		if err != nil {
+			continue // locked or gone; compared again once recorded
+		}
+		l.Files[name] = ledgerEntry{Size: info.Size(), ModTime: info.ModTime(), Blocks: blocks}
+	}
+	raw, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := writeFileAtomic(d.ledgerPath(), raw); err != nil {
+		d.healthLog_("WARN", "Could not write ledger.json: "+err.Error())
+	}
+}
+
+// ledgerOffsets picks the blocks to hash in a file of size bytes: all of
+// them, or sample evenly spaced ones including the first and the last.
+func ledgerOffsets(size int64, sample int) []int64 {
+	n := (size + ledgerBlockSize - 1) / ledgerBlockSize
+	if sample <= 0 || n <= int64(sample) {
+		offsets := make([]int64, n)
+		for i := range offsets {
+			offsets[i] = int64(i) * ledgerBlockSize
+		}
+		return offsets
+	}
+	if sample == 1 {
+		return []int64{0}
+	}
+	offsets := make([]int64, sample)
+	for i := range offsets {
+		offsets[i] = int64(i) * (n - 1) / int64(sample-1) * ledgerBlockSize
+	}
+	return offsets
+}
+
+func hashBlocks(path string, offsets []int64) ([]ledgerBlock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, ledgerBlockSize)
+	blocks := make([]ledgerBlock, 0, len(offsets))
+	for _, off := range offsets {
+		n, err := f.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		sum := sha256.Sum256(buf[:n])
+		blocks = append(blocks, ledgerBlock{Offset: off, SHA256: hex.EncodeToString(sum[:])})
+	}
+	return blocks, nil
+}
@@ -0,0 +1,141 @@
+// logfeed.go
+// Live log stream. Every line the daemon logs is also published to a feed
+// that keeps the latest logFeedBacklog lines, so the CLI and the dashboard
+// can follow the log without opening and refreshing the files:
+//
+//   → {"cmd":"logs","lines":20,"follow":true}
+//   ← {"type":"log","at":"...","log":{"subsystem":"repair","level":"TRIGGER","message":"..."}}
+//
+// A client first receives up to `lines` lines of the backlog; with `follow`
+// it then receives every new line until it disconnects, else the daemon
+// hangs up. `logs` prints the lines in the unified log format, and the
+// dashboard reads GET /api/logs as server-sent events. Like repair
+// progress, a client that does not keep up loses lines rather than stall
+// the daemon.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const logFeedBacklog = 200
+
+type logEntry struct {
+	At        time.Time `json:"at"`
+	Subsystem string    `json:"subsystem"`
+	Level     string    `json:"level"`
+	Code      string    `json:"code,omitempty"` // message code (catalog.go)
+	Message   string    `json:"message"`
+}
+
+// String formats e as a unified log line.
+func (e logEntry) String() string {
+	msg := e.Message
+	if e.Code != "" {
+		msg = e.Code + " " + msg
+	}
+	return fmt.Sprintf("[%s][%s] subsystem=%s %s", e.At.Format("2006-01-02 15:04:05"), e.Level, e.Subsystem, msg)
+}
+
+type logFeed struct {
+	mu     sync.Mutex
+	subs   map[chan logEntry]struct{}
+	recent []logEntry
+}
+
+func newLogFeed() *logFeed {
+	return &logFeed{subs: map[chan logEntry]struct{}{}}
+}
+
+// publish is a no-op on a nil feed (simulation, tests).
+func (f *logFeed) publish(e logEntry) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recent = append(f.recent, e)
+	if n := len(f.recent); n > logFeedBacklog {
+		f.recent = append(f.recent[:0], f.recent[n-logFeedBacklog:]...)
+	}
+	for ch := range f.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// backlog returns up to n of the latest lines.
+func (f *logFeed) backlog(n int) []logEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n = min(max(n, 0), len(f.recent))
+	return append([]logEntry{}, f.recent[len(f.recent)-n:]...)
+}
+
+// subscribe returns a channel that first yields up to backlog lines.
+func (f *logFeed) subscribe(backlog int) chan logEntry {
+	ch := make(chan logEntry, logFeedBacklog+64)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := min(max(backlog, 0), len(f.recent))
+	for _, e := range f.recent[len(f.recent)-n:] {
+		ch <- e
+	}
+	f.subs[ch] = struct{}{}
+	return ch
+}
+
+func (f *logFeed) unsubscribe(ch chan logEntry) {
+	f.mu.Lock()
+	delete(f.subs, ch)
+	f.mu.Unlock()
+}
+
+// cmdLogs prints the running daemon's latest log lines and, with -f, follows
+// the log.
+func cmdLogs(d *daemon, args []string) int {
+	fs := newFlagSet("logs")
+	lines := fs.Int("n", 20, "lines of backlog to print first")
+	follow := fs.Bool("f", false, "keep following new lines")
+	asJSON := fs.Bool("json", false, "print the raw events")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	c, err := openControl(d.controlAddr())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Daemon not reachable: %v\nThe log files are in %s.\n", err, d.logDir)
+		return 1
+	}
+	defer c.Close()
+	if err := c.send(controlRequest{Cmd: requestLogs, Lines: *lines, Follow: *follow}); err != nil {
+		fmt.Fprintf(os.Stderr, "Control pipe: %v\n", err)
+		return 1
+	}
+	if *follow {
+		fmt.Fprintln(os.Stderr, "Following the daemon log (Ctrl+C to stop)...")
+	}
+	for {
+		ev, _, err := c.next()
+		if err != nil {
+			return 0
+		}
+		switch {
+		case ev.Type == eventError:
+			fmt.Fprintf(os.Stderr, "Daemon: %s\n", ev.Error)
+			return 1
+		case ev.Log == nil:
+		case *asJSON:
+			out, _ := json.Marshal(ev)
+			printJSONLine("logs", out)
+		default:
+			fmt.Println(ev.Log)
+		}
+	}
+}
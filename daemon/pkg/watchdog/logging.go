@@ -0,0 +1,30 @@
+// logging.go
+// Log layout. "split" (default, the v2 layout) writes Watchdog.log and
+// IconCacheHealth.log. "unified" writes every line to IconCache.log with a
+// subsystem tag, so an incident reads in one file:
+//
+//   [2026-03-02 14:05:11][TRIGGER] subsystem=repair R200 Repair triggered: ...
+//
+// In split mode health lines go to the health log and everything else to the
+// watchdog log, untagged, exactly as before.
+
+package watchdog
+
+const unifiedLogName = "IconCache.log"
+
+const (
+	logModeSplit   = "split"
+	logModeUnified = "unified"
+)
+
+// Subsystem tags.
+const (
+	subsystemWatchdog = "watchdog" // Layer B, startup, state
+	subsystemHealth   = "health"   // Layers C and D
+	subsystemRepair   = "repair"   // trigger, hold, launch
+	subsystemIPC      = "ipc"      // hand-offs to other processes
+)
+
+type loggingOptions struct {
+	Mode string `json:"mode"` // "split" or "unified"
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+// longpath_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func extendedPath(path string) string { return path }
@@ -0,0 +1,32 @@
+// longpath_windows.go
+// Extended-length paths for the Win32 calls the daemon makes itself. The os
+// package already prefixes long paths for its own calls; syscall.CreateFile
+// and the other wide APIs called directly stop at MAX_PATH unless the path
+// carries the \\?\ prefix.
+
+package watchdog
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPathMin leaves room for an 8.3 file name below a directory, as
+// CreateDirectory requires (MAX_PATH - 12).
+const longPathMin = 248
+
+// extendedPath returns an absolute path of longPathMin or more in the
+// \\?\ (or \\?\UNC\) form. len counts UTF-8 bytes, never fewer than the
+// UTF-16 units Windows counts, so non-ASCII paths switch over early rather
+// than late. Short, relative and already extended paths are returned as
+// they are.
+func extendedPath(path string) string {
+	if len(path) < longPathMin || strings.HasPrefix(path, `\\?\`) || !filepath.IsAbs(path) {
+		return path
+	}
+	path = filepath.Clean(path)
+	if rest, ok := strings.CutPrefix(path, `\\`); ok {
+		return `\\?\UNC\` + rest
+	}
+	return `\\?\` + path
+}
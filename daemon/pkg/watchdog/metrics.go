@@ -0,0 +1,277 @@
+// metrics.go
+// Daemon metrics: one registry of counters, gauges, histograms and
+// summaries that the watchdog, health and repair subsystems update from
+// their own goroutines. Counters, gauges and histograms are atomic, so no
+// lock is taken on the hot paths; a summary keeps its last summaryWindow
+// observations under a lock of its own for the p50 and p95. Every
+// metric is declared once in metricDescs; the same values are written to
+// status.json and `status`, summarised in the heartbeat line and served in
+// the Prometheus text format on the dashboard (GET /api/metrics).
+//
+// Per-session monitors (multi-session mode) share the host daemon's
+// registry; counters add up across sessions and the per-cache gauges are
+// left to the single-user daemon. A nil registry ignores updates, so
+// daemons built for one command need none.
+
+package watchdog
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type metricKind string
+
+const (
+	metricCounter   metricKind = "counter"
+	metricGauge     metricKind = "gauge"
+	metricHistogram metricKind = "histogram"
+	metricSummary   metricKind = "summary"
+)
+
+type metricID int
+
+const (
+	mPolls metricID = iota
+	mPollSeconds
+	mCacheBytes
+	mSizeTriggers
+	mSizeTriggersFolded
+	mHealthChecks
+	mHealthSeconds
+	mHealthScore
+	mHeuristicFailures
+	mHealthTriggers
+	mRepairsSkipped
+	mRepairsStarted
+	mRepairsFailed
+	mRepairSeconds
+	mExplorerDownSeconds
+	mSlowRepairs
+	mRepairsIneffective
+	mSoftRepairs
+	mStopFallbacks
+	mIncidentsOpened
+	mIncidentsUnresolved
+	mShellRescues
+	mShellRestarts
+	mShellCrashReports
+	mShellEvents
+	mRebaselines
+	mBreakerTrips
+	numMetrics
+)
+
+type metricDesc struct {
+	name  string
+	kind  metricKind
+	help  string
+	short string // heartbeat label; counters only
+}
+
+var metricDescs = [numMetrics]metricDesc{
+	mPolls:               {"iconcache_watchdog_polls_total", metricCounter, "Layer B size polls.", "polls"},
+	mPollSeconds:         {"iconcache_watchdog_poll_seconds", metricHistogram, "Duration of a size poll.", ""},
+	mCacheBytes:          {"iconcache_cache_bytes", metricGauge, "Size of the iconcache_*.db files at the last poll.", ""},
+	mSizeTriggers:        {"iconcache_size_triggers_total", metricCounter, "Size triggers: polls that found the cache over thresholds.sizeLimit, once per cooldown.", "size triggers"},
+	mSizeTriggersFolded:  {"iconcache_size_triggers_folded_total", metricCounter, "Polls over thresholds.sizeLimit folded into an earlier size trigger during its cooldown.", ""},
+	mHealthChecks:        {"iconcache_health_checks_total", metricCounter, "Health evaluations (Layers C and D).", "health checks"},
+	mHealthSeconds:       {"iconcache_health_check_seconds", metricHistogram, "Duration of a health evaluation.", ""},
+	mHealthScore:         {"iconcache_health_score", metricGauge, "Health score of the last evaluation (0-100).", ""},
+	mHeuristicFailures:   {"iconcache_heuristic_failures_total", metricCounter, "Failed heuristics and plug-in heuristics, summed over evaluations.", "heuristic failures"},
+	mHealthTriggers:      {"iconcache_health_triggers_total", metricCounter, "Evaluations that scored below health.repairBelowScore and asked for a repair.", "health triggers"},
+	mRepairsSkipped:      {"iconcache_repairs_skipped_total", metricCounter, "Repairs not started: cooldown, report-only mode or a hold.", "skipped"},
+	mRepairsStarted:      {"iconcache_repairs_started_total", metricCounter, "Repairs started, directly or through the broker or repair task.", "repairs"},
+	mRepairsFailed:       {"iconcache_repairs_failed_total", metricCounter, "Direct repairs whose script exited with an error.", "failed"},
+	mRepairSeconds:       {"iconcache_repair_seconds", metricSummary, "Run time of a direct repair script.", ""},
+	mExplorerDownSeconds: {"iconcache_explorer_down_seconds", metricSummary, "Time Explorer was down during a direct repair.", ""},
+	mSlowRepairs:         {"iconcache_slow_repairs_total", metricCounter, "Direct repairs that took longer than thresholds.slowRepair.", "slow"},
+	mRepairsIneffective:  {"iconcache_repairs_ineffective_total", metricCounter, "Size repairs that left the cache above the recovery threshold (sizeRepair.recoveryPercent).", "ineffective"},
+	mSoftRepairs:         {"iconcache_soft_repairs_total", metricCounter, "Soft repairs (ie4uinit.exe) run for a failing health check.", "soft repairs"},
+	mStopFallbacks:       {"iconcache_explorer_stop_fallbacks_total", metricCounter, "Graceful Explorer stops (explorerStop.method) that fell through to the forced stop.", "forced stops"},
+	mIncidentsOpened:     {"iconcache_incidents_opened_total", metricCounter, "Incidents opened: a trigger with no incident open (incident.go).", "incidents"},
+	mIncidentsUnresolved: {"iconcache_incidents_unresolved_total", metricCounter, "Incidents closed unresolved: repair not started or failed, size repairs suspended, or incidents.maxOpen passed.", "unresolved"},
+	mShellRescues:        {"iconcache_shell_rescues_total", metricCounter, "Times the daemon started a missing Explorer shell.", "shell rescues"},
+	mShellRestarts:       {"iconcache_shell_restarts_total", metricCounter, "Explorer shell launches not started by a repair (Winlogon or the user).", "shell restarts"},
+	mShellCrashReports:   {"iconcache_shell_crash_reports_total", metricCounter, "New Windows Error Reporting crash and hang reports for explorer.exe.", "shell crashes"},
+	mShellEvents:         {"iconcache_shell_unstable_events", metricGauge, "Explorer crashes, hangs or restarts within shellStability.window at the last health check.", ""},
+	mRebaselines:         {"iconcache_update_rebaselines_total", metricCounter, "Heuristic baselines reset after a Windows update (updateBaseline).", "re-baselines"},
+	mBreakerTrips:        {"iconcache_breaker_trips_total", metricCounter, "Times the circuit breaker stopped automatic repairs (breaker).", "breaker trips"},
+}
+
+// metricBuckets are the histogram upper bounds in seconds, from a fast
+// poll to a deep clean.
+var metricBuckets = [...]float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+const summaryWindow = 100 // observations a summary's quantiles cover
+
+// metricCell holds one metric. v is the count of a counter, histogram or
+// summary and the float64 bits of a gauge.
+type metricCell struct {
+	v       atomic.Uint64
+	sum     atomic.Uint64 // float64 bits
+	buckets [len(metricBuckets)]atomic.Uint64
+
+	mu     sync.Mutex // summaries only
+	window []float64
+}
+
+type metricsRegistry struct {
+	cells [numMetrics]metricCell
+}
+
+func newMetricsRegistry() *metricsRegistry { return &metricsRegistry{} }
+
+// add increments a counter by n.
+func (r *metricsRegistry) add(id metricID, n uint64) {
+	if r != nil {
+		r.cells[id].v.Add(n)
+	}
+}
+
+func (r *metricsRegistry) inc(id metricID) { r.add(id, 1) }
+
+func (r *metricsRegistry) set(id metricID, v float64) {
+	if r != nil {
+		r.cells[id].v.Store(math.Float64bits(v))
+	}
+}
+
+// observe records one histogram or summary value in seconds.
+func (r *metricsRegistry) observe(id metricID, seconds float64) {
+	if r == nil {
+		return
+	}
+	c := &r.cells[id]
+	if metricDescs[id].kind == metricSummary {
+		c.mu.Lock()
+		c.window = append(c.window, seconds)
+		if len(c.window) > summaryWindow {
+			c.window = c.window[len(c.window)-summaryWindow:]
+		}
+		c.mu.Unlock()
+	} else {
+		for i, le := range metricBuckets {
+			if seconds <= le {
+				c.buckets[i].Add(1)
+			}
+		}
+	}
+	for {
+		old := c.sum.Load()
+		if c.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+seconds)) {
+			break
+		}
+	}
+	c.v.Add(1)
+}
+
+// metricSample is one metric as read by snapshot. Buckets are cumulative
+// counts per metricBuckets bound.
+type metricSample struct {
+	Name    string     `json:"name"`
+	Kind    metricKind `json:"kind"`
+	Value   float64    `json:"value"` // histograms, summaries: count
+	Sum     float64    `json:"sum,omitempty"`
+	Buckets []uint64   `json:"buckets,omitempty"`
+	P50     float64    `json:"p50,omitempty"` // summaries, over the last summaryWindow
+	P95     float64    `json:"p95,omitempty"`
+}
+
+func (s metricSample) String() string {
+	switch {
+	case s.Kind == metricSummary && s.Value > 0:
+		return fmt.Sprintf("%.0f, p50 %.2fs, p95 %.2fs", s.Value, s.P50, s.P95)
+	case s.Kind == metricHistogram && s.Value > 0:
+		return fmt.Sprintf("%.0f, avg %.2fs", s.Value, s.Sum/s.Value)
+	}
+	return strconv.FormatFloat(s.Value, 'f', -1, 64)
+}
+
+// snapshot reads every metric. Each value is read atomically; the set as a
+// whole is not a single point in time.
+func (r *metricsRegistry) snapshot() []metricSample {
+	if r == nil {
+		return nil
+	}
+	out := make([]metricSample, numMetrics)
+	for id, desc := range metricDescs {
+		c := &r.cells[id]
+		s := metricSample{Name: desc.name, Kind: desc.kind}
+		switch desc.kind {
+		case metricGauge:
+			s.Value = math.Float64frombits(c.v.Load())
+		case metricHistogram:
+			s.Sum = math.Float64frombits(c.sum.Load())
+			s.Buckets = make([]uint64, len(metricBuckets))
+			for i := range c.buckets {
+				s.Buckets[i] = c.buckets[i].Load()
+			}
+			s.Value = float64(c.v.Load())
+		case metricSummary:
+			c.mu.Lock()
+			window := append([]float64(nil), c.window...)
+			c.mu.Unlock()
+			s.Sum = math.Float64frombits(c.sum.Load())
+			s.Value = float64(c.v.Load())
+			s.P50, s.P95 = percentile(window, 0.5), percentile(window, 0.95)
+		default:
+			s.Value = float64(c.v.Load())
+		}
+		out[id] = s
+	}
+	return out
+}
+
+// summary is the heartbeat's view: the counters, by short label.
+func (r *metricsRegistry) summary() string {
+	var parts []string
+	for id, s := range r.snapshot() {
+		if short := metricDescs[id].short; short != "" {
+			parts = append(parts, fmt.Sprintf("%s %s", short, s))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writePrometheus writes the registry in the Prometheus text format.
+func (r *metricsRegistry) writePrometheus(w io.Writer) error {
+	var b strings.Builder
+	for id, s := range r.snapshot() {
+		desc := metricDescs[id]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", s.Name, desc.help, s.Name, s.Kind)
+		switch s.Kind {
+		case metricSummary:
+			fmt.Fprintf(&b, "%s{quantile=\"0.5\"} %s\n%s{quantile=\"0.95\"} %s\n", s.Name, strconv.FormatFloat(s.P50, 'g', -1, 64), s.Name, strconv.FormatFloat(s.P95, 'g', -1, 64))
+			fmt.Fprintf(&b, "%s_sum %s\n%s_count %.0f\n", s.Name, strconv.FormatFloat(s.Sum, 'g', -1, 64), s.Name, s.Value)
+			continue
+		case metricCounter, metricGauge:
+			fmt.Fprintf(&b, "%s %s\n", s.Name, strconv.FormatFloat(s.Value, 'g', -1, 64))
+			continue
+		}
+		for i, le := range metricBuckets {
+			fmt.Fprintf(&b, "%s_bucket{le=\"%s\"} %d\n", s.Name, strconv.FormatFloat(le, 'g', -1, 64), s.Buckets[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket{le=\"+Inf\"} %.0f\n", s.Name, s.Value)
+		fmt.Fprintf(&b, "%s_sum %s\n%s_count %.0f\n", s.Name, strconv.FormatFloat(s.Sum, 'g', -1, 64), s.Name, s.Value)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// percentile is the nearest-rank q-quantile of vals, or 0 without any.
+func percentile(vals []float64, q float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
@@ -0,0 +1,279 @@
+// multisession.go
+// RDS / AVD multi-session mode. One machine-wide daemon instance (run as
+// LocalSystem so it can read every profile and stop any session's Explorer)
+// enumerates the active user sessions, monitors each user's cache with its
+// own cooldown, and caps the number of repairs running at once host-wide —
+// a session host restarting twenty shells simultaneously is worse than the
+// bloat being fixed.
+//
+// Explorer in another session cannot be started from here; the repair script
+// only stops it and Winlogon's AutoRestartShell brings the shell back.
+//
+// Sessions are polled and health-checked on a bounded pool of
+// multiSession.pollWorkers goroutines, so one slow profile (a roaming or
+// container-mounted disk) does not hold up detection in the others.
+// Sessions of users who opted out are skipped (optout.go).
+
+package watchdog
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type multiSessionOptions struct {
+	Enabled              bool `json:"enabled"`
+	MaxConcurrentRepairs int  `json:"maxConcurrentRepairs"`
+	PollWorkers          int  `json:"pollWorkers"` // sessions scanned in parallel
+}
+
+type sessionInfo struct {
+	ID         uint32
+	User       string
+	Domain     string
+	ProfileDir string
+}
+
+type sessionStatus struct {
+	ID          uint32    `json:"id"`
+	User        string    `json:"user"`
+	ProfileType string    `json:"profileType"`
+	CacheDir    string    `json:"cacheDir"`
+	CacheSizeMB float64   `json:"cacheSizeMB"`
+	LastCheck   time.Time `json:"lastCheck"`
+	Healthy     bool      `json:"healthy"`
+	Score       int       `json:"score"`
+	LastRepair  time.Time `json:"lastRepair"`
+	ScanMs      int64     `json:"scanMs"` // duration of the last size poll
+}
+
+// sessionDaemon derives a per-session monitor sharing config, logs and the
+// host-wide repair throttle. Per-session cooldown is kept in memory only.
+func (d *daemon) sessionDaemon(s sessionInfo) *daemon {
+	cfg := d.cfg
+	thumbnails := applyTargets(&cfg, nil, s.Domain, s.User)
+	return &daemon{
+		cfg:          cfg,
+		configFile:   d.configFile,
+		cfgDigest:    d.cfgDigest,
+		rootDir:      d.rootDir,
+		dataDir:      d.dataDir,
+		cacheDir:     filepath.Join(s.ProfileDir, "AppData", "Local", "Microsoft", "Windows", "Explorer"),
+		repairScript: d.repairScript,
+		logDir:       d.logDir,
+		watchLog:     d.watchLog,
+		healthLog:    d.healthLog,
+		unifiedLog:   d.unifiedLog,
+		progress:     d.progress,
+		logs:         d.logs,
+		metrics:      d.metrics,
+		otel:         d.otel,
+		logPrefix:    fmt.Sprintf("[S%d %s] ", s.ID, s.User),
+		session:      &s,
+		throttle:     d.throttle,
+		started:      d.clock.Now(),
+		clock:        d.clock,
+		caps:         d.caps,
+		profile:      detectSessionProfile(s),
+		thumbnails:   thumbnails,
+	}
+}
+
+func (d *daemon) runMultiSession() {
+	max := d.cfg.MultiSession.MaxConcurrentRepairs
+	if max < 1 {
+		max = 1
+	}
+	d.throttle = make(chan struct{}, max)
+
+	d.watchLog_("INFO", "=== icon-cache-watchdog started (multi-session mode) ===")
+	t := d.cfg.Thresholds
+	d.watchLog_("INFO", fmt.Sprintf("Threshold: %s | Cooldown: %s | Max concurrent repairs: %d | Poll workers: %d",
+		t.SizeLimit, t.Cooldown, max, d.cfg.MultiSession.PollWorkers))
+
+	sessions := map[uint32]*daemon{}
+	d.refreshSessions(sessions)
+	d.writeSessionStatus(sessions)
+
+	ticker := d.clock.NewTicker(t.PollEvery.D())
+	defer ticker.Stop()
+	health, every := d.healthTicker()
+	defer health.Stop()
+	heartbeat := d.clock.NewTicker(t.HeartbeatEvery.D())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			d.refreshSessions(sessions)
+			d.forEachSession(sortedSessions(sessions), func(sd *daemon) {
+				start := time.Now()
+				sd.checkSize()
+				sd.mu.Lock()
+				sd.lastScan = time.Since(start)
+				sd.mu.Unlock()
+			})
+			d.writeSessionStatus(sessions)
+
+		case <-health.C():
+			d.checkConfigTamper()
+			d.forEachSession(sortedSessions(sessions), func(sd *daemon) {
+				sd.healthLog_("INFO", fmt.Sprintf("--- Health check running (periodic, %s) ---", every))
+				sd.checkHealth()
+			})
+			d.writeSessionStatus(sessions)
+			d.publishWMI()
+
+		case <-heartbeat.C():
+			d.heartbeat(fmt.Sprintf("Watchdog alive. Monitoring %d session(s). Self: %s. Metrics: %s", len(sessions), d.selfUsage(), d.metrics.summary()))
+
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// refreshSessions adds newly logged-on sessions (running their logon health
+// check immediately, Layer C) and drops sessions that have ended.
+func (d *daemon) refreshSessions(sessions map[uint32]*daemon) {
+	active, err := enumerateSessions()
+	if err != nil {
+		d.watchLog_("ERROR", fmt.Sprintf("Session enumeration failed: %v", err))
+		return
+	}
+	d.syncSessions(sessions, active)
+}
+
+// syncSessions brings sessions in line with the active ones. Sessions whose
+// user opted out (optout.go) are not attached, or detached when attached.
+func (d *daemon) syncSessions(sessions map[uint32]*daemon, active []sessionInfo) {
+	if d.optedOut == nil {
+		d.optedOut = map[uint32]string{}
+	}
+	seen := map[uint32]bool{}
+	var attached []*daemon
+	for _, s := range active {
+		seen[s.ID] = true
+		cur, ok := sessions[s.ID]
+		if ok && cur.session.User != s.User {
+			ok = false
+		}
+		sd := cur
+		if !ok {
+			sd = d.sessionDaemon(s)
+		}
+		if why := sd.optOutReason(); why != "" {
+			delete(sessions, s.ID)
+			if ok {
+				sd.watchLog_("INFO", fmt.Sprintf("User opted out (%s). Monitoring stopped.", why))
+			} else if d.optedOut[s.ID] != s.User {
+				sd.watchLog_("INFO", fmt.Sprintf("User opted out (%s). Session not monitored.", why))
+			}
+			d.optedOut[s.ID] = s.User
+			continue
+		}
+		delete(d.optedOut, s.ID)
+		if ok {
+			continue
+		}
+		sd.resolveCachePath()
+		sessions[s.ID] = sd
+		sd.watchLog_("INFO", fmt.Sprintf("Session attached. Watching: %s (profile: %s)", sd.cacheDir, sd.profile))
+		attached = append(attached, sd)
+	}
+	d.forEachSession(attached, func(sd *daemon) {
+		sd.healthLog_("INFO", "--- Health check running (session logon) ---")
+		sd.checkHealth()
+	})
+	for id, sd := range sessions {
+		if !seen[id] {
+			sd.watchLog_("INFO", "Session ended. Monitoring stopped.")
+			delete(sessions, id)
+		}
+	}
+	for id := range d.optedOut {
+		if !seen[id] {
+			delete(d.optedOut, id)
+		}
+	}
+}
+
+func (d *daemon) writeSessionStatus(sessions map[uint32]*daemon) {
+	var out []sessionStatus
+	for _, id := range sortedSessionIDs(sessions) {
+		sd := sessions[id]
+		sd.mu.Lock()
+		st := sessionStatus{
+			ID:          id,
+			User:        sd.session.User,
+			ProfileType: sd.profile.Type,
+			CacheDir:    sd.cacheDir,
+			LastCheck:   sd.lastHealthCheck,
+			Healthy:     sd.lastHealthy,
+			Score:       sd.lastScore,
+			LastRepair:  sd.lastRepair,
+			ScanMs:      sd.lastScan.Milliseconds(),
+		}
+		n := len(sd.samples)
+		if n > 0 {
+			st.CacheSizeMB = sd.samples[n-1].SizeMB // from this poll
+		}
+		sd.mu.Unlock()
+		if n == 0 {
+			st.CacheSizeMB = sd.getCacheSizeMB()
+		}
+		out = append(out, st)
+	}
+	d.mu.Lock()
+	d.sessionStatuses = out
+	d.mu.Unlock()
+	d.writeStatus()
+}
+
+// forEachSession runs fn for each session on at most
+// multiSession.pollWorkers goroutines and returns when all are done.
+func (d *daemon) forEachSession(sessions []*daemon, fn func(sd *daemon)) {
+	workers := min(d.cfg.MultiSession.PollWorkers, len(sessions))
+	if workers <= 1 {
+		for _, sd := range sessions {
+			fn(sd)
+		}
+		return
+	}
+	work := make(chan *daemon)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sd := range work {
+				fn(sd)
+			}
+		}()
+	}
+	for _, sd := range sessions {
+		work <- sd
+	}
+	close(work)
+	wg.Wait()
+}
+
+func sortedSessions(sessions map[uint32]*daemon) []*daemon {
+	var out []*daemon
+	for _, id := range sortedSessionIDs(sessions) {
+		out = append(out, sessions[id])
+	}
+	return out
+}
+
+func sortedSessionIDs(sessions map[uint32]*daemon) []uint32 {
+	ids := make([]uint32, 0, len(sessions))
+	for id := range sessions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,206 @@
+// network.go
+// Network-location awareness. Explorer takes a shortcut's icon from its
+// target or its icon location; when that file is on a share that does not
+// answer, the rebuilt cache gets the generic icon and keeps it until the
+// next rebuild. Rebuilding while a laptop is off the VPN therefore trades a
+// bloated cache for a cache of white icons.
+//
+// Before a non-critical repair or a deep clean the daemon reads the user's
+// shortcuts (shortcuts.go), collects the network shares and mapped drives
+// their targets and icons live on, and probes each of them. While at least
+// network.minShortcuts shortcuts depend on shares that do not answer within
+// network.probeTimeout, the repair is held like during quiet hours and
+// replayed once they do. Critical repairs are not held: their icons are
+// already broken. A share that stays unreachable for network.maxHold no
+// longer holds repairs, so a decommissioned server cannot block them for
+// good. The shortcut list is re-read every shortcutRescan and the shares
+// probed at most every networkRecheck.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	shortcutRescan = time.Hour
+	networkRecheck = time.Minute
+	networkListMax = 3 // shares named in the hold reason
+)
+
+type networkOptions struct {
+	// HoldWhileOffline defers non-critical repairs while shortcut targets
+	// are on unreachable shares. Defaults to true.
+	HoldWhileOffline bool `json:"holdWhileOffline"`
+
+	// MinShortcuts is how many shortcuts must be affected to hold.
+	MinShortcuts int `json:"minShortcuts"`
+
+	// ProbeTimeout bounds each share probe.
+	ProbeTimeout duration `json:"probeTimeout"`
+
+	// MaxHold is how long unreachable shares may hold repairs.
+	MaxHold duration `json:"maxHold"`
+}
+
+func defaultNetworkOptions() networkOptions {
+	return networkOptions{
+		HoldWhileOffline: true,
+		MinShortcuts:     3,
+		ProbeTimeout:     duration(3 * time.Second),
+		MaxHold:          duration(24 * time.Hour),
+	}
+}
+
+// networkState caches the shortcut scan and the probe results.
+type networkState struct {
+	mu        sync.Mutex
+	scanned   time.Time
+	links     [][]string // network roots each network-dependent shortcut uses
+	checked   time.Time
+	down      []string // unreachable roots
+	affected  int      // shortcuts using at least one of them
+	heldSince time.Time
+	gaveUp    bool
+}
+
+// networkRoot returns the share (\\server\share) or mapped drive (Z:\) that
+// path is on, or "" for local paths.
+func networkRoot(path string) string {
+	path = expandPercentVars(path)
+	if strings.HasPrefix(path, `\\`) && !strings.HasPrefix(path, `\\?\`) && !strings.HasPrefix(path, `\\.\`) {
+		parts := strings.SplitN(path[2:], `\`, 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return ""
+		}
+		return strings.ToLower(`\\` + parts[0] + `\` + parts[1])
+	}
+	if len(path) >= 2 && path[1] == ':' {
+		root := strings.ToUpper(path[:1]) + `:\`
+		if isRemoteDrive(root) {
+			return root
+		}
+	}
+	return ""
+}
+
+// shortcutRoots lists the network roots s depends on.
+func shortcutRoots(s shortcut) []string {
+	seen := map[string]bool{}
+	var roots []string
+	for _, r := range []string{networkRoot(s.Share), networkRoot(s.Target), networkRoot(s.Icon)} {
+		if r != "" && !seen[r] {
+			seen[r] = true
+			roots = append(roots, r)
+		}
+	}
+	return roots
+}
+
+// probeRoot reports whether root answers within timeout. A probe that hangs
+// is abandoned; its goroutine ends when the file system gives up.
+func probeRoot(root string, timeout time.Duration) bool {
+	ch := make(chan bool, 1)
+	go func() {
+		_, err := os.Stat(strings.TrimRight(root, `\`) + `\`)
+		ch <- err == nil
+	}()
+	select {
+	case ok := <-ch:
+		return ok
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// refresh re-reads the shortcuts and re-probes the shares when due.
+func (n *networkState) refresh(now time.Time, timeout time.Duration) {
+	if n.links == nil || now.Sub(n.scanned) >= shortcutRescan {
+		n.links = [][]string{}
+		for _, s := range scanShortcuts(shortcutDirs()) {
+			if roots := shortcutRoots(s); len(roots) > 0 {
+				n.links = append(n.links, roots)
+			}
+		}
+		n.scanned = now
+		n.checked = time.Time{}
+	}
+	if !n.checked.IsZero() && now.Sub(n.checked) < networkRecheck {
+		return
+	}
+	n.checked = now
+
+	roots := map[string]bool{}
+	for _, l := range n.links {
+		for _, r := range l {
+			roots[r] = true
+		}
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	down := map[string]bool{}
+	for r := range roots {
+		wg.Add(1)
+		go func(r string) {
+			defer wg.Done()
+			if !probeRoot(r, timeout) {
+				mu.Lock()
+				down[r] = true
+				mu.Unlock()
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	n.down, n.affected = nil, 0
+	for r := range down {
+		n.down = append(n.down, r)
+	}
+	sort.Strings(n.down)
+	for _, l := range n.links {
+		for _, r := range l {
+			if down[r] {
+				n.affected++
+				break
+			}
+		}
+	}
+}
+
+// networkReason returns why repairs must wait for network shares, or "".
+func (d *daemon) networkReason() string {
+	o := d.cfg.Network
+	if !o.HoldWhileOffline || d.session != nil || d.sim != nil {
+		return ""
+	}
+	n := &d.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := d.clock.Now()
+	n.refresh(now, o.ProbeTimeout.D())
+	if n.affected < o.MinShortcuts {
+		n.heldSince, n.gaveUp = time.Time{}, false
+		return ""
+	}
+	if n.heldSince.IsZero() {
+		n.heldSince = now
+	}
+	if now.Sub(n.heldSince) >= o.MaxHold.D() {
+		if !n.gaveUp {
+			n.gaveUp = true
+			d.repairLog_("WARN", fmt.Sprintf("Network shares unreachable for %s (%s). No longer holding repairs for them.", o.MaxHold, strings.Join(n.down, ", ")))
+		}
+		return ""
+	}
+	shares := n.down
+	more := ""
+	if len(shares) > networkListMax {
+		shares, more = shares[:networkListMax], fmt.Sprintf(" and %d more", len(n.down)-networkListMax)
+	}
+	return fmt.Sprintf("%d shortcut(s) on unreachable network shares (%s%s)", n.affected, strings.Join(shares, ", "), more)
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+// network_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func isRemoteDrive(root string) bool { return false }
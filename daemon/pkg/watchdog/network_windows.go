@@ -0,0 +1,22 @@
+// network_windows.go
+// kernel32!GetDriveTypeW, to tell mapped network drives from local ones.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const driveRemote = 4 // DRIVE_REMOTE
+
+var procGetDriveTypeW = modKernel32.NewProc("GetDriveTypeW")
+
+func isRemoteDrive(root string) bool {
+	p, err := syscall.UTF16PtrFromString(root)
+	if err != nil {
+		return false
+	}
+	t, _, _ := procGetDriveTypeW.Call(uintptr(unsafe.Pointer(p)))
+	return t == driveRemote
+}
@@ -0,0 +1,144 @@
+// optout.go
+// Per-user opt-out on shared machines. Some accounts must be left alone
+// even when a machine-wide instance (multi-session mode) or a logon task
+// for every user is installed: a kiosk account with a locked shell, a
+// service account, a user who simply does not want it. Accounts can be
+// excluded two ways:
+//   - by the admin: optOut.users lists accounts, comma-separated, as
+//     "user" or "DOMAIN\user" with * and ? wildcards
+//   - by the user: the marker file %LOCALAPPDATA%\IconCacheWatchdog\opt-out
+//     (written by the opt-out command), or the DWORD OptOut=1 under
+//     HKCU\Software\IconCacheWatchdog (or the matching Policies key, for
+//     Group Policy Preferences). Honoured while optOut.allowUsers is set.
+//
+// An opted-out session is not attached in multi-session mode (and detached
+// when the user opts out later); a single-user daemon logs the opt-out and
+// does not start monitoring, and the repair command skips the repair.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	optOutDir       = "IconCacheWatchdog" // under %LOCALAPPDATA%
+	optOutFile      = "opt-out"
+	optOutKey       = `Software\IconCacheWatchdog`
+	optOutPolicyKey = `Software\Policies\IconCacheWatchdog`
+	optOutValue     = "OptOut"
+)
+
+type optOutOptions struct {
+	Users      string `json:"users"`      // accounts excluded by the admin
+	AllowUsers bool   `json:"allowUsers"` // honour the users' own opt-out
+}
+
+// optOutPatterns splits optOut.users into lower-case patterns, with the
+// domain separator as "/" so path.Match does not read it as an escape.
+func optOutPatterns(list string) []string {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			patterns = append(patterns, strings.ReplaceAll(p, `\`, "/"))
+		}
+	}
+	return patterns
+}
+
+// excludedUser reports whether domain\user matches one of the patterns;
+// a pattern without a domain matches the user in any domain.
+func excludedUser(patterns []string, domain, user string) bool {
+	user = strings.ToLower(user)
+	full := strings.ToLower(domain) + "/" + user
+	for _, p := range patterns {
+		name := user
+		if strings.Contains(p, "/") {
+			name = full
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// optOutMarker is the user's marker file under their local app data.
+func optOutMarker(localAppData string) string {
+	return filepath.Join(localAppData, optOutDir, optOutFile)
+}
+
+// optOutReason returns why the monitored user is excluded, or "".
+func (d *daemon) optOutReason() string {
+	o := d.cfg.OptOut
+	domain, user := os.Getenv("USERDOMAIN"), os.Getenv("USERNAME")
+	localAppData := os.Getenv("LOCALAPPDATA")
+	if d.session != nil {
+		domain, user = d.session.Domain, d.session.User
+		localAppData = filepath.Join(d.session.ProfileDir, "AppData", "Local")
+	}
+	if user != "" && excludedUser(optOutPatterns(o.Users), domain, user) {
+		return "listed in optOut.users"
+	}
+	if !o.AllowUsers {
+		return ""
+	}
+	if localAppData != "" {
+		if _, err := os.Stat(optOutMarker(localAppData)); err == nil {
+			return "opt-out file " + optOutMarker(localAppData)
+		}
+	}
+	session := -1
+	if d.session != nil {
+		session = int(d.session.ID)
+	}
+	if registryOptOut(session) {
+		return fmt.Sprintf(`%s=1 in the user's registry`, optOutValue)
+	}
+	return ""
+}
+
+// cmdOptOut writes or removes the current user's opt-out marker.
+func cmdOptOut(d *daemon, args []string) int {
+	fs := newFlagSet("opt-out")
+	undo := fs.Bool("undo", false, "opt back in")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	localAppData := os.Getenv("LOCALAPPDATA")
+	if localAppData == "" {
+		fmt.Fprintln(os.Stderr, "LOCALAPPDATA is not set.")
+		return 1
+	}
+	marker := optOutMarker(localAppData)
+	if *undo {
+		if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Cannot opt back in: %v\n", err)
+			return 1
+		}
+		if why := d.optOutReason(); why != "" {
+			fmt.Printf("Opt-out file removed, but this account is still excluded (%s).\n", why)
+			return 0
+		}
+		fmt.Println("Opted back in. Monitoring resumes at the next logon or session poll.")
+		return 0
+	}
+	if !d.cfg.OptOut.AllowUsers {
+		fmt.Fprintln(os.Stderr, "Opting out is disabled by the administrator (optOut.allowUsers).")
+		return 1
+	}
+	err := os.MkdirAll(filepath.Dir(marker), 0755)
+	if err == nil {
+		err = os.WriteFile(marker, nil, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot opt out: %v\n", err)
+		return 1
+	}
+	fmt.Println("Opted out. This account is no longer monitored or repaired; run `opt-out -undo` to opt back in.")
+	return 0
+}
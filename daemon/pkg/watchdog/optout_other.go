@@ -0,0 +1,8 @@
+//go:build !windows
+
+// optout_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func registryOptOut(session int) bool { return false }
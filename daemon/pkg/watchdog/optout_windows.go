@@ -0,0 +1,49 @@
+// optout_windows.go
+// The OptOut registry value in the user's hive: HKEY_CURRENT_USER for our
+// own user, HKEY_USERS\<SID> (loaded while the user is logged on) for a
+// session's user, whose SID comes from their token.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// registryOptOut reports whether OptOut=1 is set for the user of session,
+// or for the current user when session is -1.
+func registryOptOut(session int) bool {
+	root, prefix := syscall.Handle(syscall.HKEY_CURRENT_USER), ""
+	if session >= 0 {
+		sid := sessionUserSID(uint32(session))
+		if sid == "" {
+			return false
+		}
+		root, prefix = syscall.HKEY_USERS, sid+`\`
+	}
+	for _, key := range []string{optOutPolicyKey, optOutKey} {
+		data, typ, err := readRegistryValueFrom(root, prefix+key, optOutValue)
+		if v, ok := registryDWORD(data, typ); err == nil && ok && v != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionUserSID returns the string SID of the session's user, or "".
+func sessionUserSID(session uint32) string {
+	var tok syscall.Token
+	if r, _, _ := procWTSQueryUserToken.Call(uintptr(session), uintptr(unsafe.Pointer(&tok))); r == 0 {
+		return ""
+	}
+	defer tok.Close()
+	u, err := tok.GetTokenUser()
+	if err != nil {
+		return ""
+	}
+	sid, err := u.User.Sid.String()
+	if err != nil {
+		return ""
+	}
+	return sid
+}
@@ -0,0 +1,452 @@
+// otel.go
+// OpenTelemetry export. For organisations that collect telemetry through
+// an OpenTelemetry Collector rather than by scraping Prometheus or reading
+// ETW, the daemon pushes to an OTLP/HTTP endpoint (otel.endpoint, e.g. the
+// collector's http://collector:4318) using the JSON encoding:
+//
+//   - metrics: every otel.interval, the registry of metrics.go to
+//     <endpoint>/v1/metrics, counters as cumulative monotonic sums, gauges
+//     as gauges, histograms with the metricBuckets bounds and summaries with
+//     their p50 and p95;
+//   - traces: one trace per direct repair to <endpoint>/v1/traces, a
+//     "repair" span from launch to exit with one child span per progress
+//     phase (explorer-stopping, files-deleting, ...), carrying the reason,
+//     the outcome, the open incident (incident.go) and, on multi-session
+//     hosts, the session.
+//
+// otel.headers adds request headers in the OTEL_EXPORTER_OTLP_HEADERS form
+// ("api-key=secret,tenant=ops"), for collectors behind authentication. The
+// service is named by otel.serviceName; host.name and user.name come from
+// the same identity as the reports. Spans are sent in the background in
+// order, at most otelQueue waiting; a failing endpoint is logged once per
+// signal until it works again. Repairs handed to the broker or the repair
+// task are not traced. Trace simulation exports nothing.
+
+package watchdog
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type otelOptions struct {
+	Enabled     bool     `json:"enabled"`
+	Endpoint    string   `json:"endpoint"`    // OTLP/HTTP base URL; /v1/traces and /v1/metrics are appended
+	Headers     string   `json:"headers"`     // "name=value,name=value"
+	ServiceName string   `json:"serviceName"` // service.name resource attribute
+	Traces      bool     `json:"traces"`
+	Metrics     bool     `json:"metrics"`
+	Interval    duration `json:"interval"` // metrics export interval
+	Timeout     duration `json:"timeout"`  // request timeout
+}
+
+func defaultOTelOptions() otelOptions {
+	return otelOptions{
+		Endpoint:    "http://localhost:4318",
+		ServiceName: "icon-cache-watchdog",
+		Traces:      true,
+		Metrics:     true,
+		Interval:    duration(time.Minute),
+		Timeout:     duration(10 * time.Second),
+	}
+}
+
+// otelQueue is the number of traces that may wait for delivery.
+const otelQueue = 16
+
+// parseOTelHeaders splits otel.headers into name/value pairs.
+func parseOTelHeaders(s string) (http.Header, error) {
+	h := http.Header{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return nil, fmt.Errorf("%q is not name=value", strings.TrimSpace(pair))
+		}
+		h.Set(name, strings.TrimSpace(value))
+	}
+	return h, nil
+}
+
+type otelExporter struct {
+	d              *daemon
+	headers        http.Header
+	resource       otlpResource
+	spans          chan []byte
+	tracesFailing  atomic.Bool
+	metricsFailing atomic.Bool
+}
+
+// startOTel sets up the exporter and, with otel.metrics, the metrics loop.
+func (d *daemon) startOTel() {
+	o := d.cfg.OTel
+	if !o.Enabled || d.sim != nil {
+		return
+	}
+	headers, _ := parseOTelHeaders(o.Headers) // checked by checkRanges
+	computer, user := reportIdentity()
+	e := &otelExporter{d: d, headers: headers, resource: otlpResource{Attributes: []otlpAttr{
+		stringAttr("service.name", o.ServiceName),
+		stringAttr("host.name", computer),
+		stringAttr("user.name", user),
+	}}}
+	if o.Traces {
+		e.spans = make(chan []byte, otelQueue)
+		go e.deliverSpans()
+	}
+	d.otel = e
+	if o.Metrics {
+		go e.runMetrics()
+	}
+	d.watchLog_("INFO", fmt.Sprintf("Exporting %s to %s via OTLP.", otelSignals(o), o.Endpoint))
+}
+
+func otelSignals(o otelOptions) string {
+	switch {
+	case o.Traces && o.Metrics:
+		return "traces and metrics"
+	case o.Traces:
+		return "traces"
+	case o.Metrics:
+		return "metrics"
+	}
+	return "nothing"
+}
+
+// runMetrics exports the metrics every otel.interval until the daemon
+// stops.
+func (e *otelExporter) runMetrics() {
+	ticker := e.d.clock.NewTicker(e.d.cfg.OTel.Interval.D())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			e.exportMetrics()
+		case <-e.d.done:
+			return
+		}
+	}
+}
+
+func (e *otelExporter) exportMetrics() {
+	start, now := otlpTime(e.d.started), otlpTime(e.d.clock.Now())
+	var metrics []otlpMetric
+	for id, s := range e.d.metrics.snapshot() {
+		m := otlpMetric{Name: s.Name, Description: metricDescs[id].help, Unit: otlpUnit(s.Name)}
+		switch s.Kind {
+		case metricCounter:
+			m.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true, DataPoints: []otlpNumberPoint{
+				{StartTimeUnixNano: start, TimeUnixNano: now, AsInt: strconv.FormatUint(uint64(s.Value), 10)}}}
+		case metricGauge:
+			v := s.Value
+			m.Gauge = &otlpGauge{DataPoints: []otlpNumberPoint{{TimeUnixNano: now, AsDouble: &v}}}
+		case metricHistogram:
+			counts := make([]string, len(metricBuckets)+1)
+			var below uint64
+			for i, cum := range s.Buckets {
+				counts[i] = strconv.FormatUint(cum-below, 10)
+				below = cum
+			}
+			counts[len(metricBuckets)] = strconv.FormatUint(uint64(s.Value)-below, 10)
+			m.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative, DataPoints: []otlpHistogramPoint{{
+				StartTimeUnixNano: start, TimeUnixNano: now, Count: strconv.FormatUint(uint64(s.Value), 10), Sum: s.Sum,
+				BucketCounts: counts, ExplicitBounds: metricBuckets[:],
+			}}}
+		case metricSummary:
+			m.Summary = &otlpSummary{DataPoints: []otlpSummaryPoint{{
+				StartTimeUnixNano: start, TimeUnixNano: now, Count: strconv.FormatUint(uint64(s.Value), 10), Sum: s.Sum,
+				QuantileValues: []otlpQuantile{{Quantile: 0.5, Value: s.P50}, {Quantile: 0.95, Value: s.P95}},
+			}}}
+		}
+		metrics = append(metrics, m)
+	}
+	raw, _ := json.Marshal(otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: e.resource, ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScopeInfo, Metrics: metrics}},
+	}}})
+	e.d.sinkResult("OTLP metrics", &e.metricsFailing, e.post("/v1/metrics", raw))
+}
+
+// otlpUnit derives the UCUM unit from the metric name's suffix.
+func otlpUnit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return "s"
+	case strings.HasSuffix(name, "_bytes"):
+		return "By"
+	}
+	return "1"
+}
+
+// repairTrace collects the spans of one direct repair.
+type repairTrace struct {
+	e       *otelExporter
+	traceID string
+	root    otlpSpan
+	phase   *otlpSpan // the phase in progress
+	spans   []otlpSpan
+}
+
+// traceRepair starts the trace of a direct repair; nil when traces are not
+// exported.
+func (d *daemon) traceRepair(reason string) *repairTrace {
+	e := d.otel
+	if e == nil || e.spans == nil {
+		return nil
+	}
+	t := &repairTrace{e: e, traceID: randomHex(16)}
+	t.root = otlpSpan{TraceID: t.traceID, SpanID: randomHex(8), Name: "repair", Kind: otlpSpanInternal,
+		StartTimeUnixNano: otlpTime(time.Now()), Attributes: []otlpAttr{stringAttr("repair.reason", reason)}}
+	if id := d.incidents.id(); id != "" {
+		t.root.Attributes = append(t.root.Attributes, stringAttr("incident.id", id))
+	}
+	if s := d.session; s != nil {
+		t.root.Attributes = append(t.root.Attributes, intAttr("session.id", int64(s.ID)), stringAttr("session.user", s.User))
+	}
+	return t
+}
+
+// phaseStarted ends the running phase span and starts one for p.
+func (t *repairTrace) phaseStarted(p string) {
+	if t == nil || t.phase != nil && t.phase.Name == p {
+		return
+	}
+	now := otlpTime(time.Now())
+	t.endPhase(now)
+	if (controlEvent{Phase: p}).finished() {
+		return
+	}
+	t.phase = &otlpSpan{TraceID: t.traceID, SpanID: randomHex(8), ParentSpanID: t.root.SpanID, Name: p,
+		Kind: otlpSpanInternal, StartTimeUnixNano: now}
+}
+
+func (t *repairTrace) endPhase(now string) {
+	if t.phase != nil {
+		t.phase.EndTimeUnixNano = now
+		t.spans = append(t.spans, *t.phase)
+		t.phase = nil
+	}
+}
+
+// end closes the trace with the script's outcome and queues it.
+func (t *repairTrace) end(err error) {
+	if t == nil {
+		return
+	}
+	now := otlpTime(time.Now())
+	t.endPhase(now)
+	t.root.EndTimeUnixNano = now
+	t.root.Status = otlpStatus{Code: otlpStatusOK}
+	outcome := phaseComplete
+	if err != nil {
+		t.root.Status = otlpStatus{Code: otlpStatusError, Message: err.Error()}
+		outcome = phaseFailed
+	}
+	t.root.Attributes = append(t.root.Attributes, stringAttr("repair.outcome", outcome))
+	raw, _ := json.Marshal(otlpTracesRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: t.e.resource, ScopeSpans: []otlpScopeSpans{{Scope: otlpScopeInfo, Spans: append([]otlpSpan{t.root}, t.spans...)}},
+	}}})
+	select {
+	case t.e.spans <- raw:
+	default:
+		t.e.d.watchLog_("WARN", fmt.Sprintf("OTLP trace export is %d traces behind; dropping the trace of this repair.", otelQueue))
+	}
+}
+
+// deliverSpans posts the queued traces in order until the daemon stops.
+func (e *otelExporter) deliverSpans() {
+	for {
+		select {
+		case raw := <-e.spans:
+			e.d.sinkResult("OTLP traces", &e.tracesFailing, e.post("/v1/traces", raw))
+		case <-e.d.done:
+			return
+		}
+	}
+}
+
+// post sends one OTLP/HTTP JSON request; any status other than 2xx is a
+// failure.
+func (e *otelExporter) post(path string, body []byte) error {
+	o := e.d.cfg.OTel
+	url := strings.TrimSuffix(o.Endpoint, "/") + path
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Timeout: o.Timeout.D()}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// otlpTime is t in the OTLP JSON form: Unix nanoseconds as a string.
+func otlpTime(t time.Time) string { return strconv.FormatInt(t.UnixNano(), 10) }
+
+// The OTLP/HTTP JSON messages, as far as the daemon uses them
+// (opentelemetry-proto, JSON mapping: 64-bit integers as strings, IDs as
+// hex).
+
+const (
+	otlpCumulative   = 2 // AGGREGATION_TEMPORALITY_CUMULATIVE
+	otlpSpanInternal = 1 // SPAN_KIND_INTERNAL
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+var otlpScopeInfo = otlpScope{Name: "icon-cache-watchdog", Version: "1"}
+
+type otlpAttr struct {
+	Key   string        `json:"key"`
+	Value otlpAttrValue `json:"value"`
+}
+
+type otlpAttrValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func stringAttr(key, v string) otlpAttr {
+	return otlpAttr{Key: key, Value: otlpAttrValue{StringValue: &v}}
+}
+
+func intAttr(key string, v int64) otlpAttr {
+	s := strconv.FormatInt(v, 10)
+	return otlpAttr{Key: key, Value: otlpAttrValue{IntValue: &s}}
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpTracesRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Unit        string         `json:"unit"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpNumberPoint struct {
+	StartTimeUnixNano string   `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string   `json:"timeUnixNano"`
+	AsInt             string   `json:"asInt,omitempty"`
+	AsDouble          *float64 `json:"asDouble,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpHistogramPoint struct {
+	StartTimeUnixNano string    `json:"startTimeUnixNano"`
+	TimeUnixNano      string    `json:"timeUnixNano"`
+	Count             string    `json:"count"`
+	Sum               float64   `json:"sum"`
+	BucketCounts      []string  `json:"bucketCounts"`
+	ExplicitBounds    []float64 `json:"explicitBounds"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryPoint `json:"dataPoints"`
+}
+
+type otlpSummaryPoint struct {
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	Count             string         `json:"count"`
+	Sum               float64        `json:"sum"`
+	QuantileValues    []otlpQuantile `json:"quantileValues"`
+}
+
+type otlpQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
@@ -0,0 +1,40 @@
+// overlay.go
+// H5 (advisory): icon overlay slot exhaustion. Explorer loads at most 15
+// overlay handlers, taking ShellIconOverlayIdentifiers in name order — hence
+// the leading spaces vendors put in their key names. With OneDrive, Dropbox,
+// TortoiseGit and the like installed, the rest silently never load, which
+// users see as "broken icons". A cache repair cannot fix that, so H5 only
+// warns: it takes no part in the health score and never triggers a repair.
+
+package watchdog
+
+import (
+	"fmt"
+	"strings"
+)
+
+const overlaySlots = 15
+
+type overlayStatus struct {
+	Registered int      `json:"registered"`
+	NotLoaded  []string `json:"notLoaded,omitempty"`
+}
+
+// checkH5OverlaySlots returns nil when the overlays cannot be enumerated.
+func (d *daemon) checkH5OverlaySlots() *overlayStatus {
+	handlers, err := overlayHandlers()
+	if err != nil {
+		return nil
+	}
+	st := &overlayStatus{Registered: len(handlers)}
+	if len(handlers) <= overlaySlots {
+		d.healthLog_("PASS", fmt.Sprintf("H5 PASS: %d of %d icon overlay slots used.", len(handlers), overlaySlots))
+		return st
+	}
+	for _, h := range handlers[overlaySlots:] {
+		st.NotLoaded = append(st.NotLoaded, strings.TrimSpace(h.Name))
+	}
+	d.healthLog_("WARN", fmt.Sprintf("H5 WARN: %d icon overlay handlers registered; Explorer loads only the first %d. Not loaded: %s. Cache repairs cannot fix missing overlays; uninstall or rename overlay handlers to free slots.",
+		len(handlers), overlaySlots, strings.Join(st.NotLoaded, ", ")))
+	return st
+}
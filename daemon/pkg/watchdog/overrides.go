@@ -0,0 +1,210 @@
+// overrides.go
+// Environment variable and command-line overrides for every setting, for
+// one-off experiments and test environments without a config file edit.
+// Names derive from the setting path (settings.go):
+//
+//   thresholds.sizeLimit  ->  --thresholds-size-limit=64MB  ICW_THRESHOLDS_SIZE_LIMIT=64MB
+//
+// thresholds.* may drop the prefix (--size-limit), and size and duration
+// settings accept a number in a fixed unit via -mb / -minutes
+// (--size-limit-mb=64, ICW_COOLDOWN_MINUTES=10). Flags go before the
+// subcommand, if any. --once stands for the healthcheck subcommand, --ui for
+// --ui-enabled, and --install-phase=register|unregister (MSI custom actions)
+// for install /S and uninstall /S.
+//
+// Precedence: default < preset < file < env < flag < policy. Machine policy stays on
+// top because the user environment is user-writable; when a signed config
+// is required, env and flag overrides are ignored altogether.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	sourceEnv  = "env"
+	sourceFlag = "flag"
+	envPrefix  = "ICW_"
+)
+
+// overrideName maps one accepted flag/env spelling onto a setting path.
+type overrideName struct {
+	path string
+	unit string // appended to the value ("MB", "m"), or ""
+}
+
+// overrideNames returns flag names (without "--") and env names for every
+// setting.
+func overrideNames() (flags, envs map[string]overrideName) {
+	flags, envs = map[string]overrideName{}, map[string]overrideName{}
+	var probe config
+	for _, s := range settingsOf(&probe) {
+		names := []string{kebab(s.Path)}
+		if short, ok := strings.CutPrefix(s.Path, "thresholds."); ok {
+			names = append(names, kebab(short))
+		}
+		for _, n := range names {
+			add := func(name, unit string) {
+				flags[name] = overrideName{s.Path, unit}
+				envs[envPrefix+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = overrideName{s.Path, unit}
+			}
+			add(n, "")
+			switch s.Value.Type() {
+			case reflect.TypeOf(byteSize(0)):
+				add(n+"-mb", "MB")
+			case reflect.TypeOf(duration(0)):
+				add(n+"-minutes", "m")
+			}
+		}
+	}
+	return flags, envs
+}
+
+// kebab turns "thresholds.sizeLimit" into "thresholds-size-limit".
+func kebab(path string) string {
+	var b strings.Builder
+	for i, r := range path {
+		switch {
+		case r == '.':
+			b.WriteByte('-')
+		case unicode.IsUpper(r):
+			if i > 0 && path[i-1] != '.' {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// splitOverrideFlags takes the leading --name=value arguments off args and
+// returns them keyed by setting path, together with the remaining args
+// (subcommand and its arguments). A bare --name sets a switch to true.
+func splitOverrideFlags(args []string) (rest []string, values map[string]string, err error) {
+	flags, _ := overrideNames()
+	values = map[string]string{}
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		if arg == "--once" {
+			// Shorthand for the healthcheck command (healthcheck.go).
+			return append([]string{"healthcheck"}, args...), values, nil
+		}
+		if arg == "--help" {
+			// Same as the help command (cli.go).
+			return append([]string{"help"}, args...), values, nil
+		}
+		if arg == "--ui" {
+			// Shorthand for --ui-enabled (dashboard.go).
+			values["ui.enabled"] = "true"
+			continue
+		}
+		if phase, ok := strings.CutPrefix(arg, "--install-phase="); ok {
+			// MSI custom action entry points (install.go).
+			cmd := map[string]string{"register": "install", "unregister": "uninstall"}[phase]
+			if cmd == "" {
+				return nil, nil, fmt.Errorf("unknown install phase %q (register, unregister)", phase)
+			}
+			return append([]string{cmd, "/S"}, args...), values, nil
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		o, ok := flags[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown option --%s", name)
+		}
+		if !hasValue {
+			var probe config
+			if !isBoolSetting(&probe, o.path) {
+				return nil, nil, fmt.Errorf("option --%s needs a value (--%s=...)", name, name)
+			}
+			value = "true"
+		}
+		values[o.path] = value + o.unit
+	}
+	return args, values, nil
+}
+
+// envOverrides collects ICW_* variables naming a setting. Unknown ICW_*
+// names are returned so they can be reported rather than silently ignored.
+func envOverrides() (values map[string]string, unknown []string) {
+	_, envs := overrideNames()
+	values = map[string]string{}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(strings.ToUpper(name), envPrefix) {
+			continue
+		}
+		o, ok := envs[strings.ToUpper(name)]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		values[o.path] = value + o.unit
+	}
+	sort.Strings(unknown)
+	return values, unknown
+}
+
+func isBoolSetting(cfg *config, path string) bool {
+	for _, s := range settingsOf(cfg) {
+		if s.Path == path {
+			return s.Value.Kind() == reflect.Bool
+		}
+	}
+	return false
+}
+
+// applyOverrides layers env, flags and policy on top of the file config.
+func applyOverrides(cfg *config, sources configSources, flagValues map[string]string) []error {
+	return applyOverridesWith(cfg, sources, flagValues, readSigningPolicy(), policyValues())
+}
+
+// applyOverridesWith is applyOverrides under the given signing policy and
+// machine policy values.
+func applyOverridesWith(cfg *config, sources configSources, flagValues map[string]string, signing signingPolicy, policy map[string]string) []error {
+	var warnings []error
+	envValues, unknown := envOverrides()
+	for _, name := range unknown {
+		warnings = append(warnings, fmt.Errorf("env %s: not a known setting", name))
+	}
+	if signing.required {
+		if len(envValues)+len(flagValues) > 0 {
+			warnings = append(warnings, fmt.Errorf("env/flag overrides ignored: signed config is required on this machine"))
+		}
+	} else {
+		warnings = append(warnings, applyLayer(cfg, sources, sourceEnv, envValues)...)
+		warnings = append(warnings, applyLayer(cfg, sources, sourceFlag, flagValues)...)
+	}
+	return append(warnings, applyLayer(cfg, sources, sourcePolicy, policy)...)
+}
+
+// overrideFlagList renders the canonical flag names for usage output.
+func overrideFlagList() string {
+	var probe config
+	var b strings.Builder
+	for _, s := range settingsOf(&probe) {
+		kind := s.Value.Kind().String()
+		switch s.Value.Type() {
+		case reflect.TypeOf(byteSize(0)):
+			kind = "size"
+		case reflect.TypeOf(duration(0)):
+			kind = "duration"
+		}
+		fmt.Fprintf(&b, "  --%s=<%s>\n", kebab(s.Path), kind)
+	}
+	b.WriteString("\nthresholds.* also take the short form (--size-limit); sizes and durations\n")
+	b.WriteString("take -mb / -minutes with a plain number (--size-limit-mb=64). Every option\n")
+	fmt.Fprintf(&b, "has an environment form: %sSIZE_LIMIT_MB=64.\n", envPrefix)
+	return b.String()
+}
@@ -0,0 +1,127 @@
+// overrides_test.go
+// Command-line and environment overrides.
+
+package watchdog
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstallPhaseFlag(t *testing.T) {
+	args, _, err := splitOverrideFlags([]string{"--install-phase=register", `-user=CORP\alice`})
+	if err != nil || strings.Join(args, " ") != `install /S -user=CORP\alice` {
+		t.Fatalf("register phase = %q, %v", args, err)
+	}
+	if args, _, err := splitOverrideFlags([]string{"--install-phase=unregister"}); err != nil || strings.Join(args, " ") != "uninstall /S" {
+		t.Fatalf("unregister phase = %q, %v", args, err)
+	}
+	if _, _, err := splitOverrideFlags([]string{"--install-phase=commit"}); err == nil {
+		t.Fatal("unknown phase accepted")
+	}
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	const file = `{"thresholds": {"sizeLimit": "64MB", "cooldown": "45m"}}`
+	for _, c := range []struct {
+		name   string
+		env    map[string]string
+		args   []string
+		policy map[string]string
+		signed bool // a signed config is required
+
+		size   byteSize
+		source string // of thresholds.sizeLimit
+		warn   string // in the warnings; "" for none
+	}{
+		{name: "file", size: 64 * mib, source: sourceFile},
+		{name: "env beats file", env: map[string]string{"ICW_SIZE_LIMIT": "80MB"},
+			size: 80 * mib, source: sourceEnv},
+		{name: "long env name", env: map[string]string{"ICW_THRESHOLDS_SIZE_LIMIT": "80MB"},
+			size: 80 * mib, source: sourceEnv},
+		{name: "flag beats env", env: map[string]string{"ICW_SIZE_LIMIT": "80MB"}, args: []string{"--size-limit=96MB"},
+			size: 96 * mib, source: sourceFlag},
+		{name: "flag in a fixed unit", args: []string{"--thresholds-size-limit-mb=72"},
+			size: 72 * mib, source: sourceFlag},
+		{name: "policy beats flag", args: []string{"--size-limit=96MB"}, policy: map[string]string{"thresholds.sizeLimit": "128MB"},
+			size: 128 * mib, source: sourcePolicy},
+		{name: "unknown env name", env: map[string]string{"ICW_SIZE_LIMT": "80MB"},
+			size: 64 * mib, source: sourceFile, warn: "env ICW_SIZE_LIMT: not a known setting"},
+		{name: "env of the wrong type", env: map[string]string{"ICW_SIZE_LIMIT": "lots"},
+			size: 64 * mib, source: sourceFile, warn: `env thresholds.sizeLimit: invalid size "lots"`},
+		{name: "flag of the wrong type", args: []string{"--size-limit=96MB", "--ui-enabled=maybe"},
+			size: 96 * mib, source: sourceFlag, warn: "flag ui.enabled: "},
+		{name: "flag out of range", env: map[string]string{"ICW_SIZE_LIMIT": "80MB"}, args: []string{"--size-limit=8GB"},
+			size: 80 * mib, source: sourceEnv, warn: "flag thresholds.sizeLimit: must be between 1MB and 4GB"},
+		{name: "policy of the wrong type", policy: map[string]string{"thresholds.sizeLimit": "big"},
+			size: 64 * mib, source: sourceFile, warn: "policy thresholds.sizeLimit: "},
+		{name: "signed config required", env: map[string]string{"ICW_SIZE_LIMIT": "80MB"}, args: []string{"--size-limit=96MB"}, signed: true,
+			size: 64 * mib, source: sourceFile, warn: "env/flag overrides ignored: signed config is required"},
+		{name: "policy despite a signed config", args: []string{"--size-limit=96MB"}, policy: map[string]string{"thresholds.sizeLimit": "128MB"}, signed: true,
+			size: 128 * mib, source: sourcePolicy, warn: "env/flag overrides ignored"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			for name, value := range c.env {
+				t.Setenv(name, value)
+			}
+			cfg, keys, err := parseConfig([]byte(file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sources := configSources{}
+			for _, k := range keys {
+				sources[k] = sourceFile
+			}
+			rest, flags, err := splitOverrideFlags(append(c.args, "status"))
+			if err != nil || strings.Join(rest, " ") != "status" {
+				t.Fatalf("split = %q, %v", rest, err)
+			}
+			warnings := applyOverridesWith(&cfg, sources, flags, signingPolicy{required: c.signed}, c.policy)
+			if cfg.Thresholds.SizeLimit != c.size || sources.of("thresholds.sizeLimit") != c.source {
+				t.Errorf("sizeLimit = %s (%s), want %s (%s)", cfg.Thresholds.SizeLimit, sources.of("thresholds.sizeLimit"), c.size, c.source)
+			}
+			// Settings no layer overrides keep the file's value.
+			if cfg.Thresholds.Cooldown.D() != 45*time.Minute || sources.of("thresholds.cooldown") != sourceFile {
+				t.Errorf("cooldown = %s (%s)", cfg.Thresholds.Cooldown, sources.of("thresholds.cooldown"))
+			}
+			var got []string
+			for _, w := range warnings {
+				got = append(got, w.Error())
+			}
+			if all := strings.Join(got, "\n"); (c.warn == "") != (all == "") || !strings.Contains(all, c.warn) {
+				t.Errorf("warnings = %q, want %q", got, c.warn)
+			}
+		})
+	}
+}
+
+func TestSplitOverrideFlags(t *testing.T) {
+	for _, c := range []struct {
+		args   []string
+		rest   string
+		values map[string]string
+		err    string // in the error; "" for none
+	}{
+		{args: []string{"--size-limit=64MB", "--cooldown-minutes=10", "status", "-json"}, rest: "status -json",
+			values: map[string]string{"thresholds.sizeLimit": "64MB", "thresholds.cooldown": "10m"}},
+		{args: []string{"--ui", "--usn-journal-enabled"}, values: map[string]string{"ui.enabled": "true", "usnJournal.enabled": "true"}},
+		{args: []string{"--cooldown=1h", "--", "--size-limit=64MB"}, rest: "--size-limit=64MB",
+			values: map[string]string{"thresholds.cooldown": "1h"}},
+		{args: []string{"--once", "-json"}, rest: "healthcheck -json", values: map[string]string{}},
+		{args: []string{"--size-limt=64MB"}, err: "unknown option --size-limt"},
+		{args: []string{"--size-limit"}, err: "option --size-limit needs a value"},
+	} {
+		rest, values, err := splitOverrideFlags(c.args)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: error %v, want %q", c.args, err, c.err)
+			}
+			continue
+		}
+		if err != nil || strings.Join(rest, " ") != c.rest || !maps.Equal(values, c.values) {
+			t.Errorf("%q = %q, %v, %v", c.args, rest, values, err)
+		}
+	}
+}
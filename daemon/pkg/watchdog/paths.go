@@ -0,0 +1,109 @@
+// paths.go
+// Resolution of the data directory (logs + state) and one-time migration of
+// logs written by v2.0.0 into the install directory's logs/ folder.
+// Writing into the install directory breaks as soon as the project lives
+// under Program Files, so the default is %ProgramData%\IconCacheWatchdog.
+
+package watchdog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dataDirProgramData  = "programdata"
+	dataDirLocalAppData = "localappdata"
+	dataDirInstall      = "install"
+
+	appDirName = "IconCacheWatchdog"
+)
+
+// legacyLogNames are the files v2.0.0 wrote to <root>/logs.
+var legacyLogNames = []string{"Watchdog.log", "IconCacheHealth.log", "IconCacheRepair.log"}
+
+// resolveDataDir maps the dataDir setting onto an absolute directory.
+// Falls back to the install root when the requested base is unavailable
+// (e.g. %ProgramData% unset on a non-Windows development machine).
+func resolveDataDir(setting, rootDir string) string {
+	switch strings.ToLower(strings.TrimSpace(setting)) {
+	case "", dataDirProgramData:
+		if base := os.Getenv("ProgramData"); base != "" {
+			return filepath.Join(base, appDirName)
+		}
+	case dataDirLocalAppData:
+		if base := os.Getenv("LOCALAPPDATA"); base != "" {
+			return filepath.Join(base, appDirName)
+		}
+	case dataDirInstall:
+		return rootDir
+	default:
+		return filepath.Clean(os.ExpandEnv(setting))
+	}
+	return rootDir
+}
+
+// migrateLegacyLogs moves logs left in <root>/logs by earlier versions into
+// the current log directory. A log not yet at the destination is renamed
+// into place; otherwise the legacy content is appended to the existing
+// one. Removal of the old file is best-effort since the install directory
+// is typically read-only for the daemon, so a copy that stays behind is
+// recorded in a <name>.migrated marker and not appended again.
+func (d *daemon) migrateLegacyLogs(legacyDir string) {
+	if samePath(legacyDir, d.logDir) {
+		return
+	}
+	for _, name := range legacyLogNames {
+		src := filepath.Join(legacyDir, name)
+		info, err := os.Stat(src)
+		if err != nil || info.Size() == 0 {
+			continue
+		}
+		dst := filepath.Join(d.logDir, name)
+		marker := dst + ".migrated"
+		if _, err := os.Stat(marker); err == nil {
+			continue
+		}
+		if _, err := os.Stat(dst); os.IsNotExist(err) && os.MkdirAll(d.logDir, 0755) == nil && os.Rename(src, dst) == nil {
+			d.watchLog_("INFO", fmt.Sprintf("Migrated legacy log %s -> %s", src, dst))
+			continue
+		}
+		if err := appendFile(dst, src); err != nil {
+			d.watchLog_("WARN", fmt.Sprintf("Log migration failed for %s: %v", name, err))
+			continue
+		}
+		if err := os.Remove(src); err != nil {
+			os.WriteFile(marker, []byte(src+"\n"), 0644)
+			d.watchLog_("WARN", fmt.Sprintf("Migrated %s but could not remove the old copy: %v. It will not be migrated again.", name, err))
+			continue
+		}
+		d.watchLog_("INFO", fmt.Sprintf("Migrated legacy log %s -> %s", src, dst))
+	}
+}
+
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func samePath(a, b string) bool {
+	return strings.EqualFold(filepath.Clean(a), filepath.Clean(b))
+}
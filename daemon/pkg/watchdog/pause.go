@@ -0,0 +1,179 @@
+// pause.go
+// Pausing the watchdog. Users about to do disk-heavy work (a large
+// checkout, a VM import) can silence the daemon for a bounded time:
+//
+//   icon-cache-watchdog.exe pause -for 2h -reason "VM import"
+//   icon-cache-watchdog.exe resume
+//
+// While paused, polls and health checks still run and log, but no repair,
+// deep clean or Explorer restart is started — neither by a trigger nor on
+// request; the `repair` command of the event task skips too. What undoes
+// damage still runs: a shell rescue, the recovery of an interrupted repair
+// and a rollback the user asks for. The pause ends on its own at the
+// chosen time; pause.max bounds it so a forgotten pause cannot leave the
+// machine unprotected for long. It is kept in state.json and survives
+// restarts. The CLI asks the running daemon over the control pipe
+// ("pause", "resume"), as the tray does; without a daemon it edits
+// state.json. Single-user mode only.
+
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+type pauseOptions struct {
+	Enabled bool     `json:"enabled"` // users may pause at all
+	Max     duration `json:"max"`     // longest pause accepted
+}
+
+func defaultPauseOptions() pauseOptions {
+	return pauseOptions{Enabled: true, Max: duration(24 * time.Hour)}
+}
+
+// pauseState is a pause in effect, as kept in state.json.
+type pauseState struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by"` // command line, control pipe
+}
+
+func (p pauseState) String() string {
+	s := "paused until " + p.Until.Local().Format("2006-01-02 15:04")
+	if p.Reason != "" {
+		s += " (" + p.Reason + ")"
+	}
+	return s
+}
+
+// pausedReason describes the pause or tripped circuit breaker
+// (breaker.go) in effect, or "" when neither is. Caller holds d.mu.
+func (d *daemon) pausedReason() string {
+	if d.breaker != nil {
+		return d.breaker.String()
+	}
+	if !d.pauseActive() {
+		return ""
+	}
+	return d.pause.String()
+}
+
+func (d *daemon) pauseActive() bool {
+	return d.pause != nil && d.clock.Now().Before(d.pause.Until)
+}
+
+// setPause pauses the watchdog for dur. Caller holds d.mu.
+func (d *daemon) setPause(dur time.Duration, reason, by string) (*pauseState, error) {
+	switch {
+	case !d.cfg.Pause.Enabled:
+		return nil, errors.New("pausing is disabled (pause.enabled)")
+	case d.session != nil || d.cfg.MultiSession.Enabled:
+		return nil, errors.New("pausing is not available in multi-session mode")
+	case dur < time.Minute || dur > d.cfg.Pause.Max.D():
+		return nil, fmt.Errorf("pause must be between 1m and %s (pause.max)", d.cfg.Pause.Max)
+	}
+	d.pause = &pauseState{Until: d.clock.Now().Add(dur), Reason: reason, By: by}
+	d.saveState()
+	d.watchLog_("WARN", fmt.Sprintf("Watchdog %s via %s; no repairs until then.", d.pause, by))
+	d.historyEvent(historyPaused, fmt.Sprintf("%s via %s", d.pause, by))
+	p := *d.pause
+	return &p, nil
+}
+
+// endPause resumes the watchdog; expired reports whether the pause ran out
+// rather than being lifted. Caller holds d.mu.
+func (d *daemon) endPause(by string, expired bool) bool {
+	if d.pause == nil {
+		return false
+	}
+	msg := fmt.Sprintf("Watchdog resumed via %s; triggers are active again.", by)
+	if expired {
+		msg = fmt.Sprintf("Pause ended at %s; triggers are active again.", d.pause.Until.Local().Format("15:04"))
+	}
+	d.pause = nil
+	d.saveState()
+	d.watchLog_("INFO", msg)
+	d.historyEvent(historyResumed, msg)
+	return true
+}
+
+// checkPause runs on every Layer B poll and ends a pause that ran out.
+func (d *daemon) checkPause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pause != nil && !d.pauseActive() {
+		d.endPause("", true)
+	}
+}
+
+// servePause answers a control-pipe pause or resume request.
+func (d *daemon) servePause(req controlRequest) controlEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ev := controlEvent{Type: eventPause, At: d.clock.Now()}
+	if req.Cmd == requestResume {
+		d.endPause("control pipe", false)
+		d.resetBreaker("control pipe")
+		return ev
+	}
+	p, err := d.setPause(req.For.D(), req.Reason, "control pipe")
+	if err != nil {
+		return controlEvent{Type: eventError, At: d.clock.Now(), Error: err.Error()}
+	}
+	ev.Pause = p
+	return ev
+}
+
+func cmdPause(d *daemon, args []string) int {
+	fs := newFlagSet("pause")
+	length := fs.String("for", "1h", "how long to pause, e.g. 30m or 2h")
+	reason := fs.String("reason", "", "note for the logs and status")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	dur, err := parseDuration(*length)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ev, err := d.pauseRequest(controlRequest{Cmd: requestPause, For: dur, Reason: *reason})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot pause: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Watchdog %s. Run `resume` to end it sooner.\n", ev.Pause)
+	return 0
+}
+
+func cmdResume(d *daemon, args []string) int {
+	if _, err := d.pauseRequest(controlRequest{Cmd: requestResume}); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot resume: %v\n", err)
+		return 1
+	}
+	fmt.Println("Watchdog resumed.")
+	return 0
+}
+
+// pauseRequest asks the running daemon, else edits state.json.
+func (d *daemon) pauseRequest(req controlRequest) (controlEvent, error) {
+	if c, err := openControl(d.controlAddr()); err == nil {
+		defer c.Close()
+		return c.call(req)
+	}
+	d.loadState()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if req.Cmd == requestResume {
+		d.endPause("command line", false)
+		d.resetBreaker("command line")
+		return controlEvent{Type: eventPause, At: d.clock.Now()}, nil
+	}
+	p, err := d.setPause(req.For.D(), req.Reason, "command line")
+	if err != nil {
+		return controlEvent{}, err
+	}
+	return controlEvent{Type: eventPause, At: d.clock.Now(), Pause: p}, nil
+}
@@ -0,0 +1,307 @@
+// plugins.go
+// Third-party heuristics and repair actions (plugins.enabled). Go's plugin
+// build mode does not exist on Windows, so a plug-in is an executable in
+// plugins.dir that speaks one JSON line each way: the daemon starts it
+// hidden, writes a request to its stdin and reads the reply from its stdout.
+//
+//	→ {"protocol":1,"call":"describe"}
+//	← {"protocol":1,"name":"vendor-icons","heuristic":true,"repair":true}
+//	→ {"protocol":1,"call":"check","cacheDir":"…","profile":"local"}
+//	← {"pass":false,"message":"vendor overlay cache is stale"}
+//	→ {"protocol":1,"call":"repair","cacheDir":"…","reason":"…","repaired":true}
+//	← {"ok":true,"message":"vendor cache cleared"}
+//
+// describe runs once per daemon start. A heuristic is asked to check with
+// every health check and adds plugins.weight to the score when it passes; a
+// plug-in that fails, exits non-zero or misses plugins.timeout is logged and
+// left out of the score, so a broken plug-in never triggers repairs. Repair
+// actions run after every repair script, whatever its outcome. Each launch
+// is validated like the repair script (integrity.go): the executable must
+// lie under the project root and must not be writable by broad groups.
+
+package watchdog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const pluginProtocol = 1
+
+// Plug-in calls.
+const (
+	pluginDescribe = "describe"
+	pluginCheck    = "check"
+	pluginRepair   = "repair"
+)
+
+type pluginOptions struct {
+	Enabled bool `json:"enabled"`
+
+	// Dir holds the plug-in executables. Relative paths are resolved
+	// against the project root.
+	Dir string `json:"dir"`
+
+	// Weight is the score weight of each plug-in heuristic (score.go). The
+	// default outweighs the margin below 100, like the built-in weights, so
+	// one failing plug-in heuristic repairs.
+	Weight float64 `json:"weight"`
+
+	// Timeout bounds every call.
+	Timeout duration `json:"timeout"`
+}
+
+func defaultPluginOptions() pluginOptions {
+	return pluginOptions{
+		Dir:     "plugins",
+		Weight:  20,
+		Timeout: duration(10 * time.Second),
+	}
+}
+
+type pluginRequest struct {
+	Protocol int    `json:"protocol"`
+	Call     string `json:"call"`
+	CacheDir string `json:"cacheDir,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+	Reason   string `json:"reason,omitempty"`   // repair
+	Repaired *bool  `json:"repaired,omitempty"` // repair: the script succeeded
+}
+
+type pluginReply struct {
+	Protocol  int    `json:"protocol"`  // describe
+	Name      string `json:"name"`      // describe
+	Heuristic bool   `json:"heuristic"` // describe: answers check
+	Repair    bool   `json:"repair"`    // describe: answers repair
+	Pass      bool   `json:"pass"`      // check
+	OK        bool   `json:"ok"`        // repair
+	Message   string `json:"message"`
+}
+
+type plugin struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Heuristic bool   `json:"heuristic"`
+	Repair    bool   `json:"repair"`
+}
+
+// pluginResult is one answered check.
+type pluginResult struct {
+	Name    string `json:"name"`
+	Pass    bool   `json:"pass"`
+	Message string `json:"message,omitempty"`
+}
+
+func (d *daemon) pluginDir() string {
+	dir := d.cfg.Plugins.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(d.rootDir, dir)
+	}
+	return dir
+}
+
+// pluginList describes the plug-ins on first use.
+func (d *daemon) pluginList() []plugin {
+	d.pluginsOnce.Do(func() { d.plugins = d.discoverPlugins() })
+	return d.plugins
+}
+
+func (d *daemon) discoverPlugins() []plugin {
+	if !d.cfg.Plugins.Enabled || d.sim != nil {
+		return nil
+	}
+	dir := d.pluginDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		d.watchLog_("WARN", fmt.Sprintf("Plug-in folder unreadable: %v", err))
+		return nil
+	}
+	var out []plugin
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if !isPluginFile(e) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		reply, err := d.callPlugin(path, pluginRequest{Call: pluginDescribe})
+		if err != nil {
+			d.watchLog_("WARN", fmt.Sprintf("Plug-in %s skipped: %v", e.Name(), err))
+			continue
+		}
+		if reply.Protocol > pluginProtocol {
+			d.watchLog_("WARN", fmt.Sprintf("Plug-in %s skipped: protocol %d is newer than %d", e.Name(), reply.Protocol, pluginProtocol))
+			continue
+		}
+		p := plugin{Name: reply.Name, Path: path, Heuristic: reply.Heuristic, Repair: reply.Repair}
+		if p.Name == "" {
+			p.Name = strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		}
+		if seen[p.Name] {
+			d.watchLog_("WARN", fmt.Sprintf("Plug-in %s skipped: name %q is taken", e.Name(), p.Name))
+			continue
+		}
+		seen[p.Name] = true
+		d.watchLog_("INFO", fmt.Sprintf("Plug-in %s loaded from %s (%s)", p.Name, e.Name(), p.roles()))
+		out = append(out, p)
+	}
+	return out
+}
+
+func (p plugin) roles() string {
+	var roles []string
+	if p.Heuristic {
+		roles = append(roles, "heuristic")
+	}
+	if p.Repair {
+		roles = append(roles, "repair action")
+	}
+	if len(roles) == 0 {
+		return "no roles"
+	}
+	return strings.Join(roles, ", ")
+}
+
+// isPluginFile accepts .exe files on Windows and executables elsewhere.
+func isPluginFile(e os.DirEntry) bool {
+	if !e.Type().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(e.Name()), ".exe")
+	}
+	info, err := e.Info()
+	return err == nil && info.Mode().Perm()&0111 != 0
+}
+
+// callPlugin runs one request against the executable at path.
+func (d *daemon) callPlugin(path string, req pluginRequest) (pluginReply, error) {
+	var reply pluginReply
+	if _, err := validateLaunch("plug-in", path, d.rootDir); err != nil {
+		d.watchLog_("TAMPER", fmt.Sprintf("Refusing to run plug-in: %v", err))
+		return reply, err
+	}
+	req.Protocol = pluginProtocol
+	in, err := json.Marshal(req)
+	if err != nil {
+		return reply, err
+	}
+	timeout := d.cfg.Plugins.Timeout.D()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = filepath.Dir(path)
+	cmd.SysProcAttr = sysProcAttr()
+	cmd.Stdin = bytes.NewReader(append(in, '\n'))
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return reply, fmt.Errorf("no answer within %s", duration(timeout))
+	}
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(bytes.TrimSpace(ee.Stderr)) > 0 {
+			line, _, _ := strings.Cut(strings.TrimSpace(string(ee.Stderr)), "\n")
+			err = fmt.Errorf("%v: %s", err, line)
+		}
+		return reply, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	if !sc.Scan() {
+		return reply, errors.New("empty reply")
+	}
+	if err := json.Unmarshal(sc.Bytes(), &reply); err != nil {
+		return reply, fmt.Errorf("invalid reply: %v", err)
+	}
+	return reply, nil
+}
+
+// checkPlugins asks every plug-in heuristic; plug-ins that did not answer
+// are left out.
+func (d *daemon) checkPlugins() []pluginResult {
+	var out []pluginResult
+	for _, p := range d.pluginList() {
+		if !p.Heuristic {
+			continue
+		}
+		reply, err := d.callPlugin(p.Path, pluginRequest{Call: pluginCheck, CacheDir: d.cacheDir, Profile: d.profile.Type})
+		if err != nil {
+			d.healthLog_("WARN", fmt.Sprintf("Plug-in %s: no result, not scored: %v", p.Name, err))
+			continue
+		}
+		r := pluginResult{Name: p.Name, Pass: reply.Pass, Message: reply.Message}
+		if r.Pass {
+			d.healthLog_("PASS", fmt.Sprintf("Plug-in %s PASS: %s", p.Name, r.Message))
+		} else {
+			d.healthLog_("WARN", fmt.Sprintf("Plug-in %s FAIL: %s", p.Name, r.Message))
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// runRepairPlugins runs the plug-in repair actions after a repair script.
+func (d *daemon) runRepairPlugins(reason string, repaired bool) {
+	for _, p := range d.pluginList() {
+		if !p.Repair {
+			continue
+		}
+		reply, err := d.callPlugin(p.Path, pluginRequest{Call: pluginRepair, CacheDir: d.cacheDir, Profile: d.profile.Type, Reason: reason, Repaired: &repaired})
+		switch {
+		case err != nil:
+			d.repairLog_("WARN", fmt.Sprintf("Plug-in %s repair action failed: %v", p.Name, err))
+		case !reply.OK:
+			d.repairLog_("WARN", fmt.Sprintf("Plug-in %s repair action failed: %s", p.Name, reply.Message))
+		default:
+			d.repairLog_("INFO", fmt.Sprintf("Plug-in %s repair action done: %s", p.Name, reply.Message))
+		}
+	}
+}
+
+func cmdPlugins(d *daemon, args []string) int {
+	fs := newFlagSet("plugins")
+	check := fs.Bool("check", false, "also run every plug-in heuristic once")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !d.cfg.Plugins.Enabled {
+		fmt.Println("Plug-ins are disabled (plugins.enabled).")
+		return 0
+	}
+	d.profile = detectCurrentProfile()
+	list := d.pluginList()
+	var results []pluginResult
+	if *check {
+		results = d.checkPlugins()
+	}
+
+	if *asJSON {
+		printJSON("plugins", struct {
+			Plugins []plugin       `json:"plugins"`
+			Results []pluginResult `json:"results,omitempty"`
+		}{list, results})
+		return 0
+	}
+	fmt.Printf("%d plug-in(s) in %s (details in the log).\n", len(list), d.pluginDir())
+	for _, p := range list {
+		fmt.Printf("  %-20s %-26s %s\n", p.Name, p.roles(), filepath.Base(p.Path))
+	}
+	for _, r := range results {
+		verdict := "PASS"
+		if !r.Pass {
+			verdict = "FAIL"
+		}
+		fmt.Printf("  %s %s: %s\n", verdict, r.Name, r.Message)
+	}
+	return 0
+}
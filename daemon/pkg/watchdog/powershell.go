@@ -0,0 +1,93 @@
+// powershell.go
+// Hardened invocation of the PowerShell repair script. The child runs hidden
+// with -ExecutionPolicy Bypass, so the attack surface is kept small:
+//   - -NoProfile (default on): no user/machine profile scripts are loaded
+//   - optional ConstrainedLanguage mode for the script body
+//   - interpreter resolved to a full path (no PATH search), every path quoted
+//   - the exact command line is logged before launch
+
+package watchdog
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+type powerShellOptions struct {
+	// Path to pwsh.exe / powershell.exe. Empty selects PowerShell 7 when
+	// installed, else Windows PowerShell from System32.
+	Path string `json:"path"`
+
+	// NoProfile passes -NoProfile. Defaults to true.
+	NoProfile bool `json:"noProfile"`
+
+	// ConstrainedLanguage switches the session to ConstrainedLanguage mode
+	// and then calls the script via -Command instead of -File.
+	// Repair-IconCache.ps1 only uses CLM-safe constructs.
+	ConstrainedLanguage bool `json:"constrainedLanguage"`
+}
+
+// powerShellCommand builds the hidden child process for script with the
+// given named parameters (name/value pairs, e.g. "-DataDir", dir).
+func (d *daemon) powerShellCommand(script string, params ...string) *exec.Cmd {
+	opts := d.cfg.PowerShell
+	exe := findPowerShell(opts.Path)
+
+	args := []string{"-NoLogo"}
+	if opts.NoProfile {
+		args = append(args, "-NoProfile")
+	}
+	args = append(args,
+		"-WindowStyle", "Hidden",
+		"-NonInteractive",
+		"-ExecutionPolicy", "Bypass",
+	)
+	if opts.ConstrainedLanguage {
+		var b strings.Builder
+		b.WriteString("$ExecutionContext.SessionState.LanguageMode = 'ConstrainedLanguage'; & ")
+		b.WriteString(psQuote(script))
+		for _, p := range params {
+			b.WriteByte(' ')
+			if strings.HasPrefix(p, "-") {
+				b.WriteString(p)
+			} else {
+				b.WriteString(psQuote(p))
+			}
+		}
+		b.WriteString("; exit $LASTEXITCODE")
+		args = append(args, "-Command", b.String())
+	} else {
+		args = append(args, "-File", script)
+		args = append(args, params...)
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.SysProcAttr = sysProcAttr() // platform-specific: CREATE_NO_WINDOW
+	d.repairLog_("INFO", fmt.Sprintf("Command line: %s", commandLine(exe, args)))
+	return cmd
+}
+
+// psSingleQuotes are the characters PowerShell accepts as a single quote:
+// the ASCII one and the typographic ones (‘ ’ ‚ ‛) that turn up in names
+// such as O’Brien. Each ends a single-quoted literal unless doubled.
+var psSingleQuotes = strings.NewReplacer("'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019", "\u201a", "\u201a\u201a", "\u201b", "\u201b\u201b")
+
+// psQuote wraps s in a PowerShell single-quoted literal (no expansion).
+func psQuote(s string) string {
+	return "'" + psSingleQuotes.Replace(s) + "'"
+}
+
+// commandLine renders the command line the way Windows receives it: the
+// executable is always quoted, arguments are quoted when they contain
+// whitespace or quotes (embedded quotes are backslash-escaped).
+func commandLine(exe string, args []string) string {
+	parts := []string{`"` + exe + `"`}
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"") {
+			a = `"` + strings.ReplaceAll(a, `"`, `\"`) + `"`
+		}
+		parts = append(parts, a)
+	}
+	return strings.Join(parts, " ")
+}
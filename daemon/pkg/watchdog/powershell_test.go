@@ -0,0 +1,21 @@
+// powershell_test.go
+// Quoting for the PowerShell command lines the daemon builds.
+
+package watchdog
+
+import "testing"
+
+func TestPSQuote(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{`C:\Icon Cache`, `'C:\Icon Cache'`},
+		{`$env:TEMP`, `'$env:TEMP'`},
+		{`C:\Users\O'Brien`, `'C:\Users\O''Brien'`},
+		// Typographic apostrophes end PowerShell literals too.
+		{`C:\Users\O’Brien`, `'C:\Users\O’’Brien'`},
+		{"‘a‚b‛", "'‘‘a‚‚b‛‛'"},
+	} {
+		if got := psQuote(c.in); got != c.want {
+			t.Errorf("psQuote(%s) = %s, want %s", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// presets.go
+// Named configuration presets (preset: "laptop", "desktop", "vdi") so admins
+// start from thresholds, polling rates and maintenance windows that suit the
+// machine class instead of tuning every knob. A preset only changes the
+// defaults it names; the config file, env, flags and policy still win:
+//
+//   default < preset < config file < env < flag < policy
+//
+// The preset itself is an ordinary setting and can come from any layer
+// (`install --profile=vdi` puts --preset=vdi on the scheduled tasks), so it
+// is applied again after the overrides. `config presets` lists the values.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const sourcePreset = "preset"
+
+// presets holds each preset's settings in text form (settings.go).
+var presets = map[string]map[string]string{
+	// Laptops sleep, roam and are often off at night: slower polling saves
+	// battery, the USN journal catches up after resume, and the deep clean
+	// runs at lunchtime rather than at 03:00.
+	"laptop": {
+		"thresholds.pollEvery":        "2m",
+		"thresholds.healthCheckEvery": "90m",
+		"thresholds.heartbeatEvery":   "12h",
+		"usnJournal.enabled":          "true",
+		"deepClean.enabled":           "true",
+		"deepClean.every":             "14d",
+		"deepClean.window":            "12:00-14:00",
+	},
+	// Desktops stay on: default intervals, a weekly night-time deep clean,
+	// and a backup before each repair.
+	"desktop": {
+		"usnJournal.enabled": "true",
+		"deepClean.enabled":  "true",
+		"deepClean.every":    "7d",
+		"deepClean.window":   "03:00-05:00",
+		"backup.enabled":     "true",
+	},
+	// Non-persistent VDI discards the profile at logoff: nothing to clean up
+	// over time, nothing worth backing up, and many sessions per host, so
+	// checks are fewer and cheaper and repairs rarer.
+	"vdi": {
+		"thresholds.pollEvery":        "1m",
+		"thresholds.healthCheckEvery": "2h",
+		"thresholds.heartbeatEvery":   "1d",
+		"thresholds.cooldown":         "60m",
+		"usnJournal.enabled":          "false",
+		"deepClean.enabled":           "false",
+		"backup.enabled":              "false",
+		"selfCheck.maxMemory":         "128MB",
+	},
+}
+
+// presetNames lists the presets in alphabetical order.
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyPreset sets the values of cfg.Preset on every setting that no layer
+// has set. Values of a previously applied preset are reset first, so a
+// preset chosen by a later layer replaces the file's choice.
+func applyPreset(cfg *config, sources configSources) {
+	def := defaultConfig()
+	defaults := map[string]reflect.Value{}
+	for _, s := range settingsOf(&def) {
+		defaults[s.Path] = s.Value
+	}
+	for _, s := range settingsOf(cfg) {
+		if sources[s.Path] == sourcePreset {
+			s.Value.Set(defaults[s.Path])
+			delete(sources, s.Path)
+		}
+	}
+	for path, text := range presets[cfg.Preset] {
+		if sources.of(path) != sourceDefault {
+			continue
+		}
+		if err := cfg.setText(path, text); err == nil {
+			sources[path] = sourcePreset
+		}
+	}
+}
+
+// presetConfig returns the defaults with preset name applied.
+func presetConfig(name string) (config, error) {
+	cfg := defaultConfig()
+	if _, ok := presets[name]; !ok && name != "" {
+		return cfg, fmt.Errorf("unknown preset %q (%s)", name, strings.Join(presetNames(), ", "))
+	}
+	cfg.Preset = name
+	applyPreset(&cfg, configSources{})
+	return cfg, nil
+}
+
+// cmdConfigPresets prints each preset's settings.
+func cmdConfigPresets(d *daemon, args []string) int {
+	fs := newFlagSet("config presets")
+	asJSON := fs.Bool("json", false, "print the presets as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: config presets [-json]")
+		return 2
+	}
+	if *asJSON {
+		printJSON("config-presets", struct {
+			Selected string                       `json:"selected"`
+			Presets  map[string]map[string]string `json:"presets"`
+		}{d.cfg.Preset, presets})
+		return 0
+	}
+	for _, name := range presetNames() {
+		current := ""
+		if name == d.cfg.Preset {
+			current = " (selected)"
+		}
+		fmt.Printf("%s%s\n", name, current)
+		values := presets[name]
+		paths := make([]string, 0, len(values))
+		for p := range values {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			fmt.Printf("  %-28s %s\n", p, values[p])
+		}
+		fmt.Println()
+	}
+	fmt.Println(`Select one with "preset" in the config file, --preset=NAME or install --profile=NAME.`)
+	return 0
+}
@@ -0,0 +1,70 @@
+// presets_test.go
+// Presets between the defaults and the config file.
+
+package watchdog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPresets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, configFileName)
+	if err := os.WriteFile(path, []byte(`{"preset": "laptop", "thresholds": {"pollEvery": "5m"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, sources, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file beats the preset; the preset beats the defaults.
+	if cfg.Thresholds.PollEvery.D() != 5*time.Minute || sources.of("thresholds.pollEvery") != sourceFile {
+		t.Fatalf("pollEvery = %s (%s)", cfg.Thresholds.PollEvery, sources.of("thresholds.pollEvery"))
+	}
+	if cfg.DeepClean.Window != "12:00-14:00" || sources.of("deepClean.window") != sourcePreset {
+		t.Fatalf("deepClean.window = %q (%s)", cfg.DeepClean.Window, sources.of("deepClean.window"))
+	}
+
+	// A preset chosen by a later layer replaces the file's choice.
+	if errs := applyLayer(&cfg, sources, sourceFlag, map[string]string{"preset": "vdi"}); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	applyPreset(&cfg, sources)
+	if cfg.DeepClean.Enabled || cfg.DeepClean.Window != defaultDeepCleanOptions().Window || cfg.Thresholds.HealthCheckEvery.D() != 2*time.Hour {
+		t.Fatalf("vdi preset not applied: %+v %+v", cfg.DeepClean, cfg.Thresholds)
+	}
+	if cfg.Thresholds.PollEvery.D() != 5*time.Minute {
+		t.Fatalf("file value lost: pollEvery = %s", cfg.Thresholds.PollEvery)
+	}
+	if errs := applyLayer(&cfg, sources, sourceFlag, map[string]string{"preset": "kiosk"}); len(errs) != 1 {
+		t.Fatalf("unknown preset accepted: %v", errs)
+	}
+
+	for _, name := range presetNames() {
+		cfg, err := presetConfig(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := cfg.checkRanges(); len(errs) > 0 {
+			t.Fatalf("preset %s: %v", name, errs)
+		}
+		for path, text := range presets[name] {
+			if err := cfg.setText(path, text); err != nil {
+				t.Fatalf("preset %s: %v", name, err)
+			}
+		}
+	}
+
+	// A config ParseConfig cannot parse yields the plain defaults, preset and
+	// all else ignored.
+	cfg, err = ParseConfig([]byte(`{"preset": "vdi", "thresholds": {"pollEvery": 5}}`))
+	if err == nil || cfg.Thresholds.HealthCheckEvery != defaultConfig().Thresholds.HealthCheckEvery {
+		t.Fatalf("ParseConfig with a bad value (%v): healthCheckEvery = %s", err, cfg.Thresholds.HealthCheckEvery)
+	}
+	if cfg, err := ParseConfig([]byte(`{"preset": "vdi"}`)); err != nil || cfg.Thresholds.HealthCheckEvery.D() != 2*time.Hour {
+		t.Fatalf("ParseConfig (%v): healthCheckEvery = %s", err, cfg.Thresholds.HealthCheckEvery)
+	}
+}
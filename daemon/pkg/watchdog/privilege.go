@@ -0,0 +1,86 @@
+// privilege.go
+// Startup capability probe: can this process actually delete the cache
+// files and restart Explorer in the interactive session? A daemon started
+// in the wrong context (service account, different session, low integrity)
+// would otherwise fail every repair with "access denied" and keep retrying.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type capabilities struct {
+	Elevated        bool   `json:"elevated"`
+	IntegrityLevel  string `json:"integrityLevel"`
+	SessionID       uint32 `json:"sessionId"`
+	ExplorerSession int    `json:"explorerSession"` // -1 when Explorer is not running
+	CanDeleteCache  bool   `json:"canDeleteCache"`
+	CanRestartShell bool   `json:"canRestartShell"`
+	BrokerAvailable bool   `json:"brokerAvailable"`
+	RepairRoute     string `json:"repairRoute"`
+}
+
+const (
+	routeDirect     = "direct"
+	routeBroker     = "elevated-broker"
+	routeTrampoline = "task-trampoline"
+)
+
+// canRepairDirect reports whether the repair script can be launched from
+// this process with a realistic chance of success.
+func (c capabilities) canRepairDirect() bool {
+	return c.CanDeleteCache && c.CanRestartShell
+}
+
+func (c capabilities) String() string {
+	return fmt.Sprintf("elevated=%t integrity=%s session=%d explorerSession=%d deleteCache=%t restartShell=%t broker=%t route=%s",
+		c.Elevated, c.IntegrityLevel, c.SessionID, c.ExplorerSession, c.CanDeleteCache, c.CanRestartShell, c.BrokerAvailable, c.RepairRoute)
+}
+
+func (d *daemon) probeCapabilities() capabilities {
+	c := capabilities{
+		Elevated:        isElevated(),
+		IntegrityLevel:  integrityLevel(),
+		SessionID:       currentSessionID(),
+		ExplorerSession: explorerSessionID(),
+	}
+	c.CanDeleteCache = canWriteDir(d.cacheDir)
+	// Explorer can be stopped by the same user in the same session. From a
+	// different session (service, scheduled task running as another user)
+	// only an elevated process can terminate it — and even then it cannot
+	// start the new shell in the user's desktop.
+	c.CanRestartShell = c.ExplorerSession < 0 || uint32(c.ExplorerSession) == c.SessionID
+	c.BrokerAvailable = !c.Elevated && scheduledTaskExists(elevatedRepairTask)
+
+	// Missing file rights need elevation (broker); a session mismatch needs
+	// the user's own context (trampoline), which the broker also provides
+	// because it runs as the interactive user.
+	switch {
+	case c.canRepairDirect():
+		c.RepairRoute = routeDirect
+	case c.BrokerAvailable:
+		c.RepairRoute = routeBroker
+	default:
+		c.RepairRoute = routeTrampoline
+	}
+	return c
+}
+
+// canWriteDir checks that files can be created and deleted in dir, which is
+// what the repair needs. A missing directory counts as writable: Explorer
+// recreates it and there is nothing to delete.
+func canWriteDir(dir string) bool {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return true
+	}
+	probe := filepath.Join(dir, ".icon-cache-watchdog.probe")
+	f, err := os.OpenFile(probe, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return os.Remove(probe) == nil
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+// privilege_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "os"
+
+func isElevated() bool { return os.Geteuid() == 0 }
+
+func integrityLevel() string { return "n/a" }
+
+func currentSessionID() uint32 { return 0 }
+
+func explorerSessionID() int { return 0 }
@@ -0,0 +1,111 @@
+// privilege_windows.go
+// Token queries for elevation, integrity level and session id.
+// Uses only the standard syscall package.
+
+package watchdog
+
+import (
+	"encoding/csv"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tokenSessionID      = 12
+	tokenElevation      = 20
+	tokenIntegrityLevel = 25
+)
+
+func tokenInfo(class uint32) []byte {
+	t, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return nil
+	}
+	defer t.Close()
+	var n uint32
+	syscall.GetTokenInformation(t, class, nil, 0, &n)
+	if n == 0 {
+		return nil
+	}
+	buf := make([]byte, n)
+	if err := syscall.GetTokenInformation(t, class, &buf[0], n, &n); err != nil {
+		return nil
+	}
+	return buf
+}
+
+func isElevated() bool {
+	buf := tokenInfo(tokenElevation)
+	return len(buf) >= 4 && *(*uint32)(unsafe.Pointer(&buf[0])) != 0
+}
+
+func integrityLevel() string {
+	buf := tokenInfo(tokenIntegrityLevel)
+	if buf == nil {
+		return "unknown"
+	}
+	// TOKEN_MANDATORY_LABEL starts with a SID_AND_ATTRIBUTES; the RID of
+	// the label SID is its last sub-authority. SID layout: revision (1),
+	// sub-authority count (1), identifier authority (6), sub-authorities.
+	sid := unsafe.Pointer((*syscall.SIDAndAttributes)(unsafe.Pointer(&buf[0])).Sid)
+	cnt := *(*uint8)(unsafe.Add(sid, 1))
+	if cnt == 0 {
+		return "unknown"
+	}
+	rid := *(*uint32)(unsafe.Add(sid, 8+4*(int(cnt)-1)))
+	switch {
+	case rid >= 0x4000:
+		return "system"
+	case rid >= 0x3000:
+		return "high"
+	case rid >= 0x2000:
+		return "medium"
+	case rid >= 0x1000:
+		return "low"
+	default:
+		return "untrusted"
+	}
+}
+
+func currentSessionID() uint32 {
+	buf := tokenInfo(tokenSessionID)
+	if len(buf) < 4 {
+		return 0
+	}
+	return *(*uint32)(unsafe.Pointer(&buf[0]))
+}
+
+// explorerSessionID returns the session of the first explorer.exe found,
+// preferring one in our own session, or -1 when none is running.
+func explorerSessionID() int {
+	out, err := exec.Command("tasklist", "/FI", "IMAGENAME eq explorer.exe", "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		return -1
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(out))).ReadAll()
+	if err != nil {
+		return -1
+	}
+	own := int(currentSessionID())
+	found := -1
+	for _, row := range rows {
+		// "Image Name","PID","Session Name","Session#","Mem Usage"
+		if len(row) < 4 || !strings.EqualFold(row[0], "explorer.exe") {
+			continue
+		}
+		id, err := strconv.Atoi(row[3])
+		if err != nil {
+			continue
+		}
+		if id == own {
+			return id
+		}
+		if found < 0 {
+			found = id
+		}
+	}
+	return found
+}
@@ -0,0 +1,64 @@
+// processhold.go
+// Process holds. Some programs must not lose Explorer under them: a
+// recording in OBS, a render in Premiere, an installer registering shell
+// extensions. quiet.holdForProcesses lists them by image name,
+// comma-separated, with * and ? wildcards ("obs64.exe, premiere*.exe,
+// setup*.exe"; ".exe" may be left out). While one of them runs in the
+// user's session every repair is held, critical included, as for a
+// full-screen application. The check is part of holdReason, which runs
+// right before the repair script is launched, so Explorer is never stopped
+// under a listed program. The held repair runs on the first poll after the
+// last listed process exits.
+
+package watchdog
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// holdPatterns splits quiet.holdForProcesses into lower-case image name
+// patterns without ".exe".
+func holdPatterns(list string) []string {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			patterns = append(patterns, strings.TrimSuffix(p, ".exe"))
+		}
+	}
+	return patterns
+}
+
+// processHoldReason returns the listed program holding repairs, or "".
+func (d *daemon) processHoldReason() string {
+	patterns := holdPatterns(d.cfg.Quiet.HoldForProcesses)
+	if len(patterns) == 0 || d.sim != nil {
+		return ""
+	}
+	session := currentSessionID()
+	if d.session != nil {
+		session = d.session.ID
+	}
+	names, err := runningProcesses(session)
+	if err != nil {
+		return ""
+	}
+	if name := matchHoldProcess(patterns, names); name != "" {
+		return fmt.Sprintf("blocklisted process %s", name)
+	}
+	return ""
+}
+
+// matchHoldProcess returns the first of names that matches a pattern.
+func matchHoldProcess(patterns, names []string) string {
+	for _, name := range names {
+		base := strings.TrimSuffix(strings.ToLower(name), ".exe")
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, base); ok {
+				return name
+			}
+		}
+	}
+	return ""
+}
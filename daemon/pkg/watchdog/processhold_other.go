@@ -0,0 +1,27 @@
+//go:build !windows
+
+// processhold_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+// Processes are read from /proc where it is available; sessions are ignored.
+
+package watchdog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func runningProcesses(session uint32) ([]string, error) {
+	comms, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil || comms == nil {
+		return nil, os.ErrNotExist
+	}
+	var names []string
+	for _, f := range comms {
+		if b, err := os.ReadFile(f); err == nil {
+			names = append(names, strings.TrimSpace(string(b)))
+		}
+	}
+	return names, nil
+}
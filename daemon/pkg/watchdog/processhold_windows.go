@@ -0,0 +1,32 @@
+// processhold_windows.go
+// Process list of one session, from tasklist as for explorer.exe.
+
+package watchdog
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func runningProcesses(session uint32) ([]string, error) {
+	out, err := exec.Command("tasklist", "/FI", fmt.Sprintf("SESSION eq %d", session), "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(strings.NewReader(string(out)))
+	r.FieldsPerRecord = -1 // "INFO: No tasks are running ..." has one field
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, row := range rows {
+		// "Image Name","PID","Session Name","Session#","Mem Usage"
+		if len(row) >= 4 {
+			names = append(names, row[0])
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,83 @@
+// profile.go
+// Roaming / mandatory / temporary profile awareness. A cache inside a
+// profile that is thrown away at logoff gains nothing from a preemptive
+// staleness rebuild (H4), so that heuristic is skipped there. Redirection of
+// %LOCALAPPDATA% away from the profile is reported because the default cache
+// path assumption no longer holds. Non-persistent VDI is detected here as
+// well (vdi.go).
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	profileLocal     = "local"
+	profileRoaming   = "roaming"
+	profileMandatory = "mandatory"
+	profileTemporary = "temporary"
+	profileUnknown   = "unknown"
+)
+
+type profileInfo struct {
+	Type              string         `json:"type"`
+	DiscardedAtLogoff bool           `json:"discardedAtLogoff"`
+	Redirected        string         `json:"redirected,omitempty"` // %LOCALAPPDATA% outside the profile
+	VDI               vdiInfo        `json:"vdi"`
+	Container         *containerInfo `json:"container,omitempty"` // FSLogix / UPD, refreshed by every health check (container.go)
+}
+
+func (p profileInfo) String() string {
+	s := p.Type
+	if p.DiscardedAtLogoff {
+		s += " (discarded at logoff)"
+	}
+	if p.Redirected != "" {
+		s += fmt.Sprintf(", LOCALAPPDATA redirected to %s", p.Redirected)
+	}
+	if v := p.VDI.String(); v != "" {
+		s += ", " + v
+	}
+	return s
+}
+
+// detectCurrentProfile inspects the profile of the user running the daemon.
+func detectCurrentProfile() profileInfo {
+	p := profileInfo{Type: currentProfileType()}
+	p.DiscardedAtLogoff = profileDiscarded(p.Type)
+
+	local, home := os.Getenv("LOCALAPPDATA"), os.Getenv("USERPROFILE")
+	if local != "" && home != "" && !isWithin(home, local) {
+		p.Redirected = local
+	}
+	p.VDI = detectVDI(local)
+	return p
+}
+
+// detectSessionProfile inspects another user's profile (multi-session mode).
+func detectSessionProfile(s sessionInfo) profileInfo {
+	p := profileInfo{Type: profileTypeForUser(s.Domain, s.User)}
+	p.DiscardedAtLogoff = profileDiscarded(p.Type)
+	local := ""
+	if s.ProfileDir != "" {
+		local = filepath.Join(s.ProfileDir, "AppData", "Local")
+	}
+	p.VDI = detectVDI(local)
+	return p
+}
+
+// profileDiscarded: mandatory and temporary profiles never persist; roaming
+// profiles lose their local copy when the "Delete cached copies of roaming
+// profiles" policy is set.
+func profileDiscarded(typ string) bool {
+	switch typ {
+	case profileMandatory, profileTemporary:
+		return true
+	case profileRoaming:
+		return deleteRoamingCachePolicy()
+	}
+	return false
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+// profile_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func currentProfileType() string { return profileLocal }
+
+func profileTypeForUser(domain, user string) string { return profileUnknown }
+
+func deleteRoamingCachePolicy() bool { return false }
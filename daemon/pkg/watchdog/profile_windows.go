@@ -0,0 +1,146 @@
+// profile_windows.go
+// Profile type via userenv!GetProfileType (current user) and the ProfileList
+// registry key (other users, multi-session mode).
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ptTemporary = 0x1
+	ptRoaming   = 0x2
+	ptMandatory = 0x4
+
+	profileListKey = `SOFTWARE\Microsoft\Windows NT\CurrentVersion\ProfileList`
+
+	// ProfileList State flags (userenv internal, stable since NT 4).
+	profileStateMandatory = 0x0001
+	profileStateTemporary = 0x0800
+)
+
+var (
+	modUserenv         = syscall.NewLazyDLL("userenv.dll")
+	procGetProfileType = modUserenv.NewProc("GetProfileType")
+)
+
+func currentProfileType() string {
+	var flags uint32
+	if r, _, _ := procGetProfileType.Call(uintptr(unsafe.Pointer(&flags))); r == 0 {
+		return profileUnknown
+	}
+	switch {
+	case flags&ptMandatory != 0:
+		return profileMandatory
+	case flags&ptTemporary != 0:
+		return profileTemporary
+	case flags&ptRoaming != 0:
+		return profileRoaming
+	}
+	return profileLocal
+}
+
+// profileListEntry returns the ProfileList key of an account, or "".
+func profileListEntry(domain, user string) string {
+	account := user
+	if domain != "" {
+		account = domain + `\` + user
+	}
+	sid, _, _, err := syscall.LookupSID("", account)
+	if err != nil {
+		return ""
+	}
+	sidStr, err := sid.String()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(`%s\%s`, profileListKey, sidStr)
+}
+
+// profileDirForUser returns the account's ProfileImagePath or, for a
+// profile not created yet, <ProfilesDirectory>\<user>. Both honour a
+// ProfilesDirectory moved to another drive.
+func profileDirForUser(domain, user string) string {
+	if key := profileListEntry(domain, user); key != "" {
+		if data, _, err := readRegistryValue(key, "ProfileImagePath"); err == nil {
+			if dir := expandWindowsEnv(registryString(data)); dir != "" {
+				return dir
+			}
+		}
+	}
+	base := filepath.Join(os.Getenv("SystemDrive")+`\`, "Users")
+	if data, _, err := readRegistryValue(profileListKey, "ProfilesDirectory"); err == nil {
+		if dir := expandWindowsEnv(registryString(data)); dir != "" {
+			base = dir
+		}
+	}
+	return filepath.Join(base, user)
+}
+
+// expandWindowsEnv expands %NAME% references as REG_EXPAND_SZ values hold
+// them. Unknown names are left as they are.
+func expandWindowsEnv(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '%')
+		j := strings.IndexByte(s[i+1:], '%')
+		if i < 0 || j < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		name := s[i+1 : i+1+j]
+		b.WriteString(s[:i])
+		if v, ok := os.LookupEnv(name); ok && name != "" {
+			b.WriteString(v)
+		} else {
+			b.WriteString(s[i : i+2+j])
+		}
+		s = s[i+2+j:]
+	}
+}
+
+func profileTypeForUser(domain, user string) string {
+	key := profileListEntry(domain, user)
+	if key == "" {
+		return profileUnknown
+	}
+
+	var state uint32
+	if data, typ, err := readRegistryValue(key, "State"); err == nil {
+		state, _ = registryDWORD(data, typ)
+	}
+	central := ""
+	if data, _, err := readRegistryValue(key, "CentralProfile"); err == nil {
+		central = registryString(data)
+	}
+	switch {
+	case state&profileStateMandatory != 0 || isMandatoryProfilePath(central):
+		return profileMandatory
+	case state&profileStateTemporary != 0:
+		return profileTemporary
+	case central != "":
+		return profileRoaming
+	}
+	return profileLocal
+}
+
+func deleteRoamingCachePolicy() bool {
+	data, typ, err := readRegistryValue(`SOFTWARE\Policies\Microsoft\Windows\System`, "DeleteRoamingCache")
+	if err != nil {
+		return false
+	}
+	v, ok := registryDWORD(data, typ)
+	return ok && v != 0
+}
+
+// isMandatoryProfilePath: mandatory profiles are stored as <name>.man.
+func isMandatoryProfilePath(central string) bool {
+	ext := strings.ToLower(filepath.Ext(strings.TrimRight(central, `\/`)))
+	return ext == ".man"
+}
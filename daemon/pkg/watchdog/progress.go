@@ -0,0 +1,171 @@
+// progress.go
+// Repair progress. Repair-IconCache.ps1 writes one record per phase to
+// stdout:
+//
+//   ##progress files-deleting 3/7
+//
+// The daemon reads them from the child's stdout, publishes them on the
+// control pipe (control.go) and, when the script exits, publishes complete
+// or failed with the exit code. `progress` is the CLI client. The
+// "##explorer-stopped" record next to them is logged, not published
+// (explorerstop.go).
+
+package watchdog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const progressPrefix = "##progress "
+
+// parseProgress decodes one stdout line; ok is false for anything else.
+func parseProgress(line string) (ev controlEvent, ok bool) {
+	rest, found := strings.CutPrefix(strings.TrimSpace(line), progressPrefix)
+	if !found {
+		return ev, false
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return ev, false
+	}
+	ev.Phase = fields[0]
+	if len(fields) > 1 {
+		if _, err := fmt.Sscanf(fields[1], "%d/%d", &ev.Done, &ev.Total); err != nil {
+			return ev, false
+		}
+	}
+	return ev, true
+}
+
+// repairEvent stamps ev with the time, reason and session.
+func (d *daemon) repairEvent(ev controlEvent, reason string) controlEvent {
+	ev.At = d.clock.Now()
+	ev.Reason = reason
+	ev.Incident = d.incidents.id()
+	if d.session != nil {
+		id := d.session.ID
+		ev.Session = &id
+	}
+	return ev
+}
+
+// followRepair relays the progress records in stdout until the script exits,
+// then reports its outcome. done runs after the exit.
+func (d *daemon) followRepair(cmd *exec.Cmd, stdout io.Reader, reason string, done func()) {
+	timer := newRepairTimer()
+	trace := d.traceRepair(reason)
+	finishAV := d.watchAV()
+	sc := bufio.NewScanner(stdout)
+	for sc.Scan() {
+		if ev, ok := parseProgress(sc.Text()); ok {
+			timer.phase(ev.Phase)
+			trace.phaseStarted(ev.Phase)
+			d.progress.publish(d.repairEvent(ev, reason))
+		} else if rung, took, ok := parseExplorerStop(sc.Text()); ok {
+			d.explorerStopped(rung, took, reason)
+		}
+	}
+	io.Copy(io.Discard, stdout)
+	err := cmd.Wait()
+	timer.finish()
+	trace.end(err)
+	done()
+	finishAV()
+	d.recordRepairTime(timer, reason)
+	d.runRepairPlugins(reason, err == nil)
+	d.mu.Lock()
+	d.breakerRepairDone(err == nil)
+	d.mu.Unlock()
+
+	ev := controlEvent{Phase: phaseComplete}
+	if err != nil {
+		ev = controlEvent{Phase: phaseFailed, Error: err.Error()}
+		d.metrics.inc(mRepairsFailed)
+		d.logMsg(msgRepairFailed, err, reason)
+	} else {
+		d.logMsg(msgRepairCompleted, reason)
+	}
+	d.progress.publish(d.repairEvent(ev, reason))
+	if err != nil {
+		d.incidents.settle(incidentRepair, phaseFailed, fmt.Sprintf("repair script failed: %v", err), false)
+	} else {
+		d.incidents.settle(incidentRepair, phaseComplete, "repair completed", true)
+		d.startWarmUp()
+	}
+}
+
+func cmdProgress(d *daemon, args []string) int {
+	fs := newFlagSet("progress")
+	follow := fs.Bool("f", false, "keep following after a repair ends")
+	asJSON := fs.Bool("json", false, "print raw events as JSON lines")
+	addr := fs.String("addr", d.controlAddr(), "control pipe of the daemon")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	c, err := openControl(*addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot reach the daemon at %s: %v\n", *addr, err)
+		return 1
+	}
+	defer c.Close()
+	if err := c.send(controlRequest{Cmd: requestProgress}); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot reach the daemon: %v\n", err)
+		return 1
+	}
+
+	if !*asJSON {
+		fmt.Println("Waiting for repair progress (Ctrl+C to stop)...")
+	}
+	for {
+		ev, raw, err := c.next()
+		if err != nil {
+			break
+		}
+		if ev.Type == eventError {
+			fmt.Fprintf(os.Stderr, "Daemon: %s\n", ev.Error)
+			return 1
+		}
+		if *asJSON {
+			printJSONLine("progress", raw)
+		} else {
+			fmt.Println(ev.describe())
+		}
+		if ev.finished() && !*follow {
+			if ev.Phase == phaseFailed {
+				return 1
+			}
+			return 0
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Daemon closed the control pipe.")
+	return 1
+}
+
+// describe renders ev as one progress line.
+func (ev controlEvent) describe() string {
+	var b strings.Builder
+	b.WriteString(ev.At.Local().Format("15:04:05") + "  ")
+	if ev.Session != nil {
+		fmt.Fprintf(&b, "[S%d] ", *ev.Session)
+	}
+	b.WriteString(ev.Phase)
+	switch {
+	case ev.Total > 0:
+		fmt.Fprintf(&b, " %d/%d %s", ev.Done, ev.Total, progressBar(ev.Done, ev.Total, 20))
+	case ev.Phase == phaseLaunched:
+		fmt.Fprintf(&b, " (%s)", ev.Reason)
+	case ev.Error != "":
+		fmt.Fprintf(&b, ": %s", ev.Error)
+	}
+	return b.String()
+}
+
+func progressBar(done, total, width int) string {
+	n := min(width, done*width/total)
+	return "[" + strings.Repeat("#", n) + strings.Repeat(".", width-n) + "]"
+}
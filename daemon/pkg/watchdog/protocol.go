@@ -0,0 +1,270 @@
+// protocol.go
+// Control pipe framing and negotiation. Fleets are upgraded in waves, so a
+// daemon meets CLI and tray builds older and newer than itself. API 1 is
+// line-delimited JSON: one request line, event lines back. API 2 frames
+// each message as a 4-byte big-endian length followed by that much JSON,
+// so a message may hold any byte and a reader knows where it ends without
+// scanning for a newline. A client asks for it with a hello line, and the
+// daemon answers with the version and the capabilities both sides have:
+//
+//   → {"cmd":"hello","api":2,"caps":["framed","ping"]}
+//   ← {"type":"hello","at":"...","api":2,"caps":["framed","ping"]}
+//   → <frame {"cmd":"progress"}>
+//   ← <frame {"type":"progress",...}> ...
+//
+// A client that sends no hello speaks API 1 and is answered in lines, as
+// before. A daemon that predates the hello answers it with an error event;
+// the client then connects again and sends its request as an API 1 line.
+// Capabilities are optional behaviour a client must understand before the
+// daemon uses it: "framed" switches to frames after the hello, "ping" lets
+// the daemon send ping events on an idle stream so either side notices a
+// peer that went away.
+
+package watchdog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+const (
+	// minControlAPIVersion is the oldest API the daemon still answers.
+	minControlAPIVersion = 1
+	// lineAPIVersion is the API spoken without a hello, and the only one
+	// without frames.
+	lineAPIVersion = 1
+
+	maxControlFrame  = 16 << 20
+	controlPingEvery = 30 * time.Second
+
+	capFramed = "framed"
+	capPing   = "ping"
+)
+
+var errFrameTooLarge = fmt.Errorf("frame larger than %d bytes", maxControlFrame)
+
+// controlCaps lists the capabilities this build has, on either side.
+var controlCaps = []string{capFramed, capPing}
+
+// writeFrame sends v as one length-prefixed message.
+func writeFrame(w io.Writer, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(body)), uint32(len(body)))
+	_, err = w.Write(append(buf, body...))
+	return err
+}
+
+// readFrame reads one length-prefixed message.
+func readFrame(r io.Reader) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size > maxControlFrame {
+		return nil, errFrameTooLarge
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// writeLine sends v as one API 1 line.
+func writeLine(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// sharedCaps returns the capabilities in both lists, in this build's order.
+func sharedCaps(theirs []string) []string {
+	var out []string
+	for _, c := range controlCaps {
+		if slices.Contains(theirs, c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// controlConn is the daemon's side of one connection once negotiated.
+type controlConn struct {
+	w      io.Writer
+	api    int
+	caps   []string
+	framed bool
+}
+
+func (c *controlConn) write(ev controlEvent) error {
+	if c.framed {
+		return writeFrame(c.w, ev)
+	}
+	return writeLine(c.w, ev)
+}
+
+// has reports whether the client accepted capability cap.
+func (c *controlConn) has(cap string) bool { return slices.Contains(c.caps, cap) }
+
+// readControlRequest reads the request of a connection, answering a hello
+// first. err is set when the request could not be read or parsed; a nil
+// conn means the client went away before sending anything.
+func (d *daemon) readControlRequest(rw io.ReadWriter) (*controlConn, controlRequest, error) {
+	var req controlRequest
+	r := bufio.NewReader(rw)
+	line, err := r.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, req, err
+	}
+	c := &controlConn{w: rw, api: lineAPIVersion}
+	if err := json.Unmarshal(line, &req); err != nil {
+		return c, req, errors.New("malformed request")
+	}
+	if req.Cmd != requestHello {
+		return c, req, nil
+	}
+	if req.API < minControlAPIVersion {
+		return c, req, fmt.Errorf("API version %d not supported (this daemon speaks %d to %d)", req.API, minControlAPIVersion, controlAPIVersion)
+	}
+	c.api, c.caps = min(req.API, controlAPIVersion), sharedCaps(req.Caps)
+	if c.api == lineAPIVersion {
+		c.caps = slices.DeleteFunc(c.caps, func(cap string) bool { return cap == capFramed })
+	}
+	if err := c.write(controlEvent{Type: eventHello, At: d.clock.Now(), API: c.api, Caps: c.caps}); err != nil {
+		return nil, req, err
+	}
+	c.framed = c.has(capFramed)
+	req = controlRequest{}
+	if c.framed {
+		line, err = readFrame(r)
+	} else if line, err = r.ReadBytes('\n'); len(line) > 0 {
+		err = nil
+	}
+	if errors.Is(err, errFrameTooLarge) {
+		return c, req, err
+	}
+	if err != nil {
+		return nil, req, err
+	}
+	if err := json.Unmarshal(line, &req); err != nil {
+		return c, req, errors.New("malformed request")
+	}
+	return c, req, nil
+}
+
+// controlClient is a connection to the daemon from the CLI (or a test).
+type controlClient struct {
+	addr   string
+	conn   io.ReadWriteCloser
+	r      *bufio.Reader
+	api    int
+	caps   []string
+	framed bool
+}
+
+// openControl connects to the daemon at addr; the protocol is negotiated
+// when the request is sent.
+func openControl(addr string) (*controlClient, error) {
+	conn, err := dialControl(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &controlClient{addr: addr, conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (c *controlClient) Close() error { return c.conn.Close() }
+
+// send negotiates the newest API both sides speak and sends req. A daemon
+// that predates the hello is asked again in an API 1 line.
+func (c *controlClient) send(req controlRequest) error {
+	if err := writeLine(c.conn, controlRequest{Cmd: requestHello, API: controlAPIVersion, Caps: controlCaps}); err != nil {
+		return err
+	}
+	line, err := c.r.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return err
+	}
+	var ev controlEvent
+	if err := json.Unmarshal(line, &ev); err != nil {
+		return fmt.Errorf("malformed reply to hello: %w", err)
+	}
+	switch ev.Type {
+	case eventHello:
+		c.api, c.caps, c.framed = ev.API, ev.Caps, slices.Contains(ev.Caps, capFramed)
+	case eventError:
+		c.conn.Close()
+		conn, err := dialControl(c.addr)
+		if err != nil {
+			return err
+		}
+		c.conn, c.r, c.api, c.caps = conn, bufio.NewReader(conn), lineAPIVersion, nil
+	default:
+		return fmt.Errorf("unexpected %q reply to hello", ev.Type)
+	}
+	if req.API > c.api {
+		return fmt.Errorf("API version %d not supported (the daemon speaks %d)", req.API, c.api)
+	}
+	if c.framed {
+		return writeFrame(c.conn, req)
+	}
+	return writeLine(c.conn, req)
+}
+
+// next reads the next event, skipping pings; raw is the message as sent.
+// An error event is returned as an event.
+func (c *controlClient) next() (ev controlEvent, raw []byte, err error) {
+	for {
+		if c.framed {
+			raw, err = readFrame(c.r)
+		} else if raw, err = c.r.ReadBytes('\n'); len(raw) > 0 {
+			err = nil
+		}
+		if err != nil {
+			return ev, nil, err
+		}
+		ev = controlEvent{}
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			return ev, nil, err
+		}
+		if ev.Type != eventPing {
+			return ev, raw, nil
+		}
+	}
+}
+
+// call sends req and reads the one event that answers it. An error event is
+// returned as an error.
+func (c *controlClient) call(req controlRequest) (controlEvent, error) {
+	if err := c.send(req); err != nil {
+		return controlEvent{}, err
+	}
+	ev, _, err := c.next()
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return ev, err
+	}
+	if ev.Type == eventError {
+		return ev, fmt.Errorf("daemon: %s", ev.Error)
+	}
+	return ev, nil
+}
+
+// pings returns a ticker channel for ping events on a stream, or nil when
+// the client did not accept them.
+func (d *daemon) pings(c *controlConn) (<-chan time.Time, func()) {
+	if !c.has(capPing) {
+		return nil, func() {}
+	}
+	t := d.clock.NewTicker(controlPingEvery)
+	return t.C(), t.Stop
+}
@@ -0,0 +1,54 @@
+// protocol_test.go
+// Control pipe frames and capability negotiation.
+
+package watchdog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	msg := controlEvent{Type: eventProgress, Reason: "line one\nline two"}
+	if err := writeFrame(&buf, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFrame(&buf, controlEvent{Type: eventPing}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"reason":"line one\nline two"`, `"type":"ping"`} {
+		body, err := readFrame(&buf)
+		if err != nil || !bytes.Contains(body, []byte(want)) {
+			t.Fatalf("frame = %s, %v; want %s", body, err, want)
+		}
+	}
+	if _, err := readFrame(&buf); err != io.EOF {
+		t.Fatalf("after the last frame: %v", err)
+	}
+
+	// A frame over the limit is refused before its body is read; a short
+	// one is an error, not a message.
+	if _, err := readFrame(bytes.NewReader([]byte{0x7f, 0, 0, 0})); !errors.Is(err, errFrameTooLarge) {
+		t.Fatalf("oversized frame: %v", err)
+	}
+	if _, err := readFrame(bytes.NewReader([]byte{0, 0, 0, 9, '{', '}'})); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated frame: %v", err)
+	}
+}
+
+func TestSharedCaps(t *testing.T) {
+	for _, c := range []struct{ theirs, want []string }{
+		{[]string{capPing, "future", capFramed}, []string{capFramed, capPing}},
+		{[]string{capPing}, []string{capPing}},
+		{[]string{"future"}, nil},
+		{nil, nil},
+	} {
+		if got := sharedCaps(c.theirs); !slices.Equal(got, c.want) {
+			t.Errorf("sharedCaps(%q) = %q, want %q", c.theirs, got, c.want)
+		}
+	}
+}
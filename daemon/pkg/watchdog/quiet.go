@@ -0,0 +1,132 @@
+// quiet.go
+// Quiet hours and Focus Assist. While the user has Focus Assist on, or
+// during the configured quiet hours, non-critical repairs (which restart
+// Explorer under the user's hands) are deferred and replayed on the first
+// poll after the quiet period ends. Critical repairs — a missing or corrupt
+// index, where icons are already broken — still run, unless a full-screen
+// application or a listed program holds them (fullscreen.go,
+// processhold.go). quietReason is also the single check future
+// notification code must consult before showing anything.
+
+package watchdog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type repairPriority int
+
+const (
+	priorityNormal repairPriority = iota
+	priorityCritical
+)
+
+type quietOptions struct {
+	// RespectFocusAssist defers repairs while Focus Assist is on
+	// (priority-only or alarms-only). Defaults to true.
+	RespectFocusAssist bool `json:"respectFocusAssist"`
+
+	// Hours is a daily local-time window such as "22:00-07:00". Empty
+	// disables quiet hours.
+	Hours string `json:"hours"`
+
+	// HoldDuringFullScreen holds every repair, critical included, while a
+	// full-screen application is in front (fullscreen.go). Defaults to true.
+	HoldDuringFullScreen bool `json:"holdDuringFullScreen"`
+
+	// HoldForProcesses lists programs, comma-separated, whose presence
+	// holds every repair (processhold.go). Empty by default.
+	HoldForProcesses string `json:"holdForProcesses"`
+}
+
+// holdReason returns why a repair of the given priority must wait, or ""
+// when it may run now.
+func (d *daemon) holdReason(prio repairPriority) string {
+	if r := d.fullScreenReason(); r != "" {
+		return r
+	}
+	if r := d.processHoldReason(); r != "" {
+		return r
+	}
+	if prio < priorityCritical {
+		if r := d.quietReason(); r != "" {
+			return r
+		}
+		return d.networkReason()
+	}
+	return ""
+}
+
+// quietReason returns why the user should not be disturbed right now, or
+// "" when repairs and notifications may proceed.
+func (d *daemon) quietReason() string {
+	q := d.cfg.Quiet
+	if start, end, ok := parseQuietHours(q.Hours); ok && inDailyWindow(d.clock.Now(), start, end) {
+		return fmt.Sprintf("quiet hours %s", q.Hours)
+	}
+	// Focus Assist is per interactive user; in multi-session mode the
+	// LocalSystem daemon cannot observe it.
+	if q.RespectFocusAssist && d.session == nil {
+		switch state, ok := focusAssistState(); {
+		case !ok:
+		case state == 1:
+			return "Focus Assist (priority only)"
+		case state == 2:
+			return "Focus Assist (alarms only)"
+		}
+	}
+	return ""
+}
+
+// deferRepair records a held repair for later. A critical request is never
+// downgraded by a later normal one. Caller holds d.mu.
+func (d *daemon) deferRepair(reason string, prio repairPriority, why string) {
+	if d.deferredReason == "" {
+		d.logMsg(msgRepairDeferred, why, reason)
+		d.historyEvent(historyDeferred, fmt.Sprintf("%s (%s)", reason, why))
+	}
+	if d.deferredReason == "" || prio >= d.deferredPrio {
+		d.deferredReason = reason
+		d.deferredPrio = prio
+	}
+}
+
+// replayDeferredRepair runs a deferred repair once nothing holds it anymore.
+func (d *daemon) replayDeferredRepair() {
+	d.mu.Lock()
+	reason, prio := d.deferredReason, d.deferredPrio
+	d.mu.Unlock()
+	if reason == "" || d.holdReason(prio) != "" {
+		return
+	}
+	d.mu.Lock()
+	d.deferredReason = ""
+	d.mu.Unlock()
+	d.repairLog_("INFO", "Hold released. Running deferred repair.")
+	d.triggerRepair(reason+" (deferred)", prio)
+}
+
+// parseQuietHours parses "HH:MM-HH:MM" into minutes after midnight.
+func parseQuietHours(s string) (start, end int, ok bool) {
+	from, to, found := strings.Cut(strings.TrimSpace(s), "-")
+	if !found {
+		return 0, 0, false
+	}
+	a, err1 := time.Parse("15:04", strings.TrimSpace(from))
+	b, err2 := time.Parse("15:04", strings.TrimSpace(to))
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return a.Hour()*60 + a.Minute(), b.Hour()*60 + b.Minute(), true
+}
+
+// inDailyWindow handles windows that wrap past midnight.
+func inDailyWindow(t time.Time, start, end int) bool {
+	m := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return m >= start && m < end
+	}
+	return m >= start || m < end
+}
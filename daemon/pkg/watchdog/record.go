@@ -0,0 +1,124 @@
+// record.go
+// `record` captures a cache trace (trace.go) for `simulate` and for sharing
+// with maintainers when tuning heuristics. It polls like Layer B and writes
+// a snapshot of file names, sizes and modification times whenever the cache
+// changes, plus a keepalive snapshot every 5 minutes while it does not —
+// enough to replay health checks on schedule while keeping a multi-day
+// trace small. Icon content is never read.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+const traceKeepalive = 5 * time.Minute
+
+func cmdRecord(d *daemon, args []string) int {
+	fs := newFlagSet("record")
+	out := fs.String("o", "", "trace file (default <dataDir>\\traces\\trace-<timestamp>.jsonl)")
+	every := fs.Duration("every", 30*time.Second, "poll interval")
+	duration := fs.Duration("duration", 0, "stop after this long (0 = until Ctrl+C)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *every <= 0 {
+		fmt.Fprintln(os.Stderr, "-every must be positive")
+		return 2
+	}
+	path := *out
+	if path == "" {
+		path = filepath.Join(d.dataDir, "traces", "trace-"+d.clock.Now().Format("20060102-150405")+".jsonl")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create trace directory: %v\n", err)
+		return 1
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create trace: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	if err := enc.Encode(traceHeader{Version: traceVersion, Profile: detectCurrentProfile().Type}); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write trace: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Recording %s every %s to %s (Ctrl+C to stop)\n", d.cacheDir, *every, path)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	ticker := d.clock.NewTicker(*every)
+	defer ticker.Stop()
+	var deadline time.Time
+	if *duration > 0 {
+		// Snapshot times are truncated to the second; so is the deadline,
+		// or the poll that reaches it would not end the recording.
+		deadline = d.clock.Now().Truncate(time.Second).Add(*duration)
+	}
+
+	var last traceSnapshot
+	written := 0
+	for {
+		snap := d.traceSnapshot()
+		if written == 0 || snap.At.Sub(last.At) >= traceKeepalive || !sameTraceState(snap, last) {
+			if err := enc.Encode(snap); err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot write trace: %v\n", err)
+				return 1
+			}
+			last = snap
+			written++
+		}
+		if !deadline.IsZero() && !snap.At.Before(deadline) {
+			break
+		}
+		interrupted := false
+		select {
+		case <-ticker.C():
+		case <-stop:
+			interrupted = true
+		}
+		if interrupted {
+			break
+		}
+	}
+	fmt.Printf("Wrote %d snapshots to %s\n", written, path)
+	return 0
+}
+
+// traceSnapshot captures the current cache metadata.
+func (d *daemon) traceSnapshot() traceSnapshot {
+	explorer := d.explorerRunning()
+	snap := traceSnapshot{
+		At:       d.clock.Now().UTC().Truncate(time.Second),
+		Explorer: &explorer,
+		Files:    []traceFile{},
+	}
+	for _, fi := range d.getCacheFiles() {
+		snap.Files = append(snap.Files, traceFile{
+			Name:    fi.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime().UTC().Truncate(time.Second),
+		})
+	}
+	return snap
+}
+
+func sameTraceState(a, b traceSnapshot) bool {
+	if *a.Explorer != *b.Explorer || len(a.Files) != len(b.Files) {
+		return false
+	}
+	for i := range a.Files {
+		x, y := a.Files[i], b.Files[i]
+		if x.Name != y.Name || x.Size != y.Size || !x.ModTime.Equal(y.ModTime) {
+			return false
+		}
+	}
+	return true
+}
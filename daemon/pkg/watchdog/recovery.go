@@ -0,0 +1,78 @@
+// recovery.go
+// Startup recovery. A direct repair is recorded in state.json while the
+// script runs. If the daemon or the machine died in the middle, the next
+// start finds the record and finishes the job: it waits for a script that is
+// still running, then verifies that Explorer is up and the cache is
+// consistent (H1, H3), and re-runs the repair — cooldown notwithstanding —
+// if either is not. Repairs handed to the broker or the repair task are not
+// tracked; those run outside the daemon.
+
+package watchdog
+
+import (
+	"fmt"
+	"time"
+)
+
+// A script still "running" after this long is a reused PID, not our repair.
+const interruptedRepairMaxAge = 10 * time.Minute
+
+type repairInFlight struct {
+	Reason    string    `json:"reason"`
+	DeepClean bool      `json:"deepClean,omitempty"`
+	Started   time.Time `json:"started"`
+	PID       int       `json:"pid"`
+}
+
+// recoverInterruptedRepair runs once at startup, after loadState.
+func (d *daemon) recoverInterruptedRepair() {
+	d.mu.Lock()
+	r := d.inFlight
+	d.mu.Unlock()
+	if r == nil {
+		return
+	}
+	d.repairLog_("WARN", fmt.Sprintf("Previous run ended during a repair started %s (pid %d): %s", formatTime(r.Started), r.PID, r.Reason))
+
+	if d.clock.Since(r.Started) < interruptedRepairMaxAge && processAlive(r.PID) {
+		d.repairLog_("INFO", "Interrupted repair script is still running; verifying after it exits.")
+		go func() {
+			ticker := d.clock.NewTicker(2 * time.Second)
+			defer ticker.Stop()
+			for range ticker.C() {
+				if !processAlive(r.PID) || d.clock.Since(r.Started) >= interruptedRepairMaxAge {
+					break
+				}
+			}
+			d.verifyInterruptedRepair(r)
+		}()
+		return
+	}
+	d.verifyInterruptedRepair(r)
+}
+
+// verifyInterruptedRepair re-runs the repair unless Explorer is running and
+// the cache passes H1 and H3.
+func (d *daemon) verifyInterruptedRepair(r *repairInFlight) {
+	problem := ""
+	switch {
+	case !d.explorerRunning():
+		problem = "Explorer is not running"
+	case !d.checkH1Index():
+		problem = "icon cache index is missing or corrupt"
+	case !d.checkH3FileCount():
+		problem = "icon cache files are missing"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight = nil
+	d.saveState()
+	if problem == "" {
+		d.repairLog_("INFO", "Recovery: interrupted repair verified; Explorer is running and the cache is consistent.")
+		return
+	}
+	reason := fmt.Sprintf("recovery of interrupted repair (%s)", problem)
+	d.repairLog_("TRIGGER", fmt.Sprintf("Repair triggered: %s", reason))
+	d.launchRepair(reason, r.DeepClean)
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+// registry_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "errors"
+
+const (
+	regSZ     = 1
+	regBinary = 3
+)
+
+var errNoRegistry = errors.New("registry not available on this platform")
+
+func readRegistryValue(path, name string) ([]byte, uint32, error) {
+	return nil, 0, errNoRegistry
+}
+
+func registryString(data []byte) string { return string(data) }
+
+func registryDWORD(data []byte, typ uint32) (uint32, bool) { return 0, false }
+
+func registryKeyExists(path string) bool { return false }
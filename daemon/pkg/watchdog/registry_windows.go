@@ -0,0 +1,117 @@
+// registry_windows.go
+// Minimal read-only registry access built on the standard syscall package
+// (golang.org/x/sys would be a dependency). Keys are opened in the 64-bit
+// view, so the 386 build under WOW64 reads HKLM\SOFTWARE and not
+// HKLM\SOFTWARE\WOW6432Node, where the policy, the script hash and the
+// shell extensions are not; 32-bit Windows ignores the flag.
+
+package watchdog
+
+import (
+	"encoding/binary"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	regSZ     = syscall.REG_SZ
+	regBinary = syscall.REG_BINARY
+
+	errorNoMoreItems = syscall.Errno(259) // ERROR_NO_MORE_ITEMS
+
+	keyWow64_64Key = 0x0100 // KEY_WOW64_64KEY
+	regKeyRead     = syscall.KEY_READ | keyWow64_64Key
+)
+
+// readRegistryValue returns the raw data and type of a value under
+// HKEY_LOCAL_MACHINE\path.
+func readRegistryValue(path, name string) ([]byte, uint32, error) {
+	return readRegistryValueFrom(syscall.HKEY_LOCAL_MACHINE, path, name)
+}
+
+func readRegistryValueFrom(root syscall.Handle, path, name string) ([]byte, uint32, error) {
+	var key syscall.Handle
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := syscall.RegOpenKeyEx(root, p, 0, regKeyRead, &key); err != nil {
+		return nil, 0, err
+	}
+	defer syscall.RegCloseKey(key)
+
+	n, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	var typ, size uint32
+	if err := syscall.RegQueryValueEx(key, n, nil, &typ, nil, &size); err != nil {
+		return nil, 0, err
+	}
+	if size == 0 {
+		return nil, typ, nil
+	}
+	buf := make([]byte, size)
+	if err := syscall.RegQueryValueEx(key, n, nil, &typ, &buf[0], &size); err != nil {
+		return nil, 0, err
+	}
+	return buf[:size], typ, nil
+}
+
+// registryString decodes REG_SZ / REG_EXPAND_SZ data.
+func registryString(data []byte) string {
+	if len(data) < 2 {
+		return ""
+	}
+	u := unsafe.Slice((*uint16)(unsafe.Pointer(&data[0])), len(data)/2)
+	return syscall.UTF16ToString(u)
+}
+
+// registryDWORD decodes REG_DWORD data; ok is false for any other shape.
+func registryDWORD(data []byte, typ uint32) (uint32, bool) {
+	if typ != syscall.REG_DWORD || len(data) < 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(data), true
+}
+
+// registrySubkeys lists the names of the subkeys of root\path.
+func registrySubkeys(root syscall.Handle, path string) ([]string, error) {
+	var key syscall.Handle
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.RegOpenKeyEx(root, p, 0, regKeyRead, &key); err != nil {
+		return nil, err
+	}
+	defer syscall.RegCloseKey(key)
+
+	var names []string
+	buf := make([]uint16, 256) // key names are at most 255 characters
+	for i := uint32(0); ; i++ {
+		n := uint32(len(buf))
+		err := syscall.RegEnumKeyEx(key, i, &buf[0], &n, nil, nil, nil, nil)
+		if err == errorNoMoreItems {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+		names = append(names, syscall.UTF16ToString(buf[:n]))
+	}
+}
+
+// registryKeyExists reports whether HKEY_LOCAL_MACHINE\path can be opened.
+func registryKeyExists(path string) bool {
+	var key syscall.Handle
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	if err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, p, 0, regKeyRead, &key); err != nil {
+		return false
+	}
+	syscall.RegCloseKey(key)
+	return true
+}
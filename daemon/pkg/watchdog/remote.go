@@ -0,0 +1,132 @@
+// remote.go
+// Remote commands for legacy RMM tools that can only run a command or set
+// an event, not speak the control-pipe protocol. With remote.enabled the
+// daemon listens for:
+//
+//   - named events Local\IconCacheWatchdog.Repair and
+//     Local\IconCacheWatchdog.HealthCheck (auto-reset), and
+//   - the registered window message "IconCacheWatchdog.Command", broadcast
+//     or posted to the hidden window of class IconCacheWatchdogRemote, with
+//     wParam 1 (repair) or 2 (health check).
+//
+// `signal repair|healthcheck` sets the event from a script. A requested
+// repair is critical (quiet hours do not defer it) but still honours the
+// cooldown and full-screen hold. Single-user mode only.
+
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	remoteRepair      = "repair"
+	remoteHealthCheck = "healthcheck"
+
+	remoteMessageName = "IconCacheWatchdog.Command"
+	remoteWindowClass = "IconCacheWatchdogRemote"
+)
+
+// remoteCommands is in wParam order: the window message carries index+1.
+var remoteCommands = []string{remoteRepair, remoteHealthCheck}
+
+var errRemoteUnsupported = errors.New("remote commands need Windows")
+
+type remoteOptions struct {
+	Enabled bool `json:"enabled"`
+}
+
+func remoteEventName(cmd string) string {
+	switch cmd {
+	case remoteRepair:
+		return `Local\IconCacheWatchdog.Repair`
+	case remoteHealthCheck:
+		return `Local\IconCacheWatchdog.HealthCheck`
+	}
+	return ""
+}
+
+// serveRemote starts listening for remote commands (remote_windows.go).
+func (d *daemon) serveRemote() {
+	if !d.cfg.Remote.Enabled || d.session != nil {
+		return
+	}
+	handle := func(cmd, via string) {
+		// Events and window messages do not say who sent them.
+		d.recordCommand(via, controlPeer{User: "unknown sender"}, cmd, "requested")
+		d.runRemote(cmd, via)
+	}
+	if err := listenRemote(handle); err != nil {
+		d.ipcLog_("WARN", fmt.Sprintf("Remote commands unavailable: %v", err))
+		return
+	}
+	d.ipcLog_("INFO", fmt.Sprintf("Remote commands listening on events %s, %s and window message %q",
+		remoteEventName(remoteRepair), remoteEventName(remoteHealthCheck), remoteMessageName))
+}
+
+// runRemote carries out a remote command; via names the channel for the log.
+func (d *daemon) runRemote(cmd, via string) {
+	d.ipcLog_("INFO", fmt.Sprintf("Remote %s requested via %s", cmd, via))
+	switch cmd {
+	case remoteRepair:
+		d.triggerRepair(fmt.Sprintf("remote request via %s", via), priorityCritical)
+	case remoteHealthCheck:
+		// Run on the health-check goroutine; one pending request is enough.
+		select {
+		case d.healthNow <- "requested via " + via:
+		default:
+		}
+	}
+}
+
+type repairOutcome struct {
+	Started bool   `json:"started"`
+	Result  string `json:"result"`
+}
+
+// requestRepair runs a remote repair request from a client that waits for
+// the outcome (dashboard.go, control pipe).
+func (d *daemon) requestRepair(via string) repairOutcome {
+	started, deferred := d.attemptRepair(func() { d.runRemote(remoteRepair, via) })
+	switch {
+	case started:
+		return repairOutcome{true, "Repair started."}
+	case deferred:
+		return repairOutcome{false, "Repair deferred; it runs once the hold ends."}
+	}
+	d.mu.Lock()
+	paused := d.pausedReason()
+	d.mu.Unlock()
+	if paused != "" {
+		return repairOutcome{false, fmt.Sprintf("Repair not started: watchdog %s. Resume it first.", paused)}
+	}
+	return repairOutcome{false, "Repair not started (cooldown or refused); see the log."}
+}
+
+// attemptRepair calls trigger and reports whether it started a repair or
+// left one deferred.
+func (d *daemon) attemptRepair(trigger func()) (started, deferred bool) {
+	d.mu.Lock()
+	before := d.lastRepair
+	d.mu.Unlock()
+	trigger()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastRepair != before, d.deferredReason != ""
+}
+
+// cmdSignal sets a remote-command event of the running daemon.
+func cmdSignal(d *daemon, args []string) int {
+	if len(args) != 1 || remoteEventName(args[0]) == "" {
+		fmt.Fprintf(os.Stderr, "usage: signal %s|%s\n", remoteRepair, remoteHealthCheck)
+		return 2
+	}
+	if err := signalRemote(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot signal the daemon: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Signalled %s.\n", remoteEventName(args[0]))
+	return 0
+}
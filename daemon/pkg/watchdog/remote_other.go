@@ -0,0 +1,10 @@
+//go:build !windows
+
+// remote_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func listenRemote(handle func(cmd, via string)) error { return errRemoteUnsupported }
+
+func signalRemote(cmd string) error { return errRemoteUnsupported }
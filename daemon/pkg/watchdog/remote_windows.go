@@ -0,0 +1,145 @@
+// remote_windows.go
+// Named events and a hidden top-level window for remote commands. The
+// window is never shown; it exists so HWND_BROADCAST reaches the daemon
+// (message-only windows do not receive broadcasts).
+
+package watchdog
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	eventModifyState = 0x0002
+	waitInfinite     = 0xFFFFFFFF
+)
+
+var (
+	modUser32                  = syscall.NewLazyDLL("user32.dll")
+	procCreateEventW           = modKernel32.NewProc("CreateEventW")
+	procOpenEventW             = modKernel32.NewProc("OpenEventW")
+	procSetEvent               = modKernel32.NewProc("SetEvent")
+	procWaitForMultipleObjects = modKernel32.NewProc("WaitForMultipleObjects")
+	procRegisterWindowMessageW = modUser32.NewProc("RegisterWindowMessageW")
+	procRegisterClassExW       = modUser32.NewProc("RegisterClassExW")
+	procCreateWindowExW        = modUser32.NewProc("CreateWindowExW")
+	procDefWindowProcW         = modUser32.NewProc("DefWindowProcW")
+	procGetMessageW            = modUser32.NewProc("GetMessageW")
+	procDispatchMessageW       = modUser32.NewProc("DispatchMessageW")
+)
+
+type wndClassEx struct {
+	Size       uint32
+	Style      uint32
+	WndProc    uintptr
+	ClsExtra   int32
+	WndExtra   int32
+	Instance   uintptr
+	Icon       uintptr
+	Cursor     uintptr
+	Background uintptr
+	MenuName   *uint16
+	ClassName  *uint16
+	IconSm     uintptr
+}
+
+type winMsg struct {
+	Hwnd    uintptr
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	Pt      struct{ X, Y int32 }
+	Private uint32
+}
+
+func listenRemote(handle func(cmd, via string)) error {
+	events := make([]uintptr, len(remoteCommands))
+	for i, cmd := range remoteCommands {
+		name, err := syscall.UTF16PtrFromString(remoteEventName(cmd))
+		if err != nil {
+			return err
+		}
+		h, _, err := procCreateEventW.Call(0, 0, 0, uintptr(unsafe.Pointer(name)))
+		if h == 0 {
+			return fmt.Errorf("CreateEvent %s: %w", remoteEventName(cmd), err)
+		}
+		events[i] = h
+	}
+	go func() {
+		for {
+			r, _, _ := procWaitForMultipleObjects.Call(uintptr(len(events)), uintptr(unsafe.Pointer(&events[0])), 0, waitInfinite)
+			if r >= uintptr(len(events)) {
+				return // WAIT_FAILED
+			}
+			handle(remoteCommands[r], "event "+remoteEventName(remoteCommands[r]))
+		}
+	}()
+
+	ready := make(chan error, 1)
+	go runRemoteWindow(handle, ready)
+	return <-ready
+}
+
+// runRemoteWindow owns the hidden window and its message loop, so it keeps
+// its OS thread.
+func runRemoteWindow(handle func(cmd, via string), ready chan<- error) {
+	runtime.LockOSThread()
+	msgName, _ := syscall.UTF16PtrFromString(remoteMessageName)
+	msgID, _, err := procRegisterWindowMessageW.Call(uintptr(unsafe.Pointer(msgName)))
+	if msgID == 0 {
+		ready <- fmt.Errorf("RegisterWindowMessage: %w", err)
+		return
+	}
+	proc := syscall.NewCallback(func(hwnd, msg, wParam, lParam uintptr) uintptr {
+		if msg == msgID {
+			if wParam >= 1 && wParam <= uintptr(len(remoteCommands)) {
+				go handle(remoteCommands[wParam-1], "window message")
+			}
+			return 0
+		}
+		r, _, _ := procDefWindowProcW.Call(hwnd, msg, wParam, lParam)
+		return r
+	})
+	className, _ := syscall.UTF16PtrFromString(remoteWindowClass)
+	wc := wndClassEx{WndProc: proc, ClassName: className}
+	wc.Size = uint32(unsafe.Sizeof(wc))
+	if atom, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&wc))); atom == 0 {
+		ready <- fmt.Errorf("RegisterClassEx: %w", err)
+		return
+	}
+	hwnd, _, err := procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(className)), uintptr(unsafe.Pointer(className)),
+		0, 0, 0, 0, 0, 0, 0, 0, 0)
+	if hwnd == 0 {
+		ready <- fmt.Errorf("CreateWindowEx: %w", err)
+		return
+	}
+	ready <- nil
+
+	var m winMsg
+	for {
+		if r, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0); int32(r) <= 0 {
+			return
+		}
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&m)))
+	}
+}
+
+func signalRemote(cmd string) error {
+	name, err := syscall.UTF16PtrFromString(remoteEventName(cmd))
+	if err != nil {
+		return err
+	}
+	h, _, err := procOpenEventW.Call(eventModifyState, 0, uintptr(unsafe.Pointer(name)))
+	if h == 0 {
+		return fmt.Errorf("%s: %w (is the daemon running with remote.enabled?)", remoteEventName(cmd), err)
+	}
+	defer syscall.CloseHandle(syscall.Handle(h))
+	if r, _, err := procSetEvent.Call(h); r == 0 {
+		return fmt.Errorf("SetEvent: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// repair.go
+// `repair`: one repair through the daemon's own logic, for admins and for
+// the Layer A task (explorer.exe crash, hang, resume) when no daemon runs
+// to take its trigger (spool.go). Tasks registered by earlier versions run
+// `repair -reason=event` directly, so event-triggered repairs share the
+// cooldown and history in state.json, the health evaluation and the log
+// files with Layers B–D either way.
+//
+// Unless -force is given, the cooldown and the full-screen / quiet-hours
+// hold apply, and the repair only runs when the health score is below the
+// threshold or the cache exceeds the size limit. A request left by the
+// daemon's trampoline route (trampoline.go) has passed those checks already.
+// The command waits for the script and exits 1 when it fails.
+
+package watchdog
+
+import (
+	"fmt"
+	"time"
+)
+
+func cmdRepair(d *daemon, args []string) int {
+	fs := newFlagSet("repair")
+	reason := fs.String("reason", "manual", "trigger reason for the logs (the EventRepair task passes \"event\")")
+	force := fs.Bool("force", false, "repair now, ignoring cooldown, holds and health")
+	deepClean := fs.Bool("deep-clean", false, "full rebuild, as the scheduled deep clean (implies -force)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	d.loadState()
+	d.started = d.clock.Now()
+	d.profile = detectCurrentProfile()
+	d.caps = d.probeCapabilities()
+	d.resolveCachePath()
+
+	d.mu.Lock()
+	req := d.takeRepairRequest()
+	d.mu.Unlock()
+	why := *reason
+	var extra []string
+	switch {
+	case req != nil:
+		why = req.Reason
+		if req.Thumbcache {
+			extra = append(extra, "-IncludeThumbcache")
+		}
+		d.repairLog_("INFO", fmt.Sprintf("Repair handed over by the daemon via %s: %s", eventRepairTask, why))
+	case *force || *deepClean:
+		d.repairLog_("INFO", fmt.Sprintf("Forced repair requested from the command line: %s", why))
+	default:
+		if msg := d.repairSkipReason(); msg != "" {
+			d.repairLog_("WARN", fmt.Sprintf("%s Skipping repair. Reason was: %s", msg, why))
+			fmt.Println(msg, "Skipping repair.")
+			return 0
+		}
+		d.healthLog_("INFO", fmt.Sprintf("--- Health check running (repair command, reason: %s) ---", why))
+		res, sizeMB, needed := d.assessCache()
+		if !needed {
+			d.repairLog_("INFO", fmt.Sprintf("No repair needed (health score %d, cache %.2f MB). Reason was: %s", res.Score, sizeMB, why))
+			fmt.Printf("No repair needed (health score %d, cache %.2f MB).\n", res.Score, sizeMB)
+			return 0
+		}
+		why = fmt.Sprintf("%s (health score %d, cache %.2f MB)", why, res.Score, sizeMB)
+	}
+
+	ok, outcome := d.repairAndWait(why, *deepClean, extra...)
+	fmt.Println(outcome)
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// assessCache runs the health evaluation without repairing; needed is set
+// when the score is below the threshold or the cache exceeds the size limit.
+func (d *daemon) assessCache() (res healthResult, sizeMB float64, needed bool) {
+	res = d.evaluateHealth(false)
+	sizeMB = d.getCacheSizeMB()
+	return res, sizeMB, res.RepairNeeded || sizeMB > d.cfg.Thresholds.SizeLimit.MB()
+}
+
+// repairAndWait starts the repair script and waits for its outcome, which
+// it returns as one line. extra is passed on to the script.
+func (d *daemon) repairAndWait(reason string, deepClean bool, extra ...string) (ok bool, outcome string) {
+	events := d.progress.subscribe()
+	defer d.progress.unsubscribe(events)
+	d.mu.Lock()
+	d.repairLog_("TRIGGER", fmt.Sprintf("Repair triggered: %s", reason))
+	started := d.startRepairScript(reason, deepClean, extra...)
+	d.mu.Unlock()
+	if !started {
+		return false, "Repair could not be started; see the logs."
+	}
+	d.metrics.inc(mRepairsStarted)
+	d.updateReport(func(r *dailyReport) { r.Repairs++ })
+
+	timeout := time.After(interruptedRepairMaxAge)
+	for {
+		select {
+		case ev := <-events:
+			if ev.finished() {
+				return ev.Phase != phaseFailed, ev.describe()
+			}
+		case <-timeout:
+			return false, "Repair still running; not waiting any longer."
+		}
+	}
+}
+
+// repairSkipReason applies the cooldown, report-only mode, a pause, the free
+// space guard and the normal-priority hold, as triggerRepair does for the
+// daemon, and returns why a repair must not run.
+func (d *daemon) repairSkipReason() string {
+	if why := d.optOutReason(); why != "" {
+		return fmt.Sprintf("User opted out (%s).", why)
+	}
+	d.mu.Lock()
+	left := d.cooldownLeft()
+	d.mu.Unlock()
+	if left > 0 {
+		return fmt.Sprintf("Cooldown active (%s remaining).", left)
+	}
+	if why := d.reportOnlyReason(); why != "" {
+		return fmt.Sprintf("Report-only (%s).", why)
+	}
+	d.mu.Lock()
+	paused, lowDisk := d.pausedReason(), d.diskSpaceReason()
+	d.mu.Unlock()
+	if paused != "" {
+		return fmt.Sprintf("Watchdog %s.", paused)
+	}
+	if lowDisk != "" {
+		return fmt.Sprintf("Low disk space: %s.", lowDisk)
+	}
+	if why := d.holdReason(priorityNormal); why != "" {
+		return fmt.Sprintf("Repair held (%s).", why)
+	}
+	return ""
+}
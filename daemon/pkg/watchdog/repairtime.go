@@ -0,0 +1,75 @@
+// repairtime.go
+// Repair duration and Explorer downtime. A direct repair normally takes a
+// few seconds; one that takes minutes usually means a slow or failing disk
+// or a scanner holding the cache files (av.go). followRepair times every
+// direct repair from launch to exit, and Explorer's downtime from the
+// explorer-stopping phase to the first phase after explorer-starting (or
+// the exit, when the script failed in between). Both go into the
+// iconcache_repair_seconds and iconcache_explorer_down_seconds summaries
+// (p50 and p95) and into the history, which `history` summarises the same
+// way. A repair longer than thresholds.slowRepair is logged as a warning,
+// written to the Application log (event 115) and kept as a "slow" history
+// event. Repairs handed to the broker or the repair task are not timed.
+
+package watchdog
+
+import (
+	"fmt"
+	"time"
+)
+
+// repairTimer follows the progress phases of one repair.
+type repairTimer struct {
+	start       time.Time
+	stopped     time.Time // explorer-stopping
+	starting    bool      // explorer-starting seen
+	restarted   time.Time // first phase after explorer-starting
+	Duration    time.Duration
+	ExplorerOff time.Duration
+}
+
+func newRepairTimer() *repairTimer { return &repairTimer{start: time.Now()} }
+
+func (t *repairTimer) phase(p string) {
+	now := time.Now()
+	switch {
+	case p == phaseExplorerStopping && t.stopped.IsZero():
+		t.stopped = now
+	case p == phaseExplorerStarting:
+		t.starting = true
+	case t.starting && t.restarted.IsZero():
+		t.restarted = now
+	}
+}
+
+// finish ends the timing when the script has exited.
+func (t *repairTimer) finish() {
+	end := time.Now()
+	t.Duration = end.Sub(t.start)
+	if t.stopped.IsZero() {
+		return
+	}
+	if t.restarted.IsZero() {
+		t.restarted = end
+	}
+	t.ExplorerOff = t.restarted.Sub(t.stopped)
+}
+
+// recordRepairTime logs, measures and keeps the timing of a finished
+// direct repair, and raises the slow-repair alert.
+func (d *daemon) recordRepairTime(t *repairTimer, reason string) {
+	d.metrics.observe(mRepairSeconds, t.Duration.Seconds())
+	if !t.stopped.IsZero() {
+		d.metrics.observe(mExplorerDownSeconds, t.ExplorerOff.Seconds())
+	}
+	d.historyRepairTime(t)
+	msg := fmt.Sprintf("Repair took %s; Explorer was down %s.", t.Duration.Round(10*time.Millisecond), t.ExplorerOff.Round(10*time.Millisecond))
+	limit := d.cfg.Thresholds.SlowRepair.D()
+	if t.Duration <= limit {
+		d.repairLog_("INFO", msg)
+		return
+	}
+	d.logMsg(msgRepairSlow, t.Duration.Round(time.Second), d.cfg.Thresholds.SlowRepair, t.ExplorerOff.Round(time.Second), reason)
+	d.historyEvent(historySlow, fmt.Sprintf("%s took %s", reason, t.Duration.Round(time.Second)))
+	d.metrics.inc(mSlowRepairs)
+}
@@ -0,0 +1,160 @@
+// reportoutbox.go
+// Delivery of the reports to a shared reports.dir. When thousands of
+// machines write to one share, writing the moment a report is due makes
+// them all arrive at once — every hour, and above all just after midnight —
+// and a machine that is offline (a laptop on the road, a VPN that is down)
+// used to lose the day's final report.
+//
+// With reports.dir set, a report is first written to the outbox in the data
+// directory and delivered from there:
+//
+//   - each machine delivers at a fixed offset within reports.jitter after a
+//     report is due, taken from a hash of its computer and user name, so a
+//     fleet spreads evenly over the window and every machine keeps its slot;
+//   - a failed delivery is retried with exponential backoff, from
+//     reportRetryMin doubling up to reports.retryMax, each wait randomised
+//     between half and all of the step so retries do not synchronise;
+//   - the outbox survives restarts, keeps files for reportOutboxDays and is
+//     delivered oldest first, so an offline week arrives complete.
+//
+// Weekly summaries (weeklysummary.go) go the same way. The default local
+// directory is written directly.
+
+package watchdog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	reportRetryMin   = time.Minute
+	reportOutboxDays = 31
+
+	defaultReportJitter   = duration(30 * time.Minute)
+	defaultReportRetryMax = duration(4 * time.Hour)
+)
+
+func (d *daemon) reportOutbox() string {
+	return filepath.Join(d.dataDir, "reports-outbox")
+}
+
+// reportSpread is this machine's offset within reports.jitter.
+func reportSpread(jitter duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	computer, user := reportIdentity()
+	h := fnv.New64a()
+	h.Write([]byte(computer + "\x00" + user))
+	return time.Duration(h.Sum64() % uint64(jitter.D()))
+}
+
+// reportBackoff is the wait before delivery attempt n+1 after n failures.
+func reportBackoff(n int, max duration) time.Duration {
+	step := reportRetryMin
+	for i := 1; i < n && step < max.D(); i++ {
+		step *= 2
+	}
+	step = min(step, max.D())
+	return step/2 + rand.N(step/2+1)
+}
+
+// stageReportFile writes one file to the outbox and schedules its delivery.
+// Caller holds d.reportMu.
+func (d *daemon) stageReportFile(name string, data []byte) error {
+	outbox := d.reportOutbox()
+	if err := os.MkdirAll(outbox, 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(outbox, name), data); err != nil {
+		return err
+	}
+	d.scheduleReportDelivery()
+	return nil
+}
+
+// scheduleReportDelivery sets the next delivery to this machine's slot,
+// unless one (or a retry) is already scheduled. Caller holds d.reportMu.
+func (d *daemon) scheduleReportDelivery() {
+	if d.reportDeliverAt.IsZero() {
+		d.reportDeliverAt = d.clock.Now().Add(reportSpread(d.cfg.Reports.Jitter))
+	}
+}
+
+// outboxFiles lists the outbox, oldest first, after dropping files older
+// than reportOutboxDays.
+func (d *daemon) outboxFiles() []os.DirEntry {
+	entries, _ := os.ReadDir(d.reportOutbox())
+	var files []os.DirEntry
+	cutoff := d.clock.Now().AddDate(0, 0, -reportOutboxDays)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !e.Type().IsRegular() || filepath.Ext(e.Name()) == ".tmp" {
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(d.reportOutbox(), e.Name()))
+			d.watchLog_("WARN", fmt.Sprintf("Dropped %s from the report outbox: not delivered within %d days.", e.Name(), reportOutboxDays))
+			continue
+		}
+		files = append(files, e)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files
+}
+
+// deliverReports moves the outbox to reports.dir once the delivery is due.
+// Caller holds d.reportMu.
+func (d *daemon) deliverReports() {
+	if !d.reportOutboxChecked {
+		// Reports left from the previous run.
+		d.reportOutboxChecked = true
+		if len(d.outboxFiles()) > 0 {
+			d.scheduleReportDelivery()
+		}
+	}
+	now := d.clock.Now()
+	if d.reportDeliverAt.IsZero() || now.Before(d.reportDeliverAt) {
+		return
+	}
+	dir := d.reportDir()
+	files := d.outboxFiles()
+	var err error
+	delivered := 0
+	if err = os.MkdirAll(dir, 0755); err == nil {
+		for _, f := range files {
+			src := filepath.Join(d.reportOutbox(), f.Name())
+			var data []byte
+			if data, err = os.ReadFile(src); err == nil {
+				err = writeFileAtomic(filepath.Join(dir, f.Name()), data)
+			}
+			if err != nil {
+				break
+			}
+			os.Remove(src)
+			delivered++
+		}
+	}
+	if err == nil {
+		if d.reportFailing {
+			d.watchLog_("INFO", fmt.Sprintf("Health reports delivered to %s again (%s from the outbox).", dir, plural(delivered, "file", "no files")))
+			d.reportFailing = false
+		}
+		d.reportDeliverAt, d.reportAttempts = time.Time{}, 0
+		return
+	}
+	d.reportAttempts++
+	wait := reportBackoff(d.reportAttempts, d.cfg.Reports.RetryMax)
+	d.reportDeliverAt = now.Add(wait)
+	if !d.reportFailing {
+		d.watchLog_("WARN", fmt.Sprintf("Cannot deliver health reports to %s: %v. Keeping %s in %s; retrying with backoff (next in %s).",
+			dir, err, plural(len(files)-delivered, "file", "no files"), d.reportOutbox(), duration(wait.Truncate(time.Second))))
+		d.reportFailing = true
+	}
+}
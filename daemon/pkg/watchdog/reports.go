@@ -0,0 +1,235 @@
+// reports.go
+// Daily health summaries for admins who collect reports from file shares.
+// With reports.enabled the daemon keeps per-day counters — health score,
+// triggers, repairs, cache size — and writes them as
+// <computer>-<user>-<YYYY-MM-DD>.json and/or .csv to reports.dir (a local
+// directory or a UNC share). The current day's files are rewritten hourly
+// and finalised at local midnight; after a restart the counters continue
+// from the day's file. A reports.dir share is written through an outbox,
+// with jitter and retries (reportoutbox.go). The counters are also kept
+// while weeklySummary is enabled, which is built from them
+// (weeklysummary.go). Single-user mode only.
+
+package watchdog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	reportFormatJSON = "json"
+	reportFormatCSV  = "csv"
+	reportFormatBoth = "both"
+
+	reportWriteEvery = time.Hour
+)
+
+type reportOptions struct {
+	Enabled  bool     `json:"enabled"`
+	Dir      string   `json:"dir"`      // empty: <dataDir>\reports
+	Format   string   `json:"format"`   // "json", "csv" or "both"
+	Jitter   duration `json:"jitter"`   // delivery window to reports.dir (reportoutbox.go)
+	RetryMax duration `json:"retryMax"` // longest wait between delivery attempts
+}
+
+type dailyReport struct {
+	Date           string    `json:"date"` // local YYYY-MM-DD
+	Computer       string    `json:"computer"`
+	User           string    `json:"user"`
+	Updated        time.Time `json:"updated"`
+	HealthChecks   int       `json:"healthChecks"`
+	ScoreMin       int       `json:"scoreMin"`
+	ScoreAvg       float64   `json:"scoreAvg"`
+	ScoreLast      int       `json:"scoreLast"`
+	SizeTriggers   int       `json:"sizeTriggers"`
+	HealthTriggers int       `json:"healthTriggers"`
+	Repairs        int       `json:"repairs"`
+	SizeSamples    int       `json:"sizeSamples"`
+	CacheMBMin     float64   `json:"cacheMBMin"`
+	CacheMBAvg     float64   `json:"cacheMBAvg"`
+	CacheMBMax     float64   `json:"cacheMBMax"`
+
+	BrokenShortcuts int `json:"brokenShortcuts"` // last scan (brokenlinks.go)
+	DiskSpaceAlerts int `json:"diskSpaceAlerts"` // low free space alerts (diskspace.go)
+	HealthyChecks   int `json:"healthyChecks"`   // checks with every heuristic passing
+}
+
+var reportCSVHeader = []string{
+	"date", "computer", "user", "updated", "healthChecks", "scoreMin", "scoreAvg", "scoreLast",
+	"sizeTriggers", "healthTriggers", "repairs", "sizeSamples", "cacheMBMin", "cacheMBAvg", "cacheMBMax",
+	"brokenShortcuts", "diskSpaceAlerts", "healthyChecks",
+}
+
+func (r *dailyReport) csvRow() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	return []string{
+		r.Date, r.Computer, r.User, r.Updated.Format(time.RFC3339),
+		strconv.Itoa(r.HealthChecks), strconv.Itoa(r.ScoreMin), f(r.ScoreAvg), strconv.Itoa(r.ScoreLast),
+		strconv.Itoa(r.SizeTriggers), strconv.Itoa(r.HealthTriggers), strconv.Itoa(r.Repairs),
+		strconv.Itoa(r.SizeSamples), f(r.CacheMBMin), f(r.CacheMBAvg), f(r.CacheMBMax),
+		strconv.Itoa(r.BrokenShortcuts), strconv.Itoa(r.DiskSpaceAlerts), strconv.Itoa(r.HealthyChecks),
+	}
+}
+
+func (r *dailyReport) addScore(score int) {
+	if r.HealthChecks == 0 || score < r.ScoreMin {
+		r.ScoreMin = score
+	}
+	r.ScoreAvg = (r.ScoreAvg*float64(r.HealthChecks) + float64(score)) / float64(r.HealthChecks+1)
+	r.HealthChecks++
+	r.ScoreLast = score
+}
+
+func (r *dailyReport) addSize(mb float64) {
+	if r.SizeSamples == 0 {
+		r.CacheMBMin, r.CacheMBMax = mb, mb
+	}
+	r.CacheMBMin = math.Min(r.CacheMBMin, mb)
+	r.CacheMBMax = math.Max(r.CacheMBMax, mb)
+	r.CacheMBAvg = (r.CacheMBAvg*float64(r.SizeSamples) + mb) / float64(r.SizeSamples+1)
+	r.SizeSamples++
+}
+
+func (d *daemon) reportDir() string {
+	if dir := d.cfg.Reports.Dir; dir != "" {
+		return dir
+	}
+	return filepath.Join(d.dataDir, "reports")
+}
+
+// reportIdentity names the machine and user in file names and reports.
+func reportIdentity() (computer, user string) {
+	name := func(env, fallback string) string {
+		v := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`\/:*?"<>|`, r) {
+				return '_'
+			}
+			return r
+		}, os.Getenv(env))
+		if v == "" {
+			return fallback
+		}
+		return v
+	}
+	host, _ := os.Hostname()
+	return name("COMPUTERNAME", host), name("USERNAME", name("USER", "unknown"))
+}
+
+// reportBase is the file name without extension for the given date.
+func reportBase(date string) string {
+	computer, user := reportIdentity()
+	return fmt.Sprintf("%s-%s-%s", computer, user, date)
+}
+
+// updateReport applies fn (which may be nil) to the current day's report,
+// rolling over at local midnight and writing the files when due.
+func (d *daemon) updateReport(fn func(r *dailyReport)) {
+	if !d.cfg.Reports.Enabled && !d.cfg.WeeklySummary.Enabled || d.session != nil || d.sim != nil {
+		return
+	}
+	d.reportMu.Lock()
+	defer d.reportMu.Unlock()
+
+	now := d.clock.Now()
+	date := now.Format("2006-01-02")
+	if d.report != nil && d.report.Date != date {
+		d.writeReport(d.report) // finalise yesterday
+		d.report = nil
+	}
+	if d.report == nil {
+		d.report = d.loadReport(date)
+		d.reportWritten = time.Time{}
+	}
+	if fn != nil {
+		fn(d.report)
+	}
+	d.report.Updated = now
+	if d.clock.Since(d.reportWritten) >= reportWriteEvery {
+		d.writeReport(d.report)
+		d.reportWritten = now
+	}
+	if d.cfg.Reports.Dir != "" {
+		d.deliverReports()
+	}
+}
+
+// loadReport continues the day's counters from an earlier run, if any,
+// preferring an undelivered copy in the outbox.
+func (d *daemon) loadReport(date string) *dailyReport {
+	name := reportBase(date) + ".json"
+	r := &dailyReport{Date: date}
+	raw, err := os.ReadFile(filepath.Join(d.reportOutbox(), name))
+	if err != nil {
+		raw, err = os.ReadFile(filepath.Join(d.reportDir(), name))
+	}
+	if err == nil {
+		json.Unmarshal(raw, r)
+	}
+	r.Computer, r.User = reportIdentity()
+	return r
+}
+
+// writeReport writes the report files, to reports.dir through the outbox
+// when it is set. Caller holds d.reportMu.
+func (d *daemon) writeReport(r *dailyReport) {
+	dir := d.reportDir()
+	write := func(name string, data []byte) error {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Join(dir, name), data)
+	}
+	if d.cfg.Reports.Dir != "" {
+		write = d.stageReportFile
+	}
+	var err error
+	base := reportBase(r.Date)
+	format := d.cfg.Reports.Format
+	if format != reportFormatCSV {
+		var raw []byte
+		raw, err = json.MarshalIndent(r, "", "  ")
+		if err == nil {
+			err = write(base+".json", raw)
+		}
+	}
+	if err == nil && format != reportFormatJSON {
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		w.Write(reportCSVHeader)
+		w.Write(r.csvRow())
+		w.Flush()
+		err = write(base+".csv", []byte(b.String()))
+	}
+	if d.cfg.Reports.Dir != "" {
+		if err != nil {
+			d.watchLog_("WARN", fmt.Sprintf("Cannot write health report to the outbox %s: %v", d.reportOutbox(), err))
+		}
+		return
+	}
+	switch {
+	case err != nil && !d.reportFailing:
+		d.watchLog_("WARN", fmt.Sprintf("Cannot write health report to %s: %v", dir, err))
+		d.reportFailing = true
+	case err == nil && d.reportFailing:
+		d.watchLog_("INFO", fmt.Sprintf("Health reports written to %s again.", dir))
+		d.reportFailing = false
+	}
+}
+
+// writeFileAtomic writes via a temporary file so collectors never read a
+// half-written report.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
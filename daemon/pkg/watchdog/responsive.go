@@ -0,0 +1,138 @@
+// responsive.go
+// H7: Explorer responsiveness. A shell that stopped pumping messages shows
+// the same symptoms users blame on the icon cache — blank or frozen
+// taskbar icons, a desktop that does not redraw — while every cache file
+// looks fine. H7 sends WM_NULL to the taskbar (Shell_TrayWnd) and the
+// desktop (Progman) with SendMessageTimeout; a window that does not answer
+// within responsiveness.timeout, twice in a row, is hung.
+//
+// H7 weighs responsiveness.weight in the score, 0 by default: deleting a
+// healthy cache does not unhang Explorer. With responsiveness.restartExplorer
+// a hung shell is restarted instead (Repair-IconCache.ps1 -RestartExplorer),
+// leaving the cache alone, unless the score already triggered a repair,
+// which restarts Explorer anyway. The restart obeys the cooldown, report-only
+// mode and holds like a repair, but a held restart is not queued: the next
+// health check probes again. Restarting one's own shell needs no elevation,
+// so it always runs directly. Only the single-user daemon probes; the
+// windows belong to the interactive desktop.
+
+package watchdog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type responsivenessOptions struct {
+	Enabled bool     `json:"enabled"`
+	Timeout duration `json:"timeout"` // per window and probe
+
+	// Weight is the score weight of H7 (score.go).
+	Weight float64 `json:"weight"`
+
+	// RestartExplorer restarts a hung Explorer whose cache looks fine.
+	RestartExplorer bool `json:"restartExplorer"`
+}
+
+func defaultResponsivenessOptions() responsivenessOptions {
+	return responsivenessOptions{Enabled: true, Timeout: duration(5 * time.Second)}
+}
+
+// shellProbe is one shell window's answer to WM_NULL.
+type shellProbe struct {
+	Window    string   `json:"window"` // taskbar, desktop
+	Responded bool     `json:"responded"`
+	Elapsed   duration `json:"elapsed"`
+}
+
+// shellStatus is H7's evidence: the probes and the windows that did not
+// answer either of two probes.
+type shellStatus struct {
+	Probes []shellProbe `json:"probes"`
+	Hung   []string     `json:"hung,omitempty"`
+}
+
+func (d *daemon) responsivenessActive() bool {
+	return d.cfg.Responsiveness.Enabled && d.session == nil && d.sim == nil
+}
+
+// checkH7Responsive probes the shell windows. A nil status means there was
+// no shell window to probe.
+func (d *daemon) checkH7Responsive() (bool, *shellStatus) {
+	return d.rateShellProbes(probeShellWindows)
+}
+
+// rateShellProbes runs probe once and, when a window did not answer, a
+// second time; only a window silent both times counts as hung.
+func (d *daemon) rateShellProbes(probe func(timeout time.Duration) []shellProbe) (bool, *shellStatus) {
+	timeout := d.cfg.Responsiveness.Timeout.D()
+	first := probe(timeout)
+	if len(first) == 0 {
+		d.healthLog_("PASS", "H7 SKIP: no taskbar or desktop window to probe (Explorer not running in this session).")
+		return true, nil
+	}
+	st := &shellStatus{Probes: first}
+	silent := map[string]bool{}
+	for _, p := range first {
+		if !p.Responded {
+			silent[p.Window] = true
+		}
+	}
+	if len(silent) > 0 {
+		st.Probes = probe(timeout)
+		for _, p := range st.Probes {
+			if !p.Responded && silent[p.Window] {
+				st.Hung = append(st.Hung, p.Window)
+			}
+		}
+	}
+	if len(st.Hung) > 0 {
+		d.logMsg(msgExplorerHung, strings.Join(st.Hung, " and "), d.cfg.Responsiveness.Timeout)
+		return false, st
+	}
+	var answered []string
+	for _, p := range st.Probes {
+		answered = append(answered, fmt.Sprintf("%s %s", p.Window, p.Elapsed.D().Round(time.Millisecond)))
+	}
+	d.healthLog_("PASS", fmt.Sprintf("H7 PASS: Explorer is responding (%s).", strings.Join(answered, ", ")))
+	return true, st
+}
+
+// restartHungExplorer restarts Explorer without touching the cache.
+func (d *daemon) restartHungExplorer(reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if left := d.cooldownLeft(); left > 0 {
+		d.repairLog_("WARN", fmt.Sprintf("Cooldown active (%s remaining). Skipping Explorer restart. Reason was: %s", left, reason))
+		d.metrics.inc(mRepairsSkipped)
+		return
+	}
+	if why := d.reportOnlyReason(); why != "" {
+		d.skipReportOnly(reason, why)
+		d.metrics.inc(mRepairsSkipped)
+		return
+	}
+	if why := d.pausedReason(); why != "" {
+		d.repairLog_("WARN", fmt.Sprintf("Watchdog %s. Skipping Explorer restart. Reason was: %s", why, reason))
+		d.metrics.inc(mRepairsSkipped)
+		return
+	}
+	if why := d.holdReason(priorityNormal); why != "" {
+		d.repairLog_("WARN", fmt.Sprintf("Explorer restart held (%s); probing again at the next health check. Reason was: %s", why, reason))
+		d.metrics.inc(mRepairsSkipped)
+		return
+	}
+
+	d.repairLog_("TRIGGER", fmt.Sprintf("Explorer restart triggered: %s", reason))
+	if err := d.validateRepairScript(); err != nil {
+		d.repairLog_("TAMPER", fmt.Sprintf("Refusing to launch Explorer restart: %v", err))
+		d.reportEvent(evtRepairRefused, fmt.Sprintf("Refusing to launch repair: %v", err))
+		return
+	}
+	if d.startRepairScript(reason, false, "-RestartExplorer") {
+		d.metrics.inc(mRepairsStarted)
+		d.updateReport(func(r *dailyReport) { r.Repairs++ })
+	}
+}
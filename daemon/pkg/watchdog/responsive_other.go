@@ -0,0 +1,10 @@
+//go:build !windows
+
+// responsive_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "time"
+
+func probeShellWindows(timeout time.Duration) []shellProbe { return nil }
@@ -0,0 +1,46 @@
+// responsive_windows.go
+// user32!FindWindowW and SendMessageTimeoutW for H7.
+
+package watchdog
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	wmNull          = 0x0000
+	smtoAbortIfHung = 0x0002
+	smtoErrorOnExit = 0x0020
+)
+
+var (
+	procFindWindowW         = modUser32.NewProc("FindWindowW")
+	procSendMessageTimeoutW = modUser32.NewProc("SendMessageTimeoutW")
+)
+
+// shellWindows are the top-level windows H7 probes, by class name.
+var shellWindows = []struct{ class, name string }{
+	{"Shell_TrayWnd", "taskbar"},
+	{"Progman", "desktop"},
+}
+
+// probeShellWindows sends WM_NULL to each shell window of this desktop;
+// windows that do not exist are left out.
+func probeShellWindows(timeout time.Duration) []shellProbe {
+	var probes []shellProbe
+	for _, w := range shellWindows {
+		class, _ := syscall.UTF16PtrFromString(w.class)
+		hwnd, _, _ := procFindWindowW.Call(uintptr(unsafe.Pointer(class)), 0)
+		if hwnd == 0 {
+			continue
+		}
+		var result uintptr
+		start := time.Now()
+		ok, _, _ := procSendMessageTimeoutW.Call(hwnd, wmNull, 0, 0,
+			smtoAbortIfHung|smtoErrorOnExit, uintptr(timeout.Milliseconds()), uintptr(unsafe.Pointer(&result)))
+		probes = append(probes, shellProbe{Window: w.name, Responded: ok != 0, Elapsed: duration(time.Since(start))})
+	}
+	return probes
+}
@@ -0,0 +1,244 @@
+// rollback.go
+// `rollback`: restore a pre-repair backup (backup.go) when a rebuild made
+// things worse, for example all-generic icons because network shares were
+// unreachable while Explorer rebuilt the cache. The repair script does the
+// restore (-RestoreFrom): it stops Explorer, replaces the cache files with
+// the backed-up ones and starts Explorer again, under the repair lock.
+//
+// With the daemon running, the command asks it over the control pipe to
+// start the restore, which then counts as a repair for the daemon's cooldown:
+// otherwise the next poll could find the restored cache over the size limit
+// and rebuild it straight away. Without a daemon the command runs the script
+// itself. Either way the restore is direct; it is not routed through the
+// broker or the repair task.
+
+package watchdog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const backupManifestName = "manifest.json"
+
+// backupManifest is the manifest.json Repair-IconCache.ps1 writes next to
+// the copied files.
+type backupManifest struct {
+	Created   time.Time `json:"created"`
+	Reason    string    `json:"reason"`
+	CachePath string    `json:"cachePath"`
+	Complete  bool      `json:"complete"`
+	Files     []struct {
+		Name       string `json:"name"`
+		Size       int64  `json:"size"`
+		ShadowCopy bool   `json:"shadowCopy"`
+	} `json:"files"`
+}
+
+type backupEntry struct {
+	Name     string // yyyyMMdd-HHmmss
+	Dir      string
+	Manifest backupManifest
+}
+
+func (b backupEntry) sizeMB() float64 {
+	var total int64
+	for _, f := range b.Manifest.Files {
+		total += f.Size
+	}
+	return mb(total)
+}
+
+// listBackups returns the backups in dir, newest first. Folders without a
+// readable manifest or without files are skipped.
+func listBackups(dir string) []backupEntry {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var out []backupEntry
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		b := backupEntry{Name: e.Name(), Dir: filepath.Join(dir, e.Name())}
+		raw, err := os.ReadFile(filepath.Join(b.Dir, backupManifestName))
+		if err != nil {
+			continue
+		}
+		// Windows PowerShell's Set-Content -Encoding UTF8 writes a BOM.
+		raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+		if json.Unmarshal(raw, &b.Manifest) != nil || len(b.Manifest.Files) == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name > out[j].Name })
+	return out
+}
+
+// rollbackPlan picks the backup to restore (name, or the newest when empty)
+// and returns the reason and script parameters for the restore.
+func (d *daemon) rollbackPlan(name string) (b backupEntry, reason string, params []string, err error) {
+	backups := listBackups(d.backupDir())
+	if len(backups) == 0 {
+		return b, "", nil, fmt.Errorf("no backups in %s", d.backupDir())
+	}
+	b = backups[0]
+	if name != "" {
+		found := false
+		for _, c := range backups {
+			if c.Name == name {
+				b, found = c, true
+			}
+		}
+		if !found {
+			return b, "", nil, fmt.Errorf("no backup named %q in %s", name, d.backupDir())
+		}
+	}
+	reason = fmt.Sprintf("rollback to backup %s", b.Name)
+	if b.Manifest.Reason != "" {
+		reason += fmt.Sprintf(" (taken before: %s)", b.Manifest.Reason)
+	}
+	return b, reason, []string{"-RestoreFrom", b.Dir}, nil
+}
+
+// serveRollback runs a control-pipe rollback request: it starts the restore
+// and streams its progress until it ends.
+func (d *daemon) serveRollback(send func(controlEvent) error, name string) {
+	fail := func(err error) {
+		send(controlEvent{Type: eventError, At: d.clock.Now(), Error: err.Error()})
+	}
+	_, reason, params, err := d.rollbackPlan(name)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if err := d.validateRepairScript(); err != nil {
+		d.repairLog_("TAMPER", fmt.Sprintf("Refusing to launch rollback: %v", err))
+		fail(err)
+		return
+	}
+	events := d.progress.subscribe()
+	defer d.progress.unsubscribe(events)
+	d.mu.Lock()
+	if d.inFlight != nil {
+		d.mu.Unlock()
+		fail(errors.New("a repair is running; roll back after it has finished"))
+		return
+	}
+	d.repairLog_("TRIGGER", fmt.Sprintf("Repair triggered: %s", reason))
+	started := d.startRepairScript(reason, false, params...)
+	d.mu.Unlock()
+	if !started {
+		fail(errors.New("the restore could not be started; see the logs"))
+		return
+	}
+	for ev := range events {
+		if send(ev) != nil || ev.finished() {
+			return
+		}
+	}
+}
+
+func cmdRollback(d *daemon, args []string) int {
+	fs := newFlagSet("rollback")
+	list := fs.Bool("list", false, "list the backups, newest first, and exit")
+	name := fs.String("backup", "", "backup to restore (default: the newest)")
+	asJSON := fs.Bool("json", false, "with -list: print the backups as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *list {
+		backups := listBackups(d.backupDir())
+		if *asJSON {
+			type listed struct {
+				Name   string  `json:"name"`
+				SizeMB float64 `json:"sizeMB"`
+				backupManifest
+			}
+			out := struct {
+				Dir     string   `json:"dir"`
+				Backups []listed `json:"backups"`
+			}{d.backupDir(), []listed{}}
+			for _, b := range backups {
+				out.Backups = append(out.Backups, listed{b.Name, b.sizeMB(), b.Manifest})
+			}
+			printJSON("rollback", out)
+			return 0
+		}
+		if len(backups) == 0 {
+			fmt.Printf("No backups in %s.\n", d.backupDir())
+			return 0
+		}
+		for _, b := range backups {
+			complete := ""
+			if !b.Manifest.Complete {
+				complete = " (incomplete)"
+			}
+			fmt.Printf("%s  %d file(s), %.2f MB%s  %s\n", b.Name, len(b.Manifest.Files), b.sizeMB(), complete, b.Manifest.Reason)
+		}
+		return 0
+	}
+
+	b, reason, params, err := d.rollbackPlan(*name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot roll back: %v\n", err)
+		if !d.cfg.Backup.Enabled {
+			fmt.Fprintln(os.Stderr, "Backups are taken before repairs with backup.enabled.")
+		}
+		return 1
+	}
+	fmt.Printf("Restoring backup %s (%d file(s), %.2f MB)...\n", b.Name, len(b.Manifest.Files), b.sizeMB())
+	if c, err := openControl(d.controlAddr()); err == nil {
+		defer c.Close()
+		return rollbackViaDaemon(c, b.Name)
+	}
+
+	d.loadState()
+	d.started = d.clock.Now()
+	if err := d.validateRepairScript(); err != nil {
+		d.repairLog_("TAMPER", fmt.Sprintf("Refusing to launch rollback: %v", err))
+		fmt.Fprintf(os.Stderr, "Cannot roll back: %v\n", err)
+		return 1
+	}
+	ok, outcome := d.repairAndWait(reason, false, params...)
+	fmt.Println(outcome)
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// rollbackViaDaemon sends the rollback request and prints its progress.
+func rollbackViaDaemon(c *controlClient, name string) int {
+	if err := c.send(controlRequest{Cmd: requestRollback, Backup: name}); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot reach the daemon: %v\n", err)
+		return 1
+	}
+	for {
+		ev, _, err := c.next()
+		if err != nil {
+			break
+		}
+		if ev.Type == eventError {
+			fmt.Fprintf(os.Stderr, "Daemon: %s\n", ev.Error)
+			return 1
+		}
+		fmt.Println(ev.describe())
+		if ev.finished() {
+			if ev.Phase == phaseFailed {
+				return 1
+			}
+			return 0
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Daemon closed the control pipe.")
+	return 1
+}
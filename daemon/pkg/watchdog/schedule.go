@@ -0,0 +1,284 @@
+// schedule.go
+// Calendar schedules for periodic health checks (health.schedule) and deep
+// cleans (deepClean.schedule), so they can follow a maintenance calendar
+// rather than an interval counted from the daemon's start. Two forms are
+// accepted, in local time:
+//
+//   Sat 03:00                 days, then one or more times of day: "daily",
+//   Mon-Fri 09:00,13:00       "*", a weekday, a list or a range of them;
+//   03:00                     no days means every day
+//   0 3 * * 6                 five cron fields: minute hour day-of-month
+//   */30 8-18 * * Mon-Fri     month day-of-week, with *, lists, ranges and
+//                             /steps; names for months and weekdays
+//
+// As in cron, when both day-of-month and day-of-week are restricted a day
+// matching either runs.
+
+package watchdog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minutesPerDay = 24 * 60
+
+	// scheduleLookahead bounds the search for the next slot; a schedule
+	// with none within it ("0 0 30 2 *") is rejected.
+	scheduleLookahead = 5 * 366
+)
+
+var (
+	weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+	monthNames   = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+)
+
+// schedule is a parsed calendar schedule.
+type schedule struct {
+	expr    string
+	minutes [minutesPerDay]bool // minute of the day
+	dom     [32]bool
+	month   [13]bool
+	dow     [7]bool
+	anyDOM  bool // day-of-month is "*"
+	anyDOW  bool // day-of-week is "*"
+}
+
+func (s *schedule) String() string { return s.expr }
+
+// parseSchedule parses a schedule in either form.
+func parseSchedule(expr string) (*schedule, error) {
+	fields := strings.Fields(expr)
+	s := &schedule{expr: strings.Join(fields, " "), anyDOM: true}
+	var err error
+	switch {
+	case len(fields) == 5:
+		err = s.parseCron(fields)
+	case len(fields) == 1 || len(fields) == 2:
+		err = s.parseDays(fields)
+	default:
+		err = fmt.Errorf("must be like \"Sat 03:00\" or five cron fields")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)).IsZero() {
+		return nil, fmt.Errorf("%q never runs", s.expr)
+	}
+	return s, nil
+}
+
+// parseDays parses "[days] HH:MM[,HH:MM...]".
+func (s *schedule) parseDays(fields []string) error {
+	for i := range s.dom {
+		s.dom[i] = true
+	}
+	for i := range s.month {
+		s.month[i] = true
+	}
+	days := "*"
+	if len(fields) == 2 {
+		days = fields[0]
+	}
+	if strings.EqualFold(days, "daily") {
+		days = "*"
+	}
+	if err := parseField(days, 0, 6, weekdayNames, s.dow[:]); err != nil {
+		return fmt.Errorf("days %q: %v", days, err)
+	}
+	s.anyDOW = days == "*"
+	for _, at := range strings.Split(fields[len(fields)-1], ",") {
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			return fmt.Errorf("time %q must look like \"03:00\"", at)
+		}
+		s.minutes[t.Hour()*60+t.Minute()] = true
+	}
+	return nil
+}
+
+// parseCron parses the five cron fields.
+func (s *schedule) parseCron(fields []string) error {
+	var minute [60]bool
+	var hour [24]bool
+	var dow [8]bool // 0 and 7 are Sunday
+	for _, f := range []struct {
+		name     string
+		lo, hi   int
+		names    []string
+		set      []bool
+		wildcard *bool
+	}{
+		{"minute", 0, 59, nil, minute[:], nil},
+		{"hour", 0, 23, nil, hour[:], nil},
+		{"day of month", 1, 31, nil, s.dom[:], &s.anyDOM},
+		{"month", 1, 12, monthNames, s.month[:], nil},
+		{"day of week", 0, 7, weekdayNames, dow[:], &s.anyDOW},
+	} {
+		v := fields[0]
+		fields = fields[1:]
+		if err := parseField(v, f.lo, f.hi, f.names, f.set); err != nil {
+			return fmt.Errorf("%s %q: %v", f.name, v, err)
+		}
+		if f.wildcard != nil {
+			*f.wildcard = v == "*"
+		}
+	}
+	for h := range hour {
+		for m := range minute {
+			s.minutes[h*60+m] = hour[h] && minute[m]
+		}
+	}
+	copy(s.dow[:], dow[:7])
+	s.dow[0] = s.dow[0] || dow[7]
+	return nil
+}
+
+// parseField sets set[v] for every value a cron field names: "*", a value,
+// "a-b", either with "/step", and comma-separated lists of those.
+func parseField(field string, lo, hi int, names []string, set []bool) error {
+	value := func(v string) (int, error) {
+		for i, name := range names {
+			if name != "" && strings.EqualFold(v, name) {
+				return i, nil
+			}
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("%q is not between %d and %d", v, lo, hi)
+		}
+		return n, nil
+	}
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return fmt.Errorf("step %q must be a positive number", stepText)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = value(a); err != nil {
+				return err
+			}
+			to = from
+			if isRange {
+				if to, err = value(b); err != nil {
+					return err
+				}
+			} else if stepped {
+				to = hi
+			}
+			if to < from {
+				return fmt.Errorf("range %q runs backwards", rng)
+			}
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+// day reports whether the schedule runs on t's date.
+func (s *schedule) day(t time.Time) bool {
+	if !s.month[t.Month()] {
+		return false
+	}
+	dom, dow := s.dom[t.Day()], s.dow[t.Weekday()]
+	switch {
+	case s.anyDOM:
+		return dow
+	case s.anyDOW:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first slot after t, or the zero time if there is none
+// within scheduleLookahead days.
+func (s *schedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for i := 0; i < scheduleLookahead; i++ {
+		if s.day(t) {
+			for m := t.Hour()*60 + t.Minute(); m < minutesPerDay; m++ {
+				if s.minutes[m] {
+					return time.Date(t.Year(), t.Month(), t.Day(), m/60, m%60, 0, 0, t.Location())
+				}
+			}
+		}
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// minGap returns the shortest time between the first n slots after t.
+func (s *schedule) minGap(t time.Time, n int) time.Duration {
+	gap := time.Duration(1<<63 - 1)
+	prev := s.next(t)
+	for i := 0; i < n; i++ {
+		next := s.next(prev)
+		if next.IsZero() {
+			break
+		}
+		gap = min(gap, next.Sub(prev))
+		prev = next
+	}
+	return gap
+}
+
+// scheduleTicker fires at the slots of a schedule. It checks once a minute,
+// so a slot fires within a minute of its time.
+type scheduleTicker struct {
+	c    chan time.Time
+	stop chan struct{}
+	once sync.Once
+}
+
+func newScheduleTicker(clock Clock, s *schedule) Ticker {
+	t := &scheduleTicker{c: make(chan time.Time, 1), stop: make(chan struct{})}
+	minute := clock.NewTicker(time.Minute)
+	due := s.next(clock.Now())
+	go func() {
+		defer minute.Stop()
+		for {
+			select {
+			case now := <-minute.C():
+				if now.Before(due) {
+					continue
+				}
+				select {
+				case t.c <- now:
+				default: // the previous slot is still being handled
+				}
+				due = s.next(now)
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func (t *scheduleTicker) C() <-chan time.Time { return t.c }
+func (t *scheduleTicker) Stop()               { t.once.Do(func() { close(t.stop) }) }
+
+// healthTicker returns the ticker of the periodic health checks, on
+// health.schedule or every thresholds.healthCheckEvery, and how it runs for
+// the log.
+func (d *daemon) healthTicker() (Ticker, string) {
+	if s, err := parseSchedule(d.cfg.Health.Schedule); err == nil && d.cfg.Health.Schedule != "" {
+		return newScheduleTicker(d.clock, s), fmt.Sprintf("schedule %q", s)
+	}
+	every := d.cfg.Thresholds.HealthCheckEvery
+	return d.clock.NewTicker(every.D()), "every " + every.String()
+}
@@ -0,0 +1,68 @@
+// schedule_test.go
+// Calendar schedules: both forms, the next slot and the cron fields.
+
+package watchdog
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	wed := time.Date(2026, 3, 4, 10, 15, 0, 0, time.Local) // a Wednesday
+	for _, c := range []struct{ expr, want string }{
+		{"Sat 03:00", "2026-03-07 03:00"},
+		{"03:00", "2026-03-05 03:00"},
+		{"Mon-Fri 09:00,13:00", "2026-03-04 13:00"},
+		{"*/30 8-18 * * Mon-Fri", "2026-03-04 10:30"},
+		{"0 3 1 * Sun", "2026-03-08 03:00"}, // day of month or day of week
+		{"0 3 * Apr 7", "2026-04-05 03:00"},
+	} {
+		s, err := parseSchedule(c.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", c.expr, err)
+		}
+		if got := s.next(wed).Format("2006-01-02 15:04"); got != c.want {
+			t.Errorf("%q: next = %s, want %s", c.expr, got, c.want)
+		}
+	}
+	for _, bad := range []string{"Sat 3am", "Someday 03:00", "0 25 * * *", "0 0 30 2 *", "5-1 * * * *", "0 3 * *"} {
+		if _, err := parseSchedule(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestParseField(t *testing.T) {
+	for _, c := range []struct {
+		field string
+		want  []int
+	}{
+		{"*", []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{"3", []int{3}},
+		{"3-5,11", []int{3, 4, 5, 11}},
+		{"*/4", []int{1, 5, 9}},
+		{"10/2", []int{10, 12}}, // a start with a step runs to the end
+		{"Feb-apr", []int{2, 3, 4}},
+	} {
+		set := make([]bool, 13)
+		if err := parseField(c.field, 1, 12, monthNames, set); err != nil {
+			t.Fatalf("%q: %v", c.field, err)
+		}
+		var got []int
+		for v, on := range set {
+			if on {
+				got = append(got, v)
+			}
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%q = %v, want %v", c.field, got, c.want)
+		}
+	}
+	for _, bad := range []string{"0", "13", "5-3", "*/0", "x", "1,"} {
+		if err := parseField(bad, 1, 12, monthNames, make([]bool, 13)); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
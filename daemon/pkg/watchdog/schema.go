@@ -0,0 +1,761 @@
+// schema.go
+// Strict config validation. The config struct (with its json tags) is the
+// schema: unknown keys are rejected, every value must decode into its
+// field's type, and checkRanges enforces the limits below. Each problem is
+// reported with the line and column of its key, so a typo is pointed out
+// instead of silently falling back to a default.
+
+package watchdog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// configError is one problem in the config file. Line is 0 when the
+// problem has no position (e.g. a missing file).
+type configError struct {
+	Line, Col int
+	Path      string
+	Msg       string
+}
+
+func (e configError) Error() string {
+	msg := e.Msg
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, msg)
+	}
+	return msg
+}
+
+type configErrors []configError
+
+func (es configErrors) Error() string {
+	parts := make([]string, len(es))
+	for i, e := range es {
+		parts[i] = e.Error()
+	}
+	return fmt.Sprintf("%d problem(s): %s", len(es), strings.Join(parts, "; "))
+}
+
+// parseConfig decodes raw on top of the defaults and validates it, and
+// returns the setting paths present in the file. Any problem yields the
+// defaults together with every error found. // and /* */ comments are
+// allowed (see `config init`).
+func parseConfig(raw []byte) (config, []string, error) {
+	raw = stripJSONComments(raw)
+	w := schemaWalker{raw: raw, dec: json.NewDecoder(bytes.NewReader(raw)), keys: map[string]int64{}, invalid: map[string]bool{}}
+	if err := w.walk(reflect.TypeOf(config{}), "", 0); err != nil {
+		w.fail(0, "", err)
+		return defaultConfig(), nil, w.errs
+	}
+	if _, err := w.dec.Token(); err != io.EOF {
+		w.fail(w.dec.InputOffset(), "", errors.New("unexpected content after the top-level object"))
+		return defaultConfig(), nil, w.errs
+	}
+
+	// Well-formed: range-check what decoded, skipping values already
+	// reported as the wrong type.
+	cfg := defaultConfig()
+	json.Unmarshal(raw, &cfg)
+	for _, e := range cfg.checkRanges() {
+		if w.invalid[e.Path] {
+			continue
+		}
+		if off, ok := w.keys[e.Path]; ok {
+			e.Line, e.Col = position(raw, off)
+		}
+		w.errs = append(w.errs, e)
+	}
+	if len(w.errs) > 0 {
+		sort.SliceStable(w.errs, func(i, j int) bool {
+			a, b := w.errs[i], w.errs[j]
+			return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+		})
+		return defaultConfig(), nil, w.errs
+	}
+	keys := make([]string, 0, len(w.keys))
+	for k := range w.keys {
+		keys = append(keys, k)
+	}
+	return cfg, keys, nil
+}
+
+// stripJSONComments blanks out // and /* */ comments outside strings. The
+// length and line breaks are kept so error positions stay exact.
+func stripJSONComments(raw []byte) []byte {
+	out := bytes.Clone(raw)
+	inString := false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		switch {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			stop := len(out)
+			if end >= 0 {
+				stop = i + 2 + end + 2
+			}
+			for ; i < stop; i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			i--
+		}
+	}
+	return out
+}
+
+// schemaWalker streams the document alongside the Go type it decodes into.
+type schemaWalker struct {
+	raw     []byte
+	dec     *json.Decoder
+	keys    map[string]int64 // dotted key path -> offset of the key
+	invalid map[string]bool  // key paths with a type error
+	errs    configErrors
+}
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func (w *schemaWalker) fail(off int64, path string, err error) {
+	var se *json.SyntaxError
+	if errors.As(err, &se) {
+		off, err = se.Offset, fmt.Errorf("syntax error: %v", se)
+	} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		off, err = int64(len(w.raw)), errors.New("unexpected end of file")
+	}
+	line, col := position(w.raw, off)
+	w.errs = append(w.errs, configError{Line: line, Col: col, Path: path, Msg: err.Error()})
+}
+
+// walk validates the next value against t. Only syntax errors are returned;
+// schema problems are collected and the walk continues.
+func (w *schemaWalker) walk(t reflect.Type, path string, at int64) error {
+	if t.Kind() != reflect.Struct || reflect.PointerTo(t).Implements(jsonUnmarshaler) {
+		var v json.RawMessage
+		if err := w.dec.Decode(&v); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(v, reflect.New(t).Interface()); err != nil {
+			w.fail(at, path, typeError(err))
+			w.invalid[path] = true
+		}
+		return nil
+	}
+
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		if d, ok := tok.(json.Delim); ok && d == '[' {
+			w.skipRest()
+		}
+		w.fail(at, path, fmt.Errorf("expected an object"))
+		return nil
+	}
+	fields := jsonFields(t)
+	for w.dec.More() {
+		tok, err := w.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		quoted, _ := json.Marshal(key)
+		keyAt := w.dec.InputOffset() - int64(len(quoted))
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		f, ok := fields[key]
+		if !ok {
+			msg := fmt.Sprintf("unknown key %q", key)
+			if s := suggestKey(key, fields); s != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", s)
+			}
+			w.fail(keyAt, path, errors.New(msg))
+			var skip json.RawMessage
+			if err := w.dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+		w.keys[keyPath] = keyAt
+		if err := w.walk(f.Type, keyPath, keyAt); err != nil {
+			return err
+		}
+	}
+	_, err = w.dec.Token() // '}'
+	return err
+}
+
+// skipRest consumes the remainder of an array whose '[' was already read.
+func (w *schemaWalker) skipRest() {
+	for w.dec.More() {
+		var skip json.RawMessage
+		if w.dec.Decode(&skip) != nil {
+			return
+		}
+	}
+	w.dec.Token()
+}
+
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for _, f := range orderedFields(t) {
+		fields[f.Name] = f
+	}
+	return fields
+}
+
+// suggestKey finds a known key differing only in case or by a small typo.
+func suggestKey(key string, fields map[string]reflect.StructField) string {
+	best, bestDist := "", 3
+	for name := range fields {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// typeError turns encoding/json's Go-centric messages into config terms.
+func typeError(err error) error {
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &te) {
+		want := te.Type.Kind().String()
+		switch te.Type.Kind() {
+		case reflect.Int, reflect.Int64, reflect.Uint32:
+			want = "an integer"
+		case reflect.Float64:
+			want = "a number"
+		case reflect.Bool:
+			want = "true or false"
+		case reflect.String:
+			want = "a string"
+		}
+		return fmt.Errorf("expected %s, got %s", want, te.Value)
+	}
+	return err
+}
+
+// position converts a byte offset into a 1-based line and column.
+func position(raw []byte, off int64) (line, col int) {
+	if off > int64(len(raw)) {
+		off = int64(len(raw))
+	}
+	before := raw[:off]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = utf8.RuneCount(before[bytes.LastIndexByte(before, '\n')+1:]) + 1
+	return line, col
+}
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+var sidString = regexp.MustCompile(`^S-1-\d+(-\d+)+$`)
+
+// checkRanges enforces value limits the types alone cannot express.
+func (c config) checkRanges() []configError {
+	var errs []configError
+	bad := func(path, format string, args ...any) {
+		errs = append(errs, configError{Path: path, Msg: fmt.Sprintf(format, args...)})
+	}
+	durationAtLeast := func(path string, d duration, least time.Duration) {
+		if d.D() < least {
+			bad(path, "must be at least %s", duration(least))
+		}
+	}
+
+	if _, ok := presets[c.Preset]; !ok && c.Preset != "" {
+		bad("preset", "must be one of %s", strings.Join(presetNames(), ", "))
+	}
+	if c.RepairScriptSHA256 != "" && !sha256Hex.MatchString(strings.TrimSpace(c.RepairScriptSHA256)) {
+		bad("repairScriptSha256", "must be 64 hex characters")
+	}
+	if p := c.PowerShell.Path; p != "" && !isAbsPath(p) {
+		bad("powershell.path", "must be an absolute path")
+	}
+	if n := c.MultiSession.MaxConcurrentRepairs; n < 1 || n > 64 {
+		bad("multiSession.maxConcurrentRepairs", "must be between 1 and 64")
+	}
+	if n := c.MultiSession.PollWorkers; n < 1 || n > 64 {
+		bad("multiSession.pollWorkers", "must be between 1 and 64")
+	}
+
+	if m := c.VDI.Mode; m != vdiModeAuto && m != vdiModeOn && m != vdiModeOff {
+		bad("vdi.mode", "must be %q, %q or %q", vdiModeAuto, vdiModeOn, vdiModeOff)
+	}
+
+	w := c.Health.Weights
+	for _, x := range []struct {
+		name string
+		v    float64
+	}{{"h1", w.H1}, {"h2", w.H2}, {"h3", w.H3}, {"h4", w.H4}, {"size", w.Size}} {
+		if x.v < 0 {
+			bad("health.weights."+x.name, "must not be negative")
+		}
+	}
+	if w.H1+w.H2+w.H3+w.H4+w.Size <= 0 {
+		bad("health.weights", "at least one weight must be positive")
+	}
+	if s := c.Health.RepairBelowScore; s < 0 || s > 100 {
+		bad("health.repairBelowScore", "must be between 0 and 100")
+	}
+	if c.Health.Schedule != "" {
+		if _, err := parseSchedule(c.Health.Schedule); err != nil {
+			bad("health.schedule", "%v", err)
+		}
+	}
+	if n := c.Ledger.SampleBlocks; n < 0 || n > 4096 {
+		bad("ledger.sampleBlocks", "must be between 0 and 4096")
+	}
+	if w := c.Ledger.Weight; w < 0 || w > 100 {
+		bad("ledger.weight", "must be between 0 and 100")
+	}
+	if t := c.Responsiveness.Timeout.D(); t < 100*time.Millisecond || t > time.Minute {
+		bad("responsiveness.timeout", "must be between 100ms and 1m")
+	}
+	if w := c.Responsiveness.Weight; w < 0 || w > 100 {
+		bad("responsiveness.weight", "must be between 0 and 100")
+	}
+	durationAtLeast("shellStability.window", c.ShellStability.Window, time.Hour)
+	if c.ShellStability.Window.D() > 30*24*time.Hour {
+		bad("shellStability.window", "must be at most 30d")
+	}
+	if n := c.ShellStability.MaxEvents; n < 0 || n > 100 {
+		bad("shellStability.maxEvents", "must be between 0 and 100")
+	}
+	if w := c.ShellStability.Weight; w < 0 || w > 100 {
+		bad("shellStability.weight", "must be between 0 and 100")
+	}
+	durationAtLeast("shellRescue.after", c.ShellRescue.After, 5*time.Second)
+	if m := c.Pause.Max.D(); m < time.Minute || m > 7*24*time.Hour {
+		bad("pause.max", "must be between 1m and 7d")
+	}
+	if h := c.Quiet.Hours; h != "" {
+		if _, _, ok := parseQuietHours(h); !ok {
+			bad("quiet.hours", "must look like \"22:00-07:00\"")
+		}
+	}
+	for _, p := range holdPatterns(c.Quiet.HoldForProcesses) {
+		if _, err := path.Match(p, ""); err != nil || strings.ContainsAny(p, `/\`) {
+			bad("quiet.holdForProcesses", "must list image names such as \"obs64.exe, setup*.exe\"; %q is not one", p)
+			break
+		}
+	}
+
+	for _, p := range optOutPatterns(c.OptOut.Users) {
+		if _, err := path.Match(p, ""); err != nil || strings.Count(p, "/") > 1 {
+			bad("optOut.users", "must list accounts such as \"kiosk, CONTOSO\\svc-*\"; %q is not one", p)
+			break
+		}
+	}
+
+	t := c.Thresholds
+	sizeLimitOK := t.SizeLimit >= mib && t.SizeLimit <= 4*gib
+	if !sizeLimitOK {
+		bad("thresholds.sizeLimit", "must be between 1MB and 4GB")
+	}
+	if g := t.GrowthSlope; g < 0 || g > 1024 {
+		bad("thresholds.growthSlope", "must be between 0 and 1024 (MB per hour)")
+	}
+	durationAtLeast("thresholds.cooldown", t.Cooldown, time.Minute)
+	durationAtLeast("thresholds.pollEvery", t.PollEvery, time.Second)
+	if t.PollEvery.D() > 10*time.Minute {
+		bad("thresholds.pollEvery", "must be at most 10m")
+	}
+	durationAtLeast("thresholds.healthCheckEvery", t.HealthCheckEvery, time.Minute)
+	durationAtLeast("thresholds.heartbeatEvery", t.HeartbeatEvery, time.Minute)
+	durationAtLeast("thresholds.stillExceededEvery", t.StillExceededEvery, time.Minute)
+	durationAtLeast("thresholds.recentWrite", t.RecentWrite, time.Minute)
+	if t.MinHealthyFiles < 0 || t.MinHealthyFiles > 100 {
+		bad("thresholds.minHealthyFiles", "must be between 0 and 100")
+	}
+	durationAtLeast("thresholds.staleAge", t.StaleAge, time.Hour)
+	// Checked against sizeLimit, so only once that is itself valid: an
+	// overflowing sizeLimit is one error, not two.
+	if sizeLimitOK && (t.IndexMinSize < 0 || t.IndexMinSize > t.SizeLimit) {
+		bad("thresholds.indexMinSize", "must be between 0B and thresholds.sizeLimit")
+	}
+	durationAtLeast("thresholds.slowRepair", t.SlowRepair, 5*time.Second)
+	if t.MinFreeSpace < 0 || t.MinFreeSpace > 64*gib {
+		bad("thresholds.minFreeSpace", "must be between 0 and 64GB")
+	}
+	if p := c.SizeRepair.RecoveryPercent; p < 10 || p > 100 {
+		bad("sizeRepair.recoveryPercent", "must be between 10 and 100")
+	}
+	durationAtLeast("sizeRepair.verifyAfter", c.SizeRepair.VerifyAfter, 30*time.Second)
+	if n := c.SizeRepair.MaxIneffective; n < 1 || n > 10 {
+		bad("sizeRepair.maxIneffective", "must be between 1 and 10")
+	}
+	if m := c.Logging.Mode; m != logModeSplit && m != logModeUnified {
+		bad("logging.mode", "must be %q or %q", logModeSplit, logModeUnified)
+	}
+	if _, _, ok := parseQuietHours(c.DeepClean.Window); !ok {
+		bad("deepClean.window", "must look like \"03:00-05:00\"")
+	}
+	durationAtLeast("deepClean.every", c.DeepClean.Every, 24*time.Hour)
+	if c.DeepClean.Schedule != "" {
+		if s, err := parseSchedule(c.DeepClean.Schedule); err != nil {
+			bad("deepClean.schedule", "%v", err)
+		} else if s.minGap(time.Now(), 60) < 20*time.Hour {
+			bad("deepClean.schedule", "slots must be at least 20h apart")
+		}
+	}
+	if m := c.SelfCheck.MaxMemory; m < 16*mib || m > 4*gib {
+		bad("selfCheck.maxMemory", "must be between 16MB and 4GB")
+	}
+	if n := c.SelfCheck.MaxHandles; n < 100 || n > 100000 {
+		bad("selfCheck.maxHandles", "must be between 100 and 100000")
+	}
+	if f := c.Reports.Format; f != reportFormatJSON && f != reportFormatCSV && f != reportFormatBoth {
+		bad("reports.format", "must be %q, %q or %q", reportFormatJSON, reportFormatCSV, reportFormatBoth)
+	}
+	if p := c.Reports.Dir; p != "" && !isAbsPath(p) {
+		bad("reports.dir", "must be an absolute or UNC path")
+	}
+	if j := c.Reports.Jitter; j < 0 || j.D() > reportWriteEvery {
+		bad("reports.jitter", "must be between 0 and %s", duration(reportWriteEvery))
+	}
+	durationAtLeast("reports.retryMax", c.Reports.RetryMax, reportRetryMin)
+	if c.Reports.RetryMax.D() > 24*time.Hour {
+		bad("reports.retryMax", "must be at most 1d")
+	}
+	if _, ok := parseWeekday(c.WeeklySummary.Day); !ok {
+		bad("weeklySummary.day", "must be a weekday such as \"monday\"")
+	}
+	if _, err := time.Parse("15:04", c.WeeklySummary.At); err != nil {
+		bad("weeklySummary.at", "must look like \"09:00\"")
+	}
+	if o := c.WeeklySummary; o.SMTP != "" {
+		if _, _, err := net.SplitHostPort(o.SMTP); err != nil {
+			bad("weeklySummary.smtp", "must be \"host:port\"")
+		}
+		if !strings.Contains(o.From, "@") || len(splitList(o.To)) == 0 {
+			bad("weeklySummary.smtp", "needs weeklySummary.from and weeklySummary.to")
+		}
+	}
+	durationAtLeast("triggers.maxAge", c.Triggers.MaxAge, time.Minute)
+	if c.Triggers.MaxAge.D() > 30*24*time.Hour {
+		bad("triggers.maxAge", "must be at most 30d")
+	}
+	if u := c.Heartbeat.Webhook; u != "" {
+		if p, err := url.Parse(u); err != nil || p.Scheme != "http" && p.Scheme != "https" || p.Host == "" {
+			bad("heartbeat.webhook", "must be an http or https URL")
+		}
+	}
+	if f := c.Heartbeat.File; f != "" && (strings.HasSuffix(f, `\`) || strings.HasSuffix(f, "/")) {
+		bad("heartbeat.file", "must name a file, not a folder")
+	}
+	durationAtLeast("heartbeat.timeout", c.Heartbeat.Timeout, time.Second)
+	if c.Heartbeat.Timeout.D() > time.Minute {
+		bad("heartbeat.timeout", "must be at most 1m")
+	}
+	if u := c.Incidents.Webhook; u != "" {
+		if p, err := url.Parse(u); err != nil || p.Scheme != "http" && p.Scheme != "https" || p.Host == "" {
+			bad("incidents.webhook", "must be an http or https URL")
+		}
+	}
+	durationAtLeast("incidents.timeout", c.Incidents.Timeout, time.Second)
+	if c.Incidents.Timeout.D() > time.Minute {
+		bad("incidents.timeout", "must be at most 1m")
+	}
+	durationAtLeast("incidents.maxOpen", c.Incidents.MaxOpen, 10*time.Minute)
+	if u, err := url.Parse(c.OTel.Endpoint); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		bad("otel.endpoint", "must be an http or https URL")
+	}
+	if _, err := parseOTelHeaders(c.OTel.Headers); err != nil {
+		bad("otel.headers", "%v", err)
+	}
+	if strings.TrimSpace(c.OTel.ServiceName) == "" {
+		bad("otel.serviceName", "must not be empty")
+	}
+	durationAtLeast("otel.interval", c.OTel.Interval, 10*time.Second)
+	durationAtLeast("otel.timeout", c.OTel.Timeout, time.Second)
+	if c.OTel.Timeout.D() > time.Minute {
+		bad("otel.timeout", "must be at most 1m")
+	}
+	if n := c.Backup.Keep; n < 1 || n > 50 {
+		bad("backup.keep", "must be between 1 and 50")
+	}
+	if n := c.Network.MinShortcuts; n < 1 || n > 1000 {
+		bad("network.minShortcuts", "must be between 1 and 1000")
+	}
+	durationAtLeast("network.probeTimeout", c.Network.ProbeTimeout, time.Second)
+	if c.Network.ProbeTimeout.D() > 30*time.Second {
+		bad("network.probeTimeout", "must be at most 30s")
+	}
+	durationAtLeast("network.maxHold", c.Network.MaxHold, time.Hour)
+	if p := c.UI.Port; p < 0 || p > 65535 {
+		bad("ui.port", "must be between 0 and 65535")
+	}
+	for _, f := range []struct{ path, p string }{{"ui.tlsCert", c.UI.TLSCert}, {"ui.tlsKey", c.UI.TLSKey}, {"ui.clientCA", c.UI.ClientCA}} {
+		if f.p != "" && !isAbsPath(f.p) {
+			bad(f.path, "must be an absolute path")
+		}
+	}
+	if (c.UI.TLSCert == "") != (c.UI.TLSKey == "") {
+		bad("ui.tlsKey", "must be set together with ui.tlsCert")
+	}
+	if c.UI.ClientCA != "" && c.UI.TLSCert == "" {
+		bad("ui.clientCA", "needs ui.tlsCert and ui.tlsKey")
+	}
+	if p := c.Fleet.Port; p < 0 || p > 65535 {
+		bad("fleet.port", "must be between 0 and 65535")
+	}
+	if p := c.GRPC.Port; p < 0 || p > 65535 {
+		bad("grpc.port", "must be between 0 and 65535")
+	}
+	if c.Fleet.Enabled && !c.MultiSession.Enabled {
+		bad("fleet.enabled", "needs multiSession.enabled: only the machine-wide instance may serve the fleet endpoint")
+	}
+	for _, f := range []struct{ path, p string }{{"fleet.tokenFile", c.Fleet.TokenFile}, {"fleet.tlsCert", c.Fleet.TLSCert}, {"fleet.tlsKey", c.Fleet.TLSKey}, {"fleet.ca", c.Fleet.CA}} {
+		if f.p != "" && !isAbsPath(f.p) {
+			bad(f.path, "must be an absolute path")
+		} else if f.p == "" && c.Fleet.Enabled && f.path != "fleet.ca" {
+			bad(f.path, "must be set when fleet.enabled is on")
+		}
+	}
+	for _, sid := range c.Control.Allow {
+		if !sidString.MatchString(sid) {
+			bad("control.allow", "%q is not a SID such as S-1-5-32-545", sid)
+		}
+	}
+	switch c.Control.Audit {
+	case auditChanges, auditAll, auditOff:
+	default:
+		bad("control.audit", "must be %q, %q or %q", auditChanges, auditAll, auditOff)
+	}
+	if strings.TrimSpace(c.Plugins.Dir) == "" {
+		bad("plugins.dir", "must not be empty")
+	}
+	if w := c.Plugins.Weight; w < 0 || w > 100 {
+		bad("plugins.weight", "must be between 0 and 100")
+	}
+	durationAtLeast("plugins.timeout", c.Plugins.Timeout, time.Second)
+	if c.Plugins.Timeout.D() > 5*time.Minute {
+		bad("plugins.timeout", "must be at most 5m")
+	}
+	switch c.ExplorerStop.Method {
+	case stopGraceful, stopForced:
+	default:
+		bad("explorerStop.method", "must be %q or %q", stopGraceful, stopForced)
+	}
+	for _, t := range []struct {
+		path string
+		d    duration
+	}{
+		{"explorerStop.restartManager", c.ExplorerStop.RestartManagerTimeout},
+		{"explorerStop.graceful", c.ExplorerStop.GracefulTimeout},
+		{"explorerStop.force", c.ExplorerStop.ForceTimeout},
+	} {
+		if t.d.D() < time.Second || t.d.D() > 5*time.Minute {
+			bad(t.path, "must be between 1s and 5m")
+		}
+	}
+	if t := c.UpdateBaseline.Settle.D(); t < 0 || t > 7*24*time.Hour {
+		bad("updateBaseline.settle", "must be between 0s and 7d")
+	}
+	if n := c.Breaker.MaxTriggers; n < 1 || n > maxBreakerSamples-1 {
+		bad("breaker.maxTriggers", "must be between 1 and %d", maxBreakerSamples-1)
+	}
+	if n := c.Breaker.MaxFailures; n < 1 || n > 100 {
+		bad("breaker.maxFailures", "must be between 1 and 100")
+	}
+	// targetProblems checks one target, by key relative to it.
+	targetProblems := func(t targetThresholds, maxSize byteSize) [][2]string {
+		var out [][2]string
+		if t.SizeLimit != 0 && (t.SizeLimit < mib || t.SizeLimit > maxSize) {
+			out = append(out, [2]string{"sizeLimit", fmt.Sprintf("must be 0 (inherit) or between 1MB and %s", maxSize)})
+		}
+		if t.Cooldown != 0 && t.Cooldown.D() < time.Minute {
+			out = append(out, [2]string{"cooldown", "must be 0 (inherit) or at least 1m"})
+		}
+		return out
+	}
+	for _, p := range targetProblems(c.Targets.Icon, 4*gib) {
+		bad("targets.icon."+p[0], "%s", p[1])
+	}
+	for _, p := range targetProblems(c.Targets.Thumbnail, 64*gib) {
+		bad("targets.thumbnail."+p[0], "%s", p[1])
+	}
+	for i, u := range c.Targets.Users {
+		patterns := optOutPatterns(u.Match)
+		if len(patterns) == 0 {
+			bad("targets.users", "entry %d needs a match such as \"CONTOSO\\design-*\"", i+1)
+		}
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil || strings.Count(p, "/") > 1 {
+				bad("targets.users", "entry %d: match must list accounts such as \"CONTOSO\\design-*\"; %q is not one", i+1, p)
+				break
+			}
+		}
+		for _, p := range targetProblems(u.Icon, 4*gib) {
+			bad("targets.users", "entry %d: icon.%s %s", i+1, p[0], p[1])
+		}
+		for _, p := range targetProblems(u.Thumbnail, 64*gib) {
+			bad("targets.users", "entry %d: thumbnail.%s %s", i+1, p[0], p[1])
+		}
+	}
+	if t := c.WarmUp.Delay.D(); t < 0 || t > 5*time.Minute {
+		bad("warmUp.delay", "must be between 0s and 5m")
+	}
+	if n := c.WarmUp.MaxItems; n < 1 || n > maxShortcuts {
+		bad("warmUp.maxItems", "must be between 1 and %d", maxShortcuts)
+	}
+	if t := c.SoftRepair.Timeout.D(); t < 5*time.Second || t > 5*time.Minute {
+		bad("softRepair.timeout", "must be between 5s and 5m")
+	}
+	if s := c.StrayFiles.MinSize; s < 64*kib || s > 4*gib {
+		bad("strayFiles.minSize", "must be between 64KB and 4GB")
+	}
+	if len(c.CacheFiles.Icon) == 0 {
+		bad("cacheFiles.icon", "must list at least one pattern such as \"iconcache_*.db\"")
+	}
+	for _, f := range []struct {
+		path     string
+		patterns []string
+	}{{"cacheFiles.icon", c.CacheFiles.Icon}, {"cacheFiles.thumbnail", c.CacheFiles.Thumbnail}} {
+		// The repair script gets the lists '|'-separated.
+		for _, p := range f.patterns {
+			re, err := compileCachePattern(p)
+			if err != nil || strings.TrimPrefix(p, cacheRegexpPrefix) == "" || strings.Contains(p, "|") || re == nil && strings.ContainsAny(p, `/\`) {
+				bad(f.path, "must list file name globs or \"re:\" expressions, without |; %q is not one", p)
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// isAbsPath accepts Windows drive and UNC paths on every platform, so a
+// config can be validated on a development machine.
+func isAbsPath(p string) bool {
+	return len(p) >= 3 && p[1] == ':' && (p[2] == '\\' || p[2] == '/') ||
+		strings.HasPrefix(p, `\\`) || strings.HasPrefix(p, "/")
+}
+
+// cmdConfigValidate checks a config file (default: the active one) and
+// prints every problem as file:line:col.
+func cmdConfigValidate(d *daemon, args []string) int {
+	fs := newFlagSet("config validate")
+	asJSON := fs.Bool("json", false, "print the problems as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	path := d.configFile
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	errs := validateConfigFile(path)
+	if *asJSON {
+		type problem struct {
+			Path    string `json:"path,omitempty"`
+			Line    int    `json:"line,omitempty"`
+			Col     int    `json:"col,omitempty"`
+			Message string `json:"message"`
+		}
+		out := struct {
+			File     string    `json:"file"`
+			Valid    bool      `json:"valid"`
+			Problems []problem `json:"problems"`
+		}{path, len(errs) == 0, []problem{}}
+		for _, e := range errs {
+			out.Problems = append(out.Problems, problem{e.Path, e.Line, e.Col, e.Msg})
+		}
+		printJSON("config-validate", out)
+	} else {
+		for _, e := range errs {
+			if e.Line == 0 && e.Path == "" {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, e.Msg)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s:%s\n", path, withPosition(e))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	if !*asJSON {
+		fmt.Printf("%s: OK\n", path)
+	}
+	return 0
+}
+
+// validateConfigFile returns the problems of a config file; errors without
+// a position (unreadable file, bad signature) have only Msg set.
+func validateConfigFile(path string) []configError {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return []configError{{Msg: fmt.Sprintf("cannot read config: %v", err)}}
+	}
+	if _, _, err := parseConfig(raw); err != nil {
+		var errs configErrors
+		if errors.As(err, &errs) {
+			return errs
+		}
+		return []configError{{Msg: err.Error()}}
+	}
+	if err := verifyConfigSignature(path, raw); err != nil {
+		return []configError{{Msg: err.Error()}}
+	}
+	return nil
+}
+
+func withPosition(e configError) string {
+	if e.Line == 0 {
+		return " " + e.Error()
+	}
+	return e.Error()
+}
@@ -0,0 +1,106 @@
+// schema_test.go
+// Config range checks and the positions parse errors are reported at.
+
+package watchdog
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckRanges(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct {
+		name string
+		set  func(*config)
+		want string // paths of the errors, in order
+	}{
+		{"defaults", func(*config) {}, ""},
+		{"thresholds", func(c *config) {
+			c.Thresholds.GrowthSlope = -1
+		}, "thresholds.growthSlope"},
+		{"backup", func(c *config) {
+			c.Backup.Keep = 0
+		}, "backup.keep"},
+		{"weekly summary", func(c *config) {
+			c.WeeklySummary.Day = "someday"
+		}, "weeklySummary.day"},
+		{"quiet", func(c *config) {
+			c.Quiet.HoldForProcesses = `obs64.exe, C:\Tools\setup.exe`
+		}, "quiet.holdForProcesses"},
+		{"explorer stop", func(c *config) {
+			c.ExplorerStop.Method = "polite"
+		}, "explorerStop.method"},
+		{"update baseline", func(c *config) {
+			c.UpdateBaseline.Settle = duration(8 * 24 * time.Hour)
+		}, "updateBaseline.settle"},
+		{"deep clean", func(c *config) {
+			c.DeepClean.Schedule = "0 */6 * * *" // slots under 20h apart
+		}, "deepClean.schedule"},
+		{"breaker", func(c *config) {
+			c.Breaker.MaxFailures = 0
+		}, "breaker.maxFailures"},
+		{"heartbeat", func(c *config) {
+			c.Heartbeat.Webhook = "ftp://example.com/ping"
+			c.Heartbeat.File = `C:\Monitoring\`
+			c.Heartbeat.Timeout = duration(5 * time.Minute)
+		}, "heartbeat.webhook heartbeat.file heartbeat.timeout"},
+		{"incidents", func(c *config) {
+			c.Incidents.Webhook = "incidents.example.com"
+			c.Incidents.Timeout = duration(0)
+			c.Incidents.MaxOpen = duration(time.Minute)
+		}, "incidents.webhook incidents.timeout incidents.maxOpen"},
+		{"otel", func(c *config) {
+			c.OTel.Endpoint = "collector:4318"
+			c.OTel.Headers = "api-key"
+			c.OTel.Interval = duration(time.Second)
+		}, "otel.endpoint otel.headers otel.interval"},
+		{"reports", func(c *config) {
+			c.Reports.Jitter = duration(2 * time.Hour)
+			c.Reports.RetryMax = duration(time.Second)
+		}, "reports.jitter reports.retryMax"},
+		{"access", func(c *config) {
+			c.UI.TLSCert = filepath.Join(dir, "server.pem")
+			c.UI.ClientCA = "ca.pem"
+			c.Control.Allow = []string{"S-1-5-4", "Everyone"}
+			c.Control.Audit = "verbose"
+		}, "ui.clientCA ui.tlsKey control.allow control.audit"},
+		{"grpc", func(c *config) {
+			c.GRPC.Port = 70000
+		}, "grpc.port"},
+		{"cache files", func(c *config) {
+			c.CacheFiles.Icon = nil
+			c.CacheFiles.Thumbnail = []string{"re:thumbcache_(96|256)\\.db"}
+		}, "cacheFiles.icon cacheFiles.thumbnail"},
+		// Checked against sizeLimit, so not reported while that is invalid.
+		{"size limit", func(c *config) {
+			c.Thresholds.SizeLimit = 5 * gib
+			c.Thresholds.IndexMinSize = 6 * gib
+		}, "thresholds.sizeLimit"},
+	} {
+		cfg := defaultConfig()
+		c.set(&cfg)
+		var paths []string
+		for _, e := range cfg.checkRanges() {
+			paths = append(paths, e.Path)
+		}
+		if got := strings.Join(paths, " "); got != c.want {
+			t.Errorf("%s: config errors = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseConfigPositions(t *testing.T) {
+	// An out-of-range sizeLimit is one error at its position; indexMinSize,
+	// checked against it, is not reported as well.
+	for _, limit := range []string{`"9000000000GB"`, `-5`, `"5GB"`} {
+		_, _, err := parseConfig([]byte("{\n  \"thresholds\": {\n    \"sizeLimit\": " + limit + "\n  }\n}\n"))
+		var errs configErrors
+		if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "thresholds.sizeLimit" || errs[0].Line != 3 || errs[0].Col != 5 {
+			t.Fatalf("sizeLimit %s: %v", limit, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// score.go
+// Composite 0–100 health score. Each heuristic contributes its weight when
+// it passes; the size component contributes in proportion to the headroom
+// below the size limit, reduced further when the recent growth trend would
+// reach the limit within a day. A repair is triggered when the score falls
+// below health.repairBelowScore rather than on any single failure.
+//
+// The defaults keep v2.0.0 behaviour: every heuristic weighs more than the
+// margin between 100 and the threshold, so one failure still repairs.
+
+package watchdog
+
+import (
+	"math"
+	"time"
+)
+
+type heuristicWeights struct {
+	H1   float64 `json:"h1"`
+	H2   float64 `json:"h2"`
+	H3   float64 `json:"h3"`
+	H4   float64 `json:"h4"`
+	Size float64 `json:"size"`
+}
+
+type healthOptions struct {
+	Weights          heuristicWeights `json:"weights"`
+	RepairBelowScore int              `json:"repairBelowScore"`
+	Schedule         string           `json:"schedule"` // periodic checks on a calendar (schedule.go); "" uses thresholds.healthCheckEvery
+}
+
+func defaultHealthOptions() healthOptions {
+	return healthOptions{
+		Weights:          heuristicWeights{H1: 30, H2: 25, H3: 20, H4: 15, Size: 10},
+		RepairBelowScore: 90,
+	}
+}
+
+const (
+	maxSizeSamples = 2880 // 24 h of 30-second polls
+	trendWindow    = time.Hour
+	trendHorizon   = 24 * time.Hour
+)
+
+type sizeSample struct {
+	At     time.Time
+	SizeMB float64
+	Files  map[string]int64 // per-file sizes (delta.go); nil when not polled
+}
+
+// recordSize appends a poll sample to the in-memory trend buffer.
+func (d *daemon) recordSize(sizeMB float64, files map[string]fileSnap) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := sizeSample{At: d.clock.Now(), SizeMB: sizeMB}
+	if files != nil {
+		s.Files = make(map[string]int64, len(files))
+		for name, f := range files {
+			s.Files[name] = f.Size
+		}
+	}
+	d.samples = append(d.samples, s)
+	if len(d.samples) > maxSizeSamples {
+		d.samples = d.samples[len(d.samples)-maxSizeSamples:]
+	}
+}
+
+// growthMBPerHour is the growth rate across the trend window, or 0 when
+// there is not enough history yet.
+func (d *daemon) growthMBPerHour() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	first, last, ok := d.trendSpan()
+	if !ok {
+		return 0
+	}
+	return (last.SizeMB - first.SizeMB) / last.At.Sub(first.At).Hours()
+}
+
+// trendSpan returns the oldest and newest samples within the trend window;
+// ok is false with less than 0.1 h between them. Caller holds d.mu.
+func (d *daemon) trendSpan() (first, last sizeSample, ok bool) {
+	if len(d.samples) < 2 {
+		return first, last, false
+	}
+	last = d.samples[len(d.samples)-1]
+	first = last
+	for i := len(d.samples) - 1; i >= 0 && last.At.Sub(d.samples[i].At) <= trendWindow; i-- {
+		first = d.samples[i]
+	}
+	return first, last, last.At.Sub(first.At).Hours() >= 0.1
+}
+
+// sizeFactor is 1 with at least half the limit as headroom, falling to 0 at
+// the limit; a trend faster than thresholds.growthSlope that reaches the
+// limit within trendHorizon caps it at the fraction of the horizon
+// remaining.
+func (d *daemon) sizeFactor() float64 {
+	limit := d.cfg.Thresholds.SizeLimit.MB()
+	size := d.getCacheSizeMB()
+	f := math.Max(0, math.Min(1, (limit-size)/(limit/2)))
+	if rate := d.growthMBPerHour(); rate > d.cfg.Thresholds.GrowthSlope && size < limit {
+		eta := time.Duration((limit - size) / rate * float64(time.Hour))
+		if eta < trendHorizon {
+			f = math.Min(f, float64(eta)/float64(trendHorizon))
+		}
+	}
+	return f
+}
+
+// healthScore weighs the heuristics that ran (H1–H4, H6 with ledger.weight,
+// H7 with responsiveness.weight, H8 with shellStability.weight), the size
+// component and each answered
+// plug-in heuristic (plugins.weight).
+func (d *daemon) healthScore(heuristics map[string]bool, plugins []pluginResult) int {
+	w := d.cfg.Health.Weights
+	weights := map[string]float64{"h1": w.H1, "h2": w.H2, "h3": w.H3, "h4": w.H4, "h6": d.cfg.Ledger.Weight, "h7": d.cfg.Responsiveness.Weight, "h8": d.cfg.ShellStability.Weight}
+	total := w.Size + d.cfg.Plugins.Weight*float64(len(plugins))
+	for name := range heuristics {
+		total += weights[name]
+	}
+	if total <= 0 {
+		return 100
+	}
+	got := 0.0
+	for _, p := range plugins {
+		if p.Pass {
+			got += d.cfg.Plugins.Weight
+		}
+	}
+	for name, pass := range heuristics {
+		if pass {
+			got += weights[name]
+		}
+	}
+	got += w.Size * d.sizeFactor()
+	return int(math.Round(100 * got / total))
+}
@@ -0,0 +1,118 @@
+// selfcheck.go
+// The daemon runs for weeks; a leak in it would eventually hurt the machine
+// it is meant to keep healthy. The heartbeat reports the daemon's own
+// memory, goroutines, handles and uptime, and a once-a-minute self check
+// warns when memory or handles pass the selfCheck limits. With
+// selfCheck.restart the daemon then starts a fresh copy of itself and exits
+// (state.json carries the cooldown across); it does so only after an hour
+// of uptime, so limits set too low cannot cause a restart loop.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+const (
+	selfCheckEvery    = time.Minute
+	selfRestartMinUp  = time.Hour
+	defaultMaxMemory  = 256 * mib
+	defaultMaxHandles = 2000
+	selfUsageUnknown  = -1
+)
+
+type selfCheckOptions struct {
+	MaxMemory  byteSize `json:"maxMemory"`  // private bytes
+	MaxHandles int      `json:"maxHandles"` // open OS handles
+	Restart    bool     `json:"restart"`    // restart when over a limit
+}
+
+type selfUsage struct {
+	Memory     byteSize // private bytes (Go runtime total where unavailable)
+	Goroutines int
+	Handles    int // selfUsageUnknown where unavailable
+	Uptime     time.Duration
+}
+
+func (d *daemon) selfUsage() selfUsage {
+	u := selfUsage{
+		Memory:     byteSize(processMemory()),
+		Goroutines: runtime.NumGoroutine(),
+		Handles:    processHandles(),
+		Uptime:     d.clock.Since(d.started),
+	}
+	if u.Memory <= 0 {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		u.Memory = byteSize(ms.Sys)
+	}
+	return u
+}
+
+func (u selfUsage) String() string {
+	handles := "n/a"
+	if u.Handles != selfUsageUnknown {
+		handles = fmt.Sprint(u.Handles)
+	}
+	return fmt.Sprintf("mem %.1f MB, goroutines %d, handles %s, uptime %s",
+		u.Memory.MB(), u.Goroutines, handles, u.Uptime.Round(time.Minute))
+}
+
+// overLimit describes the first limit u exceeds, or "".
+func (u selfUsage) overLimit(o selfCheckOptions) string {
+	switch {
+	case u.Memory > o.MaxMemory:
+		return fmt.Sprintf("memory %.1f MB > %s", u.Memory.MB(), o.MaxMemory)
+	case u.Handles > o.MaxHandles:
+		return fmt.Sprintf("handles %d > %d", u.Handles, o.MaxHandles)
+	}
+	return ""
+}
+
+func (d *daemon) runSelfCheck() {
+	ticker := d.clock.NewTicker(selfCheckEvery)
+	defer ticker.Stop()
+	warned := false
+	for {
+		select {
+		case <-ticker.C():
+		case <-d.done:
+			return
+		}
+		u := d.selfUsage()
+		over := u.overLimit(d.cfg.SelfCheck)
+		if over == "" {
+			warned = false
+			continue
+		}
+		if !warned {
+			d.watchLog_("WARN", fmt.Sprintf("Self check: %s (%s).", over, u))
+			warned = true
+		}
+		if d.cfg.SelfCheck.Restart && u.Uptime >= selfRestartMinUp {
+			d.restartSelf(over)
+		}
+	}
+}
+
+// restartSelf starts a fresh copy of the daemon with the same arguments and
+// exits. If the copy cannot be started the daemon keeps running.
+func (d *daemon) restartSelf(why string) {
+	exe, err := os.Executable()
+	if err == nil {
+		cmd := exec.Command(exe, os.Args[1:]...)
+		err = cmd.Start()
+	}
+	if err != nil {
+		d.watchLog_("ERROR", fmt.Sprintf("Self restart failed: %v", err))
+		return
+	}
+	d.mu.Lock() // no repair launch between the save and the exit
+	d.saveState()
+	d.watchLog_("WARN", fmt.Sprintf("Restarting (%s). New instance started.", why))
+	os.Exit(0)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+// selfcheck_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+// Open file descriptors stand in for handles where /proc is available.
+
+package watchdog
+
+import "os"
+
+func processMemory() int64 { return 0 }
+
+func processHandles() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return selfUsageUnknown
+	}
+	return len(entries)
+}
@@ -0,0 +1,48 @@
+// selfcheck_windows.go
+// Private bytes (K32GetProcessMemoryInfo) and handle count
+// (GetProcessHandleCount) of the daemon process.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procK32GetProcessMemoryInfo = modKernel32.NewProc("K32GetProcessMemoryInfo")
+	procGetProcessHandleCount   = modKernel32.NewProc("GetProcessHandleCount")
+)
+
+// processMemoryCounters mirrors PROCESS_MEMORY_COUNTERS.
+type processMemoryCounters struct {
+	cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr // private bytes
+	PeakPagefileUsage          uintptr
+}
+
+func processMemory() int64 {
+	self, _ := syscall.GetCurrentProcess()
+	var pmc processMemoryCounters
+	pmc.cb = uint32(unsafe.Sizeof(pmc))
+	if r, _, _ := procK32GetProcessMemoryInfo.Call(uintptr(self), uintptr(unsafe.Pointer(&pmc)), uintptr(pmc.cb)); r == 0 {
+		return 0
+	}
+	return int64(pmc.PagefileUsage)
+}
+
+func processHandles() int {
+	self, _ := syscall.GetCurrentProcess()
+	var n uint32
+	if r, _, _ := procGetProcessHandleCount.Call(uintptr(self), uintptr(unsafe.Pointer(&n))); r == 0 {
+		return selfUsageUnknown
+	}
+	return int(n)
+}
@@ -0,0 +1,263 @@
+// settings.go
+// Layered effective configuration. Every leaf of the config tree is a
+// setting addressed by its dotted JSON path (e.g. thresholds.sizeLimit).
+// Layers apply in order of increasing precedence:
+//
+//   default < preset < config file < env < flag < policy (HKLM\SOFTWARE\Policies\IconCacheWatchdog)
+//
+// Presets are in presets.go, env and flag overrides are named in overrides.go.
+//
+// Policy values are named by setting path: REG_SZ holds the value as text
+// ("64MB", "true", "22:00-07:00"), REG_DWORD a number or 0/1. An override
+// that fails to parse or is out of range is skipped and reported. The source
+// of each value is kept for `config show-effective`.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const settingsPolicyKey = `SOFTWARE\Policies\IconCacheWatchdog`
+
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourcePolicy  = "policy"
+	sourceTarget  = "target" // targets.go
+)
+
+// configSources maps setting paths to the layer that set them; a missing
+// entry means the built-in default.
+type configSources map[string]string
+
+func (s configSources) of(path string) string {
+	if src, ok := s[path]; ok {
+		return src
+	}
+	return sourceDefault
+}
+
+type setting struct {
+	Path  string
+	Value reflect.Value // addressable field inside the config
+}
+
+// settingsOf lists the leaves of cfg in declaration order.
+func settingsOf(cfg *config) []setting {
+	var out []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for _, f := range orderedFields(v.Type()) {
+			fv := v.FieldByIndex(f.Index)
+			path := f.Name
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+			if fv.Kind() == reflect.Struct && !reflect.PointerTo(fv.Type()).Implements(jsonUnmarshaler) {
+				walk(fv, path)
+				continue
+			}
+			out = append(out, setting{Path: path, Value: fv})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return out
+}
+
+// orderedFields returns the JSON-visible fields of t in declaration order,
+// with Name replaced by the JSON key.
+func orderedFields(t reflect.Type) []reflect.StructField {
+	var out []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name != "" {
+			f.Name = name
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// setText parses text into the setting at path.
+func (c *config) setText(path, text string) error {
+	for _, s := range settingsOf(c) {
+		if s.Path == path {
+			return setFromText(s.Value, text)
+		}
+	}
+	return fmt.Errorf("unknown setting %q", path)
+}
+
+func setFromText(v reflect.Value, text string) error {
+	text = strings.TrimSpace(text)
+	switch p := v.Addr().Interface().(type) {
+	case *byteSize:
+		b, err := parseByteSize(text)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	case *duration:
+		d, err := parseDuration(text)
+		if err != nil {
+			return err
+		}
+		*p = d
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", text)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", text)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", text)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("cannot set a %s from text", v.Kind())
+	}
+	return nil
+}
+
+// applyLayer sets each path from values (text form) on top of cfg and
+// records source for it. Overrides that do not parse or fail the range
+// checks are skipped and returned as errors.
+func applyLayer(cfg *config, sources configSources, source string, values map[string]string) []error {
+	paths := make([]string, 0, len(values))
+	for p := range values {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var errs []error
+	for _, p := range paths {
+		trial := *cfg
+		if err := trial.setText(p, values[p]); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", source, p, err))
+			continue
+		}
+		if e := rangeErrorFor(trial, p); e != "" {
+			errs = append(errs, fmt.Errorf("%s %s: %s", source, p, e))
+			continue
+		}
+		*cfg = trial
+		sources[p] = source
+	}
+	return errs
+}
+
+func rangeErrorFor(cfg config, path string) string {
+	for _, e := range cfg.checkRanges() {
+		if e.Path == path || strings.HasPrefix(path, e.Path+".") {
+			return e.Msg
+		}
+	}
+	return ""
+}
+
+// policyValues reads machine policy for every known setting.
+func policyValues() map[string]string {
+	values := map[string]string{}
+	var probe config
+	for _, s := range settingsOf(&probe) {
+		data, typ, err := readRegistryValue(settingsPolicyKey, s.Path)
+		if err != nil {
+			continue
+		}
+		if n, ok := registryDWORD(data, typ); ok {
+			values[s.Path] = strconv.FormatUint(uint64(n), 10)
+		} else if typ == regSZ {
+			values[s.Path] = registryString(data)
+		}
+	}
+	// The script hash pin predates the policy key (integrity.go).
+	if _, ok := values["repairScriptSha256"]; !ok {
+		if data, typ, err := readRegistryValue(policyRegistryKey, "RepairScriptSHA256"); err == nil && typ == regSZ {
+			if h := strings.TrimSpace(registryString(data)); h != "" {
+				values["repairScriptSha256"] = h
+			}
+		}
+	}
+	return values
+}
+
+// effectiveConfig is the JSON form of show-effective (also served by
+// dashboard.go).
+type effectiveConfig struct {
+	Config  config            `json:"config"`
+	Sources map[string]string `json:"sources"`
+}
+
+func (d *daemon) effectiveConfig() effectiveConfig {
+	sources := map[string]string{}
+	for _, s := range settingsOf(&d.cfg) {
+		sources[s.Path] = d.cfgSources.of(s.Path)
+	}
+	return effectiveConfig{d.cfg, sources}
+}
+
+// cmdConfigShowEffective prints every setting with its value and source.
+func cmdConfigShowEffective(d *daemon, args []string) int {
+	fs := newFlagSet("config show-effective")
+	asJSON := fs.Bool("json", false, "print effective config and sources as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *asJSON {
+		printJSON("config-show-effective", d.effectiveConfig())
+		return 0
+	}
+
+	fmt.Printf("Config file: %s (%s)\n", d.configFile, d.cfgFileState)
+	fmt.Printf("Overrides:   %s* environment, --flags\n", envPrefix)
+	fmt.Printf("Policy:      HKLM\\%s\n", settingsPolicyKey)
+	for _, err := range d.cfgWarnings {
+		fmt.Printf("Ignored:     %v\n", err)
+	}
+	fmt.Println()
+	settings := settingsOf(&d.cfg)
+	width := 0
+	for _, s := range settings {
+		width = max(width, len(s.Path))
+	}
+	for _, s := range settings {
+		raw, _ := json.Marshal(s.Value.Interface())
+		fmt.Printf("  %-*s  %-24s %s\n", width, s.Path, raw, d.cfgSources.of(s.Path))
+	}
+	return 0
+}
+
+// fileState describes the outcome of loading the config file.
+func fileState(path string, err error) string {
+	switch {
+	case err != nil:
+		return "rejected, defaults used: " + err.Error()
+	case fileDigest(path) == "":
+		return "not found, defaults used"
+	}
+	return "loaded"
+}
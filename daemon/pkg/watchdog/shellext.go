@@ -0,0 +1,227 @@
+// shellext.go
+// Third-party shell extension churn. Icon overlay and icon handlers run
+// inside Explorer and are a common cause of cache corruption that keeps
+// coming back after every repair. Each health check inventories them
+// (shellext_windows.go) into shellext.json, remembering when each was first
+// seen; the first inventory is the baseline. Health-check repairs are
+// recorded as corruptions (state.json). An extension that appeared shortly
+// before repeated corruptions — more than in the same period before it was
+// installed — is named as a likely culprit in the health log, status.json
+// and `shellext`.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	extOverlay     = "overlay"
+	extIconHandler = "icon-handler"
+
+	churnWindow         = 14 * 24 * time.Hour // corruptions counted after an install
+	churnMinCorruptions = 2
+	maxCorruptions      = 50 // kept in state.json
+)
+
+type shellExtension struct {
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"` // overlay identifier or file type
+	CLSID     string    `json:"clsid"`
+	DLL       string    `json:"dll,omitempty"`
+	FirstSeen time.Time `json:"firstSeen"`
+	Baseline  bool      `json:"baseline,omitempty"` // present at the first inventory
+}
+
+func (e shellExtension) key() string { return e.Kind + "|" + e.Name + "|" + e.CLSID }
+
+func (e shellExtension) String() string {
+	s := fmt.Sprintf("%s %q %s", e.Kind, strings.TrimSpace(e.Name), e.CLSID)
+	if e.DLL != "" {
+		s += " (" + e.DLL + ")"
+	}
+	return s
+}
+
+type shellSuspect struct {
+	shellExtension
+	Corruptions int `json:"corruptions"` // within churnWindow after FirstSeen
+	Before      int `json:"corruptionsBefore"`
+}
+
+type shellInventory struct {
+	Updated    time.Time        `json:"updated"`
+	Extensions []shellExtension `json:"extensions"`
+}
+
+func (d *daemon) shellInventoryFile() string {
+	return filepath.Join(d.dataDir, "shellext.json")
+}
+
+// recordCorruption notes a health-check repair. Repeated failing checks
+// within one cooldown count once. Caller holds d.mu.
+func (d *daemon) recordCorruption() {
+	now := d.clock.Now()
+	if n := len(d.corruptions); n > 0 && now.Sub(d.corruptions[n-1]) < d.cfg.Thresholds.Cooldown.D() {
+		return
+	}
+	d.corruptions = append(d.corruptions, now)
+	if len(d.corruptions) > maxCorruptions {
+		d.corruptions = d.corruptions[len(d.corruptions)-maxCorruptions:]
+	}
+	d.saveState()
+}
+
+// checkShellExtensions refreshes the inventory and reports suspects.
+func (d *daemon) checkShellExtensions() {
+	found, err := enumerateShellExtensions()
+	if err != nil {
+		return
+	}
+	now := d.clock.Now()
+	var inv shellInventory
+	raw, err := os.ReadFile(d.shellInventoryFile())
+	baseline := err != nil
+	if !baseline {
+		json.Unmarshal(raw, &inv)
+	}
+	settling := d.updateSettling() // extensions brought by a Windows update
+	known := map[string]shellExtension{}
+	for _, e := range inv.Extensions {
+		known[e.key()] = e
+	}
+	for i, e := range found {
+		if k, ok := known[e.key()]; ok {
+			found[i].FirstSeen, found[i].Baseline = k.FirstSeen, k.Baseline
+			delete(known, e.key())
+			continue
+		}
+		found[i].FirstSeen, found[i].Baseline = now, baseline || settling
+		if settling && !baseline {
+			d.healthLog_("INFO", fmt.Sprintf("Shell extension added with the Windows update: %s", found[i]))
+		} else if !baseline {
+			d.healthLog_("INFO", fmt.Sprintf("New shell extension: %s", found[i]))
+		}
+	}
+	for _, e := range known {
+		d.healthLog_("INFO", fmt.Sprintf("Shell extension removed: %s", e))
+	}
+	if baseline {
+		d.healthLog_("INFO", fmt.Sprintf("Shell extension baseline: %d overlay/icon handlers.", len(found)))
+	}
+	inv = shellInventory{Updated: now, Extensions: found}
+	if out, err := json.MarshalIndent(inv, "", "  "); err == nil {
+		os.WriteFile(d.shellInventoryFile(), out, 0644)
+	}
+
+	d.mu.Lock()
+	suspects := churnSuspects(found, d.corruptions)
+	d.shellSuspects = suspects
+	var keys []string
+	for _, s := range suspects {
+		keys = append(keys, s.key())
+	}
+	changed := strings.Join(keys, ";") != d.shellReported
+	d.shellReported = strings.Join(keys, ";")
+	d.mu.Unlock()
+
+	if changed {
+		for i, s := range suspects {
+			label := "Possible culprit"
+			if i == 0 {
+				label = "Likely culprit"
+			}
+			d.healthLog_("WARN", fmt.Sprintf("SHELL EXTENSION CHURN: %d corruption repair(s) within %s after %s was installed %s (%d before). %s: %s",
+				s.Corruptions, duration(churnWindow), strings.TrimSpace(s.Name), formatTime(s.FirstSeen), s.Before, label, s))
+		}
+		if len(suspects) > 0 {
+			d.logMsg(msgShellExtCulprit, suspects[0])
+		}
+	}
+}
+
+// churnSuspects returns the non-baseline extensions followed by repeated
+// corruptions, most corruptions first, then most recently installed.
+func churnSuspects(exts []shellExtension, corruptions []time.Time) []shellSuspect {
+	var out []shellSuspect
+	for _, e := range exts {
+		if e.Baseline {
+			continue
+		}
+		s := shellSuspect{shellExtension: e}
+		for _, c := range corruptions {
+			switch {
+			case !c.Before(e.FirstSeen) && c.Sub(e.FirstSeen) <= churnWindow:
+				s.Corruptions++
+			case c.Before(e.FirstSeen) && e.FirstSeen.Sub(c) <= churnWindow:
+				s.Before++
+			}
+		}
+		if s.Corruptions >= churnMinCorruptions && s.Corruptions > s.Before {
+			out = append(out, s)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Corruptions != out[j].Corruptions {
+			return out[i].Corruptions > out[j].Corruptions
+		}
+		return out[i].FirstSeen.After(out[j].FirstSeen)
+	})
+	return out
+}
+
+// cmdShellExt lists the inventory recorded by the daemon and the suspects.
+func cmdShellExt(d *daemon, args []string) int {
+	fs := newFlagSet("shellext")
+	asJSON := fs.Bool("json", false, "print inventory and suspects as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	raw, err := os.ReadFile(d.shellInventoryFile())
+	if err != nil {
+		fmt.Println("No shell extension inventory yet (written by the daemon's health checks).")
+		return 1
+	}
+	var inv shellInventory
+	if err := json.Unmarshal(raw, &inv); err != nil {
+		fmt.Fprintf(os.Stderr, "shellext.json is unreadable: %v\n", err)
+		return 1
+	}
+	d.loadState()
+	suspects := churnSuspects(inv.Extensions, d.corruptions)
+
+	if *asJSON {
+		printJSON("shellext", struct {
+			shellInventory
+			Suspects []shellSuspect `json:"suspects"`
+		}{inv, suspects})
+		return 0
+	}
+	fmt.Printf("Inventory updated %s; %d corruption repair(s) on record.\n\n", formatTime(inv.Updated), len(d.corruptions))
+	for _, e := range inv.Extensions {
+		seen := "baseline"
+		if !e.Baseline {
+			seen = "new " + formatTime(e.FirstSeen)
+		}
+		fmt.Printf("  %-12s %-38s %-28s %s\n", e.Kind, e.CLSID, seen, strings.TrimSpace(e.Name))
+	}
+	fmt.Println()
+	if len(suspects) == 0 {
+		fmt.Println("No extension coincides with repeated cache corruption.")
+		return 0
+	}
+	for i, s := range suspects {
+		label := "Possible culprit"
+		if i == 0 {
+			label = "Likely culprit  "
+		}
+		fmt.Printf("%s: %s — %d corruption(s) within %s of install, %d before\n", label, s, s.Corruptions, duration(churnWindow), s.Before)
+	}
+	return 0
+}
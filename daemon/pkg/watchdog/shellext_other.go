@@ -0,0 +1,10 @@
+//go:build !windows
+
+// shellext_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func enumerateShellExtensions() ([]shellExtension, error) { return nil, errNoRegistry }
+
+func overlayHandlers() ([]shellExtension, error) { return nil, errNoRegistry }
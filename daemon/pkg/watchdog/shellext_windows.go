@@ -0,0 +1,61 @@
+// shellext_windows.go
+// Registered icon overlay handlers (ShellIconOverlayIdentifiers) and
+// per-file-type icon handlers (HKCR\<type>\shellex\IconHandler), with the
+// DLL behind each CLSID.
+
+package watchdog
+
+import (
+	"strings"
+	"syscall"
+)
+
+const overlayIdentifiersKey = `SOFTWARE\Microsoft\Windows\CurrentVersion\Explorer\ShellIconOverlayIdentifiers`
+
+func enumerateShellExtensions() ([]shellExtension, error) {
+	exts, err := overlayHandlers()
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := registrySubkeys(syscall.HKEY_CLASSES_ROOT, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range types {
+		if clsid := defaultString(syscall.HKEY_CLASSES_ROOT, t+`\shellex\IconHandler`); clsid != "" {
+			exts = append(exts, shellExtension{Kind: extIconHandler, Name: t, CLSID: clsid})
+		}
+	}
+
+	for i := range exts {
+		if exts[i].CLSID != "" {
+			exts[i].DLL = defaultString(syscall.HKEY_CLASSES_ROOT, `CLSID\`+exts[i].CLSID+`\InprocServer32`)
+		}
+	}
+	return exts, nil
+}
+
+// overlayHandlers lists ShellIconOverlayIdentifiers in registry order, which
+// is the order in which Explorer fills its overlay slots.
+func overlayHandlers() ([]shellExtension, error) {
+	names, err := registrySubkeys(syscall.HKEY_LOCAL_MACHINE, overlayIdentifiersKey)
+	if err != nil {
+		return nil, err
+	}
+	var exts []shellExtension
+	for _, name := range names {
+		clsid := defaultString(syscall.HKEY_LOCAL_MACHINE, overlayIdentifiersKey+`\`+name)
+		exts = append(exts, shellExtension{Kind: extOverlay, Name: name, CLSID: clsid})
+	}
+	return exts, nil
+}
+
+// defaultString reads the default value of root\path as a string.
+func defaultString(root syscall.Handle, path string) string {
+	data, typ, err := readRegistryValueFrom(root, path, "")
+	if err != nil || (typ != syscall.REG_SZ && typ != syscall.REG_EXPAND_SZ) {
+		return ""
+	}
+	return strings.TrimSpace(registryString(data))
+}
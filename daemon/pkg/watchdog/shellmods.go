@@ -0,0 +1,225 @@
+// shellmods.go
+// Shell replacements. ExplorerPatcher, StartAllBack and Open-Shell replace
+// the taskbar or Start menu; some keep icon caches of their own next to
+// Explorer's, and some run a host process outside Explorer that keeps its
+// icons in memory until it is restarted. A repair that only clears
+// iconcache_*.db and restarts Explorer leaves those stale, and the user
+// still sees broken icons in the Start menu.
+//
+// Every health check looks for the known mods by install folder and lists
+// them in status.json. With shellMods.watch, their icon caches are polled by
+// Layer B with Explorer's (they count towards thresholds.sizeLimit and show
+// up in the per-file growth) and cleared by the repair script. With
+// shellMods.restartHosts (default), the repair script stops their host
+// processes with Explorer and starts them again afterwards. Repairs handed
+// to the elevated broker or the repair task leave the mods alone.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type shellModOptions struct {
+	Watch        bool `json:"watch"`        // poll and clear the mods' icon caches
+	RestartHosts bool `json:"restartHosts"` // restart their host processes with Explorer
+}
+
+func defaultShellModOptions() shellModOptions {
+	return shellModOptions{RestartHosts: true}
+}
+
+// knownShellMod describes a shell replacement. Paths start with an
+// environment variable; %LOCALAPPDATA% is the monitored user's.
+type knownShellMod struct {
+	Name    string
+	Install []string // folders that mark it installed
+	Caches  []string // its icon cache files
+	Hosts   []string // its processes outside Explorer (image names)
+}
+
+var knownShellMods = []knownShellMod{
+	{
+		Name:    "ExplorerPatcher",
+		Install: []string{`%ProgramFiles%\ExplorerPatcher`},
+		Hosts:   []string{"ep_weather_host.exe"},
+	},
+	{
+		Name:    "StartAllBack",
+		Install: []string{`%ProgramFiles%\StartAllBack`, `%LOCALAPPDATA%\StartAllBack`},
+	},
+	{
+		Name:    "Open-Shell",
+		Install: []string{`%ProgramFiles%\Open-Shell`},
+		Caches:  []string{`%LOCALAPPDATA%\OpenShell\DataCache.db`},
+		Hosts:   []string{"StartMenu.exe"},
+	},
+}
+
+// shellMod is a detected shell replacement.
+type shellMod struct {
+	Name   string   `json:"name"`
+	Dir    string   `json:"dir"`
+	Caches []string `json:"caches,omitempty"`
+	Hosts  []string `json:"hosts,omitempty"`
+}
+
+func (m shellMod) String() string {
+	s := m.Name + " in " + m.Dir
+	if len(m.Caches) > 0 {
+		s += "; icon cache " + strings.Join(m.Caches, ", ")
+	}
+	if len(m.Hosts) > 0 {
+		s += "; host " + strings.Join(m.Hosts, ", ")
+	}
+	return s
+}
+
+// profileLocalAppData is %LOCALAPPDATA% of the monitored profile, above the
+// cache's Microsoft\Windows\Explorer, or else our own.
+func (d *daemon) profileLocalAppData() string {
+	dir := filepath.Clean(d.cacheDir)
+	for _, name := range []string{"Explorer", "Windows", "Microsoft"} {
+		if !strings.EqualFold(filepath.Base(dir), name) {
+			return os.Getenv("LOCALAPPDATA")
+		}
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// expandModPath resolves a knownShellMod path, or returns "" when its
+// variable is not set. %ProgramFiles% is the native folder (wow64.go).
+func expandModPath(path, localAppData string) string {
+	v, rest, ok := strings.Cut(strings.TrimPrefix(path, "%"), "%")
+	if !ok {
+		return path
+	}
+	base := os.Getenv(v)
+	switch {
+	case strings.EqualFold(v, "LOCALAPPDATA"):
+		base = localAppData
+	case strings.EqualFold(v, "ProgramFiles"):
+		base = nativeProgramFiles()
+	}
+	if base == "" {
+		return ""
+	}
+	return filepath.Join(append([]string{base}, strings.Split(strings.TrimPrefix(rest, `\`), `\`)...)...)
+}
+
+// detectShellMods returns the known mods installed for the profile whose
+// %LOCALAPPDATA% is given.
+func detectShellMods(localAppData string) []shellMod {
+	var out []shellMod
+	for _, k := range knownShellMods {
+		for _, dir := range k.Install {
+			dir = expandModPath(dir, localAppData)
+			if info, err := os.Stat(dir); dir == "" || err != nil || !info.IsDir() {
+				continue
+			}
+			m := shellMod{Name: k.Name, Dir: dir, Hosts: k.Hosts}
+			for _, c := range k.Caches {
+				if c = expandModPath(c, localAppData); c != "" {
+					m.Caches = append(m.Caches, c)
+				}
+			}
+			out = append(out, m)
+			break
+		}
+	}
+	return out
+}
+
+// checkShellMods refreshes the detected mods and logs the changes.
+func (d *daemon) checkShellMods() {
+	mods := detectShellMods(d.profileLocalAppData())
+	d.mu.Lock()
+	prev := d.shellMods
+	d.shellMods = mods
+	d.mu.Unlock()
+	for _, m := range mods {
+		if !slices.ContainsFunc(prev, func(p shellMod) bool { return p.Name == m.Name }) {
+			d.healthLog_("INFO", fmt.Sprintf("Shell replacement detected: %s.%s", m, d.shellModHandling(m)))
+		}
+	}
+	for _, p := range prev {
+		if !slices.ContainsFunc(mods, func(m shellMod) bool { return m.Name == p.Name }) {
+			d.healthLog_("INFO", fmt.Sprintf("Shell replacement removed: %s.", p.Name))
+		}
+	}
+}
+
+// shellModHandling says what repairs do about m.
+func (d *daemon) shellModHandling(m shellMod) string {
+	var s string
+	switch {
+	case len(m.Caches) > 0 && d.cfg.ShellMods.Watch:
+		s = " Its icon cache is polled and cleared by repairs."
+	case len(m.Caches) > 0:
+		s = " Its icon cache is left alone (shellMods.watch is off)."
+	}
+	switch {
+	case len(m.Hosts) > 0 && d.cfg.ShellMods.RestartHosts:
+		s += " Repairs restart its host with Explorer."
+	case len(m.Hosts) > 0:
+		s += " Its host is not restarted by repairs (shellMods.restartHosts is off)."
+	case len(m.Caches) == 0:
+		s = " It runs inside Explorer and is restarted with it."
+	}
+	return s
+}
+
+// modCacheFiles returns the watched mods' cache files by display name
+// (mod\file).
+func (d *daemon) modCacheFiles() map[string]string {
+	if !d.cfg.ShellMods.Watch {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	files := map[string]string{}
+	for _, m := range d.shellMods {
+		for _, c := range m.Caches {
+			files[m.Name+`\`+filepath.Base(c)] = c
+		}
+	}
+	return files
+}
+
+// shellModParams returns the repair script parameters for the detected
+// mods: -ModCaches and -ModHosts, '|'-separated. Caller holds d.mu.
+func (d *daemon) shellModParams() []string {
+	var caches, hosts []string
+	for _, m := range d.shellMods {
+		if d.cfg.ShellMods.Watch {
+			caches = append(caches, m.Caches...)
+		}
+		if d.cfg.ShellMods.RestartHosts {
+			hosts = append(hosts, m.Hosts...)
+		}
+	}
+	var params []string
+	if len(caches) > 0 {
+		params = append(params, "-ModCaches", strings.Join(caches, "|"))
+	}
+	if len(hosts) > 0 {
+		params = append(params, "-ModHosts", strings.Join(hosts, "|"))
+	}
+	return params
+}
+
+// shellModHost reports whether image is a known mod's host process, which
+// start-shell -host may start.
+func shellModHost(image string) bool {
+	for _, k := range knownShellMods {
+		if slices.ContainsFunc(k.Hosts, func(h string) bool { return strings.EqualFold(h, image) }) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+// shellrefresh_windows.go
+// The F5 of the desktop and the open Explorer windows (softrepair.go):
+// shell32!SHChangeNotify(SHCNE_ASSOCCHANGED) makes the shell drop its icon
+// lookups, then every shell view (SHELLDLL_DefView) gets the WM_COMMAND its
+// Refresh menu item sends. This is what Shell.Application's Refresh does
+// over COM, without COM. The messages are posted, so a hung window cannot
+// block the daemon.
+
+package watchdog
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	wmCommand         = 0x0111
+	defViewRefresh    = 0x7103 // the shell view's Refresh command (F5)
+	shcneAssocChanged = 0x08000000
+	shcnfIDList       = 0x0000
+	defViewClass      = "SHELLDLL_DefView"
+)
+
+var (
+	procFindWindowExW    = modUser32.NewProc("FindWindowExW")
+	procEnumWindows      = modUser32.NewProc("EnumWindows")
+	procEnumChildWindows = modUser32.NewProc("EnumChildWindows")
+	procGetClassNameW    = modUser32.NewProc("GetClassNameW")
+	procPostMessageW     = modUser32.NewProc("PostMessageW")
+	procSHChangeNotify   = modShell32.NewProc("SHChangeNotify")
+)
+
+// enumFound collects the windows of one enumeration, under enumMu; the
+// callback is created once because Windows limits their number.
+var (
+	enumMu      sync.Mutex
+	enumFound   []uintptr
+	enumCollect = syscall.NewCallback(func(hwnd, _ uintptr) uintptr {
+		enumFound = append(enumFound, hwnd)
+		return 1
+	})
+)
+
+// enumWindows lists the top-level windows of this desktop, or all
+// descendants of parent.
+func enumWindows(parent uintptr) []uintptr {
+	enumMu.Lock()
+	defer enumMu.Unlock()
+	enumFound = nil
+	if parent == 0 {
+		procEnumWindows.Call(enumCollect, 0)
+	} else {
+		procEnumChildWindows.Call(parent, enumCollect, 0)
+	}
+	return enumFound
+}
+
+func windowClass(hwnd uintptr) string {
+	var buf [64]uint16
+	n, _, _ := procGetClassNameW.Call(hwnd, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	return syscall.UTF16ToString(buf[:n])
+}
+
+func postRefresh(view uintptr) bool {
+	ok, _, _ := procPostMessageW.Call(view, wmCommand, defViewRefresh, 0)
+	return ok != 0
+}
+
+// refreshShellWindows refreshes the desktop and every Explorer window.
+func refreshShellWindows() shellRefresh {
+	procSHChangeNotify.Call(shcneAssocChanged, shcnfIDList, 0, 0)
+	class, _ := syscall.UTF16PtrFromString(defViewClass)
+	var r shellRefresh
+	for _, top := range enumWindows(0) {
+		switch windowClass(top) {
+		case "Progman", "WorkerW":
+			// The desktop's view moves from Progman to a WorkerW while a
+			// wallpaper slideshow or animated wallpaper runs.
+			view, _, _ := procFindWindowExW.Call(top, 0, uintptr(unsafe.Pointer(class)), 0)
+			if view != 0 && postRefresh(view) {
+				r.Desktop = true
+			}
+		case "CabinetWClass":
+			// One view per tab on Windows 11.
+			refreshed := false
+			for _, child := range enumWindows(top) {
+				if windowClass(child) == defViewClass && postRefresh(child) {
+					refreshed = true
+				}
+			}
+			if refreshed {
+				r.Windows++
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,95 @@
+// shellrescue.go
+// Shell rescue. A repair that died between stopping and starting Explorer,
+// or an Explorer crash that Winlogon did not restart, leaves the user at a
+// black desktop with no taskbar, and often nothing on screen to fix it
+// with. Every Layer B poll checks for explorer.exe; when none has run for
+// shellRescue.after in an active, unlocked session, the daemon starts the
+// shell itself and logs the rescue (event 116). Nothing is done while the
+// session is locked or disconnected (Explorer may be gone on purpose, e.g.
+// a kiosk shell swap in progress) or while a repair script runs — the script
+// stops Explorer itself. After shellRescueAttempts starts that do not keep
+// Explorer up, the daemon gives up until it sees Explorer again. Single-user
+// mode only; on session hosts Winlogon restarts the shell (AutoRestartShell).
+// The shell is started through startUserProcess (userprocess.go), so an
+// elevated daemon does not leave the user with an elevated Explorer.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const shellRescueAttempts = 3
+
+type shellRescueOptions struct {
+	Enabled bool     `json:"enabled"`
+	After   duration `json:"after"` // time without Explorer before starting it
+}
+
+func defaultShellRescueOptions() shellRescueOptions {
+	return shellRescueOptions{Enabled: true, After: duration(30 * time.Second)}
+}
+
+// checkShell runs on every Layer B poll.
+func (d *daemon) checkShell() {
+	if !d.cfg.ShellRescue.Enabled || d.session != nil || d.sim != nil {
+		return
+	}
+	d.rescueShell(d.explorerRunning(), sessionUnlocked(), startExplorer)
+}
+
+// rescueShell advances the rescue with one observation: whether Explorer
+// runs and whether the session is active and unlocked. start launches the
+// shell.
+func (d *daemon) rescueShell(running, unlocked bool, start func() error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if running {
+		if d.shellRescues > 0 {
+			d.repairLog_("INFO", "Shell rescue: Explorer is running again.")
+		}
+		d.shellMissing, d.shellRescues = time.Time{}, 0
+		return
+	}
+	if !unlocked || d.repairRunning() {
+		d.shellMissing = time.Time{}
+		return
+	}
+	if d.shellMissing.IsZero() {
+		d.shellMissing = d.clock.Now()
+		return
+	}
+	gone := d.clock.Since(d.shellMissing)
+	if gone < d.cfg.ShellRescue.After.D() || d.shellRescues > shellRescueAttempts {
+		return
+	}
+	if d.shellRescues == shellRescueAttempts {
+		d.shellRescues++
+		d.logMsg(msgShellGaveUp, shellRescueAttempts)
+		return
+	}
+
+	d.shellRescues++
+	d.shellMissing = d.clock.Now()
+	if err := start(); err != nil {
+		d.logMsg(msgShellNoStart, err)
+		return
+	}
+	d.logMsg(msgShellRescue, gone.Round(time.Second), d.shellRescues, shellRescueAttempts)
+	d.historyEvent(historyRescue, fmt.Sprintf("shell started after %s without Explorer", gone.Round(time.Second)))
+	d.metrics.inc(mShellRescues)
+}
+
+// repairRunning reports whether a repair script is between stopping and
+// starting Explorer: a direct repair, or any script holding the repair lock.
+// Caller holds d.mu.
+func (d *daemon) repairRunning() bool {
+	if d.inFlight != nil {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(d.dataDir, "repair.lock"))
+	return err == nil && time.Since(info.ModTime()) < interruptedRepairMaxAge
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+// shellrescue_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func sessionUnlocked() bool { return false }
@@ -0,0 +1,36 @@
+// shellrescue_windows.go
+// Session state for the shell rescue via WTSQuerySessionInformationW
+// (WTSSessionInfoEx, Windows 8+).
+
+package watchdog
+
+import "unsafe"
+
+const (
+	wtsCurrentSession     = 0xFFFFFFFF
+	wtsSessionInfoEx      = 25
+	wtsSessionStateUnlock = 1
+)
+
+// wtsInfoEx is the head of WTSINFOEXW: Level, then the level-1 union, which
+// is 8-byte aligned.
+type wtsInfoEx struct {
+	Level        uint32
+	_            uint32
+	SessionID    uint32
+	SessionState uint32
+	SessionFlags int32
+}
+
+// sessionUnlocked reports whether this process's session is active
+// (connected) and unlocked.
+func sessionUnlocked() bool {
+	var info *wtsInfoEx
+	var n uint32
+	r, _, _ := procWTSQuerySessionInformationW.Call(0, wtsCurrentSession, wtsSessionInfoEx, uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&n)))
+	if r == 0 || info == nil {
+		return false
+	}
+	defer procWTSFreeMemory.Call(uintptr(unsafe.Pointer(info)))
+	return info.Level == 1 && info.SessionState == wtsActive && info.SessionFlags == wtsSessionStateUnlock
+}
@@ -0,0 +1,175 @@
+// shellstability.go
+// H8: shell stability. An Explorer that keeps crashing or being restarted
+// is both a symptom and a cause of cache damage: a shell killed mid-write
+// leaves torn database pages behind, and a faulty shell extension that
+// crashes Explorer usually corrupts the cache too. H8 counts two signals
+// over shellStability.window:
+//
+//   - Windows Error Reporting reports for explorer.exe (AppCrash_explorer.exe_*
+//     and AppHang_explorer.exe_* in the user's and the machine's WER
+//     ReportArchive and ReportQueue folders), read at every health check;
+//   - shell launches: the process owning the taskbar (Shell_TrayWnd) is
+//     looked up at every Layer B poll, and a new process that no repair
+//     started means Winlogon (AutoRestartShell) or the user restarted it.
+//
+// A crash that Winlogon recovers from shows up once in each, so H8 takes
+// the larger of the two counts; it fails above shellStability.maxEvents.
+// The counts also feed the metrics. H8 weighs shellStability.weight (10):
+// on its own it leaves the score just above the default threshold and
+// tips it below together with any other failure. Only the single-user
+// daemon tracks the shell; the taskbar belongs to the interactive desktop.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type shellStabilityOptions struct {
+	Enabled   bool     `json:"enabled"`
+	Window    duration `json:"window"`    // crashes and restarts counted over this span
+	MaxEvents int      `json:"maxEvents"` // H8 fails above this many
+	Weight    float64  `json:"weight"`    // score weight of H8 (score.go)
+}
+
+func defaultShellStabilityOptions() shellStabilityOptions {
+	return shellStabilityOptions{Enabled: true, Window: duration(24 * time.Hour), MaxEvents: 2, Weight: 10}
+}
+
+// stabilityStatus is H8's evidence.
+type stabilityStatus struct {
+	Window   duration `json:"window"`
+	Crashes  int      `json:"crashes"`  // WER crash reports for explorer.exe
+	Hangs    int      `json:"hangs"`    // WER hang reports for explorer.exe
+	Restarts int      `json:"restarts"` // shell launches not started by a repair
+	Reports  []string `json:"reports,omitempty"`
+}
+
+// events is the number of incidents: a crash Winlogon recovered from is
+// both a report and a restart.
+func (s stabilityStatus) events() int {
+	return max(s.Crashes+s.Hangs, s.Restarts)
+}
+
+func (s stabilityStatus) String() string {
+	return fmt.Sprintf("%s, %s and %s in the last %s",
+		plural(s.Crashes, "crash report", "no crash reports"), plural(s.Hangs, "hang report", "no hang reports"),
+		plural(s.Restarts, "restart", "no restarts"), s.Window)
+}
+
+func (d *daemon) shellStabilityActive() bool {
+	return d.cfg.ShellStability.Enabled && d.session == nil && d.sim == nil
+}
+
+// trackShell runs on every Layer B poll.
+func (d *daemon) trackShell() {
+	if !d.shellStabilityActive() {
+		return
+	}
+	d.observeShell(shellProcessID())
+}
+
+// observeShell records the taskbar's process at one poll; 0 means there
+// was no taskbar.
+func (d *daemon) observeShell(pid uint32) {
+	if pid == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev, seen := d.shellPID, d.shellSeen
+	d.shellPID, d.shellSeen = pid, d.clock.Now()
+	if prev == 0 || pid == prev {
+		return
+	}
+	if d.repairRunning() || d.lastRepair.After(seen) {
+		return // a repair or Explorer restart of ours
+	}
+	d.shellRestarts = append(d.shellRestarts, d.clock.Now())
+	cutoff := d.clock.Now().Add(-d.cfg.ShellStability.Window.D())
+	for len(d.shellRestarts) > 0 && d.shellRestarts[0].Before(cutoff) {
+		d.shellRestarts = d.shellRestarts[1:]
+	}
+	d.metrics.inc(mShellRestarts)
+	d.watchLog_("WARN", fmt.Sprintf("Explorer shell restarted outside a repair (process %d replaced %d); %s in the last %s.",
+		pid, prev, plural(len(d.shellRestarts), "restart", "no restarts"), d.cfg.ShellStability.Window))
+}
+
+// werReportDirs are the WER folders that collect explorer.exe reports:
+// the user's (next to the cache, under %LOCALAPPDATA%\Microsoft\Windows)
+// and the machine's.
+func (d *daemon) werReportDirs() []string {
+	roots := []string{filepath.Join(filepath.Dir(d.cacheDir), "WER")}
+	if pd := os.Getenv("ProgramData"); pd != "" {
+		roots = append(roots, filepath.Join(pd, "Microsoft", "Windows", "WER"))
+	}
+	var dirs []string
+	for _, root := range roots {
+		dirs = append(dirs, filepath.Join(root, "ReportArchive"), filepath.Join(root, "ReportQueue"))
+	}
+	return dirs
+}
+
+// checkH8Stability counts Explorer crashes, hangs and restarts in the
+// window.
+func (d *daemon) checkH8Stability() (bool, *stabilityStatus) {
+	o := d.cfg.ShellStability
+	since := d.clock.Now().Add(-o.Window.D())
+	d.mu.Lock()
+	if since.Before(d.osUpdated) {
+		since = d.osUpdated // re-baselined by a Windows update (updatebaseline.go)
+	}
+	d.mu.Unlock()
+	st := &stabilityStatus{Window: o.Window}
+	seen := map[string]bool{}
+	for _, dir := range d.werReportDirs() {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			name := strings.ToLower(e.Name())
+			crash := strings.HasPrefix(name, "appcrash_explorer.exe_")
+			if !e.IsDir() || !crash && !strings.HasPrefix(name, "apphang_explorer.exe_") {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil || info.ModTime().Before(since) || seen[e.Name()] {
+				continue
+			}
+			seen[e.Name()] = true
+			if crash {
+				st.Crashes++
+			} else {
+				st.Hangs++
+			}
+			st.Reports = append(st.Reports, e.Name())
+		}
+	}
+	sort.Strings(st.Reports)
+
+	d.mu.Lock()
+	for _, at := range d.shellRestarts {
+		if !at.Before(since) {
+			st.Restarts++
+		}
+	}
+	for _, name := range st.Reports {
+		if !d.werSeen[name] {
+			d.metrics.inc(mShellCrashReports)
+			d.healthLog_("WARN", fmt.Sprintf("New Windows Error Reporting report for Explorer: %s", name))
+		}
+	}
+	d.werSeen = seen
+	d.mu.Unlock()
+	d.metrics.set(mShellEvents, float64(st.events()))
+
+	if st.events() > o.MaxEvents {
+		d.healthLog_("WARN", fmt.Sprintf("H8 FAIL: Explorer is unstable: %s (more than %d).", st, o.MaxEvents))
+		return false, st
+	}
+	d.healthLog_("PASS", fmt.Sprintf("H8 PASS: Explorer is stable: %s.", st))
+	return true, st
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+// shellstability_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func shellProcessID() uint32 { return 0 }
@@ -0,0 +1,24 @@
+// shellstability_windows.go
+// user32!GetWindowThreadProcessId on the taskbar window for H8.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetWindowThreadProcessId = modUser32.NewProc("GetWindowThreadProcessId")
+
+// shellProcessID returns the process that owns the taskbar of this
+// desktop, or 0 when there is none.
+func shellProcessID() uint32 {
+	class, _ := syscall.UTF16PtrFromString("Shell_TrayWnd")
+	hwnd, _, _ := procFindWindowW.Call(uintptr(unsafe.Pointer(class)), 0)
+	if hwnd == 0 {
+		return 0
+	}
+	var pid uint32
+	procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid)))
+	return pid
+}
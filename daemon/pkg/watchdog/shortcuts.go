@@ -0,0 +1,295 @@
+// shortcuts.go
+// Shell link (.lnk) files, decoded in pure Go after [MS-SHLLINK] so the
+// parser runs on every platform. Only what the daemon needs is read: the
+// target from the LinkInfo structure (a local path, or a network share plus
+// path suffix), the relative path as a fallback, and the icon location.
+// Shortcuts are read from the places whose icons Explorer shows without
+// being asked: the desktops, the Start menus and the pinned taskbar items.
+
+package watchdog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	lnkHeaderSize = 0x4c
+	maxShortcuts  = 5000 // per scan; a profile with more is not typical
+
+	lnkHasIDList       = 0x01
+	lnkHasLinkInfo     = 0x02
+	lnkHasName         = 0x04
+	lnkHasRelativePath = 0x08
+	lnkHasWorkingDir   = 0x10
+	lnkHasArguments    = 0x20
+	lnkHasIconLocation = 0x40
+	lnkIsUnicode       = 0x80
+
+	linkInfoLocal   = 0x1 // VolumeIDAndLocalBasePath
+	linkInfoNetwork = 0x2 // CommonNetworkRelativeLinkAndPathSuffix
+)
+
+// {00021401-0000-0000-C000-000000000046}
+var lnkCLSID = []byte{0x01, 0x14, 0x02, 0x00, 0, 0, 0, 0, 0xc0, 0, 0, 0, 0, 0, 0, 0x46}
+
+var errShortcutTruncated = errors.New("truncated shell link")
+
+type shortcut struct {
+	Path   string // the .lnk file
+	Target string // empty for links to virtual items (Control Panel, apps)
+	Share  string // \\server\share the target is on, per LinkInfo
+	Icon   string // icon location as stored, %variables% unexpanded
+}
+
+// readShortcut reads and parses the .lnk file at path.
+func readShortcut(path string) (shortcut, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return shortcut{}, err
+	}
+	s, err := parseShortcut(raw)
+	s.Path = path
+	return s, err
+}
+
+func parseShortcut(raw []byte) (shortcut, error) {
+	var s shortcut
+	le := binary.LittleEndian
+	if len(raw) < lnkHeaderSize || le.Uint32(raw) != lnkHeaderSize || !bytes.Equal(raw[4:20], lnkCLSID) {
+		return s, errors.New("not a shell link")
+	}
+	flags := le.Uint32(raw[0x14:])
+	off := lnkHeaderSize
+	if flags&lnkHasIDList != 0 {
+		if off+2 > len(raw) {
+			return s, errShortcutTruncated
+		}
+		off += 2 + int(le.Uint16(raw[off:]))
+	}
+	if flags&lnkHasLinkInfo != 0 {
+		if off+4 > len(raw) {
+			return s, errShortcutTruncated
+		}
+		size := int(le.Uint32(raw[off:]))
+		if size < 0x1c || off+size > len(raw) {
+			return s, errShortcutTruncated
+		}
+		s.Target, s.Share = parseLinkInfo(raw[off : off+size])
+		off += size
+	}
+
+	// StringData: each present string in this fixed order.
+	var relative string
+	for _, bit := range []uint32{lnkHasName, lnkHasRelativePath, lnkHasWorkingDir, lnkHasArguments, lnkHasIconLocation} {
+		if flags&bit == 0 {
+			continue
+		}
+		str, n, err := lnkString(raw[off:], flags&lnkIsUnicode != 0)
+		if err != nil {
+			return s, err
+		}
+		off += n
+		switch bit {
+		case lnkHasRelativePath:
+			relative = str
+		case lnkHasIconLocation:
+			s.Icon = str
+		}
+	}
+	if s.Target == "" && relative != "" {
+		s.Target = relative
+	}
+	return s, nil
+}
+
+// parseLinkInfo returns the target path and, for network targets, the share.
+func parseLinkInfo(li []byte) (target, share string) {
+	le := binary.LittleEndian
+	header, flags := le.Uint32(li[4:]), le.Uint32(li[8:])
+	unicode := header >= 0x24 && len(li) >= 0x24
+
+	suffix := cString(li, le.Uint32(li[0x18:]))
+	if unicode && le.Uint32(li[0x20:]) != 0 {
+		suffix = wString(li, le.Uint32(li[0x20:]))
+	}
+	if flags&linkInfoLocal != 0 {
+		local := cString(li, le.Uint32(li[0x10:]))
+		if unicode && le.Uint32(li[0x1c:]) != 0 {
+			local = wString(li, le.Uint32(li[0x1c:]))
+		}
+		if local != "" {
+			return joinWinPath(local, suffix), ""
+		}
+	}
+	if flags&linkInfoNetwork != 0 {
+		if o := le.Uint32(li[0x14:]); int(o)+0x14 <= len(li) {
+			cnrl := li[o:]
+			netOff := le.Uint32(cnrl[8:])
+			share = cString(cnrl, netOff)
+			if netOff > 0x14 && len(cnrl) >= 0x1c {
+				if u := wString(cnrl, le.Uint32(cnrl[0x14:])); u != "" {
+					share = u
+				}
+			}
+		}
+		if share != "" {
+			return joinWinPath(share, suffix), share
+		}
+	}
+	return "", ""
+}
+
+// lnkString decodes one StringData entry and returns its length in bytes.
+func lnkString(b []byte, unicode bool) (string, int, error) {
+	if len(b) < 2 {
+		return "", 0, errShortcutTruncated
+	}
+	count := int(binary.LittleEndian.Uint16(b))
+	if !unicode {
+		if 2+count > len(b) {
+			return "", 0, errShortcutTruncated
+		}
+		return ansiString(b[2 : 2+count]), 2 + count, nil
+	}
+	if 2+2*count > len(b) {
+		return "", 0, errShortcutTruncated
+	}
+	u := make([]uint16, count)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2+2*i:])
+	}
+	return string(utf16.Decode(u)), 2 + 2*count, nil
+}
+
+// cString reads a NUL-terminated system code page string at off ("" when
+// off is 0 or out of range).
+func cString(b []byte, off uint32) string {
+	if off == 0 || int(off) >= len(b) {
+		return ""
+	}
+	s := b[off:]
+	if i := bytes.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return ansiString(s)
+}
+
+// ansiString decodes system code page text. Shortcuts store paths that way
+// when the shell wrote no Unicode copy, which is the common case for the
+// LinkInfo block: read as UTF-8, an accented user name would make every
+// target under the profile look missing.
+func ansiString(b []byte) string {
+	for _, c := range b {
+		if c >= 0x80 {
+			return decodeANSI(b)
+		}
+	}
+	return string(b)
+}
+
+// wString reads a NUL-terminated UTF-16 string at off.
+func wString(b []byte, off uint32) string {
+	if off == 0 || int(off) >= len(b) {
+		return ""
+	}
+	var u []uint16
+	for i := int(off); i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+func joinWinPath(base, suffix string) string {
+	if suffix == "" {
+		return base
+	}
+	return strings.TrimRight(base, `\`) + `\` + suffix
+}
+
+// shortcutGroup is one place Explorer shows shortcut icons.
+type shortcutGroup struct {
+	Name string
+	Dirs []string
+}
+
+// shortcutGroups lists the folders whose shortcuts Explorer shows icons
+// for, in the order they appear after logon: taskbar, desktop, Start menu.
+func shortcutGroups() []shortcutGroup {
+	dir := func(env string, elem ...string) []string {
+		if base := os.Getenv(env); base != "" {
+			return []string{filepath.Join(append([]string{base}, elem...)...)}
+		}
+		return nil
+	}
+	return []shortcutGroup{
+		{"taskbar", dir("APPDATA", "Microsoft", "Internet Explorer", "Quick Launch", "User Pinned", "TaskBar")},
+		{"desktop", append(dir("USERPROFILE", "Desktop"), dir("PUBLIC", "Desktop")...)},
+		{"Start menu", append(dir("APPDATA", "Microsoft", "Windows", "Start Menu"), dir("ProgramData", "Microsoft", "Windows", "Start Menu")...)},
+	}
+}
+
+// shortcutDirs lists the folders of all shortcut groups.
+func shortcutDirs() []string {
+	var dirs []string
+	for _, g := range shortcutGroups() {
+		dirs = append(dirs, g.Dirs...)
+	}
+	return dirs
+}
+
+// scanShortcuts reads every .lnk under dirs, up to maxShortcuts. Files that
+// do not parse are skipped.
+func scanShortcuts(dirs []string) []shortcut {
+	var out []shortcut
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+			if err != nil || e.IsDir() || !strings.EqualFold(filepath.Ext(path), ".lnk") {
+				return nil
+			}
+			if len(out) >= maxShortcuts {
+				return filepath.SkipAll
+			}
+			if s, err := readShortcut(path); err == nil {
+				out = append(out, s)
+			}
+			return nil
+		})
+	}
+	return out
+}
+
+// expandPercentVars expands %NAME% references as Windows does; unknown
+// names are left as they are.
+func expandPercentVars(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '%')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i+1:], '%')
+		if j < 0 {
+			break
+		}
+		name := s[i+1 : i+1+j]
+		if v, ok := os.LookupEnv(name); ok && name != "" {
+			b.WriteString(s[:i] + v)
+		} else {
+			b.WriteString(s[:i+2+j])
+		}
+		s = s[i+2+j:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
@@ -0,0 +1,246 @@
+// signature.go
+// Optional config signing and tamper detection.
+//
+// The config can point the daemon at a repair script that it then runs
+// hidden with -ExecutionPolicy Bypass, so on managed machines an admin can
+// require the file to carry an Ed25519 signature:
+//
+//   HKLM\SOFTWARE\IconCacheWatchdog
+//     RequireSignedConfig  REG_DWORD   1
+//     ConfigPublicKey      REG_SZ      (base64, or REG_BINARY) — 32-byte key
+//
+// The daemon runs as the interactive user, so whatever it verifies with is
+// readable by that user; only a public key is safe there. The private key
+// stays off the machine: `config keygen` creates the pair, and `config sign
+// -key=FILE` signs a config on the admin's workstation before it is
+// deployed. The signature is stored hex-encoded next to the config as
+// icon-cache-watchdog.json.sig. Both values live in HKLM so a user cannot
+// switch the check off. The HMAC ConfigKey of earlier versions is no longer
+// trusted, as any user who can read it can forge a signature.
+
+package watchdog
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const policyRegistryKey = `SOFTWARE\IconCacheWatchdog`
+
+var errConfigSignature = errors.New("config signature check failed")
+
+type signingPolicy struct {
+	required  bool
+	publicKey ed25519.PublicKey
+	legacyKey bool // an HMAC ConfigKey is still set
+}
+
+func readSigningPolicy() signingPolicy {
+	var p signingPolicy
+	if data, typ, err := readRegistryValue(policyRegistryKey, "RequireSignedConfig"); err == nil {
+		v, ok := registryDWORD(data, typ)
+		p.required = ok && v != 0
+	}
+	if data, typ, err := readRegistryValue(policyRegistryKey, "ConfigPublicKey"); err == nil {
+		var key []byte
+		switch typ {
+		case regBinary:
+			key = data
+		case regSZ:
+			key, _ = base64.StdEncoding.DecodeString(strings.TrimSpace(registryString(data)))
+		}
+		if len(key) == ed25519.PublicKeySize {
+			p.publicKey = key
+		}
+	}
+	if _, _, err := readRegistryValue(policyRegistryKey, "ConfigKey"); err == nil {
+		p.legacyKey = true
+	}
+	return p
+}
+
+func signatureFile(configPath string) string { return configPath + ".sig" }
+
+// verifyConfigSignature checks raw (the config file content) against its
+// .sig file. A signature that is present is always verified when a public
+// key is available; a missing signature is only an error when signing is
+// required.
+func verifyConfigSignature(configPath string, raw []byte) error {
+	return readSigningPolicy().verify(configPath, raw)
+}
+
+func (p signingPolicy) verify(configPath string, raw []byte) error {
+	sigHex, err := os.ReadFile(signatureFile(configPath))
+	if err != nil {
+		if p.required {
+			return fmt.Errorf("%w: signed config required but %s is missing", errConfigSignature, signatureFile(configPath))
+		}
+		return nil
+	}
+	if p.publicKey == nil {
+		if !p.required {
+			return nil
+		}
+		if p.legacyKey {
+			return fmt.Errorf("%w: signed config required but only an HMAC ConfigKey is set in HKLM\\%s; it is no longer trusted, set ConfigPublicKey (see config keygen)", errConfigSignature, policyRegistryKey)
+		}
+		return fmt.Errorf("%w: signed config required but no valid ConfigPublicKey in HKLM\\%s", errConfigSignature, policyRegistryKey)
+	}
+	sig, err := hex.DecodeString(strings.TrimSpace(string(sigHex)))
+	if err != nil || !ed25519.Verify(p.publicKey, raw, sig) {
+		return fmt.Errorf("%w: %s does not match the config content", errConfigSignature, signatureFile(configPath))
+	}
+	return nil
+}
+
+func fileDigest(path string) string {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return digestOf(raw)
+}
+
+func digestOf(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkConfigTamper compares the config file with the digest taken at load.
+// The daemon does not hot-reload config, so a change cannot take effect
+// until restart — but an unexpected edit is worth flagging immediately.
+func (d *daemon) checkConfigTamper() {
+	raw, err := os.ReadFile(d.configFile)
+	now := ""
+	if err == nil {
+		now = digestOf(raw)
+	}
+	if now == d.cfgDigest {
+		return
+	}
+	d.logMsg(msgConfigChanged, d.configFile, shortDigest(d.cfgDigest), shortDigest(now))
+	if err := verifyConfigSignature(d.configFile, raw); err != nil {
+		d.healthLog_("TAMPER", fmt.Sprintf("Modified config will be rejected at next start: %v", err))
+	}
+	d.cfgDigest = now
+}
+
+func shortDigest(s string) string {
+	if s == "" {
+		return "<missing>"
+	}
+	return s[:12]
+}
+
+// cmdConfigKeygen creates a signing key pair: the private key goes to a
+// file to be kept off the managed machines, the public key is printed for
+// the registry.
+func cmdConfigKeygen(d *daemon, args []string) int {
+	fs := newFlagSet("config keygen")
+	out := fs.String("out", "icon-cache-watchdog.key", "file for the private key")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot generate a key: %v\n", err)
+		return 1
+	}
+	if err := writePrivateKey(*out, priv); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write the private key: %v\n", err)
+		return 1
+	}
+	b64 := base64.StdEncoding.EncodeToString(pub)
+	fmt.Printf("Wrote the private key to %s. Keep it off the managed machines.\n", *out)
+	fmt.Printf("Public key: %s\n\nOn each machine, as Administrator:\n", b64)
+	fmt.Printf("  Set-ItemProperty HKLM:\\SOFTWARE\\IconCacheWatchdog ConfigPublicKey '%s' -Type String\n", b64)
+	return 0
+}
+
+func writePrivateKey(path string, priv ed25519.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return priv, nil
+}
+
+// signConfigFile writes the .sig file for the config at path.
+func signConfigFile(path string, priv ed25519.PrivateKey) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sig := hex.EncodeToString(ed25519.Sign(priv, raw))
+	return os.WriteFile(signatureFile(path), []byte(sig+"\n"), 0644)
+}
+
+// cmdConfigSign writes the .sig file for a config, by default the active
+// one. It needs the private key, so it normally runs where the config is
+// prepared rather than on the managed machine.
+func cmdConfigSign(d *daemon, args []string) int {
+	fs := newFlagSet("config sign")
+	keyFile := fs.String("key", "", "private key file from config keygen")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *keyFile == "" {
+		fmt.Fprintln(os.Stderr, "config sign needs -key=FILE, the private key from config keygen.")
+		return 2
+	}
+	path := d.configFile
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	priv, err := readPrivateKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read the private key: %v\n", err)
+		return 1
+	}
+	if err := signConfigFile(path, priv); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot sign: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Signed %s -> %s\n", path, signatureFile(path))
+	if p := readSigningPolicy(); p.publicKey != nil && !p.publicKey.Equal(priv.Public()) {
+		fmt.Println("Note: this machine's ConfigPublicKey does not belong to that key; the daemon here will reject the file.")
+	}
+	return 0
+}
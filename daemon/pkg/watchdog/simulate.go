@@ -0,0 +1,224 @@
+// simulate.go
+// `simulate <trace>` replays a recorded cache trace (trace.go) through the
+// real detection engine — size checks every snapshot, health checks on the
+// configured schedule, cooldown and quiet hours — on a simulated clock, as
+// fast as the disk allows. Repairs are not launched; the report lists the
+// ones that would have run. Thresholds, health weights, the score threshold
+// and quiet hours come from the config file, so they can be tuned against
+// real captures before rolling them out.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// simulation is attached to a daemon replaying a trace. It replaces the
+// log files, the Explorer probe and the repair launch.
+type simulation struct {
+	explorer bool
+	lines    []string
+	repairs  []simRepair
+	report   simReport
+}
+
+type simRepair struct {
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason"`
+}
+
+type simReport struct {
+	Snapshots    int         `json:"snapshots"`
+	From         time.Time   `json:"from"`
+	To           time.Time   `json:"to"`
+	SizeChecks   int         `json:"sizeChecks"`
+	HealthChecks int         `json:"healthChecks"`
+	SizeTriggers int         `json:"sizeTriggers"`
+	HealthFails  int         `json:"healthFailures"`
+	Cooldowns    int         `json:"suppressedByCooldown"`
+	Deferrals    int         `json:"deferred"`
+	Repairs      []simRepair `json:"repairs"`
+}
+
+func (s *simulation) log(ts, level, code, msg string) {
+	if code != "" {
+		msg = code + " " + msg
+	}
+	s.lines = append(s.lines, fmt.Sprintf("[%s][%s] %s", ts, level, msg))
+	switch {
+	case code == msgSizeTrigger:
+		s.report.SizeTriggers++
+	case level == "REPAIR":
+		s.report.HealthFails++
+	case code == msgCooldownSkip:
+		s.report.Cooldowns++
+	case code == msgRepairDeferred:
+		s.report.Deferrals++
+	}
+}
+
+// simClock is set explicitly to each snapshot's timestamp.
+type simClock struct{ now time.Time }
+
+func (c *simClock) Now() time.Time                  { return c.now }
+func (c *simClock) Since(t time.Time) time.Duration { return c.now.Sub(t) }
+
+// NewTicker is never used by the replay loop; the ticker never fires.
+func (c *simClock) NewTicker(time.Duration) Ticker { return simTicker{} }
+
+type simTicker struct{}
+
+func (simTicker) C() <-chan time.Time { return nil }
+func (simTicker) Stop()               {}
+
+func cmdSimulate(d *daemon, args []string) int {
+	fs := newFlagSet("simulate")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	verbose := fs.Bool("v", false, "print the simulated watchdog and health log")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: simulate [-json] [-v] <trace.jsonl>")
+		return 2
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open trace: %v\n", err)
+		return 1
+	}
+	hdr, snaps, err := readTrace(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid trace: %v\n", err)
+		return 1
+	}
+	if len(snaps) == 0 {
+		fmt.Fprintln(os.Stderr, "Trace contains no snapshots.")
+		return 1
+	}
+
+	sandbox, err := os.MkdirTemp("", "icw-simulate-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create sandbox: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(sandbox)
+
+	sim, err := d.replay(hdr, snaps, sandbox)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Replay failed: %v\n", err)
+		return 1
+	}
+
+	if *verbose {
+		for _, l := range sim.lines {
+			fmt.Println(l)
+		}
+		fmt.Println()
+	}
+	r := sim.report
+	if *asJSON {
+		printJSON("simulate", r)
+		return 0
+	}
+	fmt.Printf("Replayed %d snapshots: %s -> %s (%s)\n", r.Snapshots, formatTime(r.From), formatTime(r.To), r.To.Sub(r.From).Round(time.Minute))
+	fmt.Printf("Checks:   %d size, %d health (repair below score %d)\n", r.SizeChecks, r.HealthChecks, d.cfg.Health.RepairBelowScore)
+	fmt.Printf("Triggers: %d size, %d health score\n", r.SizeTriggers, r.HealthFails)
+	fmt.Printf("Held:     %d by cooldown, %d deferred\n", r.Cooldowns, r.Deferrals)
+	fmt.Printf("Repairs:  %d\n", len(r.Repairs))
+	for _, rep := range r.Repairs {
+		fmt.Printf("  %s  %s\n", formatTime(rep.At), rep.Reason)
+	}
+	return 0
+}
+
+// replay runs snaps through a detached daemon whose cache lives in sandbox.
+func (d *daemon) replay(hdr traceHeader, snaps []traceSnapshot, sandbox string) (*simulation, error) {
+	clock := &simClock{now: snaps[0].At}
+	sim := &simulation{}
+	cfg := d.cfg
+	// Focus Assist and full-screen state belong to this machine, not the trace.
+	cfg.Quiet.RespectFocusAssist = false
+	cfg.Quiet.HoldDuringFullScreen = false
+	profile := profileInfo{Type: profileLocal}
+	if hdr.Profile != "" {
+		profile = profileInfo{Type: hdr.Profile, DiscardedAtLogoff: profileDiscarded(hdr.Profile)}
+	}
+	sd := &daemon{
+		cfg:          cfg,
+		configFile:   d.configFile,
+		cfgDigest:    d.cfgDigest,
+		rootDir:      d.rootDir,
+		cacheDir:     sandbox,
+		repairScript: d.repairScript,
+		started:      clock.now,
+		profile:      profile,
+		clock:        clock,
+		sim:          sim,
+	}
+
+	var nextHealth time.Time
+	for _, snap := range snaps {
+		clock.now = snap.At
+		sim.explorer = snap.Explorer == nil || *snap.Explorer
+		if err := materialize(sandbox, snap.Files); err != nil {
+			return nil, err
+		}
+		// Layer B every poll; Layers C (first snapshot) and D on schedule.
+		sd.checkSize()
+		sim.report.SizeChecks++
+		if !snap.At.Before(nextHealth) {
+			sd.checkHealth()
+			sim.report.HealthChecks++
+			nextHealth = snap.At.Add(sd.cfg.Thresholds.HealthCheckEvery.D())
+			if s, err := parseSchedule(sd.cfg.Health.Schedule); err == nil && sd.cfg.Health.Schedule != "" {
+				nextHealth = s.next(snap.At)
+			}
+		}
+	}
+
+	sim.report.Snapshots = len(snaps)
+	sim.report.From = snaps[0].At
+	sim.report.To = snaps[len(snaps)-1].At
+	sim.report.Repairs = sim.repairs
+	return sim, nil
+}
+
+// materialize makes dir match a snapshot: sparse files of the recorded size
+// and modification time; files absent from the snapshot are deleted.
+func materialize(dir string, files []traceFile) error {
+	want := map[string]bool{}
+	for _, f := range files {
+		name := filepath.Base(f.Name)
+		want[name] = true
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err != nil || info.Size() != f.Size {
+			h, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			err = h.Truncate(f.Size)
+			h.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if err := os.Chtimes(path, f.ModTime, f.ModTime); err != nil {
+			return err
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !want[e.Name()] {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// sizeverify.go
+// Shrink verification for size-triggered repairs. A repair that deletes the
+// cache normally leaves a few megabytes; if the cache is still large
+// afterwards, something rebuilds it at once (a misbehaving shell extension,
+// a folder of thousands of images being thumbnailed, a filter driver that
+// restores the files) and repeating the same repair every cooldown only
+// costs the user an Explorer restart each time.
+//
+// Once a size repair has ended, and at least sizeRepair.verifyAfter after it
+// started, the next Layer B poll compares the cache with the recovery
+// threshold, sizeRepair.recoveryPercent of thresholds.sizeLimit. Below it the
+// repair is verified. Above it the repair is ineffective (event 118), and
+// the daemon escalates:
+//
+//   - after the first ineffective repair the next size repair is a deep
+//     clean (not on the event-triggered task route, which cannot run one);
+//   - after sizeRepair.maxIneffective in a row, size repairs are suspended:
+//     the size trigger still logs, but no repair runs until a poll finds the
+//     cache below the recovery threshold again.
+//
+// The state survives restarts in state.json. Health, remote and manual
+// repairs are not affected.
+
+package watchdog
+
+import (
+	"fmt"
+	"time"
+)
+
+type sizeRepairOptions struct {
+	Verify          bool     `json:"verify"`
+	RecoveryPercent int      `json:"recoveryPercent"` // of thresholds.sizeLimit, the size a repair must reach
+	VerifyAfter     duration `json:"verifyAfter"`     // earliest check after the repair started
+	MaxIneffective  int      `json:"maxIneffective"`  // ineffective repairs in a row before suspending
+}
+
+func defaultSizeRepairOptions() sizeRepairOptions {
+	return sizeRepairOptions{Verify: true, RecoveryPercent: 50, VerifyAfter: duration(2 * time.Minute), MaxIneffective: 2}
+}
+
+// sizeRepairState tracks size repairs across polls and restarts.
+type sizeRepairState struct {
+	Pending     time.Time `json:"pending,omitempty"`  // start of the repair awaiting verification
+	BeforeMB    float64   `json:"beforeMB,omitempty"` // cache size that triggered it
+	DeepClean   bool      `json:"deepClean,omitempty"`
+	Ineffective int       `json:"ineffective,omitempty"` // ineffective repairs in a row
+	Suspended   bool      `json:"suspended,omitempty"`
+}
+
+func (s sizeRepairState) String() string {
+	return fmt.Sprintf("suspended after %s in a row", plural(s.Ineffective, "ineffective repair", "no ineffective repairs"))
+}
+
+// recoveryMB is the size a size repair has to bring the cache below.
+func (d *daemon) recoveryMB() float64 {
+	return d.cfg.Thresholds.SizeLimit.MB() * float64(d.cfg.SizeRepair.RecoveryPercent) / 100
+}
+
+// triggerSizeRepair runs the size trigger's repair, escalated to a deep
+// clean after an ineffective one, and remembers it for verification.
+func (d *daemon) triggerSizeRepair(reason string, sizeMB float64) {
+	d.mu.Lock()
+	s := d.sizeRepair
+	deep := d.cfg.SizeRepair.Verify && s.Ineffective > 0 && d.caps.RepairRoute != routeTrampoline
+	d.mu.Unlock()
+	if s.Suspended {
+		d.logMsg(msgSuspendedSkip, s, d.recoveryMB(), reason)
+		d.metrics.inc(mRepairsSkipped)
+		return
+	}
+	if deep {
+		reason += fmt.Sprintf(" (deep clean: %s)", plural(s.Ineffective, "ineffective repair", "no ineffective repairs"))
+	}
+	if !d.tryRepair(reason, priorityNormal, deep) || !d.cfg.SizeRepair.Verify {
+		return
+	}
+	d.mu.Lock()
+	d.sizeRepair.Pending, d.sizeRepair.BeforeMB, d.sizeRepair.DeepClean = d.clock.Now(), sizeMB, deep
+	d.saveState()
+	d.mu.Unlock()
+}
+
+// verifySizeRepair checks a finished size repair against the recovery
+// threshold, and lifts a suspension once the cache is small again. Called
+// on every Layer B poll.
+func (d *daemon) verifySizeRepair(sizeMB float64) {
+	o := d.cfg.SizeRepair
+	recovery := d.recoveryMB()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := &d.sizeRepair
+	if s.Pending.IsZero() {
+		if s.Ineffective > 0 && sizeMB <= recovery {
+			if s.Suspended {
+				d.repairLog_("INFO", fmt.Sprintf("Cache is below the recovery threshold again (%.2f MB <= %.2f MB). Size repairs resumed.", sizeMB, recovery))
+			}
+			*s = sizeRepairState{}
+			d.saveState()
+		}
+		return
+	}
+	if d.clock.Since(s.Pending) < o.VerifyAfter.D() || d.repairRunning() {
+		return
+	}
+	kind := "Size repair"
+	if s.DeepClean {
+		kind = "Size repair (deep clean)"
+	}
+	if sizeMB <= recovery {
+		d.repairLog_("INFO", fmt.Sprintf("%s verified: cache is %.2f MB (was %.2f MB), below the recovery threshold of %.2f MB.", kind, sizeMB, s.BeforeMB, recovery))
+		d.incidents.step(stepVerified, fmt.Sprintf("%s verified at %.2f MB", kind, sizeMB))
+		*s = sizeRepairState{}
+		d.saveState()
+		return
+	}
+	s.Ineffective++
+	before := s.BeforeMB
+	s.Pending, s.BeforeMB, s.DeepClean = time.Time{}, 0, false
+	d.metrics.inc(mRepairsIneffective)
+	msg := d.logMsg(msgIneffective, kind, sizeMB, before, recovery, o.RecoveryPercent, d.cfg.Thresholds.SizeLimit,
+		plural(s.Ineffective, "ineffective repair", "no ineffective repairs"))
+	d.incidents.step(stepIneffective, fmt.Sprintf("%s ineffective at %.2f MB", kind, sizeMB))
+	switch {
+	case s.Ineffective >= o.MaxIneffective:
+		s.Suspended = true
+		msg += fmt.Sprintf(" Size repairs suspended until the cache drops below %.2f MB; something rebuilds it right after a repair (run `healthcheck` and check the shell extensions).", recovery)
+		d.logMsg(msgSizeSuspended)
+	case d.caps.RepairRoute != routeTrampoline:
+		d.repairLog_("INFO", "Escalating: the next size repair is a deep clean.")
+	}
+	d.historyEvent(historyIneffective, msg)
+	if s.Suspended {
+		d.incidents.fail("size repairs suspended")
+	}
+	d.saveState()
+}
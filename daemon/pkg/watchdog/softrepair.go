@@ -0,0 +1,229 @@
+// softrepair.go
+// Soft repair: ie4uinit.exe. Many health failures (icons gone stale after an
+// update, a shell that lost track of its icon index) clear up once the shell
+// is told to reload its icons, which ie4uinit.exe does without stopping
+// Explorer or deleting a file. With softRepair.enabled, the first repair a
+// failing health check asks for is this soft repair, run by the daemon
+// itself. The full repair follows only when the next health check still
+// scores below the threshold (R212), or at once when ie4uinit.exe fails
+// (R210).
+//
+// The switch depends on the Windows version: Windows 10 and later reset the
+// icon index with -show, Windows 7 and 8 only know -ClearIconCache. The tool
+// is looked up in the native System32 (wow64.go) at each use; without it,
+// health checks go straight to the full repair. Size triggers never use the
+// soft repair (it does not shrink the cache), nor do multi-session monitors
+// (ie4uinit.exe must run in the user's session) and trace simulation.
+// `refresh` runs it once from the command line.
+//
+// ie4uinit.exe fixes the cache but leaves what is on screen: the desktop and
+// open folders show the old icons until the user presses F5. With
+// softRepair.refreshWindows, a soft repair that ran is followed by that F5
+// for the desktop and every Explorer window (shellrefresh_windows.go).
+
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const win10Build = 10240 // first build whose ie4uinit.exe takes -show
+
+type softRepairOptions struct {
+	Enabled        bool     `json:"enabled"`
+	Timeout        duration `json:"timeout"`        // ie4uinit.exe is stopped after this
+	RefreshWindows bool     `json:"refreshWindows"` // F5 the desktop and Explorer windows afterwards
+}
+
+func defaultSoftRepairOptions() softRepairOptions {
+	return softRepairOptions{Enabled: true, Timeout: duration(30 * time.Second), RefreshWindows: true}
+}
+
+// softRepairState is the soft repair of the current health episode; it is
+// cleared when the score is back at or above the threshold.
+type softRepairState struct {
+	At        time.Time
+	Escalated bool // the full repair took over
+}
+
+// shellRefresh is what refreshShellWindows reached.
+type shellRefresh struct {
+	Desktop bool
+	Windows int // Explorer windows
+}
+
+func (r shellRefresh) String() string {
+	switch {
+	case r.Desktop && r.Windows > 0:
+		return fmt.Sprintf("desktop and %d Explorer window(s)", r.Windows)
+	case r.Desktop:
+		return "desktop"
+	default:
+		return fmt.Sprintf("%d Explorer window(s)", r.Windows)
+	}
+}
+
+// ie4uinitCommand returns ie4uinit.exe and the switch this Windows version
+// takes; path is "" when the tool is missing.
+func ie4uinitCommand() (path, arg string) {
+	dir := nativeSystemDir()
+	if dir == "" {
+		return "", ""
+	}
+	path = filepath.Join(dir, "ie4uinit.exe")
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", ""
+	}
+	if b := windowsBuild(); b > 0 && b < win10Build {
+		return path, "-ClearIconCache"
+	}
+	return path, "-show"
+}
+
+// windowsBuild is the Windows build number, or 0 when it cannot be read.
+func windowsBuild() int {
+	data, _, err := readRegistryValue(`SOFTWARE\Microsoft\Windows NT\CurrentVersion`, "CurrentBuildNumber")
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(registryString(data)))
+	return n
+}
+
+// runIe4uinit runs ie4uinit.exe and returns how long it took.
+func runIe4uinit(path, arg string, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, arg)
+	cmd.SysProcAttr = sysProcAttr()
+	cmd.WaitDelay = time.Second
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	took := time.Since(start)
+	if ctx.Err() != nil {
+		return took, fmt.Errorf("no exit within %s", duration(timeout))
+	}
+	if err != nil {
+		if line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n"); line != "" {
+			err = fmt.Errorf("%v: %s", err, line)
+		}
+		return took, err
+	}
+	return took, nil
+}
+
+func (d *daemon) softRepairActive() bool {
+	return d.cfg.SoftRepair.Enabled && d.session == nil && d.sim == nil
+}
+
+// logSoftRepair logs at startup whether soft repairs are available.
+func (d *daemon) logSoftRepair() {
+	if !d.softRepairActive() {
+		return
+	}
+	if path, arg := ie4uinitCommand(); path != "" {
+		d.watchLog_("INFO", fmt.Sprintf("Soft repair: %s %s", path, arg))
+	} else {
+		d.watchLog_("INFO", fmt.Sprintf("Soft repair unavailable: no ie4uinit.exe in %s. Health checks run the full repair.", nativeSystemDir()))
+	}
+}
+
+// trySoftRepair runs the soft repair for a failing health check and reports
+// whether the full repair waits for the next check.
+func (d *daemon) trySoftRepair(reason string, score int) bool {
+	if !d.softRepairActive() {
+		return false
+	}
+	d.mu.Lock()
+	soft := d.softRepair
+	blocked := d.reportOnlyReason() != "" || d.pausedReason() != ""
+	d.mu.Unlock()
+	switch {
+	case blocked || soft.Escalated:
+		return false // the full repair logs the skip
+	case !soft.At.IsZero():
+		d.logMsg(msgSoftNoEffect, formatTime(soft.At), score)
+		d.setSoftRepair(softRepairState{At: soft.At, Escalated: true})
+		return false
+	}
+	path, arg := ie4uinitCommand()
+	if path == "" {
+		return false
+	}
+
+	d.healthLog_("REPAIR", "=== HEALTH SCORE BELOW THRESHOLD. Trying the soft repair first... ===")
+	took, err := runIe4uinit(path, arg, d.cfg.SoftRepair.Timeout.D())
+	if err != nil {
+		d.logMsg(msgSoftFailed, filepath.Base(path), arg, err)
+		d.incidents.step(stepSoftRepair, fmt.Sprintf("ie4uinit.exe %s failed: %v", arg, err))
+		d.setSoftRepair(softRepairState{At: d.clock.Now(), Escalated: true})
+		return false
+	}
+	d.logMsg(msgSoftRepaired, filepath.Base(path), arg, took.Seconds(), reason)
+	d.incidents.step(stepSoftRepair, fmt.Sprintf("ie4uinit.exe %s ran", arg))
+	d.historyEvent(historySoft, fmt.Sprintf("soft repair (ie4uinit.exe %s)", arg))
+	d.metrics.inc(mSoftRepairs)
+	d.setSoftRepair(softRepairState{At: d.clock.Now()})
+	d.refreshShell(refreshShellWindows)
+	return true
+}
+
+// refreshShell makes a soft repair visible at once by refreshing the
+// desktop and the open Explorer windows.
+func (d *daemon) refreshShell(refresh func() shellRefresh) {
+	if !d.cfg.SoftRepair.RefreshWindows {
+		return
+	}
+	if r := refresh(); r.Desktop || r.Windows > 0 {
+		d.repairLog_("INFO", fmt.Sprintf("Shell refreshed: %s.", r))
+	} else {
+		d.repairLog_("INFO", "Shell refresh: no desktop or Explorer window found.")
+	}
+}
+
+// softRepairSettled ends the episode once the score is back at or above
+// the threshold.
+func (d *daemon) softRepairSettled(score, threshold int) {
+	d.mu.Lock()
+	soft := d.softRepair
+	d.softRepair = softRepairState{}
+	d.mu.Unlock()
+	if !soft.At.IsZero() && !soft.Escalated {
+		d.logMsg(msgSoftRestored, score, threshold)
+	}
+}
+
+func (d *daemon) setSoftRepair(s softRepairState) {
+	d.mu.Lock()
+	d.softRepair = s
+	d.mu.Unlock()
+}
+
+func cmdRefresh(d *daemon, args []string) int {
+	fs := newFlagSet("refresh")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	path, arg := ie4uinitCommand()
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "No ie4uinit.exe in %s.\n", nativeSystemDir())
+		return 1
+	}
+	took, err := runIe4uinit(path, arg, d.cfg.SoftRepair.Timeout.D())
+	if err != nil {
+		d.repairLog_("WARN", fmt.Sprintf("Soft repair from the command line failed: ie4uinit.exe %s: %v", arg, err))
+		fmt.Fprintf(os.Stderr, "ie4uinit.exe %s failed: %v\n", arg, err)
+		return 1
+	}
+	d.repairLog_("INFO", fmt.Sprintf("Soft repair from the command line: ie4uinit.exe %s finished in %.2fs.", arg, took.Seconds()))
+	fmt.Printf("ie4uinit.exe %s finished in %.2fs.\n", arg, took.Seconds())
+	d.refreshShell(refreshShellWindows)
+	return 0
+}
@@ -0,0 +1,268 @@
+// spool.go
+// Trigger spool. Layer A used to decide on its own: the EventRepair task ran
+// `repair -reason=event`, which evaluated the cache in a second process next
+// to the daemon. Now the task runs `trigger`, which writes a JSON payload
+// (reason, source, evidence such as the event ID) to
+// <dataDir>\triggers\<user>\ and exits. The daemon drains the spool at
+// startup and on every Layer B poll and feeds each trigger into the same
+// pipeline as its own layers: "check" asks for a health check, which repairs
+// when the score is below the threshold (as Layer A did); "normal" and
+// "critical" go straight to triggerRepair with that priority, so the
+// cooldown, holds, pause and free space guard apply.
+//
+// The spool is also the offline queue. A trigger raised while no daemon
+// runs (boot, crash, upgrade) stays queued: `trigger` starts the Watchdog
+// task, and whichever daemon starts next processes it. Files are written
+// with a sync and claimed by renaming them to .claimed before they run, so
+// a daemon that dies mid-drain picks them up again at its next start.
+// Payloads older than triggers.maxAge are dropped rather than replaying old
+// crashes. Only when the Watchdog task cannot be started does `trigger`
+// fall back to `repair`. Single-user mode only.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	triggerVersion = 1
+	watchdogTask   = `\IconCache\Watchdog`
+
+	triggerCheck    = "check"
+	triggerNormal   = "normal"
+	triggerCritical = "critical"
+)
+
+type triggerOptions struct {
+	MaxAge      duration `json:"maxAge"`      // queued triggers older than this are dropped
+	StartDaemon bool     `json:"startDaemon"` // `trigger` starts the Watchdog task when no daemon runs
+}
+
+func defaultTriggerOptions() triggerOptions {
+	return triggerOptions{MaxAge: duration(24 * time.Hour), StartDaemon: true}
+}
+
+// triggerPayload is one spooled trigger.
+type triggerPayload struct {
+	Version  int               `json:"version"`
+	Created  time.Time         `json:"created"`
+	Source   string            `json:"source"` // e.g. "event" for the EventRepair task
+	Reason   string            `json:"reason"` // free text for the logs
+	Priority string            `json:"priority,omitempty"`
+	Evidence map[string]string `json:"evidence,omitempty"` // e.g. eventId, provider, module
+}
+
+// describe is the reason with its evidence, for the logs.
+func (p triggerPayload) describe() string {
+	if len(p.Evidence) == 0 {
+		return p.Reason
+	}
+	keys := make([]string, 0, len(p.Evidence))
+	for k := range p.Evidence {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + p.Evidence[k]
+	}
+	return fmt.Sprintf("%s (%s)", p.Reason, strings.Join(keys, ", "))
+}
+
+func (d *daemon) triggerDir() string {
+	_, user := reportIdentity()
+	return filepath.Join(d.dataDir, "triggers", user)
+}
+
+// spoolTrigger queues p for the daemon and returns the file name. The
+// file is synced before it is renamed into place, so a trigger raised just
+// before a crash or power loss survives it.
+func (d *daemon) spoolTrigger(p triggerPayload) (string, error) {
+	dir := d.triggerDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	raw, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	source := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(p.Source))
+	file := filepath.Join(dir, fmt.Sprintf("%d-%s.json", p.Created.UnixNano(), source))
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(raw)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return file, nil
+}
+
+// queuedTriggers lists the spooled triggers, oldest first. Claims left by
+// a daemon that stopped mid-drain are returned to the queue first.
+func (d *daemon) queuedTriggers() []string {
+	dir := d.triggerDir()
+	claimed, _ := filepath.Glob(filepath.Join(dir, "*.json.claimed"))
+	for _, f := range claimed {
+		os.Rename(f, strings.TrimSuffix(f, ".claimed"))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Strings(files)
+	return files
+}
+
+// drainTriggers runs the spooled triggers, oldest first. Called at startup
+// and on every Layer B poll.
+func (d *daemon) drainTriggers() {
+	if d.session != nil || d.sim != nil {
+		return
+	}
+	for _, f := range d.queuedTriggers() {
+		// Claim the file first: if another daemon (an upgrade overlapping
+		// the old instance) got there before us, the rename fails.
+		claim := f + ".claimed"
+		if os.Rename(f, claim) != nil {
+			continue
+		}
+		raw, err := os.ReadFile(claim)
+		var p triggerPayload
+		switch {
+		case err != nil:
+			continue
+		case json.Unmarshal(raw, &p) != nil || p.Version != triggerVersion:
+			d.watchLog_("WARN", fmt.Sprintf("Discarded unreadable trigger %s.", filepath.Base(f)))
+		default:
+			if age := d.clock.Since(p.Created); age > d.cfg.Triggers.MaxAge.D() {
+				d.watchLog_("WARN", fmt.Sprintf("Discarded stale trigger from %s (%s old): %s", p.Source, age.Round(time.Minute), p.describe()))
+			} else {
+				d.runTrigger(p)
+			}
+		}
+		os.Remove(claim)
+	}
+}
+
+// drainQueuedTriggers processes what was queued while no daemon ran,
+// before the first poll.
+func (d *daemon) drainQueuedTriggers() {
+	if d.session != nil || d.sim != nil {
+		return
+	}
+	if n := len(d.queuedTriggers()); n > 0 {
+		d.watchLog_("INFO", fmt.Sprintf("%s queued while the daemon was not running.", capitalize(plural(n, "trigger", "no triggers"))))
+	}
+	d.drainTriggers()
+}
+
+// runTrigger hands one trigger to the health-check or repair pipeline.
+func (d *daemon) runTrigger(p triggerPayload) {
+	if p.Priority != triggerNormal && p.Priority != triggerCritical {
+		p.Priority = triggerCheck
+	}
+	reason := fmt.Sprintf("%s trigger: %s", p.Source, p.describe())
+	d.watchLog_("TRIGGER", fmt.Sprintf("Spooled %s (priority %s).", reason, p.Priority))
+	switch p.Priority {
+	case triggerNormal:
+		d.triggerRepair(reason, priorityNormal)
+	case triggerCritical:
+		d.triggerRepair(reason, priorityCritical)
+	default:
+		// Run on the health-check goroutine; one pending request is enough.
+		select {
+		case d.healthNow <- reason:
+		default:
+		}
+	}
+}
+
+// evidenceFlag collects repeated -evidence key=value flags.
+type evidenceFlag map[string]string
+
+func (e evidenceFlag) String() string { return "" }
+
+func (e evidenceFlag) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("want key=value, got %q", v)
+	}
+	// Task Scheduler passes an empty string for a value query that found
+	// nothing; such evidence is left out.
+	if val = strings.TrimSpace(val); val != "" {
+		e[strings.TrimSpace(k)] = val
+	}
+	return nil
+}
+
+// cmdTrigger queues a trigger for the daemon. When none is running, it
+// starts the Watchdog task to process the queue, or repairs through
+// `repair` when that is not possible.
+func cmdTrigger(d *daemon, args []string) int {
+	fs := newFlagSet("trigger")
+	source := fs.String("source", "manual", "what raised the trigger (the EventRepair task passes \"event\")")
+	reason := fs.String("reason", "manual", "trigger reason for the logs")
+	priority := fs.String("priority", triggerCheck, "check (health check first), normal or critical (repair)")
+	evidence := evidenceFlag{}
+	fs.Var(evidence, "evidence", "key=value detail for the logs; repeatable")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	switch *priority {
+	case triggerCheck, triggerNormal, triggerCritical:
+	default:
+		fmt.Fprintf(os.Stderr, "-priority must be %s, %s or %s\n", triggerCheck, triggerNormal, triggerCritical)
+		return 2
+	}
+	p := triggerPayload{Version: triggerVersion, Created: d.clock.Now(), Source: *source, Reason: *reason, Priority: *priority, Evidence: evidence}
+
+	// Started by the daemon's trampoline route: the repair is already decided.
+	if d.repairRequestPending() {
+		return cmdRepair(d, nil)
+	}
+	file, err := d.spoolTrigger(p)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot spool trigger: %v\n", err)
+		return cmdRepair(d, []string{"-reason=" + p.Source + " trigger: " + p.describe()})
+	}
+	if conn, err := dialControl(d.controlAddr()); err == nil {
+		conn.Close()
+		fmt.Println("Trigger spooled for the running daemon.")
+		return 0
+	}
+	if d.cfg.Triggers.StartDaemon && runtime.GOOS == "windows" {
+		if err := runScheduledTask(watchdogTask); err == nil {
+			fmt.Printf("Trigger queued; started %s to process it.\n", watchdogTask)
+			return 0
+		}
+	}
+	// No daemon will pick it up soon: take the trigger back and decide here.
+	if os.Remove(file) != nil {
+		// Claimed by a daemon that started meanwhile.
+		fmt.Println("Trigger spooled for the running daemon.")
+		return 0
+	}
+	return cmdRepair(d, []string{"-reason=" + p.Source + " trigger: " + p.describe()})
+}
@@ -0,0 +1,86 @@
+// state.go
+// Persisted daemon state (state.json in the data directory).
+// Keeps the last repair time across restarts so the cooldown is honoured
+// even when the daemon is relaunched at the next logon, and the repair in
+// progress so an interrupted one can be recovered (recovery.go).
+
+package watchdog
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+type persistedState struct {
+	LastRepair    time.Time                  `json:"lastRepair"`
+	LastDeepClean time.Time                  `json:"lastDeepClean,omitempty"`
+	LastSummary   time.Time                  `json:"lastSummary,omitempty"` // weekly summary slot (weeklysummary.go)
+	Corruptions   []time.Time                `json:"corruptions,omitempty"` // health-check repairs (shellext.go)
+	AVSightings   []avSighting               `json:"avSightings,omitempty"` // scanners seen during repairs (av.go)
+	Pause         *pauseState                `json:"pause,omitempty"`       // pause.go
+	SizeRepair    *sizeRepairState           `json:"sizeRepair,omitempty"`  // sizeverify.go
+	Heuristics    map[string]heuristicRecord `json:"heuristics,omitempty"`  // heuristicstats.go
+	OSBuild       string                     `json:"osBuild,omitempty"`     // updatebaseline.go
+	OSUpdated     time.Time                  `json:"osUpdated,omitempty"`
+	Breaker       *breakerState              `json:"circuitBreaker,omitempty"` // breaker.go
+	Failures      int                        `json:"repairFailures,omitempty"`
+	LastThumbnail time.Time                  `json:"lastThumbnailRepair,omitempty"` // targets.go
+
+	RepairInProgress *repairInFlight `json:"repairInProgress,omitempty"` // recovery.go
+	RepairRequest    *repairRequest  `json:"repairRequest,omitempty"`    // trampoline.go
+}
+
+func (d *daemon) loadState() {
+	raw, err := os.ReadFile(d.stateFile)
+	if err != nil {
+		return
+	}
+	var s persistedState
+	if err := json.Unmarshal(raw, &s); err != nil {
+		d.watchLog_("WARN", "state.json is unreadable; starting with fresh state.")
+		return
+	}
+	d.lastRepair = s.LastRepair
+	d.deepCleaned = s.LastDeepClean
+	d.summarized = s.LastSummary
+	d.corruptions = s.Corruptions
+	d.avSightings = s.AVSightings
+	d.avAdvisory = avAdvice(d.avSightings, d.cacheDir, d.cfg.CacheFiles.Icon)
+	d.inFlight = s.RepairInProgress
+	d.pause = s.Pause
+	d.heuristicStats = s.Heuristics
+	d.osBuild, d.osUpdated = s.OSBuild, s.OSUpdated
+	d.breaker, d.failures = s.Breaker, s.Failures
+	d.thumbRepaired = s.LastThumbnail
+	if s.SizeRepair != nil {
+		d.sizeRepair = *s.SizeRepair
+	}
+}
+
+// saveState writes state.json atomically. Caller must hold d.mu.
+// Per-session monitors (multi-session mode) have no state file.
+func (d *daemon) saveState() {
+	if d.stateFile == "" {
+		return
+	}
+	s := persistedState{LastRepair: d.lastRepair, LastDeepClean: d.deepCleaned, LastSummary: d.summarized, Corruptions: d.corruptions,
+		AVSightings: d.avSightings, Pause: d.pause, Heuristics: d.heuristicStats, RepairInProgress: d.inFlight, RepairRequest: d.taskRequest,
+		OSBuild: d.osBuild, OSUpdated: d.osUpdated, Breaker: d.breaker, Failures: d.failures,
+		LastThumbnail: d.thumbRepaired}
+	if d.sizeRepair != (sizeRepairState{}) {
+		s.SizeRepair = &d.sizeRepair
+	}
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := d.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		d.watchLog_("WARN", "Could not write state.json: "+err.Error())
+		return
+	}
+	if err := os.Rename(tmp, d.stateFile); err != nil {
+		d.watchLog_("WARN", "Could not replace state.json: "+err.Error())
+	}
+}
@@ -0,0 +1,303 @@
+// status.go
+// status.json is rewritten by the running daemon on every poll; the
+// `status` subcommand reads it back so the state of a silent, windowless
+// process can be inspected without digging through logs.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// statusStaleAfter marks the daemon as not running when status.json has not
+// been refreshed for several poll intervals.
+const statusStaleAfter = 2 * time.Minute
+
+type statusReport struct {
+	PID          int                        `json:"pid"`
+	Started      time.Time                  `json:"started"`
+	Updated      time.Time                  `json:"updated"`
+	CacheDir     string                     `json:"cacheDir"`
+	CacheLink    string                     `json:"cacheLink,omitempty"` // cachepath.go
+	DataDir      string                     `json:"dataDir"`
+	CacheSizeMB  float64                    `json:"cacheSizeMB"`
+	GrowthMBPerH float64                    `json:"growthMBPerHour"`
+	Thumbnails   *thumbnailStatus           `json:"thumbnails,omitempty"` // watched thumbnail target (targets.go)
+	HealthScore  int                        `json:"healthScore"`
+	Healthy      bool                       `json:"healthy"`
+	LastCheck    time.Time                  `json:"lastHealthCheck"`
+	Heuristics   map[string]heuristicRecord `json:"heuristics,omitempty"` // heuristicstats.go
+	LastRepair   time.Time                  `json:"lastRepair"`
+	Deferred     string                     `json:"deferredRepair,omitempty"`
+	Quiet        string                     `json:"quiet,omitempty"`
+	ReportOnly   string                     `json:"reportOnly,omitempty"`        // why repairs are skipped (vdi.go)
+	Paused       *pauseState                `json:"paused,omitempty"`            // pause.go
+	Breaker      *breakerState              `json:"circuitBreaker,omitempty"`    // breaker.go
+	DiskSpace    *diskSpaceStatus           `json:"diskSpace,omitempty"`         // diskspace.go
+	CacheGone    *time.Time                 `json:"cacheMissingSince,omitempty"` // cachedir.go
+	SizeRepair   *sizeRepairState           `json:"sizeRepair,omitempty"`        // sizeverify.go
+	Incident     *incident                  `json:"incident,omitempty"`          // the open one (incident.go)
+	Profile      profileInfo                `json:"profile"`
+	Capabilities capabilities               `json:"capabilities"`
+	Architecture archInfo                   `json:"architecture"` // arch.go
+	Sessions     []sessionStatus            `json:"sessions,omitempty"`
+	Suspects     []shellSuspect             `json:"shellExtensionSuspects,omitempty"`
+	Overlays     *overlayStatus             `json:"overlays,omitempty"`
+	Shortcuts    *shortcutStatus            `json:"shortcuts,omitempty"`
+	Strays       *strayStatus               `json:"strayFiles,omitempty"`        // strayfiles.go
+	ShellMods    []shellMod                 `json:"shellMods,omitempty"`         // shellmods.go
+	Antivirus    *avAdvisory                `json:"antivirusAdvisory,omitempty"` // av.go
+	Metrics      []metricSample             `json:"metrics,omitempty"`           // metrics.go
+}
+
+func (d *daemon) statusFile() string {
+	return filepath.Join(d.dataDir, "status.json")
+}
+
+func (d *daemon) writeStatus() {
+	raw, err := json.MarshalIndent(d.statusSnapshot(), "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := d.statusFile() + ".tmp"
+	if os.WriteFile(tmp, raw, 0644) == nil {
+		os.Rename(tmp, d.statusFile())
+	}
+}
+
+// statusSnapshot gathers the current status (also served by dashboard.go).
+func (d *daemon) statusSnapshot() statusReport {
+	d.mu.Lock()
+	r := statusReport{
+		PID:          os.Getpid(),
+		Started:      d.started,
+		Updated:      d.clock.Now(),
+		CacheDir:     d.cacheDir,
+		CacheLink:    d.cacheLink,
+		DataDir:      d.dataDir,
+		LastRepair:   d.lastRepair,
+		Deferred:     d.deferredReason,
+		HealthScore:  d.lastScore,
+		Healthy:      d.lastHealthy,
+		LastCheck:    d.lastHealthCheck,
+		Profile:      d.profile,
+		Capabilities: d.caps,
+		Architecture: hostArch(),
+		Sessions:     d.sessionStatuses,
+		Suspects:     d.shellSuspects,
+		Overlays:     d.overlays,
+		Shortcuts:    d.shortcuts,
+		Strays:       d.strays,
+		ShellMods:    d.shellMods,
+		Antivirus:    d.avAdvisory,
+		Heuristics:   d.heuristicRecords(),
+	}
+	if d.pauseActive() {
+		p := *d.pause
+		r.Paused = &p
+	}
+	if d.breaker != nil {
+		b := *d.breaker
+		r.Breaker = &b
+	}
+	if d.diskSpace != nil {
+		s := *d.diskSpace
+		r.DiskSpace = &s
+	}
+	if !d.cacheGone.IsZero() {
+		t := d.cacheGone
+		r.CacheGone = &t
+	}
+	if d.sizeRepair != (sizeRepairState{}) {
+		s := d.sizeRepair
+		r.SizeRepair = &s
+	}
+	if d.thumbnails.SizeLimit != 0 {
+		r.Thumbnails = &thumbnailStatus{SizeMB: d.thumbSizeMB, SizeLimit: d.thumbnails.SizeLimit, Cooldown: d.thumbnails.Cooldown, LastRepair: d.thumbRepaired}
+	}
+	d.mu.Unlock()
+	r.CacheSizeMB = d.getCacheSizeMB()
+	r.GrowthMBPerH = d.growthMBPerHour()
+	r.Quiet = d.holdReason(priorityNormal)
+	r.ReportOnly = d.reportOnlyReason()
+	r.Metrics = d.metrics.snapshot()
+	r.Incident = d.incidents.snapshot()
+	return r
+}
+
+func cmdStatus(d *daemon, args []string) int {
+	fs := newFlagSet("status")
+	asJSON := fs.Bool("json", false, "print the status as JSON")
+	hosts := fs.String("host", "", "comma-separated machines to ask over their fleet endpoint (fleet.go)")
+	tokenFile := fs.String("token-file", "", "fleet token for -host (default: fleet.tokenFile)")
+	timeout := fs.Duration("timeout", 10*time.Second, "how long to wait for each host")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *hosts != "" {
+		var list []string
+		for _, h := range strings.Split(*hosts, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				list = append(list, h)
+			}
+		}
+		return cmdFleetStatus(d, list, *tokenFile, *timeout, *asJSON)
+	}
+	raw, err := os.ReadFile(d.statusFile())
+	if err != nil {
+		if *asJSON {
+			printJSON("status", struct {
+				Running bool   `json:"running"`
+				DataDir string `json:"dataDir"`
+			}{false, d.dataDir})
+			return 1
+		}
+		fmt.Println("Daemon status: unknown (no status.json — has the daemon ever run?)")
+		fmt.Printf("Data dir:      %s\n", d.dataDir)
+		return 1
+	}
+	var r statusReport
+	if err := json.Unmarshal(raw, &r); err != nil {
+		fmt.Fprintf(os.Stderr, "status.json is unreadable: %v\n", err)
+		return 1
+	}
+	running := d.clock.Since(r.Updated) <= statusStaleAfter
+	if *asJSON {
+		printJSON("status", struct {
+			Running bool `json:"running"` // status.json refreshed within statusStaleAfter
+			statusReport
+		}{running, r})
+		return 0
+	}
+
+	state := fmt.Sprintf("running (pid %d, up %s)", r.PID, d.clock.Since(r.Started).Round(time.Second))
+	if !running {
+		state = fmt.Sprintf("NOT RUNNING (last seen %s)", r.Updated.Format("2006-01-02 15:04:05"))
+	}
+	lastRepair := formatTime(r.LastRepair)
+	c := r.Capabilities
+
+	fmt.Printf("Daemon status: %s\n", state)
+	fmt.Printf("Cache dir:     %s\n", r.CacheDir)
+	if r.CacheLink != "" {
+		fmt.Printf("               (via %s)\n", r.CacheLink)
+	}
+	if r.CacheGone != nil {
+		fmt.Printf("               MISSING since %s; watching is paused until it returns\n", formatTime(*r.CacheGone))
+	}
+	fmt.Printf("Data dir:      %s\n", r.DataDir)
+	fmt.Printf("Cache size:    %.2f MB (threshold: %s, growth %.2f MB/h)\n", r.CacheSizeMB, d.cfg.Thresholds.SizeLimit, r.GrowthMBPerH)
+	if t := r.Thumbnails; t != nil {
+		fmt.Printf("Thumbnails:    %.2f MB (threshold: %s, cooldown %s, last repair %s)\n", t.SizeMB, t.SizeLimit, t.Cooldown, formatTime(t.LastRepair))
+	}
+	if r.LastCheck.IsZero() {
+		fmt.Println("Health score:  n/a (no health check yet)")
+	} else {
+		fmt.Printf("Health score:  %d/100 (repair below %d, checked %s)\n", r.HealthScore, d.cfg.Health.RepairBelowScore, formatTime(r.LastCheck))
+	}
+	for _, name := range sortedHeuristics(r.Heuristics) {
+		fmt.Printf("  %-12s %s\n", heuristicLabel(name), r.Heuristics[name])
+	}
+	fmt.Printf("Last repair:   %s\n", lastRepair)
+	if b := r.Breaker; b != nil {
+		fmt.Printf("Breaker:       TRIPPED since %s (%s), %d triggers skipped; run `resume` to re-enable repairs\n",
+			formatTime(b.Tripped), b.Reason, b.Suppressed)
+	}
+	if p := r.Paused; p != nil && d.clock.Now().Before(p.Until) {
+		fmt.Printf("Paused:        %s, via %s; run `resume` to end it\n", p, p.By)
+	}
+	if s := r.DiskSpace; s != nil && s.Low {
+		fmt.Printf("Disk space:    LOW, %s; repairs are skipped\n", s)
+	}
+	if s := r.SizeRepair; s != nil && s.Suspended {
+		fmt.Printf("Size repairs:  %s; they resume when the cache shrinks\n", s)
+	}
+	if r.Incident != nil {
+		fmt.Printf("Incident:      %s\n", r.Incident)
+	}
+	if r.Quiet != "" {
+		fmt.Printf("Holding:       %s\n", r.Quiet)
+	}
+	if r.Deferred != "" {
+		fmt.Printf("Deferred:      %s\n", r.Deferred)
+	}
+	fmt.Printf("Profile:       %s\n", r.Profile)
+	fmt.Printf("Architecture:  %s\n", r.Architecture)
+	if c := r.Profile.Container; c != nil {
+		fmt.Printf("Container:     %s\n", c)
+	}
+	if r.ReportOnly != "" {
+		fmt.Printf("Report-only:   %s; repairs are logged, not run\n", r.ReportOnly)
+	}
+	if o := r.Overlays; o != nil {
+		if len(o.NotLoaded) > 0 {
+			fmt.Printf("Overlays:      %d registered, only %d load. Not loaded: %s\n", o.Registered, overlaySlots, strings.Join(o.NotLoaded, ", "))
+		} else {
+			fmt.Printf("Overlays:      %d of %d slots used\n", o.Registered, overlaySlots)
+		}
+	}
+	if s := r.Shortcuts; s != nil {
+		fmt.Printf("Shortcuts:     %s (run `healthcheck` for the list)\n", s)
+	}
+	if s := r.Strays; s != nil && len(s.Files) > 0 {
+		fmt.Printf("Stray files:   %s (run `strays` for the list)\n", s)
+	}
+	if a := r.Antivirus; a != nil {
+		fmt.Printf("Antivirus:     %s\n", a)
+		if a.Command != "" {
+			fmt.Printf("               %s\n", a.Command)
+		}
+	}
+	for _, m := range r.ShellMods {
+		fmt.Printf("Shell mod:     %s\n", m)
+	}
+	for i, s := range r.Suspects {
+		label := "Possible culprit"
+		if i == 0 {
+			label = "Likely culprit"
+		}
+		fmt.Printf("Shell ext:     %s (%d corruptions since install): %s\n", label, s.Corruptions, s)
+	}
+	fmt.Println()
+	fmt.Println("Capabilities:")
+	fmt.Printf("  Elevated:         %t (integrity: %s)\n", c.Elevated, c.IntegrityLevel)
+	fmt.Printf("  Session:          %d (Explorer in session %d)\n", c.SessionID, c.ExplorerSession)
+	fmt.Printf("  Delete cache:     %t\n", c.CanDeleteCache)
+	fmt.Printf("  Restart Explorer: %t\n", c.CanRestartShell)
+	fmt.Printf("  Elevated broker:  %t\n", c.BrokerAvailable)
+	fmt.Printf("  Repair route:     %s\n", c.RepairRoute)
+
+	if len(r.Metrics) > 0 {
+		fmt.Println()
+		fmt.Println("Metrics:")
+		for _, m := range r.Metrics {
+			fmt.Printf("  %-36s %s\n", m.Name, m)
+		}
+	}
+
+	if len(r.Sessions) > 0 {
+		fmt.Println()
+		fmt.Println("Sessions:")
+		fmt.Printf("  %-4s %-20s %-10s %10s %7s  %-6s %-6s %-19s %s\n", "ID", "User", "Profile", "Cache MB", "Scan", "Health", "Score", "Last check", "Last repair")
+		for _, s := range r.Sessions {
+			health := "FAIL"
+			if s.Healthy {
+				health = "PASS"
+			}
+			fmt.Printf("  %-4d %-20s %-10s %10.2f %5dms  %-6s %-6d %-19s %s\n", s.ID, s.User, s.ProfileType, s.CacheSizeMB, s.ScanMs, health, s.Score, formatTime(s.LastCheck), formatTime(s.LastRepair))
+		}
+	}
+	return 0
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
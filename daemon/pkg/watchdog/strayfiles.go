@@ -0,0 +1,159 @@
+// strayfiles.go
+// Stray files in the cache directory (strayFiles.enabled). Leftovers of
+// manual fixes (iconcache_256.db.bak, "thumbcache_1280 (2).db.old"), temp
+// files of crashed tools and dumps end up next to the cache. They are not
+// cache files (cachefiles.go), so the daemon neither counts nor deletes
+// them, but they make the folder look bloated to users and helpdesks that
+// check its size, and no repair will ever shrink it.
+//
+// With every health check the daemon lists the files in the directory that
+// match neither the icon nor the thumbnail patterns and are at least
+// strayFiles.minSize. Like the shortcut scan they are reported apart from
+// the heuristics and never trigger a repair. `strays` prints them;
+// `strays -clean` deletes them.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const strayLogMax = 5 // files named in the health log
+
+type strayFileOptions struct {
+	Enabled bool     `json:"enabled"`
+	MinSize byteSize `json:"minSize"` // smallest file reported
+}
+
+func defaultStrayFileOptions() strayFileOptions {
+	return strayFileOptions{Enabled: true, MinSize: 4 * mib}
+}
+
+type strayFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+func (f strayFile) String() string {
+	return fmt.Sprintf("%s (%.2f MB)", f.Name, byteSize(f.Size).MB())
+}
+
+type strayStatus struct {
+	Files []strayFile `json:"files,omitempty"` // largest first
+	Bytes int64       `json:"bytes"`
+}
+
+func (s *strayStatus) String() string {
+	return fmt.Sprintf("%d file(s), %.2f MB", len(s.Files), byteSize(s.Bytes).MB())
+}
+
+// findStrayFiles lists the non-cache files of at least strayFiles.minSize
+// in the cache directory.
+func (d *daemon) findStrayFiles() *strayStatus {
+	entries, err := os.ReadDir(d.cacheDir)
+	if err != nil {
+		return nil
+	}
+	st := &strayStatus{}
+	for _, e := range entries {
+		if e.IsDir() || matchCachePattern(d.cfg.CacheFiles.Icon, e.Name()) || matchCachePattern(d.cfg.CacheFiles.Thumbnail, e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Size() < int64(d.cfg.StrayFiles.MinSize) {
+			continue
+		}
+		st.Files = append(st.Files, strayFile{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+		st.Bytes += info.Size()
+	}
+	sort.Slice(st.Files, func(i, j int) bool { return st.Files[i].Size > st.Files[j].Size })
+	return st
+}
+
+// checkStrayFiles logs the stray files found by a health check; nil when
+// the check is disabled.
+func (d *daemon) checkStrayFiles() *strayStatus {
+	if !d.cfg.StrayFiles.Enabled {
+		return nil
+	}
+	st := d.findStrayFiles()
+	if st == nil || len(st.Files) == 0 {
+		d.healthLog_("PASS", fmt.Sprintf("Stray files: none of %s or more.", d.cfg.StrayFiles.MinSize))
+		return st
+	}
+	var names []string
+	for _, f := range st.Files[:min(len(st.Files), strayLogMax)] {
+		names = append(names, f.String())
+	}
+	if more := len(st.Files) - strayLogMax; more > 0 {
+		names = append(names, fmt.Sprintf("%d more", more))
+	}
+	d.healthLog_("WARN", fmt.Sprintf("Stray files: %s in the cache directory that are not cache files. Repairs leave them; run `strays -clean` to delete them: %s",
+		st, strings.Join(names, ", ")))
+	return st
+}
+
+func cmdStrays(d *daemon, args []string) int {
+	fs := newFlagSet("strays")
+	asJSON := fs.Bool("json", false, "print the files as JSON")
+	clean := fs.Bool("clean", false, "delete the files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	d.resolveCachePath()
+	st := d.findStrayFiles()
+	if st == nil {
+		fmt.Fprintf(os.Stderr, "Cannot read the cache directory %s.\n", d.cacheDir)
+		return 1
+	}
+	var failed []string
+	if *clean {
+		for _, f := range st.Files {
+			if err := os.Remove(filepath.Join(d.cacheDir, f.Name)); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", f.Name, err))
+				continue
+			}
+			d.healthLog_("INFO", fmt.Sprintf("Stray file deleted (strays -clean): %s", f))
+		}
+	}
+
+	if *asJSON {
+		printJSON("strays", struct {
+			CacheDir string   `json:"cacheDir"`
+			MinSize  byteSize `json:"minSize"`
+			strayStatus
+			Cleaned bool     `json:"cleaned,omitempty"`
+			Failed  []string `json:"failed,omitempty"`
+		}{d.cacheDir, d.cfg.StrayFiles.MinSize, *st, *clean, failed})
+	} else {
+		if len(st.Files) == 0 {
+			fmt.Printf("No stray files of %s or more in %s.\n", d.cfg.StrayFiles.MinSize, d.cacheDir)
+			return 0
+		}
+		fmt.Printf("Stray files in %s: %s\n", d.cacheDir, st)
+		for _, f := range st.Files {
+			fmt.Printf("  %10.2f MB  %s  %s\n", byteSize(f.Size).MB(), formatTime(f.Modified), f.Name)
+		}
+		switch {
+		case !*clean:
+			fmt.Println("Run `strays -clean` to delete them.")
+		case len(failed) == 0:
+			fmt.Println("Deleted.")
+		default:
+			fmt.Printf("Deleted %d; could not delete:\n", len(st.Files)-len(failed))
+			for _, f := range failed {
+				fmt.Printf("  %s\n", f)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -3,7 +3,7 @@
 // syscall_other.go
 // Stub for non-Windows platforms (allows development/testing on Linux/macOS).
 
-package main
+package watchdog
 
 import "syscall"
 
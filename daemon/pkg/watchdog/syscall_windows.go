@@ -4,7 +4,7 @@
 // when this process spawns child processes (PowerShell repair scripts).
 // This file is only compiled on Windows (build tag enforced by filename).
 
-package main
+package watchdog
 
 import "syscall"
 
@@ -0,0 +1,151 @@
+// targets.go
+// Per-target thresholds. One daemon may watch more than one thing: the
+// icon cache, the thumbnail cache next to it and, on a session host, the
+// caches of many users. One size limit and one cooldown do not fit them
+// all — 400 MB of thumbnails is normal where a 40 MB icon cache is
+// bloated, and a designer's profile churns more than a kiosk's:
+//
+//   "targets": {
+//     "icon":      {"sizeLimit": "32MB"},
+//     "thumbnail": {"sizeLimit": "512MB", "cooldown": "4h"},
+//     "users": [{"match": "CONTOSO\\design-*", "icon": {"sizeLimit": "96MB", "cooldown": "2h"}}]
+//   }
+//
+// A value left out (zero) is inherited: a users entry's from targets.icon
+// or targets.thumbnail, and those from thresholds.sizeLimit and
+// thresholds.cooldown. The first users entry whose match (accounts as in
+// optOut.users) names the monitored account applies; in multi-session mode
+// each session resolves its own. The icon target replaces the thresholds
+// for that account, so Layer B, the health score and `tune` use it, and
+// `config show-effective` lists its source as "target".
+//
+// The thumbnail cache is watched only while a thumbnail sizeLimit is set;
+// it does not inherit the icon limit. A poll finding it over the limit
+// starts a repair with -IncludeThumbcache, at most once per the thumbnail
+// cooldown. thresholds.cooldown still applies, as any repair restarts
+// Explorer. Trace simulation does not watch thumbnails.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type targetThresholds struct {
+	SizeLimit byteSize `json:"sizeLimit"` // 0 inherits
+	Cooldown  duration `json:"cooldown"`  // 0 inherits
+}
+
+// or fills the values t leaves out from parent.
+func (t targetThresholds) or(parent targetThresholds) targetThresholds {
+	if t.SizeLimit == 0 {
+		t.SizeLimit = parent.SizeLimit
+	}
+	if t.Cooldown == 0 {
+		t.Cooldown = parent.Cooldown
+	}
+	return t
+}
+
+type userTarget struct {
+	Match     string           `json:"match"` // accounts as in optOut.users
+	Icon      targetThresholds `json:"icon"`
+	Thumbnail targetThresholds `json:"thumbnail"`
+}
+
+type targetOptions struct {
+	Icon      targetThresholds `json:"icon"`
+	Thumbnail targetThresholds `json:"thumbnail"` // watched while sizeLimit is set
+	Users     []userTarget     `json:"users"`
+}
+
+func defaultTargetOptions() targetOptions {
+	return targetOptions{Users: []userTarget{}}
+}
+
+// applyTargets resolves the targets for domain\user: the icon target
+// replaces thresholds.sizeLimit and thresholds.cooldown, recorded in
+// sources unless it is nil, and the thumbnail target is returned.
+func applyTargets(cfg *config, sources configSources, domain, user string) targetThresholds {
+	o := cfg.Targets
+	icon, thumb := o.Icon, o.Thumbnail
+	for _, u := range o.Users {
+		if user != "" && excludedUser(optOutPatterns(u.Match), domain, user) {
+			icon, thumb = u.Icon.or(icon), u.Thumbnail.or(thumb)
+			break
+		}
+	}
+	t := &cfg.Thresholds
+	if icon.SizeLimit != 0 {
+		t.SizeLimit = icon.SizeLimit
+		if sources != nil {
+			sources["thresholds.sizeLimit"] = sourceTarget
+		}
+	}
+	if icon.Cooldown != 0 {
+		t.Cooldown = icon.Cooldown
+		if sources != nil {
+			sources["thresholds.cooldown"] = sourceTarget
+		}
+	}
+	return thumb.or(targetThresholds{Cooldown: t.Cooldown})
+}
+
+type thumbnailStatus struct {
+	SizeMB     float64   `json:"sizeMB"` // at the last poll
+	SizeLimit  byteSize  `json:"sizeLimit"`
+	Cooldown   duration  `json:"cooldown"`
+	LastRepair time.Time `json:"lastRepair"`
+}
+
+// thumbnailSizeMB sums the files matching cacheFiles.thumbnail.
+func (d *daemon) thumbnailSizeMB() float64 {
+	var total int64
+	for _, name := range cacheFileNames(d.cacheDir, d.cfg.CacheFiles.Thumbnail) {
+		if info, err := os.Stat(filepath.Join(d.cacheDir, name)); err == nil {
+			total += info.Size()
+		}
+	}
+	return mb(total)
+}
+
+// checkThumbnails is the thumbnail target's part of a Layer B poll. A cache
+// over the limit is tried once both cooldowns have passed, and then at
+// most once per thresholds.stillExceededEvery while something else (a
+// pause, a hold) keeps the repair from starting.
+func (d *daemon) checkThumbnails() {
+	t := d.thumbnails
+	if t.SizeLimit == 0 || d.sim != nil {
+		return
+	}
+	sizeMB := d.thumbnailSizeMB()
+	now := d.clock.Now()
+	d.mu.Lock()
+	d.thumbSizeMB = sizeMB
+	left, repaired := d.cooldownLeft(), d.thumbRepaired
+	d.mu.Unlock()
+	if sizeMB <= t.SizeLimit.MB() {
+		if !d.thumbOver.IsZero() {
+			d.logMsg(msgThumbBackUnder, t.SizeLimit, sizeMB, duration(now.Sub(d.thumbOver).Truncate(time.Second)))
+			d.thumbOver = time.Time{}
+		}
+		return
+	}
+	if d.thumbOver.IsZero() {
+		d.thumbOver = now
+	}
+	if left > 0 || now.Sub(repaired) < t.Cooldown.D() || now.Sub(d.thumbTried) < d.cfg.Thresholds.StillExceededEvery.D() {
+		return
+	}
+	d.thumbTried = now
+	d.logMsg(msgThumbTrigger, sizeMB, t.SizeLimit)
+	if d.tryRepair(fmt.Sprintf("thumbnail cache %.2f MB exceeds %s limit", sizeMB, t.SizeLimit), priorityNormal, false, "-IncludeThumbcache") {
+		d.mu.Lock()
+		d.thumbRepaired = now
+		d.saveState()
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,44 @@
+// targets_test.go
+// Per-cache and per-account thresholds.
+
+package watchdog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyTargets(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Targets = targetOptions{
+		Icon:      targetThresholds{SizeLimit: 48 * mib},
+		Thumbnail: targetThresholds{SizeLimit: 2 * mib},
+		Users:     []userTarget{{Match: `CONTOSO\design-*`, Icon: targetThresholds{SizeLimit: 96 * mib, Cooldown: duration(2 * time.Hour)}}},
+	}
+	if errs := cfg.checkRanges(); len(errs) != 0 {
+		t.Fatalf("range errors = %v", errs)
+	}
+
+	// Values left out are inherited from the level above.
+	kiosk, sources := cfg, configSources{}
+	thumb := applyTargets(&kiosk, sources, "PC01", "kiosk")
+	if kiosk.Thresholds.SizeLimit != 48*mib || kiosk.Thresholds.Cooldown != cfg.Thresholds.Cooldown ||
+		thumb != (targetThresholds{SizeLimit: 2 * mib, Cooldown: cfg.Thresholds.Cooldown}) {
+		t.Fatalf("kiosk thresholds = %+v, thumbnail = %+v", kiosk.Thresholds, thumb)
+	}
+	if sources["thresholds.sizeLimit"] != sourceTarget || sources["thresholds.cooldown"] != "" {
+		t.Fatalf("sources = %v", sources)
+	}
+	design := cfg
+	thumb = applyTargets(&design, nil, "CONTOSO", "design-07")
+	if design.Thresholds.SizeLimit != 96*mib || design.Thresholds.Cooldown.D() != 2*time.Hour || thumb.Cooldown.D() != 2*time.Hour {
+		t.Fatalf("designer thresholds = %+v, thumbnail = %+v", design.Thresholds, thumb)
+	}
+
+	cfg.Targets.Thumbnail.SizeLimit = 512 << 10
+	cfg.Targets.Users = append(cfg.Targets.Users, userTarget{Icon: targetThresholds{Cooldown: duration(time.Second)}})
+	errs := cfg.checkRanges()
+	if len(errs) != 3 || errs[0].Path != "targets.thumbnail.sizeLimit" || errs[1].Path != "targets.users" || errs[2].Path != "targets.users" {
+		t.Fatalf("checkRanges = %v", errs)
+	}
+}
@@ -0,0 +1,197 @@
+// tiers.go
+// Targeted tier repair (tierRepair.enabled). The icon cache is one database
+// per resolution tier (iconcache_16.db … iconcache_2560.db, _wide, _exif)
+// plus the index. When one tier is damaged, only the icons of that size are
+// wrong, and deleting every file costs a full rebuild: longer Explorer
+// downtime and a burst of I/O while every icon is extracted again.
+//
+// With every health check the daemon walks each tier's entries. A tier
+// database starts with a "CMMM" header whose first-entry offset sits at 12
+// (up to Windows 8) or 16 (8.1 and later); every entry starts with "CMMM"
+// and its size, and free space after the last entry is zero. A tier is
+// corrupt when its header or an entry breaks those rules. The parser reads
+// nothing else, so a format change makes it miss damage rather than invent
+// it, and a tier must be found corrupt by two checks in a row, so a file
+// caught mid-write by Explorer is not reported.
+//
+// When the health score is at or above the threshold but tiers are corrupt,
+// the repair script runs with -Tiers and deletes only those tiers and the
+// index, which Explorer rebuilds from them. When every tier is corrupt, or
+// the score is below the threshold, the normal repair runs. Repairs handed
+// to the elevated broker or the repair task always delete every file.
+
+package watchdog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	tierSignature = "CMMM"
+	tierIndexFile = "iconcache_idx.db"
+	tierHeaderMin = 24 // shortest header of any format version
+	tierEntryMin  = 24 // shortest entry header of any format version
+)
+
+type tierRepairOptions struct {
+	Enabled bool `json:"enabled"`
+}
+
+type tierProblem struct {
+	File    string `json:"file"`
+	Problem string `json:"problem"`
+}
+
+type tierStatus struct {
+	Parsed    int           `json:"parsed"`
+	Corrupt   []tierProblem `json:"corrupt,omitempty"`
+	Confirmed []string      `json:"confirmed,omitempty"` // corrupt at two checks in a row
+}
+
+// parseCacheTier walks the entries of one tier database and returns the
+// first problem found, or "".
+func parseCacheTier(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+	if size == 0 {
+		return "", nil // created, not written yet
+	}
+	if size < tierHeaderMin {
+		return fmt.Sprintf("%d bytes, shorter than a header", size), nil
+	}
+	var hdr [tierHeaderMin]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		return "", err
+	}
+	if string(hdr[:4]) != tierSignature {
+		return "no CMMM signature", nil
+	}
+
+	first := int64(-1)
+	for _, at := range []int{12, 16} {
+		off := int64(binary.LittleEndian.Uint32(hdr[at:]))
+		if off < tierHeaderMin || off > size {
+			continue
+		}
+		if sig, _ := readTierSignature(f, off, size); off == size || sig == tierSignature || sig == "" {
+			first = off
+			if sig == tierSignature {
+				break
+			}
+		}
+	}
+	if first < 0 {
+		return "header names no first entry", nil
+	}
+
+	var eh [8]byte
+	for pos := first; pos+int64(len(eh)) <= size; {
+		if _, err := f.ReadAt(eh[:], pos); err != nil && err != io.EOF {
+			return "", err
+		}
+		if binary.LittleEndian.Uint64(eh[:]) == 0 {
+			break // free space
+		}
+		if string(eh[:4]) != tierSignature {
+			return fmt.Sprintf("entry at 0x%X has no CMMM signature", pos), nil
+		}
+		n := int64(binary.LittleEndian.Uint32(eh[4:]))
+		if n < tierEntryMin || pos+n > size {
+			return fmt.Sprintf("entry at 0x%X claims %d bytes", pos, n), nil
+		}
+		pos += n
+	}
+	return "", nil
+}
+
+// readTierSignature returns the 4 bytes at off, or "" when they are zero or
+// past the end.
+func readTierSignature(f *os.File, off, size int64) (string, error) {
+	if off+4 > size {
+		return "", nil
+	}
+	var sig [4]byte
+	if _, err := f.ReadAt(sig[:], off); err != nil {
+		return "", err
+	}
+	if sig == [4]byte{} {
+		return "", nil
+	}
+	return string(sig[:]), nil
+}
+
+func (d *daemon) tierRepairActive() bool {
+	return d.cfg.TierRepair.Enabled && d.sim == nil
+}
+
+// checkTiers parses every tier and confirms those that were corrupt at the
+// previous check too.
+func (d *daemon) checkTiers() *tierStatus {
+	st := &tierStatus{}
+	corrupt := map[string]bool{}
+	for _, name := range cacheFileNames(d.cacheDir, d.cfg.CacheFiles.Icon) {
+		if strings.EqualFold(name, tierIndexFile) {
+			continue // H1
+		}
+		problem, err := parseCacheTier(filepath.Join(d.cacheDir, name))
+		if err != nil {
+			d.healthLog_("INFO", fmt.Sprintf("Cache tier %s not parsed: %v", name, err))
+			continue
+		}
+		st.Parsed++
+		if problem == "" {
+			continue
+		}
+		st.Corrupt = append(st.Corrupt, tierProblem{File: name, Problem: problem})
+		corrupt[name] = true
+	}
+
+	d.mu.Lock()
+	for _, p := range st.Corrupt {
+		if d.tierSuspects[p.File] {
+			st.Confirmed = append(st.Confirmed, p.File)
+		}
+	}
+	d.tierSuspects = corrupt
+	d.mu.Unlock()
+
+	if len(st.Corrupt) == 0 {
+		d.healthLog_("PASS", fmt.Sprintf("Cache tiers: %d parsed, none corrupt.", st.Parsed))
+		return st
+	}
+	for _, p := range st.Corrupt {
+		state := "first seen; confirmed if the next check agrees"
+		if slices.Contains(st.Confirmed, p.File) {
+			state = "confirmed"
+		}
+		d.healthLog_("WARN", fmt.Sprintf("Cache tier %s corrupt: %s (%s).", p.File, p.Problem, state))
+	}
+	return st
+}
+
+// triggerTierRepair repairs the confirmed corrupt tiers of a cache that
+// otherwise passes, by themselves when other tiers are intact.
+func (d *daemon) triggerTierRepair(st *tierStatus) {
+	reason := fmt.Sprintf("corrupt cache tier %s", strings.Join(st.Confirmed, ", "))
+	if len(st.Confirmed) >= st.Parsed {
+		d.healthLog_("REPAIR", "=== EVERY CACHE TIER CORRUPT. Triggering repair... ===")
+		d.triggerRepair(reason, priorityNormal)
+		return
+	}
+	d.healthLog_("REPAIR", fmt.Sprintf("=== CORRUPT CACHE TIER. Rebuilding %s and the index only... ===", strings.Join(st.Confirmed, ", ")))
+	d.tryRepair(reason, priorityNormal, false, "-Tiers", strings.Join(st.Confirmed, "|"))
+}
@@ -0,0 +1,75 @@
+// trace.go
+// Cache mutation traces: JSON Lines, one snapshot of the cache directory's
+// metadata per poll. File names, sizes and modification times only — never
+// icon content. A file missing from a snapshot was deleted. An optional
+// first line carries trace metadata. Replayed by `simulate` (simulate.go).
+//
+//   {"trace":1,"profile":"local"}
+//   {"t":"2026-10-01T08:00:00Z","explorer":true,"files":[{"n":"iconcache_idx.db","s":4096,"m":"2026-10-01T07:58:12Z"}]}
+
+package watchdog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const traceVersion = 1
+
+type traceFile struct {
+	Name    string    `json:"n"`
+	Size    int64     `json:"s"`
+	ModTime time.Time `json:"m"`
+}
+
+type traceSnapshot struct {
+	At       time.Time   `json:"t"`
+	Explorer *bool       `json:"explorer,omitempty"` // nil: not recorded, assume running
+	Files    []traceFile `json:"files"`
+}
+
+type traceHeader struct {
+	Version int    `json:"trace"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// traceLine is either a header (Version set) or a snapshot.
+type traceLine struct {
+	traceHeader
+	traceSnapshot
+}
+
+// readTrace parses a trace. Snapshots must be in chronological order.
+func readTrace(r io.Reader) (traceHeader, []traceSnapshot, error) {
+	var hdr traceHeader
+	var snaps []traceSnapshot
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var l traceLine
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			return hdr, nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if l.Version != 0 {
+			if l.Version > traceVersion {
+				return hdr, nil, fmt.Errorf("line %d: trace version %d is newer than supported (%d)", n, l.Version, traceVersion)
+			}
+			hdr = l.traceHeader
+			continue
+		}
+		if l.At.IsZero() {
+			return hdr, nil, fmt.Errorf("line %d: snapshot without timestamp", n)
+		}
+		if len(snaps) > 0 && l.At.Before(snaps[len(snaps)-1].At) {
+			return hdr, nil, fmt.Errorf("line %d: snapshot out of order", n)
+		}
+		snaps = append(snaps, l.traceSnapshot)
+	}
+	return hdr, snaps, sc.Err()
+}
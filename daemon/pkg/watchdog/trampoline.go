@@ -0,0 +1,73 @@
+// trampoline.go
+// Scheduled-task trampoline. When this process lacks the rights to repair
+// directly (wrong session, cannot delete cache files), the repair is handed
+// to the \IconCache\EventRepair task, which Task Scheduler runs with the
+// interactive user's token in their own session.
+//
+// The task runs `icon-cache-watchdog trigger` (spool.go), the same command
+// it runs for Explorer crash, hang and resume events. The daemon leaves its
+// reason in state.json first; `trigger` sees it and runs `repair`
+// (repair.go) instead of spooling, which takes the reason from there and
+// skips the cooldown the daemon has already applied.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	eventRepairTask   = `\IconCache\EventRepair`
+	taskRequestMaxAge = 5 * time.Minute
+)
+
+type repairRequest struct {
+	Reason     string    `json:"reason"`
+	Requested  time.Time `json:"requested"`
+	Thumbcache bool      `json:"includeThumbcache,omitempty"` // targets.go
+}
+
+// takeRepairRequest returns the daemon's pending request, if fresh, and
+// removes it from state.json. Caller holds d.mu.
+func (d *daemon) takeRepairRequest() *repairRequest {
+	raw, err := os.ReadFile(d.stateFile)
+	if err != nil {
+		return nil
+	}
+	var s persistedState
+	if json.Unmarshal(raw, &s) != nil || s.RepairRequest == nil {
+		return nil
+	}
+	d.saveState() // without the request
+	if d.clock.Since(s.RepairRequest.Requested) > taskRequestMaxAge {
+		return nil
+	}
+	return s.RepairRequest
+}
+
+// repairRequestPending reports whether a fresh request is waiting in
+// state.json, without taking it.
+func (d *daemon) repairRequestPending() bool {
+	raw, err := os.ReadFile(d.stateFile)
+	if err != nil {
+		return false
+	}
+	var s persistedState
+	return json.Unmarshal(raw, &s) == nil && s.RepairRequest != nil &&
+		d.clock.Since(s.RepairRequest.Requested) <= taskRequestMaxAge
+}
+
+func runScheduledTask(name string) error {
+	cmd := exec.Command("schtasks.exe", "/Run", "/TN", name)
+	cmd.SysProcAttr = sysProcAttr()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("schtasks /Run %s: %v (%s)", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
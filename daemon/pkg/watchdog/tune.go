@@ -0,0 +1,360 @@
+// tune.go
+// Threshold tuning (`tune`). The defaults suit a typical desktop; a design
+// workstation with thousands of thumbnails or a kiosk whose cache never
+// grows needs others. tune reads what the daemon recorded on this machine —
+// up to tuneDays of daily reports (reports.go), the 24-hour history
+// (history.go) and the health-check repairs in state.json (shellext.go) —
+// and recommends:
+//
+//   - thresholds.sizeLimit: half again the cache's usual peak (the 90th
+//     percentile of the daily peaks, or of the 10-minute peaks with fewer
+//     than tuneMinDays reports), so normal use stays below it
+//   - thresholds.staleAge: four fifths of the usual time between
+//     health-check repairs, so H4 refreshes an idle cache before it would
+//     typically break. Only ever lowered: longer gaps may be H4's own
+//     refreshes
+//   - thresholds.growthSlope: half again the usual hourly growth, so the
+//     size score only reacts to growth faster than this machine's normal
+//
+// A value is recommended only with enough data and when it differs from
+// the current one by at least a quarter. With -apply the recommendations
+// are written to the config file after a confirmation (-yes skips it):
+// keys already in the file are replaced in place, so its comments and
+// layout are kept. A setting fixed by policy or an override is reported
+// but not written, as the file would not win.
+
+package watchdog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	tuneDays       = 30
+	tuneMinDays    = 3  // daily reports for the size limit; fewer use the history
+	tuneMinBuckets = 36 // 10-minute history buckets (6 h) for the size limit
+	tuneMinSlopes  = 12 // one-hour spans in the history for the growth slope
+	tuneMinRepairs = 3  // health-check repairs for the stale age
+	tuneMinChange  = 0.25
+)
+
+// tuneRecommendation is one setting. Recommended is empty when the current
+// value is kept; Basis says why either way.
+type tuneRecommendation struct {
+	Setting     string `json:"setting"`
+	Current     string `json:"current"`
+	Recommended string `json:"recommended,omitempty"`
+	Basis       string `json:"basis"`
+	Source      string `json:"source"` // layer of the current value (settings.go)
+	value       any    // the recommended value as written to the file
+}
+
+type tuneReport struct {
+	Days            int                  `json:"days"` // daily reports read
+	HistoryBuckets  int                  `json:"historyBuckets"`
+	Repairs         int                  `json:"healthRepairs"` // health-check repairs in state.json
+	Recommendations []tuneRecommendation `json:"recommendations"`
+}
+
+// changes lists the recommendations that differ from the current values.
+func (r tuneReport) changes() []tuneRecommendation {
+	var out []tuneRecommendation
+	for _, rec := range r.Recommendations {
+		if rec.Recommended != "" {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// buildTuneReport recommends thresholds from the recorded history.
+func (d *daemon) buildTuneReport() tuneReport {
+	var r tuneReport
+	today := d.clock.Now().Local()
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	var peaks []float64
+	sizeTriggers := 0
+	for day := today.AddDate(0, 0, -tuneDays); !day.After(today); day = day.AddDate(0, 0, 1) {
+		rep := d.loadReport(day.Format("2006-01-02"))
+		if rep.Updated.IsZero() || rep.SizeSamples == 0 {
+			continue
+		}
+		r.Days++
+		peaks = append(peaks, rep.CacheMBMax)
+		sizeTriggers += rep.SizeTriggers
+	}
+	view, _ := fetchHistory(d)
+	r.HistoryBuckets = len(view.Sizes)
+	d.loadState()
+	r.Repairs = len(d.corruptions)
+
+	r.Recommendations = []tuneRecommendation{
+		d.tuneSizeLimit(peaks, sizeTriggers, view.Sizes),
+		d.tuneStaleAge(d.corruptions),
+		d.tuneGrowthSlope(view.Sizes),
+	}
+	for i := range r.Recommendations {
+		rec := &r.Recommendations[i]
+		rec.Source = d.cfgSources.of(rec.Setting)
+	}
+	return r
+}
+
+func (d *daemon) tuneSizeLimit(dailyPeaks []float64, sizeTriggers int, sizes []historyPoint) tuneRecommendation {
+	cur := d.cfg.Thresholds.SizeLimit
+	rec := tuneRecommendation{Setting: "thresholds.sizeLimit", Current: cur.String()}
+	var usual float64
+	switch {
+	case len(dailyPeaks) >= tuneMinDays:
+		usual = percentile(dailyPeaks, 0.9)
+		rec.Basis = fmt.Sprintf("usual daily peak %.1f MB (90th percentile of %d days)", usual, len(dailyPeaks))
+		if sizeTriggers > 0 {
+			rec.Basis += fmt.Sprintf("; %s in that time", plural(sizeTriggers, "size trigger", "no size triggers"))
+		}
+	case len(sizes) >= tuneMinBuckets:
+		var vals []float64
+		for _, p := range sizes {
+			vals = append(vals, p.SizeMB)
+		}
+		usual = percentile(vals, 0.9)
+		rec.Basis = fmt.Sprintf("usual size %.1f MB over the last 24h (90th percentile; no daily reports)", usual)
+	default:
+		rec.Basis = fmt.Sprintf("not enough data: needs %d days of daily reports (reports.enabled or weeklySummary.enabled) or %s of history",
+			tuneMinDays, duration(tuneMinBuckets*historyBucket))
+		return rec
+	}
+	want := byteSize(math.Ceil(usual*1.5/8) * 8 * float64(mib))
+	want = min(max(want, 8*mib), 4*gib)
+	if tuneChanged(cur.MB(), want.MB()) {
+		rec.Recommended, rec.value = want.String(), want
+	}
+	return rec
+}
+
+func (d *daemon) tuneStaleAge(repairs []time.Time) tuneRecommendation {
+	cur := d.cfg.Thresholds.StaleAge
+	rec := tuneRecommendation{Setting: "thresholds.staleAge", Current: cur.String()}
+	if len(repairs) < tuneMinRepairs {
+		rec.Basis = fmt.Sprintf("not enough data: needs %d health-check repairs, %d recorded", tuneMinRepairs, len(repairs))
+		return rec
+	}
+	var gaps []float64
+	for i := 1; i < len(repairs); i++ {
+		gaps = append(gaps, repairs[i].Sub(repairs[i-1]).Hours()/24)
+	}
+	usual := percentile(gaps, 0.5)
+	rec.Basis = fmt.Sprintf("health-check repairs every %.1f days (median of %d)", usual, len(gaps))
+	want := duration(time.Duration(max(math.Floor(usual*0.8), 1)) * 24 * time.Hour)
+	if want < cur && tuneChanged(cur.D().Hours(), want.D().Hours()) {
+		rec.Recommended, rec.value = want.String(), want
+	}
+	return rec
+}
+
+func (d *daemon) tuneGrowthSlope(sizes []historyPoint) tuneRecommendation {
+	cur := d.cfg.Thresholds.GrowthSlope
+	rec := tuneRecommendation{Setting: "thresholds.growthSlope", Current: formatSlope(cur)}
+	at := map[time.Time]float64{}
+	for _, p := range sizes {
+		at[p.At] = p.SizeMB
+	}
+	var slopes []float64
+	for _, p := range sizes {
+		before, ok := at[p.At.Add(-time.Hour)]
+		if ok && p.SizeMB >= before { // a drop is a repair, not growth
+			slopes = append(slopes, p.SizeMB-before)
+		}
+	}
+	if len(slopes) < tuneMinSlopes {
+		rec.Basis = fmt.Sprintf("not enough data: needs %d one-hour spans in the 24h history, %d found", tuneMinSlopes, len(slopes))
+		return rec
+	}
+	usual := percentile(slopes, 0.9)
+	rec.Basis = fmt.Sprintf("usual growth %.2f MB/h (90th percentile of %d one-hour spans)", usual, len(slopes))
+	want := math.Ceil(usual*1.5*10) / 10
+	if tuneChanged(cur, want) {
+		rec.Recommended, rec.value = formatSlope(want), want
+	}
+	return rec
+}
+
+// tuneChanged reports whether want differs from cur by at least tuneMinChange.
+func tuneChanged(cur, want float64) bool {
+	return want != cur && math.Abs(want-cur) >= tuneMinChange*max(cur, want)
+}
+
+func formatSlope(mbPerHour float64) string {
+	return fmt.Sprintf("%g", mbPerHour)
+}
+
+func cmdTune(d *daemon, args []string) int {
+	fs := newFlagSet("tune")
+	asJSON := fs.Bool("json", false, "print the recommendations as JSON")
+	apply := fs.Bool("apply", false, "write the recommended values to the config file")
+	yes := fs.Bool("yes", false, "with -apply, do not ask for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *asJSON && *apply {
+		fmt.Fprintln(os.Stderr, "-json and -apply cannot be combined")
+		return 2
+	}
+	r := d.buildTuneReport()
+	if *asJSON {
+		printJSON("tune", r)
+		return 0
+	}
+
+	fmt.Printf("Tuning from %s, %s of history and %s.\n\n",
+		plural(r.Days, "daily report", "no daily reports"), duration(time.Duration(r.HistoryBuckets)*historyBucket),
+		plural(r.Repairs, "health-check repair", "no health-check repairs"))
+	for _, rec := range r.Recommendations {
+		to := "keep"
+		if rec.Recommended != "" {
+			to = "-> " + rec.Recommended
+		}
+		fmt.Printf("  %-24s %-6s %-10s %s\n", rec.Setting, rec.Current, to, rec.Basis)
+	}
+	fmt.Println()
+	changes := r.changes()
+	switch {
+	case len(changes) == 0:
+		fmt.Println("Nothing to change.")
+		return 0
+	case !*apply:
+		fmt.Printf("Run `tune -apply` to write these to %s.\n", d.configFile)
+		return 0
+	}
+
+	var sets []configSet
+	for _, rec := range changes {
+		if rec.Source != sourceDefault && rec.Source != sourceFile {
+			fmt.Printf("%s is set by %s; not written, as the config file would not win.\n", rec.Setting, rec.Source)
+			continue
+		}
+		sets = append(sets, configSet{rec.Setting, rec.value})
+	}
+	if len(sets) == 0 {
+		return 1
+	}
+	if !*yes && !confirm(fmt.Sprintf("Write %s to %s?", plural(len(sets), "setting", ""), d.configFile)) {
+		fmt.Println("Nothing written.")
+		return 1
+	}
+	if err := writeConfigValues(d.configFile, sets); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write config: %v\n", err)
+		return 1
+	}
+	for _, s := range sets {
+		d.watchLog_("INFO", fmt.Sprintf("Config %s set to %v (tune -apply).", s.path, s.value))
+	}
+	fmt.Printf("Wrote %s. Restart the daemon for the new values to take effect.\n", d.configFile)
+	if readSigningPolicy().required {
+		fmt.Println("Signed config is required on this machine: sign it again with `config sign -key=FILE`.")
+	}
+	return 0
+}
+
+// confirm asks a yes/no question on the console; anything but y/yes is no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// configSet is one setting to write, by dotted path.
+type configSet struct {
+	path  string
+	value any
+}
+
+// writeConfigValues sets the values in the config file at path, creating it
+// if needed, and writes it only if the result passes the schema.
+func writeConfigValues(path string, sets []configSet) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		raw, err = []byte("{\n}\n"), nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, s := range sets {
+		if raw, err = setConfigValue(raw, s.path, s.value); err != nil {
+			return err
+		}
+	}
+	if _, _, err := parseConfig(raw); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, raw)
+}
+
+// setConfigValue sets one dotted setting path in the config file text. A
+// key already present has its value replaced in place; a missing one is
+// added at the top of the innermost object that exists, creating the
+// objects in between. Comments and layout elsewhere are kept.
+func setConfigValue(raw []byte, path string, value any) ([]byte, error) {
+	text, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	stripped := stripJSONComments(raw)
+	w := schemaWalker{raw: stripped, dec: json.NewDecoder(bytes.NewReader(stripped)), keys: map[string]int64{}, invalid: map[string]bool{}}
+	if err := w.walk(reflect.TypeOf(config{}), "", 0); err != nil || len(w.errs) > 0 {
+		return nil, errors.New("the config file has problems; run `config validate` and fix them first")
+	}
+
+	if off, ok := w.keys[path]; ok {
+		colon := int(off) + bytes.IndexByte(stripped[off:], ':') + 1
+		dec := json.NewDecoder(bytes.NewReader(stripped[colon:]))
+		var old json.RawMessage
+		if err := dec.Decode(&old); err != nil {
+			return nil, err
+		}
+		end := colon + int(dec.InputOffset())
+		return splice(raw, end-len(old), end, text), nil
+	}
+
+	parts := strings.Split(path, ".")
+	depth, open := 0, bytes.IndexByte(stripped, '{')
+	for i := len(parts) - 1; i >= 1; i-- {
+		if off, ok := w.keys[strings.Join(parts[:i], ".")]; ok {
+			depth, open = i, int(off)+bytes.IndexByte(stripped[off:], '{')
+			break
+		}
+	}
+	if open < 0 {
+		return nil, errors.New("the config file is not a JSON object")
+	}
+	indent := func(level int) string { return strings.Repeat("  ", level) }
+	member := string(text)
+	for i := len(parts) - 1; i >= depth; i-- {
+		if i < len(parts)-1 {
+			member = fmt.Sprintf("{\n%s%s\n%s}", indent(i+2), member, indent(i+1))
+		}
+		member = fmt.Sprintf("%q: %s", parts[i], member)
+	}
+	insert := "\n" + indent(depth+1) + member
+	if rest := bytes.TrimSpace(stripped[open+1:]); len(rest) > 0 && rest[0] == '}' {
+		insert += "\n" + indent(depth)
+	} else {
+		insert += ","
+	}
+	return splice(raw, open+1, open+1, []byte(insert)), nil
+}
+
+func splice(raw []byte, from, to int, with []byte) []byte {
+	out := append([]byte{}, raw[:from]...)
+	out = append(out, with...)
+	return append(out, raw[to:]...)
+}
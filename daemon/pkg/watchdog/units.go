@@ -0,0 +1,120 @@
+// units.go
+// Config value types with units. Sizes are written "32MB", "512KB", "1GB"
+// (binary multiples; a bare JSON number is bytes). Durations use Go syntax
+// ("45m", "6h", "90s") plus a "d" suffix for days ("30d"). Both marshal back
+// to the shortest exact form, so effective config round-trips.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type byteSize int64
+
+const (
+	kib byteSize = 1 << 10
+	mib byteSize = 1 << 20
+	gib byteSize = 1 << 30
+)
+
+var sizeUnits = []struct {
+	suffix string
+	mult   byteSize
+}{{"GB", gib}, {"MB", mib}, {"KB", kib}, {"B", 1}}
+
+func parseByteSize(s string) (byteSize, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range sizeUnits {
+		if num, ok := strings.CutSuffix(t, u.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil || n < 0 {
+				break
+			}
+			return byteSize(n * float64(u.mult)), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q (use e.g. \"32MB\", \"512KB\")", s)
+}
+
+func (b byteSize) MB() float64 { return float64(b) / float64(mib) }
+
+func (b byteSize) String() string {
+	for _, u := range sizeUnits {
+		if b != 0 && b%u.mult == 0 {
+			return fmt.Sprintf("%d%s", b/u.mult, u.suffix)
+		}
+	}
+	return "0B"
+}
+
+func (b byteSize) MarshalJSON() ([]byte, error) { return json.Marshal(b.String()) }
+
+func (b *byteSize) UnmarshalJSON(raw []byte) error {
+	var n int64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		*b = byteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return fmt.Errorf("expected a size such as \"32MB\"")
+	}
+	v, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+type duration time.Duration
+
+func parseDuration(s string) (duration, error) {
+	t := strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(t, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err == nil && n >= 0 {
+			return duration(n * float64(24*time.Hour)), nil
+		}
+	} else if d, err := time.ParseDuration(t); err == nil && d >= 0 {
+		return duration(d), nil
+	}
+	return 0, fmt.Errorf("invalid duration %q (use e.g. \"45m\", \"6h\", \"30d\")", s)
+}
+
+func (d duration) D() time.Duration { return time.Duration(d) }
+
+func (d duration) String() string {
+	td := time.Duration(d)
+	if td != 0 && td%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", td/(24*time.Hour))
+	}
+	s := td.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+func (d duration) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+func (d *duration) UnmarshalJSON(raw []byte) error {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return fmt.Errorf("expected a duration string such as \"45m\"")
+	}
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
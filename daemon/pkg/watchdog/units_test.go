@@ -0,0 +1,79 @@
+// units_test.go
+// Sizes and durations with units, and their round trip through JSON.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestByteSize(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want byteSize
+		out  string
+	}{
+		{`"32MB"`, 32 * mib, "32MB"},
+		{`"512kb"`, 512 * kib, "512KB"},
+		{`" 1.5 GB "`, 1536 * mib, "1536MB"},
+		{`"0B"`, 0, "0B"},
+		{`1048576`, mib, "1MB"}, // a bare number is bytes
+		{`1000`, 1000, "1000B"},
+	} {
+		var b byteSize
+		if err := json.Unmarshal([]byte(c.in), &b); err != nil || b != c.want {
+			t.Errorf("%s = %d, %v; want %d", c.in, b, err, c.want)
+			continue
+		}
+		raw, _ := json.Marshal(b)
+		if string(raw) != `"`+c.out+`"` {
+			t.Errorf("%s marshals to %s, want %q", c.in, raw, c.out)
+		}
+	}
+	for _, bad := range []string{`"32"`, `"MB"`, `"-1MB"`, `"32TB"`, `true`, `1.5`} {
+		var b byteSize
+		if err := json.Unmarshal([]byte(bad), &b); err == nil {
+			t.Errorf("%s accepted as %d", bad, b)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want time.Duration
+		out  string
+	}{
+		{"45m", 45 * time.Minute, "45m"},
+		{"6h", 6 * time.Hour, "6h"},
+		{"90s", 90 * time.Second, "1m30s"},
+		{"30d", 30 * 24 * time.Hour, "30d"},
+		{"1.5d", 36 * time.Hour, "36h"},
+		{"2h30m", 150 * time.Minute, "2h30m"},
+		{"0s", 0, "0s"},
+	} {
+		d, err := parseDuration(c.in)
+		if err != nil || d.D() != c.want {
+			t.Errorf("%q = %s, %v; want %s", c.in, d.D(), err, c.want)
+			continue
+		}
+		if d.String() != c.out {
+			t.Errorf("%q renders as %q, want %q", c.in, d, c.out)
+		}
+		var back duration
+		if raw, _ := json.Marshal(d); json.Unmarshal(raw, &back) != nil || back != d {
+			t.Errorf("%q does not round-trip: %s", c.in, raw)
+		}
+	}
+	for _, bad := range []string{"", "10", "-5m", "-1d", "xd", "1w"} {
+		if d, err := parseDuration(bad); err == nil {
+			t.Errorf("%q accepted as %s", bad, d)
+		}
+	}
+	var d duration
+	if err := json.Unmarshal([]byte(`300`), &d); err == nil {
+		t.Error("a bare number accepted as a duration")
+	}
+}
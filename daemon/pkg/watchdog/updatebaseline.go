@@ -0,0 +1,101 @@
+// updatebaseline.go
+// Re-baselining after Windows cumulative updates (updateBaseline). A
+// cumulative update replaces shell components and is followed by a burst
+// of legitimate cache churn: servicing rewrites the cache while Explorer is
+// down at the update reboot, files are recreated at new sizes, Explorer
+// restarts during installation and the update may bring shell extensions of
+// its own. Measured against what the daemon learned before the update, that
+// churn looks like corruption.
+//
+// Each health check reads the OS build and update revision (CurrentBuild
+// and UBR, bumped by every cumulative update) and keeps them in state.json.
+// When they change the daemon re-baselines: it drops the content ledger
+// (H6, recorded again after the next healthy check), the size trend and the
+// suspected tiers; H8 counts only crashes, hangs and restarts after the
+// update; shell extensions first seen within updateBaseline.settle count
+// as baseline rather than as new installs; and H2 tolerates writes while
+// Explorer was down for the same period. H1 and H3 still judge the cache
+// itself, so an update that really breaks it is repaired as before.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type updateBaselineOptions struct {
+	Enabled bool     `json:"enabled"`
+	Settle  duration `json:"settle"` // post-update churn tolerated by H2 and the shell extension inventory
+}
+
+func defaultUpdateBaselineOptions() updateBaselineOptions {
+	return updateBaselineOptions{Enabled: true, Settle: duration(6 * time.Hour)}
+}
+
+// osBuild returns the OS build and update revision, e.g. "19045.4291", or
+// "" when they cannot be read.
+func osBuild() string {
+	const key = `SOFTWARE\Microsoft\Windows NT\CurrentVersion`
+	data, _, err := readRegistryValue(key, "CurrentBuildNumber")
+	if err != nil {
+		return ""
+	}
+	build := strings.TrimSpace(registryString(data))
+	if data, typ, err := readRegistryValue(key, "UBR"); err == nil {
+		if ubr, ok := registryDWORD(data, typ); ok {
+			build += "." + strconv.FormatUint(uint64(ubr), 10)
+		}
+	}
+	return build
+}
+
+// checkOSUpdate runs at every health check of the single-user daemon.
+func (d *daemon) checkOSUpdate() {
+	if build := osBuild(); build != "" {
+		d.noteOSBuild(build)
+	}
+}
+
+// noteOSBuild records the OS build and re-baselines when it changed since
+// the last one recorded. The first build recorded is the baseline.
+func (d *daemon) noteOSBuild(build string) {
+	d.mu.Lock()
+	prev := d.osBuild
+	if build == prev {
+		d.mu.Unlock()
+		return
+	}
+	d.osBuild = build
+	rebaseline := prev != "" && d.cfg.UpdateBaseline.Enabled
+	if rebaseline {
+		d.osUpdated = d.clock.Now()
+		d.samples = nil
+		d.tierSuspects = nil
+	}
+	d.saveState()
+	d.mu.Unlock()
+	if !rebaseline {
+		return
+	}
+
+	if err := os.Remove(d.ledgerPath()); err != nil && !os.IsNotExist(err) {
+		d.healthLog_("WARN", "Could not remove ledger.json: "+err.Error())
+	}
+	d.metrics.inc(mRebaselines)
+	d.historyEvent(historyUpdate, fmt.Sprintf("Windows updated to build %s", build))
+	d.healthLog_("INFO", fmt.Sprintf("Windows updated from build %s to %s: heuristic baselines reset (ledger, size trend, tiers, Explorer stability); cache churn tolerated for %s.",
+		prev, build, d.cfg.UpdateBaseline.Settle))
+}
+
+// updateSettling reports whether the last Windows update was detected
+// within updateBaseline.settle.
+func (d *daemon) updateSettling() bool {
+	d.mu.Lock()
+	at := d.osUpdated
+	d.mu.Unlock()
+	return !at.IsZero() && d.cfg.UpdateBaseline.Enabled && d.clock.Since(at) < d.cfg.UpdateBaseline.Settle.D()
+}
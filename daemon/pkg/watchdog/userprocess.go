@@ -0,0 +1,80 @@
+// userprocess.go
+// Starting user-facing processes (Explorer) in the right session with the
+// right token. A process started the plain way inherits our context: as
+// LocalSystem that is session 0 and the SYSTEM account, and from the
+// elevated repair route it is an elevated shell that breaks drag and drop
+// and file associations. startUserProcess picks the token instead (see
+// userprocess_windows.go): the logged-on user's own token when running as
+// LocalSystem or for another session, an unelevated token when elevated,
+// and our own token otherwise. The repair script calls back into the binary
+// (start-shell) for the same reason when it restarts Explorer.
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// explorerPath is %SystemRoot%\explorer.exe, or plain explorer.exe.
+func explorerPath() string {
+	if root := os.Getenv("SystemRoot"); root != "" {
+		return filepath.Join(root, "explorer.exe")
+	}
+	return "explorer.exe"
+}
+
+// startExplorer starts the shell in this process's session.
+func startExplorer() error {
+	return startUserProcess(currentSessionID(), explorerPath())
+}
+
+// startDetached starts exe with this process's token and lets it run on.
+func startDetached(exe string, args ...string) error {
+	cmd := exec.Command(exe, args...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// cmdStartShell starts Explorer in a session unless it already runs there.
+// Used by Repair-IconCache.ps1 (-ShellLauncher) when it runs elevated or on
+// behalf of another session. -host starts a shell replacement's host process
+// instead (shellmods.go); only the known hosts are accepted.
+func cmdStartShell(d *daemon, args []string) int {
+	fs := newFlagSet("start-shell")
+	session := fs.Int("session", -1, "session to start Explorer in (default: this one)")
+	host := fs.String("host", "", "path of a shell replacement's host process to start instead")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	id := currentSessionID()
+	if *session >= 0 {
+		id = uint32(*session)
+	}
+	if *host != "" {
+		if !shellModHost(filepath.Base(*host)) || !filepath.IsAbs(*host) {
+			fmt.Fprintf(os.Stderr, "Not a known shell replacement host: %s\n", *host)
+			return 2
+		}
+		if err := startUserProcess(id, *host); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot start %s in session %d: %v\n", filepath.Base(*host), id, err)
+			return 1
+		}
+		fmt.Printf("%s started in session %d.\n", filepath.Base(*host), id)
+		return 0
+	}
+	if isExplorerRunning("/FI", fmt.Sprintf("SESSION eq %d", id)) {
+		fmt.Printf("Explorer is already running in session %d.\n", id)
+		return 0
+	}
+	if err := startUserProcess(id, explorerPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot start Explorer in session %d: %v\n", id, err)
+		return 1
+	}
+	fmt.Printf("Explorer started in session %d.\n", id)
+	return 0
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+// userprocess_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func startUserProcess(session uint32, exe string, args ...string) error {
+	return startDetached(exe, args...)
+}
@@ -0,0 +1,121 @@
+// userprocess_windows.go
+// Token selection for startUserProcess. As LocalSystem, or for a session
+// other than ours, the user's primary token comes from WTSQueryUserToken
+// (needs SeTcbPrivilege). When elevated in the user's own session the
+// linked token is no use (without SeTcbPrivilege it is only good for
+// identification), so a SAFER normal-user token is computed from our own
+// and labelled medium integrity, as `runas /trustlevel:0x20000` does.
+// The process gets the user's environment block and the interactive
+// desktop (winsta0\default).
+
+package watchdog
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCreateEnvironmentBlock     = modUserenv.NewProc("CreateEnvironmentBlock")
+	procDestroyEnvironmentBlock    = modUserenv.NewProc("DestroyEnvironmentBlock")
+	procSaferCreateLevel           = modAdvapi32.NewProc("SaferCreateLevel")
+	procSaferComputeTokenFromLevel = modAdvapi32.NewProc("SaferComputeTokenFromLevel")
+	procSaferCloseLevel            = modAdvapi32.NewProc("SaferCloseLevel")
+	procSetTokenInformation        = modAdvapi32.NewProc("SetTokenInformation")
+)
+
+const (
+	saferScopeIDUser         = 2
+	saferLevelIDNormalUser   = 0x20000
+	saferLevelOpen           = 1
+	seGroupIntegrity         = 0x20
+	createUnicodeEnvironment = 0x00000400
+	mediumIntegritySID       = "S-1-16-8192"
+	interactiveDesktop       = `winsta0\default`
+)
+
+// tokenMandatoryLabel is TOKEN_MANDATORY_LABEL.
+type tokenMandatoryLabel struct {
+	Sid        *syscall.SID
+	Attributes uint32
+}
+
+func startUserProcess(session uint32, exe string, args ...string) error {
+	tok, err := userProcessToken(session)
+	if err != nil {
+		return err
+	}
+	if tok == 0 {
+		return startDetached(exe, args...)
+	}
+	defer tok.Close()
+
+	var env *uint16
+	if r, _, e := procCreateEnvironmentBlock.Call(uintptr(unsafe.Pointer(&env)), uintptr(tok), 0); r == 0 {
+		return fmt.Errorf("CreateEnvironmentBlock: %v", e)
+	}
+	defer procDestroyEnvironmentBlock.Call(uintptr(unsafe.Pointer(env)))
+
+	line := syscall.EscapeArg(exe)
+	for _, a := range args {
+		line += " " + syscall.EscapeArg(a)
+	}
+	cmdLine, err := syscall.UTF16PtrFromString(line)
+	if err != nil {
+		return err
+	}
+	desktop, _ := syscall.UTF16PtrFromString(interactiveDesktop)
+	si := &syscall.StartupInfo{Desktop: desktop}
+	si.Cb = uint32(unsafe.Sizeof(*si))
+	var pi syscall.ProcessInformation
+	if err := syscall.CreateProcessAsUser(tok, nil, cmdLine, nil, nil, false, createUnicodeEnvironment, env, nil, si, &pi); err != nil {
+		return fmt.Errorf("CreateProcessAsUser: %w", err)
+	}
+	syscall.CloseHandle(pi.Thread)
+	syscall.CloseHandle(pi.Process)
+	return nil
+}
+
+// userProcessToken returns the token to start a user-facing process in
+// session with, or 0 when our own token is right: same session, not
+// elevated.
+func userProcessToken(session uint32) (syscall.Token, error) {
+	if own := currentSessionID(); own == 0 || own != session {
+		var tok syscall.Token
+		if r, _, e := procWTSQueryUserToken.Call(uintptr(session), uintptr(unsafe.Pointer(&tok))); r == 0 {
+			return 0, fmt.Errorf("no user token for session %d (needs LocalSystem): %v", session, e)
+		}
+		return tok, nil
+	}
+	if !isElevated() {
+		return 0, nil
+	}
+	return unelevatedToken()
+}
+
+// unelevatedToken computes a normal-user SAFER token from our own and
+// lowers its integrity label to medium.
+func unelevatedToken() (syscall.Token, error) {
+	var level uintptr
+	if r, _, e := procSaferCreateLevel.Call(saferScopeIDUser, saferLevelIDNormalUser, saferLevelOpen, uintptr(unsafe.Pointer(&level)), 0); r == 0 {
+		return 0, fmt.Errorf("SaferCreateLevel: %v", e)
+	}
+	defer procSaferCloseLevel.Call(level)
+	var tok syscall.Token
+	if r, _, e := procSaferComputeTokenFromLevel.Call(level, 0, uintptr(unsafe.Pointer(&tok)), 0, 0); r == 0 {
+		return 0, fmt.Errorf("SaferComputeTokenFromLevel: %v", e)
+	}
+	sid, err := syscall.StringToSid(mediumIntegritySID)
+	if err != nil {
+		tok.Close()
+		return 0, err
+	}
+	label := tokenMandatoryLabel{Sid: sid, Attributes: seGroupIntegrity}
+	size := unsafe.Sizeof(label) + uintptr(sid.Len())
+	if r, _, e := procSetTokenInformation.Call(uintptr(tok), tokenIntegrityLevel, uintptr(unsafe.Pointer(&label)), size); r == 0 {
+		tok.Close()
+		return 0, fmt.Errorf("SetTokenInformation: %v", e)
+	}
+	return tok, nil
+}
@@ -0,0 +1,207 @@
+// usn.go
+// NTFS USN change journal as the Layer B change source (usnJournal.enabled,
+// single-user mode). NTFS records every create, write, rename and delete on
+// the volume in the journal, so the daemon can tell from a few journal
+// records, instead of listing the cache directory, whether anything in it
+// changed since the last poll. Polls without cache records reuse the
+// previous snapshot; any cache record, or any doubt, falls back to a normal
+// scan, which still does the size check and the per-file deltas (delta.go).
+//
+// The read position is kept in usn.json in the data directory. At startup
+// the daemon reads the journal from there and logs what happened to the
+// cache while it was not running. A journal that was deleted, recreated or
+// has wrapped past the saved position cannot be caught up; that is logged
+// and the daemon starts from the current position. Errors (not NTFS, no
+// journal, access denied) disable the journal for this run and are logged
+// once; polling is unaffected.
+
+package watchdog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	usnFileName  = "usn.json"
+	usnSaveEvery = 5 * time.Minute
+	usnMaxListed = 10 // files named in the catch-up log line
+)
+
+// errUSNReset means the saved position is no longer in the journal.
+var errUSNReset = errors.New("journal recreated or wrapped past the saved position")
+
+type usnOptions struct {
+	Enabled bool `json:"enabled"`
+}
+
+// usnCursor is a read position in one volume's journal.
+type usnCursor struct {
+	Volume    string `json:"volume"`
+	JournalID uint64 `json:"journalId"`
+	Next      int64  `json:"next"`
+	Dir       uint64 `json:"dir"` // file reference number of the cache directory
+}
+
+// usnChange is one journal record for a cache file.
+type usnChange struct {
+	Name    string
+	Reasons uint32
+	At      time.Time
+}
+
+// USN_RECORD reason flags the daemon names in its logs.
+var usnReasonNames = []struct {
+	bit  uint32
+	name string
+}{
+	{0x00000001, "overwrite"},
+	{0x00000002, "extend"},
+	{0x00000004, "truncate"},
+	{0x00000100, "create"},
+	{0x00000200, "delete"},
+	{0x00001000, "rename-from"},
+	{0x00002000, "rename-to"},
+	{0x00008000, "attributes"},
+}
+
+func usnReasons(r uint32) string {
+	var names []string
+	for _, n := range usnReasonNames {
+		if r&n.bit != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "other"
+	}
+	return strings.Join(names, "+")
+}
+
+func (d *daemon) usnEnabled() bool {
+	return d.cfg.USNJournal.Enabled && d.sim == nil && d.session == nil
+}
+
+// usnCatchUp logs the cache changes recorded while the daemon was not
+// running and sets the read position for the polls.
+func (d *daemon) usnCatchUp() {
+	if !d.usnEnabled() {
+		return
+	}
+	var saved *usnCursor
+	if raw, err := os.ReadFile(filepath.Join(d.dataDir, usnFileName)); err == nil {
+		saved = &usnCursor{}
+		if json.Unmarshal(raw, saved) != nil {
+			saved = nil
+		}
+	}
+	changes, cur, err := readCacheUSN(d.cacheDir, d.isIconCacheFile, saved)
+	switch {
+	case errors.Is(err, errUSNReset):
+		d.watchLog_("WARN", fmt.Sprintf("USN journal: cannot catch up on changes while the daemon was not running (%v).", err))
+		changes, cur, err = readCacheUSN(d.cacheDir, d.isIconCacheFile, nil)
+	case err == nil && saved == nil:
+		d.watchLog_("INFO", fmt.Sprintf("USN journal: first start on %s, nothing to catch up.", cur.Volume))
+	}
+	if err != nil {
+		d.usnFailed(err)
+		return
+	}
+	if len(changes) > 0 {
+		d.watchLog_("INFO", "USN journal: while the daemon was not running, "+summarizeUSN(changes))
+	}
+	d.mu.Lock()
+	d.usn = &cur
+	d.mu.Unlock()
+	d.saveUSN(true)
+}
+
+// usnPoll reports whether the journal shows cache changes since the last
+// poll. ok is false when the journal cannot answer and the caller must scan.
+func (d *daemon) usnPoll() (changed, ok bool) {
+	d.mu.Lock()
+	from := d.usn
+	d.mu.Unlock()
+	if from == nil {
+		return false, false
+	}
+	changes, cur, err := readCacheUSN(d.cacheDir, d.isIconCacheFile, from)
+	if err != nil {
+		d.usnFailed(err)
+		return false, false
+	}
+	d.mu.Lock()
+	d.usn = &cur
+	d.mu.Unlock()
+	d.saveUSN(false)
+	return len(changes) > 0, true
+}
+
+// usnFailed disables the journal for the rest of this run.
+func (d *daemon) usnFailed(err error) {
+	d.mu.Lock()
+	d.usn = nil
+	d.mu.Unlock()
+	d.watchLog_("WARN", fmt.Sprintf("USN journal unavailable, scanning the cache directory on every poll: %v", err))
+}
+
+// saveUSN writes the read position, at most every usnSaveEvery unless
+// forced. A position saved a little early only means the next catch-up
+// logs a few changes seen before the stop.
+func (d *daemon) saveUSN(force bool) {
+	d.mu.Lock()
+	cur := d.usn
+	due := force || d.clock.Since(d.usnSaved) >= usnSaveEvery
+	if cur != nil && due {
+		d.usnSaved = d.clock.Now()
+	}
+	d.mu.Unlock()
+	if cur == nil || !due {
+		return
+	}
+	raw, _ := json.MarshalIndent(cur, "", "  ")
+	if err := writeFileAtomic(filepath.Join(d.dataDir, usnFileName), raw); err != nil {
+		d.watchLog_("WARN", fmt.Sprintf("Cannot save USN journal position: %v", err))
+	}
+}
+
+// summarizeUSN folds the records per file: "3 cache files changed:
+// iconcache_256.db (overwrite+extend, last 2026-03-02 14:02:11), ...".
+func summarizeUSN(changes []usnChange) string {
+	type agg struct {
+		reasons uint32
+		last    time.Time
+	}
+	byName := map[string]*agg{}
+	for _, c := range changes {
+		a := byName[c.Name]
+		if a == nil {
+			a = &agg{}
+			byName[c.Name] = a
+		}
+		a.reasons |= c.Reasons
+		if c.At.After(a.last) {
+			a.last = c.At
+		}
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names[:min(len(names), usnMaxListed)] {
+		a := byName[name]
+		parts = append(parts, fmt.Sprintf("%s (%s, last %s)", name, usnReasons(a.reasons), a.last.Local().Format("2006-01-02 15:04:05")))
+	}
+	if len(names) > usnMaxListed {
+		parts = append(parts, fmt.Sprintf("%d more", len(names)-usnMaxListed))
+	}
+	return fmt.Sprintf("%d cache files changed: %s", len(names), strings.Join(parts, ", "))
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+// usn_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "errors"
+
+func readCacheUSN(cacheDir string, isCacheFile func(string) bool, from *usnCursor) ([]usnChange, usnCursor, error) {
+	return nil, usnCursor{}, errors.New("the USN journal is only available on NTFS under Windows")
+}
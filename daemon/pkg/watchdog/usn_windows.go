@@ -0,0 +1,189 @@
+// usn_windows.go
+// USN journal reads via DeviceIoControl on the cache directory's volume.
+// Elevated processes use FSCTL_READ_USN_JOURNAL on a volume handle opened
+// for reading; everyone else opens the volume without access rights and
+// uses FSCTL_READ_UNPRIVILEGED_USN_JOURNAL (Windows 10 1709+), which
+// returns the records of files the caller may see.
+
+package watchdog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	fsctlQueryUSNJournal            = 0x000900f4
+	fsctlReadUSNJournal             = 0x000900bb
+	fsctlReadUnprivilegedUSNJournal = 0x000903ab
+	usnReadBuffer                   = 64 << 10
+	filetimeUnixEpoch               = 116444736000000000 // 1601 to 1970 in 100 ns
+)
+
+// USN_JOURNAL_DATA_V0
+type usnJournalData struct {
+	JournalID       uint64
+	FirstUSN        int64
+	NextUSN         int64
+	LowestValidUSN  int64
+	MaxUSN          int64
+	MaximumSize     uint64
+	AllocationDelta uint64
+}
+
+// READ_USN_JOURNAL_DATA_V0 (returns USN_RECORD_V2 records)
+type readUSNJournalData struct {
+	StartUSN          int64
+	ReasonMask        uint32
+	ReturnOnlyOnClose uint32
+	Timeout           uint64
+	BytesToWaitFor    uint64
+	JournalID         uint64
+}
+
+func readCacheUSN(cacheDir string, isCacheFile func(string) bool, from *usnCursor) ([]usnChange, usnCursor, error) {
+	vol, err := journalVolume(cacheDir)
+	if err != nil {
+		return nil, usnCursor{}, err
+	}
+	h, read, err := openVolume(vol)
+	if err != nil {
+		return nil, usnCursor{}, fmt.Errorf("open volume %s: %w", vol, err)
+	}
+	defer syscall.CloseHandle(h)
+
+	var jd usnJournalData
+	var n uint32
+	if err := syscall.DeviceIoControl(h, fsctlQueryUSNJournal, nil, 0, (*byte)(unsafe.Pointer(&jd)), uint32(unsafe.Sizeof(jd)), &n, nil); err != nil {
+		return nil, usnCursor{}, fmt.Errorf("query journal on %s: %w", vol, err)
+	}
+	cur := usnCursor{Volume: vol, JournalID: jd.JournalID, Next: jd.NextUSN, Dir: fileReference(cacheDir)}
+	if from == nil {
+		return nil, cur, nil
+	}
+	if from.Volume != vol || from.JournalID != jd.JournalID || from.Next < jd.LowestValidUSN {
+		return nil, cur, errUSNReset
+	}
+
+	// Records of the directory itself (deleted, renamed) and of cache files
+	// in it, under its old or current file reference number.
+	dirs := map[uint64]bool{from.Dir: true, cur.Dir: true}
+	delete(dirs, 0)
+	var changes []usnChange
+	buf := make([]byte, usnReadBuffer)
+	in := readUSNJournalData{StartUSN: from.Next, ReasonMask: 0xFFFFFFFF, JournalID: jd.JournalID}
+	for in.StartUSN < jd.NextUSN {
+		if err := syscall.DeviceIoControl(h, read, (*byte)(unsafe.Pointer(&in)), uint32(unsafe.Sizeof(in)), &buf[0], uint32(len(buf)), &n, nil); err != nil {
+			return nil, cur, fmt.Errorf("read journal on %s: %w", vol, err)
+		}
+		if n < 8 {
+			break
+		}
+		next := int64(binary.LittleEndian.Uint64(buf))
+		for off := uint32(8); off+60 <= n; {
+			rec := buf[off:n]
+			size := binary.LittleEndian.Uint32(rec)
+			if size < 60 || size > uint32(len(rec)) {
+				break
+			}
+			if c, ok := parseUSNRecord(rec[:size], dirs, isCacheFile); ok {
+				changes = append(changes, c)
+			}
+			off += size
+		}
+		if next <= in.StartUSN {
+			break
+		}
+		in.StartUSN = next
+	}
+	cur.Next = max(in.StartUSN, from.Next)
+	return changes, cur, nil
+}
+
+// parseUSNRecord decodes a USN_RECORD_V2 and keeps it when it concerns the
+// cache directory or a cache file in it.
+func parseUSNRecord(rec []byte, dirs map[uint64]bool, isCacheFile func(string) bool) (usnChange, bool) {
+	le := binary.LittleEndian
+	if le.Uint16(rec[4:]) != 2 {
+		return usnChange{}, false
+	}
+	ref, parent := le.Uint64(rec[8:]), le.Uint64(rec[16:])
+	ts := int64(le.Uint64(rec[32:]))
+	reason := le.Uint32(rec[40:])
+	nameLen, nameOff := int(le.Uint16(rec[56:])), int(le.Uint16(rec[58:]))
+	if nameOff+nameLen > len(rec) {
+		return usnChange{}, false
+	}
+	u := make([]uint16, nameLen/2)
+	for i := range u {
+		u[i] = le.Uint16(rec[nameOff+2*i:])
+	}
+	name := syscall.UTF16ToString(u)
+	switch {
+	case dirs[ref]:
+	case dirs[parent] && (name == "" || isCacheFile(name)):
+		if name == "" {
+			name = "(unnamed)" // unprivileged reads may omit names
+		}
+	default:
+		return usnChange{}, false
+	}
+	return usnChange{Name: name, Reasons: reason, At: time.Unix(0, (ts-filetimeUnixEpoch)*100)}, true
+}
+
+// journalVolume names the volume whose journal records the cache: its drive
+// ("C:"), or \\?\Volume{GUID} when a profile container is mounted below the
+// drive (container.go).
+func journalVolume(cacheDir string) (string, error) {
+	vol := filepath.VolumeName(cacheDir)
+	if len(vol) != 2 || vol[1] != ':' {
+		return "", fmt.Errorf("%s is not on a local drive", cacheDir)
+	}
+	guid, _, err := resolveVolume(cacheDir)
+	if err != nil {
+		return vol, nil
+	}
+	if root, err := volumeGUIDName(vol + `\`); err == nil && !strings.EqualFold(root, guid) {
+		return strings.TrimSuffix(guid, `\`), nil
+	}
+	return vol, nil
+}
+
+// openVolume opens \\.\C: (or \\?\Volume{GUID}) for reading when elevated,
+// else without access rights for the unprivileged read, and returns the
+// read FSCTL to use.
+func openVolume(vol string) (syscall.Handle, uint32, error) {
+	if !strings.HasPrefix(vol, `\\?\`) {
+		vol = `\\.\` + vol
+	}
+	path, _ := syscall.UTF16PtrFromString(vol)
+	access, read := uint32(syscall.GENERIC_READ), uint32(fsctlReadUSNJournal)
+	if !isElevated() {
+		access, read = 0, fsctlReadUnprivilegedUSNJournal
+	}
+	h, err := syscall.CreateFile(path, access, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil, syscall.OPEN_EXISTING, 0, 0)
+	return h, read, err
+}
+
+// fileReference returns the 64-bit file reference number of dir, or 0.
+func fileReference(dir string) uint64 {
+	path, err := syscall.UTF16PtrFromString(extendedPath(dir))
+	if err != nil {
+		return 0
+	}
+	h, err := syscall.CreateFile(path, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0
+	}
+	defer syscall.CloseHandle(h)
+	var info syscall.ByHandleFileInformation
+	if syscall.GetFileInformationByHandle(h, &info) != nil {
+		return 0
+	}
+	return uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow)
+}
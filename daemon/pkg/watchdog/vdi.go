@@ -0,0 +1,161 @@
+// vdi.go
+// Non-persistent VDI (vdi.mode). Pooled desktops built from Citrix
+// Provisioning (PVS) standard images or Machine Creation Services (MCS) are
+// reset when the user logs off, and a rebuilt icon cache goes with them: the
+// rebuild only costs IOPS on shared storage. On such machines the daemon
+// reports only — health checks, logs, events and status.json carry on, and
+// each repair it would have run is logged instead, once per cooldown.
+//
+// FSLogix profile containers keep the profile in a VHDX that follows the
+// user, icon cache included, so repairs there do last. The exception is a
+// container whose redirections.xml excludes the Explorer cache folder: the
+// cache then stays on the local disk and is discarded with it.
+//
+// vdi.mode "auto" reports only when the cache is discarded that way, "on"
+// always and "off" never. `repair -force` still repairs.
+
+package watchdog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	vdiModeAuto = "auto"
+	vdiModeOn   = "on"
+	vdiModeOff  = "off"
+
+	vdiCitrixPVS = "Citrix PVS"
+	vdiCitrixMCS = "Citrix MCS"
+
+	// PVS target devices report the vDisk write cache type; 0 is private
+	// mode, a persistent read/write vDisk.
+	pvsAgentKey = `SYSTEM\CurrentControlSet\Services\bnistack\PvsAgent`
+	// MCS machines run the Machine Identity Service agent. Dedicated MCS
+	// desktops cannot be told apart from pooled ones here; set vdi.mode to
+	// "off" on those.
+	mcsAgentKey        = `SOFTWARE\Citrix\MachineIdentityServiceAgent`
+	fslogixProfilesKey = `SOFTWARE\FSLogix\Profiles`
+
+	// The cache folder relative to the profile root, as redirections.xml
+	// names folders.
+	cacheProfilePath = `AppData\Local\Microsoft\Windows\Explorer`
+)
+
+type vdiOptions struct {
+	Mode string `json:"mode"`
+}
+
+// vdiInfo describes how the machine is provisioned and whether the icon
+// cache outlives a logoff.
+type vdiInfo struct {
+	Platform      string `json:"platform,omitempty"` // vdiCitrixPVS, vdiCitrixMCS or empty
+	NonPersistent bool   `json:"nonPersistent"`
+	FSLogix       bool   `json:"fslogix"`                 // profile containers enabled
+	CacheExcluded bool   `json:"cacheExcluded,omitempty"` // redirections.xml keeps the cache local
+}
+
+// cacheDiscarded reports whether the cache is lost at logoff.
+func (v vdiInfo) cacheDiscarded() bool {
+	return v.NonPersistent && (!v.FSLogix || v.CacheExcluded)
+}
+
+func (v vdiInfo) String() string {
+	var parts []string
+	if v.Platform != "" {
+		s := v.Platform
+		if v.NonPersistent {
+			s += " (non-persistent)"
+		}
+		parts = append(parts, s)
+	}
+	if v.FSLogix {
+		s := "FSLogix profile container"
+		if v.CacheExcluded {
+			s += " (cache excluded by redirections.xml)"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// detectVDI inspects the machine and, for FSLogix, the redirections.xml
+// copied into the profile whose %LOCALAPPDATA% is localAppData.
+func detectVDI(localAppData string) vdiInfo {
+	var v vdiInfo
+	if data, typ, err := readRegistryValue(pvsAgentKey, "WriteCacheType"); err == nil {
+		n, _ := registryDWORD(data, typ)
+		v.Platform, v.NonPersistent = vdiCitrixPVS, n != 0
+	} else if registryKeyExists(mcsAgentKey) {
+		v.Platform, v.NonPersistent = vdiCitrixMCS, true
+	}
+	if data, typ, err := readRegistryValue(fslogixProfilesKey, "Enabled"); err == nil {
+		n, _ := registryDWORD(data, typ)
+		v.FSLogix = n != 0
+	}
+	if v.FSLogix && localAppData != "" {
+		if raw, err := os.ReadFile(filepath.Join(localAppData, "FSLogix", "redirections.xml")); err == nil {
+			v.CacheExcluded = redirectionsExclude(raw, cacheProfilePath)
+		}
+	}
+	return v
+}
+
+// redirectionsExclude reports whether an FSLogix redirections.xml keeps
+// path (relative to the profile root) out of the container: an Exclude
+// covers it and no narrower Include brings it back.
+func redirectionsExclude(raw []byte, path string) bool {
+	var r struct {
+		Excludes []string `xml:"Excludes>Exclude"`
+		Includes []string `xml:"Includes>Include"`
+	}
+	if xml.Unmarshal(raw, &r) != nil {
+		return false
+	}
+	norm := func(p string) string {
+		return strings.ToLower(strings.Trim(strings.ReplaceAll(strings.TrimSpace(p), "/", `\`), `\`))
+	}
+	covers := func(folder string) bool {
+		f, p := norm(folder), norm(path)
+		return f != "" && (p == f || strings.HasPrefix(p, f+`\`))
+	}
+	excluded := -1 // length of the narrowest covering Exclude
+	for _, e := range r.Excludes {
+		if covers(e) {
+			excluded = max(excluded, len(norm(e)))
+		}
+	}
+	for _, i := range r.Includes {
+		if covers(i) && len(norm(i)) > excluded {
+			return false
+		}
+	}
+	return excluded >= 0
+}
+
+// reportOnlyReason says why repairs are skipped, or "" when they run.
+func (d *daemon) reportOnlyReason() string {
+	switch d.cfg.VDI.Mode {
+	case vdiModeOn:
+		return "vdi.mode is on"
+	case vdiModeAuto:
+		if d.profile.VDI.cacheDiscarded() {
+			return fmt.Sprintf("non-persistent desktop, %s", d.profile.VDI)
+		}
+	}
+	return ""
+}
+
+// skipReportOnly logs a repair kept back by report-only mode, at most once
+// per cooldown. Caller holds d.mu.
+func (d *daemon) skipReportOnly(reason, why string) {
+	if !d.reportOnlyLogged.IsZero() && d.clock.Since(d.reportOnlyLogged) < d.cfg.Thresholds.Cooldown.D() {
+		return
+	}
+	d.reportOnlyLogged = d.clock.Now()
+	d.repairLog_("WARN", fmt.Sprintf("Report-only (%s): repair not run. Reason was: %s", why, reason))
+}
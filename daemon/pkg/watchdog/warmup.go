@@ -0,0 +1,106 @@
+// warmup.go
+// Cache warm-up after a repair (warmUp.enabled). A rebuilt cache is empty,
+// and Explorer fills it as icons come into view: for the first minutes the
+// taskbar, the desktop and the Start menu show white placeholders while each
+// icon is extracted from its file. The daemon can do that work up front.
+// Once a repair it ran has completed and Explorer is back, it asks the shell
+// for the small and the large icon of every shortcut, stage by stage: the
+// pinned taskbar items, then the desktop (shortcuts and the files on it),
+// then the Start menu (shortcuts.go). Each request extracts the icon into the
+// fresh cache (SHGetFileInfo, warmup_windows.go).
+//
+// At most warmUp.maxItems files are asked for, and the result goes to
+// Repair.log. Repairs handed to the broker or the repair task, multi-session
+// monitors (LocalSystem has no desktop in the user's session) and trace
+// simulation get no warm-up.
+
+package watchdog
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type warmUpOptions struct {
+	Enabled  bool     `json:"enabled"`
+	Delay    duration `json:"delay"`    // after the repair, for Explorer to settle
+	MaxItems int      `json:"maxItems"` // files asked for per warm-up
+}
+
+func defaultWarmUpOptions() warmUpOptions {
+	return warmUpOptions{Delay: duration(10 * time.Second), MaxItems: 500}
+}
+
+// warmUpStage is the files of one shortcut group.
+type warmUpStage struct {
+	Name  string
+	Paths []string
+}
+
+// warmUpStages lists the files whose icons a warm-up asks for, up to max.
+func warmUpStages(max int) []warmUpStage {
+	var stages []warmUpStage
+	for _, g := range shortcutGroups() {
+		s := warmUpStage{Name: g.Name}
+		for _, dir := range g.Dirs {
+			filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+				if err != nil || e.IsDir() {
+					return nil
+				}
+				if max == 0 {
+					return filepath.SkipAll
+				}
+				ext := strings.ToLower(filepath.Ext(path))
+				onDesktop := g.Name == "desktop" && filepath.Dir(path) == dir && !strings.EqualFold(e.Name(), "desktop.ini")
+				if ext == ".lnk" || ext == ".url" || onDesktop {
+					s.Paths = append(s.Paths, path)
+					max--
+				}
+				return nil
+			})
+		}
+		if len(s.Paths) > 0 {
+			stages = append(stages, s)
+		}
+	}
+	return stages
+}
+
+// startWarmUp warms the cache in the background after a completed repair.
+func (d *daemon) startWarmUp() {
+	if !d.cfg.WarmUp.Enabled || d.session != nil || d.sim != nil || !d.warmUpBusy.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer d.warmUpBusy.Store(false)
+		time.Sleep(d.cfg.WarmUp.Delay.D())
+		d.warmUp(warmIcons)
+	}()
+}
+
+// warmUp asks for the icons stage by stage; warm returns how many of paths
+// it loaded.
+func (d *daemon) warmUp(warm func(paths []string) int) {
+	if !d.explorerRunning() {
+		d.repairLog_("INFO", "Cache warm-up skipped: Explorer is not running.")
+		return
+	}
+	start := time.Now()
+	var loaded, asked int
+	var parts []string
+	for _, s := range warmUpStages(d.cfg.WarmUp.MaxItems) {
+		n := warm(s.Paths)
+		loaded += n
+		asked += len(s.Paths)
+		parts = append(parts, fmt.Sprintf("%s %d/%d", s.Name, n, len(s.Paths)))
+	}
+	if asked == 0 {
+		d.repairLog_("INFO", "Cache warm-up: no shortcuts found.")
+		return
+	}
+	d.repairLog_("INFO", fmt.Sprintf("Cache warm-up: %d of %d icons loaded (%s) in %.1fs.",
+		loaded, asked, strings.Join(parts, ", "), time.Since(start).Seconds()))
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+// warmup_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+func warmIcons(paths []string) int { return 0 }
@@ -0,0 +1,67 @@
+// warmup_windows.go
+// shell32!SHGetFileInfoW for the cache warm-up (warmup.go). Asking for a
+// file's index in the system image list makes the shell extract its icon,
+// which lands in the icon cache; SHGFI_SYSICONINDEX returns no icon handle
+// to free. The shell needs COM on the calling thread for shortcuts and
+// icon handlers, so the requests run on one locked thread in a
+// single-threaded apartment.
+
+package watchdog
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	shgfiSysIconIndex       = 0x4000
+	shgfiLargeIcon          = 0x0000
+	shgfiSmallIcon          = 0x0001
+	coinitApartmentThreaded = 0x2
+)
+
+var (
+	modOle32           = syscall.NewLazyDLL("ole32.dll")
+	procCoInitializeEx = modOle32.NewProc("CoInitializeEx")
+	procCoUninitialize = modOle32.NewProc("CoUninitialize")
+	procSHGetFileInfoW = modShell32.NewProc("SHGetFileInfoW")
+)
+
+// shFileInfo is SHFILEINFOW.
+type shFileInfo struct {
+	Icon        uintptr
+	IconIndex   int32
+	Attributes  uint32
+	DisplayName [260]uint16
+	TypeName    [80]uint16
+}
+
+// warmIcons asks for the small and large icon of each path and returns how
+// many paths the shell answered for.
+func warmIcons(paths []string) int {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	// S_FALSE (already initialized) must be balanced as well.
+	if hr, _, _ := procCoInitializeEx.Call(0, coinitApartmentThreaded); int32(hr) >= 0 {
+		defer procCoUninitialize.Call()
+	}
+	loaded := 0
+	for _, path := range paths {
+		p, err := syscall.UTF16PtrFromString(path)
+		if err != nil {
+			continue
+		}
+		ok := true
+		for _, size := range []uintptr{shgfiSmallIcon, shgfiLargeIcon} {
+			var info shFileInfo
+			r, _, _ := procSHGetFileInfoW.Call(uintptr(unsafe.Pointer(p)), 0,
+				uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info), shgfiSysIconIndex|size)
+			ok = ok && r != 0
+		}
+		if ok {
+			loaded++
+		}
+	}
+	return loaded
+}
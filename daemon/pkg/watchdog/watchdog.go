@@ -0,0 +1,178 @@
+// watchdog.go
+// Public API for programs that embed the daemon (see the package comment).
+
+// Package watchdog is the icon cache self-healing daemon behind
+// icon-cache-watchdog.exe, for Go programs such as system tweakers and kiosk
+// shells that would rather embed it than ship and register a second process:
+//
+//	cfg := watchdog.DefaultConfig()
+//	cfg.DataDir = `C:\ProgramData\MyKiosk\IconCache`
+//	w, err := watchdog.New(cfg)
+//	if err != nil {
+//		return err
+//	}
+//	go w.Run(ctx)
+//
+// An embedded watchdog runs Layers B–D, the control pipe and, if enabled, the
+// dashboard, like the executable does; Layer A stays with the scheduled task
+// that Register-Tasks.ps1 installs. Paths resolve as for the executable: the
+// project root is the folder above the program's bin\ folder, or the
+// program's own folder when it holds scripts\, and the repair script must be
+// inside it. Environment, command-line and policy overrides do not apply to
+// a Config passed to New, and selfCheck.restart is refused because it would
+// restart the host program. Remote commands, once listening, stay registered
+// until the process exits.
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Config is the daemon configuration, as documented for
+// icon-cache-watchdog.json. Start from DefaultConfig, PresetConfig,
+// LoadConfig or ParseConfig; New uses the values as given and does not apply
+// cfg.Preset again.
+type Config = config
+
+// ByteSize and Duration are the types of the size and duration settings,
+// e.g. cfg.Thresholds.PollEvery = watchdog.Duration(10 * time.Second).
+type (
+	ByteSize = byteSize
+	Duration = duration
+)
+
+// Health is the outcome of a health check, as printed by `healthcheck -json`.
+type Health = healthResult
+
+var (
+	// ErrRunning is returned by a second call to Run.
+	ErrRunning = errors.New("watchdog: Run called twice")
+
+	// ErrRepairDeferred means a hold (full screen, network shares) keeps
+	// the repair back; it runs when the hold ends.
+	ErrRepairDeferred = errors.New("watchdog: repair deferred")
+
+	// ErrRepairNotStarted means the cooldown was active or the repair was
+	// refused; the log says which.
+	ErrRepairNotStarted = errors.New("watchdog: repair not started")
+)
+
+// DefaultConfig returns the built-in defaults.
+func DefaultConfig() Config { return defaultConfig() }
+
+// PresetConfig returns the defaults tuned by a preset: "laptop", "desktop"
+// or "vdi".
+func PresetConfig(name string) (Config, error) { return presetConfig(name) }
+
+// LoadConfig reads a config file on top of the defaults, with the same
+// schema and signature checks as the executable.
+func LoadConfig(path string) (Config, error) {
+	cfg, _, err := loadConfig(path)
+	return cfg, err
+}
+
+// ParseConfig reads JSON in the config file format on top of the defaults.
+// A config with errors yields the defaults, without its preset, and the
+// errors.
+func ParseConfig(raw []byte) (Config, error) {
+	cfg, keys, err := parseConfig(raw)
+	if err != nil {
+		return cfg, err
+	}
+	sources := configSources{}
+	for _, k := range keys {
+		sources[k] = sourceFile
+	}
+	applyPreset(&cfg, sources)
+	return cfg, nil
+}
+
+// Watchdog is an embedded daemon.
+type Watchdog struct {
+	d *daemon
+
+	mu      sync.Mutex
+	running bool
+	ran     bool
+}
+
+// New checks cfg and prepares a watchdog for the current user's icon cache.
+// Nothing runs until Run is called.
+func New(cfg Config) (*Watchdog, error) {
+	if errs := cfg.checkRanges(); len(errs) > 0 {
+		return nil, fmt.Errorf("watchdog: config: %w", configErrors(errs))
+	}
+	if cfg.SelfCheck.Restart {
+		return nil, errors.New("watchdog: config: selfCheck.restart cannot be used when embedded")
+	}
+	d := newDaemonWith(cfg, projectRoot(), "")
+	d.cfgFileState = "none, supplied by the embedding program"
+	if err := d.validateRepairScript(); err != nil {
+		return nil, fmt.Errorf("watchdog: %w", err)
+	}
+	return &Watchdog{d: d}, nil
+}
+
+// Run runs the daemon until ctx is done and returns ctx.Err(). It may be
+// called once.
+func (w *Watchdog) Run(ctx context.Context) error {
+	w.mu.Lock()
+	if w.ran {
+		w.mu.Unlock()
+		return ErrRunning
+	}
+	w.ran, w.running = true, true
+	w.d.done = ctx.Done()
+	w.mu.Unlock()
+
+	w.d.run(nil)
+
+	w.mu.Lock()
+	w.running = false
+	w.mu.Unlock()
+	return ctx.Err()
+}
+
+// TriggerRepair requests a repair now. Like a remote command it is critical:
+// quiet hours and Focus Assist do not defer it, but the cooldown and the
+// full-screen hold apply. It returns nil when the repair started.
+func (w *Watchdog) TriggerRepair(reason string) error {
+	d := w.d
+	d.repairLog_("INFO", fmt.Sprintf("Repair requested by the embedding program: %s", reason))
+	started, deferred := d.attemptRepair(func() { d.triggerRepair(reason, priorityCritical) })
+	switch {
+	case started:
+		return nil
+	case deferred:
+		return ErrRepairDeferred
+	}
+	return ErrRepairNotStarted
+}
+
+// Health returns the latest health check. While Run is active that is the
+// daemon's own most recent check (Checked is zero before the first one);
+// otherwise a check runs now, without repairing.
+func (w *Watchdog) Health() Health {
+	d := w.d
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.lastResult == nil {
+			return Health{CacheDir: d.cacheDir}
+		}
+		return *d.lastResult
+	}
+	d.profile = detectCurrentProfile()
+	d.caps = d.probeCapabilities()
+	d.healthLog_("INFO", "--- Health check running (embedding program) ---")
+	res := d.evaluateHealth(false)
+	res.CacheDir = d.cacheDir
+	res.CacheSizeMB = d.getCacheSizeMB()
+	res.Profile = d.profile
+	return res
+}
@@ -0,0 +1,309 @@
+// weeklysummary.go
+// Weekly summary for the people the tool works for rather than the admins
+// who collect its reports: one plain-language headline ("2 repairs, peak
+// cache 41 MB, all heuristics healthy in 96% of checks") plus a few detail
+// lines, built from the last seven daily reports (reports.go keeps the
+// daily counters while the summary is enabled). Once a week, at the first
+// Layer B poll after weeklySummary.day/at, the summary is logged, written
+// to <reports dir>\<computer>-<user>-week-<first day>.txt and, as
+// configured, shown as a toast (Show-IconCacheSummary.ps1, next to the
+// repair script) and mailed through an SMTP relay. A summary missed while
+// the machine was off is delivered at the next poll. With the toast on, the
+// summary is held while the user must not be disturbed (quiet.go,
+// fullscreen.go). Single-user mode only.
+
+package watchdog
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const summaryToastScript = "Show-IconCacheSummary.ps1"
+
+type weeklySummaryOptions struct {
+	Enabled bool   `json:"enabled"`
+	Day     string `json:"day"`   // weekday of delivery, e.g. "monday"
+	At      string `json:"at"`    // local time of delivery, "HH:MM"
+	Toast   bool   `json:"toast"` // show the headline as a Windows notification
+	SMTP    string `json:"smtp"`  // "host:port" of a relay; empty: no email
+	From    string `json:"from"`
+	To      string `json:"to"` // comma-separated recipients
+}
+
+func defaultWeeklySummaryOptions() weeklySummaryOptions {
+	return weeklySummaryOptions{Day: "monday", At: "09:00", Toast: true}
+}
+
+// parseWeekday accepts English weekday names, case-insensitively.
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(strings.TrimSpace(s), d.String()) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// weeklySummary adds up the daily reports of seven days.
+type weeklySummary struct {
+	From            string  `json:"from"` // local dates, first and last day
+	To              string  `json:"to"`
+	Computer        string  `json:"computer"`
+	User            string  `json:"user"`
+	Days            int     `json:"days"` // days with a daily report
+	HealthChecks    int     `json:"healthChecks"`
+	HealthyChecks   int     `json:"healthyChecks"`
+	ScoreMin        int     `json:"scoreMin"`
+	SizeTriggers    int     `json:"sizeTriggers"`
+	HealthTriggers  int     `json:"healthTriggers"`
+	Repairs         int     `json:"repairs"`
+	CacheMBMax      float64 `json:"cacheMBMax"`
+	DiskSpaceAlerts int     `json:"diskSpaceAlerts"`
+	BrokenShortcuts int     `json:"brokenShortcuts"` // at the last health check of the week
+}
+
+// buildWeeklySummary reads the daily reports of the seven days before end
+// (a local date).
+func (d *daemon) buildWeeklySummary(end time.Time) weeklySummary {
+	first := end.AddDate(0, 0, -7)
+	s := weeklySummary{From: first.Format("2006-01-02"), To: end.AddDate(0, 0, -1).Format("2006-01-02")}
+	s.Computer, s.User = reportIdentity()
+	for day := first; day.Before(end); day = day.AddDate(0, 0, 1) {
+		r := d.loadReport(day.Format("2006-01-02"))
+		if r.Updated.IsZero() {
+			continue
+		}
+		s.Days++
+		if r.HealthChecks > 0 && (s.HealthChecks == 0 || r.ScoreMin < s.ScoreMin) {
+			s.ScoreMin = r.ScoreMin
+		}
+		s.HealthChecks += r.HealthChecks
+		s.HealthyChecks += r.HealthyChecks
+		s.SizeTriggers += r.SizeTriggers
+		s.HealthTriggers += r.HealthTriggers
+		s.Repairs += r.Repairs
+		s.CacheMBMax = max(s.CacheMBMax, r.CacheMBMax)
+		s.DiskSpaceAlerts += r.DiskSpaceAlerts
+		s.BrokenShortcuts = r.BrokenShortcuts
+	}
+	return s
+}
+
+// headline is the one sentence a non-technical user reads.
+func (s weeklySummary) headline() string {
+	if s.Days == 0 {
+		return "No activity was recorded this week."
+	}
+	parts := []string{plural(s.Repairs, "repair", "no repairs"), fmt.Sprintf("peak cache %.0f MB", s.CacheMBMax)}
+	if s.HealthChecks > 0 {
+		parts = append(parts, fmt.Sprintf("all heuristics healthy in %d%% of checks", s.HealthyChecks*100/s.HealthChecks))
+	}
+	return capitalize(strings.Join(parts, ", ")) + "."
+}
+
+func (s weeklySummary) subject() string {
+	return fmt.Sprintf("Icon cache weekly summary: %s / %s, %s to %s", s.Computer, s.User, s.From, s.To)
+}
+
+func (s weeklySummary) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\r\n\r\n%s\r\n\r\n", s.subject(), s.headline())
+	line := func(label, format string, args ...any) {
+		fmt.Fprintf(&b, "%-17s %s\r\n", label+":", fmt.Sprintf(format, args...))
+	}
+	if s.HealthChecks > 0 {
+		line("Health checks", "%d, lowest score %d/100", s.HealthChecks, s.ScoreMin)
+	} else {
+		line("Health checks", "none")
+	}
+	line("Repairs", "%d (%s, %s)", s.Repairs, plural(s.SizeTriggers, "size trigger", "no size triggers"), plural(s.HealthTriggers, "health trigger", "no health triggers"))
+	line("Peak cache size", "%.2f MB", s.CacheMBMax)
+	line("Low disk space", "%s", plural(s.DiskSpaceAlerts, "alert", "no alerts"))
+	line("Broken shortcuts", "%d", s.BrokenShortcuts)
+	line("Days recorded", "%d of 7", s.Days)
+	return b.String()
+}
+
+// plural formats n with noun, or none for zero.
+func plural(n int, noun, none string) string {
+	switch n {
+	case 0:
+		return none
+	case 1:
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// weeklySummaryDue returns the scheduled delivery time the summary is due
+// for, or the zero time. Called on every Layer B poll.
+func (d *daemon) weeklySummaryDue() time.Time {
+	o := d.cfg.WeeklySummary
+	if !o.Enabled || d.session != nil || d.sim != nil {
+		return time.Time{}
+	}
+	day, ok := parseWeekday(o.Day)
+	at, err := time.Parse("15:04", o.At)
+	if !ok || err != nil {
+		return time.Time{}
+	}
+	now := d.clock.Now()
+	slot := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	for slot.Weekday() != day || slot.After(now) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.summarized.IsZero() {
+		// First run: start with next week's summary, not a week of no data.
+		d.summarized = slot
+		d.saveState()
+	}
+	if !d.summarized.Before(slot) {
+		return time.Time{}
+	}
+	return slot
+}
+
+// checkWeeklySummary delivers a due summary.
+func (d *daemon) checkWeeklySummary() {
+	slot := d.weeklySummaryDue()
+	if slot.IsZero() {
+		return
+	}
+	if why := d.summaryHoldReason(); why != "" {
+		if why != d.summaryHeld {
+			d.watchLog_("INFO", fmt.Sprintf("Weekly summary held (%s).", why))
+			d.summaryHeld = why
+		}
+		return
+	}
+	d.summaryHeld = ""
+	d.updateReport(nil) // finalises yesterday's report after midnight
+	end := time.Date(slot.Year(), slot.Month(), slot.Day(), 0, 0, 0, 0, slot.Location())
+	s := d.buildWeeklySummary(end)
+	d.watchLog_("INFO", fmt.Sprintf("Weekly summary (%s to %s): %s", s.From, s.To, s.headline()))
+	d.deliverWeeklySummary(s)
+
+	d.mu.Lock()
+	d.summarized = slot
+	d.saveState()
+	d.mu.Unlock()
+}
+
+// summaryHoldReason returns why the toast may not be shown now, or "".
+func (d *daemon) summaryHoldReason() string {
+	if !d.cfg.WeeklySummary.Toast {
+		return ""
+	}
+	if r := d.quietReason(); r != "" {
+		return r
+	}
+	return d.fullScreenReason()
+}
+
+// deliverWeeklySummary writes the summary file, then shows and mails it as
+// configured. Failures are logged; only the file is retried, through the
+// outbox of a reports.dir share (reportoutbox.go).
+func (d *daemon) deliverWeeklySummary(s weeklySummary) {
+	o := d.cfg.WeeklySummary
+	dir := d.reportDir()
+	name := fmt.Sprintf("%s-%s-week-%s.txt", s.Computer, s.User, s.From)
+	var err error
+	if d.cfg.Reports.Dir != "" {
+		d.reportMu.Lock()
+		err = d.stageReportFile(name, []byte(s.text()))
+		d.reportMu.Unlock()
+	} else if err = os.MkdirAll(dir, 0755); err == nil {
+		err = writeFileAtomic(filepath.Join(dir, name), []byte(s.text()))
+	}
+	if err != nil {
+		d.watchLog_("WARN", fmt.Sprintf("Cannot write weekly summary to %s: %v", dir, err))
+	}
+
+	if o.Toast && runtime.GOOS == "windows" {
+		go d.showSummaryToast(s.headline())
+	}
+
+	if o.SMTP != "" {
+		if err := sendSummaryMail(o, s); err != nil {
+			d.watchLog_("WARN", fmt.Sprintf("Cannot mail the weekly summary via %s: %v", o.SMTP, err))
+		} else {
+			d.watchLog_("INFO", fmt.Sprintf("Weekly summary mailed to %s.", o.To))
+		}
+	}
+}
+
+// showSummaryToast shows the headline as a notification. It runs off the
+// poll loop, as the script may take a while.
+func (d *daemon) showSummaryToast(headline string) {
+	script := filepath.Join(filepath.Dir(d.repairScript), summaryToastScript)
+	// Run hidden with -ExecutionPolicy Bypass, so held to the repair
+	// script's rules (integrity.go).
+	if _, err := validateLaunch("summary script", script, d.rootDir); err != nil {
+		d.watchLog_("WARN", fmt.Sprintf("Not showing the weekly summary: %v", err))
+		return
+	}
+	cmd := d.powerShellCommand(script, "-Title", "Icon cache: weekly summary", "-Message", headline)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		d.watchLog_("WARN", fmt.Sprintf("Cannot show the weekly summary: %v %s", err, strings.TrimSpace(string(out))))
+	}
+}
+
+// sendSummaryMail sends the summary as plain text through an
+// unauthenticated relay (STARTTLS when the relay offers it).
+func sendSummaryMail(o weeklySummaryOptions, s weeklySummary) error {
+	to := splitList(o.To)
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", o.From, strings.Join(to, ", "), s.subject())
+	fmt.Fprintf(&b, "Date: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString(s.text())
+	return smtp.SendMail(o.SMTP, nil, o.From, to, []byte(b.String()))
+}
+
+// splitList splits a comma-separated setting, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// cmdSummary prints the summary of the seven days before today.
+func cmdSummary(d *daemon, args []string) int {
+	fs := newFlagSet("summary")
+	asJSON := fs.Bool("json", false, "print the summary as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	now := d.clock.Now()
+	s := d.buildWeeklySummary(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	if *asJSON {
+		printJSON("summary", struct {
+			Headline string `json:"headline"`
+			weeklySummary
+		}{s.headline(), s})
+		return 0
+	}
+	fmt.Print(strings.ReplaceAll(s.text(), "\r\n", "\n"))
+	if s.Days == 0 {
+		fmt.Println("\nNo daily reports found; enable reports or weeklySummary to collect them.")
+	}
+	return 0
+}
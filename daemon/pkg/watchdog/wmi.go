@@ -0,0 +1,54 @@
+// wmi.go
+// Health state as WMI instances of root\IconCacheWatchdog:IconCache_Health,
+// for SCCM/Intune compliance baselines and Get-CimInstance. Register-Tasks.ps1
+// registers the namespace and class; with wmi.enabled the daemon runs
+// Publish-IconCacheHealth.ps1 (next to the repair script) after every round
+// of health checks, and the script turns status.json into one instance per
+// user or session. The script must pass the repair script's launch checks.
+// A failing publish is logged once until it works again.
+
+package watchdog
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	wmiNamespace     = `root\IconCacheWatchdog`
+	wmiClass         = "IconCache_Health"
+	wmiPublishScript = "Publish-IconCacheHealth.ps1"
+)
+
+type wmiOptions struct {
+	Enabled bool `json:"enabled"`
+}
+
+// publishWMI runs the publish script against the current status.json.
+func (d *daemon) publishWMI() {
+	if !d.cfg.WMI.Enabled || d.sim != nil || runtime.GOOS != "windows" {
+		return
+	}
+	script := filepath.Join(filepath.Dir(d.repairScript), wmiPublishScript)
+	// Run hidden with -ExecutionPolicy Bypass, so held to the repair
+	// script's rules (integrity.go).
+	if _, err := validateLaunch("WMI publish script", script, d.rootDir); err != nil {
+		if !d.wmiFailing {
+			d.watchLog_("WARN", fmt.Sprintf("Not publishing health to WMI %s:%s: %v", wmiNamespace, wmiClass, err))
+			d.wmiFailing = true
+		}
+		return
+	}
+	cmd := d.powerShellCommand(script, "-StatusFile", d.statusFile())
+	out, err := cmd.CombinedOutput()
+	switch {
+	case err != nil && !d.wmiFailing:
+		d.watchLog_("WARN", fmt.Sprintf("Cannot publish health to WMI %s:%s: %v %s", wmiNamespace, wmiClass, err, strings.TrimSpace(string(out))))
+		d.wmiFailing = true
+	case err == nil && d.wmiFailing:
+		d.watchLog_("INFO", fmt.Sprintf("Health published to WMI %s:%s again.", wmiNamespace, wmiClass))
+		d.wmiFailing = false
+	}
+}
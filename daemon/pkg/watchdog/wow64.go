@@ -0,0 +1,40 @@
+// wow64.go
+// Native folders for the 386 build. Shops still on 32-bit LTSC images
+// deploy one binary everywhere, so it also runs on 64-bit Windows, under
+// WOW64, where a 32-bit process is redirected: %ProgramFiles% is
+// "Program Files (x86)" and System32 is SysWOW64. The daemon looks for 64-bit
+// software (PowerShell 7, shell replacements) and must start the repair
+// script in a 64-bit PowerShell, which sees the real System32 and registry,
+// so it resolves both to the native folders. On 32-bit Windows and for the
+// 64-bit builds these are the usual folders. The registry side is
+// registry_windows.go.
+
+package watchdog
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// nativeProgramFiles is %ProgramW6432%, which 64-bit Windows sets for both
+// 32-bit and 64-bit processes, or else %ProgramFiles%.
+func nativeProgramFiles() string {
+	if dir := os.Getenv("ProgramW6432"); dir != "" {
+		return dir
+	}
+	return os.Getenv("ProgramFiles")
+}
+
+// nativeSystemDir is %SystemRoot%\Sysnative, the alias of the real System32
+// that only WOW64 processes see, or else %SystemRoot%\System32. It is ""
+// when SystemRoot is not set.
+func nativeSystemDir() string {
+	root := os.Getenv("SystemRoot")
+	if root == "" {
+		return ""
+	}
+	if info, err := os.Stat(filepath.Join(root, "Sysnative")); err == nil && info.IsDir() {
+		return filepath.Join(root, "Sysnative")
+	}
+	return filepath.Join(root, "System32")
+}
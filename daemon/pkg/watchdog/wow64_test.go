@@ -0,0 +1,56 @@
+// wow64_test.go
+// Native folders for the 386 build under WOW64.
+
+package watchdog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWow64Paths(t *testing.T) {
+	// Under WOW64, %ProgramFiles% is the x86 folder and System32 is SysWOW64.
+	root := t.TempDir()
+	native, x86, windows := filepath.Join(root, "Program Files"), filepath.Join(root, "Program Files (x86)"), filepath.Join(root, "Windows")
+	t.Setenv("ProgramFiles", x86)
+	t.Setenv("ProgramW6432", native)
+	t.Setenv("SystemRoot", windows)
+	mkdir := func(dir string) {
+		t.Helper()
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkdir(filepath.Join(x86, "PowerShell", "7"))
+	if err := os.WriteFile(filepath.Join(x86, "PowerShell", "7", "pwsh.exe"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The x86 PowerShell 7 is not the native one, and System32 is used
+	// until Sysnative shows up.
+	if got, want := findPowerShell(""), filepath.Join(windows, "System32", "WindowsPowerShell", "v1.0", "powershell.exe"); got != want {
+		t.Fatalf("findPowerShell = %s, want %s", got, want)
+	}
+	mkdir(filepath.Join(windows, "Sysnative"))
+	if got, want := findPowerShell(""), filepath.Join(windows, "Sysnative", "WindowsPowerShell", "v1.0", "powershell.exe"); got != want {
+		t.Fatalf("findPowerShell under WOW64 = %s, want %s", got, want)
+	}
+	mkdir(filepath.Join(native, "PowerShell", "7"))
+	if err := os.WriteFile(filepath.Join(native, "PowerShell", "7", "pwsh.exe"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := findPowerShell(""), filepath.Join(native, "PowerShell", "7", "pwsh.exe"); got != want {
+		t.Fatalf("findPowerShell with PowerShell 7 = %s, want %s", got, want)
+	}
+
+	// Shell replacements are found in the native Program Files only.
+	mkdir(filepath.Join(x86, "Open-Shell"))
+	if mods := detectShellMods(filepath.Join(root, "LocalAppData")); len(mods) != 0 {
+		t.Fatalf("mods in the x86 folder = %+v", mods)
+	}
+	mkdir(filepath.Join(native, "Open-Shell"))
+	if mods := detectShellMods(filepath.Join(root, "LocalAppData")); len(mods) != 1 || mods[0].Dir != filepath.Join(native, "Open-Shell") {
+		t.Fatalf("mods = %+v", mods)
+	}
+}
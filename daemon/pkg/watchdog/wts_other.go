@@ -0,0 +1,12 @@
+//go:build !windows
+
+// wts_other.go
+// Stub for non-Windows platforms (allows development/testing on Linux/macOS).
+
+package watchdog
+
+import "errors"
+
+func enumerateSessions() ([]sessionInfo, error) {
+	return nil, errors.New("session enumeration is only supported on Windows")
+}
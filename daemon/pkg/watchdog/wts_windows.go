@@ -0,0 +1,85 @@
+// wts_windows.go
+// Session enumeration on RDS / AVD session hosts via wtsapi32.
+
+package watchdog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	wtsActive     = 0
+	wtsUserName   = 5
+	wtsDomainName = 7
+)
+
+var (
+	modWtsapi32                     = syscall.NewLazyDLL("wtsapi32.dll")
+	procWTSEnumerateSessionsW       = modWtsapi32.NewProc("WTSEnumerateSessionsW")
+	procWTSQuerySessionInformationW = modWtsapi32.NewProc("WTSQuerySessionInformationW")
+	procWTSQueryUserToken           = modWtsapi32.NewProc("WTSQueryUserToken")
+	procWTSFreeMemory               = modWtsapi32.NewProc("WTSFreeMemory")
+)
+
+type wtsSessionInfo struct {
+	SessionID      uint32
+	WinStationName *uint16
+	State          uint32
+}
+
+// enumerateSessions returns the active interactive sessions on this host.
+// Session 0 (services) is never included.
+func enumerateSessions() ([]sessionInfo, error) {
+	var infos *wtsSessionInfo
+	var count uint32
+	r, _, err := procWTSEnumerateSessionsW.Call(0, 0, 1, uintptr(unsafe.Pointer(&infos)), uintptr(unsafe.Pointer(&count)))
+	if r == 0 {
+		return nil, err
+	}
+	defer procWTSFreeMemory.Call(uintptr(unsafe.Pointer(infos)))
+
+	var out []sessionInfo
+	for _, s := range unsafe.Slice(infos, count) {
+		if s.SessionID == 0 || s.State != wtsActive {
+			continue
+		}
+		user := wtsQueryString(s.SessionID, wtsUserName)
+		if user == "" {
+			continue // logon screen, no user yet
+		}
+		domain := wtsQueryString(s.SessionID, wtsDomainName)
+		out = append(out, sessionInfo{
+			ID:         s.SessionID,
+			User:       user,
+			Domain:     domain,
+			ProfileDir: sessionProfileDir(s.SessionID, domain, user),
+		})
+	}
+	return out, nil
+}
+
+func wtsQueryString(session uint32, class uint32) string {
+	var buf *uint16
+	var n uint32
+	r, _, _ := procWTSQuerySessionInformationW.Call(0, uintptr(session), uintptr(class), uintptr(unsafe.Pointer(&buf)), uintptr(unsafe.Pointer(&n)))
+	if r == 0 || buf == nil {
+		return ""
+	}
+	defer procWTSFreeMemory.Call(uintptr(unsafe.Pointer(buf)))
+	return syscall.UTF16ToString(unsafe.Slice(buf, n/2))
+}
+
+// sessionProfileDir resolves the user's profile through their token, which
+// requires running as LocalSystem. Otherwise it is looked up in the
+// ProfileList key, which also knows profiles moved to another drive.
+func sessionProfileDir(session uint32, domain, user string) string {
+	var tok syscall.Token
+	if r, _, _ := procWTSQueryUserToken.Call(uintptr(session), uintptr(unsafe.Pointer(&tok))); r != 0 {
+		defer tok.Close()
+		if dir, err := tok.GetUserProfileDirectory(); err == nil {
+			return dir
+		}
+	}
+	return profileDirForUser(domain, user)
+}
@@ -4,6 +4,12 @@
     Compiles icon-cache-watchdog.exe from Go source.
     Output: bin\icon-cache-watchdog.exe (GUI subsystem, no console window)
 
+.PARAMETER Arch
+    Target architecture: amd64 (x64), arm64 (Surface Pro X, Snapdragon
+    laptops) or 386 (32-bit LTSC images; also runs on 64-bit Windows under
+    WOW64). Default: the architecture of this machine's Windows, so an
+    ARM64 device gets a native binary instead of an emulated x64 one.
+
 .NOTES
     Naming Policy: naming-conventions-policy-v3.2.0 - Style C (Verb-Noun.ps1)
     Requires:      Go 1.20+ installed (https://go.dev/dl/)
@@ -11,7 +17,9 @@
 #>
 
 [CmdletBinding()]
-param()
+param(
+    [ValidateSet('auto', 'amd64', 'arm64', '386')][string]$Arch = 'auto'
+)
 
 Set-StrictMode -Off
 $ErrorActionPreference = 'Stop'
@@ -55,14 +63,25 @@ if (-not (Test-Path $BinDir)) {
     New-Item -Path $BinDir -ItemType Directory -Force | Out-Null
 }
 
+# Native architecture of Windows, not of this (possibly emulated) PowerShell:
+# the machine-wide PROCESSOR_ARCHITECTURE is not rewritten for emulation.
+if ($Arch -eq 'auto') {
+    $Arch = 'amd64'
+    $machineEnv = Get-ItemProperty 'HKLM:\SYSTEM\CurrentControlSet\Control\Session Manager\Environment' -ErrorAction SilentlyContinue
+    switch ($machineEnv.PROCESSOR_ARCHITECTURE) {
+        'ARM64' { $Arch = 'arm64' }
+        'x86'   { $Arch = '386' }
+    }
+}
+
 # Compile
-Write-Step "Compiling for Windows x64 (GUI subsystem)..." 'INFO'
+Write-Step "Compiling for Windows $Arch (GUI subsystem)..." 'INFO'
 Write-Step "Source: $DaemonDir" 'INFO'
 Write-Step "Output: $OutputExe" 'INFO'
 Write-Host ""
 
 $env:GOOS   = "windows"
-$env:GOARCH = "amd64"
+$env:GOARCH = $Arch
 $env:CGO_ENABLED = "0"
 
 Push-Location $DaemonDir
@@ -82,7 +101,8 @@ try {
 $size = [math]::Round((Get-Item $OutputExe).Length / 1MB, 1)
 Write-Host ""
 Write-Step "Build successful: icon-cache-watchdog.exe ($size MB)" 'OK'
-Write-Step "Binary type: PE32+ GUI subsystem (no console window, ever)" 'OK'
+$peType = if ($Arch -eq '386') { 'PE32' } else { 'PE32+' }
+Write-Step "Binary type: $peType $Arch GUI subsystem (no console window, ever)" 'OK'
 Write-Host ""
 Write-Host "Next step: run .\scripts\Register-Tasks.ps1 as Administrator" -ForegroundColor Gray
 Write-Host ""
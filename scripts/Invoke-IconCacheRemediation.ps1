@@ -0,0 +1,27 @@
+#Requires -Version 5.1
+<#
+.SYNOPSIS
+    Intune Remediations remediation script: repair the icon cache.
+
+.DESCRIPTION
+    Remediation half of the package described in Test-IconCacheCompliance.ps1.
+    Runs `icon-cache-watchdog.exe compliance remediate`, which repairs the
+    cache regardless of cooldown, waits for the repair to finish and prints
+    one line (Remediated: ... / RemediationFailed: ...).
+
+.NOTES
+    Naming Policy:  naming-conventions-policy-v3.2.0 - Style C (Verb-Noun.ps1)
+    Exit codes:     0 remediated, 1 failed
+#>
+
+# The binary is wherever Register-Tasks.ps1 pointed the Watchdog task.
+$task = Get-ScheduledTask -TaskPath '\IconCache\' -TaskName 'Watchdog' -ErrorAction SilentlyContinue
+if (-not $task -or -not (Test-Path $task.Actions[0].Execute)) {
+    Write-Output "RemediationFailed: icon-cache-watchdog is not installed (no \IconCache\Watchdog task)"
+    exit 1
+}
+$DaemonExe = $task.Actions[0].Execute
+
+$out = & $DaemonExe compliance remediate | Select-Object -Last 1
+Write-Output $out
+exit $LASTEXITCODE
@@ -0,0 +1,111 @@
+#Requires -Version 5.1
+<#
+.SYNOPSIS
+    Publishes the daemon's health state as WMI instances of
+    root\IconCacheWatchdog:IconCache_Health.
+
+.DESCRIPTION
+    Run by icon-cache-watchdog.exe after every health check when wmi.enabled
+    is set. Reads status.json and writes one instance per user (one per
+    session in multi-session mode), so SCCM/Intune compliance baselines and
+    Get-CimInstance can query the health state without parsing logs:
+
+        Get-CimInstance -Namespace root/IconCacheWatchdog -ClassName IconCache_Health
+
+    The namespace and class are created by Register-Tasks.ps1. Uses CIM
+    cmdlets only, so it also runs in ConstrainedLanguage mode.
+
+.PARAMETER StatusFile
+    status.json written by the daemon.
+
+.NOTES
+    Naming Policy:  naming-conventions-policy-v3.2.0 - Style C (Verb-Noun.ps1)
+    Exit codes:     0 published, 1 status.json unreadable, 2 WMI class missing
+#>
+
+[CmdletBinding()]
+param(
+    [Parameter(Mandatory)]
+    [string]$StatusFile
+)
+
+Set-StrictMode -Version Latest
+$ErrorActionPreference = 'Stop'
+
+$Namespace = 'root/IconCacheWatchdog'
+$ClassName = 'IconCache_Health'
+
+function ConvertTo-CimDate {
+    param($Value)
+    if (-not $Value) { return $null }
+    $date = [datetime]$Value
+    if ($date.Year -lt 1900) { return $null }   # Go zero time: never happened
+    return $date
+}
+
+function Publish-Instance {
+    param([hashtable]$Property)
+    foreach ($key in @($Property.Keys)) {
+        if ($null -eq $Property[$key]) { $Property.Remove($key) }
+    }
+    $filter = "User='" + ($Property.User -replace "'", "''" -replace '\\', '\\') + "'"
+    $existing = Get-CimInstance -Namespace $Namespace -ClassName $ClassName -Filter $filter -ErrorAction SilentlyContinue
+    if ($existing) {
+        $Property.Remove('User')
+        Set-CimInstance -InputObject $existing -Property $Property | Out-Null
+    } else {
+        New-CimInstance -Namespace $Namespace -ClassName $ClassName -Property $Property | Out-Null
+    }
+}
+
+try {
+    # status.json is UTF-8 without a BOM; Windows PowerShell would read it as ANSI.
+    $status = Get-Content -LiteralPath $StatusFile -Raw -Encoding UTF8 | ConvertFrom-Json
+} catch {
+    Write-Error "Cannot read ${StatusFile}: $_"
+    exit 1
+}
+
+if (-not (Get-CimClass -Namespace $Namespace -ClassName $ClassName -ErrorAction SilentlyContinue)) {
+    Write-Error "WMI class $Namespace`:$ClassName is not registered (run Register-Tasks.ps1)."
+    exit 2
+}
+
+$updated = ConvertTo-CimDate $status.updated
+$sessions = @()
+if ($status.PSObject.Properties['sessions']) { $sessions = @($status.sessions) }
+
+if ($sessions.Count -gt 0) {
+    foreach ($s in $sessions) {
+        Publish-Instance @{
+            User            = [string]$s.user
+            SessionId       = [uint32]$s.id
+            Healthy         = [bool]$s.healthy
+            HealthScore     = [uint32]$s.score
+            CacheSizeMB     = [double]$s.cacheSizeMB
+            ProfileType     = [string]$s.profileType
+            LastHealthCheck = ConvertTo-CimDate $s.lastCheck
+            LastRepair      = ConvertTo-CimDate $s.lastRepair
+            DeferredRepair  = ''
+            DaemonPid       = [uint32]$status.pid
+            Updated         = $updated
+        }
+    }
+} else {
+    $deferred = ''
+    if ($status.PSObject.Properties['deferredRepair']) { $deferred = [string]$status.deferredRepair }
+    Publish-Instance @{
+        User            = "$env:USERDOMAIN\$env:USERNAME"
+        SessionId       = [uint32](Get-Process -Id $PID).SessionId
+        Healthy         = [bool]$status.healthy
+        HealthScore     = [uint32]$status.healthScore
+        CacheSizeMB     = [double]$status.cacheSizeMB
+        ProfileType     = [string]$status.profile.type
+        LastHealthCheck = ConvertTo-CimDate $status.lastHealthCheck
+        LastRepair      = ConvertTo-CimDate $status.lastRepair
+        DeferredRepair  = $deferred
+        DaemonPid       = [uint32]$status.pid
+        Updated         = $updated
+    }
+}
+exit 0
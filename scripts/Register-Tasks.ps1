@@ -13,11 +13,24 @@
     WHAT THIS INSTALLS:
       Task: \IconCache\EventRepair
         Trigger: explorer.exe crash (1000), hang (1002), sleep resume (107)
-        Action:  Run Repair-IconCache.ps1 silently
+        Action:  icon-cache-watchdog.exe trigger -source=event (spools the event
+                 with its ID, provider and module for the daemon; without a
+                 running daemon it repairs itself with the same cooldown,
+                 health check and logs)
+
+      Task: \IconCache\ElevatedRepair
+        Trigger: None (started on demand by the daemon's elevation broker)
+        Action:  Run Repair-IconCache.ps1 elevated, reason read from handoff file
+
+      WMI:  root\IconCacheWatchdog:IconCache_Health
+        Health state per user, published by the daemon (wmi.enabled)
+
+      Event source: IconCacheWatchdog (Application log)
+        Daemon state changes for admin scripts (eventLog.enabled)
 
       Task: \IconCache\Watchdog
         Trigger: At logon (runs indefinitely)
-        Action:  icon-cache-watchdog.exe (GUI binary - no window)
+        Action:  icon-cache-watchdog.exe [--preset=NAME] (GUI binary - no window)
                  Handles Layer B (file size), C (logon check), D (periodic)
 
 .NOTES
@@ -25,10 +38,27 @@
     Must run as:   Administrator
     Requires:      bin\icon-cache-watchdog.exe (run Build-Daemon.ps1 first)
     Idempotent:    Yes - safe to re-run at any time
+    Silent:        No prompts; `icon-cache-watchdog.exe install /S` runs this
+                   script for package managers and MSI custom actions
+                   (--install-phase=register). Exit code 0 on success, 1 on
+                   any failure. Unregister-Tasks.ps1 undoes it.
+
+.PARAMETER User
+    DOMAIN\name the tasks run as. Defaults to the user running the script;
+    required when that is SYSTEM (MSI deferred custom actions).
+
+.PARAMETER Preset
+    Configuration preset for the machine class: laptop, desktop or vdi.
+    The daemon tasks start with --preset=NAME (`install -profile=NAME`);
+    settings in the config file still take precedence over the preset.
 #>
 
 [CmdletBinding()]
-param()
+param(
+    [string]$User,
+    [ValidateSet('laptop', 'desktop', 'vdi')]
+    [string]$Preset
+)
 
 Set-StrictMode -Off
 $ErrorActionPreference = 'Stop'
@@ -39,14 +69,21 @@ $ErrorActionPreference = 'Stop'
 $Version      = "2.0.0"
 $ScriptDir    = Split-Path -Parent $MyInvocation.MyCommand.Path
 $RootDir      = Split-Path -Parent $ScriptDir
-$LogDir       = Join-Path $RootDir "logs"
+$InstallUser  = if ($User) { $User } else { "$env:USERDOMAIN\$env:USERNAME" }
 $RepairScript = Join-Path $ScriptDir "Repair-IconCache.ps1"
 $DaemonExe    = Join-Path $RootDir "bin\icon-cache-watchdog.exe"
 $TaskFolder   = "\IconCache"
 $TaskFolderPS = "\IconCache\"
+$WmiNamespace = "root\IconCacheWatchdog"
+$WmiClass     = "IconCache_Health"
+$EventSource  = "IconCacheWatchdog"
+$PresetArg    = if ($Preset) { "--preset=$Preset " } else { "" }
 
-$pwsh7 = Join-Path $env:ProgramFiles "PowerShell\7\pwsh.exe"
-$pwshExe = if (Test-Path $pwsh7) { $pwsh7 } else { "powershell.exe" }
+# Full paths only: a bare name would be looked up on PATH by the elevated
+# task. The native Program Files, also from a 32-bit PowerShell.
+$programFiles = if ($env:ProgramW6432) { $env:ProgramW6432 } else { $env:ProgramFiles }
+$pwsh7 = Join-Path $programFiles "PowerShell\7\pwsh.exe"
+$pwshExe = if (Test-Path $pwsh7) { $pwsh7 } else { Join-Path $env:SystemRoot "System32\WindowsPowerShell\v1.0\powershell.exe" }
 
 # ---------------------------------------------------------------------------
 # HELPERS
@@ -68,6 +105,25 @@ function Assert-Admin {
         Write-Step "Must be run as Administrator." 'ERROR'
         exit 1
     }
+    # The tasks run as the installing user; under SYSTEM (e.g. an Intune
+    # device-context install) they would watch SYSTEM's icon cache.
+    if (-not $User -and $current.Identity.User.Value -eq 'S-1-5-18') {
+        Write-Step "Running as SYSTEM: pass -User DOMAIN\name for the user to watch." 'ERROR'
+        exit 1
+    }
+}
+
+function Register-TaskXml {
+    param([string]$Name, [string]$Xml, [string]$TempName)
+    $tempXml = Join-Path $env:TEMP $TempName
+    $Xml | Out-File -FilePath $tempXml -Encoding Unicode
+    $out = schtasks.exe /Create /XML $tempXml /TN "$TaskFolder\$Name" /F 2>&1
+    $code = $LASTEXITCODE
+    Remove-Item $tempXml -Force -ErrorAction SilentlyContinue
+    if ($code -ne 0) {
+        Write-Step "schtasks /Create $TaskFolder\$Name failed ($code): $out" 'ERROR'
+        exit 1
+    }
 }
 
 function Remove-ExistingTask {
@@ -103,6 +159,21 @@ if (-not (Test-Path $DaemonExe)) {
 
 Write-Step "All required files found." 'OK'
 
+# The daemon resolves dataDir from the config file and policy; ask it rather
+# than assume the default. (With "localappdata" this is the installing
+# account's folder.)
+$DataDir = $null
+try {
+    $DataDir = ((& $DaemonExe status -json) -join "`n" | ConvertFrom-Json).dataDir
+} catch { }
+if (-not $DataDir) {
+    $DataDir = Join-Path $env:ProgramData "IconCacheWatchdog"
+    Write-Step "Could not ask the daemon for its data directory; using $DataDir" 'WARN'
+}
+$LogDir       = Join-Path $DataDir "logs"
+$BrokerDir    = Join-Path $DataDir "broker"
+$HandoffFile  = Join-Path $BrokerDir "handoff.json"
+
 if (-not (Test-Path $LogDir)) {
     New-Item -Path $LogDir -ItemType Directory -Force | Out-Null
 }
@@ -128,19 +199,35 @@ $taskXml = @"
       <Enabled>true</Enabled>
       <Subscription><![CDATA[<QueryList><Query Id="0" Path="Application"><Select Path="Application">*[System[Provider[@Name='Application Error'] and EventID=1000]] and *[EventData[Data[@Name='param1'] and (Data='explorer.exe')]]</Select></Query></QueryList>]]></Subscription>
       <Delay>PT60S</Delay>
+      <ValueQueries>
+        <Value name="eventId">Event/System/EventID</Value>
+        <Value name="provider">Event/System/Provider/@Name</Value>
+        <Value name="module">Event/EventData/Data[@Name='param4']</Value>
+      </ValueQueries>
     </EventTrigger>
     <EventTrigger>
       <Enabled>true</Enabled>
       <Subscription><![CDATA[<QueryList><Query Id="0" Path="Application"><Select Path="Application">*[System[Provider[@Name='Application Hang'] and EventID=1002]] and *[EventData[Data[@Name='param1'] and (Data='explorer.exe')]]</Select></Query></QueryList>]]></Subscription>
       <Delay>PT90S</Delay>
+      <ValueQueries>
+        <Value name="eventId">Event/System/EventID</Value>
+        <Value name="provider">Event/System/Provider/@Name</Value>
+        <Value name="module">Event/EventData/Data[@Name='param4']</Value>
+      </ValueQueries>
     </EventTrigger>
     <EventTrigger>
       <Enabled>true</Enabled>
       <Subscription><![CDATA[<QueryList><Query Id="0" Path="System"><Select Path="System">*[System[Provider[@Name='Microsoft-Windows-Kernel-Power'] and EventID=107]]</Select></Query></QueryList>]]></Subscription>
+      <ValueQueries>
+        <Value name="eventId">Event/System/EventID</Value>
+        <Value name="provider">Event/System/Provider/@Name</Value>
+        <Value name="module">Event/EventData/Data[@Name='param4']</Value>
+      </ValueQueries>
     </EventTrigger>
   </Triggers>
   <Principals>
     <Principal id="Author">
+      <UserId>$InstallUser</UserId>
       <LogonType>InteractiveToken</LogonType>
       <RunLevel>LeastPrivilege</RunLevel>
     </Principal>
@@ -155,19 +242,128 @@ $taskXml = @"
   </Settings>
   <Actions>
     <Exec>
-      <Command>$pwshExe</Command>
-      <Arguments>-WindowStyle Hidden -NonInteractive -ExecutionPolicy Bypass -File "$RepairScript"</Arguments>
+      <Command>$DaemonExe</Command>
+      <Arguments>${PresetArg}trigger -source=event -reason=event "-evidence=eventId=`$(eventId)" "-evidence=provider=`$(provider)" "-evidence=module=`$(module)"</Arguments>
     </Exec>
   </Actions>
 </Task>
 "@
 
-$tempXml = Join-Path $env:TEMP "icon-cache-event-repair.xml"
-$taskXml | Out-File -FilePath $tempXml -Encoding Unicode
-schtasks.exe /Create /XML $tempXml /TN "$TaskFolder\EventRepair" /F 2>&1 | Out-Null
-Remove-Item $tempXml -Force -ErrorAction SilentlyContinue
+Register-TaskXml -Name "EventRepair" -Xml $taskXml -TempName "icon-cache-event-repair.xml"
 Write-Step "Task registered: $TaskFolder\EventRepair" 'OK'
 
+# ---------------------------------------------------------------------------
+# ELEVATION BROKER - on-demand elevated repair (no UAC prompt at repair time)
+# ---------------------------------------------------------------------------
+Write-Host ""
+Write-Host "--- Registering Elevation Broker ---" -ForegroundColor White
+
+# Handoff directory: only SYSTEM, Administrators and the installing user.
+if (-not (Test-Path $BrokerDir)) {
+    New-Item -Path $BrokerDir -ItemType Directory -Force | Out-Null
+}
+icacls.exe $BrokerDir /inheritance:r /grant:r "*S-1-5-18:(OI)(CI)F" "*S-1-5-32-544:(OI)(CI)F" "${InstallUser}:(OI)(CI)M" 2>&1 | Out-Null
+Write-Step "Handoff directory secured: $BrokerDir" 'OK'
+
+Remove-ExistingTask "ElevatedRepair"
+
+$brokerXml = @"
+<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.4" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Description>icon-cache-self-healing v$Version - Elevated repair, started on demand by the watchdog daemon when it lacks the rights to repair unelevated.</Description>
+    <URI>\IconCache\ElevatedRepair</URI>
+  </RegistrationInfo>
+  <Triggers />
+  <Principals>
+    <Principal id="Author">
+      <UserId>$InstallUser</UserId>
+      <LogonType>InteractiveToken</LogonType>
+      <RunLevel>HighestAvailable</RunLevel>
+    </Principal>
+  </Principals>
+  <Settings>
+    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>
+    <AllowStartOnDemand>true</AllowStartOnDemand>
+    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>
+    <StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>
+    <Hidden>true</Hidden>
+    <ExecutionTimeLimit>PT5M</ExecutionTimeLimit>
+    <Priority>7</Priority>
+  </Settings>
+  <Actions>
+    <Exec>
+      <Command>$pwshExe</Command>
+      <Arguments>-NoProfile -WindowStyle Hidden -NonInteractive -ExecutionPolicy Bypass -File "$RepairScript" -DataDir "$DataDir" -HandoffFile "$HandoffFile"</Arguments>
+    </Exec>
+  </Actions>
+</Task>
+"@
+
+Register-TaskXml -Name "ElevatedRepair" -Xml $brokerXml -TempName "icon-cache-elevated-repair.xml"
+Write-Step "Task registered: $TaskFolder\ElevatedRepair (on demand, highest privileges)" 'OK'
+
+# ---------------------------------------------------------------------------
+# WMI CLASS - health state for SCCM/Intune baselines (daemon wmi.enabled)
+# ---------------------------------------------------------------------------
+Write-Host ""
+Write-Host "--- Registering WMI class $WmiNamespace`:$WmiClass ---" -ForegroundColor White
+
+if (-not (Get-CimInstance -Namespace root -ClassName __Namespace -Filter "Name='IconCacheWatchdog'" -ErrorAction SilentlyContinue)) {
+    New-CimInstance -Namespace root -ClassName __Namespace -Property @{ Name = 'IconCacheWatchdog' } | Out-Null
+}
+
+# Static class; instances are written by Publish-IconCacheHealth.ps1.
+$wmiClassDef = New-Object System.Management.ManagementClass($WmiNamespace, [string]::Empty, $null)
+$wmiClassDef['__CLASS'] = $WmiClass
+$wmiClassDef.Qualifiers.Add('Static', $true)
+$wmiClassDef.Qualifiers.Add('Description', 'icon-cache-self-healing health state, one instance per user')
+$wmiProps = [ordered]@{
+    User            = [System.Management.CimType]::String
+    SessionId       = [System.Management.CimType]::UInt32
+    Healthy         = [System.Management.CimType]::Boolean
+    HealthScore     = [System.Management.CimType]::UInt32
+    CacheSizeMB     = [System.Management.CimType]::Real64
+    ProfileType     = [System.Management.CimType]::String
+    LastHealthCheck = [System.Management.CimType]::DateTime
+    LastRepair      = [System.Management.CimType]::DateTime
+    DeferredRepair  = [System.Management.CimType]::String
+    DaemonPid       = [System.Management.CimType]::UInt32
+    Updated         = [System.Management.CimType]::DateTime
+}
+foreach ($name in $wmiProps.Keys) {
+    $wmiClassDef.Properties.Add($name, $wmiProps[$name], $false)
+}
+$wmiClassDef.Properties['User'].Qualifiers.Add('Key', $true)
+$wmiClassDef.Put() | Out-Null
+
+# Users publish their own instance: Enable Account + Partial Write for
+# Authenticated Users, added once.
+$wmiSecurity = Get-WmiObject -Namespace $WmiNamespace -Class __SystemSecurity
+$wmiSD = $wmiSecurity.GetSecurityDescriptor().Descriptor
+if (-not ($wmiSD.DACL | Where-Object { $_.Trustee.SidString -eq 'S-1-5-11' })) {
+    $trustee = ([wmiclass]'Win32_Trustee').CreateInstance()
+    $trustee.SidString = 'S-1-5-11'
+    $ace = ([wmiclass]'Win32_ACE').CreateInstance()
+    $ace.AccessMask = 0x1 -bor 0x4   # WBEM_ENABLE | WBEM_PARTIAL_WRITE_REP
+    $ace.AceFlags = 0x2              # CONTAINER_INHERIT
+    $ace.AceType = 0                 # ACCESS_ALLOWED
+    $ace.Trustee = $trustee
+    $wmiSD.DACL += $ace.PSObject.ImmutableBaseObject
+    $wmiSecurity.SetSecurityDescriptor($wmiSD) | Out-Null
+}
+Write-Step "WMI class registered: $WmiNamespace`:$WmiClass (set wmi.enabled in the config to publish)" 'OK'
+
+# ---------------------------------------------------------------------------
+# EVENT SOURCE - daemon state changes in the Application log (eventLog.enabled)
+# ---------------------------------------------------------------------------
+# New-EventLog uses the .NET message file, which renders the daemon's text
+# for every event ID. Unregistered sources still log, without that text.
+if (-not [System.Diagnostics.EventLog]::SourceExists($EventSource)) {
+    New-EventLog -LogName Application -Source $EventSource
+}
+Write-Step "Event source registered: Application/$EventSource (set eventLog.enabled in the config to write events)" 'OK'
+
 # ---------------------------------------------------------------------------
 # SOLUTION B+C+D - Go Daemon (GUI binary, no window ever)
 # ---------------------------------------------------------------------------
@@ -190,6 +386,7 @@ $watchXml = @"
   </Triggers>
   <Principals>
     <Principal id="Author">
+      <UserId>$InstallUser</UserId>
       <LogonType>InteractiveToken</LogonType>
       <RunLevel>LeastPrivilege</RunLevel>
     </Principal>
@@ -208,17 +405,17 @@ $watchXml = @"
   </Settings>
   <Actions>
     <Exec>
-      <Command>$DaemonExe</Command>
+      <Command>$DaemonExe</Command>$(if ($Preset) { "`n      <Arguments>--preset=$Preset</Arguments>" })
     </Exec>
   </Actions>
 </Task>
 "@
 
-$tempXml = Join-Path $env:TEMP "icon-cache-watchdog.xml"
-$watchXml | Out-File -FilePath $tempXml -Encoding Unicode
-schtasks.exe /Create /XML $tempXml /TN "$TaskFolder\Watchdog" /F 2>&1 | Out-Null
-Remove-Item $tempXml -Force -ErrorAction SilentlyContinue
+Register-TaskXml -Name "Watchdog" -Xml $watchXml -TempName "icon-cache-watchdog.xml"
 Write-Step "Task registered: $TaskFolder\Watchdog (GUI daemon - no window)" 'OK'
+if ($Preset) {
+    Write-Step "Configuration preset: $Preset" 'OK'
+}
 
 try {
     Start-ScheduledTask -TaskPath $TaskFolderPS -TaskName "Watchdog"
@@ -250,7 +447,7 @@ Write-Host "Log files: $LogDir" -ForegroundColor Gray
 Write-Host ""
 Write-Host "Next steps:" -ForegroundColor White
 Write-Host "  Smoke test:   .\scripts\Repair-IconCache.ps1 -Force" -ForegroundColor Gray
-Write-Host "  Repair log:   Get-Content .\logs\IconCacheRepair.log" -ForegroundColor Gray
-Write-Host "  Watchdog log: Get-Content .\logs\Watchdog.log -Tail 20" -ForegroundColor Gray
-Write-Host "  Health log:   Get-Content .\logs\IconCacheHealth.log -Tail 20" -ForegroundColor Gray
+Write-Host "  Repair log:   Get-Content -Encoding UTF8 `"$LogDir\IconCacheRepair.log`"" -ForegroundColor Gray
+Write-Host "  Watchdog log: Get-Content -Encoding UTF8 `"$LogDir\Watchdog.log`" -Tail 20" -ForegroundColor Gray
+Write-Host "  Health log:   Get-Content -Encoding UTF8 `"$LogDir\IconCacheHealth.log`" -Tail 20" -ForegroundColor Gray
 Write-Host ""
@@ -8,7 +8,8 @@
     This script is the shared core logic for the icon-cache-self-healing toolkit.
     It can be called:
       - Directly (manual / smoke test):  .\Repair-IconCache.ps1 -Force
-      - By Task Scheduler (Solution A):  triggered on Event ID 1000/1002
+      - By icon-cache-watchdog.exe: the daemon (Layers B-D) and its `repair`
+        command, which the Solution A task runs on Event ID 1000/1002/107
       - By Watch-IconCache.ps1 (Solution B): triggered on file-size threshold
 
     The script is idempotent and safe to run multiple times. A lock file prevents
@@ -23,13 +24,113 @@
     Use this for manual testing or guaranteed cleanup.
 
 .PARAMETER IncludeThumbcache
-    Also delete thumbcache_*.db files (thumbnail database). Thumbnails will
-    take longer to rebuild. Off by default.
+    Also delete the thumbnail cache files (thumbcache_*.db by default).
+    Thumbnails will take longer to rebuild. Off by default.
+
+.PARAMETER DeepClean
+    Full rebuild for the daemon's scheduled deep clean: implies -Force and
+    -IncludeThumbcache, also clears the tray icon streams (TrayNotify
+    IconStreams / PastIconsStream) and runs ie4uinit.exe -ClearIconCache.
+
+.PARAMETER DataDir
+    Directory for logs and the lock file (the daemon passes its resolved data
+    directory, by default %ProgramData%\IconCacheWatchdog). When omitted the
+    legacy layout is used: ..\logs for logs and the script folder for the lock.
+
+.PARAMETER Reason
+    Free-text trigger reason, recorded in the repair log.
+
+.PARAMETER HandoffFile
+    Handoff file written by the daemon's elevation broker. Used by the
+    \IconCache\ElevatedRepair task: the reason is read from the file, the
+    file is deleted, and requests older than 5 minutes are ignored.
+    Implies -Force.
+
+.PARAMETER CachePath
+    Explorer cache folder to repair. Defaults to the current user's
+    %LOCALAPPDATA%\Microsoft\Windows\Explorer. Passed by the daemon in
+    multi-session (RDS / AVD) mode, where it repairs other users' caches.
+
+.PARAMETER SessionId
+    Only stop explorer.exe in this session (multi-session mode). When the
+    session is not our own, Winlogon normally restarts the shell
+    (AutoRestartShell); if it has not within 10 seconds, Explorer is started
+    in that session with the user's token through -ShellLauncher.
+
+.PARAMETER BackupDir
+    Copy the files about to be deleted to <BackupDir>\<yyyyMMdd-HHmmss>\
+    (with a manifest.json) first. Passed by the daemon with backup.enabled;
+    the elevated broker route uses <DataDir>\backups\<user>.
+
+.PARAMETER BackupKeep
+    Number of backups kept in BackupDir, newest first. Default: 3.
+
+.PARAMETER ShadowCopy
+    Read the backup from a Volume Shadow Copy snapshot of the cache volume,
+    so files still held open by another process are copied consistently.
+    Needs elevation; without it the files are copied directly.
+
+.PARAMETER RestoreFrom
+    Restore the backup in this folder instead of repairing (the daemon's
+    `rollback` command): Explorer is stopped, the cache files are replaced
+    with the ones listed in its manifest.json and Explorer is started again.
+    No backup is taken first.
+
+.PARAMETER ShellLauncher
+    The daemon binary, passed by the daemon. When this script runs elevated
+    or for another session, Explorer is started with `<ShellLauncher>
+    start-shell`, which uses the user's own token in the user's session
+    instead of this process's context. Defaults to
+    ..\bin\icon-cache-watchdog.exe; without it Explorer is started directly.
+
+.PARAMETER RestartExplorer
+    Only restart Explorer, leaving the cache files alone (the daemon's
+    response to a hung shell with a healthy cache, responsiveness.
+    restartExplorer). Implies -Force.
+
+.PARAMETER ModCaches
+    Icon caches of shell replacements (Open-Shell's DataCache.db and the
+    like), '|'-separated, deleted with the Explorer cache. Passed by the
+    daemon with shellMods.watch.
+
+.PARAMETER ModHosts
+    Image names of shell replacement host processes (e.g. StartMenu.exe),
+    '|'-separated. Running ones are stopped with Explorer and started again
+    from the same path after it, through -ShellLauncher when elevated or for
+    another session. Passed by the daemon with shellMods.restartHosts.
+
+.PARAMETER IconPatterns
+    Names of the icon cache files, '|'-separated: wildcards, or regular
+    expressions matching the whole name after "re:". Default:
+    iconcache_*.db. Passed by the daemon when cacheFiles.icon is changed.
+
+.PARAMETER ThumbPatterns
+    Names of the thumbnail cache files, in the same form. Default:
+    thumbcache_*.db. Passed by the daemon when cacheFiles.thumbnail is
+    changed.
+
+.PARAMETER Tiers
+    Delete only these icon cache tiers (e.g. iconcache_48.db), '|'-separated,
+    and the index iconcache_idx.db instead of every icon cache file. Passed
+    by the daemon with tierRepair.enabled when its parser finds only some
+    tiers corrupt. Names must match -IconPatterns.
+
+.PARAMETER StopMethod
+    How Explorer is stopped. 'forced' (default) kills it. 'graceful' tries
+    Restart Manager first, then the shell's own exit ("Exit Explorer" on
+    the taskbar), then kills it, each until -StopTimeouts runs out. Passed
+    by the daemon with explorerStop.method. The restart of a hung Explorer
+    (-RestartExplorer) always kills it.
+
+.PARAMETER StopTimeouts
+    Seconds to wait for Explorer to exit after each way of stopping it:
+    Restart Manager, the shell's own exit and the kill, '|'-separated.
+    Default: 10|10|5. Passed by the daemon with explorerStop.
 
 .NOTES
     Naming Policy:  naming-conventions-policy-v3.2.0 — Style C (Verb-Noun.ps1)
-    Log output:     ..\logs\IconCacheRepair.log (relative to script location)
-    Lock file:      ..\scripts\repair.lock
+    Log output:     <DataDir>\logs\IconCacheRepair.log (default ..\logs\IconCacheRepair.log)
+    Lock file:      <DataDir>\repair.lock (default ..\scripts\repair.lock)
     Requires:       No elevation needed for cache deletion (user-scope files).
                     Elevation IS required for Task Scheduler registration
                     (handled by Register-Tasks.ps1, not this script).
@@ -47,7 +148,26 @@
 param(
     [int]   $SizeLimitMB      = 256,
     [switch]$Force,
-    [switch]$IncludeThumbcache
+    [switch]$IncludeThumbcache,
+    [switch]$DeepClean,
+    [string]$DataDir,
+    [string]$Reason,
+    [string]$HandoffFile,
+    [string]$CachePath,
+    [int]   $SessionId = -1,
+    [string]$BackupDir,
+    [int]   $BackupKeep = 3,
+    [switch]$ShadowCopy,
+    [string]$RestoreFrom,
+    [string]$ShellLauncher,
+    [switch]$RestartExplorer,
+    [string]$ModCaches,
+    [string]$ModHosts,
+    [string]$IconPatterns  = 'iconcache_*.db',
+    [string]$ThumbPatterns = 'thumbcache_*.db',
+    [string]$Tiers,
+    [ValidateSet('graceful','forced')][string]$StopMethod = 'forced',
+    [string]$StopTimeouts = '10|10|5'
 )
 
 Set-StrictMode -Version Latest
@@ -58,16 +178,40 @@ $ErrorActionPreference = 'Stop'
 # ---------------------------------------------------------------------------
 $ScriptDir   = Split-Path -Parent $MyInvocation.MyCommand.Path
 $RootDir     = Split-Path -Parent $ScriptDir
-$LogDir      = Join-Path $RootDir "logs"
+if ($DataDir) {
+    $LogDir   = Join-Path $DataDir "logs"
+    $LockFile = Join-Path $DataDir "repair.lock"
+} else {
+    $LogDir   = Join-Path $RootDir "logs"
+    $LockFile = Join-Path $ScriptDir "repair.lock"
+}
 $LogPath     = Join-Path $LogDir  "IconCacheRepair.log"
-$LockFile    = Join-Path $ScriptDir "repair.lock"
-$CachePath   = Join-Path $env:LOCALAPPDATA "Microsoft\Windows\Explorer"
+if (-not $CachePath) {
+    $CachePath = Join-Path $env:LOCALAPPDATA "Microsoft\Windows\Explorer"
+}
+if ($SessionId -ge 0) {
+    # Per-session lock so the daemon's host-wide limit, not the lock,
+    # decides how many sessions are repaired in parallel.
+    $LockFile = $LockFile -replace '\.lock$', "-s$SessionId.lock"
+}
+$OwnSessionId = (Get-Process -Id $PID).SessionId
+$ForeignSession = ($SessionId -ge 0) -and ($SessionId -ne $OwnSessionId)
+$Elevated = ([Security.Principal.WindowsPrincipal][Security.Principal.WindowsIdentity]::GetCurrent()).IsInRole([Security.Principal.WindowsBuiltInRole]::Administrator)
+if (-not $ShellLauncher) {
+    $ShellLauncher = Join-Path $RootDir "bin\icon-cache-watchdog.exe"
+}
 $LockTimeoutMinutes = 10
+$IconPatternList  = @($IconPatterns  -split '\|' | Where-Object { $_ })
+$ThumbPatternList = @($ThumbPatterns -split '\|' | Where-Object { $_ })
+$TierList = @($Tiers -split '\|' | Where-Object { $_ })
+$HandoffMaxAgeMinutes = 5
+$StoppedHosts = @()
+$TrayNotifyKey = 'HKCU:\Software\Classes\Local Settings\Software\Microsoft\Windows\CurrentVersion\TrayNotify'
 
 # ---------------------------------------------------------------------------
 # INIT — ensure log directory exists
 # ---------------------------------------------------------------------------
-if (-not (Test-Path $LogDir)) {
+if (-not (Test-Path -LiteralPath $LogDir)) {
     New-Item -Path $LogDir -ItemType Directory -Force | Out-Null
 }
 
@@ -81,34 +225,111 @@ function Write-Log {
     )
     $timestamp = Get-Date -Format 'yyyy-MM-dd HH:mm:ss'
     $entry     = "[$timestamp][$Level] $Message"
-    Add-Content -Path $LogPath -Value $entry -Encoding UTF8
+    Add-Content -LiteralPath $LogPath -Value $entry -Encoding UTF8
     # Also write to host for manual runs (suppressed by Task Scheduler)
     Write-Verbose $entry
 }
 
+# Progress records on stdout, relayed by the daemon to control-pipe clients:
+#   ##progress <phase> [<done>/<total>]
+function Send-RepairProgress {
+    param([string]$Phase, [int]$Done = -1, [int]$Total = -1)
+    if ($Total -ge 0) {
+        Write-Host "##progress $Phase $Done/$Total"
+    } else {
+        Write-Host "##progress $Phase"
+    }
+}
+
+# ---------------------------------------------------------------------------
+# ELEVATION BROKER HANDOFF — consume the daemon's request exactly once
+# ---------------------------------------------------------------------------
+function Read-Handoff {
+    if (-not (Test-Path -LiteralPath $HandoffFile)) {
+        Write-Log "Handoff file not found: $HandoffFile. Elevated repair was not requested by the daemon." 'WARN'
+        return $false
+    }
+    try {
+        $handoff = Get-Content -LiteralPath $HandoffFile -Raw -Encoding UTF8 | ConvertFrom-Json
+    } catch {
+        Write-Log "Handoff file is unreadable: $($_.Exception.Message)" 'ERROR'
+        return $false
+    } finally {
+        Remove-Item -LiteralPath $HandoffFile -Force -ErrorAction SilentlyContinue
+    }
+    $age = (Get-Date).ToUniversalTime() - ([datetime]$handoff.requested).ToUniversalTime()
+    if ($age.TotalMinutes -gt $HandoffMaxAgeMinutes) {
+        Write-Log "Handoff request is stale ($([math]::Round($age.TotalMinutes,1)) min old). Ignoring." 'WARN'
+        return $false
+    }
+    # The reason is informational only; strip anything but printable text.
+    $script:Reason = ([string]$handoff.reason -replace '[^\x20-\x7E]', '')
+    if ($script:Reason.Length -gt 200) { $script:Reason = $script:Reason.Substring(0, 200) }
+    Write-Log "Elevated repair requested by daemon (pid $($handoff.pid), nonce $($handoff.nonce))." 'REPAIR'
+    if ($handoff.PSObject.Properties['deepClean'] -and $handoff.deepClean -eq $true) {
+        $script:DeepClean = $true
+    }
+    if ($handoff.PSObject.Properties['includeThumbcache'] -and $handoff.includeThumbcache -eq $true) {
+        $script:IncludeThumbcache = $true
+    }
+    if ($handoff.PSObject.Properties['backup'] -and $handoff.backup -eq $true -and $DataDir) {
+        # Fixed location: the handoff file never names a path for the elevated run.
+        $script:BackupDir = Join-Path $DataDir "backups\$env:USERNAME"
+        if ($handoff.PSObject.Properties['backupKeep']) {
+            $script:BackupKeep = [math]::Max(1, [math]::Min(50, [int]$handoff.backupKeep))
+        }
+        $script:ShadowCopy = ($handoff.PSObject.Properties['shadowCopy'] -and $handoff.shadowCopy -eq $true)
+    }
+    if ($handoff.PSObject.Properties['stopMethod'] -and @('graceful','forced') -contains $handoff.stopMethod) {
+        $script:StopMethod = [string]$handoff.stopMethod
+    }
+    if ($handoff.PSObject.Properties['stopTimeouts'] -and [string]$handoff.stopTimeouts -match '^\d{1,3}\|\d{1,3}\|\d{1,3}$') {
+        $script:StopTimeouts = [string]$handoff.stopTimeouts
+    }
+    return $true
+}
+
 # ---------------------------------------------------------------------------
 # LOCK — prevent concurrent runs
 # ---------------------------------------------------------------------------
 function Test-LockActive {
-    if (-not (Test-Path $LockFile)) { return $false }
-    $age = (Get-Date) - (Get-Item $LockFile).LastWriteTime
+    if (-not (Test-Path -LiteralPath $LockFile)) { return $false }
+    $age = (Get-Date) - (Get-Item -LiteralPath $LockFile).LastWriteTime
     if ($age.TotalMinutes -lt $LockTimeoutMinutes) {
         Write-Log "Lock file active (age: $([math]::Round($age.TotalMinutes,1)) min). Skipping run." 'WARN'
         return $true
     }
     Write-Log "Stale lock file found (age: $([math]::Round($age.TotalMinutes,1)) min). Clearing." 'WARN'
-    Remove-Item $LockFile -Force -ErrorAction SilentlyContinue
+    Remove-Item -LiteralPath $LockFile -Force -ErrorAction SilentlyContinue
     return $false
 }
 
 function Set-Lock   { New-Item -Path $LockFile -ItemType File -Force | Out-Null }
-function Clear-Lock { Remove-Item $LockFile -Force -ErrorAction SilentlyContinue }
+function Clear-Lock { Remove-Item -LiteralPath $LockFile -Force -ErrorAction SilentlyContinue }
 
 # ---------------------------------------------------------------------------
 # HEALTH CHECK — is repair actually needed?
 # ---------------------------------------------------------------------------
+# A cache file name matches a wildcard (-like) or, after "re:", a regular
+# expression over the whole name (-match); both ignore case, as the daemon.
+function Test-CacheName([string]$Name, [string[]]$Patterns) {
+    foreach ($p in $Patterns) {
+        if ($p.StartsWith('re:')) {
+            if ($Name -match "^(?:$($p.Substring(3)))`$") { return $true }
+        } elseif ($Name -like $p) {
+            return $true
+        }
+    }
+    return $false
+}
+
+function Get-CacheFile([string[]]$Patterns) {
+    Get-ChildItem -LiteralPath $CachePath -File -ErrorAction SilentlyContinue |
+        Where-Object { Test-CacheName $_.Name $Patterns }
+}
+
 function Get-CacheSizeMB {
-    $files = Get-ChildItem -Path $CachePath -Filter 'iconcache_*.db' -ErrorAction SilentlyContinue
+    $files = @(Get-CacheFile $IconPatternList)
     if (-not $files) { return 0 }
     return [math]::Round(($files | Measure-Object -Property Length -Sum).Sum / 1MB, 2)
 }
@@ -117,9 +338,9 @@ function Test-RepairNeeded {
     $sizeMB = Get-CacheSizeMB
     Write-Log "Current icon cache size: $sizeMB MB (threshold: $SizeLimitMB MB)"
 
-    $cacheFiles = Get-ChildItem -Path $CachePath -Filter 'iconcache_*.db' -ErrorAction SilentlyContinue
+    $cacheFiles = @(Get-CacheFile $IconPatternList)
     if (-not $cacheFiles) {
-        Write-Log "No iconcache_*.db files found — cache is either pristine or already cleared." 'WARN'
+        Write-Log "No icon cache files ($($IconPatternList -join ', ')) found — cache is either pristine or already cleared." 'WARN'
         return $false
     }
 
@@ -132,61 +353,433 @@ function Test-RepairNeeded {
     return $false
 }
 
+# ---------------------------------------------------------------------------
+# BACKUP — copy the cache files before deleting them
+# ---------------------------------------------------------------------------
+function New-ShadowCopy {
+    # Snapshot of the cache volume, linked at a temporary directory so the
+    # file system provider can read it. Returns $null when unavailable.
+    $volume = Split-Path -Qualifier $CachePath
+    try {
+        $result = Invoke-CimMethod -ClassName Win32_ShadowCopy -MethodName Create -Arguments @{ Volume = "$volume\"; Context = 'ClientAccessible' }
+        if ($result.ReturnValue -ne 0) {
+            Write-Log "Shadow copy of $volume failed (code $($result.ReturnValue)). Copying directly." 'WARN'
+            return $null
+        }
+        $shadow = Get-CimInstance -ClassName Win32_ShadowCopy -Filter "ID='$($result.ShadowID)'"
+        $link = Join-Path $env:TEMP "icon-cache-shadow-$PID"
+        cmd.exe /c mklink /d "$link" "$($shadow.DeviceObject)\" | Out-Null
+        Write-Log "Shadow copy created: $($shadow.DeviceObject)"
+        return @{ Instance = $shadow; Link = $link; Volume = $volume }
+    } catch {
+        Write-Log "Shadow copy unavailable: $($_.Exception.Message). Copying directly." 'WARN'
+        return $null
+    }
+}
+
+function Remove-ShadowCopy {
+    param($Shadow)
+    cmd.exe /c rmdir "$($Shadow.Link)" | Out-Null
+    Remove-CimInstance -InputObject $Shadow.Instance -ErrorAction SilentlyContinue
+    Write-Log "Shadow copy removed."
+}
+
+function Backup-CacheFiles {
+    param([object[]]$Files)
+    Send-RepairProgress 'backing-up'
+    $target = Join-Path $BackupDir (Get-Date -Format 'yyyyMMdd-HHmmss')
+    New-Item -Path $target -ItemType Directory -Force | Out-Null
+    $shadow = $null
+    if ($ShadowCopy) { $shadow = New-ShadowCopy }
+    $saved = @()
+    try {
+        foreach ($file in $Files) {
+            $source = $file.FullName
+            if ($shadow) {
+                $source = Join-Path $shadow.Link $file.FullName.Substring($shadow.Volume.Length)
+            }
+            try {
+                Copy-Item -LiteralPath $source -Destination (Join-Path $target $file.Name) -Force
+                $saved += [ordered]@{ name = $file.Name; size = $file.Length; shadowCopy = [bool]$shadow }
+            } catch {
+                Write-Log "Backup: could not copy $($file.Name) — $($_.Exception.Message)" 'WARN'
+            }
+        }
+    } finally {
+        if ($shadow) { Remove-ShadowCopy $shadow }
+    }
+    [ordered]@{
+        created   = (Get-Date).ToUniversalTime().ToString('o')
+        reason    = $Reason
+        cachePath = $CachePath
+        complete  = ($saved.Count -eq $Files.Count)
+        files     = $saved
+    } | ConvertTo-Json -Depth 3 | Set-Content -LiteralPath (Join-Path $target 'manifest.json') -Encoding UTF8
+    Write-Log "Backup: $($saved.Count) of $($Files.Count) file(s) saved to $target"
+
+    Get-ChildItem -LiteralPath $BackupDir -Directory |
+        Sort-Object Name -Descending |
+        Select-Object -Skip $BackupKeep |
+        Remove-Item -Recurse -Force -ErrorAction SilentlyContinue
+}
+
+# ---------------------------------------------------------------------------
+# EXPLORER CONTROL — scoped to one session in multi-session mode
+# ---------------------------------------------------------------------------
+function Get-ExplorerProcess {
+    $procs = Get-Process -Name explorer -ErrorAction SilentlyContinue
+    if ($SessionId -ge 0) {
+        $procs = $procs | Where-Object { $_.SessionId -eq $SessionId }
+    }
+    return $procs
+}
+
+# Stops Explorer by the first way that has every explorer.exe exit within
+# its timeout (-StopMethod, -StopTimeouts) and reports it to the daemon:
+#   ##explorer-stopped <restart-manager|graceful|force|none|failed> <seconds>
+function Stop-Explorer {
+    param([switch]$ForceOnly)
+    $timeouts = @($StopTimeouts -split '\|' | ForEach-Object { [math]::Max(1, [math]::Min(300, [int]$_)) })
+    while ($timeouts.Count -lt 3) { $timeouts += 5 }
+    $rungs = @(
+        @{ Name = 'restart-manager'; Timeout = $timeouts[0] },
+        @{ Name = 'graceful';        Timeout = $timeouts[1] },
+        @{ Name = 'force';           Timeout = $timeouts[2] }
+    )
+    if ($ForceOnly -or $StopMethod -ne 'graceful') {
+        $rungs = @($rungs[2])
+    }
+
+    $start = Get-Date
+    $procs = @(Get-ExplorerProcess)
+    if ($procs.Count -eq 0) {
+        Write-Log "explorer.exe is not running."
+        Send-ExplorerStopped 'none' $start
+        return
+    }
+    foreach ($rung in $rungs) {
+        try {
+            switch ($rung.Name) {
+                'restart-manager' { Stop-ExplorerRestartManager $procs $rung.Timeout }
+                'graceful'        { Stop-ExplorerShell }
+                'force'           { $procs | Stop-Process -Force -ErrorAction SilentlyContinue }
+            }
+        } catch {
+            Write-Log "Stopping Explorer ($($rung.Name)) failed: $($_.Exception.Message)" 'WARN'
+        }
+        if (Wait-ExplorerExit $procs $rung.Timeout) {
+            Write-Log ("Explorer stopped ({0}) in {1:N1}s." -f $rung.Name, ((Get-Date) - $start).TotalSeconds)
+            Send-ExplorerStopped $rung.Name $start
+            return
+        }
+        Write-Log "Explorer still running $($rung.Timeout)s after $($rung.Name)." 'WARN'
+    }
+    Write-Log "explorer.exe outlived every stop method. Cache files may stay locked." 'ERROR'
+    Send-ExplorerStopped 'failed' $start
+}
+
+function Send-ExplorerStopped([string]$Rung, [datetime]$Start) {
+    $took = ((Get-Date) - $Start).TotalSeconds.ToString('0.0', [Globalization.CultureInfo]::InvariantCulture)
+    Write-Host "##explorer-stopped $Rung $took"
+}
+
+function Wait-ExplorerExit($Procs, [int]$TimeoutSeconds) {
+    $deadline = (Get-Date).AddSeconds($TimeoutSeconds)
+    while ($true) {
+        $alive = @($Procs | Where-Object { Get-Process -Id $_.Id -ErrorAction SilentlyContinue })
+        if ($alive.Count -eq 0) { return $true }
+        if ((Get-Date) -ge $deadline) { return $false }
+        Start-Sleep -Milliseconds 250
+    }
+}
+
+# Restart Manager sends Explorer the shutdown it gets from setup programs:
+# it saves its state and exits. RmShutdown runs on a thread of its own so
+# the timeout holds when Explorer does not answer.
+function Stop-ExplorerRestartManager($Procs, [int]$TimeoutSeconds) {
+    if (-not ('IconCacheRestartManager' -as [type])) {
+        Add-Type -TypeDefinition @'
+using System;
+using System.Runtime.InteropServices;
+using System.Threading;
+
+public static class IconCacheRestartManager {
+    [StructLayout(LayoutKind.Sequential)]
+    struct RM_UNIQUE_PROCESS {
+        public int dwProcessId;
+        public System.Runtime.InteropServices.ComTypes.FILETIME ProcessStartTime;
+    }
+
+    [DllImport("rstrtmgr.dll", CharSet = CharSet.Unicode)]
+    static extern int RmStartSession(out uint pSessionHandle, int dwSessionFlags, string strSessionKey);
+    [DllImport("rstrtmgr.dll")]
+    static extern int RmEndSession(uint pSessionHandle);
+    [DllImport("rstrtmgr.dll", CharSet = CharSet.Unicode)]
+    static extern int RmRegisterResources(uint pSessionHandle, uint nFiles, string[] rgsFilenames,
+        uint nApplications, RM_UNIQUE_PROCESS[] rgApplications, uint nServices, string[] rgsServiceNames);
+    [DllImport("rstrtmgr.dll")]
+    static extern int RmShutdown(uint pSessionHandle, uint lActionFlags, IntPtr fnStatus);
+
+    // Returns the Win32 error of the shutdown, or -1 when it outlived timeoutMs.
+    public static int Shutdown(int[] pids, long[] startTimes, int timeoutMs) {
+        uint session;
+        int err = RmStartSession(out session, 0, Guid.NewGuid().ToString("N"));
+        if (err != 0) return err;
+        try {
+            RM_UNIQUE_PROCESS[] apps = new RM_UNIQUE_PROCESS[pids.Length];
+            for (int i = 0; i < pids.Length; i++) {
+                apps[i].dwProcessId = pids[i];
+                apps[i].ProcessStartTime.dwLowDateTime = (int)(startTimes[i] & 0xFFFFFFFF);
+                apps[i].ProcessStartTime.dwHighDateTime = (int)(startTimes[i] >> 32);
+            }
+            err = RmRegisterResources(session, 0, null, (uint)apps.Length, apps, 0, null);
+            if (err != 0) return err;
+            int result = -1;
+            Thread t = new Thread(() => { result = RmShutdown(session, 0, IntPtr.Zero); });
+            t.IsBackground = true;
+            t.Start();
+            if (!t.Join(timeoutMs)) return -1;
+            return result;
+        } finally {
+            RmEndSession(session);
+        }
+    }
+}
+'@
+    }
+    $pids  = [int[]]@($Procs | ForEach-Object Id)
+    $times = [long[]]@($Procs | ForEach-Object { $_.StartTime.ToFileTime() })
+    $err = [IconCacheRestartManager]::Shutdown($pids, $times, $TimeoutSeconds * 1000)
+    if ($err -eq -1) {
+        Write-Log "Restart Manager shutdown of Explorer did not finish within $($TimeoutSeconds)s." 'WARN'
+    } elseif ($err -ne 0) {
+        Write-Log "Restart Manager shutdown of Explorer failed (error $err)." 'WARN'
+    }
+}
+
+# The shell's own exit: the message behind Ctrl+Shift+right-click "Exit
+# Explorer" on the taskbar (WM_USER+436 to Shell_TrayWnd). Windows of
+# another session are out of reach, so that rung is skipped there.
+function Stop-ExplorerShell {
+    if ($ForeignSession) {
+        Write-Log "Explorer in session $SessionId cannot be asked to exit from session $OwnSessionId." 'WARN'
+        return
+    }
+    if (-not ('IconCacheShellExit' -as [type])) {
+        Add-Type -TypeDefinition @'
+using System;
+using System.Runtime.InteropServices;
+
+public static class IconCacheShellExit {
+    [DllImport("user32.dll", CharSet = CharSet.Unicode)]
+    static extern IntPtr FindWindow(string lpClassName, string lpWindowName);
+    [DllImport("user32.dll")]
+    static extern bool PostMessage(IntPtr hWnd, uint Msg, IntPtr wParam, IntPtr lParam);
+
+    public static bool Exit() {
+        IntPtr tray = FindWindow("Shell_TrayWnd", null);
+        return tray != IntPtr.Zero && PostMessage(tray, 0x0400 + 436, IntPtr.Zero, IntPtr.Zero);
+    }
+}
+'@
+    }
+    if (-not [IconCacheShellExit]::Exit()) {
+        Write-Log "No taskbar window to ask Explorer to exit." 'WARN'
+    }
+}
+
+# An elevated or foreign-session script must not start Explorer itself: it
+# would run elevated, or as the wrong user in the wrong session. The daemon
+# binary's start-shell command starts it with the user's own token.
+function Start-Explorer {
+    if ($ForeignSession) {
+        # Winlogon restarts the shell (AutoRestartShell); only step in when
+        # it has not, so no second Explorer opens as a folder window.
+        for ($i = 0; $i -lt 10 -and -not (Get-ExplorerProcesses); $i++) {
+            Start-Sleep -Seconds 1
+        }
+        if (Get-ExplorerProcesses) {
+            Write-Log "Explorer in session $SessionId was restarted by Winlogon (AutoRestartShell)."
+            return
+        }
+    }
+    if ($ForeignSession -or $Elevated) {
+        $target = if ($SessionId -ge 0) { $SessionId } else { $OwnSessionId }
+        if (Test-Path -LiteralPath $ShellLauncher) {
+            # GUI-subsystem binary: -Wait for its exit code.
+            $launch = Start-Process -FilePath $ShellLauncher -ArgumentList 'start-shell', "-session=$target" -Wait -PassThru -WindowStyle Hidden
+            if ($launch.ExitCode -eq 0) {
+                Write-Log "Explorer started in session $target with the user's token."
+                return
+            }
+            Write-Log "start-shell failed for session $target (exit $($launch.ExitCode))." 'WARN'
+        } else {
+            Write-Log "Shell launcher not found: $ShellLauncher." 'WARN'
+        }
+        if ($ForeignSession) {
+            Write-Log "Explorer in session $SessionId is left to Winlogon (AutoRestartShell)." 'WARN'
+            return
+        }
+    }
+    Start-Process explorer.exe
+}
+
+# Shell replacement hosts keep their icons in memory, so they are stopped
+# with Explorer and started again from the same path after it.
+function Stop-ModHosts {
+    if (-not $ModHosts) { return }
+    foreach ($image in $ModHosts -split '\|') {
+        $procs = @(Get-Process -Name ([IO.Path]::GetFileNameWithoutExtension($image)) -ErrorAction SilentlyContinue)
+        if ($SessionId -ge 0) {
+            $procs = @($procs | Where-Object { $_.SessionId -eq $SessionId })
+        }
+        foreach ($proc in $procs) {
+            $path = $proc.Path
+            Stop-Process -Id $proc.Id -Force -ErrorAction SilentlyContinue
+            Write-Log "Stopped shell replacement host: $image (pid $($proc.Id))"
+            if ($path -and $script:StoppedHosts -notcontains $path) {
+                $script:StoppedHosts += $path
+            }
+        }
+    }
+}
+
+function Start-ModHosts {
+    foreach ($path in $script:StoppedHosts) {
+        if ($ForeignSession -or $Elevated) {
+            $target = if ($SessionId -ge 0) { $SessionId } else { $OwnSessionId }
+            if (-not (Test-Path -LiteralPath $ShellLauncher)) {
+                Write-Log "Shell launcher not found: $ShellLauncher. Not restarting $path." 'WARN'
+                continue
+            }
+            $launch = Start-Process -FilePath $ShellLauncher -ArgumentList 'start-shell', "-session=$target", "-host=`"$path`"" -Wait -PassThru -WindowStyle Hidden
+            if ($launch.ExitCode -ne 0) {
+                Write-Log "start-shell -host failed for $path (exit $($launch.ExitCode))." 'WARN'
+                continue
+            }
+        } else {
+            Start-Process -FilePath $path
+        }
+        Write-Log "Restarted shell replacement host: $path"
+    }
+    $script:StoppedHosts = @()
+}
+
 # ---------------------------------------------------------------------------
 # CORE REPAIR LOGIC
 # ---------------------------------------------------------------------------
 function Invoke-Repair {
     Write-Log "=== REPAIR STARTED ===" 'REPAIR'
-    Write-Log "Parameters: SizeLimitMB=$SizeLimitMB Force=$Force IncludeThumbcache=$IncludeThumbcache" 'REPAIR'
+    Write-Log "Parameters: SizeLimitMB=$SizeLimitMB Force=$Force IncludeThumbcache=$IncludeThumbcache DeepClean=$DeepClean CachePath=$CachePath SessionId=$SessionId BackupDir=$BackupDir ShadowCopy=$ShadowCopy ModCaches=$ModCaches ModHosts=$ModHosts Tiers=$Tiers StopMethod=$StopMethod" 'REPAIR'
 
     $sizeBefore = Get-CacheSizeMB
     $deletedCount = 0
 
     try {
-        # 1. Stop Explorer gracefully
+        # 1. Stop Explorer (-StopMethod)
+        Send-RepairProgress 'explorer-stopping'
         Write-Log "Stopping explorer.exe..."
-        Stop-Process -Name explorer -Force -ErrorAction SilentlyContinue
+        Stop-Explorer
+        Stop-ModHosts
         Start-Sleep -Seconds 2
 
-        # 2. Delete iconcache_*.db files
-        $iconFiles = Get-ChildItem -Path $CachePath -Filter 'iconcache_*.db' -ErrorAction SilentlyContinue
+        $iconFiles  = @(Get-CacheFile $IconPatternList)
+        if ($TierList) {
+            # Targeted rebuild: the named tiers and the index built over them.
+            $keep = @($TierList | Where-Object { $_ -notmatch '[\\/]' -and (Test-CacheName $_ $IconPatternList) }) + 'iconcache_idx.db'
+            $iconFiles = @($iconFiles | Where-Object { $keep -contains $_.Name })
+            Write-Log "Tier repair: deleting $(($iconFiles | ForEach-Object Name) -join ', ') only."
+        }
+        $thumbFiles = @()
+        if ($IncludeThumbcache) {
+            $thumbFiles = @(Get-CacheFile $ThumbPatternList)
+        }
+        $total = $iconFiles.Count + $thumbFiles.Count
+        $done  = 0
+        if ($BackupDir -and $total -gt 0) {
+            try {
+                Backup-CacheFiles ($iconFiles + $thumbFiles)
+            } catch {
+                Write-Log "Backup failed, repairing without: $($_.Exception.Message)" 'ERROR'
+            }
+        }
+        Send-RepairProgress 'files-deleting' $done $total
+
+        # 2. Delete the icon cache files
         foreach ($file in $iconFiles) {
             try {
-                Remove-Item $file.FullName -Force
+                Remove-Item -LiteralPath $file.FullName -Force
                 Write-Log "Deleted: $($file.Name) ($([math]::Round($file.Length / 1KB, 1)) KB)"
                 $deletedCount++
             } catch {
                 Write-Log "Could not delete: $($file.Name) — $($_.Exception.Message)" 'ERROR'
             }
+            $done++
+            Send-RepairProgress 'files-deleting' $done $total
         }
 
-        # 3. Optionally delete thumbcache_*.db
+        # 3. Optionally delete the thumbnail cache files
         if ($IncludeThumbcache) {
-            Write-Log "IncludeThumbcache: deleting thumbcache_*.db files..."
-            $thumbFiles = Get-ChildItem -Path $CachePath -Filter 'thumbcache_*.db' -ErrorAction SilentlyContinue
+            Write-Log "IncludeThumbcache: deleting $($ThumbPatternList -join ', ') files..."
             foreach ($file in $thumbFiles) {
                 try {
-                    Remove-Item $file.FullName -Force
+                    Remove-Item -LiteralPath $file.FullName -Force
                     Write-Log "Deleted thumbcache: $($file.Name)"
                     $deletedCount++
                 } catch {
                     Write-Log "Could not delete thumbcache: $($file.Name) — $($_.Exception.Message)" 'ERROR'
                 }
+                $done++
+                Send-RepairProgress 'files-deleting' $done $total
+            }
+        }
+
+        # 4. Shell replacements' own icon caches (not backed up)
+        if ($ModCaches) {
+            foreach ($path in $ModCaches -split '\|') {
+                if (-not (Test-Path -LiteralPath $path -PathType Leaf)) { continue }
+                try {
+                    Remove-Item -LiteralPath $path -Force
+                    Write-Log "Deleted shell replacement cache: $path"
+                    $deletedCount++
+                } catch {
+                    Write-Log "Could not delete shell replacement cache: $path — $($_.Exception.Message)" 'ERROR'
+                }
+            }
+        }
+
+        # 5. Deep clean: tray icon streams (notification area icon history).
+        #    HKCU belongs to another user when repairing a foreign session.
+        if ($DeepClean -and -not $ForeignSession) {
+            Send-RepairProgress 'tray-streams-clearing'
+            foreach ($name in 'IconStreams', 'PastIconsStream') {
+                if (Get-ItemProperty -Path $TrayNotifyKey -Name $name -ErrorAction SilentlyContinue) {
+                    Remove-ItemProperty -Path $TrayNotifyKey -Name $name -Force
+                    Write-Log "Cleared tray icon stream: $name"
+                }
             }
         }
 
-        # 4. Signal Windows Shell to reset icon cache index
+        # 6. Signal Windows Shell to reset icon cache index
         $ie4uinit = Join-Path $env:SystemRoot 'System32\ie4uinit.exe'
         if (Test-Path $ie4uinit) {
+            Send-RepairProgress 'shell-refreshing'
+            if ($DeepClean) {
+                & $ie4uinit -ClearIconCache 2>$null
+                Write-Log "ie4uinit.exe -ClearIconCache executed."
+            }
             & $ie4uinit -show 2>$null
             Write-Log "ie4uinit.exe -show executed (shell icon index reset)."
         }
 
-        # 5. Restart Explorer
+        # 7. Restart Explorer, then the replacement hosts
+        Send-RepairProgress 'explorer-starting'
         Write-Log "Restarting explorer.exe..."
-        Start-Process explorer.exe
+        Start-Explorer
+        Start-ModHosts
         Start-Sleep -Seconds 3
 
+        Send-RepairProgress 'verifying'
         $sizeAfter = Get-CacheSizeMB
         Write-Log "=== REPAIR COMPLETE — $deletedCount file(s) deleted | Before: $sizeBefore MB | After: $sizeAfter MB ===" 'REPAIR'
 
@@ -194,27 +787,161 @@ function Invoke-Repair {
         Write-Log "CRITICAL ERROR during repair: $($_.Exception.Message)" 'ERROR'
         Write-Log "Stack trace: $($_.ScriptStackTrace)" 'ERROR'
         # Ensure Explorer is running even if repair failed
-        $explorerRunning = Get-Process -Name explorer -ErrorAction SilentlyContinue
+        $explorerRunning = Get-ExplorerProcess
         if (-not $explorerRunning) {
-            Start-Process explorer.exe
+            Start-Explorer
             Write-Log "Explorer restarted after error recovery." 'WARN'
         }
+        Start-ModHosts
     }
 }
 
+# ---------------------------------------------------------------------------
+# EXPLORER RESTART — a hung shell with a healthy cache
+# ---------------------------------------------------------------------------
+function Invoke-ExplorerRestart {
+    Write-Log "=== EXPLORER RESTART STARTED ===" 'REPAIR'
+    Write-Log "Parameters: CachePath=$CachePath SessionId=$SessionId" 'REPAIR'
+    try {
+        Send-RepairProgress 'explorer-stopping'
+        Write-Log "Stopping explorer.exe (not responding)..."
+        Stop-Explorer -ForceOnly
+        Stop-ModHosts
+        Start-Sleep -Seconds 2
+
+        Send-RepairProgress 'explorer-starting'
+        Write-Log "Restarting explorer.exe..."
+        Start-Explorer
+        Start-ModHosts
+        Start-Sleep -Seconds 3
+
+        Send-RepairProgress 'verifying'
+        $running = @(Get-ExplorerProcess).Count
+        Write-Log "=== EXPLORER RESTART COMPLETE — $running explorer.exe process(es) running | cache files kept ===" 'REPAIR'
+    } catch {
+        Write-Log "CRITICAL ERROR during Explorer restart: $($_.Exception.Message)" 'ERROR'
+        if (-not (Get-ExplorerProcess)) {
+            Start-Explorer
+            Write-Log "Explorer restarted after error recovery." 'WARN'
+        }
+        Start-ModHosts
+        exit 1
+    }
+}
+
+# ---------------------------------------------------------------------------
+# ROLLBACK — put a backup back in place of the current cache
+# ---------------------------------------------------------------------------
+function Invoke-Restore {
+    Write-Log "=== RESTORE STARTED ===" 'REPAIR'
+    Write-Log "Parameters: RestoreFrom=$RestoreFrom CachePath=$CachePath SessionId=$SessionId" 'REPAIR'
+
+    $manifestPath = Join-Path $RestoreFrom 'manifest.json'
+    try {
+        $manifest = Get-Content -LiteralPath $manifestPath -Raw -Encoding UTF8 | ConvertFrom-Json
+    } catch {
+        Write-Log "Backup manifest is unreadable: $($_.Exception.Message)" 'ERROR'
+        exit 1
+    }
+    # Names come from the manifest; only plain cache file names are accepted.
+    $names = @($manifest.files | ForEach-Object { [string]$_.name } |
+        Where-Object { $_ -notmatch '[\\/:]' -and $_ -notmatch '^\.+$' -and (Test-CacheName $_ ($IconPatternList + $ThumbPatternList)) -and
+            (Test-Path -LiteralPath (Join-Path $RestoreFrom $_)) })
+    if ($names.Count -eq 0) {
+        Write-Log "Backup $RestoreFrom holds no cache files. Nothing restored." 'ERROR'
+        exit 1
+    }
+    if (-not $manifest.complete) {
+        Write-Log "Backup is incomplete: files missing from it are rebuilt by Explorer." 'WARN'
+    }
+
+    $sizeBefore = Get-CacheSizeMB
+    $restored = 0
+    try {
+        Send-RepairProgress 'explorer-stopping'
+        Write-Log "Stopping explorer.exe..."
+        Stop-Explorer
+        Start-Sleep -Seconds 2
+
+        # The current icon cache goes as a whole so old and restored files
+        # are never mixed; thumbnails only when the backup has them.
+        $current = @(Get-CacheFile $IconPatternList)
+        if (@($names | Where-Object { Test-CacheName $_ $ThumbPatternList }).Count -gt 0) {
+            $current += @(Get-CacheFile $ThumbPatternList)
+        }
+        foreach ($file in $current) {
+            try {
+                Remove-Item -LiteralPath $file.FullName -Force
+            } catch {
+                Write-Log "Could not delete: $($file.Name) — $($_.Exception.Message)" 'ERROR'
+            }
+        }
+
+        Send-RepairProgress 'files-restoring' 0 $names.Count
+        foreach ($name in $names) {
+            try {
+                Copy-Item -LiteralPath (Join-Path $RestoreFrom $name) -Destination (Join-Path $CachePath $name) -Force
+                Write-Log "Restored: $name"
+                $restored++
+            } catch {
+                Write-Log "Could not restore: $name — $($_.Exception.Message)" 'ERROR'
+            }
+            Send-RepairProgress 'files-restoring' $restored $names.Count
+        }
+
+        Send-RepairProgress 'explorer-starting'
+        Write-Log "Restarting explorer.exe..."
+        Start-Explorer
+        Start-Sleep -Seconds 3
+
+        Send-RepairProgress 'verifying'
+        $sizeAfter = Get-CacheSizeMB
+        Write-Log "=== RESTORE COMPLETE — $restored of $($names.Count) file(s) restored from $RestoreFrom | Before: $sizeBefore MB | After: $sizeAfter MB ===" 'REPAIR'
+    } catch {
+        Write-Log "CRITICAL ERROR during restore: $($_.Exception.Message)" 'ERROR'
+        Write-Log "Stack trace: $($_.ScriptStackTrace)" 'ERROR'
+        if (-not (Get-ExplorerProcess)) {
+            Start-Explorer
+            Write-Log "Explorer restarted after error recovery." 'WARN'
+        }
+        exit 1
+    }
+    if ($restored -eq 0) { exit 1 }
+}
+
 # ---------------------------------------------------------------------------
 # MAIN
 # ---------------------------------------------------------------------------
 Write-Log "--- Repair-IconCache.ps1 invoked ---"
 
+if ($HandoffFile) {
+    if (-not (Read-Handoff)) {
+        exit 0
+    }
+    $Force = $true
+}
+if ($DeepClean) {
+    $Force = $true
+    $IncludeThumbcache = $true
+}
+if ($Reason) {
+    Write-Log "Trigger reason: $Reason"
+}
+
 if (Test-LockActive) {
+    # A skipped repair is fine; a skipped restore is a failed rollback.
+    if ($RestoreFrom) { exit 1 }
     exit 0
 }
 
 Set-Lock
 
 try {
-    if ($Force) {
+    if ($RestoreFrom) {
+        Invoke-Restore
+    } elseif ($RestartExplorer) {
+        Invoke-ExplorerRestart
+    } elseif ($Force) {
         Write-Log "-Force specified. Skipping health check."
         Invoke-Repair
     } elseif (Test-RepairNeeded) {
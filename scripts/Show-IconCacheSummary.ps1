@@ -0,0 +1,61 @@
+#Requires -Version 5.1
+<#
+.SYNOPSIS
+    Shows the daemon's weekly summary as a Windows notification.
+
+.DESCRIPTION
+    Run by icon-cache-watchdog.exe once a week when weeklySummary.enabled
+    and weeklySummary.toast are set. Shows a toast with the summary's
+    headline ("2 repairs, peak cache 41 MB, all heuristics healthy in 96%
+    of checks") in the current user's session. The toast is raised under
+    Windows PowerShell's app ID, so it needs no registration. Uses WinRT
+    types and therefore does not run in ConstrainedLanguage mode.
+
+.PARAMETER Title
+    First line of the toast.
+
+.PARAMETER Message
+    Body text of the toast.
+
+.NOTES
+    Naming Policy:  naming-conventions-policy-v3.2.0 - Style C (Verb-Noun.ps1)
+    Exit codes:     0 shown, 1 notifications unavailable
+#>
+
+[CmdletBinding()]
+param(
+    [Parameter(Mandatory)]
+    [string]$Title,
+    [Parameter(Mandatory)]
+    [string]$Message
+)
+
+Set-StrictMode -Version Latest
+$ErrorActionPreference = 'Stop'
+
+$AppId = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+
+try {
+    $null = [Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime]
+    $null = [Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime]
+} catch {
+    Write-Error "Windows notifications are not available: $_"
+    exit 1
+}
+
+$escape = { param($s) [System.Security.SecurityElement]::Escape($s) }
+$xml = New-Object Windows.Data.Xml.Dom.XmlDocument
+$xml.LoadXml(@"
+<toast>
+  <visual>
+    <binding template="ToastGeneric">
+      <text>$(& $escape $Title)</text>
+      <text>$(& $escape $Message)</text>
+    </binding>
+  </visual>
+</toast>
+"@)
+
+$toast = [Windows.UI.Notifications.ToastNotification]::new($xml)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($AppId).Show($toast)
+exit 0
@@ -0,0 +1,32 @@
+#Requires -Version 5.1
+<#
+.SYNOPSIS
+    Intune Remediations detection script: is the icon cache healthy?
+
+.DESCRIPTION
+    Upload as the detection script of an Intune Remediations package, with
+    Invoke-IconCacheRemediation.ps1 as the remediation script. Set "Run this
+    script using the logged-on credentials" to Yes: the icon cache belongs to
+    the user.
+
+    Runs `icon-cache-watchdog.exe compliance detect`, which prints one line
+    (Compliant: ... / NonCompliant: ... / NotEvaluated: ...) and exits 0
+    when compliant, 1 when not or when the cache directory is missing. Capturing the output also makes PowerShell wait for the
+    GUI-subsystem binary.
+
+.NOTES
+    Naming Policy:  naming-conventions-policy-v3.2.0 - Style C (Verb-Noun.ps1)
+    Exit codes:     0 compliant, 1 non-compliant or not evaluated (Intune runs remediation)
+#>
+
+# The binary is wherever Register-Tasks.ps1 pointed the Watchdog task.
+$task = Get-ScheduledTask -TaskPath '\IconCache\' -TaskName 'Watchdog' -ErrorAction SilentlyContinue
+if (-not $task -or -not (Test-Path $task.Actions[0].Execute)) {
+    Write-Output "NonCompliant: icon-cache-watchdog is not installed (no \IconCache\Watchdog task)"
+    exit 1
+}
+$DaemonExe = $task.Actions[0].Execute
+
+$out = & $DaemonExe compliance detect | Select-Object -Last 1
+Write-Output $out
+exit $LASTEXITCODE
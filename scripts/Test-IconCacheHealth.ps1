@@ -5,7 +5,7 @@
 
     This script is retained for documentation and auditability purposes.
     In v2.0.0+, this functionality is handled by the compiled Go daemon
-    (bin/icon-cache-watchdog.exe, source in daemon/main.go).
+    (bin/icon-cache-watchdog.exe, source in daemon/pkg/watchdog).
 
     This script is NOT registered as the active runtime.
     To understand how Layers C and D work, read this file.
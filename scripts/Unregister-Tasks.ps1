@@ -0,0 +1,101 @@
+#Requires -Version 5.1
+<#
+.SYNOPSIS
+    Uninstaller for icon-cache-self-healing. Undoes Register-Tasks.ps1.
+
+.DESCRIPTION
+    Stops the watchdog daemon and removes everything Register-Tasks.ps1
+    registered:
+
+      Tasks: \IconCache\Watchdog, \IconCache\EventRepair,
+             \IconCache\ElevatedRepair and the \IconCache folder
+      WMI:   the root\IconCacheWatchdog namespace
+      Event source: IconCacheWatchdog (events already written stay in the
+             Application log)
+
+    The data directory (%ProgramData%\IconCacheWatchdog: logs, state,
+    reports) is left in place unless -RemoveData is given, so a reinstall
+    keeps the repair history. Removing something that is not there is not an
+    error: uninstalling twice succeeds.
+
+.PARAMETER CallerPid
+    Process id that is not stopped with the daemon processes. Passed by
+    `icon-cache-watchdog.exe uninstall`, which runs this script.
+
+.PARAMETER RemoveData
+    Also delete %ProgramData%\IconCacheWatchdog.
+
+.NOTES
+    Naming Policy: naming-conventions-policy-v3.2.0 - Style C (Verb-Noun.ps1)
+    Must run as:   Administrator
+    Silent:        No prompts. Exit code 0 on success, 1 on any failure.
+#>
+
+[CmdletBinding()]
+param(
+    [int]$CallerPid = 0,
+    [switch]$RemoveData
+)
+
+Set-StrictMode -Off
+$ErrorActionPreference = 'Stop'
+
+$DataDir      = Join-Path $env:ProgramData "IconCacheWatchdog"
+$TaskFolderPS = "\IconCache\"
+$TaskNames    = @("Watchdog", "EventRepair", "ElevatedRepair")
+
+function Write-Step {
+    param([string]$Message, [string]$Status = 'INFO')
+    Write-Host "[$Status] $Message"
+}
+
+$current = [Security.Principal.WindowsPrincipal][Security.Principal.WindowsIdentity]::GetCurrent()
+if (-not $current.IsInRole([Security.Principal.WindowsBuiltInRole]::Administrator)) {
+    Write-Step "Must be run as Administrator." 'ERROR'
+    exit 1
+}
+
+try {
+    # Stop the daemon first so no repair starts while its tasks disappear.
+    Stop-ScheduledTask -TaskPath $TaskFolderPS -TaskName "Watchdog" -ErrorAction SilentlyContinue
+    Get-Process -Name "icon-cache-watchdog" -ErrorAction SilentlyContinue |
+        Where-Object { $_.Id -ne $CallerPid } |
+        Stop-Process -Force -ErrorAction SilentlyContinue
+
+    foreach ($name in $TaskNames) {
+        if (Get-ScheduledTask -TaskPath $TaskFolderPS -TaskName $name -ErrorAction SilentlyContinue) {
+            Unregister-ScheduledTask -TaskPath $TaskFolderPS -TaskName $name -Confirm:$false
+            Write-Step "Removed task: $TaskFolderPS$name" 'OK'
+        }
+    }
+
+    $scheduler = New-Object -ComObject Schedule.Service
+    $scheduler.Connect()
+    $root = $scheduler.GetFolder("\")
+    if (@($root.GetFolders(0) | Where-Object { $_.Name -eq 'IconCache' }).Count -gt 0) {
+        $root.DeleteFolder("IconCache", 0)
+        Write-Step "Removed task folder: \IconCache" 'OK'
+    }
+
+    $ns = Get-CimInstance -Namespace root -ClassName __Namespace -Filter "Name='IconCacheWatchdog'" -ErrorAction SilentlyContinue
+    if ($ns) {
+        Remove-CimInstance -InputObject $ns
+        Write-Step "Removed WMI namespace: root\IconCacheWatchdog" 'OK'
+    }
+
+    if ([System.Diagnostics.EventLog]::SourceExists("IconCacheWatchdog")) {
+        Remove-EventLog -Source "IconCacheWatchdog"
+        Write-Step "Removed event source: IconCacheWatchdog" 'OK'
+    }
+
+    if ($RemoveData -and (Test-Path $DataDir)) {
+        Remove-Item -Path $DataDir -Recurse -Force
+        Write-Step "Removed data directory: $DataDir" 'OK'
+    }
+} catch {
+    Write-Step "Uninstall failed: $_" 'ERROR'
+    exit 1
+}
+
+Write-Step "Uninstall complete." 'OK'
+exit 0
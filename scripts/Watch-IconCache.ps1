@@ -5,7 +5,7 @@
     
     This script is retained for documentation and auditability purposes.
     In v2.0.0+, this functionality is handled by the compiled Go daemon
-    (bin/icon-cache-watchdog.exe, source in daemon/main.go).
+    (bin/icon-cache-watchdog.exe, source in daemon/pkg/watchdog).
     
     This script is NOT registered as the active runtime.
     To understand how Layer B works, read this file.
@@ -14,7 +14,7 @@
 .NOTES
     Naming Policy:  naming-conventions-policy-v3.2.0 - Style C (Verb-Noun.ps1)
     Status:         Reference implementation (superseded by Go daemon in v2.0.0)
-    Production:     daemon/main.go
+    Production:     daemon/pkg/watchdog
     Log output:     ..\logs\Watchdog.log
 #>
 